@@ -0,0 +1,129 @@
+package usb
+
+import "syscall"
+
+// AcquisitionReader keeps a bulk IN endpoint saturated by cycling a set of
+// large zero-copy buffers through the kernel.  Each call to Next hands the
+// oldest filled buffer to the consumer and requeues the one returned by the
+// previous call, so the device always has somewhere to put data while the
+// consumer works.  This suits logic analyzers, SDRs and other devices that
+// overflow their onboard FIFO if the host pauses even briefly.
+//
+// An AcquisitionReader belongs to one consumer: Next, Close and the
+// counters are not safe to call concurrently, and Close mustn't be
+// called while another goroutine is blocked in Next.  To stop from
+// elsewhere, signal the consumer and let it call Close once Next returns.
+type AcquisitionReader struct {
+	dev      *Device
+	xfers    []*Transfer
+	done     chan *Transfer
+	held     *Transfer
+	inflight int // submitted and not yet taken from done
+	overruns int
+	closed   bool
+}
+
+// NewAcquisitionReader allocates count buffers of size bytes each and
+// submits all of them to the IN endpoint.  At least two buffers are needed
+// to keep the endpoint busy while the consumer holds one.
+func (u *Device) NewAcquisitionReader(endpoint uint8, count int, size int) (*AcquisitionReader, error) {
+	if count < 2 || size <= 0 {
+		return nil, syscall.EINVAL
+	}
+	r := &AcquisitionReader{
+		dev:  u,
+		done: make(chan *Transfer, count),
+	}
+	for i := 0; i < count; i++ {
+		b, e := u.AllocBuffer(size)
+		if e != nil {
+			r.Close()
+			return nil, e
+		}
+		r.xfers = append(r.xfers, &Transfer{
+			Type:     URB_TYPE_BULK,
+			Endpoint: endpoint | ENDPOINT_IN,
+			Data:     b,
+			Done:     r.done,
+		})
+	}
+	for _, xfer := range r.xfers {
+		if e := u.SubmitTransfer(xfer); e != nil {
+			r.Close()
+			return nil, e
+		}
+		r.inflight++
+	}
+	return r, nil
+}
+
+// Next requeues the buffer returned by the previous call and blocks until
+// the next buffer has been filled.  The returned slice is only valid until
+// the following call to Next or Close.  A failed transfer returns its
+// data so far along with the URB status as an error; the reader carries
+// on with the next buffer on the following call.
+func (r *AcquisitionReader) Next() ([]byte, error) {
+	if r.closed {
+		return nil, syscall.EBADF
+	}
+	if r.held != nil {
+		// held until it's back with the kernel, so Close doesn't wait
+		// for it
+		if e := r.dev.SubmitTransfer(r.held); e != nil {
+			return nil, e
+		}
+		r.held = nil
+		r.inflight++
+	}
+	xfer := <-r.done
+	r.inflight--
+	r.held = xfer
+	// every other buffer is also waiting on us: the endpoint went idle
+	if len(r.done) == len(r.xfers)-1 {
+		r.overruns++
+	}
+	data := xfer.Data[:xfer.Length]
+	if xfer.Status != 0 {
+		return data, xfer.Err()
+	}
+	return data, nil
+}
+
+// Pending reports how many filled buffers are waiting for the consumer.
+// A value that keeps climbing towards the buffer count means the consumer
+// is not keeping up.
+func (r *AcquisitionReader) Pending() int {
+	return len(r.done)
+}
+
+// Overruns counts the times Next found every buffer full, meaning no URB
+// was queued on the endpoint and the device may have dropped data.
+func (r *AcquisitionReader) Overruns() int {
+	return r.overruns
+}
+
+// Close cancels the outstanding transfers, waits for the kernel to give
+// them back and releases the buffers, returning the first error in
+// unmapping them.
+func (r *AcquisitionReader) Close() error {
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	r.held = nil
+	// those never submitted, or not requeued, give EINVAL
+	for _, xfer := range r.xfers {
+		r.dev.CancelTransfer(xfer)
+	}
+	for ; r.inflight > 0; r.inflight-- {
+		<-r.done
+	}
+	var err error
+	for _, xfer := range r.xfers {
+		if e := r.dev.FreeBuffer(xfer.Data); e != nil && err == nil {
+			err = e
+		}
+	}
+	r.xfers = nil
+	return err
+}
@@ -0,0 +1,39 @@
+package usb
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+)
+
+// TestAcquisitionClose checks that Close frees every buffer and reports
+// a failure to unmap one, here a buffer that was never mapped
+func TestAcquisitionClose(t *testing.T) {
+	m, e := AllocAligned(os.Getpagesize(), 0, false)
+	if e != nil {
+		t.Fatal(e)
+	}
+	bogus := make([]byte, 512)
+	u := &Device{mapped: map[*byte]int{&m[0]: len(m), &bogus[0]: len(bogus)}}
+	r := &AcquisitionReader{
+		dev:  u,
+		done: make(chan *Transfer, 2),
+		xfers: []*Transfer{
+			{Type: URB_TYPE_BULK, Endpoint: 0x81, Data: bogus},
+			{Type: URB_TYPE_BULK, Endpoint: 0x81, Data: m},
+		},
+	}
+	if e := r.Close(); !errors.Is(e, syscall.EINVAL) {
+		t.Errorf("Close: %v, want %v", e, syscall.EINVAL)
+	}
+	if len(u.mapped) != 0 {
+		t.Errorf("%d buffers still mapped after Close", len(u.mapped))
+	}
+	if e := r.Close(); e != nil {
+		t.Errorf("second Close: %v", e)
+	}
+	if _, e := r.Next(); e != syscall.EBADF {
+		t.Errorf("Next after Close: %v, want %v", e, syscall.EBADF)
+	}
+}
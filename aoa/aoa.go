@@ -0,0 +1,231 @@
+// Package aoa implements the Android Open Accessory protocol, which turns
+// an Android phone plugged into the host into a USB accessory talking to
+// an app on the phone.
+package aoa
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/richardnwinder/usb"
+)
+
+const (
+	REQ_GET_PROTOCOL        = 51
+	REQ_SEND_STRING         = 52
+	REQ_START               = 53
+	REQ_REGISTER_HID        = 54
+	REQ_UNREGISTER_HID      = 55
+	REQ_SET_HID_REPORT_DESC = 56
+	REQ_SEND_HID_EVENT      = 57
+	REQ_SET_AUDIO_MODE      = 58
+
+	// string indexes for REQ_SEND_STRING
+	STRING_MANUFACTURER = 0
+	STRING_MODEL        = 1
+	STRING_DESCRIPTION  = 2
+	STRING_VERSION      = 3
+	STRING_URI          = 4
+	STRING_SERIAL       = 5
+
+	VendorID                    = 0x18d1
+	PRODUCT_ACCESSORY           = 0x2d00
+	PRODUCT_ACCESSORY_ADB       = 0x2d01
+	PRODUCT_AUDIO               = 0x2d02
+	PRODUCT_AUDIO_ADB           = 0x2d03
+	PRODUCT_ACCESSORY_AUDIO     = 0x2d04
+	PRODUCT_ACCESSORY_AUDIO_ADB = 0x2d05
+
+	timeout = 1000
+)
+
+// Identity is what the phone uses to pick the app that handles the
+// accessory.  Manufacturer and Model must match the app's accessory
+// filter.
+type Identity struct {
+	Manufacturer string
+	Model        string
+	Description  string
+	Version      string
+	URI          string // offered to the user when no app is installed
+	Serial       string
+}
+
+// Protocol returns the AOA version the phone supports, 0 if none.
+func Protocol(dev *usb.Device) (int, error) {
+	b := make([]byte, 2)
+	n, e := dev.ControlTransfer(0xc0, REQ_GET_PROTOCOL, 0, 0, 2, timeout, b)
+	if e != nil {
+		if errors.Is(e, syscall.EPIPE) {
+			return 0, nil
+		}
+		return 0, e
+	}
+	if n < 2 {
+		return 0, syscall.EPROTO
+	}
+	return int(b[0]) | int(b[1])<<8, nil
+}
+
+// IsAccessory reports whether di is a phone already in accessory mode.
+func IsAccessory(di *usb.DeviceInfo) bool {
+	return di.VendorID == VendorID && di.ProductID >= PRODUCT_ACCESSORY && di.ProductID <= PRODUCT_ACCESSORY_AUDIO_ADB &&
+		di.ProductID != PRODUCT_AUDIO && di.ProductID != PRODUCT_AUDIO_ADB
+}
+
+// Start sends the identification strings and switches the phone into
+// accessory mode.  The phone then drops off the bus and comes back as an
+// accessory; use Reopen to find it.  audio, on AOA 2 phones, also turns
+// on 44.1kHz stereo audio output.
+func Start(dev *usb.Device, id Identity, audio bool) error {
+	v, e := Protocol(dev)
+	if e != nil {
+		return e
+	}
+	if v < 1 {
+		return syscall.EOPNOTSUPP
+	}
+	strs := []string{id.Manufacturer, id.Model, id.Description, id.Version, id.URI, id.Serial}
+	for i, s := range strs {
+		if s == "" {
+			continue
+		}
+		b := append([]byte(s), 0)
+		if _, e := dev.ControlTransfer(0x40, REQ_SEND_STRING, 0, uint16(i), uint16(len(b)), timeout, b); e != nil {
+			return e
+		}
+	}
+	if audio {
+		if v < 2 {
+			return syscall.EOPNOTSUPP
+		}
+		if _, e := dev.ControlTransfer(0x40, REQ_SET_AUDIO_MODE, 1, 0, 0, timeout, nil); e != nil {
+			return e
+		}
+	}
+	_, e = dev.ControlTransfer(0x40, REQ_START, 0, 0, 0, timeout, nil)
+	return e
+}
+
+// Reopen waits for the phone that was at di's port to come back in
+// accessory mode and opens it.
+func Reopen(ctx context.Context, di *usb.DeviceInfo) (*Accessory, error) {
+	match := func(n *usb.DeviceInfo) bool {
+		return IsAccessory(n) && n.BusNum == di.BusNum && slices.Equal(n.Ports, di.Ports)
+	}
+	n, e := usb.WaitForDevice(ctx, match)
+	if e != nil {
+		return nil, e
+	}
+	// the node may not be accessible yet
+	var dev *usb.Device
+	for i := 0; i < 20; i++ {
+		if dev, e = usb.Open(n); !errors.Is(e, syscall.EACCES) && !errors.Is(e, syscall.ENOENT) {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if e != nil {
+		return nil, e
+	}
+	return Open(dev, n)
+}
+
+// Accessory is the data channel to the app on the phone.
+type Accessory struct {
+	dev     *usb.Device
+	in, out uint8
+	Timeout uint32 // ms, 0 for none
+}
+
+// Open claims the accessory interface of a phone in accessory mode.  The
+// Accessory owns dev from then on.
+func Open(dev *usb.Device, di *usb.DeviceInfo) (*Accessory, error) {
+	if !IsAccessory(di) || len(di.Config) == 0 || len(di.Config[0].Interface) == 0 {
+		dev.Close()
+		return nil, syscall.ENODEV
+	}
+	// interface 0 is the accessory; adb, if enabled, comes after
+	ii := &di.Config[0].Interface[0]
+	a := &Accessory{dev: dev}
+	for _, ep := range ii.Endpoint {
+		if ep.Attributes&usb.ENDPOINT_XFER_MASK != usb.ENDPOINT_XFER_BULK {
+			continue
+		}
+		if ep.EndpointAddress&usb.ENDPOINT_IN != 0 {
+			a.in = ep.EndpointAddress
+		} else {
+			a.out = ep.EndpointAddress
+		}
+	}
+	if a.in == 0 || a.out == 0 {
+		dev.Close()
+		return nil, syscall.ENODEV
+	}
+	if e := dev.ClaimInterface(uint32(ii.InterfaceNumber)); e != nil {
+		dev.Close()
+		return nil, e
+	}
+	return a, nil
+}
+
+// Read reads a packet written by the app.  buf should be at least 16k,
+// the largest write Android's accessory API makes in one go.
+func (a *Accessory) Read(buf []byte) (int, error) {
+	if len(buf) == 0 {
+		return 0, nil
+	}
+	n, _, e := a.dev.BulkTransfer(uint32(a.in), uint32(len(buf)), a.Timeout, buf)
+	return n, e
+}
+
+func (a *Accessory) Write(buf []byte) (int, error) {
+	n, _, e := a.dev.BulkTransfer(uint32(a.out), uint32(len(buf)), a.Timeout, buf)
+	return n, e
+}
+
+// Device returns the underlying device, for the AOA 2 HID requests.
+func (a *Accessory) Device() *usb.Device {
+	return a.dev
+}
+
+func (a *Accessory) Close() error {
+	a.dev.Close()
+	return nil
+}
+
+// HID is an input device the host injects events from, AOA 2 only.  It is
+// issued on the device in either mode, before or after Start.
+type HID struct {
+	dev *usb.Device
+	id  uint16
+}
+
+// RegisterHID registers HID id with the given report descriptor.
+func RegisterHID(dev *usb.Device, id uint16, desc []byte) (*HID, error) {
+	if _, e := dev.ControlTransfer(0x40, REQ_REGISTER_HID, id, uint16(len(desc)), 0, timeout, nil); e != nil {
+		return nil, e
+	}
+	// the descriptor may be sent in pieces, wIndex being the offset
+	for off := 0; off < len(desc); off += 64 {
+		p := desc[off:min(off+64, len(desc))]
+		if _, e := dev.ControlTransfer(0x40, REQ_SET_HID_REPORT_DESC, id, uint16(off), uint16(len(p)), timeout, p); e != nil {
+			return nil, e
+		}
+	}
+	return &HID{dev, id}, nil
+}
+
+// Send delivers an input report.
+func (h *HID) Send(report []byte) error {
+	_, e := h.dev.ControlTransfer(0x40, REQ_SEND_HID_EVENT, h.id, 0, uint16(len(report)), timeout, report)
+	return e
+}
+
+func (h *HID) Unregister() error {
+	_, e := h.dev.ControlTransfer(0x40, REQ_UNREGISTER_HID, h.id, 0, 0, timeout, nil)
+	return e
+}
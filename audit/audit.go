@@ -0,0 +1,270 @@
+// Package audit keeps a tamper-evident journal of what was done to USB
+// devices, for environments that have to show what was sent to a medical
+// or avionics device.  Each open, claim, release, reset and transfer is
+// appended as a line of JSON, timestamped, chained to the line before
+// it by hash and signed with an Ed25519 key, so lines that were changed,
+// removed or reordered fail Verify.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/richardnwinder/usb"
+)
+
+var (
+	ErrBadSignature = errors.New("audit: bad signature")
+	ErrBrokenChain  = errors.New("audit: record out of sequence or chain broken")
+)
+
+// HASH_SIZE is how much of each payload's SHA-256 is kept.
+const HASH_SIZE = 16
+
+// Record is one line of the journal.
+type Record struct {
+	Seq      uint64 `json:"seq"`
+	Time     string `json:"time"` // RFC 3339, UTC, with nanoseconds
+	Op       string `json:"op"`   // "open", a usb.TapOp name, or the transfer type
+	Bus      int    `json:"bus"`
+	Device   int    `json:"dev"`
+	ID       string `json:"id,omitempty"`     // vendor:product, on open
+	Serial   string `json:"serial,omitempty"` // on open
+	Args     []int  `json:"args,omitempty"`
+	Event    string `json:"event,omitempty"` // "submit" or "complete", for transfers
+	Transfer uint64 `json:"xfer,omitempty"`  // pairs a submission with its completion
+	Endpoint uint8  `json:"ep,omitempty"`
+	Setup    string `json:"setup,omitempty"` // hex
+	Length   int    `json:"len,omitempty"`
+	Hash     string `json:"sha256,omitempty"` // of the payload, truncated to HASH_SIZE bytes, hex
+	Status   int32  `json:"status,omitempty"`
+	Error    string `json:"error,omitempty"`
+	Prev     string `json:"prev"` // SHA-256 of the previous line, hex
+	Sig      string `json:"sig,omitempty"`
+}
+
+var xferNames = map[uint8]string{
+	usb.URB_TYPE_ISO: "iso", usb.URB_TYPE_INTERRUPT: "interrupt",
+	usb.URB_TYPE_CONTROL: "control", usb.URB_TYPE_BULK: "bulk",
+}
+
+// Journal appends signed records to a writer.  It is safe for concurrent
+// use, so one journal can follow several devices.
+type Journal struct {
+	lock sync.Mutex
+	w    io.Writer
+	f    *os.File // when the journal opened it
+	key  ed25519.PrivateKey
+	seq  uint64
+	prev string
+	err  error
+}
+
+// NewJournal starts a journal on w, which should be empty.
+func NewJournal(w io.Writer, key ed25519.PrivateKey) *Journal {
+	return &Journal{w: w, key: key}
+}
+
+// OpenJournal appends to the journal in the file at path, creating it if
+// need be.  An existing journal is verified with the key's public half
+// first, and new records continue its chain.
+func OpenJournal(path string, key ed25519.PrivateKey) (*Journal, error) {
+	f, e := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if e != nil {
+		return nil, e
+	}
+	j := &Journal{w: f, f: f, key: key}
+	last, e := verify(f, key.Public().(ed25519.PublicKey))
+	if e != nil {
+		f.Close()
+		return nil, e
+	}
+	if last != nil {
+		j.seq = last.Seq
+		j.prev = last.hash
+	}
+	return j, nil
+}
+
+// Err returns the first error writing the journal.  Records are written
+// from taps, which can't return one.
+func (j *Journal) Err() error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	return j.err
+}
+
+// Close closes the file OpenJournal opened, returning Err if it is set.
+func (j *Journal) Close() error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	if j.f != nil {
+		if e := j.f.Close(); e != nil && j.err == nil {
+			j.err = e
+		}
+		j.f = nil
+	}
+	return j.err
+}
+
+func lineHash(line []byte) string {
+	h := sha256.Sum256(line)
+	return hex.EncodeToString(h[:])
+}
+
+func payloadHash(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:HASH_SIZE])
+}
+
+// Append signs r, filling in its sequence number, chain and signature,
+// and writes it.  The taps call it; it is exported for applications
+// that want to note their own steps, such as which image was flashed.
+func (j *Journal) Append(r *Record) error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	if j.err != nil {
+		return j.err
+	}
+	j.seq++
+	r.Seq = j.seq
+	if r.Time == "" {
+		r.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	}
+	r.Prev = j.prev
+	r.Sig = ""
+	unsigned, e := json.Marshal(r)
+	if e != nil {
+		j.err = e
+		return e
+	}
+	r.Sig = base64.StdEncoding.EncodeToString(ed25519.Sign(j.key, unsigned))
+	line, e := json.Marshal(r)
+	if e != nil {
+		j.err = e
+		return e
+	}
+	line = append(line, '\n')
+	if _, e := j.w.Write(line); e != nil {
+		j.err = e
+		return e
+	}
+	j.prev = lineHash(line[:len(line)-1])
+	return nil
+}
+
+func errString(e error) string {
+	if e == nil {
+		return ""
+	}
+	return e.Error()
+}
+
+// Open opens the device, recording the attempt, and attaches the journal
+// to it.
+func (j *Journal) Open(di *usb.DeviceInfo) (*usb.Device, error) {
+	u, e := usb.Open(di)
+	j.Append(&Record{Op: "open", Bus: di.BusNum, Device: di.DevNum,
+		ID: fmt.Sprintf("%04x:%04x", di.VendorID, di.ProductID), Serial: di.Serial(), Error: errString(e)})
+	if e != nil {
+		return nil, e
+	}
+	j.Attach(u)
+	return u, nil
+}
+
+// Attach records the operations and transfers of an open device, by
+// installing a tap and an operation tap on it.  Those replace any the
+// device had.
+func (j *Journal) Attach(u *usb.Device) {
+	u.SetTap(j.tap)
+	u.SetOpTap(j.opTap)
+}
+
+func (j *Journal) tap(ev *usb.TapEvent) {
+	r := &Record{Op: xferNames[ev.Type], Bus: ev.Bus, Device: ev.Device, Event: "submit",
+		Transfer: ev.ID, Endpoint: ev.Endpoint, Length: ev.Length, Status: ev.Status,
+		Time: ev.Time.UTC().Format(time.RFC3339Nano)}
+	if ev.Complete {
+		r.Event = "complete"
+	}
+	if ev.Setup != nil {
+		r.Setup = hex.EncodeToString(ev.Setup)
+	}
+	if len(ev.Data) > 0 {
+		r.Hash = payloadHash(ev.Data)
+	}
+	if ev.Status != 0 {
+		r.Error = syscall.Errno(-ev.Status).Error()
+	}
+	j.Append(r)
+}
+
+func (j *Journal) opTap(op *usb.TapOp) {
+	j.Append(&Record{Op: op.Name, Bus: op.Bus, Device: op.Device, Args: op.Args,
+		Error: errString(op.Err), Time: op.Time.UTC().Format(time.RFC3339Nano)})
+}
+
+// Verify checks every record of a journal against the public key, in
+// order, and returns how many there are.  On failure it returns how many
+// came before the bad one, with ErrBadSignature or ErrBrokenChain.
+// Records cut off the end leave a valid journal, so keep the count
+// somewhere else too if that matters.
+func Verify(r io.Reader, pub ed25519.PublicKey) (int, error) {
+	last, e := verify(r, pub)
+	if last == nil {
+		return 0, e
+	}
+	return int(last.Seq), e
+}
+
+type verified struct {
+	Seq  uint64
+	hash string // of its line
+}
+
+// verify returns the last good record
+func verify(r io.Reader, pub ed25519.PublicKey) (*verified, error) {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 1<<20)
+	var last *verified
+	prev := ""
+	for seq := uint64(1); s.Scan(); seq++ {
+		line := s.Bytes()
+		var rec Record
+		if e := json.Unmarshal(line, &rec); e != nil {
+			return last, e
+		}
+		if rec.Seq != seq || rec.Prev != prev {
+			return last, ErrBrokenChain
+		}
+		sig, e := base64.StdEncoding.DecodeString(rec.Sig)
+		if e != nil {
+			return last, ErrBadSignature
+		}
+		rec.Sig = ""
+		unsigned, e := json.Marshal(&rec)
+		if e != nil {
+			return last, e
+		}
+		// the line must be exactly what was signed, plus the signature
+		if !ed25519.Verify(pub, unsigned, sig) || !bytes.HasPrefix(line, unsigned[:len(unsigned)-1]) {
+			return last, ErrBadSignature
+		}
+		prev = lineHash(line)
+		last = &verified{rec.Seq, prev}
+	}
+	return last, s.Err()
+}
@@ -0,0 +1,143 @@
+package audit_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/richardnwinder/usb/audit"
+)
+
+func key(seed byte) ed25519.PrivateKey {
+	return ed25519.NewKeyFromSeed(bytes.Repeat([]byte{seed}, ed25519.SeedSize))
+}
+
+// write appends n transfer records, starting at from
+func write(t *testing.T, j *audit.Journal, from, n int) {
+	t.Helper()
+	for i := from; i < from+n; i++ {
+		r := &audit.Record{Op: "bulk", Bus: 1, Device: 4, Event: "submit", Transfer: uint64(i),
+			Endpoint: 0x02, Length: 64 + i, Time: fmt.Sprintf("2026-01-02T03:04:%02dZ", i)}
+		if e := j.Append(r); e != nil {
+			t.Fatal(e)
+		}
+		if r.Seq != uint64(i+1) || r.Sig == "" {
+			t.Fatalf("record %d appended with seq %d, sig %q", i, r.Seq, r.Sig)
+		}
+	}
+}
+
+func journal(t *testing.T, k ed25519.PrivateKey, n int) []string {
+	var b bytes.Buffer
+	j := audit.NewJournal(&b, k)
+	write(t, j, 0, n)
+	if e := j.Err(); e != nil {
+		t.Fatal(e)
+	}
+	return strings.SplitAfter(strings.TrimSuffix(b.String(), "\n"), "\n")
+}
+
+func TestVerify(t *testing.T) {
+	k := key(1)
+	lines := journal(t, k, 5)
+	if len(lines) != 5 {
+		t.Fatalf("%d lines, want 5", len(lines))
+	}
+	n, e := audit.Verify(strings.NewReader(strings.Join(lines, "")), k.Public().(ed25519.PublicKey))
+	if n != 5 || e != nil {
+		t.Errorf("Verify: %d, %v; want 5 records", n, e)
+	}
+	// cut off the end, which Verify can't tell
+	n, e = audit.Verify(strings.NewReader(strings.Join(lines[:3], "")), k.Public().(ed25519.PublicKey))
+	if n != 3 || e != nil {
+		t.Errorf("Verify of a truncated journal: %d, %v; want 3 records", n, e)
+	}
+}
+
+func TestVerifyTampered(t *testing.T) {
+	k := key(1)
+	pub := k.Public().(ed25519.PublicKey)
+	lines := journal(t, k, 4)
+	edit := func(f func(l []string) []string) string {
+		return strings.Join(f(append([]string(nil), lines...)), "")
+	}
+	for _, c := range []struct {
+		name    string
+		journal string
+		pub     ed25519.PublicKey
+		good    int
+		want    error
+	}{
+		{"edited", edit(func(l []string) []string {
+			l[2] = strings.Replace(l[2], `"len":66`, `"len":67`, 1)
+			return l
+		}), pub, 2, audit.ErrBadSignature},
+		{"field added", edit(func(l []string) []string {
+			l[1] = strings.Replace(l[1], `{"seq"`, `{"error":"x","seq"`, 1)
+			return l
+		}), pub, 1, audit.ErrBadSignature},
+		{"deleted", edit(func(l []string) []string {
+			return append(l[:1], l[2:]...)
+		}), pub, 1, audit.ErrBrokenChain},
+		{"reordered", edit(func(l []string) []string {
+			l[1], l[2] = l[2], l[1]
+			return l
+		}), pub, 1, audit.ErrBrokenChain},
+		{"renumbered", edit(func(l []string) []string {
+			// a record dropped and the next given its number, which the
+			// chain still catches
+			l[2] = strings.Replace(l[2], `"seq":3`, `"seq":2`, 1)
+			return append(l[:1], l[2:]...)
+		}), pub, 1, audit.ErrBrokenChain},
+		{"other key", edit(func(l []string) []string { return l }), key(2).Public().(ed25519.PublicKey), 0, audit.ErrBadSignature},
+	} {
+		if c.journal == strings.Join(lines, "") && c.pub.Equal(pub) {
+			t.Fatalf("%s: journal not changed", c.name)
+		}
+		n, e := audit.Verify(strings.NewReader(c.journal), c.pub)
+		if n != c.good || !errors.Is(e, c.want) {
+			t.Errorf("%s: Verify gave %d, %v; want %d, %v", c.name, n, e, c.good, c.want)
+		}
+	}
+}
+
+// TestOpenJournal appends to a journal file over several opens and checks
+// that the chain carries on across them
+func TestOpenJournal(t *testing.T) {
+	k := key(3)
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	for i, c := range []struct{ from, n int }{{0, 0}, {0, 3}, {3, 2}} {
+		j, e := audit.OpenJournal(path, k)
+		if e != nil {
+			t.Fatalf("open %d: %v", i, e)
+		}
+		write(t, j, c.from, c.n)
+		if e := j.Close(); e != nil {
+			t.Fatal(e)
+		}
+	}
+	b, e := os.ReadFile(path)
+	if e != nil {
+		t.Fatal(e)
+	}
+	n, e := audit.Verify(bytes.NewReader(b), k.Public().(ed25519.PublicKey))
+	if n != 5 || e != nil {
+		t.Errorf("Verify: %d, %v; want 5 records", n, e)
+	}
+
+	// a journal that doesn't verify isn't appended to
+	if e := os.WriteFile(path, bytes.Replace(b, []byte(`"len":65`), []byte(`"len":1`), 1), 0644); e != nil {
+		t.Fatal(e)
+	}
+	if _, e := audit.OpenJournal(path, k); !errors.Is(e, audit.ErrBadSignature) {
+		t.Errorf("OpenJournal of a tampered journal: %v, want %v", e, audit.ErrBadSignature)
+	}
+	if _, e := audit.OpenJournal(path, key(4)); e == nil {
+		t.Error("OpenJournal with another key succeeded")
+	}
+}
@@ -0,0 +1,158 @@
+package usb
+
+import (
+	"sort"
+	"syscall"
+	"time"
+)
+
+// Bandwidth is what a periodic endpoint reserves on the bus.
+type Bandwidth struct {
+	Endpoint         uint8
+	Type             uint8 // ENDPOINT_XFER_ISOC or ENDPOINT_XFER_INT
+	BytesPerInterval int
+	Interval         time.Duration // the service interval
+	BytesPerSecond   int
+	// Share is the fraction of the bus's periodic budget it takes: 90%
+	// of each frame at full and low speed and SuperSpeed, 80% of each
+	// microframe at high speed.  Packet overhead isn't counted, so a
+	// share near 1 is likely to be refused already.
+	Share float64
+}
+
+// periodic bytes per second each speed can reserve
+func periodicBudget(s Speed) float64 {
+	switch s {
+	case SPEED_LOW:
+		return 187500 * 0.9
+	case SPEED_FULL:
+		return 1500000 * 0.9
+	case SPEED_HIGH:
+		return 60000000 * 0.8
+	case SPEED_SUPER:
+		return 500000000 * 0.9
+	case SPEED_SUPER_PLUS:
+		return 1212121212 * 0.9
+	}
+	return 0
+}
+
+// EndpointBandwidth works out what ep reserves at speed s.  comp holds
+// its SuperSpeed companion descriptors, if any, as they follow it in the
+// configuration; without them a SuperSpeed endpoint is taken to send one
+// packet per interval.  Control and bulk endpoints reserve nothing and
+// give EINVAL, as does an unknown speed.
+func EndpointBandwidth(ep EndpointDescriptor, comp []byte, s Speed) (Bandwidth, error) {
+	b := Bandwidth{Endpoint: ep.EndpointAddress, Type: ep.Attributes & ENDPOINT_XFER_MASK}
+	if (b.Type != ENDPOINT_XFER_ISOC && b.Type != ENDPOINT_XFER_INT) || s == SPEED_UNKNOWN {
+		return b, syscall.EINVAL
+	}
+	interval := max(int(ep.Interval), 1)
+	size := int(ep.MaxPacketSize & 0x7ff)
+	switch s {
+	case SPEED_LOW, SPEED_FULL:
+		if b.Type == ENDPOINT_XFER_ISOC {
+			b.Interval = time.Millisecond << (min(interval, 16) - 1)
+		} else {
+			b.Interval = time.Duration(interval) * time.Millisecond
+		}
+		b.BytesPerInterval = size
+	case SPEED_HIGH:
+		b.Interval = 125 * time.Microsecond << (min(interval, 16) - 1)
+		b.BytesPerInterval = size * (1 + int(ep.MaxPacketSize>>11&3))
+	default:
+		b.Interval = 125 * time.Microsecond << (min(interval, 16) - 1)
+		b.BytesPerInterval = size
+		if len(comp) >= 6 && comp[1] == DT_SS_ENDPOINT_COMP {
+			b.BytesPerInterval = int(comp[4]) | int(comp[5])<<8
+			if b.Type == ENDPOINT_XFER_ISOC && comp[3]&0x80 != 0 {
+				// SuperSpeedPlus: the real figure is in the next descriptor
+				rest := comp[comp[0]:]
+				if len(rest) >= 8 && rest[1] == DT_SSP_ISOC_ENDPOINT_COMP {
+					b.BytesPerInterval = int(rest[4]) | int(rest[5])<<8 | int(rest[6])<<16 | int(rest[7])<<24
+				}
+			}
+		}
+	}
+	b.BytesPerSecond = int(int64(b.BytesPerInterval) * int64(time.Second) / int64(b.Interval))
+	b.Share = float64(b.BytesPerSecond) / periodicBudget(s)
+	return b, nil
+}
+
+// AltBandwidth is what one alternate setting reserves.
+type AltBandwidth struct {
+	Interface *InterfaceInfo
+	Endpoints []Bandwidth // its periodic endpoints
+	In, Out   int         // bytes per second in each direction
+	Share     float64
+}
+
+// Bandwidth adds up what the periodic endpoints of ii reserve at speed s.
+func (ii *InterfaceInfo) Bandwidth(s Speed) AltBandwidth {
+	ab := AltBandwidth{Interface: ii}
+	comp := companions(ii.Extra)
+	for i, ep := range ii.Endpoint {
+		var c []byte
+		if i < len(comp) {
+			c = comp[i]
+		}
+		b, e := EndpointBandwidth(ep, c, s)
+		if e != nil {
+			continue
+		}
+		ab.Endpoints = append(ab.Endpoints, b)
+		if ep.EndpointAddress&ENDPOINT_IN != 0 {
+			ab.In += b.BytesPerSecond
+		} else {
+			ab.Out += b.BytesPerSecond
+		}
+		ab.Share += b.Share
+	}
+	return ab
+}
+
+// companions splits out the SuperSpeed companion descriptors, one per
+// endpoint in order, each running on to whatever follows it
+func companions(extra []byte) [][]byte {
+	var list [][]byte
+	for d := extra; len(d) >= 2 && d[0] >= 2 && int(d[0]) <= len(d); d = d[d[0]:] {
+		if d[1] == DT_SS_ENDPOINT_COMP {
+			list = append(list, d)
+		}
+	}
+	return list
+}
+
+// AltSettings lists the alternate settings of interface ifnum by the
+// bandwidth they reserve at speed s, least first.  When SetInterface
+// fails with ENOSPC, the host can't fit the setting alongside what other
+// devices reserve, and only the settings before it in the list are
+// worth trying.
+func (ci *ConfigInfo) AltSettings(ifnum uint8, s Speed) []AltBandwidth {
+	var list []AltBandwidth
+	for i := range ci.Interface {
+		if ci.Interface[i].InterfaceNumber == ifnum {
+			list = append(list, ci.Interface[i].Bandwidth(s))
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Share < list[j].Share })
+	return list
+}
+
+// ChooseAltSetting picks the alternate setting of interface ifnum that
+// reserves the least bandwidth while moving at least need bytes per
+// second in direction dir (ENDPOINT_IN, or 0 for OUT), as an audio or
+// video format needs.  It fails with ENOSPC if no setting is fast
+// enough or fits within the bus's periodic budget.
+func (ci *ConfigInfo) ChooseAltSetting(ifnum uint8, s Speed, dir uint8, need int) (*InterfaceInfo, error) {
+	for _, ab := range ci.AltSettings(ifnum, s) {
+		got := ab.Out
+		if dir&ENDPOINT_IN != 0 {
+			got = ab.In
+		}
+		if got >= need && ab.Share <= 1 {
+			return ab.Interface, nil
+		}
+	}
+	return nil, syscall.ENOSPC
+}
@@ -0,0 +1,157 @@
+// Package billboard decodes the Billboard capability a Type-C device
+// puts in its BOS when it couldn't enter the alternate modes it wanted,
+// such as DisplayPort, and says in words what went wrong.
+package billboard
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/richardnwinder/usb"
+)
+
+// AltModeState is the outcome of entering an alternate mode, from the
+// Billboard bmConfigured field.
+type AltModeState uint8
+
+const (
+	ALTMODE_ERROR         AltModeState = 0 // unspecified error
+	ALTMODE_NOT_ATTEMPTED AltModeState = 1 // not attempted, or exited
+	ALTMODE_UNSUCCESSFUL  AltModeState = 2 // attempted but not entered
+	ALTMODE_CONFIGURED    AltModeState = 3
+)
+
+func (s AltModeState) String() string {
+	switch s {
+	case ALTMODE_ERROR:
+		return "error"
+	case ALTMODE_NOT_ATTEMPTED:
+		return "not attempted or exited"
+	case ALTMODE_UNSUCCESSFUL:
+		return "unsuccessful"
+	}
+	return "configured"
+}
+
+// bAdditionalFailureInfo
+const (
+	BILLBOARD_FAIL_POWER = 0x01 // not enough power to enter a mode
+	BILLBOARD_FAIL_PD    = 0x02 // USB PD negotiation failed
+)
+
+var svidNames = map[uint16]string{
+	0xff00: "USB PD",
+	0xff01: "DisplayPort",
+	0x8087: "Thunderbolt",
+	0x1c68: "Huawei",
+	0x04e8: "Samsung",
+}
+
+type AltMode struct {
+	SVID      uint16
+	Mode      uint8 // index of the mode within the SVID
+	StringIdx uint8
+	State     AltModeState
+	VDO       uint32 // from the Billboard AUM capability, if present
+}
+
+func (m AltMode) String() string {
+	name, ok := svidNames[m.SVID]
+	if !ok {
+		name = fmt.Sprintf("SVID %04x", m.SVID)
+	}
+	return fmt.Sprintf("%s mode %d: %s", name, m.Mode, m.State)
+}
+
+// Billboard is the Billboard capability a Type-C device exposes when
+// alternate mode negotiation didn't go the way it should.
+type Billboard struct {
+	AdditionalInfoURL uint8 // string index
+	Preferred         uint8 // index into Modes
+	VCONNPower        uint16
+	Version           uint16 // bcd
+	FailureInfo       uint8
+	Modes             []AltMode
+}
+
+// VCONNWatts returns the VCONN power the device needs, or 0 if it needs
+// none.
+func (b *Billboard) VCONNWatts() int {
+	if b.VCONNPower&0x8000 != 0 {
+		return 0
+	}
+	return []int{1, 2, 3, 4, 5, 6, 0, 0}[b.VCONNPower&7]
+}
+
+// Parse decodes the BOS's Billboard capability, merging in the per-mode
+// VDOs from any AUM capabilities.  It returns nil if there is none or it
+// is malformed.
+func Parse(b *usb.BOS) *Billboard {
+	caps := b.Find(usb.CAP_BILLBOARD)
+	if len(caps) == 0 {
+		return nil
+	}
+	c := caps[0]
+	if len(c) < 44 {
+		return nil
+	}
+	bb := &Billboard{
+		AdditionalInfoURL: c[3],
+		Preferred:         c[5],
+		VCONNPower:        uint16(c[6]) | uint16(c[7])<<8,
+		Version:           uint16(c[40]) | uint16(c[41])<<8,
+		FailureInfo:       c[42],
+	}
+	n := min(int(c[4]), 34, (len(c)-44)/4)
+	for i := 0; i < n; i++ {
+		e := c[44+4*i:]
+		bb.Modes = append(bb.Modes, AltMode{
+			SVID:      uint16(e[0]) | uint16(e[1])<<8,
+			Mode:      e[2],
+			StringIdx: e[3],
+			State:     AltModeState(c[8+i/4] >> (2 * (i % 4)) & 3),
+		})
+	}
+	for _, c := range b.Find(usb.CAP_BILLBOARD_EX) {
+		if len(c) >= 8 && int(c[3]) < len(bb.Modes) {
+			bb.Modes[c[3]].VDO = uint32(c[4]) | uint32(c[5])<<8 | uint32(c[6])<<16 | uint32(c[7])<<24
+		}
+	}
+	return bb
+}
+
+// Problems describes in words what the Billboard says went wrong.
+func (b *Billboard) Problems() []string {
+	var p []string
+	if b.FailureInfo&BILLBOARD_FAIL_POWER != 0 {
+		p = append(p, "the host port cannot supply enough power for an alternate mode")
+	}
+	if b.FailureInfo&BILLBOARD_FAIL_PD != 0 {
+		p = append(p, "USB Power Delivery negotiation failed")
+	}
+	for _, m := range b.Modes {
+		if m.State != ALTMODE_CONFIGURED {
+			p = append(p, m.String())
+		}
+	}
+	return p
+}
+
+func (b *Billboard) String() string {
+	var s strings.Builder
+	fmt.Fprintf(&s, "Billboard %x.%02x, %d alternate modes, preferred %d\n",
+		b.Version>>8, b.Version&0xff, len(b.Modes), b.Preferred)
+	if w := b.VCONNWatts(); w > 0 {
+		fmt.Fprintf(&s, "  VCONN power %dW\n", w)
+	}
+	for _, m := range b.Modes {
+		fmt.Fprintf(&s, "  %s\n", m)
+	}
+	if b.FailureInfo&BILLBOARD_FAIL_POWER != 0 {
+		s.WriteString("  insufficient power\n")
+	}
+	if b.FailureInfo&BILLBOARD_FAIL_PD != 0 {
+		s.WriteString("  USB PD negotiation failed\n")
+	}
+	return s.String()
+}
@@ -0,0 +1,112 @@
+package usb
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// device capability types
+const (
+	CAP_WIRELESS_USB          = 0x01
+	CAP_USB_2_0_EXTENSION     = 0x02
+	CAP_SUPERSPEED_USB        = 0x03
+	CAP_CONTAINER_ID          = 0x04
+	CAP_PLATFORM              = 0x05
+	CAP_POWER_DELIVERY        = 0x06
+	CAP_BATTERY_INFO          = 0x07
+	CAP_PD_CONSUMER_PORT      = 0x08
+	CAP_PD_PROVIDER_PORT      = 0x09
+	CAP_SUPERSPEED_PLUS       = 0x0a
+	CAP_PRECISION_TIME        = 0x0b
+	CAP_WIRELESS_USB_EXT      = 0x0c
+	CAP_BILLBOARD             = 0x0d
+	CAP_AUTHENTICATION        = 0x0e
+	CAP_BILLBOARD_EX          = 0x0f
+	CAP_CONFIGURATION_SUMMARY = 0x10
+)
+
+// DeviceCapability is one capability descriptor from the BOS, starting
+// at bLength.
+type DeviceCapability []byte
+
+func (c DeviceCapability) Type() uint8 {
+	return c[2]
+}
+
+// BOS is a device's Binary device Object Store, which USB 2.1 and later
+// devices use to describe their capabilities.
+type BOS struct {
+	Capabilities []DeviceCapability
+}
+
+// ReadBOS fetches and splits the BOS descriptor.  Devices older than USB
+// 2.1 usually stall the request.
+func (u *Device) ReadBOS() (*BOS, error) {
+	h := make([]byte, 5)
+	n, e := u.GetDescriptor(DT_BOS, 0, 0, h)
+	if e != nil {
+		return nil, e
+	}
+	if n < 5 || h[1] != DT_BOS {
+		return nil, syscall.EPROTO
+	}
+	b := make([]byte, int(h[2])|int(h[3])<<8)
+	if n, e = u.GetDescriptor(DT_BOS, 0, 0, b); e != nil {
+		return nil, e
+	}
+	return ParseBOS(b[:n])
+}
+
+func ParseBOS(d []byte) (*BOS, error) {
+	if badDesc(d, DT_BOS, 5) {
+		return nil, syscall.EINVAL
+	}
+	bos := &BOS{}
+	for d = d[d[0]:]; len(d) >= 3; d = d[d[0]:] {
+		if d[0] < 3 || int(d[0]) > len(d) {
+			return nil, syscall.EINVAL
+		}
+		if d[1] == DT_DEVICE_CAPABILITY {
+			bos.Capabilities = append(bos.Capabilities, DeviceCapability(d[:d[0]]))
+		}
+	}
+	return bos, nil
+}
+
+// Find returns the capabilities of the given type.
+func (b *BOS) Find(kind uint8) []DeviceCapability {
+	var caps []DeviceCapability
+	for _, c := range b.Capabilities {
+		if c.Type() == kind {
+			caps = append(caps, c)
+		}
+	}
+	return caps
+}
+
+var capNames = map[uint8]string{
+	CAP_WIRELESS_USB:          "Wireless USB",
+	CAP_USB_2_0_EXTENSION:     "USB 2.0 Extension",
+	CAP_SUPERSPEED_USB:        "SuperSpeed USB",
+	CAP_CONTAINER_ID:          "Container ID",
+	CAP_PLATFORM:              "Platform",
+	CAP_POWER_DELIVERY:        "Power Delivery",
+	CAP_BATTERY_INFO:          "Battery Info",
+	CAP_PD_CONSUMER_PORT:      "PD Consumer Port",
+	CAP_PD_PROVIDER_PORT:      "PD Provider Port",
+	CAP_SUPERSPEED_PLUS:       "SuperSpeedPlus USB",
+	CAP_PRECISION_TIME:        "Precision Time Measurement",
+	CAP_WIRELESS_USB_EXT:      "Wireless USB Extension",
+	CAP_BILLBOARD:             "Billboard",
+	CAP_AUTHENTICATION:        "Authentication",
+	CAP_BILLBOARD_EX:          "Billboard AUM",
+	CAP_CONFIGURATION_SUMMARY: "Configuration Summary",
+}
+
+func (c DeviceCapability) String() string {
+	name, ok := capNames[c.Type()]
+	if !ok {
+		name = fmt.Sprintf("capability 0x%02x", c.Type())
+	}
+	return fmt.Sprintf("%s: % x", name, []byte(c[3:]))
+}
@@ -0,0 +1,326 @@
+// Package bridge lets a web page drive local devices through a helper
+// daemon.  Server is an http.Handler that speaks a JSON protocol over
+// WebSocket, shaped after WebUSB so that a page can use the same code
+// against navigator.usb or the bridge:
+//
+//	-> {"id": 1, "op": "open", "bus": 1, "device": 4}
+//	<- {"id": 1, "handle": 1}
+//	-> {"id": 2, "op": "controlTransferIn", "handle": 1, "length": 18,
+//	    "setup": {"requestType": "standard", "recipient": "device",
+//	              "request": 6, "value": 256, "index": 0}}
+//	<- {"id": 2, "status": "ok", "data": "EgEAAgAAAEDRGAAt..."}
+//
+// Data travels as base64.  Other ops are list, close, claimInterface,
+// releaseInterface, selectConfiguration, selectAlternateInterface,
+// controlTransferOut, transferIn, transferOut, clearHalt and reset.
+package bridge
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/richardnwinder/usb"
+)
+
+// Server bridges WebSocket clients to local devices.
+type Server struct {
+	// Origins lists the web origins allowed to connect, such as
+	// "https://provision.example.com".  With none, only pages served
+	// from the bridge's own host are, and only when that is a loopback
+	// address or localhost; any page could otherwise talk to the user's
+	// devices, and a page whose name is rebound to the bridge's address
+	// would pass for one of the bridge's own.
+	Origins []string
+	// Allow, if set, picks the devices clients may list and open.
+	Allow func(di *usb.DeviceInfo) bool
+	// Timeout for transfers in milliseconds; 0 is 5000.
+	Timeout uint32
+}
+
+type Setup struct {
+	RequestType string `json:"requestType"` // standard, class or vendor
+	Recipient   string `json:"recipient"`   // device, interface, endpoint or other
+	Request     uint8  `json:"request"`
+	Value       uint16 `json:"value"`
+	Index       uint16 `json:"index"`
+}
+
+type Request struct {
+	ID        int    `json:"id"`
+	Op        string `json:"op"`
+	Bus       int    `json:"bus,omitempty"`
+	Device    int    `json:"device,omitempty"`
+	Handle    int    `json:"handle,omitempty"`
+	Interface uint8  `json:"interface,omitempty"`
+	Alternate uint8  `json:"alternate,omitempty"`
+	Config    uint8  `json:"configuration,omitempty"`
+	Endpoint  uint8  `json:"endpoint,omitempty"`  // number; direction follows the op
+	Direction string `json:"direction,omitempty"` // in or out, for clearHalt
+	Setup     *Setup `json:"setup,omitempty"`
+	Length    int    `json:"length,omitempty"`
+	Data      []byte `json:"data,omitempty"`
+}
+
+type DeviceJSON struct {
+	Bus         int    `json:"bus"`
+	Device      int    `json:"device"`
+	VendorID    uint16 `json:"vendorId"`
+	ProductID   uint16 `json:"productId"`
+	DeviceClass uint8  `json:"deviceClass"`
+	Descriptors []byte `json:"descriptors"`
+}
+
+type Response struct {
+	ID           int          `json:"id"`
+	Error        string       `json:"error,omitempty"`
+	Status       string       `json:"status,omitempty"` // ok, stall or babble, as WebUSB
+	Handle       int          `json:"handle,omitempty"`
+	Data         []byte       `json:"data,omitempty"`
+	BytesWritten int          `json:"bytesWritten,omitempty"`
+	Devices      []DeviceJSON `json:"devices,omitempty"`
+}
+
+func (s *Server) originOK(r *http.Request) bool {
+	o := r.Header.Get("Origin")
+	if o == "" {
+		// not a browser
+		return true
+	}
+	for _, a := range s.Origins {
+		if o == a {
+			return true
+		}
+	}
+	u, e := url.Parse(o)
+	return len(s.Origins) == 0 && e == nil && u.Host == r.Host && loopback(r.Host)
+}
+
+// loopback says whether a Host header names this machine, which a
+// rebound DNS name can't
+func loopback(host string) bool {
+	if h, _, e := net.SplitHostPort(host); e == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.originOK(r) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+	ws, e := upgrade(w, r)
+	if e != nil {
+		return
+	}
+	c := &client{s: s, ws: ws, handles: map[int]*usb.Device{}}
+	c.run()
+}
+
+type client struct {
+	s       *Server
+	ws      *wsConn
+	lock    sync.Mutex
+	next    int
+	handles map[int]*usb.Device
+}
+
+func (c *client) run() {
+	var wg sync.WaitGroup
+	defer func() {
+		wg.Wait()
+		for _, u := range c.handles {
+			u.Close()
+		}
+		c.ws.Close()
+	}()
+	for {
+		msg, e := c.ws.ReadMessage()
+		if e != nil {
+			return
+		}
+		req := &Request{}
+		if e := json.Unmarshal(msg, req); e != nil {
+			c.reply(&Response{Error: e.Error()})
+			continue
+		}
+		// transferIn can wait for a long time; keep serving the rest
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp := c.do(req)
+			resp.ID = req.ID
+			c.reply(resp)
+		}()
+	}
+}
+
+func (c *client) reply(resp *Response) {
+	b, _ := json.Marshal(resp)
+	c.ws.WriteText(b)
+}
+
+func (c *client) devices() ([]*usb.DeviceInfo, error) {
+	devs, e := usb.ListDevices()
+	if e != nil || c.s.Allow == nil {
+		return devs, e
+	}
+	var list []*usb.DeviceInfo
+	for _, di := range devs {
+		if c.s.Allow(di) {
+			list = append(list, di)
+		}
+	}
+	return list, nil
+}
+
+// maxTransfer is the most a transferIn may ask for: its reply, in
+// base64, still fits in a message
+const maxTransfer = wsMaxMsg / 2
+
+var requestTypes = map[string]uint8{"standard": usb.REQTYPE_STANDARD, "class": usb.REQTYPE_CLASS, "vendor": usb.REQTYPE_VENDOR}
+var recipients = map[string]uint8{"device": usb.REQTYPE_DEVICE, "interface": usb.REQTYPE_INTERFACE,
+	"endpoint": usb.REQTYPE_ENDPOINT, "other": usb.REQTYPE_OTHER}
+
+// status turns a transfer error into WebUSB's status, or an error
+func status(resp *Response, e error) *Response {
+	switch {
+	case e == nil:
+		resp.Status = "ok"
+	case errors.Is(e, syscall.EPIPE):
+		resp.Status = "stall"
+	case errors.Is(e, syscall.EOVERFLOW):
+		resp.Status = "babble"
+	default:
+		resp.Error = e.Error()
+	}
+	return resp
+}
+
+func (c *client) do(req *Request) *Response {
+	timeout := c.s.Timeout
+	if timeout == 0 {
+		timeout = 5000
+	}
+	switch req.Op {
+	case "list":
+		devs, e := c.devices()
+		if e != nil {
+			return &Response{Error: e.Error()}
+		}
+		resp := &Response{Devices: []DeviceJSON{}}
+		for _, di := range devs {
+			d := DeviceJSON{Bus: di.BusNum, Device: di.DevNum, VendorID: di.VendorID,
+				ProductID: di.ProductID, DeviceClass: di.DeviceClass}
+			d.Descriptors, _ = di.Descriptors()
+			resp.Devices = append(resp.Devices, d)
+		}
+		return resp
+	case "open":
+		devs, e := c.devices()
+		if e != nil {
+			return &Response{Error: e.Error()}
+		}
+		for _, di := range devs {
+			if di.BusNum == req.Bus && di.DevNum == req.Device {
+				u, e := usb.Open(di)
+				if e != nil {
+					return &Response{Error: e.Error()}
+				}
+				c.lock.Lock()
+				c.next++
+				h := c.next
+				c.handles[h] = u
+				c.lock.Unlock()
+				return &Response{Handle: h}
+			}
+		}
+		return &Response{Error: "no such device"}
+	}
+	c.lock.Lock()
+	u := c.handles[req.Handle]
+	if req.Op == "close" {
+		delete(c.handles, req.Handle)
+	}
+	c.lock.Unlock()
+	if u == nil {
+		return &Response{Error: "bad handle"}
+	}
+	var e error
+	switch req.Op {
+	case "close":
+		u.Close()
+	case "claimInterface":
+		e = u.ClaimInterface(uint32(req.Interface))
+		if errors.Is(e, syscall.EBUSY) && u.DisconnectDriver(req.Interface) == nil {
+			e = u.ClaimInterface(uint32(req.Interface))
+		}
+	case "releaseInterface":
+		e = u.ReleaseInterface(uint32(req.Interface))
+	case "selectConfiguration":
+		e = u.SetConfiguration(req.Config)
+	case "selectAlternateInterface":
+		e = u.SetInterface(req.Interface, req.Alternate)
+	case "clearHalt":
+		ep := req.Endpoint
+		if req.Direction == "in" {
+			ep |= usb.ENDPOINT_IN
+		}
+		e = u.ClearHalt(ep)
+	case "reset":
+		e = u.Reset()
+	case "controlTransferIn", "controlTransferOut":
+		if req.Setup == nil {
+			return &Response{Error: "no setup"}
+		}
+		kind, ok1 := requestTypes[req.Setup.RequestType]
+		rcpt, ok2 := recipients[req.Setup.Recipient]
+		if !ok1 || !ok2 {
+			return &Response{Error: "bad setup"}
+		}
+		reqtype := kind | rcpt
+		data := req.Data
+		if req.Op == "controlTransferIn" {
+			if req.Length < 0 || req.Length > 0xffff {
+				return &Response{Error: "bad length"}
+			}
+			reqtype |= usb.REQTYPE_IN
+			data = make([]byte, req.Length)
+		} else if len(data) > 0xffff {
+			return &Response{Error: "bad length"}
+		}
+		n, e := u.ControlTransfer(reqtype, req.Setup.Request, req.Setup.Value, req.Setup.Index,
+			uint16(len(data)), timeout, data)
+		if req.Op == "controlTransferIn" {
+			return status(&Response{Data: data[:max(n, 0)]}, e)
+		}
+		return status(&Response{BytesWritten: max(n, 0)}, e)
+	case "transferIn":
+		if req.Length < 0 || req.Length > maxTransfer {
+			return &Response{Error: "bad length"}
+		}
+		data := make([]byte, req.Length)
+		n, _, e := u.BulkTransfer(uint32(req.Endpoint|usb.ENDPOINT_IN), uint32(len(data)), timeout, data)
+		return status(&Response{Data: data[:max(n, 0)]}, e)
+	case "transferOut":
+		n, _, e := u.BulkTransfer(uint32(req.Endpoint&0x0f), uint32(len(req.Data)), timeout, req.Data)
+		return status(&Response{BytesWritten: max(n, 0)}, e)
+	default:
+		return &Response{Error: "unknown op " + req.Op}
+	}
+	if e != nil {
+		return &Response{Error: e.Error()}
+	}
+	return &Response{}
+}
@@ -0,0 +1,57 @@
+package bridge
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/richardnwinder/usb"
+)
+
+func TestOriginOK(t *testing.T) {
+	for _, c := range []struct {
+		origins []string
+		host    string
+		origin  string
+		ok      bool
+	}{
+		{nil, "localhost:8080", "", true},
+		{nil, "localhost:8080", "http://localhost:8080", true},
+		{nil, "127.0.0.1:8080", "http://127.0.0.1:8080", true},
+		{nil, "[::1]:8080", "http://[::1]:8080", true},
+		{nil, "app.localhost", "http://app.localhost", true},
+		{nil, "localhost:8080", "http://localhost:9090", false},
+		{nil, "localhost:8080", "https://evil.example.com", false},
+		// a name rebound to 127.0.0.1 sends its own name as the host
+		{nil, "evil.example.com:8080", "http://evil.example.com:8080", false},
+		{nil, "192.168.1.5:8080", "http://192.168.1.5:8080", false},
+		{[]string{"https://provision.example.com"}, "localhost:8080", "https://provision.example.com", true},
+		{[]string{"https://provision.example.com"}, "localhost:8080", "http://localhost:8080", false},
+		{[]string{"https://provision.example.com"}, "provision.example.com", "https://provision.example.com", true},
+	} {
+		r := httptest.NewRequest("GET", "http://"+c.host+"/", nil)
+		if c.origin != "" {
+			r.Header.Set("Origin", c.origin)
+		}
+		s := &Server{Origins: c.origins}
+		if ok := s.originOK(r); ok != c.ok {
+			t.Errorf("origins %q, host %s, origin %q: %v, want %v", c.origins, c.host, c.origin, ok, c.ok)
+		}
+	}
+}
+
+func TestLengths(t *testing.T) {
+	// the device is never used: every request is refused before it is
+	c := &client{s: &Server{}, handles: map[int]*usb.Device{1: {}}}
+	setup := &Setup{RequestType: "vendor", Recipient: "device"}
+	for _, req := range []*Request{
+		{Op: "transferIn", Handle: 1, Endpoint: 1, Length: -1},
+		{Op: "transferIn", Handle: 1, Endpoint: 1, Length: maxTransfer + 1},
+		{Op: "controlTransferIn", Handle: 1, Setup: setup, Length: -1},
+		{Op: "controlTransferIn", Handle: 1, Setup: setup, Length: 0x10000},
+		{Op: "controlTransferOut", Handle: 1, Setup: setup, Data: make([]byte, 0x10000)},
+	} {
+		if resp := c.do(req); resp.Error != "bad length" {
+			t.Errorf("%s of length %d/%d: %+v, want bad length", req.Op, req.Length, len(req.Data), resp)
+		}
+	}
+}
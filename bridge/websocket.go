@@ -0,0 +1,167 @@
+package bridge
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// just enough RFC 6455 for a JSON protocol: text and binary messages,
+// fragmentation, ping and close
+
+const (
+	wsGUID     = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	wsMaxMsg   = 16 << 20
+	opContinue = 0x0
+	opText     = 0x1
+	opBinary   = 0x2
+	opClose    = 0x8
+	opPing     = 0x9
+	opPong     = 0xa
+)
+
+var errNotWebSocket = errors.New("bridge: not a websocket request")
+
+type wsConn struct {
+	conn  net.Conn
+	r     *bufio.Reader
+	wlock sync.Mutex
+}
+
+func headerHas(h http.Header, name string, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func upgrade(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != http.MethodGet || !headerHas(r.Header, "Connection", "upgrade") ||
+		!headerHas(r.Header, "Upgrade", "websocket") || key == "" ||
+		r.Header.Get("Sec-WebSocket-Version") != "13" {
+		http.Error(w, "websocket required", http.StatusBadRequest)
+		return nil, errNotWebSocket
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "can't upgrade", http.StatusInternalServerError)
+		return nil, errNotWebSocket
+	}
+	conn, rw, e := hj.Hijack()
+	if e != nil {
+		return nil, e
+	}
+	sum := sha1.Sum([]byte(key + wsGUID))
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n")
+	if e = rw.Flush(); e != nil {
+		conn.Close()
+		return nil, e
+	}
+	return &wsConn{conn: conn, r: rw.Reader}, nil
+}
+
+func (c *wsConn) frame() (fin bool, op byte, payload []byte, e error) {
+	var h [2]byte
+	if _, e = io.ReadFull(c.r, h[:]); e != nil {
+		return
+	}
+	fin, op = h[0]&0x80 != 0, h[0]&0x0f
+	n := uint64(h[1] & 0x7f)
+	switch n {
+	case 126:
+		var b [2]byte
+		_, e = io.ReadFull(c.r, b[:])
+		n = uint64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		_, e = io.ReadFull(c.r, b[:])
+		n = binary.BigEndian.Uint64(b[:])
+	}
+	if e != nil {
+		return
+	}
+	// clients must mask
+	if h[1]&0x80 == 0 || n > wsMaxMsg {
+		return false, 0, nil, errors.New("bridge: bad websocket frame")
+	}
+	var mask [4]byte
+	if _, e = io.ReadFull(c.r, mask[:]); e != nil {
+		return
+	}
+	payload = make([]byte, n)
+	if _, e = io.ReadFull(c.r, payload); e != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i&3]
+	}
+	return
+}
+
+// ReadMessage returns the next text or binary message, answering pings
+// on the way.  A close from the client is io.EOF.
+func (c *wsConn) ReadMessage() ([]byte, error) {
+	var msg []byte
+	for {
+		fin, op, p, e := c.frame()
+		if e != nil {
+			return nil, e
+		}
+		switch op {
+		case opPing:
+			c.write(opPong, p)
+			continue
+		case opPong:
+			continue
+		case opClose:
+			c.write(opClose, p[:min(len(p), 2)])
+			return nil, io.EOF
+		}
+		msg = append(msg, p...)
+		if len(msg) > wsMaxMsg {
+			return nil, errors.New("bridge: websocket message too large")
+		}
+		if fin {
+			return msg, nil
+		}
+	}
+}
+
+func (c *wsConn) write(op byte, p []byte) error {
+	h := []byte{0x80 | op, 0}
+	switch {
+	case len(p) < 126:
+		h[1] = byte(len(p))
+	case len(p) <= 0xffff:
+		h[1] = 126
+		h = binary.BigEndian.AppendUint16(h, uint16(len(p)))
+	default:
+		h[1] = 127
+		h = binary.BigEndian.AppendUint64(h, uint64(len(p)))
+	}
+	c.wlock.Lock()
+	defer c.wlock.Unlock()
+	_, e := c.conn.Write(append(h, p...))
+	return e
+}
+
+func (c *wsConn) WriteText(p []byte) error {
+	return c.write(opText, p)
+}
+
+func (c *wsConn) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,176 @@
+package usb
+
+import (
+	"os"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// BufferMode reports how AllocBuffer provides transfer buffers.
+type BufferMode int
+
+const (
+	BUFFER_UNTRIED  BufferMode = iota // no buffer allocated yet
+	BUFFER_ZEROCOPY                   // mmap'd from usbfs, no kernel copy
+	BUFFER_COPY                       // ordinary memory, copied by the kernel
+)
+
+func (m BufferMode) String() string {
+	switch m {
+	case BUFFER_ZEROCOPY:
+		return "zero-copy"
+	case BUFFER_COPY:
+		return "copy"
+	}
+	return "untried"
+}
+
+// AllocBuffer maps a transfer buffer from the usbfs device node.  URBs
+// pointing into such a buffer are transferred by the host controller
+// without copying through the kernel.  Kernels before 4.6 and some host
+// controllers (usbip's vhci among them) can't do this; AllocBuffer then
+// falls back to ordinary memory for the rest of the device's lifetime.
+// Release the buffer with FreeBuffer either way.
+func (u *Device) AllocBuffer(size int) ([]byte, error) {
+	if size <= 0 {
+		return nil, syscall.EINVAL
+	}
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	if u.fd < 0 || u.closing {
+		return nil, syscall.EBADF
+	}
+	if u.bufmode != BUFFER_COPY {
+		b, e := syscall.Mmap(u.fd, 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+		if e == nil {
+			u.bufmode = BUFFER_ZEROCOPY
+			u.mapped[&b[0]] = size
+			u.held.Add(int64(size))
+			return b, nil
+		}
+		// a zero-copy buffer worked before, so this is a real shortage
+		// (usbfs_memory_mb) rather than a missing feature
+		if u.bufmode == BUFFER_ZEROCOPY {
+			if e == syscall.ENOMEM {
+				e = u.memoryError()
+			}
+			return nil, e
+		}
+		u.bufmode = BUFFER_COPY
+		u.buferr = e
+		u.log.Println("zero-copy buffers unavailable, copying:", e)
+	}
+	return make([]byte, size), nil
+}
+
+func (u *Device) FreeBuffer(b []byte) error {
+	if len(b) == 0 {
+		return nil
+	}
+	u.lock.Lock()
+	size, mapped := u.mapped[&b[0]]
+	delete(u.mapped, &b[0])
+	u.lock.Unlock()
+	if !mapped {
+		return nil
+	}
+	u.held.Add(-int64(size))
+	return syscall.Munmap(b)
+}
+
+// inMapped reports whether b lies in one of AllocBuffer's mapped buffers,
+// which usbfs counted against its limit once already when it mapped them.
+// u.lock is held.
+func (u *Device) inMapped(b []byte) bool {
+	if len(b) == 0 || len(u.mapped) == 0 {
+		return false
+	}
+	p := uintptr(unsafe.Pointer(&b[0]))
+	for m, size := range u.mapped {
+		at := uintptr(unsafe.Pointer(m))
+		if p >= at && p-at+uintptr(len(b)) <= uintptr(size) {
+			return true
+		}
+	}
+	return false
+}
+
+// count counts a submitted transfer's Data as usbfs memory, unless it is in
+// a mapped buffer
+func (u *Device) count(xfer *Transfer, mapped bool) {
+	if !mapped {
+		xfer.held = int64(len(xfer.Data))
+		u.held.Add(xfer.held)
+	}
+}
+
+// uncount takes back what count counted, once the kernel is done with Data
+func (u *Device) uncount(xfer *Transfer) {
+	u.held.Add(-xfer.held)
+	xfer.held = 0
+}
+
+// BufferMode reports which buffer path AllocBuffer is using and, for
+// BUFFER_COPY, the mmap error that forced the fallback.
+func (u *Device) BufferMode() (BufferMode, error) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	return u.bufmode, u.buferr
+}
+
+var (
+	alignedLock sync.Mutex
+	aligned     = map[*byte][]byte{} // the mapping each buffer is in
+)
+
+// AllocAligned returns a buffer of size bytes starting at a multiple of
+// align, which must be a power of two; 0 means the page size, as does
+// anything smaller.  The buffer is anonymous memory outside the Go heap,
+// for host controllers and protocols that want aligned transfer data,
+// which make gives no promise of.  With pin it is also locked into
+// memory, so it is never paged out mid-transfer; that needs
+// RLIMIT_MEMLOCK or CAP_IPC_LOCK.  AllocBuffer's zero-copy buffers are
+// already page-aligned.  Release the buffer with FreeAligned.
+func AllocAligned(size, align int, pin bool) ([]byte, error) {
+	page := os.Getpagesize()
+	if size <= 0 || align < 0 || align&(align-1) != 0 {
+		return nil, syscall.EINVAL
+	}
+	align = max(align, page)
+	// mappings are page-aligned; for more, map enough to cut an aligned
+	// piece from and leave the slack mapped
+	m, e := syscall.Mmap(-1, 0, size+align-page, syscall.PROT_READ|syscall.PROT_WRITE,
+		syscall.MAP_PRIVATE|syscall.MAP_ANON)
+	if e != nil {
+		return nil, e
+	}
+	off := int(-uintptr(unsafe.Pointer(&m[0])) & uintptr(align-1))
+	b := m[off : off+size : off+size]
+	if pin {
+		if e := syscall.Mlock(b); e != nil {
+			syscall.Munmap(m)
+			return nil, e
+		}
+	}
+	alignedLock.Lock()
+	aligned[&b[0]] = m
+	alignedLock.Unlock()
+	return b, nil
+}
+
+// FreeAligned releases a buffer from AllocAligned, as it was returned.
+func FreeAligned(b []byte) error {
+	if len(b) == 0 {
+		return nil
+	}
+	alignedLock.Lock()
+	m := aligned[&b[0]]
+	delete(aligned, &b[0])
+	alignedLock.Unlock()
+	if m == nil {
+		return syscall.EINVAL
+	}
+	// unmapping unlocks
+	return syscall.Munmap(m)
+}
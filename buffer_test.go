@@ -0,0 +1,102 @@
+package usb
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+	"unsafe"
+)
+
+func TestAllocAligned(t *testing.T) {
+	page := os.Getpagesize()
+	for _, align := range []int{0, 64, page, 2 * page, 64 << 10, 2 << 20} {
+		for _, size := range []int{1, page - 1, page + 1, 3 * page} {
+			b, e := AllocAligned(size, align, false)
+			if e != nil {
+				t.Fatalf("AllocAligned(%d, %d): %v", size, align, e)
+			}
+			if len(b) != size || cap(b) != size {
+				t.Errorf("AllocAligned(%d, %d) gave len %d cap %d", size, align, len(b), cap(b))
+			}
+			if p := uintptr(unsafe.Pointer(&b[0])); p%uintptr(max(align, page)) != 0 {
+				t.Errorf("AllocAligned(%d, %d) at %#x", size, align, p)
+			}
+			for i := range b {
+				b[i] = byte(i)
+			}
+			if e := FreeAligned(b); e != nil {
+				t.Errorf("FreeAligned: %v", e)
+			}
+			if e := FreeAligned(b); !errors.Is(e, syscall.EINVAL) {
+				t.Errorf("freeing twice gave %v, want EINVAL", e)
+			}
+		}
+	}
+}
+
+func TestAllocAlignedInvalid(t *testing.T) {
+	for _, c := range []struct{ size, align int }{{0, 0}, {-1, 0}, {64, -1}, {64, 3}, {64, 4097}} {
+		if _, e := AllocAligned(c.size, c.align, false); !errors.Is(e, syscall.EINVAL) {
+			t.Errorf("AllocAligned(%d, %d) gave %v, want EINVAL", c.size, c.align, e)
+		}
+	}
+	if e := FreeAligned(make([]byte, 64)); !errors.Is(e, syscall.EINVAL) {
+		t.Errorf("freeing a heap slice gave %v, want EINVAL", e)
+	}
+}
+
+// TestAllocAlignedPinned is skipped where RLIMIT_MEMLOCK is too small
+// for a page and there is no CAP_IPC_LOCK
+func TestAllocAlignedPinned(t *testing.T) {
+	b, e := AllocAligned(os.Getpagesize(), 0, true)
+	if errors.Is(e, syscall.EPERM) || errors.Is(e, syscall.ENOMEM) || errors.Is(e, syscall.EAGAIN) {
+		t.Skip("mlock:", e)
+	}
+	if e != nil {
+		t.Fatal(e)
+	}
+	b[0] = 1
+	if e := FreeAligned(b); e != nil {
+		t.Error(e)
+	}
+}
+
+// TestHeldMapped checks that a transfer in a mapped buffer, which usbfs
+// counted when it was mapped, isn't counted again while in flight
+func TestHeldMapped(t *testing.T) {
+	page := os.Getpagesize()
+	m, e := AllocAligned(2*page, 0, false)
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer FreeAligned(m)
+	u := &Device{mapped: map[*byte]int{&m[0]: len(m)}}
+	// as AllocBuffer counts a mapping
+	u.held.Add(int64(len(m)))
+	done := make(chan *Transfer, 3)
+	xfers := []*Transfer{
+		{Type: URB_TYPE_BULK, Endpoint: 0x81, Data: m[:page], Done: done},
+		{Type: URB_TYPE_BULK, Endpoint: 0x81, Data: m[page+16:], Done: done},
+		{Type: URB_TYPE_BULK, Endpoint: 0x81, Data: make([]byte, 512), Done: done},
+	}
+	for _, x := range xfers {
+		inFlight(t, u, x)
+		u.count(x, u.inMapped(x.Data))
+	}
+	if n := u.UsbfsHeld(); n != int64(len(m)+512) {
+		t.Errorf("%d bytes held in flight, want %d", n, len(m)+512)
+	}
+	u.complete(xfers[0].urb)
+	u.complete(xfers[2].urb)
+	u.failAll(-int32(syscall.ESHUTDOWN))
+	for range xfers {
+		<-done
+	}
+	if n := u.UsbfsHeld(); n != int64(len(m)) {
+		t.Errorf("%d bytes held after completion, want the %d mapped", n, len(m))
+	}
+	if u.inMapped(make([]byte, 8)) || !u.inMapped(m[page:]) {
+		t.Error("inMapped wrong about which buffers are mapped")
+	}
+}
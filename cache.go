@@ -0,0 +1,128 @@
+package usb
+
+import (
+	"errors"
+	"sync"
+	"syscall"
+)
+
+// DeviceCache holds the list of attached devices and keeps it current
+// from hotplug events, for services that list devices often and would
+// otherwise rescan sysfs each time.  A device is read from sysfs when it
+// arrives, and again when a driver binds, unbinds or it changes
+// configuration.  If the kernel drops events because they weren't read
+// fast enough, the cache rescans.
+type DeviceCache struct {
+	lock sync.Mutex
+	mon  *HotplugMonitor
+	devs map[string]*DeviceInfo // by sysfs name, as Path gives it
+	err  error
+	done chan struct{}
+}
+
+// NewDeviceCache lists the attached devices and starts following events.
+func NewDeviceCache() (*DeviceCache, error) {
+	// listen before listing, so nothing is missed in between
+	m, e := NewHotplugMonitor()
+	if e != nil {
+		return nil, e
+	}
+	c := &DeviceCache{mon: m, done: make(chan struct{})}
+	if e := c.rescan(); e != nil {
+		m.Close()
+		return nil, e
+	}
+	go c.follow()
+	return c, nil
+}
+
+func (c *DeviceCache) rescan() error {
+	list, e := ListDevices()
+	if e != nil {
+		return e
+	}
+	devs := make(map[string]*DeviceInfo, len(list))
+	for _, di := range list {
+		devs[di.Path()] = di
+	}
+	c.lock.Lock()
+	c.devs = devs
+	c.lock.Unlock()
+	return nil
+}
+
+func (c *DeviceCache) follow() {
+	defer close(c.done)
+	for {
+		ev, e := c.mon.Next()
+		if errors.Is(e, syscall.ENOBUFS) {
+			e = c.rescan()
+		}
+		if e != nil {
+			c.lock.Lock()
+			if c.err == nil {
+				c.err = e
+			}
+			c.lock.Unlock()
+			return
+		}
+		if ev != nil {
+			c.update(ev)
+		}
+	}
+}
+
+func (c *DeviceCache) update(ev *HotplugEvent) {
+	name := ev.Device.Path()
+	di := ev.Device
+	switch ev.Action {
+	case "remove":
+		di = nil
+	case "add":
+		// a device that couldn't be read only has what the uevent said
+		if di.Length != 0 {
+			break
+		}
+		fallthrough
+	default:
+		var e error
+		if di, e = readDeviceInfo(DefaultRoot, DefaultRoot.sysfs()+name); e != nil {
+			di = nil
+		}
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if di == nil {
+		delete(c.devs, name)
+	} else {
+		c.devs[name] = di
+	}
+}
+
+// Devices returns the attached devices in port order.  They are shared
+// with the cache and other callers, so they must not be modified.  Once
+// the cache has stopped following events, because of Close or a failure
+// reading them, Devices returns what it had with the error.
+func (c *DeviceCache) Devices() ([]*DeviceInfo, error) {
+	c.lock.Lock()
+	list := make([]*DeviceInfo, 0, len(c.devs))
+	for _, di := range c.devs {
+		list = append(list, di)
+	}
+	err := c.err
+	c.lock.Unlock()
+	SortByPort(list)
+	return list, err
+}
+
+// Close stops following events.
+func (c *DeviceCache) Close() error {
+	c.lock.Lock()
+	if c.err == nil {
+		c.err = syscall.EBADF
+	}
+	c.lock.Unlock()
+	e := c.mon.Close()
+	<-c.done
+	return e
+}
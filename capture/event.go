@@ -0,0 +1,76 @@
+// Package capture records USB traffic in the formats Wireshark reads.
+// Events come from the library's own tap (usb.Device.SetTap) or from
+// usbmon, and are written as pcapng with the Linux usbmon link type;
+// PcapngReader reads such files back.
+package capture
+
+import (
+	"time"
+
+	"github.com/richardnwinder/usb"
+)
+
+// event types, as usbmon has them
+const (
+	EVENT_SUBMIT   = 'S'
+	EVENT_COMPLETE = 'C'
+	EVENT_ERROR    = 'E'
+)
+
+// Event is one usbmon record: the 64-byte binary header and the data
+// captured after it.
+type Event struct {
+	ID         uint64
+	Type       byte  // EVENT_*
+	XferType   uint8 // usb.URB_TYPE_*
+	Endpoint   uint8 // with usb.ENDPOINT_IN for IN
+	Device     uint8
+	Bus        uint16
+	SetupFlag  byte // 0 when Setup holds a setup packet
+	DataFlag   byte // 0 when Data is present
+	Time       time.Time
+	Status     int32
+	Length     uint32 // URB length, which Data may be shorter than
+	Setup      [8]byte
+	Interval   int32
+	StartFrame int32
+	Flags      uint32
+	NumDesc    uint32
+	Data       []byte
+}
+
+// FromTap converts a tap event, copying its data.
+func FromTap(t *usb.TapEvent) *Event {
+	ev := &Event{
+		ID:        t.ID,
+		Type:      EVENT_SUBMIT,
+		XferType:  t.Type,
+		Endpoint:  t.Endpoint,
+		Device:    uint8(t.Device),
+		Bus:       uint16(t.Bus),
+		SetupFlag: '-',
+		DataFlag:  0,
+		Time:      t.Time,
+		Status:    t.Status,
+		Length:    uint32(t.Length),
+		Data:      append([]byte(nil), t.Data...),
+	}
+	if t.Complete {
+		ev.Type = EVENT_COMPLETE
+	} else {
+		// usbmon reports submissions as in progress
+		ev.Status = -115 // EINPROGRESS
+	}
+	if len(t.Setup) == 8 {
+		copy(ev.Setup[:], t.Setup)
+		ev.SetupFlag = 0
+	}
+	if len(ev.Data) == 0 {
+		// no data: '<' for an IN yet to come, '>' for OUT data already gone
+		ev.DataFlag = '>'
+		if ev.Endpoint&usb.ENDPOINT_IN != 0 {
+			ev.DataFlag = '<'
+		}
+	}
+	return ev
+}
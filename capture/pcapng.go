@@ -0,0 +1,201 @@
+package capture
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/richardnwinder/usb"
+)
+
+const (
+	LINKTYPE_USB_LINUX_MMAPPED = 220
+
+	pcapngSHB = 0x0a0d0d0a
+	pcapngIDB = 0x00000001
+	pcapngEPB = 0x00000006
+	byteOrder = 0x1a2b3c4d
+)
+
+// PcapngWriter writes events to a pcapng file with one interface.  It is
+// safe for concurrent use, so one writer can tap several devices.
+type PcapngWriter struct {
+	w    io.Writer
+	lock sync.Mutex
+}
+
+func block(kind uint32, body []byte) []byte {
+	for len(body)%4 != 0 {
+		body = append(body, 0)
+	}
+	n := uint32(12 + len(body))
+	b := binary.LittleEndian.AppendUint32(nil, kind)
+	b = binary.LittleEndian.AppendUint32(b, n)
+	b = append(b, body...)
+	return binary.LittleEndian.AppendUint32(b, n)
+}
+
+// NewPcapngWriter writes the section and interface headers.
+func NewPcapngWriter(w io.Writer) (*PcapngWriter, error) {
+	shb := binary.LittleEndian.AppendUint32(nil, byteOrder)
+	shb = binary.LittleEndian.AppendUint16(shb, 1) // version 1.0
+	shb = binary.LittleEndian.AppendUint16(shb, 0)
+	shb = binary.LittleEndian.AppendUint64(shb, 0xffffffffffffffff) // length unknown
+	idb := binary.LittleEndian.AppendUint16(nil, LINKTYPE_USB_LINUX_MMAPPED)
+	idb = binary.LittleEndian.AppendUint16(idb, 0)
+	idb = binary.LittleEndian.AppendUint32(idb, 0) // no snap length
+	_, e := w.Write(append(block(pcapngSHB, shb), block(pcapngIDB, idb)...))
+	if e != nil {
+		return nil, e
+	}
+	return &PcapngWriter{w: w}, nil
+}
+
+// Header encodes the 64-byte usbmon mmap header that precedes the data.
+func (ev *Event) Header() []byte {
+	b := binary.LittleEndian.AppendUint64(nil, ev.ID)
+	b = append(b, ev.Type, ev.XferType, ev.Endpoint, ev.Device)
+	b = binary.LittleEndian.AppendUint16(b, ev.Bus)
+	b = append(b, ev.SetupFlag, ev.DataFlag)
+	b = binary.LittleEndian.AppendUint64(b, uint64(ev.Time.Unix()))
+	b = binary.LittleEndian.AppendUint32(b, uint32(ev.Time.Nanosecond()/1000))
+	b = binary.LittleEndian.AppendUint32(b, uint32(ev.Status))
+	b = binary.LittleEndian.AppendUint32(b, ev.Length)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(ev.Data)))
+	b = append(b, ev.Setup[:]...)
+	b = binary.LittleEndian.AppendUint32(b, uint32(ev.Interval))
+	b = binary.LittleEndian.AppendUint32(b, uint32(ev.StartFrame))
+	b = binary.LittleEndian.AppendUint32(b, ev.Flags)
+	return binary.LittleEndian.AppendUint32(b, ev.NumDesc)
+}
+
+// WriteEvent appends one event as an enhanced packet block.
+func (p *PcapngWriter) WriteEvent(ev *Event) error {
+	pkt := append(ev.Header(), ev.Data...)
+	ts := uint64(ev.Time.UnixMicro())
+	body := binary.LittleEndian.AppendUint32(nil, 0) // interface 0
+	body = binary.LittleEndian.AppendUint32(body, uint32(ts>>32))
+	body = binary.LittleEndian.AppendUint32(body, uint32(ts))
+	body = binary.LittleEndian.AppendUint32(body, uint32(len(pkt)))
+	body = binary.LittleEndian.AppendUint32(body, uint32(len(pkt)))
+	body = append(body, pkt...)
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	_, e := p.w.Write(block(pcapngEPB, body))
+	return e
+}
+
+// Tap returns a tap writing every event to p, for usb.Device.SetTap.
+// Write errors are dropped; the device carries on.
+func (p *PcapngWriter) Tap() usb.Tap {
+	return func(t *usb.TapEvent) {
+		p.WriteEvent(FromTap(t))
+	}
+}
+
+const LINKTYPE_USB_LINUX = 189
+
+// PcapngReader reads the USB events back out of a pcapng file, from
+// interfaces with either Linux usbmon link type.  Packets on other
+// interfaces are skipped.
+type PcapngReader struct {
+	r      io.Reader
+	order  binary.ByteOrder
+	ifaces []uint16 // link type of each interface in the section
+}
+
+func NewPcapngReader(r io.Reader) (*PcapngReader, error) {
+	p := &PcapngReader{r: r}
+	kind, _, e := p.block()
+	if e != nil {
+		return nil, e
+	}
+	if kind != pcapngSHB {
+		return nil, errors.New("capture: not a pcapng file")
+	}
+	return p, nil
+}
+
+// block reads the next block; a section header settles the byte order
+// for what follows
+func (p *PcapngReader) block() (uint32, []byte, error) {
+	var hdr [12]byte
+	if _, e := io.ReadFull(p.r, hdr[:8]); e != nil {
+		return 0, nil, e
+	}
+	if binary.LittleEndian.Uint32(hdr[:]) == pcapngSHB {
+		if _, e := io.ReadFull(p.r, hdr[8:]); e != nil {
+			return 0, nil, io.ErrUnexpectedEOF
+		}
+		p.order = binary.LittleEndian
+		if binary.BigEndian.Uint32(hdr[8:]) == byteOrder {
+			p.order = binary.BigEndian
+		}
+		n := p.order.Uint32(hdr[4:])
+		if n < 28 || n%4 != 0 {
+			return 0, nil, errors.New("capture: bad pcapng block")
+		}
+		b := make([]byte, n-12)
+		if _, e := io.ReadFull(p.r, b); e != nil {
+			return 0, nil, io.ErrUnexpectedEOF
+		}
+		// a new section starts its interface numbering over
+		p.ifaces = p.ifaces[:0]
+		return pcapngSHB, nil, nil
+	}
+	if p.order == nil {
+		return 0, nil, errors.New("capture: not a pcapng file")
+	}
+	kind := p.order.Uint32(hdr[:])
+	n := p.order.Uint32(hdr[4:])
+	if n < 12 || n%4 != 0 {
+		return 0, nil, errors.New("capture: bad pcapng block")
+	}
+	b := make([]byte, n-8)
+	if _, e := io.ReadFull(p.r, b); e != nil {
+		return 0, nil, io.ErrUnexpectedEOF
+	}
+	return kind, b[:len(b)-4], nil
+}
+
+// Next returns the next USB event, or io.EOF at the end of the file.
+func (p *PcapngReader) Next() (*Event, error) {
+	for {
+		kind, b, e := p.block()
+		if e != nil {
+			return nil, e
+		}
+		switch kind {
+		case pcapngIDB:
+			if len(b) < 8 {
+				return nil, errors.New("capture: bad pcapng interface")
+			}
+			p.ifaces = append(p.ifaces, p.order.Uint16(b))
+		case pcapngEPB:
+			if len(b) < 20 {
+				return nil, errors.New("capture: bad pcapng packet")
+			}
+			iface := p.order.Uint32(b)
+			n := p.order.Uint32(b[12:])
+			if int(iface) >= len(p.ifaces) || int(n) > len(b)-20 {
+				return nil, errors.New("capture: bad pcapng packet")
+			}
+			pkt := b[20 : 20+n]
+			size := 64
+			switch p.ifaces[iface] {
+			case LINKTYPE_USB_LINUX_MMAPPED:
+			case LINKTYPE_USB_LINUX:
+				size = 48
+			default:
+				continue
+			}
+			if len(pkt) < size {
+				return nil, errors.New("capture: short usbmon packet")
+			}
+			ev, _ := parseHeader(pkt, p.order, size == 64)
+			ev.Data = append([]byte(nil), pkt[size:]...)
+			return ev, nil
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package capture_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/richardnwinder/usb"
+	"github.com/richardnwinder/usb/capture"
+)
+
+var pcapngEvents = []*capture.Event{
+	{ID: 1, Type: capture.EVENT_SUBMIT, XferType: usb.URB_TYPE_CONTROL, Endpoint: 0x80, Device: 3, Bus: 2,
+		DataFlag: '<', Status: -115, Length: 18, Setup: [8]byte{0x80, 0x06, 0x00, 0x01, 0x00, 0x00, 0x12, 0x00},
+		Time: time.UnixMicro(1700000000123456)},
+	{ID: 1, Type: capture.EVENT_COMPLETE, XferType: usb.URB_TYPE_CONTROL, Endpoint: 0x80, Device: 3, Bus: 2,
+		SetupFlag: '-', Length: 18, Data: []byte{0x12, 0x01, 0x00, 0x02, 0, 0, 0, 0x40, 0x6b, 0x1d, 0x04, 0x01, 0, 0, 1, 2, 3, 1},
+		Time: time.UnixMicro(1700000000124000)},
+	// three bytes of data, which the block pads to four
+	{ID: 2, Type: capture.EVENT_SUBMIT, XferType: usb.URB_TYPE_BULK, Endpoint: 0x02, Device: 3, Bus: 2,
+		SetupFlag: '-', Status: -115, Length: 3, Data: []byte{0xaa, 0xbb, 0xcc}, Time: time.UnixMicro(1700000001000000)},
+	{ID: 3, Type: capture.EVENT_COMPLETE, XferType: usb.URB_TYPE_ISO, Endpoint: 0x83, Device: 3, Bus: 2,
+		SetupFlag: '-', Length: 192, Interval: 1, StartFrame: 1234, Flags: 2, NumDesc: 2, Data: []byte{1, 2, 3, 4, 5},
+		Time: time.UnixMicro(1700000001000999)},
+	{ID: 4, Type: capture.EVENT_ERROR, XferType: usb.URB_TYPE_INTERRUPT, Endpoint: 0x81, Device: 3, Bus: 2,
+		SetupFlag: '-', DataFlag: '<', Status: -19, Interval: 8, Time: time.UnixMicro(1700000002000000)},
+}
+
+// equal compares events field by field, times as instants
+func equal(a, b *capture.Event) bool {
+	if !a.Time.Equal(b.Time) {
+		return false
+	}
+	x, y := *a, *b
+	x.Time, y.Time = time.Time{}, time.Time{}
+	if len(x.Data) == 0 && len(y.Data) == 0 {
+		x.Data, y.Data = nil, nil
+	}
+	return fmt.Sprintf("%+v", x) == fmt.Sprintf("%+v", y)
+}
+
+func TestPcapngRoundTrip(t *testing.T) {
+	var b bytes.Buffer
+	w, e := capture.NewPcapngWriter(&b)
+	if e != nil {
+		t.Fatal(e)
+	}
+	for _, ev := range pcapngEvents {
+		if e := w.WriteEvent(ev); e != nil {
+			t.Fatal(e)
+		}
+	}
+	if b.Len()%4 != 0 {
+		t.Errorf("file of %d bytes, not a whole number of 32-bit words", b.Len())
+	}
+	r, e := capture.NewPcapngReader(bytes.NewReader(b.Bytes()))
+	if e != nil {
+		t.Fatal(e)
+	}
+	for i, want := range pcapngEvents {
+		ev, e := r.Next()
+		if e != nil {
+			t.Fatalf("event %d: %v", i, e)
+		}
+		if !equal(ev, want) {
+			t.Errorf("event %d:\n got %+v\nwant %+v", i, ev, want)
+		}
+	}
+	if _, e := r.Next(); e != io.EOF {
+		t.Errorf("after the last event: %v, want EOF", e)
+	}
+
+	// a truncated file fails rather than ending early
+	r, _ = capture.NewPcapngReader(bytes.NewReader(b.Bytes()[:b.Len()-6]))
+	var err error
+	for err == nil {
+		_, err = r.Next()
+	}
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("truncated file: %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+// TestPcapngTap writes through a device tap's events and reads them back
+// as usbmon would have shown them
+func TestPcapngTap(t *testing.T) {
+	var b bytes.Buffer
+	w, e := capture.NewPcapngWriter(&b)
+	if e != nil {
+		t.Fatal(e)
+	}
+	at := time.UnixMicro(1700000000000000)
+	tap := w.Tap()
+	tap(&usb.TapEvent{ID: 7, Type: usb.URB_TYPE_BULK, Endpoint: 0x81, Bus: 1, Device: 5, Length: 64, Time: at})
+	tap(&usb.TapEvent{ID: 7, Type: usb.URB_TYPE_BULK, Endpoint: 0x81, Bus: 1, Device: 5, Length: 2,
+		Data: []byte{9, 8}, Complete: true, Time: at.Add(time.Millisecond)})
+	r, e := capture.NewPcapngReader(&b)
+	if e != nil {
+		t.Fatal(e)
+	}
+	for i, want := range []*capture.Event{
+		{ID: 7, Type: capture.EVENT_SUBMIT, XferType: usb.URB_TYPE_BULK, Endpoint: 0x81, Device: 5, Bus: 1,
+			SetupFlag: '-', DataFlag: '<', Status: -115, Length: 64, Time: at},
+		{ID: 7, Type: capture.EVENT_COMPLETE, XferType: usb.URB_TYPE_BULK, Endpoint: 0x81, Device: 5, Bus: 1,
+			SetupFlag: '-', Length: 2, Data: []byte{9, 8}, Time: at.Add(time.Millisecond)},
+	} {
+		ev, e := r.Next()
+		if e != nil {
+			t.Fatalf("event %d: %v", i, e)
+		}
+		if !equal(ev, want) {
+			t.Errorf("event %d:\n got %+v\nwant %+v", i, ev, want)
+		}
+	}
+}
+
+func TestPcapngNotPcapng(t *testing.T) {
+	for _, b := range [][]byte{
+		{},
+		[]byte("not a capture file"),
+		// an interface block with no section header first
+		{1, 0, 0, 0, 20, 0, 0, 0, 220, 0, 0, 0, 0, 0, 0, 0, 20, 0, 0, 0},
+	} {
+		if _, e := capture.NewPcapngReader(bytes.NewReader(b)); e == nil {
+			t.Errorf("% x read as pcapng", b)
+		}
+	}
+}
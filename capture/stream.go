@@ -0,0 +1,118 @@
+package capture
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/richardnwinder/usb"
+)
+
+// ParseHeader decodes a 64-byte usbmon mmap header, as Header encodes it.
+// Data is left for the caller: the header's captured length says how
+// much follows.
+func ParseHeader(b []byte) (*Event, int, error) {
+	if len(b) < 64 {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	ev, n := parseHeader(b, binary.LittleEndian, true)
+	return ev, n, nil
+}
+
+// parseHeader also reads the 48-byte header of LINKTYPE_USB_LINUX, which
+// stops after the setup packet
+func parseHeader(b []byte, order binary.ByteOrder, mmapped bool) (*Event, int) {
+	ev := &Event{
+		ID:        order.Uint64(b[0:]),
+		Type:      b[8],
+		XferType:  b[9],
+		Endpoint:  b[10],
+		Device:    b[11],
+		Bus:       order.Uint16(b[12:]),
+		SetupFlag: b[14],
+		DataFlag:  b[15],
+		Time:      time.Unix(int64(order.Uint64(b[16:])), int64(order.Uint32(b[24:]))*1000),
+		Status:    int32(order.Uint32(b[28:])),
+		Length:    order.Uint32(b[32:]),
+	}
+	copy(ev.Setup[:], b[40:48])
+	if mmapped {
+		ev.Interval = int32(order.Uint32(b[48:]))
+		ev.StartFrame = int32(order.Uint32(b[52:]))
+		ev.Flags = order.Uint32(b[56:])
+		ev.NumDesc = order.Uint32(b[60:])
+	}
+	return ev, int(order.Uint32(b[36:]))
+}
+
+// ReadEvent reads one header and its data, the layout WriteEvent puts
+// in each packet and TapConn streams.
+func ReadEvent(r io.Reader) (*Event, error) {
+	var hdr [64]byte
+	if _, e := io.ReadFull(r, hdr[:]); e != nil {
+		return nil, e
+	}
+	ev, n, e := ParseHeader(hdr[:])
+	if e != nil {
+		return nil, e
+	}
+	ev.Data = make([]byte, n)
+	if _, e := io.ReadFull(r, ev.Data); e != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return ev, nil
+}
+
+// TapConn streams a program's tap events to a listener such as
+// usbextcap, so that its traffic can be watched live without usbmon.
+type TapConn struct {
+	conn net.Conn
+	lock sync.Mutex
+	err  error
+}
+
+// TapSocket is where usbextcap listens by default: usbtap.sock in
+// $XDG_RUNTIME_DIR, or a per-user name in the temporary directory.
+func TapSocket() string {
+	if d := os.Getenv("XDG_RUNTIME_DIR"); d != "" {
+		return d + "/usbtap.sock"
+	}
+	return os.TempDir() + "/usbtap-" + strconv.Itoa(os.Getuid()) + ".sock"
+}
+
+// DialTap connects to a listening Unix socket.
+func DialTap(path string) (*TapConn, error) {
+	c, e := net.Dial("unix", path)
+	if e != nil {
+		return nil, e
+	}
+	return &TapConn{conn: c}, nil
+}
+
+// Tap returns a tap for usb.Device.SetTap.  After the first write error
+// events are dropped; Err reports it.
+func (c *TapConn) Tap() usb.Tap {
+	return func(t *usb.TapEvent) {
+		ev := FromTap(t)
+		b := append(ev.Header(), ev.Data...)
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		if c.err == nil {
+			_, c.err = c.conn.Write(b)
+		}
+	}
+}
+
+func (c *TapConn) Err() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.err
+}
+
+func (c *TapConn) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,193 @@
+package capture
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/richardnwinder/usb"
+)
+
+const USBMON_DEBUGFS = "/sys/kernel/debug/usb/usbmon/"
+
+var textTypes = map[byte]uint8{
+	'Z': usb.URB_TYPE_ISO, 'I': usb.URB_TYPE_INTERRUPT,
+	'C': usb.URB_TYPE_CONTROL, 'B': usb.URB_TYPE_BULK,
+}
+
+// ParseText parses one line of the usbmon "u" text interface, such as
+//
+//	d5ea89a0 3575914555 S Ci:1:001:0 s a3 00 0000 0003 0004 4 <
+//	d5ea89a0 3575914560 C Ci:1:001:0 0 4 = 01050000
+//
+// The text interface's timestamps are wall-clock microseconds with the
+// seconds taken modulo 4096; Time holds them as they are, since the Unix
+// epoch.  Data is what the line shows, which usbmon caps at 32 bytes.
+func ParseText(line string) (*Event, error) {
+	f := strings.Fields(line)
+	bad := func(what string) (*Event, error) {
+		return nil, fmt.Errorf("capture: bad usbmon %s in %q", what, line)
+	}
+	if len(f) < 5 || len(f[2]) != 1 {
+		return bad("line")
+	}
+	ev := &Event{Type: f[2][0], SetupFlag: '-', DataFlag: '-'}
+	var e error
+	if ev.ID, e = strconv.ParseUint(f[0], 16, 64); e != nil {
+		return bad("tag")
+	}
+	us, e := strconv.ParseUint(f[1], 10, 64)
+	if e != nil {
+		return bad("timestamp")
+	}
+	ev.Time = time.UnixMicro(int64(us))
+	addr := strings.Split(f[3], ":")
+	if len(addr) != 4 || len(addr[0]) != 2 {
+		return bad("address")
+	}
+	kind, ok := textTypes[addr[0][0]]
+	if !ok {
+		return bad("transfer type")
+	}
+	ev.XferType = kind
+	bus, e1 := strconv.Atoi(addr[1])
+	dev, e2 := strconv.Atoi(addr[2])
+	ep, e3 := strconv.Atoi(addr[3])
+	if e1 != nil || e2 != nil || e3 != nil {
+		return bad("address")
+	}
+	ev.Bus, ev.Device, ev.Endpoint = uint16(bus), uint8(dev), uint8(ep)
+	if addr[0][1] == 'i' {
+		ev.Endpoint |= usb.ENDPOINT_IN
+	}
+	f = f[4:]
+	if f[0] == "s" {
+		if len(f) < 6 {
+			return bad("setup")
+		}
+		var setup []byte
+		for _, w := range f[1:6] {
+			b, e := hex.DecodeString(w)
+			if e != nil {
+				return bad("setup")
+			}
+			// the 16-bit fields print most significant byte first
+			for i := len(b) - 1; i >= 0; i-- {
+				setup = append(setup, b[i])
+			}
+		}
+		if len(setup) != 8 {
+			return bad("setup")
+		}
+		copy(ev.Setup[:], setup)
+		ev.SetupFlag = 0
+		f = f[6:]
+	} else {
+		// status, then :interval and for iso :start_frame:error_count
+		st := strings.Split(f[0], ":")
+		v, e := strconv.ParseInt(st[0], 10, 32)
+		if e != nil {
+			return bad("status")
+		}
+		ev.Status = int32(v)
+		if len(st) > 1 {
+			n, _ := strconv.ParseInt(st[1], 10, 32)
+			ev.Interval = int32(n)
+		}
+		if len(st) > 2 {
+			n, _ := strconv.ParseInt(st[2], 10, 32)
+			ev.StartFrame = int32(n)
+		}
+		f = f[1:]
+		if kind == usb.URB_TYPE_ISO && len(f) > 0 {
+			// descriptor count and the descriptors themselves
+			n, _ := strconv.Atoi(f[0])
+			ev.NumDesc = uint32(n)
+			f = f[min(1+n, len(f)):]
+		}
+	}
+	if ev.Type == EVENT_ERROR || len(f) == 0 {
+		return ev, nil
+	}
+	n, e := strconv.ParseUint(f[0], 10, 32)
+	if e != nil {
+		return bad("length")
+	}
+	ev.Length = uint32(n)
+	if len(f) > 1 && len(f[1]) == 1 {
+		ev.DataFlag = f[1][0]
+		if ev.DataFlag == '=' {
+			ev.DataFlag = 0
+			for _, w := range f[2:] {
+				b, e := hex.DecodeString(w)
+				if e != nil {
+					return bad("data")
+				}
+				ev.Data = append(ev.Data, b...)
+			}
+		}
+	}
+	return ev, nil
+}
+
+// TextReader reads events from a usbmon text stream.
+type TextReader struct {
+	s    *bufio.Scanner
+	c    io.Closer
+	live bool
+}
+
+func NewTextReader(r io.Reader) *TextReader {
+	return &TextReader{s: bufio.NewScanner(r)}
+}
+
+// OpenText opens the text interface for bus, or every bus for 0.  It
+// needs debugfs mounted and root.
+func OpenText(bus int) (*TextReader, error) {
+	f, e := os.Open(USBMON_DEBUGFS + strconv.Itoa(bus) + "u")
+	if e != nil {
+		return nil, e
+	}
+	r := NewTextReader(f)
+	r.c = f
+	r.live = true
+	return r, nil
+}
+
+// Next returns the next event, skipping blank lines.  It returns io.EOF
+// at the end of the stream.  Events read live from OpenText have their
+// timestamps placed in the current 4096-second window, which makes them
+// wall-clock times.
+func (r *TextReader) Next() (*Event, error) {
+	for r.s.Scan() {
+		if strings.TrimSpace(r.s.Text()) == "" {
+			continue
+		}
+		ev, e := ParseText(r.s.Text())
+		if e == nil && r.live {
+			now := time.Now()
+			t := time.Unix(now.Unix()&^0xfff, 0).Add(time.Duration(ev.Time.UnixMicro()) * time.Microsecond)
+			if t.After(now.Add(time.Second)) {
+				t = t.Add(-4096 * time.Second)
+			}
+			ev.Time = t
+		}
+		return ev, e
+	}
+	if e := r.s.Err(); e != nil {
+		return nil, e
+	}
+	return nil, io.EOF
+}
+
+func (r *TextReader) Close() error {
+	if r.c == nil {
+		return nil
+	}
+	return r.c.Close()
+}
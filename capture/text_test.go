@@ -0,0 +1,140 @@
+package capture_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/richardnwinder/usb"
+	"github.com/richardnwinder/usb/capture"
+)
+
+// lines in the formats Documentation/usb/usbmon.rst gives, and what they
+// parse to; Time is checked apart
+var textLines = []struct {
+	line string
+	want capture.Event
+}{
+	// a hub status request and its answer
+	{"d5ea89a0 3575914555 S Ci:1:001:0 s a3 00 0000 0003 0004 4 <", capture.Event{
+		ID: 0xd5ea89a0, Type: 'S', XferType: usb.URB_TYPE_CONTROL, Endpoint: 0x80, Device: 1, Bus: 1,
+		Setup: [8]byte{0xa3, 0x00, 0x00, 0x00, 0x03, 0x00, 0x04, 0x00}, DataFlag: '<', Length: 4}},
+	{"d5ea89a0 3575914560 C Ci:1:001:0 0 4 = 01050000", capture.Event{
+		ID: 0xd5ea89a0, Type: 'C', XferType: usb.URB_TYPE_CONTROL, Endpoint: 0x80, Device: 1, Bus: 1,
+		SetupFlag: '-', Length: 4, Data: []byte{0x01, 0x05, 0x00, 0x00}}},
+	// a SET_REPORT with its data
+	{"f7a8c400 1000 S Co:2:005:0 s 21 09 0200 0001 0008 8 = 01020304 05060708", capture.Event{
+		ID: 0xf7a8c400, Type: 'S', XferType: usb.URB_TYPE_CONTROL, Endpoint: 0, Device: 5, Bus: 2,
+		Setup: [8]byte{0x21, 0x09, 0x00, 0x02, 0x01, 0x00, 0x08, 0x00}, Length: 8,
+		Data: []byte{1, 2, 3, 4, 5, 6, 7, 8}}},
+	// interrupt transfers carry status:interval
+	{"c4a1b2c0 2000 S Ii:1:003:1 -115:8 4 <", capture.Event{
+		ID: 0xc4a1b2c0, Type: 'S', XferType: usb.URB_TYPE_INTERRUPT, Endpoint: 0x81, Device: 3, Bus: 1,
+		SetupFlag: '-', DataFlag: '<', Status: -115, Interval: 8, Length: 4}},
+	{"c4a1b2c0 2008 C Ii:1:003:1 0:8 4 = 00000100", capture.Event{
+		ID: 0xc4a1b2c0, Type: 'C', XferType: usb.URB_TYPE_INTERRUPT, Endpoint: 0x81, Device: 3, Bus: 1,
+		SetupFlag: '-', Interval: 8, Length: 4, Data: []byte{0, 0, 1, 0}}},
+	// a mass storage command, its last word short
+	{"dd65f0e8 4128379752 S Bo:1:002:2 -115 31 = 55534243 ad000000 00800000 80010a28 20000000 20000040 00000000 000000",
+		capture.Event{ID: 0xdd65f0e8, Type: 'S', XferType: usb.URB_TYPE_BULK, Endpoint: 2, Device: 2, Bus: 1,
+			SetupFlag: '-', Status: -115, Length: 31, Data: []byte{
+				0x55, 0x53, 0x42, 0x43, 0xad, 0, 0, 0, 0, 0x80, 0, 0, 0x80, 0x01, 0x0a, 0x28,
+				0x20, 0, 0, 0, 0x20, 0, 0, 0x40, 0, 0, 0, 0, 0, 0, 0}}},
+	// OUT data that isn't shown on completion
+	{"dd65f0e8 4128379808 C Bo:1:002:2 0 31 >", capture.Event{
+		ID: 0xdd65f0e8, Type: 'C', XferType: usb.URB_TYPE_BULK, Endpoint: 2, Device: 2, Bus: 1,
+		SetupFlag: '-', DataFlag: '>', Length: 31}},
+	// isochronous: status:interval:start_frame:error_count, then the
+	// descriptors, which are skipped
+	{"e0a3b000 3000 C Zi:1:004:3 0:1:1234:0 2 0:0:192 -18:192:0 192 = 01020304", capture.Event{
+		ID: 0xe0a3b000, Type: 'C', XferType: usb.URB_TYPE_ISO, Endpoint: 0x83, Device: 4, Bus: 1,
+		SetupFlag: '-', Interval: 1, StartFrame: 1234, NumDesc: 2, Length: 192, Data: []byte{1, 2, 3, 4}}},
+	{"e0a3b100 3001 S Zo:1:004:2 -115:1:1230 1 -18:0:96 96 = 0a0b", capture.Event{
+		ID: 0xe0a3b100, Type: 'S', XferType: usb.URB_TYPE_ISO, Endpoint: 2, Device: 4, Bus: 1,
+		SetupFlag: '-', Status: -115, Interval: 1, StartFrame: 1230, NumDesc: 1, Length: 96, Data: []byte{0x0a, 0x0b}}},
+	// a submission that failed, with no length after the error
+	{"d5ea8800 5000 E Bo:1:002:2 -19 0", capture.Event{
+		ID: 0xd5ea8800, Type: 'E', XferType: usb.URB_TYPE_BULK, Endpoint: 2, Device: 2, Bus: 1,
+		SetupFlag: '-', DataFlag: '-', Status: -19}},
+}
+
+func TestParseText(t *testing.T) {
+	for _, c := range textLines {
+		ev, e := capture.ParseText(c.line)
+		if e != nil {
+			t.Errorf("%q: %v", c.line, e)
+			continue
+		}
+		var us int64
+		fmt.Sscan(strings.Fields(c.line)[1], &us)
+		if ev.Time.UnixMicro() != us {
+			t.Errorf("%q: time %v, want %d µs", c.line, ev.Time, us)
+		}
+		got := *ev
+		got.Time = c.want.Time
+		if fmt.Sprintf("%+v", got) != fmt.Sprintf("%+v", c.want) {
+			t.Errorf("%q:\n got %+v\nwant %+v", c.line, got, c.want)
+		}
+	}
+}
+
+// TestParseTextSetup checks that the 16-bit setup fields, which usbmon
+// prints as numbers, come out little endian as on the wire
+func TestParseTextSetup(t *testing.T) {
+	ev, e := capture.ParseText("c0000000 1 S Ci:3:010:0 s 80 06 0302 0409 00ff 255 <")
+	if e != nil {
+		t.Fatal(e)
+	}
+	s := ev.Setup
+	if s[0] != 0x80 || s[1] != usb.REQ_GET_DESCRIPTOR ||
+		binary.LittleEndian.Uint16(s[2:]) != 0x0302 || binary.LittleEndian.Uint16(s[4:]) != 0x0409 ||
+		binary.LittleEndian.Uint16(s[6:]) != 0x00ff {
+		t.Errorf("setup % x, want 80 06 02 03 09 04 ff 00", s)
+	}
+}
+
+func TestParseTextInvalid(t *testing.T) {
+	for _, line := range []string{
+		"",
+		"d5ea89a0 3575914555 S",
+		"xyz 3575914555 S Ci:1:001:0 0 4 <",
+		"d5ea89a0 -1 S Ci:1:001:0 0 4 <",
+		"d5ea89a0 3575914555 SS Ci:1:001:0 0 4 <",
+		"d5ea89a0 3575914555 S Xi:1:001:0 0 4 <",
+		"d5ea89a0 3575914555 S Ci:1:001 0 4 <",
+		"d5ea89a0 3575914555 S Ci:1:abc:0 0 4 <",
+		"d5ea89a0 3575914555 S Ci:1:001:0 s a3 00 0000 0003",
+		"d5ea89a0 3575914555 S Ci:1:001:0 s a3 00 0000 00x3 0004 4 <",
+		"d5ea89a0 3575914555 S Ci:1:001:0 s a3 00 000000 0003 0004 4 <",
+		"d5ea89a0 3575914555 C Ci:1:001:0 ok 4 <",
+		"d5ea89a0 3575914555 C Ci:1:001:0 0 four <",
+		"d5ea89a0 3575914555 C Ci:1:001:0 0 4 = 01zz",
+	} {
+		if ev, e := capture.ParseText(line); e == nil {
+			t.Errorf("%q parsed as %+v", line, ev)
+		}
+	}
+}
+
+func TestTextReader(t *testing.T) {
+	var b bytes.Buffer
+	for _, c := range textLines {
+		fmt.Fprintf(&b, "%s\n\n", c.line)
+	}
+	r := capture.NewTextReader(&b)
+	for i := range textLines {
+		ev, e := r.Next()
+		if e != nil {
+			t.Fatalf("event %d: %v", i, e)
+		}
+		if ev.ID != textLines[i].want.ID || ev.Type != textLines[i].want.Type {
+			t.Errorf("event %d: %x %c, want %x %c", i, ev.ID, ev.Type, textLines[i].want.ID, textLines[i].want.Type)
+		}
+	}
+	if _, e := r.Next(); e != io.EOF {
+		t.Errorf("after the last event: %v, want EOF", e)
+	}
+}
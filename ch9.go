@@ -16,25 +16,56 @@ package usb
 
 const (
 	// descriptor types
-	DT_DEVICE             = 0x01
-	DT_CONFIG             = 0x02
-	DT_STRING             = 0x03
-	DT_INTERFACE          = 0x04
-	DT_ENDPOINT           = 0x05
-	DT_DEVICE_QUALIFIER   = 0x06
-	DT_OTHER_SPEED_CONFIG = 0x07
-	DT_INTERFACE_POWER    = 0x08
+	DT_DEVICE                 = 0x01
+	DT_CONFIG                 = 0x02
+	DT_STRING                 = 0x03
+	DT_INTERFACE              = 0x04
+	DT_ENDPOINT               = 0x05
+	DT_DEVICE_QUALIFIER       = 0x06
+	DT_OTHER_SPEED_CONFIG     = 0x07
+	DT_INTERFACE_POWER        = 0x08
+	DT_INTERFACE_ASSOC        = 0x0b
+	DT_BOS                    = 0x0f
+	DT_DEVICE_CAPABILITY      = 0x10
+	DT_SS_ENDPOINT_COMP       = 0x30
+	DT_SSP_ISOC_ENDPOINT_COMP = 0x31
 
 	// descriptor sizes
-	DT_DEVICE_SIZE         = 18
-	DT_CONFIG_SIZE         = 9
-	DT_INTERFACE_SIZE      = 9
-	DT_ENDPOINT_SIZE       = 7
-	DT_ENDPOINT_AUDIO_SIZE = 9
+	DT_DEVICE_SIZE           = 18
+	DT_DEVICE_QUALIFIER_SIZE = 10
+	DT_CONFIG_SIZE           = 9
+	DT_INTERFACE_SIZE        = 9
+	DT_ENDPOINT_SIZE         = 7
+	DT_ENDPOINT_AUDIO_SIZE   = 9
 
 	// endpoint address
 	ENDPOINT_IN = 0x80
 
+	// bmRequestType
+	REQTYPE_IN        = 0x80
+	REQTYPE_STANDARD  = 0x00
+	REQTYPE_CLASS     = 0x20
+	REQTYPE_VENDOR    = 0x40
+	REQTYPE_DEVICE    = 0x00
+	REQTYPE_INTERFACE = 0x01
+	REQTYPE_ENDPOINT  = 0x02
+	REQTYPE_OTHER     = 0x03
+
+	// standard requests
+	REQ_GET_STATUS        = 0x00
+	REQ_CLEAR_FEATURE     = 0x01
+	REQ_SET_FEATURE       = 0x03
+	REQ_SET_ADDRESS       = 0x05
+	REQ_GET_DESCRIPTOR    = 0x06
+	REQ_SET_DESCRIPTOR    = 0x07
+	REQ_GET_CONFIGURATION = 0x08
+	REQ_SET_CONFIGURATION = 0x09
+	REQ_GET_INTERFACE     = 0x0a
+	REQ_SET_INTERFACE     = 0x0b
+	REQ_SYNCH_FRAME       = 0x0c
+	REQ_SET_SEL           = 0x30
+	REQ_SET_ISOCH_DELAY   = 0x31
+
 	// endpoint attributes
 	ENDPOINT_XFER_CONTROL = 0
 	ENDPOINT_XFER_ISOC    = 1
@@ -60,6 +91,19 @@ type DeviceDescriptor struct {
 	NumConfigurations uint8
 }
 
+// DeviceQualifier describes how a high-speed capable device would look
+// at the other speed.
+type DeviceQualifier struct {
+	Length            uint8
+	DescriptorType    uint8
+	UsbVersion        uint16 // bcd
+	DeviceClass       uint8
+	DeviceSubClass    uint8
+	DeviceProtocol    uint8
+	MaxPacketSize0    uint8
+	NumConfigurations uint8
+}
+
 type ConfigDescriptor struct {
 	Length             uint8
 	DescriptorType     uint8
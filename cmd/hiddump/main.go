@@ -0,0 +1,280 @@
+// Command hiddump prints the report descriptor of a HID interface, in hex
+// and as its items with usages named, and with -live prints the input
+// reports the device sends, decoded.
+//
+//	hiddump [-d vid:pid] [-i interface] [-live]
+//	hiddump -f descriptor.bin
+//
+// Reading the descriptor from the device detaches usbhid from the
+// interface until the device is replugged.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/richardnwinder/usb"
+	"github.com/richardnwinder/usb/hid"
+)
+
+var (
+	devFlag  = flag.String("d", "", "vid:pid of the device in hex (default: the first HID interface found)")
+	ifcFlag  = flag.Int("i", -1, "interface number (default: the first HID interface)")
+	liveFlag = flag.Bool("live", false, "after the descriptor, print input reports as they arrive")
+	fileFlag = flag.String("f", "", "decode a binary report descriptor from this file instead of a device")
+)
+
+func main() {
+	flag.Parse()
+	if flag.NArg() != 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if e := run(); e != nil {
+		fmt.Fprintln(os.Stderr, "hiddump:", e)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	if *fileFlag != "" {
+		b, e := os.ReadFile(*fileFlag)
+		if e != nil {
+			return e
+		}
+		fmt.Printf("%s, %d bytes:\n", *fileFlag, len(b))
+		return dump(b)
+	}
+	di, ii, e := find()
+	if e != nil {
+		return e
+	}
+	dev, e := usb.Open(di)
+	if e != nil {
+		return e
+	}
+	defer dev.Close()
+	d, e := hid.NewDevice(dev, ii)
+	if e != nil {
+		return e
+	}
+	defer d.Close()
+	b, e := readDescriptor(dev, d)
+	if e != nil {
+		return e
+	}
+	fmt.Printf("%s interface %d, %d bytes:\n", di, ii.InterfaceNumber, len(b))
+	if e := dump(b); e != nil {
+		return e
+	}
+	if !*liveFlag {
+		return nil
+	}
+	rd, e := hid.ParseReportDescriptor(b)
+	if e != nil {
+		return e
+	}
+	return live(d, rd)
+}
+
+func find() (*usb.DeviceInfo, *usb.InterfaceInfo, error) {
+	var vid, pid uint64
+	if *devFlag != "" {
+		v, p, ok := strings.Cut(*devFlag, ":")
+		var e1, e2 error
+		vid, e1 = strconv.ParseUint(v, 16, 16)
+		pid, e2 = strconv.ParseUint(p, 16, 16)
+		if !ok || e1 != nil || e2 != nil {
+			return nil, nil, fmt.Errorf("bad -d %q, want vid:pid in hex", *devFlag)
+		}
+	}
+	devs, e := usb.ListDevices()
+	if e != nil {
+		return nil, nil, e
+	}
+	usb.SortByPort(devs)
+	for _, di := range devs {
+		if *devFlag != "" && (uint64(di.VendorID) != vid || uint64(di.ProductID) != pid) {
+			continue
+		}
+		if len(di.Config) == 0 {
+			continue
+		}
+		for i := range di.Config[0].Interface {
+			ii := &di.Config[0].Interface[i]
+			if ii.InterfaceClass != hid.CLASS_HID || ii.AlternateSetting != 0 {
+				continue
+			}
+			if *ifcFlag < 0 || int(ii.InterfaceNumber) == *ifcFlag {
+				return di, ii, nil
+			}
+		}
+	}
+	return nil, nil, errors.New("no HID interface found")
+}
+
+// readDescriptor fetches the raw descriptor, which hid.Device only hands
+// out parsed
+func readDescriptor(dev *usb.Device, d *hid.Device) ([]byte, error) {
+	b := make([]byte, 4096)
+	n, e := dev.ControlTransfer(usb.REQTYPE_IN|usb.REQTYPE_STANDARD|usb.REQTYPE_INTERFACE,
+		usb.REQ_GET_DESCRIPTOR, hid.DT_REPORT<<8, uint16(d.Interface()), uint16(len(b)), 1000, b)
+	if e != nil {
+		return nil, e
+	}
+	if n == 0 {
+		return nil, syscall.EPROTO
+	}
+	return b[:n], nil
+}
+
+func dump(b []byte) error {
+	for i := 0; i < len(b); i += 16 {
+		fmt.Printf("  %04x  % x\n", i, b[i:min(i+16, len(b))])
+	}
+	fmt.Println()
+	items, err := hid.Items(b)
+	depth := 0
+	var page uint16
+	var pages []uint16 // pushed
+	for _, it := range items {
+		if it.Type == hid.ITEM_MAIN && it.Tag == 0xc && depth > 0 {
+			depth--
+		}
+		fmt.Printf("  %-15s%s%s\n", fmt.Sprintf("% x", it.Raw), strings.Repeat("  ", depth), describe(&it, page))
+		switch {
+		case it.Type == hid.ITEM_MAIN && it.Tag == 0xa:
+			depth++
+		case it.Type == hid.ITEM_GLOBAL && it.Tag == 0x0:
+			page = uint16(it.Unsigned())
+		case it.Type == hid.ITEM_GLOBAL && it.Tag == 0xa:
+			pages = append(pages, page)
+		case it.Type == hid.ITEM_GLOBAL && it.Tag == 0xb && len(pages) > 0:
+			page = pages[len(pages)-1]
+			pages = pages[:len(pages)-1]
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("descriptor cut short after %d items: %w", len(items), err)
+	}
+	return nil
+}
+
+var collectionKinds = []string{"Physical", "Application", "Logical", "Report",
+	"Named Array", "Usage Switch", "Usage Modifier"}
+
+// mainFlags names bits 0 to 8 of Input, Output and Feature items, clear
+// then set
+var mainFlags = [][2]string{{"Data", "Const"}, {"Array", "Var"}, {"Abs", "Rel"},
+	{"No Wrap", "Wrap"}, {"Linear", "Non Linear"}, {"Preferred State", "No Preferred"},
+	{"No Null", "Null State"}, {"Non Volatile", "Volatile"}, {"Bit Field", "Buffered Bytes"}}
+
+func describe(it *hid.Item, page uint16) string {
+	name := it.Name()
+	if len(it.Data) == 0 {
+		return name
+	}
+	switch it.Type {
+	case hid.ITEM_MAIN:
+		switch it.Tag {
+		case 0x8, 0x9, 0xb:
+			u := it.Unsigned()
+			var flags []string
+			for i, f := range mainFlags {
+				// outputs and features only have volatility
+				if i == 7 && it.Tag == 0x8 {
+					continue
+				}
+				flags = append(flags, f[u>>i&1])
+			}
+			return fmt.Sprintf("%s (%s)", name, strings.Join(flags, ","))
+		case 0xa:
+			k := it.Unsigned()
+			if int(k) < len(collectionKinds) {
+				return fmt.Sprintf("%s (%s)", name, collectionKinds[k])
+			}
+			return fmt.Sprintf("%s (0x%02x)", name, k)
+		}
+	case hid.ITEM_GLOBAL:
+		switch it.Tag {
+		case 0x0:
+			return fmt.Sprintf("%s (%s)", name, hid.PageName(uint16(it.Unsigned())))
+		case 0x1, 0x2, 0x3, 0x4:
+			return fmt.Sprintf("%s (%d)", name, it.Signed())
+		case 0x5:
+			x := int(it.Unsigned() & 0xf)
+			if x >= 8 {
+				x -= 16
+			}
+			return fmt.Sprintf("%s (%d)", name, x)
+		case 0x6:
+			return fmt.Sprintf("%s (0x%x)", name, it.Unsigned())
+		}
+	case hid.ITEM_LOCAL:
+		switch it.Tag {
+		case 0x0, 0x1, 0x2:
+			u := hid.Usage(it.Unsigned())
+			if len(it.Data) < 4 {
+				u = hid.MakeUsage(page, uint16(u))
+				return fmt.Sprintf("%s (%s)", name, u.Name())
+			}
+			return fmt.Sprintf("%s (%s)", name, u)
+		}
+	}
+	return fmt.Sprintf("%s (%d)", name, it.Unsigned())
+}
+
+func live(d *hid.Device, rd *hid.ReportDescriptor) error {
+	n := d.InSize
+	for _, id := range rd.IDs(hid.REPORT_INPUT) {
+		n = max(n, rd.Size(hid.REPORT_INPUT, id))
+	}
+	b := make([]byte, n)
+	fmt.Println("\ninput reports, ^C to stop:")
+	for {
+		n, e := d.Read(b, 0)
+		if e != nil {
+			return e
+		}
+		fmt.Println(decode(rd, b[:n]))
+	}
+}
+
+func decode(rd *hid.ReportDescriptor, report []byte) string {
+	var id uint8
+	if rd.Numbered && len(report) > 0 {
+		id = report[0]
+	}
+	s := []string{fmt.Sprintf("% x:", report)}
+	if rd.Numbered {
+		s = append(s, fmt.Sprintf("id %d", id))
+	}
+	for _, f := range rd.Fields {
+		if f.Kind != hid.REPORT_INPUT || f.ReportID != id || f.Constant() {
+			continue
+		}
+		if !f.Variable() {
+			for _, u := range f.Active(report) {
+				s = append(s, u.Name())
+			}
+			continue
+		}
+		for i := 0; i < f.Count; i++ {
+			v, ok := f.Raw(report, i)
+			if !ok {
+				break
+			}
+			if f.Size == 1 && v == 0 {
+				// buttons and other bits that aren't set
+				continue
+			}
+			s = append(s, fmt.Sprintf("%s=%d", f.Usage(i).Name(), v))
+		}
+	}
+	return strings.Join(s, " ")
+}
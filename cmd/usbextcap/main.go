@@ -0,0 +1,222 @@
+// Command usbextcap is a Wireshark extcap for USB traffic.  It offers
+// each bus's usbmon text interface, which needs only read access to
+// debugfs rather than a privileged dumpcap, and a "usbtap" interface that
+// collects the traffic of programs streaming their library tap to it
+// with capture.DialTap.
+//
+// Install it in Wireshark's extcap directory (see About > Folders).
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/richardnwinder/usb"
+	"github.com/richardnwinder/usb/capture"
+)
+
+var (
+	interfacesFlag = flag.Bool("extcap-interfaces", false, "list the interfaces")
+	versionFlag    = flag.String("extcap-version", "", "Wireshark version")
+	ifaceFlag      = flag.String("extcap-interface", "", "interface to work on")
+	dltsFlag       = flag.Bool("extcap-dlts", false, "list the interface's link types")
+	configFlag     = flag.Bool("extcap-config", false, "list the interface's options")
+	captureFlag    = flag.Bool("capture", false, "start capturing")
+	fifoFlag       = flag.String("fifo", "", "pipe to write the capture to")
+	filterFlag     = flag.String("extcap-capture-filter", "", "capture filter (unused)")
+	deviceFlag     = flag.String("device", "", "only capture bus:device")
+	socketFlag     = flag.String("socket", capture.TapSocket(), "socket for usbtap")
+	_              = flag.Bool("debug", false, "")
+	_              = flag.String("debug-file", "", "")
+)
+
+const TAP_INTERFACE = "usbtap"
+
+func main() {
+	flag.Parse()
+	var e error
+	switch {
+	case *interfacesFlag:
+		e = interfaces()
+	case *ifaceFlag == "":
+		flag.Usage()
+		os.Exit(2)
+	case *dltsFlag:
+		fmt.Printf("dlt {number=%d}{name=USB_LINUX_MMAPPED}{display=USB with Linux mmapped header}\n",
+			capture.LINKTYPE_USB_LINUX_MMAPPED)
+	case *configFlag:
+		e = config()
+	case *captureFlag:
+		e = run()
+	}
+	if e != nil {
+		fmt.Fprintln(os.Stderr, "usbextcap:", e)
+		os.Exit(1)
+	}
+}
+
+// buses lists the usbmon text interfaces, falling back to the root hubs
+// when debugfs can't be read; bus 0 is every bus at once
+func buses() []int {
+	var list []int
+	if fi, e := os.ReadDir(capture.USBMON_DEBUGFS); e == nil {
+		for _, f := range fi {
+			if n, ok := strings.CutSuffix(f.Name(), "u"); ok {
+				if b, e := strconv.Atoi(n); e == nil {
+					list = append(list, b)
+				}
+			}
+		}
+	} else {
+		list = append(list, 0)
+		devs, _ := usb.ListDevices()
+		for _, di := range devs {
+			if di.Ports == nil {
+				list = append(list, di.BusNum)
+			}
+		}
+	}
+	sort.Ints(list)
+	return list
+}
+
+func interfaces() error {
+	fmt.Println("extcap {version=1.0}{help=https://github.com/richardnwinder/usb}")
+	for _, b := range buses() {
+		name := fmt.Sprintf("USB bus %d (usbmon)", b)
+		if b == 0 {
+			name = "All USB buses (usbmon)"
+		}
+		fmt.Printf("interface {value=usbmon%d}{display=%s}\n", b, name)
+	}
+	fmt.Printf("interface {value=%s}{display=USB library tap}\n", TAP_INTERFACE)
+	return nil
+}
+
+func config() error {
+	if *ifaceFlag == TAP_INTERFACE {
+		fmt.Printf("arg {number=0}{call=--socket}{display=Socket}{type=string}{default=%s}"+
+			"{tooltip=Unix socket programs stream their tap to}\n", capture.TapSocket())
+		return nil
+	}
+	fmt.Println("arg {number=0}{call=--device}{display=Device}{type=selector}" +
+		"{tooltip=Only capture this device}")
+	fmt.Println("value {arg=0}{value=}{display=All devices}{default=true}")
+	devs, _ := usb.ListDevices()
+	usb.SortByPort(devs)
+	for _, di := range devs {
+		fmt.Printf("value {arg=0}{value=%d:%d}{display=%03d:%03d %04x:%04x}\n",
+			di.BusNum, di.DevNum, di.BusNum, di.DevNum, di.VendorID, di.ProductID)
+	}
+	return nil
+}
+
+func run() error {
+	if *fifoFlag == "" {
+		return errors.New("no --fifo")
+	}
+	f, e := os.OpenFile(*fifoFlag, os.O_WRONLY, 0)
+	if e != nil {
+		return e
+	}
+	defer f.Close()
+	w, e := capture.NewPcapngWriter(f)
+	if e != nil {
+		return e
+	}
+	// Wireshark stops a capture with SIGTERM
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, syscall.SIGINT)
+	errc := make(chan error, 1)
+	if *ifaceFlag == TAP_INTERFACE {
+		defer os.Remove(*socketFlag)
+		go func() { errc <- tap(w) }()
+	} else {
+		go func() { errc <- usbmon(w) }()
+	}
+	select {
+	case <-sig:
+		return nil
+	case e := <-errc:
+		// Wireshark closing the pipe is the other way a capture ends
+		if errors.Is(e, syscall.EPIPE) {
+			return nil
+		}
+		return e
+	}
+}
+
+func usbmon(w *capture.PcapngWriter) error {
+	bus, e := strconv.Atoi(strings.TrimPrefix(*ifaceFlag, "usbmon"))
+	if e != nil {
+		return fmt.Errorf("unknown interface %q", *ifaceFlag)
+	}
+	var devBus, devNum int
+	if *deviceFlag != "" {
+		b, d, _ := strings.Cut(*deviceFlag, ":")
+		devBus, _ = strconv.Atoi(b)
+		devNum, _ = strconv.Atoi(d)
+	}
+	r, e := capture.OpenText(bus)
+	if e != nil {
+		return e
+	}
+	defer r.Close()
+	for {
+		ev, e := r.Next()
+		if e != nil {
+			return e
+		}
+		if devNum != 0 && (int(ev.Bus) != devBus || int(ev.Device) != devNum) {
+			continue
+		}
+		if e := w.WriteEvent(ev); e != nil {
+			return e
+		}
+	}
+}
+
+func tap(w *capture.PcapngWriter) error {
+	os.Remove(*socketFlag)
+	l, e := net.Listen("unix", *socketFlag)
+	if e != nil {
+		return e
+	}
+	defer l.Close()
+	errc := make(chan error, 1)
+	go func() {
+		for {
+			c, e := l.Accept()
+			if e != nil {
+				errc <- e
+				return
+			}
+			go func() {
+				defer c.Close()
+				for {
+					ev, e := capture.ReadEvent(c)
+					if e != nil {
+						if e != io.EOF {
+							fmt.Fprintln(os.Stderr, "usbextcap:", e)
+						}
+						return
+					}
+					if e := w.WriteEvent(ev); e != nil {
+						errc <- e
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return <-errc
+}
@@ -0,0 +1,211 @@
+// Command usbflash writes a firmware image to a device in its bootloader,
+// speaking DFU (including DfuSe), the Microchip HID Bootloader protocol
+// or Atmel's FLIP over HID.  Atmel's vendor ID is on plenty of HID devices
+// that are not bootloaders, so a FLIP bootloader is only used when -d
+// names it.
+//
+//	usbflash [-d vid:pid] [-a address] [-resume n] [-reset] image.{bin,hex}
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/richardnwinder/usb"
+	"github.com/richardnwinder/usb/dfu"
+	"github.com/richardnwinder/usb/firmware"
+	"github.com/richardnwinder/usb/flip"
+	"github.com/richardnwinder/usb/hid"
+	"github.com/richardnwinder/usb/mchp"
+)
+
+var (
+	devFlag    = flag.String("d", "", "vid:pid of the device (default: first bootloader found)")
+	addrFlag   = flag.String("a", "", "load address for .bin images (DfuSe)")
+	resumeFlag = flag.Int64("resume", 0, "skip this many bytes already written by an interrupted run")
+	resetFlag  = flag.Bool("reset", true, "restart into the application when done")
+)
+
+func main() {
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if e := run(flag.Arg(0)); e != nil {
+		fmt.Fprintln(os.Stderr, "\nusbflash:", e)
+		os.Exit(1)
+	}
+	fmt.Fprintln(os.Stderr)
+}
+
+func progress(stage firmware.Stage, done int64, total int64) {
+	if total > 0 {
+		fmt.Fprintf(os.Stderr, "\r%-8s %3d%% (%d/%d)", stage, done*100/total, done, total)
+	} else {
+		fmt.Fprintf(os.Stderr, "\r%-8s %-20s", stage, "")
+	}
+}
+
+func parseNum(s string) (uint64, error) {
+	return strconv.ParseUint(s, 0, 32)
+}
+
+func loadImage(name string) ([]firmware.Segment, error) {
+	b, e := os.ReadFile(name)
+	if e != nil {
+		return nil, e
+	}
+	if strings.HasSuffix(strings.ToLower(name), ".hex") {
+		return firmware.ReadHex(bytes.NewReader(b))
+	}
+	var addr uint64
+	if *addrFlag != "" {
+		if addr, e = parseNum(*addrFlag); e != nil {
+			return nil, e
+		}
+	}
+	return []firmware.Segment{{Address: uint32(addr), Data: b}}, nil
+}
+
+func isDFU(ii *usb.InterfaceInfo) bool {
+	return ii.InterfaceClass == dfu.CLASS_APP_SPECIFIC && ii.InterfaceSubClass == dfu.SUBCLASS_DFU
+}
+
+// find picks the device to flash and the bootloader interface on it
+func find() (*usb.DeviceInfo, *usb.InterfaceInfo, error) {
+	var vid, pid uint64
+	if *devFlag != "" {
+		v, p, ok := strings.Cut(*devFlag, ":")
+		var e1, e2 error
+		vid, e1 = strconv.ParseUint(v, 16, 16)
+		pid, e2 = strconv.ParseUint(p, 16, 16)
+		if !ok || e1 != nil || e2 != nil {
+			return nil, nil, fmt.Errorf("bad -d %q, want vid:pid in hex", *devFlag)
+		}
+	}
+	devs, e := usb.ListDevices()
+	if e != nil {
+		return nil, nil, e
+	}
+	usb.SortByPort(devs)
+	for _, di := range devs {
+		if *devFlag != "" && (uint64(di.VendorID) != vid || uint64(di.ProductID) != pid) {
+			continue
+		}
+		if len(di.Config) == 0 {
+			continue
+		}
+		for i := range di.Config[0].Interface {
+			ii := &di.Config[0].Interface[i]
+			if isDFU(ii) {
+				return di, ii, nil
+			}
+			if ii.InterfaceClass == hid.CLASS_HID &&
+				di.VendorID == mchp.VendorID && di.ProductID == mchp.ProductID {
+				return di, ii, nil
+			}
+			if ii.InterfaceClass == hid.CLASS_HID && *devFlag != "" && di.VendorID == flip.VendorID {
+				return di, ii, nil
+			}
+		}
+	}
+	return nil, nil, errors.New("no bootloader found")
+}
+
+func run(name string) error {
+	segs, e := loadImage(name)
+	if e != nil {
+		return e
+	}
+	if len(segs) == 0 {
+		return errors.New("empty image")
+	}
+	di, ii, e := find()
+	if e != nil {
+		return e
+	}
+	dev, e := usb.Open(di)
+	if e != nil {
+		return e
+	}
+	defer dev.Close()
+	opts := &firmware.Options{Progress: progress, Resume: *resumeFlag}
+	if isDFU(ii) {
+		return flashDFU(dev, ii, segs, opts)
+	}
+	if di.VendorID == flip.VendorID {
+		return flashFLIP(dev, ii, segs, opts)
+	}
+	return flashMCHP(dev, ii, segs, opts)
+}
+
+func flashDFU(dev *usb.Device, ii *usb.InterfaceInfo, segs []firmware.Segment, opts *firmware.Options) error {
+	d, e := dfu.Open(dev, ii)
+	if e != nil {
+		return e
+	}
+	defer d.Close()
+	if d.Mode == dfu.PROTOCOL_RUNTIME {
+		return errors.New("device is in runtime mode; detach it and run again")
+	}
+	addr, img := firmware.Flatten(segs, 0xff)
+	if !d.DfuSe() && len(segs) > 1 {
+		return errors.New("image has gaps, which plain DFU can't address")
+	}
+	opts.Address = addr
+	if e := d.Download(bytes.NewReader(img), int64(len(img)), opts); e != nil {
+		return e
+	}
+	if *resetFlag && d.Attributes&dfu.ATTR_MANIFESTATION_TOLERANT == 0 {
+		opts.Report(firmware.STAGE_RESET, 0, 0)
+		// the device may already be gone
+		dev.Reset()
+	}
+	return nil
+}
+
+func flashMCHP(dev *usb.Device, ii *usb.InterfaceInfo, segs []firmware.Segment, opts *firmware.Options) error {
+	h, e := hid.NewDevice(dev, ii)
+	if e != nil {
+		return e
+	}
+	defer h.Close()
+	d, e := mchp.Open(h)
+	if e != nil {
+		return e
+	}
+	if e := d.Program(segs, opts); e != nil {
+		return e
+	}
+	if *resetFlag {
+		opts.Report(firmware.STAGE_RESET, 0, 0)
+		return d.Reset()
+	}
+	return nil
+}
+
+func flashFLIP(dev *usb.Device, ii *usb.InterfaceInfo, segs []firmware.Segment, opts *firmware.Options) error {
+	h, e := hid.NewDevice(dev, ii)
+	if e != nil {
+		return e
+	}
+	defer h.Close()
+	d, e := flip.Open(h)
+	if e != nil {
+		return e
+	}
+	if e := d.Program(segs, opts); e != nil {
+		return e
+	}
+	if *resetFlag {
+		opts.Report(firmware.STAGE_RESET, 0, 0)
+		return d.Reset()
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+// Command usbreset resets a USB device, as the usbreset.c programs do,
+// falling back to resetting its port on the parent hub when the device
+// doesn't respond to USBDEVFS_RESET.
+//
+//	usbreset [-port] vid:pid | bus/dev | port-path | /dev/bus/usb/BBB/DDD
+//
+// vid:pid is in hex, bus/dev in decimal and a port path is as sysfs
+// names devices, such as 1-2.3.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/richardnwinder/usb"
+	"github.com/richardnwinder/usb/hub"
+)
+
+var portFlag = flag.Bool("port", false, "reset the port on the parent hub without trying the device first")
+
+func main() {
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	di, e := find(flag.Arg(0))
+	if e != nil {
+		fmt.Fprintln(os.Stderr, "usbreset:", e)
+		os.Exit(1)
+	}
+	fmt.Printf("resetting %s (%s)\n", di, di.Path())
+	if !*portFlag {
+		e = reset(di)
+		if e == nil {
+			fmt.Println("ok")
+			return
+		}
+		fmt.Fprintf(os.Stderr, "usbreset: device reset failed: %v; trying the hub port\n", e)
+	}
+	if e := resetPort(di); e != nil {
+		fmt.Fprintln(os.Stderr, "usbreset: port reset failed:", e)
+		os.Exit(1)
+	}
+	fmt.Println("ok, port reset")
+}
+
+func find(arg string) (*usb.DeviceInfo, error) {
+	devs, e := usb.ListDevices()
+	if e != nil {
+		return nil, e
+	}
+	var match func(di *usb.DeviceInfo) bool
+	switch {
+	case strings.HasPrefix(arg, "/dev/"):
+		match = func(di *usb.DeviceInfo) bool {
+			return fmt.Sprintf("/dev/bus/usb/%03d/%03d", di.BusNum, di.DevNum) == arg
+		}
+	case strings.Contains(arg, "/"):
+		b, d, _ := strings.Cut(arg, "/")
+		bus, e1 := strconv.Atoi(b)
+		dev, e2 := strconv.Atoi(d)
+		if e1 != nil || e2 != nil {
+			return nil, fmt.Errorf("bad bus/dev %q", arg)
+		}
+		match = func(di *usb.DeviceInfo) bool { return di.BusNum == bus && di.DevNum == dev }
+	case strings.Contains(arg, ":"):
+		v, p, _ := strings.Cut(arg, ":")
+		vid, e1 := strconv.ParseUint(v, 16, 16)
+		pid, e2 := strconv.ParseUint(p, 16, 16)
+		if e1 != nil || e2 != nil {
+			return nil, fmt.Errorf("bad vid:pid %q", arg)
+		}
+		match = usb.MatchVidPid(uint16(vid), uint16(pid))
+	case strings.Contains(arg, "-"):
+		match = func(di *usb.DeviceInfo) bool { return di.Path() == arg }
+	default:
+		return nil, fmt.Errorf("%q is not a vid:pid, bus/dev or port path", arg)
+	}
+	var found []*usb.DeviceInfo
+	for _, di := range devs {
+		if match(di) {
+			found = append(found, di)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("no device %s", arg)
+	case 1:
+		return found[0], nil
+	}
+	usb.SortByPort(found)
+	var paths []string
+	for _, di := range found {
+		paths = append(paths, di.Path())
+	}
+	return nil, fmt.Errorf("%d devices match %s, at %s; pick one by port path", len(found), arg, strings.Join(paths, ", "))
+}
+
+func reset(di *usb.DeviceInfo) error {
+	u, e := usb.Open(di)
+	if e != nil {
+		return e
+	}
+	defer u.Close()
+	return u.Reset()
+}
+
+func resetPort(di *usb.DeviceInfo) error {
+	parent, e := di.Parent()
+	if errors.Is(e, syscall.ENODEV) {
+		return errors.New("root hubs have no parent hub")
+	}
+	if e != nil {
+		return fmt.Errorf("reading the parent hub: %w", e)
+	}
+	u, e := usb.Open(parent)
+	if e != nil {
+		return fmt.Errorf("opening hub %s: %w", parent.Path(), e)
+	}
+	defer u.Close()
+	return hub.ResetPort(u, di.Ports[len(di.Ports)-1])
+}
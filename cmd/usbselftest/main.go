@@ -0,0 +1,40 @@
+// Command usbselftest brings up the dummy_hcd loopback gadget and runs the
+// package's transfer paths against it.  It must be run as root.
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/richardnwinder/usb/internal/loopback"
+)
+
+func main() {
+	g, e := loopback.Setup()
+	if e != nil {
+		fmt.Fprintln(os.Stderr, "setup:", e)
+		os.Exit(1)
+	}
+	status := run(g)
+	g.Teardown()
+	os.Exit(status)
+}
+
+func run(g *loopback.Gadget) int {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	dev, di, e := g.Open(ctx)
+	if e != nil {
+		fmt.Fprintln(os.Stderr, "open:", e)
+		return 1
+	}
+	defer dev.Close()
+	if e := loopback.Exercise(dev, di); e != nil {
+		fmt.Fprintln(os.Stderr, e)
+		return 1
+	}
+	fmt.Println("ok")
+	return 0
+}
@@ -0,0 +1,227 @@
+// Command usbterm is a terminal on a USB serial adapter, driven from user
+// space by package serial rather than through /dev/ttyACM or ttyUSB.
+//
+//	usbterm [-d vid:pid] [-b baud] [-m 8N1] [-raw=false] [-log file]
+//
+// In raw mode, the default, keys go to the device as they are typed and
+// ^] quits; otherwise lines are sent as they are entered and end of file
+// quits.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/richardnwinder/usb"
+	"github.com/richardnwinder/usb/serial"
+)
+
+const escape = 0x1d // ^]
+
+var (
+	devFlag  = flag.String("d", "", "vid:pid of the adapter in hex (default: the first one found)")
+	baudFlag = flag.Int("b", 115200, "baud rate")
+	modeFlag = flag.String("m", "8N1", "data bits, parity (N, O, E, M or S) and stop bits (1, 1.5 or 2)")
+	rawFlag  = flag.Bool("raw", true, "put the terminal in raw mode and send each key as it is typed")
+	logFlag  = flag.String("log", "", "also append what the device sends to this file")
+)
+
+func main() {
+	flag.Parse()
+	if flag.NArg() != 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if e := run(); e != nil {
+		fmt.Fprintln(os.Stderr, "usbterm:", e)
+		os.Exit(1)
+	}
+}
+
+func parseMode(s string, baud int) (serial.Mode, error) {
+	m := serial.Mode{Baud: baud}
+	if len(s) < 3 || s[0] < '5' || s[0] > '8' {
+		return m, fmt.Errorf("bad mode %q", s)
+	}
+	m.DataBits = int(s[0] - '0')
+	p := strings.Index("NOEMS", strings.ToUpper(s[1:2]))
+	if p < 0 {
+		return m, fmt.Errorf("bad parity in %q", s)
+	}
+	m.Parity = serial.Parity(p)
+	switch s[2:] {
+	case "1":
+		m.StopBits = serial.STOP_1
+	case "1.5":
+		m.StopBits = serial.STOP_1_5
+	case "2":
+		m.StopBits = serial.STOP_2
+	default:
+		return m, fmt.Errorf("bad stop bits in %q", s)
+	}
+	return m, nil
+}
+
+// isSerial says whether package serial can drive the device
+func isSerial(di *usb.DeviceInfo) bool {
+	if di.VendorID == serial.FTDI_VID {
+		return true
+	}
+	if len(di.Config) == 0 {
+		return false
+	}
+	for _, f := range di.Config[0].Functions() {
+		if f.Class == usb.CLASS_COMM && f.SubClass == serial.SUBCLASS_ACM {
+			return true
+		}
+	}
+	return false
+}
+
+func find() (*usb.DeviceInfo, error) {
+	var vid, pid uint64
+	if *devFlag != "" {
+		v, p, ok := strings.Cut(*devFlag, ":")
+		var e1, e2 error
+		vid, e1 = strconv.ParseUint(v, 16, 16)
+		pid, e2 = strconv.ParseUint(p, 16, 16)
+		if !ok || e1 != nil || e2 != nil {
+			return nil, fmt.Errorf("bad -d %q, want vid:pid in hex", *devFlag)
+		}
+	}
+	devs, e := usb.ListDevices()
+	if e != nil {
+		return nil, e
+	}
+	usb.SortByPort(devs)
+	for _, di := range devs {
+		if *devFlag != "" && (uint64(di.VendorID) != vid || uint64(di.ProductID) != pid) {
+			continue
+		}
+		if isSerial(di) {
+			return di, nil
+		}
+	}
+	return nil, errors.New("no serial adapter found")
+}
+
+func run() error {
+	mode, e := parseMode(*modeFlag, *baudFlag)
+	if e != nil {
+		return e
+	}
+	di, e := find()
+	if e != nil {
+		return e
+	}
+	var out io.Writer = os.Stdout
+	if *logFlag != "" {
+		f, e := os.OpenFile(*logFlag, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if e != nil {
+			return e
+		}
+		defer f.Close()
+		out = io.MultiWriter(os.Stdout, f)
+	}
+	dev, e := usb.Open(di)
+	if e != nil {
+		return e
+	}
+	defer dev.Close()
+	port, e := serial.Open(dev, di)
+	if e != nil {
+		return e
+	}
+	defer port.Close()
+	if e := port.SetMode(mode); e != nil {
+		return fmt.Errorf("setting %d %s: %w", mode.Baud, *modeFlag, e)
+	}
+	if *rawFlag {
+		restore, e := makeRaw(int(os.Stdin.Fd()))
+		if e != nil {
+			return fmt.Errorf("raw mode: %w", e)
+		}
+		defer restore()
+		fmt.Fprintf(os.Stderr, "connected to %s at %d %s; ^] quits\r\n", di, mode.Baud, *modeFlag)
+	} else {
+		fmt.Fprintf(os.Stderr, "connected to %s at %d %s\n", di, mode.Baud, *modeFlag)
+	}
+	done := make(chan error, 2)
+	go func() {
+		_, e := io.Copy(out, port)
+		done <- e
+	}()
+	go func() {
+		done <- send(port)
+	}()
+	e = <-done
+	if e == io.EOF {
+		e = nil
+	}
+	return e
+}
+
+// send copies standard input to the port until end of file or, in raw
+// mode, the escape key
+func send(port serial.Port) error {
+	b := make([]byte, 256)
+	for {
+		n, e := os.Stdin.Read(b)
+		if n > 0 && *rawFlag {
+			if i := strings.IndexByte(string(b[:n]), escape); i >= 0 {
+				if i > 0 {
+					port.Write(b[:i])
+				}
+				return nil
+			}
+		}
+		if n > 0 {
+			if _, e := port.Write(b[:n]); e != nil {
+				return e
+			}
+		}
+		if e == io.EOF {
+			return nil
+		}
+		if e != nil {
+			return e
+		}
+	}
+}
+
+// makeRaw puts the terminal in raw mode as cfmakeraw(3) does, returning
+// a function to put it back
+func makeRaw(fd int) (func(), error) {
+	var old syscall.Termios
+	if e := termios(fd, syscall.TCGETS, &old); e != nil {
+		return nil, e
+	}
+	t := old
+	t.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP |
+		syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	t.Oflag &^= syscall.OPOST
+	t.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	t.Cflag &^= syscall.CSIZE | syscall.PARENB
+	t.Cflag |= syscall.CS8
+	t.Cc[syscall.VMIN] = 1
+	t.Cc[syscall.VTIME] = 0
+	if e := termios(fd, syscall.TCSETS, &t); e != nil {
+		return nil, e
+	}
+	return func() { termios(fd, syscall.TCSETS, &old) }, nil
+}
+
+func termios(fd int, req uintptr, t *syscall.Termios) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), req, uintptr(unsafe.Pointer(t)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
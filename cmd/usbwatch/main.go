@@ -0,0 +1,173 @@
+// Command usbwatch prints USB devices being plugged in and removed, as
+// the kernel announces them.
+//
+//	usbwatch [-json] [-e] [-a actions] [-d vid[:pid]]...
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/richardnwinder/usb"
+)
+
+type match struct {
+	vid, pid uint16
+	anyPID   bool
+}
+
+// devices collects -d filters
+type devices []match
+
+func (d *devices) String() string { return "" }
+
+func (d *devices) Set(s string) error {
+	v, p, hasPID := strings.Cut(s, ":")
+	vid, e := strconv.ParseUint(v, 16, 16)
+	if e != nil {
+		return fmt.Errorf("bad vendor ID %q", v)
+	}
+	m := match{vid: uint16(vid), anyPID: !hasPID || p == ""}
+	if !m.anyPID {
+		pid, e := strconv.ParseUint(p, 16, 16)
+		if e != nil {
+			return fmt.Errorf("bad product ID %q", p)
+		}
+		m.pid = uint16(pid)
+	}
+	*d = append(*d, m)
+	return nil
+}
+
+var (
+	jsonFlag    = flag.Bool("json", false, "print one JSON object per event")
+	enumFlag    = flag.Bool("e", false, "first report devices already attached, as add events")
+	actionsFlag = flag.String("a", "add,remove", "comma separated actions to show: add, remove, bind, unbind, change, or all")
+	devFlag     devices
+)
+
+func init() {
+	flag.Var(&devFlag, "d", "only show devices with this vid:pid in hex, or any product of vid; may be repeated")
+}
+
+func accept(di *usb.DeviceInfo) bool {
+	if len(devFlag) == 0 {
+		return true
+	}
+	for _, m := range devFlag {
+		if di.VendorID == m.vid && (m.anyPID || di.ProductID == m.pid) {
+			return true
+		}
+	}
+	return false
+}
+
+func portPath(di *usb.DeviceInfo) string {
+	if len(di.Ports) == 0 {
+		return fmt.Sprintf("usb%d", di.BusNum)
+	}
+	p := make([]string, len(di.Ports))
+	for i, n := range di.Ports {
+		p[i] = strconv.Itoa(n)
+	}
+	return fmt.Sprintf("%d-%s", di.BusNum, strings.Join(p, "."))
+}
+
+type record struct {
+	Time    string `json:"time"`
+	Action  string `json:"action"`
+	Bus     int    `json:"bus"`
+	Device  int    `json:"dev"`
+	Port    string `json:"port"`
+	Vendor  string `json:"vid"`
+	Product string `json:"pid"`
+	Version string `json:"bcdDevice"`
+	Class   string `json:"class"`
+	Serial  string `json:"serial,omitempty"`
+}
+
+func report(ev *usb.HotplugEvent) {
+	di := ev.Device
+	now := time.Now()
+	if *jsonFlag {
+		b, _ := json.Marshal(record{
+			Time:    now.Format(time.RFC3339Nano),
+			Action:  ev.Action,
+			Bus:     di.BusNum,
+			Device:  di.DevNum,
+			Port:    portPath(di),
+			Vendor:  fmt.Sprintf("%04x", di.VendorID),
+			Product: fmt.Sprintf("%04x", di.ProductID),
+			Version: fmt.Sprintf("%04x", di.DeviceVersion),
+			Class:   fmt.Sprintf("%02x/%02x/%02x", di.DeviceClass, di.DeviceSubClass, di.DeviceProtocol),
+			Serial:  serial(ev),
+		})
+		fmt.Printf("%s\n", b)
+		return
+	}
+	line := fmt.Sprintf("%s %-6s %s port %s", now.Format("15:04:05.000"), ev.Action, di, portPath(di))
+	if s := serial(ev); s != "" {
+		line += " serial " + s
+	}
+	fmt.Println(line)
+}
+
+// removed devices have no sysfs entry left to read the serial from
+func serial(ev *usb.HotplugEvent) string {
+	if ev.Action == "remove" {
+		return ""
+	}
+	return ev.Device.Serial()
+}
+
+func main() {
+	flag.Parse()
+	if flag.NArg() != 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	show := make(map[string]bool)
+	for _, a := range strings.Split(*actionsFlag, ",") {
+		show[strings.TrimSpace(a)] = true
+	}
+	if show["all"] {
+		for _, a := range []string{"add", "remove", "bind", "unbind", "change"} {
+			show[a] = true
+		}
+	}
+	// listen before listing, so nothing is missed in between
+	m, e := usb.NewHotplugMonitor()
+	if e != nil {
+		fmt.Fprintln(os.Stderr, "usbwatch:", e)
+		os.Exit(1)
+	}
+	defer m.Close()
+	if *enumFlag {
+		devs, e := usb.ListDevices()
+		if e != nil {
+			fmt.Fprintln(os.Stderr, "usbwatch:", e)
+			os.Exit(1)
+		}
+		usb.SortByPort(devs)
+		for _, di := range devs {
+			if accept(di) {
+				report(&usb.HotplugEvent{Action: "add", Device: di})
+			}
+		}
+	}
+	for {
+		ev, e := m.Next()
+		if e != nil {
+			fmt.Fprintln(os.Stderr, "usbwatch:", e)
+			os.Exit(1)
+		}
+		if show[ev.Action] && accept(ev.Device) {
+			report(ev)
+		}
+	}
+}
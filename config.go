@@ -0,0 +1,134 @@
+package usb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// DriverBoundError is returned by SelectConfiguration when a kernel
+// driver holds an interface of the active configuration, which makes
+// SET_CONFIGURATION fail with EBUSY.  errors.Is matches EBUSY.
+type DriverBoundError struct {
+	Interface uint8
+	Driver    string
+}
+
+func (e *DriverBoundError) Error() string {
+	return fmt.Sprintf("usb: interface %d is bound to %s; detach it before changing configuration", e.Interface, e.Driver)
+}
+
+func (e *DriverBoundError) Unwrap() error {
+	return syscall.EBUSY
+}
+
+// Driver returns the name of the kernel driver bound to an interface of
+// the active configuration, giving ENODATA if there is none.  Interfaces
+// claimed through usbfs show "usbfs".
+func (u *Device) Driver(ifc uint8) (string, error) {
+	x := usbdevfs_getdriver{ifc: uint32(ifc)}
+	start := time.Now()
+	if _, e := u.do(USBDEVFS_GETDRIVER, unsafe.Pointer(&x)); e != nil {
+		return "", u.opError("get_driver", start, e, int(ifc))
+	}
+	n := bytes.IndexByte(x.driver[:], 0)
+	if n < 0 {
+		n = len(x.driver)
+	}
+	return string(x.driver[:n]), nil
+}
+
+// Configuration asks the device for its bConfigurationValue, 0 when it is
+// unconfigured.
+func (u *Device) Configuration() (uint8, error) {
+	b := make([]byte, 1)
+	n, e := u.ControlTransfer(REQTYPE_IN, REQ_GET_CONFIGURATION, 0, 0, 1, 1000, b)
+	if e != nil {
+		return 0, e
+	}
+	if n < 1 {
+		return 0, syscall.EPROTO
+	}
+	return b[0], nil
+}
+
+// SelectConfiguration switches the device to the configuration with
+// bConfigurationValue value and returns its descriptors, re-read from the
+// device.  Interfaces claimed through u are released first.  Kernel
+// drivers bound to the active configuration's interfaces are disconnected
+// if detach is set; otherwise the first is returned as a
+// *DriverBoundError.  Interfaces claimed through another usbfs file still
+// make it fail with EBUSY.
+func (u *Device) SelectConfiguration(value uint8, detach bool) (*ConfigInfo, error) {
+	configs, e := u.ConfigDescriptors()
+	if e != nil {
+		return nil, e
+	}
+	var want *ConfigInfo
+	for _, ci := range configs {
+		if ci.ConfigurationValue == value {
+			want = ci
+		}
+	}
+	if want == nil && value != 0 {
+		return nil, syscall.EINVAL
+	}
+	cur, e := u.Configuration()
+	if e != nil {
+		return nil, e
+	}
+
+	u.lock.Lock()
+	var claimed []uint32
+	for n := range u.claimed {
+		claimed = append(claimed, n)
+	}
+	u.lock.Unlock()
+	for _, n := range claimed {
+		if e := u.ReleaseInterface(n); e != nil && !errors.Is(e, syscall.EINVAL) {
+			return nil, e
+		}
+	}
+
+	// only the active configuration's interfaces can have drivers
+	for _, ci := range configs {
+		if ci.ConfigurationValue != cur {
+			continue
+		}
+		for _, ii := range ci.Interface {
+			if ii.AlternateSetting != 0 {
+				continue
+			}
+			name, e := u.Driver(ii.InterfaceNumber)
+			if errors.Is(e, syscall.ENODATA) || name == "usbfs" {
+				continue
+			}
+			if e != nil {
+				return nil, e
+			}
+			if !detach {
+				return nil, &DriverBoundError{ii.InterfaceNumber, name}
+			}
+			if e := u.DisconnectDriver(ii.InterfaceNumber); e != nil && !errors.Is(e, syscall.ENODATA) {
+				return nil, e
+			}
+		}
+	}
+
+	if e := u.SetConfiguration(value); e != nil {
+		return nil, e
+	}
+	if value == 0 {
+		return nil, nil
+	}
+	// the configuration may describe itself differently once selected
+	for i, ci := range configs {
+		if ci.ConfigurationValue == value {
+			return u.ConfigDescriptor(uint8(i))
+		}
+	}
+	return want, nil
+}
@@ -0,0 +1,74 @@
+package usb
+
+import (
+	"context"
+	"io"
+)
+
+// ControlTransferer does control transfers on endpoint 0, as
+// Device.ControlTransfer does.
+type ControlTransferer interface {
+	ControlTransfer(reqtype uint8, request uint8, value uint16, index uint16, length uint16, timeout uint32, data []byte) (int, error)
+}
+
+// BulkTransferer does synchronous bulk and interrupt transfers, as
+// Device.BulkTransfer does.
+type BulkTransferer interface {
+	BulkTransfer(endpoint uint32, length uint32, timeout uint32, data []byte) (int, []byte, error)
+}
+
+// EndpointReader reads from an IN endpoint, as a BulkReader does.
+type EndpointReader interface {
+	io.Reader
+	ReadContext(ctx context.Context, p []byte) (int, error)
+}
+
+// EndpointWriter writes to an OUT endpoint, as a ThrottledWriter does.
+type EndpointWriter interface {
+	io.Writer
+	WriteContext(ctx context.Context, p []byte) (int, error)
+}
+
+// Conn is what code driving an open device usually needs of it.  Code
+// that accepts a Conn works with a local *Device, a *remote.Device or a
+// simulated *usbtest.Device alike.
+type Conn interface {
+	ControlTransferer
+	BulkTransferer
+	ClaimInterface(n uint32) error
+	ReleaseInterface(n uint32) error
+	SetConfiguration(num uint8) error
+	SetInterface(num uint8, alt uint8) error
+	ClearHalt(endpoint uint8) error
+	Reset() error
+	Close()
+}
+
+// Opener lists and opens devices.  Local is this machine's; a
+// remote.Client is another's.
+type Opener interface {
+	ListDevices() ([]*DeviceInfo, error)
+	OpenDevice(di *DeviceInfo) (Conn, error)
+}
+
+var (
+	_ Conn           = (*Device)(nil)
+	_ EndpointReader = (*BulkReader)(nil)
+	_ EndpointWriter = (*ThrottledWriter)(nil)
+)
+
+// Local opens devices through this machine's usbfs.
+var Local Opener = local{}
+
+type local struct{}
+
+func (local) ListDevices() ([]*DeviceInfo, error) { return ListDevices() }
+
+func (local) OpenDevice(di *DeviceInfo) (Conn, error) {
+	u, e := Open(di)
+	if e != nil {
+		// not a Conn holding a nil *Device
+		return nil, e
+	}
+	return u, nil
+}
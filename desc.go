@@ -0,0 +1,124 @@
+package usb
+
+import (
+	"syscall"
+	"unicode/utf16"
+)
+
+// GetDescriptor reads descriptor kind/index into buf.  lang is the
+// language ID for string descriptors, 0 otherwise.
+func (u *Device) GetDescriptor(kind uint8, index uint8, lang uint16, buf []byte) (int, error) {
+	return u.ControlTransfer(REQTYPE_IN, REQ_GET_DESCRIPTOR, uint16(kind)<<8|uint16(index), lang,
+		uint16(len(buf)), 1000, buf)
+}
+
+// GetString reads string descriptor index in the device's first language.
+func (u *Device) GetString(index uint8) (string, error) {
+	if index == 0 {
+		return "", nil
+	}
+	b := make([]byte, 255)
+	n, e := u.GetDescriptor(DT_STRING, 0, 0, b)
+	if e != nil {
+		return "", e
+	}
+	if n < 4 {
+		return "", syscall.EPROTO
+	}
+	lang := uint16(b[2]) | uint16(b[3])<<8
+	if n, e = u.GetDescriptor(DT_STRING, index, lang, b); e != nil {
+		return "", e
+	}
+	if n < 2 || b[1] != DT_STRING {
+		return "", syscall.EPROTO
+	}
+	n = min(n, int(b[0]))
+	s := make([]uint16, 0, n/2)
+	for i := 2; i+1 < n; i += 2 {
+		s = append(s, uint16(b[i])|uint16(b[i+1])<<8)
+	}
+	return string(utf16.Decode(s)), nil
+}
+
+// ConfigDescriptor reads configuration index (counting from 0, not the
+// bConfigurationValue) from the device, so that configurations other than
+// the active one can be inspected before SetConfiguration.
+func (u *Device) ConfigDescriptor(index uint8) (*ConfigInfo, error) {
+	return u.readConfig(DT_CONFIG, index)
+}
+
+// OtherSpeedConfig reads configuration index as it would be at the speed
+// the device isn't running at: full speed for a device on a high-speed
+// port and vice versa.  Its DescriptorType is DT_OTHER_SPEED_CONFIG.
+// Devices that only do full speed stall the request.
+func (u *Device) OtherSpeedConfig(index uint8) (*ConfigInfo, error) {
+	return u.readConfig(DT_OTHER_SPEED_CONFIG, index)
+}
+
+func (u *Device) readConfig(kind uint8, index uint8) (*ConfigInfo, error) {
+	h := make([]byte, DT_CONFIG_SIZE)
+	n, e := u.GetDescriptor(kind, index, 0, h)
+	if e != nil {
+		return nil, e
+	}
+	ci := &ConfigInfo{}
+	if parseConfigKind(h[:n], kind, &ci.ConfigDescriptor) == nil {
+		return nil, syscall.EPROTO
+	}
+	// now that wTotalLength is known, read the lot
+	b := make([]byte, ci.TotalLength)
+	if n, e = u.GetDescriptor(kind, index, 0, b); e != nil {
+		return nil, e
+	}
+	d := parseConfigKind(b[:n], kind, &ci.ConfigDescriptor)
+	if d == nil || parseConfig(d, ci) == nil {
+		return nil, syscall.EPROTO
+	}
+	return ci, nil
+}
+
+// DeviceQualifier reads the device qualifier of a high-speed capable
+// device.  Full-speed only devices stall the request.
+func (u *Device) DeviceQualifier() (*DeviceQualifier, error) {
+	b := make([]byte, DT_DEVICE_QUALIFIER_SIZE)
+	n, e := u.GetDescriptor(DT_DEVICE_QUALIFIER, 0, 0, b)
+	if e != nil {
+		return nil, e
+	}
+	dq := &DeviceQualifier{}
+	if parseQualifierDesc(b[:n], dq) == nil {
+		return nil, syscall.EPROTO
+	}
+	return dq, nil
+}
+
+// DeviceDescriptor reads the device descriptor from the device.
+func (u *Device) DeviceDescriptor() (*DeviceDescriptor, error) {
+	b := make([]byte, DT_DEVICE_SIZE)
+	n, e := u.GetDescriptor(DT_DEVICE, 0, 0, b)
+	if e != nil {
+		return nil, e
+	}
+	dd := &DeviceDescriptor{}
+	if parseDeviceDesc(b[:n], dd) == nil {
+		return nil, syscall.EPROTO
+	}
+	return dd, nil
+}
+
+// ConfigDescriptors reads every configuration the device offers.
+func (u *Device) ConfigDescriptors() ([]*ConfigInfo, error) {
+	dd, e := u.DeviceDescriptor()
+	if e != nil {
+		return nil, e
+	}
+	var list []*ConfigInfo
+	for i := 0; i < int(dd.NumConfigurations); i++ {
+		ci, e := u.ConfigDescriptor(uint8(i))
+		if e != nil {
+			return list, e
+		}
+		list = append(list, ci)
+	}
+	return list, nil
+}
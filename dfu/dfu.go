@@ -0,0 +1,360 @@
+// Package dfu implements the USB Device Firmware Upgrade class (DFU 1.1)
+// and ST's DfuSe extensions.
+package dfu
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"syscall"
+	"time"
+
+	"github.com/richardnwinder/usb"
+	"github.com/richardnwinder/usb/firmware"
+)
+
+const (
+	CLASS_APP_SPECIFIC = 0xfe
+	SUBCLASS_DFU       = 0x01
+	PROTOCOL_RUNTIME   = 0x01
+	PROTOCOL_DFU_MODE  = 0x02
+
+	DT_DFU_FUNCTIONAL = 0x21
+
+	// requests
+	DFU_DETACH    = 0
+	DFU_DNLOAD    = 1
+	DFU_UPLOAD    = 2
+	DFU_GETSTATUS = 3
+	DFU_CLRSTATUS = 4
+	DFU_GETSTATE  = 5
+	DFU_ABORT     = 6
+
+	// functional descriptor bmAttributes
+	ATTR_CAN_DNLOAD             = 0x01
+	ATTR_CAN_UPLOAD             = 0x02
+	ATTR_MANIFESTATION_TOLERANT = 0x04
+	ATTR_WILL_DETACH            = 0x08
+
+	// DfuSe commands, sent as block 0 downloads
+	DFUSE_SET_ADDRESS = 0x21
+	DFUSE_ERASE       = 0x41
+
+	reqOut = 0x21 // class, interface, host to device
+	reqIn  = 0xa1
+)
+
+// bState values
+const (
+	STATE_APP_IDLE = iota
+	STATE_APP_DETACH
+	STATE_IDLE
+	STATE_DNLOAD_SYNC
+	STATE_DNBUSY
+	STATE_DNLOAD_IDLE
+	STATE_MANIFEST_SYNC
+	STATE_MANIFEST
+	STATE_MANIFEST_WAIT_RESET
+	STATE_UPLOAD_IDLE
+	STATE_ERROR
+)
+
+type Status struct {
+	Status      uint8 // bStatus, 0 == OK
+	PollTimeout time.Duration
+	State       uint8
+	String      uint8 // iString describing the status
+}
+
+// StatusError is a non-OK bStatus.
+type StatusError uint8
+
+var statusNames = []string{"OK", "errTARGET", "errFILE", "errWRITE", "errERASE",
+	"errCHECK_ERASED", "errPROG", "errVERIFY", "errADDRESS", "errNOTDONE",
+	"errFIRMWARE", "errVENDOR", "errUSBR", "errPOR", "errUNKNOWN", "errSTALLEDPKT"}
+
+func (e StatusError) Error() string {
+	if int(e) < len(statusNames) {
+		return "dfu: " + statusNames[e]
+	}
+	return fmt.Sprintf("dfu: status 0x%02x", uint8(e))
+}
+
+var (
+	ErrNotDFU       = errors.New("dfu: not a DFU interface")
+	ErrTransferSize = errors.New("dfu: functional descriptor gives a wTransferSize of 0")
+)
+
+type Device struct {
+	dev           usb.Conn
+	ifc           uint8
+	Mode          uint8 // PROTOCOL_RUNTIME or PROTOCOL_DFU_MODE
+	Attributes    uint8
+	DetachTimeout uint16 // ms
+	TransferSize  uint16
+	Version       uint16 // bcdDFUVersion, 0x011a for DfuSe
+	Timeout       uint32 // per request, ms
+}
+
+// Open claims the DFU interface ii and reads its functional descriptor.
+func Open(dev usb.Conn, ii *usb.InterfaceInfo) (*Device, error) {
+	if ii.InterfaceClass != CLASS_APP_SPECIFIC || ii.InterfaceSubClass != SUBCLASS_DFU {
+		return nil, ErrNotDFU
+	}
+	d := &Device{dev: dev, ifc: ii.InterfaceNumber, Mode: ii.InterfaceProtocol,
+		TransferSize: 64, Version: 0x0100, Timeout: 5000}
+	for x := ii.Extra; len(x) >= 2 && int(x[0]) <= len(x) && x[0] >= 2; x = x[x[0]:] {
+		if x[1] == DT_DFU_FUNCTIONAL && x[0] >= 7 {
+			d.Attributes = x[2]
+			d.DetachTimeout = uint16(x[3]) | uint16(x[4])<<8
+			d.TransferSize = uint16(x[5]) | uint16(x[6])<<8
+			if x[0] >= 9 {
+				d.Version = uint16(x[7]) | uint16(x[8])<<8
+			}
+		}
+	}
+	// a download would never move a byte
+	if d.TransferSize == 0 {
+		return nil, ErrTransferSize
+	}
+	if e := dev.ClaimInterface(uint32(d.ifc)); e != nil {
+		return nil, e
+	}
+	return d, nil
+}
+
+func (d *Device) Close() error {
+	return d.dev.ReleaseInterface(uint32(d.ifc))
+}
+
+// DfuSe reports whether the device speaks ST's DfuSe dialect.
+func (d *Device) DfuSe() bool {
+	return d.Version == 0x011a
+}
+
+func (d *Device) GetStatus() (Status, error) {
+	b := make([]byte, 6)
+	n, e := d.dev.ControlTransfer(reqIn, DFU_GETSTATUS, 0, uint16(d.ifc), 6, d.Timeout, b)
+	if e != nil {
+		return Status{}, e
+	}
+	if n < 6 {
+		return Status{}, syscall.EPROTO
+	}
+	ms := uint32(b[1]) | uint32(b[2])<<8 | uint32(b[3])<<16
+	return Status{b[0], time.Duration(ms) * time.Millisecond, b[4], b[5]}, nil
+}
+
+func (d *Device) ClearStatus() error {
+	_, e := d.dev.ControlTransfer(reqOut, DFU_CLRSTATUS, 0, uint16(d.ifc), 0, d.Timeout, nil)
+	return e
+}
+
+func (d *Device) Abort() error {
+	_, e := d.dev.ControlTransfer(reqOut, DFU_ABORT, 0, uint16(d.ifc), 0, d.Timeout, nil)
+	return e
+}
+
+// Detach asks a runtime-mode device to switch to its bootloader.  Devices
+// without ATTR_WILL_DETACH need a bus reset afterwards.
+func (d *Device) Detach() error {
+	_, e := d.dev.ControlTransfer(reqOut, DFU_DETACH, d.DetachTimeout, uint16(d.ifc), 0, d.Timeout, nil)
+	return e
+}
+
+// wait polls GETSTATUS until the device leaves the busy states
+func (d *Device) wait() (Status, error) {
+	for {
+		st, e := d.GetStatus()
+		if e != nil {
+			return st, e
+		}
+		if st.Status != 0 {
+			d.ClearStatus()
+			return st, StatusError(st.Status)
+		}
+		if st.State != STATE_DNBUSY && st.State != STATE_MANIFEST {
+			return st, nil
+		}
+		time.Sleep(st.PollTimeout)
+	}
+}
+
+func (d *Device) dnload(block uint16, data []byte) (Status, error) {
+	_, e := d.dev.ControlTransfer(reqOut, DFU_DNLOAD, block, uint16(d.ifc), uint16(len(data)), d.Timeout, data)
+	if e != nil {
+		return Status{}, e
+	}
+	return d.wait()
+}
+
+// idle gets the device back to dfuIDLE from an error or a previous
+// aborted transfer
+func (d *Device) idle() error {
+	st, e := d.GetStatus()
+	if e != nil {
+		return e
+	}
+	switch st.State {
+	case STATE_IDLE:
+		return nil
+	case STATE_ERROR:
+		e = d.ClearStatus()
+	default:
+		e = d.Abort()
+	}
+	if e != nil {
+		return e
+	}
+	st, e = d.GetStatus()
+	if e == nil && st.State != STATE_IDLE {
+		e = syscall.EPROTO
+	}
+	return e
+}
+
+// dfuseCommand issues a DfuSe special command with a 32-bit address
+func (d *Device) dfuseCommand(cmd uint8, addr uint32) error {
+	b := []byte{cmd, byte(addr), byte(addr >> 8), byte(addr >> 16), byte(addr >> 24)}
+	if _, e := d.dnload(0, b); e != nil {
+		return e
+	}
+	// DfuSe leaves the device in dnIDLE after a command; abort back to
+	// idle so the next command or download starts cleanly
+	return d.idle()
+}
+
+// Erase erases the DfuSe flash page containing addr.
+func (d *Device) Erase(addr uint32) error {
+	if !d.DfuSe() {
+		return syscall.EOPNOTSUPP
+	}
+	return d.dfuseCommand(DFUSE_ERASE, addr)
+}
+
+// Download writes size bytes (or -1 if unknown) of image from r.  Plain DFU
+// always starts from the beginning of the image, so only DfuSe devices can
+// honour opts.Resume; for them opts.Address is the load address.  DfuSe
+// only programs flash that is already erased, and Download doesn't erase
+// it, so the caller must Erase each page the image covers first.  Unless
+// the device is manifestation tolerant it has gone from the bus, or waits
+// for a reset, once Download returns.
+func (d *Device) Download(r io.Reader, size int64, opts *firmware.Options) error {
+	if d.Attributes&ATTR_CAN_DNLOAD == 0 {
+		return syscall.EOPNOTSUPP
+	}
+	var done int64
+	if opts != nil && opts.Resume > 0 && !d.DfuSe() {
+		return firmware.ErrNoResume
+	}
+	if e := d.idle(); e != nil {
+		return e
+	}
+	if e := opts.Skip(r); e != nil {
+		return e
+	}
+	// DfuSe numbers blocks from 2, addressing Address + (block-2)*size
+	block := uint16(0)
+	if d.DfuSe() {
+		var addr uint32
+		if opts != nil {
+			addr = opts.Address
+			done = opts.Resume
+		}
+		if e := d.dfuseCommand(DFUSE_SET_ADDRESS, addr+uint32(done)); e != nil {
+			return e
+		}
+		block = 2
+	}
+	opts.Report(firmware.STAGE_DOWNLOAD, done, size)
+	buf := make([]byte, d.TransferSize)
+	for {
+		n, re := io.ReadFull(r, buf)
+		if n > 0 {
+			if _, e := d.dnload(block, buf[:n]); e != nil {
+				return e
+			}
+			block++
+			done += int64(n)
+			opts.Report(firmware.STAGE_DOWNLOAD, done, size)
+		}
+		if re == io.EOF || re == io.ErrUnexpectedEOF {
+			break
+		}
+		if re != nil {
+			return re
+		}
+	}
+	opts.Report(firmware.STAGE_MANIFEST, 0, 0)
+	if e := d.manifest(block); e != nil {
+		return e
+	}
+	opts.Report(firmware.STAGE_DONE, done, size)
+	return nil
+}
+
+// manifest sends the zero length download that starts manifestation.
+// Only a manifestation tolerant device comes back to dfuIDLE; others
+// wait for a reset in dfuMANIFEST-WAIT-RESET or drop off the bus, as a
+// DfuSe device leaving for its application does, so their status is read
+// once, to move them on from dfuMANIFEST-SYNC, and losing them then is
+// the download succeeding
+func (d *Device) manifest(block uint16) error {
+	_, e := d.dev.ControlTransfer(reqOut, DFU_DNLOAD, block, uint16(d.ifc), 0, d.Timeout, nil)
+	var st Status
+	switch {
+	case e != nil:
+	case d.Attributes&ATTR_MANIFESTATION_TOLERANT != 0:
+		if st, e = d.wait(); e == nil && st.State == STATE_MANIFEST_SYNC {
+			_, e = d.wait()
+		}
+	default:
+		if st, e = d.GetStatus(); e == nil && st.Status != 0 {
+			e = StatusError(st.Status)
+		}
+	}
+	if errors.Is(e, syscall.ENODEV) || errors.Is(e, syscall.EPIPE) {
+		return nil
+	}
+	return e
+}
+
+// Upload reads the device's image into w.
+func (d *Device) Upload(w io.Writer, opts *firmware.Options) error {
+	if d.Attributes&ATTR_CAN_UPLOAD == 0 {
+		return syscall.EOPNOTSUPP
+	}
+	if e := d.idle(); e != nil {
+		return e
+	}
+	block := uint16(0)
+	if d.DfuSe() {
+		var addr uint32
+		if opts != nil {
+			addr = opts.Address
+		}
+		if e := d.dfuseCommand(DFUSE_SET_ADDRESS, addr); e != nil {
+			return e
+		}
+		block = 2
+	}
+	var done int64
+	buf := make([]byte, d.TransferSize)
+	for {
+		n, e := d.dev.ControlTransfer(reqIn, DFU_UPLOAD, block, uint16(d.ifc), d.TransferSize, d.Timeout, buf)
+		if e != nil {
+			return e
+		}
+		if _, e := w.Write(buf[:n]); e != nil {
+			d.Abort()
+			return e
+		}
+		block++
+		done += int64(n)
+		opts.Report(firmware.STAGE_VERIFY, done, -1)
+		// a short block ends the upload
+		if n < int(d.TransferSize) {
+			return nil
+		}
+	}
+}
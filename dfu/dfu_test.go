@@ -0,0 +1,155 @@
+package dfu_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/richardnwinder/usb/dfu"
+	"github.com/richardnwinder/usb/usbtest"
+)
+
+// fakeDFU is a DFU-mode device's state machine, enough of it for a
+// download: blocks go through dfuDNLOAD-SYNC to dfuDNLOAD-IDLE, and the
+// zero length download through dfuMANIFEST-SYNC and dfuMANIFEST to either
+// dfuIDLE or, when not manifestation tolerant, off the bus
+type fakeDFU struct {
+	lock     sync.Mutex
+	tolerant bool
+	leave    bool  // gone as soon as manifestation starts, as DfuSe leaving is
+	fail     uint8 // bStatus on entering dfuMANIFEST
+	state    uint8
+	gone     bool
+	image    []byte
+	polls    int // GETSTATUS requests since the zero length download
+}
+
+func (f *fakeDFU) status(q *usbtest.Request) ([]byte, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.gone {
+		return nil, syscall.ENODEV
+	}
+	switch f.state {
+	case dfu.STATE_DNLOAD_SYNC:
+		f.state = dfu.STATE_DNLOAD_IDLE
+	case dfu.STATE_MANIFEST_SYNC:
+		f.polls++
+		f.state = dfu.STATE_MANIFEST
+		if f.fail != 0 {
+			f.state = dfu.STATE_ERROR
+			return []byte{f.fail, 0, 0, 0, f.state, 0}, nil
+		}
+	case dfu.STATE_MANIFEST:
+		f.polls++
+		if !f.tolerant {
+			// in dfuMANIFEST-WAIT-RESET devices usually stop answering
+			f.gone = true
+			return nil, syscall.EPIPE
+		}
+		f.state = dfu.STATE_IDLE
+	}
+	return []byte{0, 1, 0, 0, f.state, 0}, nil
+}
+
+func (f *fakeDFU) dnload(q *usbtest.Request) ([]byte, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.gone {
+		return nil, syscall.ENODEV
+	}
+	if len(q.Data) == 0 {
+		f.state = dfu.STATE_MANIFEST_SYNC
+		f.gone = f.leave
+		return nil, nil
+	}
+	f.image = append(f.image, q.Data...)
+	f.state = dfu.STATE_DNLOAD_SYNC
+	return nil, nil
+}
+
+// open makes a DFU-mode device with the given functional descriptor
+// bmAttributes and a wTransferSize of 64
+func open(t *testing.T, f *fakeDFU, attrs uint8) *dfu.Device {
+	fx, e := usbtest.ParseHex("dfu", fmt.Sprintf(`
+12 01 00 02 00 00 00 40  83 04 11 df 00 02 00 00 00 01
+09 02 1b 00 01 01 00 80 32
+09 04 00 00 00 fe 01 02 00
+09 21 %02x ff 00 40 00 10 01
+`, attrs))
+	if e != nil {
+		t.Fatal(e)
+	}
+	di, e := fx.Info()
+	if e != nil {
+		t.Fatal(e)
+	}
+	d := fx.Device()
+	f.state = dfu.STATE_IDLE
+	d.On(usbtest.Control(0xa1, dfu.DFU_GETSTATUS)).Do(f.status)
+	d.On(usbtest.Control(0x21, dfu.DFU_DNLOAD)).Do(f.dnload)
+	dev, e := dfu.Open(d, &di.Config[0].Interface[0])
+	if e != nil {
+		t.Fatal(e)
+	}
+	return dev
+}
+
+func TestDownload(t *testing.T) {
+	image := make([]byte, 150)
+	for i := range image {
+		image[i] = byte(i)
+	}
+	for _, c := range []struct {
+		name      string
+		tolerant  bool
+		leave     bool
+		wantPolls int
+	}{
+		// polled through dfuMANIFEST back to dfuIDLE
+		{"tolerant", true, false, 2},
+		// read once, then left alone to wait for its reset
+		{"not tolerant", false, false, 1},
+		{"gone", false, true, 0},
+	} {
+		f := &fakeDFU{tolerant: c.tolerant, leave: c.leave}
+		attrs := uint8(dfu.ATTR_CAN_DNLOAD)
+		if c.tolerant {
+			attrs |= dfu.ATTR_MANIFESTATION_TOLERANT
+		}
+		d := open(t, f, attrs)
+		if e := d.Download(bytes.NewReader(image), int64(len(image)), nil); e != nil {
+			t.Errorf("%s: %v", c.name, e)
+			continue
+		}
+		if !bytes.Equal(f.image, image) {
+			t.Errorf("%s: device got %d bytes, want the %d of the image", c.name, len(f.image), len(image))
+		}
+		if f.polls != c.wantPolls {
+			t.Errorf("%s: %d status requests during manifestation, want %d", c.name, f.polls, c.wantPolls)
+		}
+		if c.tolerant && f.state != dfu.STATE_IDLE {
+			t.Errorf("%s: left in state %d, want dfuIDLE", c.name, f.state)
+		}
+	}
+}
+
+// TestDownloadError checks that an error status during manifestation
+// still fails the download, even from a device that isn't tolerant
+func TestDownloadError(t *testing.T) {
+	for _, tolerant := range []bool{false, true} {
+		f := &fakeDFU{tolerant: tolerant, fail: 7}
+		attrs := uint8(dfu.ATTR_CAN_DNLOAD)
+		if tolerant {
+			attrs |= dfu.ATTR_MANIFESTATION_TOLERANT
+		}
+		d := open(t, f, attrs)
+		e := d.Download(bytes.NewReader([]byte{1, 2, 3}), 3, nil)
+		if !errors.Is(e, dfu.StatusError(7)) {
+			t.Errorf("tolerant %v: %v, want errVERIFY", tolerant, e)
+		}
+	}
+}
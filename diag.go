@@ -0,0 +1,223 @@
+package usb
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const USBFS_MEMORY_MB = "/sys/module/usbcore/parameters/usbfs_memory_mb"
+
+// Diagnosis is what Diagnose found out about access to a device.
+type Diagnosis struct {
+	Path         string
+	Exists       bool
+	Mode         os.FileMode
+	UID, GID     int
+	Major, Minor uint32
+	Readable     bool  // as access(2) sees it
+	Writable     bool  // as access(2) sees it
+	OpenErr      error // from opening the node read-write, nil if it opened
+
+	// Drivers maps each interface of the active configuration to the
+	// kernel driver bound to it, "" for none.  Open works regardless,
+	// but claiming a bound interface needs DisconnectDriver first.
+	Drivers map[uint8]string
+
+	// Cgroup is the cgroup version devices are controlled by, 0 if
+	// unknown.  Only version 1 can be read back: CgroupAllowed says
+	// whether devices.list grants read and write.  Version 2 uses BPF
+	// programs, which leave OpenErr as the only test.
+	Cgroup        int
+	CgroupAllowed bool
+
+	// UsbfsMemoryMB is usbcore's cap on memory for in-flight transfers
+	// across all devices, 0 for unlimited and -1 if unknown.
+	UsbfsMemoryMB int
+
+	Problems []string // findings worth acting on, in plain words
+}
+
+// Diagnose checks the things that commonly stop a device from being
+// opened or used: the device node, its permissions, kernel drivers on
+// its interfaces, the cgroup device controller in containers and the
+// usbfs memory limit.  It doesn't change anything.
+func Diagnose(di *DeviceInfo) *Diagnosis {
+	d := &Diagnosis{Path: di.devpath, Drivers: make(map[uint8]string), UsbfsMemoryMB: -1}
+	var st syscall.Stat_t
+	if e := syscall.Stat(d.Path, &st); e != nil {
+		d.problem("%s: %v; in a container, /dev/bus/usb has to be mounted or the device passed in", d.Path, e)
+		d.OpenErr = e
+	} else {
+		d.Exists = true
+		d.Mode = os.FileMode(st.Mode & 0777)
+		d.UID, d.GID = int(st.Uid), int(st.Gid)
+		rdev := uint64(st.Rdev)
+		d.Major = uint32((rdev>>8)&0xfff | (rdev>>32)&^0xfff)
+		d.Minor = uint32(rdev&0xff | (rdev>>12)&^0xff)
+		d.Readable = syscall.Access(d.Path, 4) == nil
+		d.Writable = syscall.Access(d.Path, 2) == nil
+		fd, e := syscall.Open(d.Path, os.O_RDWR|syscall.O_CLOEXEC, 0)
+		if e == nil {
+			syscall.Close(fd)
+		}
+		d.OpenErr = e
+		if !d.Writable {
+			d.problem("%s is mode %v owned by %d:%d and not writable by uid %d; add a udev rule or join the group",
+				d.Path, d.Mode, d.UID, d.GID, os.Getuid())
+		}
+	}
+	d.cgroup()
+	if d.Cgroup == 1 && d.Exists && !d.CgroupAllowed {
+		d.problem("the devices cgroup doesn't allow c %d:%d rw; run the container with --device or a device cgroup rule",
+			d.Major, d.Minor)
+	}
+	if errors.Is(d.OpenErr, syscall.EPERM) && d.Writable {
+		d.problem("opening %s is refused despite its permissions, which points at a cgroup or security module", d.Path)
+	}
+	d.drivers(di)
+	if s, e := os.ReadFile(USBFS_MEMORY_MB); e == nil {
+		d.UsbfsMemoryMB = atou(s)
+		if d.UsbfsMemoryMB != 0 && d.UsbfsMemoryMB < 64 {
+			d.problem("usbfs_memory_mb is %d, which large or many queued transfers will run into", d.UsbfsMemoryMB)
+		}
+	}
+	return d
+}
+
+func (d *Diagnosis) problem(format string, args ...any) {
+	d.Problems = append(d.Problems, fmt.Sprintf(format, args...))
+}
+
+// drivers reads the driver links of the active configuration's
+// interfaces, which sysfs names <device>:<config>.<interface>
+func (d *Diagnosis) drivers(di *DeviceInfo) {
+	if di.syspath == "" {
+		return
+	}
+	s, e := os.ReadFile(di.syspath + "/bConfigurationValue")
+	if e != nil {
+		return
+	}
+	config := atou(s)
+	for _, ci := range di.Config {
+		if int(ci.ConfigurationValue) != config {
+			continue
+		}
+		for _, ii := range ci.Interface {
+			if ii.AlternateSetting != 0 {
+				continue
+			}
+			dir := fmt.Sprintf("%s:%d.%d", di.syspath, config, ii.InterfaceNumber)
+			name := ""
+			if l, e := os.Readlink(dir + "/driver"); e == nil {
+				name = filepath.Base(l)
+			}
+			d.Drivers[ii.InterfaceNumber] = name
+			// usbfs itself shows up as the driver of claimed interfaces
+			if name != "" && name != "usbfs" {
+				d.problem("interface %d is bound to %s; DisconnectDriver before claiming it", ii.InterfaceNumber, name)
+			}
+		}
+	}
+}
+
+// cgroup works out which device controller applies to this process and,
+// for version 1, whether it lets the device through
+func (d *Diagnosis) cgroup() {
+	f, e := os.Open("/proc/self/cgroup")
+	if e != nil {
+		return
+	}
+	defer f.Close()
+	path := ""
+	v1 := false
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		// hierarchy-ID:controllers:path
+		parts := strings.SplitN(s.Text(), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		for _, c := range strings.Split(parts[1], ",") {
+			if c == "devices" {
+				v1 = true
+				path = parts[2]
+			}
+		}
+	}
+	if !v1 {
+		if _, e := os.Stat("/sys/fs/cgroup/cgroup.controllers"); e == nil {
+			d.Cgroup = 2
+		}
+		return
+	}
+	d.Cgroup = 1
+	// inside a cgroup namespace our own group is the root
+	list, e := os.ReadFile("/sys/fs/cgroup/devices" + path + "/devices.list")
+	if e != nil {
+		list, e = os.ReadFile("/sys/fs/cgroup/devices/devices.list")
+	}
+	if e != nil {
+		// without the list there's nothing to say either way
+		d.CgroupAllowed = true
+		return
+	}
+	for _, rule := range strings.Split(string(list), "\n") {
+		// type major:minor access, as in "c 189:* rwm"
+		f := strings.Fields(rule)
+		if len(f) != 3 || (f[0] != "a" && f[0] != "c") {
+			continue
+		}
+		if f[0] == "a" {
+			d.CgroupAllowed = d.CgroupAllowed || strings.Contains(f[2], "rw")
+			continue
+		}
+		maj, minor, _ := strings.Cut(f[1], ":")
+		if !devMatch(maj, d.Major) || !devMatch(minor, d.Minor) {
+			continue
+		}
+		if strings.Contains(f[2], "r") && strings.Contains(f[2], "w") {
+			d.CgroupAllowed = true
+		}
+	}
+}
+
+func devMatch(s string, n uint32) bool {
+	if s == "*" {
+		return true
+	}
+	v, e := strconv.ParseUint(s, 10, 32)
+	return e == nil && uint32(v) == n
+}
+
+func (d *Diagnosis) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: ", d.Path)
+	if d.Exists {
+		fmt.Fprintf(&b, "c %d:%d %v %d:%d", d.Major, d.Minor, d.Mode, d.UID, d.GID)
+	} else {
+		b.WriteString("missing")
+	}
+	if d.OpenErr != nil {
+		fmt.Fprintf(&b, ", open: %v", d.OpenErr)
+	} else {
+		b.WriteString(", opens")
+	}
+	if d.Cgroup != 0 {
+		fmt.Fprintf(&b, ", cgroup v%d", d.Cgroup)
+	}
+	if d.UsbfsMemoryMB >= 0 {
+		fmt.Fprintf(&b, ", usbfs_memory_mb %d", d.UsbfsMemoryMB)
+	}
+	b.WriteByte('\n')
+	for _, p := range d.Problems {
+		fmt.Fprintf(&b, "  %s\n", p)
+	}
+	return b.String()
+}
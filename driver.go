@@ -0,0 +1,158 @@
+package usb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// The kernel's driver binding is controlled through sysfs rather than
+// usbfs: a driver's bind and unbind files take the sysfs name of a device
+// or interface, and authorized files say whether drivers may bind at all.
+// Unlike DisconnectDriver, these work without opening the device, and on
+// one interface of a composite device without touching the others.  They
+// need write access to sysfs, which usually means root.
+
+// drivers is the directory of the bus's drivers, the sibling of the
+// devices directory
+func (r Root) drivers() string {
+	return filepath.Dir(strings.TrimSuffix(r.sysfs(), "/")) + "/drivers/"
+}
+
+// InterfaceName is the sysfs name of an interface of the active
+// configuration, <device>:<config>.<interface>, as driver files take it.
+func (di *DeviceInfo) InterfaceName(ifc uint8) (string, error) {
+	if di.syspath == "" {
+		return "", syscall.ENODEV
+	}
+	s, e := os.ReadFile(di.syspath + "/bConfigurationValue")
+	if e != nil {
+		return "", e
+	}
+	config := atou(s)
+	if config == 0 {
+		// unconfigured, so there are no interfaces
+		return "", syscall.ENODEV
+	}
+	return fmt.Sprintf("%s:%d.%d", filepath.Base(di.syspath), config, ifc), nil
+}
+
+// InterfaceDriver is the name of the kernel driver bound to an interface,
+// read from sysfs, or "" if none is.
+func (di *DeviceInfo) InterfaceDriver(ifc uint8) (string, error) {
+	name, e := di.InterfaceName(ifc)
+	if e != nil {
+		return "", e
+	}
+	return boundDriver(di.root.sysfs() + name)
+}
+
+func boundDriver(dir string) (string, error) {
+	l, e := os.Readlink(dir + "/driver")
+	if os.IsNotExist(e) {
+		if _, e := os.Stat(dir); e != nil {
+			return "", e
+		}
+		return "", nil
+	}
+	if e != nil {
+		return "", e
+	}
+	return filepath.Base(l), nil
+}
+
+// UnbindInterface detaches whatever driver is bound to an interface.
+// The kernel may bind a driver again when the device is reset or
+// replugged; AuthorizeInterface prevents that.
+func (di *DeviceInfo) UnbindInterface(ifc uint8) error {
+	name, e := di.InterfaceName(ifc)
+	if e != nil {
+		return e
+	}
+	return di.root.unbind(name)
+}
+
+// BindInterface binds driver to an interface, which must have none.  The
+// driver decides whether to take it.
+func (di *DeviceInfo) BindInterface(ifc uint8, driver string) error {
+	name, e := di.InterfaceName(ifc)
+	if e != nil {
+		return e
+	}
+	return di.root.bind(name, driver)
+}
+
+// Unbind detaches the device from its device driver, normally "usb",
+// which unbinds every interface along with it.
+func (di *DeviceInfo) Unbind() error {
+	return di.root.unbind(filepath.Base(di.syspath))
+}
+
+// Bind binds driver to the device, such as "usb" to have it configured
+// and its interfaces probed again.
+func (di *DeviceInfo) Bind(driver string) error {
+	return di.root.bind(filepath.Base(di.syspath), driver)
+}
+
+func (r Root) unbind(name string) error {
+	driver, e := boundDriver(r.sysfs() + name)
+	if e != nil {
+		return e
+	}
+	if driver == "" {
+		return nil
+	}
+	return writeAttr(r.drivers()+driver+"/unbind", name)
+}
+
+func (r Root) bind(name string, driver string) error {
+	if driver == "" || strings.ContainsRune(driver, '/') {
+		return syscall.EINVAL
+	}
+	return writeAttr(r.drivers()+driver+"/bind", name)
+}
+
+// AuthorizeInterface allows or forbids drivers binding to an interface.
+// Forbidding it unbinds the driver it has, and keeps any from binding
+// until it is allowed again, but usbfs can still claim it.
+func (di *DeviceInfo) AuthorizeInterface(ifc uint8, allow bool) error {
+	name, e := di.InterfaceName(ifc)
+	if e != nil {
+		return e
+	}
+	return writeAttr(di.root.sysfs()+name+"/authorized", authorized(allow))
+}
+
+// AuthorizeInterfacesByDefault sets whether the interfaces of
+// configurations the device changes to later start out authorized, so
+// that after SetConfiguration no driver gets the chance to bind before
+// AuthorizeInterface allows it.
+func (di *DeviceInfo) AuthorizeInterfacesByDefault(allow bool) error {
+	if di.syspath == "" {
+		return syscall.ENODEV
+	}
+	return writeAttr(di.syspath+"/interface_authorized_default", authorized(allow))
+}
+
+func authorized(allow bool) string {
+	if allow {
+		return "1"
+	}
+	return "0"
+}
+
+// writeAttr writes a sysfs attribute, which takes its value in a single
+// write
+func writeAttr(path string, value string) error {
+	f, e := os.OpenFile(path, os.O_WRONLY, 0)
+	if e != nil {
+		return e
+	}
+	_, e = f.WriteString(value)
+	if e2 := f.Close(); e == nil {
+		e = e2
+	}
+	return e
+}
@@ -0,0 +1,155 @@
+package usb_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/richardnwinder/usb"
+	"github.com/richardnwinder/usb/usbtest"
+)
+
+// fakeSysfs builds the part of /sys/bus/usb the driver helpers use: a
+// device 1-2 bound to usb, its interface 1-2:1.0 bound to usbhid, and
+// the two drivers' bind and unbind files.  It returns the bus directory
+// and the device as listed from it.
+func fakeSysfs(t *testing.T) (string, *usb.DeviceInfo) {
+	f, e := usbtest.ParseHex("test", `
+12 01 00 02 00 00 00 40  34 12 78 56 00 01 00 00 00 01
+09 02 19 00 01 01 00 80 32
+09 04 00 00 01 03 00 00 00
+07 05 81 03 08 00 0a
+`)
+	if e != nil {
+		t.Fatal(e)
+	}
+	bus := t.TempDir()
+	files := map[string]string{
+		"devices/1-2/busnum":                       "1\n",
+		"devices/1-2/devnum":                       "5\n",
+		"devices/1-2/descriptors":                  string(f.Descriptors),
+		"devices/1-2/bConfigurationValue":          "1\n",
+		"devices/1-2/interface_authorized_default": "",
+		"devices/1-2:1.0/authorized":               "",
+		"drivers/usb/bind":                         "",
+		"drivers/usb/unbind":                       "",
+		"drivers/usbhid/bind":                      "",
+		"drivers/usbhid/unbind":                    "",
+	}
+	for name, v := range files {
+		path := filepath.Join(bus, name)
+		if e := os.MkdirAll(filepath.Dir(path), 0755); e != nil {
+			t.Fatal(e)
+		}
+		if e := os.WriteFile(path, []byte(v), 0644); e != nil {
+			t.Fatal(e)
+		}
+	}
+	for dev, driver := range map[string]string{"1-2": "usb", "1-2:1.0": "usbhid"} {
+		if e := os.Symlink("../../drivers/"+driver, filepath.Join(bus, "devices", dev, "driver")); e != nil {
+			t.Fatal(e)
+		}
+	}
+	devs, e := usb.Root{Sysfs: filepath.Join(bus, "devices")}.ListDevices(context.Background())
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(devs) != 1 {
+		t.Fatalf("listed %d devices, want 1", len(devs))
+	}
+	return bus, devs[0]
+}
+
+// written checks what was written to a file under the fake bus
+func written(t *testing.T, bus string, name string, want string) {
+	t.Helper()
+	b, e := os.ReadFile(filepath.Join(bus, name))
+	if e != nil {
+		t.Fatal(e)
+	}
+	if string(b) != want {
+		t.Errorf("%s holds %q, want %q", name, b, want)
+	}
+}
+
+func TestInterfaceDriver(t *testing.T) {
+	_, di := fakeSysfs(t)
+	if name, e := di.InterfaceName(0); e != nil || name != "1-2:1.0" {
+		t.Errorf("InterfaceName(0) = %q, %v", name, e)
+	}
+	if driver, e := di.InterfaceDriver(0); e != nil || driver != "usbhid" {
+		t.Errorf("InterfaceDriver(0) = %q, %v", driver, e)
+	}
+	if _, e := di.InterfaceDriver(1); !errors.Is(e, os.ErrNotExist) {
+		t.Errorf("InterfaceDriver of a missing interface: %v", e)
+	}
+}
+
+func TestBindUnbind(t *testing.T) {
+	bus, di := fakeSysfs(t)
+	if e := di.UnbindInterface(0); e != nil {
+		t.Fatal(e)
+	}
+	written(t, bus, "drivers/usbhid/unbind", "1-2:1.0")
+	if e := di.BindInterface(0, "usbhid"); e != nil {
+		t.Fatal(e)
+	}
+	written(t, bus, "drivers/usbhid/bind", "1-2:1.0")
+	for _, driver := range []string{"", "../usb"} {
+		if e := di.BindInterface(0, driver); e != syscall.EINVAL {
+			t.Errorf("BindInterface(0, %q) gave %v, want EINVAL", driver, e)
+		}
+	}
+	if e := di.Unbind(); e != nil {
+		t.Fatal(e)
+	}
+	written(t, bus, "drivers/usb/unbind", "1-2")
+	if e := di.Bind("usb"); e != nil {
+		t.Fatal(e)
+	}
+	written(t, bus, "drivers/usb/bind", "1-2")
+}
+
+// TestUnbindUnbound checks that unbinding an interface with no driver
+// writes nothing
+func TestUnbindUnbound(t *testing.T) {
+	bus, di := fakeSysfs(t)
+	if e := os.Remove(filepath.Join(bus, "devices/1-2:1.0/driver")); e != nil {
+		t.Fatal(e)
+	}
+	if driver, e := di.InterfaceDriver(0); e != nil || driver != "" {
+		t.Errorf("InterfaceDriver(0) of an unbound interface = %q, %v", driver, e)
+	}
+	if e := di.UnbindInterface(0); e != nil {
+		t.Fatal(e)
+	}
+	written(t, bus, "drivers/usbhid/unbind", "")
+}
+
+func TestAuthorize(t *testing.T) {
+	bus, di := fakeSysfs(t)
+	if e := di.AuthorizeInterface(0, false); e != nil {
+		t.Fatal(e)
+	}
+	written(t, bus, "devices/1-2:1.0/authorized", "0")
+	if e := di.AuthorizeInterfacesByDefault(true); e != nil {
+		t.Fatal(e)
+	}
+	written(t, bus, "devices/1-2/interface_authorized_default", "1")
+}
+
+func TestInterfaceNameUnconfigured(t *testing.T) {
+	bus, di := fakeSysfs(t)
+	if e := os.WriteFile(filepath.Join(bus, "devices/1-2/bConfigurationValue"), []byte("\n"), 0644); e != nil {
+		t.Fatal(e)
+	}
+	if _, e := di.InterfaceName(0); e != syscall.ENODEV {
+		t.Errorf("InterfaceName of an unconfigured device gave %v, want ENODEV", e)
+	}
+	if e := new(usb.DeviceInfo).AuthorizeInterfacesByDefault(true); e != syscall.ENODEV {
+		t.Errorf("a DeviceInfo not from sysfs gave %v, want ENODEV", e)
+	}
+}
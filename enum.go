@@ -0,0 +1,130 @@
+package usb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// ListError reports the devices ListDevicesContext found in sysfs but
+// couldn't read, each as an *os.PathError, and the context's error if
+// it ended the scan early.
+type ListError struct {
+	Errors []error
+}
+
+func (e *ListError) Error() string {
+	if len(e.Errors) == 1 {
+		return "usb: listing devices: " + e.Errors[0].Error()
+	}
+	return fmt.Sprintf("usb: listing devices: %d errors, the first: %v", len(e.Errors), e.Errors[0])
+}
+
+// Unwrap gives the individual errors to errors.Is and errors.As.
+func (e *ListError) Unwrap() []error {
+	return e.Errors
+}
+
+// listWorkers is how many devices ListDevicesContext reads at once.  A
+// test rack's hubs can hold over a hundred, which read one at a time
+// take seconds.
+const listWorkers = 16
+
+type listed struct {
+	di  *DeviceInfo
+	err error
+}
+
+// ListDevicesContext enumerates the attached devices from sysfs, as
+// ListDevices does, reading several devices at once.  It gives up when
+// ctx is done rather than waiting on a read a misbehaving device holds
+// up.  Along with the devices it read it returns a *ListError for those
+// it couldn't.  Devices unplugged during the scan are left out without
+// an error.  It looks in DefaultRoot.
+func ListDevicesContext(ctx context.Context) ([]*DeviceInfo, error) {
+	return DefaultRoot.ListDevices(ctx)
+}
+
+// ListDevices is ListDevicesContext for the devices under r.
+func (r Root) ListDevices(ctx context.Context) ([]*DeviceInfo, error) {
+	dir := r.sysfs()
+	fi, e := os.ReadDir(dir)
+	if e != nil {
+		return nil, e
+	}
+	var names []string
+	for _, f := range fi {
+		if strings.IndexByte(f.Name(), ':') == -1 {
+			names = append(names, f.Name())
+		}
+	}
+	res := make([]listed, len(names))
+	next := make(chan int)
+	done := make(chan int)
+	go func() {
+		defer close(next)
+		for i := range names {
+			select {
+			case next <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	// reads that block are left behind on their worker
+	for range min(len(names), listWorkers) {
+		go func() {
+			for i := range next {
+				res[i] = readListed(r, dir+names[i])
+				select {
+				case done <- i:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	got := make([]bool, len(names))
+	var err error
+	for n := 0; n < len(names) && err == nil; n++ {
+		select {
+		case i := <-done:
+			got[i] = true
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+	var list []*DeviceInfo
+	var errs []error
+	for i := range res {
+		// workers may still be writing the ones not got
+		if !got[i] {
+			continue
+		}
+		switch r := res[i]; {
+		case r.err == nil:
+			list = append(list, r.di)
+		case !errors.Is(r.err, fs.ErrNotExist):
+			errs = append(errs, r.err)
+		}
+	}
+	if err != nil {
+		errs = append(errs, err)
+	}
+	if errs != nil {
+		return list, &ListError{errs}
+	}
+	return list, nil
+}
+
+func readListed(r Root, dir string) listed {
+	di, e := readDeviceInfo(r, dir)
+	var pe *fs.PathError
+	if e != nil && !errors.As(e, &pe) {
+		e = &fs.PathError{Op: "read", Path: dir, Err: e}
+	}
+	return listed{di, e}
+}
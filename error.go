@@ -0,0 +1,69 @@
+package usb
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Error is what Device's operations return when usbfs fails them: the
+// errno, and what was being done, so that a log line from the field says
+// which transfer failed and how long it took.  errors.Is matches the
+// errno, so code that compares against syscall.ETIMEDOUT and the like
+// should use it rather than ==.
+type Error struct {
+	Op       string // as TapOp names it, or "control", "bulk", "submit", "open"
+	Bus      int
+	Device   int
+	Endpoint uint8 // with ENDPOINT_IN for reads; control transfers are endpoint 0
+	Length   int   // bytes asked for, or offered
+	Args     []int // of operations other than transfers, as TapOp has them
+	Elapsed  time.Duration
+	Err      error
+}
+
+func (e *Error) In() bool {
+	return e.Endpoint&ENDPOINT_IN != 0
+}
+
+func (e *Error) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "usb %03d/%03d: %s", e.Bus, e.Device, e.Op)
+	switch e.Op {
+	case "control", "bulk", "submit":
+		dir := "out"
+		if e.In() {
+			dir = "in"
+		}
+		fmt.Fprintf(&b, " %s ep 0x%02x, %d bytes", dir, e.Endpoint, e.Length)
+	default:
+		for _, a := range e.Args {
+			fmt.Fprintf(&b, " %d", a)
+		}
+	}
+	if e.Elapsed >= time.Millisecond {
+		fmt.Fprintf(&b, ", after %v", e.Elapsed.Round(time.Millisecond))
+	}
+	return b.String() + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// opError wraps the error of a device operation
+func (u *Device) opError(op string, start time.Time, e error, args ...int) error {
+	if e == nil {
+		return nil
+	}
+	return &Error{Op: op, Bus: u.bus, Device: u.devnum, Args: args, Elapsed: time.Since(start), Err: e}
+}
+
+// xferError wraps the error of a transfer
+func (u *Device) xferError(op string, start time.Time, ep uint8, length int, e error) error {
+	if e == nil {
+		return nil
+	}
+	return &Error{Op: op, Bus: u.bus, Device: u.devnum, Endpoint: ep, Length: length,
+		Elapsed: time.Since(start), Err: e}
+}
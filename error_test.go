@@ -0,0 +1,64 @@
+package usb_test
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/richardnwinder/usb"
+)
+
+func TestErrorString(t *testing.T) {
+	for _, c := range []struct {
+		e    *usb.Error
+		want string
+	}{
+		{&usb.Error{Op: "bulk", Bus: 1, Device: 5, Endpoint: 0x81, Length: 512, Elapsed: 1500 * time.Millisecond,
+			Err: syscall.ETIMEDOUT},
+			"usb 001/005: bulk in ep 0x81, 512 bytes, after 1.5s: connection timed out"},
+		{&usb.Error{Op: "control", Bus: 2, Device: 12, Length: 8, Elapsed: 12400 * time.Microsecond,
+			Err: syscall.EPIPE},
+			"usb 002/012: control out ep 0x00, 8 bytes, after 12ms: broken pipe"},
+		{&usb.Error{Op: "claim_port", Bus: 3, Device: 1, Args: []int{4}, Elapsed: 200 * time.Microsecond,
+			Err: syscall.EBUSY},
+			"usb 003/001: claim_port 4: device or resource busy"},
+		{&usb.Error{Op: "submit", Bus: 3, Device: 4, Endpoint: 0x02, Length: 16384,
+			Err: &usb.MemoryLimitError{Limit: 16 << 20, Held: 40960}},
+			"usb 003/004: submit out ep 0x02, 16384 bytes: usbfs memory limit of 16MB reached, 40960 bytes held by this device"},
+	} {
+		if got := c.e.Error(); got != c.want {
+			t.Errorf("got  %q\nwant %q", got, c.want)
+		}
+	}
+}
+
+func TestErrorIs(t *testing.T) {
+	var e error = &usb.Error{Op: "bulk", Endpoint: 0x81, Err: syscall.ETIMEDOUT}
+	if !errors.Is(e, syscall.ETIMEDOUT) || errors.Is(e, syscall.EPIPE) {
+		t.Errorf("errors.Is doesn't match the errno of %v", e)
+	}
+	e = &usb.Error{Op: "submit", Err: &usb.MemoryLimitError{}}
+	if !errors.Is(e, usb.ErrUsbfsMemoryLimit) || !errors.Is(e, syscall.ENOMEM) {
+		t.Errorf("errors.Is doesn't see the memory limit in %v", e)
+	}
+	var ml *usb.MemoryLimitError
+	if !errors.As(e, &ml) {
+		t.Errorf("errors.As doesn't find the MemoryLimitError in %v", e)
+	}
+}
+
+func TestMemoryLimitErrorString(t *testing.T) {
+	for _, c := range []struct {
+		e    usb.MemoryLimitError
+		want string
+	}{
+		{usb.MemoryLimitError{Held: 4096}, "usbfs out of memory, 4096 bytes held by this device"},
+		{usb.MemoryLimitError{Limit: 16 << 20, Held: 1 << 20},
+			"usbfs memory limit of 16MB reached, 1048576 bytes held by this device"},
+	} {
+		if got := c.e.Error(); got != c.want {
+			t.Errorf("got %q, want %q", got, c.want)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package usb
+
+import (
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// SyscallConn gives access to the usbfs file descriptor, following the
+// net package's conventions.  The fd stays open for as long as a
+// callback runs, so Close waits for callbacks rather than racing them.
+//
+// The library's reaper owns URB completion once SubmitTransfer has been
+// used: don't reap URBs on the fd, and don't submit URBs of your own,
+// since their completions would reach the reaper instead of you.  Any
+// other ioctl, and polling for POLLOUT (completions waiting) or POLLERR
+// (disconnect), is safe.
+func (u *Device) SyscallConn() (syscall.RawConn, error) {
+	if _, e := u.hold(); e != nil {
+		return nil, e
+	}
+	u.users.Done()
+	return rawConn{u}, nil
+}
+
+// RawIoctl issues a usbfs ioctl the package doesn't wrap yet, with the
+// fd held open against Close, and returns the ioctl's result.  It is as
+// unsafe as the pointer it's given: arg must point at memory laid out as
+// the kernel expects req's argument, and stay valid for the call.
+// Requests that submit, reap or discard URBs are refused with EINVAL,
+// since the reaper owns URB completion.
+func (u *Device) RawIoctl(req uintptr, arg unsafe.Pointer) (int, error) {
+	if req>>8&0xff == 'U' {
+		switch req & 0xff {
+		case USBDEVFS_SUBMITURB & 0xff, USBDEVFS_DISCARDURB & 0xff,
+			USBDEVFS_REAPURB & 0xff, USBDEVFS_REAPURBNDELAY & 0xff:
+			return 0, syscall.EINVAL
+		}
+	}
+	start := time.Now()
+	n, e := u.do(req, arg)
+	return n, u.opError("ioctl", start, e, int(req))
+}
+
+type rawConn struct {
+	u *Device
+}
+
+func (c rawConn) Control(f func(fd uintptr)) error {
+	fd, e := c.u.hold()
+	if e != nil {
+		return e
+	}
+	defer c.u.users.Done()
+	f(uintptr(fd))
+	return nil
+}
+
+// Read calls f until it returns true, waiting for the fd to become
+// readable in between.  usbfs is never readable, so in practice f is
+// retried every 100ms, or until a disconnect ends the wait with ENODEV.
+func (c rawConn) Read(f func(fd uintptr) bool) error {
+	return c.wait(POLLIN, f)
+}
+
+// Write waits for POLLOUT, which usbfs raises while completed URBs are
+// waiting to be reaped.
+func (c rawConn) Write(f func(fd uintptr) bool) error {
+	return c.wait(POLLOUT, f)
+}
+
+func (c rawConn) wait(events int16, f func(fd uintptr) bool) error {
+	for {
+		fd, e := c.u.hold()
+		if e != nil {
+			return e
+		}
+		if f(uintptr(fd)) {
+			c.u.users.Done()
+			return nil
+		}
+		// wake now and then to let Close through
+		fds := []pollfd{{fd: int32(fd), events: events}}
+		ts := syscall.NsecToTimespec(int64(100 * time.Millisecond))
+		_, _, en := syscall.Syscall6(syscall.SYS_PPOLL, uintptr(unsafe.Pointer(&fds[0])), 1,
+			uintptr(unsafe.Pointer(&ts)), 0, 0, 0)
+		c.u.users.Done()
+		if en != 0 && en != syscall.EINTR {
+			return en
+		}
+		if fds[0].revents&(POLLERR|POLLHUP) != 0 && fds[0].revents&events == 0 {
+			return syscall.ENODEV
+		}
+	}
+}
+
+// File returns a duplicate of the usbfs fd, to hand to a child process.
+// The duplicate shares everything with this Device: claimed interfaces,
+// the URB queue and the device's open count, which lasts until both are
+// closed.  A child using it is bound by the same rules as SyscallConn
+// callbacks for as long as this Device has a reaper.
+func (u *Device) File() (*os.File, error) {
+	fd, e := u.hold()
+	if e != nil {
+		return nil, e
+	}
+	defer u.users.Done()
+	nfd, _, en := syscall.Syscall(syscall.SYS_FCNTL, uintptr(fd), syscall.F_DUPFD_CLOEXEC, 0)
+	if en != 0 {
+		return nil, en
+	}
+	return os.NewFile(nfd, u.path), nil
+}
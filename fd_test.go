@@ -0,0 +1,28 @@
+package usb
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+)
+
+// TestRawIoctlRefused checks that the URB requests are refused whatever
+// their direction and size bits, before the fd is used: the device here
+// was never opened, so anything passed through fails with EBADF instead
+func TestRawIoctlRefused(t *testing.T) {
+	u := &Device{fd: -1}
+	for _, req := range []uintptr{
+		USBDEVFS_SUBMITURB, USBDEVFS_DISCARDURB, USBDEVFS_REAPURB, USBDEVFS_REAPURBNDELAY,
+		// the 32-bit layouts of the same requests
+		0x802c550a, 0x4004550c, 0x4004550d,
+	} {
+		if _, e := u.RawIoctl(req, nil); e != syscall.EINVAL {
+			t.Errorf("RawIoctl(%#x) gave %v, want EINVAL", req, e)
+		}
+	}
+	_, e := u.RawIoctl(USBDEVFS_CONNECTINFO, nil)
+	var ue *Error
+	if !errors.As(e, &ue) || ue.Op != "ioctl" || !errors.Is(e, syscall.EBADF) {
+		t.Errorf("RawIoctl(CONNECTINFO) on a closed device gave %v, want an ioctl Error with EBADF", e)
+	}
+}
@@ -0,0 +1,244 @@
+// Package fido implements the CTAP-HID transport used by FIDO U2F and
+// FIDO2 security keys, on top of package hid.
+package fido
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/richardnwinder/usb/hid"
+)
+
+// CTAPHID commands
+const (
+	CTAPHID_PING      = 0x01
+	CTAPHID_MSG       = 0x03
+	CTAPHID_LOCK      = 0x04
+	CTAPHID_INIT      = 0x06
+	CTAPHID_WINK      = 0x08
+	CTAPHID_CBOR      = 0x10
+	CTAPHID_CANCEL    = 0x11
+	CTAPHID_KEEPALIVE = 0x3b
+	CTAPHID_ERROR     = 0x3f
+
+	CID_BROADCAST = 0xffffffff
+
+	// capability flags from INIT
+	CAPABILITY_WINK = 0x01
+	CAPABILITY_CBOR = 0x04
+	CAPABILITY_NMSG = 0x08
+
+	// KEEPALIVE status
+	STATUS_PROCESSING = 1
+	STATUS_UPNEEDED   = 2
+
+	// payload capacity of the packets in a 64 byte report
+	initData = 64 - 7
+	contData = 64 - 5
+	maxMsg   = initData + 128*contData
+)
+
+// Error is a CTAPHID_ERROR response from the authenticator.
+type Error uint8
+
+var errorNames = map[Error]string{
+	0x01: "invalid command",
+	0x02: "invalid parameter",
+	0x03: "invalid length",
+	0x04: "invalid sequence",
+	0x05: "message timeout",
+	0x06: "channel busy",
+	0x0a: "lock required",
+	0x0b: "invalid channel",
+	0x7f: "other",
+}
+
+func (e Error) Error() string {
+	if s, ok := errorNames[e]; ok {
+		return "ctaphid: " + s
+	}
+	return fmt.Sprintf("ctaphid: error 0x%02x", uint8(e))
+}
+
+var (
+	ErrTooLong  = errors.New("ctaphid: message too long")
+	ErrProtocol = errors.New("ctaphid: malformed response")
+)
+
+// Device is a CTAP-HID channel to an authenticator.
+type Device struct {
+	hid  *hid.Device
+	cid  uint32
+	lock sync.Mutex
+
+	// filled in from the INIT response
+	Protocol     uint8
+	Major        uint8
+	Minor        uint8
+	Build        uint8
+	Capabilities uint8
+
+	// Keepalive, if set, is called with STATUS_PROCESSING or
+	// STATUS_UPNEEDED each time the authenticator reports it is still
+	// working, e.g. to prompt the user to touch the key.
+	Keepalive func(status uint8)
+
+	// Timeout bounds the wait for each packet, in ms.  Authenticators
+	// send keepalives every 100ms or so while busy.
+	Timeout uint32
+}
+
+// Open allocates a channel on the authenticator behind h.
+func Open(h *hid.Device) (*Device, error) {
+	d := &Device{hid: h, cid: CID_BROADCAST, Timeout: 1000}
+	nonce := make([]byte, 8)
+	if _, e := rand.Read(nonce); e != nil {
+		return nil, e
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if e := d.send(CTAPHID_INIT, nonce); e != nil {
+		return nil, e
+	}
+	// replies to someone else's INIT on the broadcast channel are
+	// identified by their nonce; ours may come after them, so keep
+	// reading rather than asking again
+	deadline := time.Now().Add(time.Duration(d.Timeout) * time.Millisecond)
+	for {
+		resp, e := d.recv(CTAPHID_INIT)
+		if e != nil {
+			return nil, e
+		}
+		if len(resp) < 17 {
+			return nil, ErrProtocol
+		}
+		if string(resp[:8]) != string(nonce) {
+			if time.Now().After(deadline) {
+				return nil, syscall.ETIMEDOUT
+			}
+			continue
+		}
+		d.cid = binary.BigEndian.Uint32(resp[8:])
+		d.Protocol = resp[12]
+		d.Major = resp[13]
+		d.Minor = resp[14]
+		d.Build = resp[15]
+		d.Capabilities = resp[16]
+		return d, nil
+	}
+}
+
+func (d *Device) send(cmd uint8, data []byte) error {
+	if len(data) > maxMsg {
+		return ErrTooLong
+	}
+	pkt := make([]byte, 64)
+	binary.BigEndian.PutUint32(pkt, d.cid)
+	pkt[4] = 0x80 | cmd
+	binary.BigEndian.PutUint16(pkt[5:], uint16(len(data)))
+	n := copy(pkt[7:], data)
+	data = data[n:]
+	if _, e := d.hid.Write(pkt, d.Timeout); e != nil {
+		return e
+	}
+	for seq := 0; len(data) > 0; seq++ {
+		clear(pkt[4:])
+		pkt[4] = uint8(seq)
+		n := copy(pkt[5:], data)
+		data = data[n:]
+		if _, e := d.hid.Write(pkt, d.Timeout); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+func (d *Device) recv(cmd uint8) ([]byte, error) {
+	pkt := make([]byte, 64)
+	var msg []byte
+	var want int
+	seq := 0
+	for {
+		n, e := d.hid.Read(pkt, d.Timeout)
+		if e != nil {
+			return nil, e
+		}
+		if n < 7 || binary.BigEndian.Uint32(pkt) != d.cid {
+			continue
+		}
+		if msg == nil {
+			if pkt[4]&0x80 == 0 {
+				continue
+			}
+			switch pkt[4] & 0x7f {
+			case CTAPHID_KEEPALIVE:
+				if d.Keepalive != nil {
+					d.Keepalive(pkt[7])
+				}
+				continue
+			case CTAPHID_ERROR:
+				return nil, Error(pkt[7])
+			case cmd:
+			default:
+				return nil, ErrProtocol
+			}
+			want = int(binary.BigEndian.Uint16(pkt[5:]))
+			if want > maxMsg {
+				return nil, ErrProtocol
+			}
+			msg = make([]byte, 0, want)
+			msg = append(msg, pkt[7:min(n, 7+want)]...)
+		} else {
+			if pkt[4] != uint8(seq) {
+				return nil, Error(0x04)
+			}
+			seq++
+			msg = append(msg, pkt[5:min(n, 5+want-len(msg))]...)
+		}
+		if len(msg) >= want {
+			return msg, nil
+		}
+	}
+}
+
+// Transact sends a request and returns the authenticator's reply,
+// handling fragmentation and keepalives.
+func (d *Device) Transact(cmd uint8, data []byte) ([]byte, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if e := d.send(cmd, data); e != nil {
+		return nil, e
+	}
+	return d.recv(cmd)
+}
+
+func (d *Device) Ping(data []byte) ([]byte, error) {
+	return d.Transact(CTAPHID_PING, data)
+}
+
+// Msg carries a U2F (CTAP1) APDU.
+func (d *Device) Msg(apdu []byte) ([]byte, error) {
+	return d.Transact(CTAPHID_MSG, apdu)
+}
+
+// CBOR carries a CTAP2 command byte followed by its CBOR parameters.
+func (d *Device) CBOR(req []byte) ([]byte, error) {
+	return d.Transact(CTAPHID_CBOR, req)
+}
+
+func (d *Device) Wink() error {
+	_, e := d.Transact(CTAPHID_WINK, nil)
+	return e
+}
+
+// Cancel aborts a CBOR request waiting for user presence.  It is the one
+// call that may be made while another goroutine is inside Transact; the
+// pending request then fails with CTAP2_ERR_KEEPALIVE_CANCEL.
+func (d *Device) Cancel() error {
+	return d.send(CTAPHID_CANCEL, nil)
+}
@@ -0,0 +1,255 @@
+package fido_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/richardnwinder/usb/fido"
+	"github.com/richardnwinder/usb/hid"
+	"github.com/richardnwinder/usb/usbtest"
+)
+
+const keyCID = 0x01020304
+
+// frames splits a message into CTAPHID packets, as an authenticator
+// sends it
+func frames(cid uint32, cmd uint8, data []byte) [][]byte {
+	var list [][]byte
+	pkt := make([]byte, 64)
+	binary.BigEndian.PutUint32(pkt, cid)
+	pkt[4] = 0x80 | cmd
+	binary.BigEndian.PutUint16(pkt[5:], uint16(len(data)))
+	data = data[copy(pkt[7:], data):]
+	list = append(list, pkt)
+	for seq := 0; len(data) > 0; seq++ {
+		pkt = make([]byte, 64)
+		binary.BigEndian.PutUint32(pkt, cid)
+		pkt[4] = uint8(seq)
+		data = data[copy(pkt[5:], data):]
+		list = append(list, pkt)
+	}
+	return list
+}
+
+// fakeKey is an authenticator that reassembles requests and answers them
+// through respond, which by default allocates keyCID on INIT and echoes
+// anything else
+type fakeKey struct {
+	lock    sync.Mutex
+	got     [][]byte // packets received
+	cmd     uint8
+	msg     []byte
+	want    int
+	in      [][]byte
+	respond func(cid uint32, cmd uint8, data []byte) [][]byte
+}
+
+func (k *fakeKey) out(q *usbtest.Request) ([]byte, error) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	p := q.Data
+	if len(p) != 64 {
+		return nil, syscall.EPROTO
+	}
+	k.got = append(k.got, p)
+	cid := binary.BigEndian.Uint32(p)
+	if p[4]&0x80 != 0 {
+		k.cmd = p[4] & 0x7f
+		k.want = int(binary.BigEndian.Uint16(p[5:]))
+		k.msg = append([]byte(nil), p[7:7+min(k.want, 57)]...)
+	} else {
+		k.msg = append(k.msg, p[5:5+min(k.want-len(k.msg), 59)]...)
+	}
+	if len(k.msg) == k.want {
+		if k.cmd == fido.CTAPHID_CANCEL {
+			return nil, nil
+		}
+		respond := k.respond
+		if respond == nil {
+			respond = echo
+		}
+		k.in = append(k.in, respond(cid, k.cmd, k.msg)...)
+	}
+	return nil, nil
+}
+
+func echo(cid uint32, cmd uint8, data []byte) [][]byte {
+	if cmd == fido.CTAPHID_INIT {
+		resp := append(append([]byte(nil), data...), 0, 0, 0, 0, 2, 1, 2, 3, fido.CAPABILITY_WINK|fido.CAPABILITY_CBOR)
+		binary.BigEndian.PutUint32(resp[8:], keyCID)
+		return frames(cid, cmd, resp)
+	}
+	return frames(cid, cmd, data)
+}
+
+func (k *fakeKey) input(q *usbtest.Request) ([]byte, error) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	if len(k.in) == 0 {
+		return nil, syscall.ETIMEDOUT
+	}
+	p := k.in[0]
+	k.in = k.in[1:]
+	return p, nil
+}
+
+func open(t *testing.T, k *fakeKey) *fido.Device {
+	fx, e := usbtest.ParseHex("fido", `
+12 01 00 02 00 00 00 40  50 10 07 04 00 01 01 02 00 01
+09 02 29 00 01 01 00 80 32
+09 04 00 00 02 03 00 00 00
+09 21 11 01 00 01 22 22 00
+07 05 81 03 40 00 05
+07 05 01 03 40 00 05
+`)
+	if e != nil {
+		t.Fatal(e)
+	}
+	di, e := fx.Info()
+	if e != nil {
+		t.Fatal(e)
+	}
+	d := fx.Device()
+	d.On(usbtest.Bulk(0x01)).Do(k.out)
+	d.On(usbtest.Bulk(0x81)).Do(k.input)
+	h, e := hid.NewDevice(d, &di.Config[0].Interface[0])
+	if e != nil {
+		t.Fatal(e)
+	}
+	dev, e := fido.Open(h)
+	if e != nil {
+		t.Fatal(e)
+	}
+	return dev
+}
+
+// TestOpen answers INIT after a reply to someone else's INIT on the
+// broadcast channel and a packet on another channel, both of which Open
+// must pass over
+func TestOpen(t *testing.T) {
+	k := &fakeKey{}
+	k.respond = func(cid uint32, cmd uint8, data []byte) [][]byte {
+		other := []byte{9, 9, 9, 9, 9, 9, 9, 9, 0xaa, 0xbb, 0xcc, 0xdd, 2, 9, 9, 9, 0}
+		foreign := frames(0x0a0b0c0d, fido.CTAPHID_PING, []byte{1})
+		list := append(frames(fido.CID_BROADCAST, fido.CTAPHID_INIT, other), foreign...)
+		return append(list, echo(cid, cmd, data)...)
+	}
+	d := open(t, k)
+	if d.Protocol != 2 || d.Major != 1 || d.Minor != 2 || d.Build != 3 || d.Capabilities != fido.CAPABILITY_WINK|fido.CAPABILITY_CBOR {
+		t.Errorf("INIT response read as %+v", d)
+	}
+	if len(k.got) != 1 || binary.BigEndian.Uint32(k.got[0]) != fido.CID_BROADCAST || k.got[0][4] != 0x80|fido.CTAPHID_INIT {
+		t.Fatalf("INIT sent as % x", k.got)
+	}
+	// the channel is the one in our reply, not in the other
+	k.got = nil
+	if _, e := d.Ping([]byte{1, 2, 3}); e != nil {
+		t.Fatal(e)
+	}
+	if cid := binary.BigEndian.Uint32(k.got[0]); cid != keyCID {
+		t.Errorf("ping on channel %#x, want %#x", cid, keyCID)
+	}
+}
+
+// TestFragmentation sends and receives a message needing an
+// initialization packet and several continuations
+func TestFragmentation(t *testing.T) {
+	k := &fakeKey{}
+	d := open(t, k)
+	msg := make([]byte, 300)
+	for i := range msg {
+		msg[i] = byte(i)
+	}
+	k.got = nil
+	resp, e := d.Ping(msg)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if !bytes.Equal(resp, msg) {
+		t.Errorf("echo of %d bytes came back as %d: % x", len(msg), len(resp), resp)
+	}
+	// 57 bytes in the first packet, then 59 in each of 5 continuations
+	if len(k.got) != 6 {
+		t.Fatalf("%d packets sent, want 6", len(k.got))
+	}
+	if p := k.got[0]; p[4] != 0x80|fido.CTAPHID_PING || binary.BigEndian.Uint16(p[5:]) != 300 {
+		t.Errorf("initialization packet % x", p[:7])
+	}
+	for i, p := range k.got[1:] {
+		if p[4] != uint8(i) || binary.BigEndian.Uint32(p) != keyCID {
+			t.Errorf("continuation %d: channel %#x seq %d", i, binary.BigEndian.Uint32(p), p[4])
+		}
+	}
+	// an exact fit takes no continuation
+	k.got = nil
+	if _, e := d.Ping(msg[:57]); e != nil || len(k.got) != 1 {
+		t.Errorf("57 byte ping in %d packets, %v", len(k.got), e)
+	}
+	if _, e := d.Ping(make([]byte, 57+128*59+1)); !errors.Is(e, fido.ErrTooLong) {
+		t.Errorf("oversized ping: %v, want %v", e, fido.ErrTooLong)
+	}
+}
+
+func TestSequence(t *testing.T) {
+	k := &fakeKey{}
+	d := open(t, k)
+	k.respond = func(cid uint32, cmd uint8, data []byte) [][]byte {
+		list := frames(cid, cmd, data)
+		list[1], list[2] = list[2], list[1]
+		return list
+	}
+	if _, e := d.Ping(make([]byte, 200)); !errors.Is(e, fido.Error(0x04)) {
+		t.Errorf("out of order continuations: %v, want %v", e, fido.Error(0x04))
+	}
+}
+
+func TestKeepalive(t *testing.T) {
+	k := &fakeKey{}
+	d := open(t, k)
+	var statuses []uint8
+	d.Keepalive = func(status uint8) { statuses = append(statuses, status) }
+	k.respond = func(cid uint32, cmd uint8, data []byte) [][]byte {
+		list := frames(cid, fido.CTAPHID_KEEPALIVE, []byte{fido.STATUS_PROCESSING})
+		list = append(list, frames(cid, fido.CTAPHID_KEEPALIVE, []byte{fido.STATUS_UPNEEDED})...)
+		return append(list, frames(cid, cmd, []byte{0x00, 0xa0})...)
+	}
+	resp, e := d.CBOR([]byte{0x01})
+	if e != nil || !bytes.Equal(resp, []byte{0x00, 0xa0}) {
+		t.Errorf("CBOR: % x, %v", resp, e)
+	}
+	if len(statuses) != 2 || statuses[0] != fido.STATUS_PROCESSING || statuses[1] != fido.STATUS_UPNEEDED {
+		t.Errorf("keepalives %v, want processing then user presence needed", statuses)
+	}
+}
+
+func TestError(t *testing.T) {
+	k := &fakeKey{}
+	d := open(t, k)
+	for _, c := range []struct {
+		code uint8
+		msg  string
+	}{
+		{0x06, "ctaphid: channel busy"},
+		{0x0b, "ctaphid: invalid channel"},
+		{0x42, "ctaphid: error 0x42"},
+	} {
+		k.respond = func(cid uint32, cmd uint8, data []byte) [][]byte {
+			return frames(cid, fido.CTAPHID_ERROR, []byte{c.code})
+		}
+		e := d.Wink()
+		if !errors.Is(e, fido.Error(c.code)) || e.Error() != c.msg {
+			t.Errorf("error %#x: %v, want %q", c.code, e, c.msg)
+		}
+	}
+	// an answer to a different command
+	k.respond = func(cid uint32, cmd uint8, data []byte) [][]byte {
+		return frames(cid, fido.CTAPHID_MSG, data)
+	}
+	if e := d.Wink(); !errors.Is(e, fido.ErrProtocol) {
+		t.Errorf("answer to another command: %v, want %v", e, fido.ErrProtocol)
+	}
+}
@@ -0,0 +1,57 @@
+package usb
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+)
+
+// Fingerprint hashes what a device says it is, for telling apart devices
+// that share a vendor and product ID: a counterfeit, or one running other
+// firmware, usually differs somewhere in its descriptors.  The hash
+// covers the device descriptor, bcdDevice included, and every
+// configuration, interface and endpoint descriptor with the class
+// descriptors between them, re-encoded from di so that devices listed
+// locally, through remote or from a fixture hash alike.  Nothing that
+// differs between units of one product goes in, so not the serial
+// number, nor where the device is plugged in.  With withStrings the
+// manufacturer and product strings the kernel read are hashed too, which
+// catches clones that copy the descriptors but not the strings; they are
+// only known for devices listed from sysfs.
+func Fingerprint(di *DeviceInfo, withStrings bool) string {
+	var b bytes.Buffer
+	b.WriteString("usb fingerprint 1\x00")
+	binary.Write(&b, binary.LittleEndian, di.DeviceDescriptor)
+	for i := range di.Config {
+		ci := &di.Config[i]
+		binary.Write(&b, binary.LittleEndian, ci.ConfigDescriptor)
+		writeBlob(&b, ci.Extra)
+		for j := range ci.Interface {
+			ii := &ci.Interface[j]
+			binary.Write(&b, binary.LittleEndian, ii.InterfaceDescriptor)
+			for _, ep := range ii.Endpoint {
+				binary.Write(&b, binary.LittleEndian, ep)
+			}
+			writeBlob(&b, ii.Extra)
+		}
+	}
+	if withStrings {
+		var manufacturer, product string
+		if di.syspath != "" {
+			manufacturer = readAttr(di.syspath + "/manufacturer")
+			product = readAttr(di.syspath + "/product")
+		}
+		writeBlob(&b, []byte(manufacturer))
+		writeBlob(&b, []byte(product))
+	}
+	sum := sha256.Sum256(b.Bytes())
+	return hex.EncodeToString(sum[:])
+}
+
+// writeBlob writes b with its length, so that where one ends and the next
+// begins can't shift without changing the hash
+func writeBlob(w *bytes.Buffer, b []byte) {
+	binary.Write(w, binary.LittleEndian, uint32(len(b)))
+	w.Write(b)
+}
@@ -0,0 +1,126 @@
+package usb_test
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/richardnwinder/usb"
+	"github.com/richardnwinder/usb/usbtest"
+)
+
+// a HID device, with the class descriptor between interface and endpoint
+const hidDescriptors = `
+12 01 00 02 00 00 00 40  34 12 78 56 00 01 01 02 03 01
+09 02 22 00 01 01 00 80 32
+09 04 00 00 01 03 00 00 00
+09 21 11 01 00 01 22 3f 00
+07 05 81 03 08 00 0a
+`
+
+func parse(t *testing.T, text string) *usb.DeviceInfo {
+	t.Helper()
+	f, e := usbtest.ParseHex("test", text)
+	if e != nil {
+		t.Fatal(e)
+	}
+	di, e := f.Info()
+	if e != nil {
+		t.Fatal(e)
+	}
+	return di
+}
+
+func TestFingerprint(t *testing.T) {
+	a := usb.Fingerprint(parse(t, hidDescriptors), false)
+	if len(a) != 64 {
+		t.Fatalf("fingerprint %q is not a hex SHA-256", a)
+	}
+	if _, e := hex.DecodeString(a); e != nil {
+		t.Fatalf("fingerprint %q: %v", a, e)
+	}
+	if b := usb.Fingerprint(parse(t, hidDescriptors), false); b != a {
+		t.Errorf("the same descriptors hash to %s and %s", a, b)
+	}
+
+	// where the device is plugged in isn't part of what it is
+	di := parse(t, hidDescriptors)
+	di.BusNum, di.DevNum, di.Ports = 3, 17, []int{1, 4}
+	if b := usb.Fingerprint(di, false); b != a {
+		t.Errorf("bus and ports changed the fingerprint")
+	}
+	// without sysfs there are no strings to hash
+	if b := usb.Fingerprint(di, true); b == a {
+		t.Errorf("withStrings hashes the same as without")
+	} else if c := usb.Fingerprint(parse(t, hidDescriptors), true); c != b {
+		t.Errorf("withStrings and no strings hash to %s and %s", b, c)
+	}
+
+	for _, c := range []struct {
+		name string
+		edit func(di *usb.DeviceInfo)
+	}{
+		{"bcdDevice", func(di *usb.DeviceInfo) { di.DeviceVersion = 0x0101 }},
+		{"attributes", func(di *usb.DeviceInfo) { di.Config[0].Attributes = 0xc0 }},
+		{"interface class", func(di *usb.DeviceInfo) { di.Config[0].Interface[0].InterfaceClass = 0xff }},
+		{"endpoint", func(di *usb.DeviceInfo) { di.Config[0].Interface[0].Endpoint[0].Interval = 1 }},
+		{"class descriptor", func(di *usb.DeviceInfo) { di.Config[0].Interface[0].Extra[7] = 0x40 }},
+		// the class descriptor moved to the config's extra descriptors
+		{"moved", func(di *usb.DeviceInfo) {
+			ci := &di.Config[0]
+			ci.Extra, ci.Interface[0].Extra = ci.Interface[0].Extra, nil
+		}},
+	} {
+		di := parse(t, hidDescriptors)
+		c.edit(di)
+		if usb.Fingerprint(di, false) == a {
+			t.Errorf("changing the %s kept the fingerprint", c.name)
+		}
+	}
+}
+
+// TestFingerprintStrings hashes a device listed from a fake sysfs, which
+// matches the same descriptors from a fixture, and whose strings count
+// but whose serial number doesn't
+func TestFingerprintStrings(t *testing.T) {
+	bus, di := fakeSysfs(t)
+	fixture := parse(t, `
+12 01 00 02 00 00 00 40  34 12 78 56 00 01 00 00 00 01
+09 02 19 00 01 01 00 80 32
+09 04 00 00 01 03 00 00 00
+07 05 81 03 08 00 0a
+`)
+	if a, b := usb.Fingerprint(di, false), usb.Fingerprint(fixture, false); a != b {
+		t.Errorf("listed and fixture hash to %s and %s", a, b)
+	}
+
+	dir := filepath.Join(bus, "devices/1-2")
+	write := func(name string, v string) {
+		if e := os.WriteFile(filepath.Join(dir, name), []byte(v), 0644); e != nil {
+			t.Fatal(e)
+		}
+	}
+	write("manufacturer", "Acme\n")
+	write("product", "Widget\n")
+	write("serial", "0001\n")
+	a := usb.Fingerprint(di, true)
+	if a == usb.Fingerprint(fixture, true) {
+		t.Errorf("strings didn't change the fingerprint")
+	}
+	write("serial", "0002\n")
+	if b := usb.Fingerprint(di, true); b != a {
+		t.Errorf("the serial number changed the fingerprint")
+	}
+	write("product", "Widget Pro\n")
+	if b := usb.Fingerprint(di, true); b == a {
+		t.Errorf("the product string didn't change the fingerprint")
+	}
+
+	// manufacturer and product are hashed apart, not run together
+	write("manufacturer", "AcmeWidget\n")
+	write("product", "\n")
+	if b := usb.Fingerprint(di, true); b == a {
+		t.Errorf("moving text between manufacturer and product kept the fingerprint")
+	}
+}
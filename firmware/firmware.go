@@ -0,0 +1,65 @@
+// Package firmware holds what the flashing drivers (dfu and the vendor
+// bootloaders) have in common: update stages, progress reporting and
+// resumption.
+package firmware
+
+import (
+	"errors"
+	"io"
+)
+
+type Stage int
+
+const (
+	STAGE_DETACH   Stage = iota // switching the device into its bootloader
+	STAGE_ERASE                 // erasing flash
+	STAGE_DOWNLOAD              // writing the image
+	STAGE_VERIFY                // reading back and comparing
+	STAGE_MANIFEST              // device is committing the image
+	STAGE_RESET                 // restarting into the application
+	STAGE_DONE
+)
+
+var stageNames = []string{"detach", "erase", "download", "verify", "manifest", "reset", "done"}
+
+func (s Stage) String() string {
+	if int(s) < len(stageNames) {
+		return stageNames[s]
+	}
+	return "unknown"
+}
+
+// Progress is called as an update advances.  done and total are in bytes
+// for STAGE_DOWNLOAD and STAGE_VERIFY; total is -1 if the image size is
+// unknown, and both are 0 for stages with no measurable progress.  The
+// done value seen during STAGE_DOWNLOAD is what to pass as Resume if the
+// update is interrupted.
+type Progress func(stage Stage, done int64, total int64)
+
+type Options struct {
+	Progress Progress
+	// Resume skips this many bytes of the image, which an earlier
+	// attempt already wrote.  Drivers whose protocol can't restart
+	// mid-image return ErrNoResume.
+	Resume int64
+	// Address is the load address, for protocols that have one.
+	Address uint32
+}
+
+var ErrNoResume = errors.New("firmware: protocol cannot resume a download")
+
+// Report calls the progress callback, if there is one.
+func (o *Options) Report(stage Stage, done int64, total int64) {
+	if o != nil && o.Progress != nil {
+		o.Progress(stage, done, total)
+	}
+}
+
+// Skip discards the part of the image that is being resumed over.
+func (o *Options) Skip(r io.Reader) error {
+	if o == nil || o.Resume == 0 {
+		return nil
+	}
+	_, e := io.CopyN(io.Discard, r, o.Resume)
+	return e
+}
@@ -0,0 +1,106 @@
+package firmware
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Segment is a contiguous run of image data at a load address.
+type Segment struct {
+	Address uint32
+	Data    []byte
+}
+
+// ReadHex parses an Intel HEX image into segments sorted by address, with
+// adjacent records merged.
+func ReadHex(r io.Reader) ([]Segment, error) {
+	var segs []Segment
+	var base uint32
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		t := strings.TrimSpace(s.Text())
+		if t == "" {
+			continue
+		}
+		if t[0] != ':' {
+			return nil, fmt.Errorf("hex line %d: missing ':'", line)
+		}
+		b, e := hex.DecodeString(t[1:])
+		if e != nil || len(b) < 5 || len(b) != int(b[0])+5 {
+			return nil, fmt.Errorf("hex line %d: malformed record", line)
+		}
+		var sum byte
+		for _, c := range b {
+			sum += c
+		}
+		if sum != 0 {
+			return nil, fmt.Errorf("hex line %d: bad checksum", line)
+		}
+		data := b[4 : 4+b[0]]
+		switch b[3] {
+		case 0x00:
+			addr := base + (uint32(b[1])<<8 | uint32(b[2]))
+			if n := len(segs); n > 0 && segs[n-1].Address+uint32(len(segs[n-1].Data)) == addr {
+				segs[n-1].Data = append(segs[n-1].Data, data...)
+			} else {
+				segs = append(segs, Segment{addr, append([]byte(nil), data...)})
+			}
+		case 0x01:
+			return sortSegments(segs), nil
+		case 0x02:
+			if len(data) != 2 {
+				return nil, fmt.Errorf("hex line %d: malformed record", line)
+			}
+			base = (uint32(data[0])<<8 | uint32(data[1])) << 4
+		case 0x04:
+			if len(data) != 2 {
+				return nil, fmt.Errorf("hex line %d: malformed record", line)
+			}
+			base = (uint32(data[0])<<8 | uint32(data[1])) << 16
+		case 0x03, 0x05:
+			// start address, meaningless for flashing
+		default:
+			return nil, fmt.Errorf("hex line %d: unknown record type %02x", line, b[3])
+		}
+	}
+	if e := s.Err(); e != nil {
+		return nil, e
+	}
+	return nil, fmt.Errorf("hex: missing end of file record")
+}
+
+func sortSegments(segs []Segment) []Segment {
+	sort.Slice(segs, func(i, j int) bool { return segs[i].Address < segs[j].Address })
+	// records needn't be in order, so merge again once sorted
+	out := segs[:0]
+	for _, s := range segs {
+		if n := len(out); n > 0 && out[n-1].Address+uint32(len(out[n-1].Data)) == s.Address {
+			out[n-1].Data = append(out[n-1].Data, s.Data...)
+		} else {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// Flatten joins segments into a single image starting at the lowest
+// address, filling the gaps with fill (0xff for erased flash).
+func Flatten(segs []Segment, fill byte) (uint32, []byte) {
+	if len(segs) == 0 {
+		return 0, nil
+	}
+	start := segs[0].Address
+	var img []byte
+	for _, s := range segs {
+		off := int(s.Address - start)
+		for len(img) < off {
+			img = append(img, fill)
+		}
+		img = append(img[:off], s.Data...)
+	}
+	return start, img
+}
@@ -0,0 +1,314 @@
+// Package flip drives the Atmel bootloaders that take the FLIP command
+// set over HID rather than over DFU.
+//
+// The commands are FLIP's, as the DFU bootloaders take them in DFU_DNLOAD
+// requests.  Over HID each command is sent as one output report, zero
+// padded to the OUT endpoint's size, and answered by one input report
+// whose first byte is a DFU bStatus (0 for OK) and, for a configuration
+// read, whose second byte is the value read.  The data of a program
+// command follows it in output reports of that size, the last padded; the
+// data of a read command follows its status in input reports.  Starting
+// the application is not answered.
+package flip
+
+import (
+	"bytes"
+	"fmt"
+	"syscall"
+
+	"github.com/richardnwinder/usb/dfu"
+	"github.com/richardnwinder/usb/firmware"
+	"github.com/richardnwinder/usb/hid"
+)
+
+const (
+	VendorID = 0x03eb
+
+	// command groups
+	CMD_PROG_START  = 0x01
+	CMD_READ        = 0x03
+	CMD_WRITE       = 0x04
+	CMD_READ_CONFIG = 0x05
+	CMD_CHANGE_BASE = 0x06
+
+	// memories, for CMD_PROG_START and CMD_READ
+	MEM_FLASH  = 0x00
+	MEM_EEPROM = 0x01
+
+	// configuration bytes, for CMD_READ_CONFIG
+	CONFIG_VERSION      = 0x0000 // bootloader version
+	CONFIG_MANUFACTURER = 0x0130
+	CONFIG_FAMILY       = 0x0131
+	CONFIG_PRODUCT      = 0x0160
+	CONFIG_REVISION     = 0x0161
+
+	pageSize  = 0x10000 // what a 16 bit command address reaches
+	blockSize = 0x400   // image bytes per program or read command
+)
+
+// Part is a device the bootloader may run on.
+type Part struct {
+	Name  string
+	ID    [3]byte // manufacturer, family and product codes
+	Flash uint32  // application flash, below the factory bootloader
+}
+
+// Parts are the parts Open recognises.
+var Parts = []Part{
+	{"ATmega8U2", [3]byte{0x1e, 0x93, 0x89}, 0x1000},
+	{"ATmega16U2", [3]byte{0x1e, 0x94, 0x89}, 0x3000},
+	{"ATmega16U4", [3]byte{0x1e, 0x94, 0x88}, 0x3000},
+	{"ATmega32U2", [3]byte{0x1e, 0x95, 0x8a}, 0x7000},
+	{"ATmega32U4", [3]byte{0x1e, 0x95, 0x87}, 0x7000},
+	{"AT90USB646", [3]byte{0x1e, 0x96, 0x82}, 0xe000},
+	{"AT90USB1286", [3]byte{0x1e, 0x97, 0x82}, 0x1e000},
+}
+
+type Device struct {
+	h            *hid.Device
+	size         int // bytes per report
+	page         int // 64K page the addresses are in, -1 until one is selected
+	Version      uint8
+	ID           [3]byte
+	Part         *Part  // nil if ID isn't in Parts
+	FlashSize    uint32 // from Part; set it for a part Open doesn't know
+	Timeout      uint32 // ms, per report
+	EraseTimeout uint32 // ms, for the chip erase
+}
+
+// Open asks the bootloader behind h for its version and the part's ID.
+func Open(h *hid.Device) (*Device, error) {
+	d := &Device{h: h, size: h.OutSize, page: -1, Timeout: 5000, EraseTimeout: 20000}
+	if d.size < 8 {
+		d.size = 64
+	}
+	var e error
+	if d.Version, e = d.ReadConfig(CONFIG_VERSION); e != nil {
+		return nil, e
+	}
+	for i, c := range []uint16{CONFIG_MANUFACTURER, CONFIG_FAMILY, CONFIG_PRODUCT} {
+		if d.ID[i], e = d.ReadConfig(c); e != nil {
+			return nil, e
+		}
+	}
+	for i := range Parts {
+		if Parts[i].ID == d.ID {
+			d.Part = &Parts[i]
+			d.FlashSize = Parts[i].Flash
+		}
+	}
+	return d, nil
+}
+
+func (d *Device) command(cmd ...byte) error {
+	p := make([]byte, d.size)
+	copy(p, cmd)
+	_, e := d.h.Write(p, d.Timeout)
+	return e
+}
+
+// status reads the answer to a command, returning its second byte
+func (d *Device) status(timeout uint32) (uint8, error) {
+	p := make([]byte, max(d.h.InSize, 64))
+	n, e := d.h.Read(p, timeout)
+	if e != nil {
+		return 0, e
+	}
+	if n < 2 {
+		return 0, syscall.EPROTO
+	}
+	if p[0] != 0 {
+		return 0, dfu.StatusError(p[0])
+	}
+	return p[1], nil
+}
+
+// ReadConfig reads a configuration byte: the bootloader version or one of
+// the part's ID bytes.  c holds the command's two argument bytes.
+func (d *Device) ReadConfig(c uint16) (uint8, error) {
+	if e := d.command(CMD_READ_CONFIG, byte(c>>8), byte(c)); e != nil {
+		return 0, e
+	}
+	return d.status(d.Timeout)
+}
+
+// Erase erases all of flash.  The bootloader answers once it is done.
+func (d *Device) Erase() error {
+	if e := d.command(CMD_WRITE, 0x00, 0xff); e != nil {
+		return e
+	}
+	_, e := d.status(d.EraseTimeout)
+	return e
+}
+
+// selectPage points the 16 bit command addresses into addr's 64K page
+func (d *Device) selectPage(addr uint32) error {
+	page := int(addr / pageSize)
+	if page == d.page {
+		return nil
+	}
+	if e := d.command(CMD_CHANGE_BASE, 0x03, 0x00, byte(page)); e != nil {
+		return e
+	}
+	if _, e := d.status(d.Timeout); e != nil {
+		return e
+	}
+	d.page = page
+	return nil
+}
+
+// blocks splits len bytes from addr into commands, none crossing a page
+func blocks(addr uint32, n int, f func(addr uint32, n int) error) error {
+	for n > 0 {
+		k := min(n, blockSize, int(pageSize-addr%pageSize))
+		if e := f(addr, k); e != nil {
+			return e
+		}
+		addr += uint32(k)
+		n -= k
+	}
+	return nil
+}
+
+// span is the command arguments for n bytes at addr: memory, then 16 bit
+// big endian first and last addresses within the page
+func span(mem uint8, addr uint32, n int) []byte {
+	start, end := addr%pageSize, addr%pageSize+uint32(n)-1
+	return []byte{mem, byte(start >> 8), byte(start), byte(end >> 8), byte(end)}
+}
+
+// Write programs data into memory mem at addr.
+func (d *Device) Write(mem uint8, addr uint32, data []byte) error {
+	return d.write(mem, addr, data, nil)
+}
+
+func (d *Device) write(mem uint8, addr uint32, data []byte, sent func(n int)) error {
+	return blocks(addr, len(data), func(addr uint32, n int) error {
+		if e := d.selectPage(addr); e != nil {
+			return e
+		}
+		if e := d.command(append([]byte{CMD_PROG_START}, span(mem, addr, n)...)...); e != nil {
+			return e
+		}
+		block := data[:n]
+		data = data[n:]
+		for len(block) > 0 {
+			k := min(len(block), d.size)
+			if e := d.command(block[:k]...); e != nil {
+				return e
+			}
+			block = block[k:]
+		}
+		if _, e := d.status(d.Timeout); e != nil {
+			return e
+		}
+		if sent != nil {
+			sent(n)
+		}
+		return nil
+	})
+}
+
+// Read reads len(buf) bytes of memory mem from addr.
+func (d *Device) Read(mem uint8, addr uint32, buf []byte) error {
+	p := make([]byte, max(d.h.InSize, 64))
+	return blocks(addr, len(buf), func(addr uint32, n int) error {
+		if e := d.selectPage(addr); e != nil {
+			return e
+		}
+		if e := d.command(append([]byte{CMD_READ}, span(mem, addr, n)...)...); e != nil {
+			return e
+		}
+		if _, e := d.status(d.Timeout); e != nil {
+			return e
+		}
+		for got := 0; got < n; {
+			k, e := d.h.Read(p, d.Timeout)
+			if e != nil {
+				return e
+			}
+			if k == 0 {
+				return syscall.EPROTO
+			}
+			got += copy(buf[got:n], p[:k])
+		}
+		buf = buf[n:]
+		return nil
+	})
+}
+
+// Reset starts the application.  The bootloader doesn't answer, and the
+// device drops off the bus.
+func (d *Device) Reset() error {
+	return d.command(CMD_WRITE, 0x03, 0x00)
+}
+
+// clip restricts segments to the application flash
+func (d *Device) clip(segs []firmware.Segment) []firmware.Segment {
+	var out []firmware.Segment
+	for _, s := range segs {
+		a, b := uint64(s.Address), uint64(s.Address)+uint64(len(s.Data))
+		b = min(b, uint64(d.FlashSize))
+		if a < b {
+			out = append(out, firmware.Segment{Address: s.Address, Data: s.Data[:b-a]})
+		}
+	}
+	return out
+}
+
+// Program erases the flash, writes the parts of segs that fall in the
+// application flash and reads them back.  FlashSize must be known.  The
+// erase is whole-chip, so a resumed update skips it along with the first
+// opts.Resume bytes.  The device is not reset.
+func (d *Device) Program(segs []firmware.Segment, opts *firmware.Options) error {
+	if d.FlashSize == 0 {
+		return fmt.Errorf("flip: unknown part %x; set FlashSize", d.ID)
+	}
+	segs = d.clip(segs)
+	var total int64
+	for _, s := range segs {
+		total += int64(len(s.Data))
+	}
+	var skip int64
+	if opts != nil {
+		skip = opts.Resume
+	}
+	if skip == 0 {
+		opts.Report(firmware.STAGE_ERASE, 0, 0)
+		if e := d.Erase(); e != nil {
+			return e
+		}
+	}
+	done := skip
+	opts.Report(firmware.STAGE_DOWNLOAD, done, total)
+	for _, s := range segs {
+		data, addr := s.Data, s.Address
+		if skip >= int64(len(data)) {
+			skip -= int64(len(data))
+			continue
+		}
+		data, addr, skip = data[skip:], addr+uint32(skip), 0
+		e := d.write(MEM_FLASH, addr, data, func(n int) {
+			done += int64(n)
+			opts.Report(firmware.STAGE_DOWNLOAD, done, total)
+		})
+		if e != nil {
+			return e
+		}
+	}
+	done = 0
+	opts.Report(firmware.STAGE_VERIFY, done, total)
+	for _, s := range segs {
+		buf := make([]byte, len(s.Data))
+		if e := d.Read(MEM_FLASH, s.Address, buf); e != nil {
+			return e
+		}
+		if !bytes.Equal(buf, s.Data) {
+			return fmt.Errorf("flip: verify failed in block at 0x%x", s.Address)
+		}
+		done += int64(len(buf))
+		opts.Report(firmware.STAGE_VERIFY, done, total)
+	}
+	opts.Report(firmware.STAGE_DONE, total, total)
+	return nil
+}
@@ -0,0 +1,219 @@
+package flip_test
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/richardnwinder/usb/dfu"
+	"github.com/richardnwinder/usb/firmware"
+	"github.com/richardnwinder/usb/flip"
+	"github.com/richardnwinder/usb/hid"
+	"github.com/richardnwinder/usb/usbtest"
+)
+
+// fakeFLIP is a bootloader taking FLIP commands in output reports and
+// answering them in input reports
+type fakeFLIP struct {
+	lock   sync.Mutex
+	id     [3]byte
+	flash  []byte
+	page   uint32
+	pages  []uint32 // pages selected, in order
+	fail   uint8    // bStatus to answer program commands with
+	mem    uint8
+	at     uint32 // where the data of a program command goes
+	want   int    // bytes of it still to come
+	in     [][]byte
+	erases int
+}
+
+func (f *fakeFLIP) reply(p ...byte) {
+	r := make([]byte, 64)
+	copy(r, p)
+	f.in = append(f.in, r)
+}
+
+func (f *fakeFLIP) out(q *usbtest.Request) ([]byte, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	p := q.Data
+	if len(p) != 64 {
+		return nil, syscall.EPROTO
+	}
+	if f.want > 0 {
+		n := min(f.want, 64)
+		copy(f.flash[f.at:], p[:n])
+		f.at += uint32(n)
+		if f.want -= n; f.want == 0 {
+			f.reply(f.fail)
+		}
+		return nil, nil
+	}
+	start := f.page<<16 | uint32(p[2])<<8 | uint32(p[3])
+	end := f.page<<16 | uint32(p[4])<<8 | uint32(p[5])
+	switch {
+	case p[0] == flip.CMD_READ_CONFIG && p[1] == 0x00:
+		f.reply(0, 0x10)
+	case p[0] == flip.CMD_READ_CONFIG && p[1] == 0x01 && p[2] == 0x30:
+		f.reply(0, f.id[0])
+	case p[0] == flip.CMD_READ_CONFIG && p[1] == 0x01 && p[2] == 0x31:
+		f.reply(0, f.id[1])
+	case p[0] == flip.CMD_READ_CONFIG && p[1] == 0x01 && p[2] == 0x60:
+		f.reply(0, f.id[2])
+	case p[0] == flip.CMD_WRITE && p[1] == 0x00 && p[2] == 0xff:
+		for i := range f.flash {
+			f.flash[i] = 0xff
+		}
+		f.erases++
+		f.reply(0)
+	case p[0] == flip.CMD_CHANGE_BASE && p[1] == 0x03:
+		f.page = uint32(p[3])
+		f.pages = append(f.pages, f.page)
+		f.reply(0)
+	case p[0] == flip.CMD_PROG_START:
+		f.mem, f.at, f.want = p[1], start, int(end-start+1)
+	case p[0] == flip.CMD_READ:
+		f.reply(0)
+		for data := f.flash[start : end+1]; len(data) > 0; {
+			n := min(len(data), 64)
+			f.in = append(f.in, data[:n])
+			data = data[n:]
+		}
+	default:
+		f.reply(0x0e) // errUNKNOWN
+	}
+	return nil, nil
+}
+
+func (f *fakeFLIP) input(q *usbtest.Request) ([]byte, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if len(f.in) == 0 {
+		return nil, syscall.ETIMEDOUT
+	}
+	p := f.in[0]
+	f.in = f.in[1:]
+	return p, nil
+}
+
+func open(t *testing.T, f *fakeFLIP) *flip.Device {
+	fx, e := usbtest.ParseHex("flip", `
+12 01 00 02 00 00 00 40  eb 03 ef 2f 00 01 00 00 00 01
+09 02 29 00 01 01 00 80 32
+09 04 00 00 02 03 00 00 00
+09 21 11 01 00 01 22 22 00
+07 05 81 03 40 00 01
+07 05 02 03 40 00 01
+`)
+	if e != nil {
+		t.Fatal(e)
+	}
+	di, e := fx.Info()
+	if e != nil {
+		t.Fatal(e)
+	}
+	d := fx.Device()
+	d.On(usbtest.Bulk(0x02)).Do(f.out)
+	d.On(usbtest.Bulk(0x81)).Do(f.input)
+	h, e := hid.NewDevice(d, &di.Config[0].Interface[0])
+	if e != nil {
+		t.Fatal(e)
+	}
+	dev, e := flip.Open(h)
+	if e != nil {
+		t.Fatal(e)
+	}
+	return dev
+}
+
+// TestProgram writes an image with a block straddling the first 64K page
+// and one past the application flash, which is left out
+func TestProgram(t *testing.T) {
+	f := &fakeFLIP{id: [3]byte{0x1e, 0x97, 0x82}, flash: make([]byte, 0x20000)}
+	d := open(t, f)
+	if d.Version != 0x10 || d.Part == nil || d.Part.Name != "AT90USB1286" || d.FlashSize != 0x1e000 {
+		t.Fatalf("version %#x, part %+v, flash %#x", d.Version, d.Part, d.FlashSize)
+	}
+	low := bytes.Repeat([]byte{1, 2, 3}, 700)
+	high := bytes.Repeat([]byte{4, 5}, 0x30)
+	segs := []firmware.Segment{
+		{Address: 0x100, Data: low},
+		{Address: 0xffd0, Data: high},
+		{Address: 0x1f000, Data: []byte{6}},
+	}
+	var stages []firmware.Stage
+	opts := &firmware.Options{Progress: func(s firmware.Stage, done, total int64) {
+		if len(stages) == 0 || stages[len(stages)-1] != s {
+			stages = append(stages, s)
+		}
+		if s == firmware.STAGE_DONE && done != int64(len(low)+len(high)) {
+			t.Errorf("done at %d bytes, want %d", done, len(low)+len(high))
+		}
+	}}
+	if e := d.Program(segs, opts); e != nil {
+		t.Fatal(e)
+	}
+	if !bytes.Equal(f.flash[0x100:0x100+len(low)], low) || !bytes.Equal(f.flash[0xffd0:0xffd0+len(high)], high) {
+		t.Error("image not in flash")
+	}
+	if f.flash[0xff] != 0xff || f.flash[0x1f000] != 0xff {
+		t.Error("flash written outside the image")
+	}
+	if f.erases != 1 || f.mem != flip.MEM_FLASH {
+		t.Errorf("%d erases, memory %d", f.erases, f.mem)
+	}
+	// page 0, then 1 for the end of the straddling block, and the same
+	// again reading back
+	want := []uint32{0, 1, 0, 1}
+	if len(f.pages) != len(want) {
+		t.Fatalf("pages selected %v, want %v", f.pages, want)
+	}
+	for i := range want {
+		if f.pages[i] != want[i] {
+			t.Fatalf("pages selected %v, want %v", f.pages, want)
+		}
+	}
+	wantStages := []firmware.Stage{firmware.STAGE_ERASE, firmware.STAGE_DOWNLOAD, firmware.STAGE_VERIFY, firmware.STAGE_DONE}
+	if len(stages) != len(wantStages) {
+		t.Fatalf("stages %v, want %v", stages, wantStages)
+	}
+	for i := range wantStages {
+		if stages[i] != wantStages[i] {
+			t.Errorf("stages %v, want %v", stages, wantStages)
+		}
+	}
+}
+
+func TestProgramResume(t *testing.T) {
+	f := &fakeFLIP{id: [3]byte{0x1e, 0x95, 0x87}, flash: make([]byte, 0x8000)}
+	d := open(t, f)
+	image := bytes.Repeat([]byte{0xa5}, 300)
+	copy(f.flash, image[:100])
+	if e := d.Program([]firmware.Segment{{Address: 0, Data: image}}, &firmware.Options{Resume: 100}); e != nil {
+		t.Fatal(e)
+	}
+	if f.erases != 0 || !bytes.Equal(f.flash[:300], image) {
+		t.Errorf("%d erases; flash % x", f.erases, f.flash[:300])
+	}
+}
+
+func TestProgramErrors(t *testing.T) {
+	f := &fakeFLIP{id: [3]byte{0x1e, 0x95, 0x87}, flash: make([]byte, 0x8000), fail: 3}
+	d := open(t, f)
+	e := d.Program([]firmware.Segment{{Address: 0, Data: []byte{1, 2, 3}}}, nil)
+	if !errors.Is(e, dfu.StatusError(3)) {
+		t.Errorf("program: %v, want errWRITE", e)
+	}
+
+	f = &fakeFLIP{id: [3]byte{0x1e, 0x42, 0x42}, flash: make([]byte, 0x100)}
+	d = open(t, f)
+	if d.Part != nil {
+		t.Errorf("unknown part taken for %s", d.Part.Name)
+	}
+	if e := d.Program([]firmware.Segment{{Address: 0, Data: []byte{1}}}, nil); e == nil || f.erases != 0 {
+		t.Errorf("programmed a part of unknown size: %v", e)
+	}
+}
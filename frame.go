@@ -0,0 +1,31 @@
+package usb
+
+// SynchFrame asks an isochronous endpoint which frame number its
+// repeating pattern of packet sizes starts on, so a stream can be lined
+// up with it.  Only endpoints that use implicit synchronisation with a
+// varying pattern support it; others stall.  usbfs doesn't give the
+// current frame number, but each completed isochronous transfer's
+// StartFrame does, and a transfer submitted without URB_FLAG_ISO_ASAP
+// starts in the frame its StartFrame names.
+func (u *Device) SynchFrame(endpoint uint8) (uint16, error) {
+	b := make([]byte, 2)
+	n, e := u.ControlTransfer(REQTYPE_IN|REQTYPE_STANDARD|REQTYPE_ENDPOINT, REQ_SYNCH_FRAME, 0, uint16(endpoint), 2, 1000, b)
+	if e != nil {
+		return 0, e
+	}
+	if n < 2 {
+		return 0, ErrShortPacket
+	}
+	return uint16(b[0]) | uint16(b[1])<<8, nil
+}
+
+// FrameDiff is how many frames b is after a, with the 11-bit frame
+// numbers on the bus and in SYNCH_FRAME wrapping at 2048.  The result is
+// between -1024 and 1023.
+func FrameDiff(a, b uint16) int {
+	d := int(b-a) & 0x7ff
+	if d >= 1024 {
+		d -= 2048
+	}
+	return d
+}
@@ -0,0 +1,23 @@
+package usb
+
+import "testing"
+
+func TestFrameDiff(t *testing.T) {
+	for _, c := range []struct {
+		a, b uint16
+		want int
+	}{
+		{100, 100, 0},
+		{100, 108, 8},
+		{108, 100, -8},
+		{2040, 8, 16}, // across the wrap
+		{8, 2040, -16},
+		{0, 1023, 1023},
+		{0, 1024, -1024},
+		{0x7ff, 0x800, 1}, // only the low 11 bits count
+	} {
+		if got := FrameDiff(c.a, c.b); got != c.want {
+			t.Errorf("FrameDiff(%d, %d) = %d, want %d", c.a, c.b, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package usb
+
+import "sort"
+
+const (
+	CLASS_COMM     = 0x02
+	CLASS_CDC_DATA = 0x0a
+
+	DT_CS_INTERFACE = 0x24
+	CDC_UNION       = 0x06 // functional descriptor subtype
+)
+
+// Function is a set of interfaces that a composite device means to be
+// driven together, such as the control and streaming interfaces of a
+// webcam or the comm and data interfaces of a CDC modem.
+type Function struct {
+	Interfaces []uint8 // ascending
+	Class      uint8
+	SubClass   uint8
+	Protocol   uint8
+	Index      uint8 // iFunction, 0 if none
+}
+
+// Functions groups a configuration's interfaces into functions: by their
+// interface association descriptors, then by CDC union descriptors for
+// devices that predate IADs.  Other interfaces are each a function of
+// their own, with the class of their first alternate setting.  They
+// are in order of their first interface.
+func (ci *ConfigInfo) Functions() []Function {
+	var list []Function
+	taken := make(map[uint8]bool)
+	present := make(map[uint8]*InterfaceInfo)
+	for i := range ci.Interface {
+		ii := &ci.Interface[i]
+		if present[ii.InterfaceNumber] == nil {
+			present[ii.InterfaceNumber] = ii
+		}
+	}
+	add := func(f Function) {
+		sort.Slice(f.Interfaces, func(i, j int) bool { return f.Interfaces[i] < f.Interfaces[j] })
+		for _, n := range f.Interfaces {
+			taken[n] = true
+		}
+		list = append(list, f)
+	}
+	for d := ci.Extra; len(d) >= 2 && d[0] >= 2 && int(d[0]) <= len(d); d = d[d[0]:] {
+		if d[1] != DT_INTERFACE_ASSOC || d[0] < 8 {
+			continue
+		}
+		f := Function{Class: d[4], SubClass: d[5], Protocol: d[6], Index: d[7]}
+		for n := int(d[2]); n < int(d[2])+int(d[3]) && n < 256; n++ {
+			if present[uint8(n)] != nil && !taken[uint8(n)] {
+				f.Interfaces = append(f.Interfaces, uint8(n))
+			}
+		}
+		if len(f.Interfaces) > 0 {
+			add(f)
+		}
+	}
+	for i := range ci.Interface {
+		ii := &ci.Interface[i]
+		if taken[ii.InterfaceNumber] || ii.InterfaceClass != CLASS_COMM {
+			continue
+		}
+		for d := ii.Extra; len(d) >= 2 && d[0] >= 2 && int(d[0]) <= len(d); d = d[d[0]:] {
+			if d[1] != DT_CS_INTERFACE || d[0] < 5 || d[2] != CDC_UNION {
+				continue
+			}
+			f := Function{Class: ii.InterfaceClass, SubClass: ii.InterfaceSubClass, Protocol: ii.InterfaceProtocol}
+			for _, n := range d[3:d[0]] {
+				if present[n] != nil && !taken[n] {
+					f.Interfaces = append(f.Interfaces, n)
+					taken[n] = true
+				}
+			}
+			if len(f.Interfaces) > 0 {
+				add(f)
+			}
+			break
+		}
+	}
+	for i := range ci.Interface {
+		ii := &ci.Interface[i]
+		if taken[ii.InterfaceNumber] {
+			continue
+		}
+		add(Function{Interfaces: []uint8{ii.InterfaceNumber},
+			Class: ii.InterfaceClass, SubClass: ii.InterfaceSubClass, Protocol: ii.InterfaceProtocol})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Interfaces[0] < list[j].Interfaces[0] })
+	return list
+}
+
+// Function returns the function interface ifnum belongs to, or nil.
+func (ci *ConfigInfo) Function(ifnum uint8) *Function {
+	for _, f := range ci.Functions() {
+		for _, n := range f.Interfaces {
+			if n == ifnum {
+				return &f
+			}
+		}
+	}
+	return nil
+}
+
+// Claim claims every interface of the function.  If one can't be
+// claimed, those already claimed are released again and its error is
+// returned, so the function is either wholly claimed or not at all.
+func (f *Function) Claim(u *Device) error {
+	for i, n := range f.Interfaces {
+		if e := u.ClaimInterface(uint32(n)); e != nil {
+			for _, m := range f.Interfaces[:i] {
+				u.ReleaseInterface(uint32(m))
+			}
+			return e
+		}
+	}
+	return nil
+}
+
+// Release releases every interface of the function, returning the first
+// error.
+func (f *Function) Release(u *Device) error {
+	var err error
+	for _, n := range f.Interfaces {
+		if e := u.ReleaseInterface(uint32(n)); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
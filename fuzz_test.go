@@ -0,0 +1,27 @@
+package usb
+
+import "testing"
+
+// FuzzParseDescriptors feeds descriptor parsing, which reads whatever a
+// device chose to send.
+func FuzzParseDescriptors(f *testing.F) {
+	// a device and configuration with one interface and two endpoints
+	f.Add([]byte{
+		0x12, 0x01, 0x00, 0x02, 0x00, 0x00, 0x00, 0x40, 0x34, 0x12, 0x78, 0x56, 0x00, 0x01, 0x01, 0x02, 0x03, 0x01,
+		0x09, 0x02, 0x20, 0x00, 0x01, 0x01, 0x00, 0x80, 0x32,
+		0x09, 0x04, 0x00, 0x00, 0x02, 0xff, 0x00, 0x00, 0x00,
+		0x07, 0x05, 0x81, 0x02, 0x00, 0x02, 0x00,
+		0x07, 0x05, 0x02, 0x02, 0x00, 0x02, 0x00,
+	})
+	// a BOS with USB 2.0 extension and SuperSpeed capabilities
+	f.Add([]byte{
+		0x05, 0x0f, 0x16, 0x00, 0x02,
+		0x07, 0x10, 0x02, 0x02, 0x00, 0x00, 0x00,
+		0x0a, 0x10, 0x03, 0x00, 0x0e, 0x00, 0x01, 0x0a, 0xff, 0x07,
+	})
+	f.Add([]byte{0x00, 0x01})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		ParseDescriptors(data)
+		ParseBOS(data)
+	})
+}
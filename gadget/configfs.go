@@ -0,0 +1,283 @@
+package gadget
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+const CONFIGFS = "/sys/kernel/config/usb_gadget/"
+
+// Gadget is a gadget composed through configfs from the kernel's
+// function drivers (hid, acm, mass_storage, ncm...) and FunctionFS.  It
+// has one configuration.  Creating one needs root and the libcomposite
+// module.
+type Gadget struct {
+	Name    string
+	dir     string
+	funcs   []string // function directories, in the order added
+	started []starter
+	cleanup []func() // run after unbinding, before the functions go
+	bound   bool
+}
+
+// starter is a function that has work to do once the gadget is bound,
+// such as opening its device node
+type starter interface {
+	start() error
+	stop()
+}
+
+func writeAttr(path string, value string) error {
+	return os.WriteFile(path, []byte(value), 0644)
+}
+
+// NewGadget creates gadget name in configfs with device descriptor d.
+// Only the device level fields of d are used; the functions bring their
+// own interfaces.
+func NewGadget(name string, d *Device) (*Gadget, error) {
+	g := &Gadget{Name: name, dir: CONFIGFS + name}
+	if e := os.Mkdir(g.dir, 0755); e != nil {
+		return nil, e
+	}
+	power := 100
+	cname := "c"
+	if len(d.Configs) > 0 {
+		power, cname = d.Configs[0].MaxPowerMA, d.Configs[0].Name
+	}
+	usbv := d.USBVersion
+	if usbv == 0 {
+		usbv = 0x0200
+	}
+	attrs := [][2]string{
+		{"idVendor", fmt.Sprintf("0x%04x", d.VendorID)},
+		{"idProduct", fmt.Sprintf("0x%04x", d.ProductID)},
+		{"bcdDevice", fmt.Sprintf("0x%04x", d.Version)},
+		{"bcdUSB", fmt.Sprintf("0x%04x", usbv)},
+		{"bDeviceClass", fmt.Sprint(d.Class)},
+		{"bDeviceSubClass", fmt.Sprint(d.SubClass)},
+		{"bDeviceProtocol", fmt.Sprint(d.Protocol)},
+		{"strings/0x409/manufacturer", d.Manufacturer},
+		{"strings/0x409/product", d.Product},
+		{"strings/0x409/serialnumber", d.Serial},
+		{"configs/c.1/MaxPower", fmt.Sprint(power)},
+		{"configs/c.1/strings/0x409/configuration", cname},
+	}
+	e := os.Mkdir(g.dir+"/strings/0x409", 0755)
+	if e == nil {
+		e = os.Mkdir(g.dir+"/configs/c.1", 0755)
+	}
+	if e == nil {
+		e = os.Mkdir(g.dir+"/configs/c.1/strings/0x409", 0755)
+	}
+	for _, a := range attrs {
+		if e != nil {
+			break
+		}
+		e = writeAttr(g.dir+"/"+a[0], a[1])
+	}
+	if e != nil {
+		g.Remove()
+		return nil, e
+	}
+	return g, nil
+}
+
+// addFunction creates function kind.instance and links it into the
+// configuration; set writes its attributes before the link is made,
+// since most are fixed once the function is in use
+func (g *Gadget) addFunction(kind string, instance string, attrs [][2]string) (string, error) {
+	if g.bound {
+		return "", syscall.EBUSY
+	}
+	fn := kind + "." + instance
+	dir := g.dir + "/functions/" + fn
+	if e := os.Mkdir(dir, 0755); e != nil {
+		return "", e
+	}
+	g.funcs = append(g.funcs, fn)
+	for _, a := range attrs {
+		if e := writeAttr(dir+"/"+a[0], a[1]); e != nil {
+			return "", fmt.Errorf("gadget: %s/%s: %w", fn, a[0], e)
+		}
+	}
+	if e := os.Symlink(dir, g.dir+"/configs/c.1/"+fn); e != nil {
+		return "", e
+	}
+	return dir, nil
+}
+
+// UDCs lists the device controllers a gadget can be bound to.
+func UDCs() ([]string, error) {
+	m, e := filepath.Glob("/sys/class/udc/*")
+	if e != nil {
+		return nil, e
+	}
+	for i := range m {
+		m[i] = filepath.Base(m[i])
+	}
+	return m, nil
+}
+
+// Bind attaches the gadget to controller udc, or the first one if udc is
+// empty, which makes it visible to the host.
+func (g *Gadget) Bind(udc string) error {
+	if udc == "" {
+		list, e := UDCs()
+		if e != nil {
+			return e
+		}
+		if len(list) == 0 {
+			return syscall.ENODEV
+		}
+		udc = list[0]
+	}
+	if e := writeAttr(g.dir+"/UDC", udc); e != nil {
+		return e
+	}
+	g.bound = true
+	for _, s := range g.started {
+		if e := s.start(); e != nil {
+			g.Unbind()
+			return e
+		}
+	}
+	return nil
+}
+
+// Unbind detaches the gadget from its controller.
+func (g *Gadget) Unbind() error {
+	if !g.bound {
+		return nil
+	}
+	for _, s := range g.started {
+		s.stop()
+	}
+	g.bound = false
+	return writeAttr(g.dir+"/UDC", "\n")
+}
+
+// Remove unbinds the gadget and deletes it from configfs.
+func (g *Gadget) Remove() error {
+	g.Unbind()
+	for _, f := range g.cleanup {
+		f()
+	}
+	os.Remove(g.dir + "/os_desc/c.1")
+	for i := len(g.funcs) - 1; i >= 0; i-- {
+		os.Remove(g.dir + "/configs/c.1/" + g.funcs[i])
+		os.Remove(g.dir + "/functions/" + g.funcs[i])
+	}
+	os.Remove(g.dir + "/configs/c.1/strings/0x409")
+	os.Remove(g.dir + "/configs/c.1")
+	os.Remove(g.dir + "/strings/0x409")
+	return os.Remove(g.dir)
+}
+
+// SetOSDescriptors turns on Microsoft OS descriptors, which let Windows
+// load drivers such as WinUSB or NCM without an INF.  vendorCode is the
+// request Windows uses to fetch them.
+func (g *Gadget) SetOSDescriptors(vendorCode uint8, sign string) error {
+	if sign == "" {
+		sign = "MSFT100"
+	}
+	e := writeAttr(g.dir+"/os_desc/use", "1")
+	if e == nil {
+		e = writeAttr(g.dir+"/os_desc/b_vendor_code", fmt.Sprintf("0x%02x", vendorCode))
+	}
+	if e == nil {
+		e = writeAttr(g.dir+"/os_desc/qw_sign", sign)
+	}
+	if e == nil {
+		e = os.Symlink(g.dir+"/configs/c.1", g.dir+"/os_desc/c.1")
+	}
+	return e
+}
+
+// CompatibleID sets the Microsoft compatible and sub-compatible IDs of
+// every interface of a kernel function, such as "WINNCM" for ncm or
+// "RNDIS" for rndis.  function is the directory name, kind.instance.
+func (g *Gadget) CompatibleID(function string, id string, subID string) error {
+	dirs, _ := filepath.Glob(g.dir + "/functions/" + function + "/os_desc/interface.*")
+	if len(dirs) == 0 {
+		return fmt.Errorf("gadget: %s has no OS descriptor interfaces", function)
+	}
+	for _, d := range dirs {
+		if e := writeAttr(d+"/compatible_id", id); e != nil {
+			return e
+		}
+		if subID != "" {
+			if e := writeAttr(d+"/sub_compatible_id", subID); e != nil {
+				return e
+			}
+		}
+	}
+	return nil
+}
+
+// RemoveGadget tears down gadget name as found in configfs, whoever made
+// it, such as one left behind by a process that died.  The FunctionFS
+// instances it used are unmounted.
+func RemoveGadget(name string) error {
+	dir := CONFIGFS + name
+	if _, e := os.Stat(dir); e != nil {
+		return e
+	}
+	writeAttr(dir+"/UDC", "\n")
+	if m, e := os.ReadFile("/proc/self/mounts"); e == nil {
+		for _, l := range strings.Split(string(m), "\n") {
+			f := strings.Fields(l)
+			if len(f) > 2 && f[2] == "functionfs" {
+				if _, e := os.Stat(dir + "/functions/ffs." + f[0]); e == nil {
+					syscall.Unmount(f[1], 0)
+				}
+			}
+		}
+	}
+	os.Remove(dir + "/os_desc/c.1")
+	configs, _ := filepath.Glob(dir + "/configs/*")
+	for _, c := range configs {
+		links, _ := filepath.Glob(c + "/*.*")
+		for _, l := range links {
+			if fi, e := os.Lstat(l); e == nil && fi.Mode()&os.ModeSymlink != 0 {
+				os.Remove(l)
+			}
+		}
+		langs, _ := filepath.Glob(c + "/strings/*")
+		for _, l := range langs {
+			os.Remove(l)
+		}
+		os.Remove(c)
+	}
+	funcs, _ := filepath.Glob(dir + "/functions/*")
+	for _, f := range funcs {
+		os.Remove(f)
+	}
+	langs, _ := filepath.Glob(dir + "/strings/*")
+	for _, l := range langs {
+		os.Remove(l)
+	}
+	return os.Remove(dir)
+}
+
+// devNode finds the /dev node for a function's "dev" attribute, which
+// holds major:minor
+func devNode(fdir string) (string, error) {
+	mm := readAttr(fdir + "/dev")
+	if mm == "" {
+		return "", syscall.ENODEV
+	}
+	for _, l := range strings.Split(readAttr("/sys/dev/char/"+mm+"/uevent"), "\n") {
+		if v, ok := strings.CutPrefix(l, "DEVNAME="); ok {
+			return "/dev/" + v, nil
+		}
+	}
+	return "", syscall.ENODEV
+}
+
+func readAttr(path string) string {
+	b, _ := os.ReadFile(path)
+	return strings.TrimSpace(string(b))
+}
@@ -0,0 +1,531 @@
+// Package gadget builds and runs USB devices on the Linux gadget stack:
+// descriptor construction, configfs composition and FunctionFS functions.
+package gadget
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+	"time"
+	"unicode/utf16"
+
+	"github.com/richardnwinder/usb"
+)
+
+type Speed int
+
+const (
+	SPEED_FULL Speed = iota
+	SPEED_HIGH
+	SPEED_SUPER
+)
+
+const DT_SS_ENDPOINT_COMPANION = 0x30
+
+// Endpoint describes one endpoint of an interface.  Address is assigned
+// when the endpoint is added: numbers count up from 1 separately for
+// each direction.
+type Endpoint struct {
+	Address uint8
+	Type    uint8 // usb.ENDPOINT_XFER_*
+	// MaxPacket of 0 picks the largest the transfer type allows at each
+	// speed, which is what bulk endpoints want.
+	MaxPacket uint16
+	// Interval is the polling period for interrupt and isochronous
+	// endpoints, rounded down to what each speed can express.
+	Interval time.Duration
+	MaxBurst uint8  // SuperSpeed bursts, 0..15
+	Extra    []byte // class-specific descriptors after the endpoint
+}
+
+type Interface struct {
+	Number    uint8
+	Alt       uint8
+	Class     uint8
+	SubClass  uint8
+	Protocol  uint8
+	Name      string
+	Extra     []byte // class-specific descriptors before the endpoints
+	Endpoints []*Endpoint
+	f         *Function
+}
+
+type association struct {
+	first, count              uint8
+	class, subclass, protocol uint8
+	name                      string
+}
+
+// Function is a set of interfaces.  On its own it is what FunctionFS
+// wants; a Config is a Function plus the configuration header.
+type Function struct {
+	Interfaces []*Interface
+	assocs     []association
+	nextIn     uint8
+	nextOut    uint8
+}
+
+// Interface adds the next interface number.
+func (f *Function) Interface(class uint8, subclass uint8, protocol uint8, name string) *Interface {
+	n := uint8(0)
+	for _, i := range f.Interfaces {
+		n = max(n, i.Number+1)
+	}
+	i := &Interface{Number: n, Class: class, SubClass: subclass, Protocol: protocol, Name: name, f: f}
+	f.Interfaces = append(f.Interfaces, i)
+	return i
+}
+
+// AltSetting adds the next alternate setting of interface i, with no
+// endpoints.
+func (i *Interface) AltSetting() *Interface {
+	alt := uint8(0)
+	for _, x := range i.f.Interfaces {
+		if x.Number == i.Number {
+			alt = max(alt, x.Alt+1)
+		}
+	}
+	a := &Interface{Number: i.Number, Alt: alt, Class: i.Class, SubClass: i.SubClass,
+		Protocol: i.Protocol, Name: i.Name, f: i.f}
+	i.f.Interfaces = append(i.f.Interfaces, a)
+	return a
+}
+
+// Endpoint adds an endpoint.  Alternate settings of one interface
+// normally reuse addresses, so an alternate setting takes the address
+// of the matching endpoint of setting 0 when it has one.
+func (i *Interface) Endpoint(in bool, kind uint8, maxPacket uint16, interval time.Duration) *Endpoint {
+	ep := &Endpoint{Type: kind, MaxPacket: maxPacket, Interval: interval}
+	if i.Alt > 0 {
+		for _, x := range i.f.Interfaces {
+			if x.Number == i.Number && x.Alt == 0 && len(x.Endpoints) > len(i.Endpoints) {
+				ep.Address = x.Endpoints[len(i.Endpoints)].Address
+			}
+		}
+	}
+	if ep.Address == 0 {
+		if in {
+			i.f.nextIn++
+			ep.Address = usb.ENDPOINT_IN | i.f.nextIn
+		} else {
+			i.f.nextOut++
+			ep.Address = i.f.nextOut
+		}
+	}
+	i.Endpoints = append(i.Endpoints, ep)
+	return ep
+}
+
+// Associate groups count interfaces starting at first into one function
+// with an interface association descriptor, as CDC and UVC need.
+func (f *Function) Associate(first *Interface, count int, class uint8, subclass uint8, protocol uint8, name string) {
+	f.assocs = append(f.assocs, association{first.Number, uint8(count), class, subclass, protocol, name})
+}
+
+// strtab assigns string descriptor indexes in order of first use
+type strtab struct {
+	list []string
+	idx  map[string]uint8
+}
+
+func (t *strtab) index(s string) uint8 {
+	if s == "" {
+		return 0
+	}
+	if t.idx == nil {
+		t.idx = map[string]uint8{}
+	}
+	if n, ok := t.idx[s]; ok {
+		return n
+	}
+	t.list = append(t.list, s)
+	t.idx[s] = uint8(len(t.list))
+	return t.idx[s]
+}
+
+func (t *strtab) validate() error {
+	if len(t.list) > 254 {
+		return fmt.Errorf("gadget: %d strings, at most 254 fit", len(t.list))
+	}
+	for _, s := range t.list {
+		if len(utf16.Encode([]rune(s))) > 126 {
+			return fmt.Errorf("gadget: string %q too long for a descriptor", s)
+		}
+	}
+	return nil
+}
+
+// limit is the largest wMaxPacketSize for a type at a speed
+func limit(kind uint8, speed Speed) uint16 {
+	switch {
+	case speed == SPEED_SUPER:
+		return 1024
+	case kind == usb.ENDPOINT_XFER_CONTROL, kind == usb.ENDPOINT_XFER_BULK:
+		if speed == SPEED_HIGH {
+			return 512
+		}
+		return 64
+	case kind == usb.ENDPOINT_XFER_INT:
+		if speed == SPEED_HIGH {
+			return 1024
+		}
+		return 64
+	default:
+		if speed == SPEED_HIGH {
+			return 1024
+		}
+		return 1023
+	}
+}
+
+func (ep *Endpoint) packet(speed Speed) (uint16, error) {
+	max := limit(ep.Type, speed)
+	mp := ep.MaxPacket
+	if mp == 0 || ep.Type == usb.ENDPOINT_XFER_BULK && speed != SPEED_FULL && mp < max {
+		// high speed bulk must be exactly 512, SuperSpeed 1024
+		mp = max
+	}
+	mp = min(mp, max)
+	if ep.Type == usb.ENDPOINT_XFER_BULK && speed == SPEED_FULL && (mp < 8 || bits.OnesCount16(mp) != 1) {
+		return 0, fmt.Errorf("gadget: endpoint 0x%02x: full speed bulk packets are 8, 16, 32 or 64 bytes", ep.Address)
+	}
+	return mp, nil
+}
+
+// interval encodes Interval: milliseconds at full speed for interrupt
+// endpoints, 2^(n-1) (micro)frames otherwise
+func (ep *Endpoint) interval(speed Speed) uint8 {
+	if ep.Type != usb.ENDPOINT_XFER_INT && ep.Type != usb.ENDPOINT_XFER_ISOC {
+		return 0
+	}
+	if speed == SPEED_FULL && ep.Type == usb.ENDPOINT_XFER_INT {
+		return uint8(min(max(ep.Interval.Milliseconds(), 1), 255))
+	}
+	unit := time.Millisecond
+	if speed != SPEED_FULL {
+		unit = 125 * time.Microsecond
+	}
+	n := max(int64(ep.Interval/unit), 1)
+	return uint8(min(bits.Len64(uint64(n)), 16))
+}
+
+func (ep *Endpoint) write(b *bytes.Buffer, speed Speed) error {
+	mp, e := ep.packet(speed)
+	if e != nil {
+		return e
+	}
+	b.Write([]byte{usb.DT_ENDPOINT_SIZE, usb.DT_ENDPOINT, ep.Address, ep.Type, byte(mp), byte(mp >> 8), ep.interval(speed)})
+	if speed == SPEED_SUPER {
+		var bpi uint16
+		if ep.Type == usb.ENDPOINT_XFER_INT || ep.Type == usb.ENDPOINT_XFER_ISOC {
+			bpi = mp * uint16(ep.MaxBurst+1)
+		}
+		b.Write([]byte{6, DT_SS_ENDPOINT_COMPANION, ep.MaxBurst, 0, byte(bpi), byte(bpi >> 8)})
+	}
+	b.Write(ep.Extra)
+	return nil
+}
+
+func (f *Function) validate() error {
+	in, out := map[uint8]bool{}, map[uint8]bool{}
+	for n, i := range f.Interfaces {
+		if i.Number > 0 && n > 0 && i.Number > f.Interfaces[n-1].Number+1 {
+			return fmt.Errorf("gadget: interface numbers skip %d", f.Interfaces[n-1].Number+1)
+		}
+		for _, ep := range i.Endpoints {
+			if ep.Address&0x0f == 0 || ep.Address&0x70 != 0 {
+				return fmt.Errorf("gadget: bad endpoint address 0x%02x", ep.Address)
+			}
+			if ep.Type == usb.ENDPOINT_XFER_CONTROL {
+				return fmt.Errorf("gadget: endpoint 0x%02x: only ep0 is a control endpoint", ep.Address)
+			}
+			if ep.MaxBurst > 15 {
+				return fmt.Errorf("gadget: endpoint 0x%02x: MaxBurst over 15", ep.Address)
+			}
+			// alternate settings may reuse an address
+			if i.Alt != 0 {
+				continue
+			}
+			seen := out
+			if ep.Address&usb.ENDPOINT_IN != 0 {
+				seen = in
+			}
+			if seen[ep.Address] {
+				return fmt.Errorf("gadget: endpoint 0x%02x used twice", ep.Address)
+			}
+			seen[ep.Address] = true
+		}
+	}
+	return nil
+}
+
+// descriptors writes the interface level descriptors for speed, returning
+// how many descriptors there are
+func (f *Function) descriptors(b *bytes.Buffer, speed Speed, strs *strtab) (int, error) {
+	if e := f.validate(); e != nil {
+		return 0, e
+	}
+	count := 0
+	for _, i := range f.Interfaces {
+		for _, a := range f.assocs {
+			if a.first == i.Number && i.Alt == 0 {
+				b.Write([]byte{8, usb.DT_INTERFACE_ASSOC, a.first, a.count, a.class, a.subclass, a.protocol, strs.index(a.name)})
+				count++
+			}
+		}
+		b.Write([]byte{usb.DT_INTERFACE_SIZE, usb.DT_INTERFACE, i.Number, i.Alt, uint8(len(i.Endpoints)),
+			i.Class, i.SubClass, i.Protocol, strs.index(i.Name)})
+		count += 1 + countDescs(i.Extra)
+		b.Write(i.Extra)
+		for _, ep := range i.Endpoints {
+			if e := ep.write(b, speed); e != nil {
+				return 0, e
+			}
+			count += 1 + countDescs(ep.Extra)
+			if speed == SPEED_SUPER {
+				count++
+			}
+		}
+	}
+	return count, nil
+}
+
+func countDescs(d []byte) int {
+	n := 0
+	for len(d) >= 2 && d[0] >= 2 && int(d[0]) <= len(d) {
+		n++
+		d = d[d[0]:]
+	}
+	return n
+}
+
+// Descriptors returns the function's interface, endpoint and class
+// descriptors for speed, with string indexes counting from 1 in order of
+// first use.
+func (f *Function) Descriptors(speed Speed) ([]byte, error) {
+	var b bytes.Buffer
+	_, e := f.descriptors(&b, speed, &strtab{})
+	return b.Bytes(), e
+}
+
+// FunctionFS ep0 blob formats
+const (
+	FUNCTIONFS_DESCRIPTORS_MAGIC_V2 = 3
+	FUNCTIONFS_STRINGS_MAGIC        = 2
+	FUNCTIONFS_HAS_FS_DESC          = 1
+	FUNCTIONFS_HAS_HS_DESC          = 2
+	FUNCTIONFS_HAS_SS_DESC          = 4
+)
+
+// FunctionFS returns the descriptor and string blobs to write to a
+// FunctionFS ep0, with full, high and SuperSpeed descriptor sets.  The
+// endpoint files appear in the order the endpoints were added.
+func (f *Function) FunctionFS() ([]byte, []byte, error) {
+	var sets [3]bytes.Buffer
+	var counts [3]int
+	strs := &strtab{}
+	for s := SPEED_FULL; s <= SPEED_SUPER; s++ {
+		n, e := f.descriptors(&sets[s], s, strs)
+		if e != nil {
+			return nil, nil, e
+		}
+		counts[s] = n
+	}
+	if e := strs.validate(); e != nil {
+		return nil, nil, e
+	}
+	var d bytes.Buffer
+	total := 4*6 + sets[0].Len() + sets[1].Len() + sets[2].Len()
+	binary.Write(&d, binary.LittleEndian, []uint32{
+		FUNCTIONFS_DESCRIPTORS_MAGIC_V2, uint32(total),
+		FUNCTIONFS_HAS_FS_DESC | FUNCTIONFS_HAS_HS_DESC | FUNCTIONFS_HAS_SS_DESC,
+		uint32(counts[0]), uint32(counts[1]), uint32(counts[2]),
+	})
+	for i := range sets {
+		d.Write(sets[i].Bytes())
+	}
+	var body bytes.Buffer
+	binary.Write(&body, binary.LittleEndian, uint16(0x409))
+	for _, s := range strs.list {
+		body.WriteString(s)
+		body.WriteByte(0)
+	}
+	var s bytes.Buffer
+	binary.Write(&s, binary.LittleEndian, []uint32{FUNCTIONFS_STRINGS_MAGIC, uint32(16 + body.Len()), uint32(len(strs.list)), 1})
+	if len(strs.list) == 0 {
+		// no languages either
+		s.Reset()
+		binary.Write(&s, binary.LittleEndian, []uint32{FUNCTIONFS_STRINGS_MAGIC, 16, 0, 0})
+	} else {
+		s.Write(body.Bytes())
+	}
+	return d.Bytes(), s.Bytes(), nil
+}
+
+// Config is one configuration of a Device.
+type Config struct {
+	Function
+	Value        uint8 // bConfigurationValue, from 1
+	Name         string
+	SelfPowered  bool
+	RemoteWakeup bool
+	MaxPowerMA   int
+}
+
+// Device describes a whole device, for backends such as raw-gadget that
+// need the device and configuration descriptors too.
+type Device struct {
+	USBVersion   uint16 // bcd; 0 picks 2.00, or 3.20 for SuperSpeed
+	Class        uint8
+	SubClass     uint8
+	Protocol     uint8
+	MaxPacket0   uint8 // 0 picks the largest for the speed
+	VendorID     uint16
+	ProductID    uint16
+	Version      uint16 // bcdDevice
+	Manufacturer string
+	Product      string
+	Serial       string
+	Configs      []*Config
+	strs         *strtab
+}
+
+func NewDevice(vid uint16, pid uint16) *Device {
+	return &Device{VendorID: vid, ProductID: pid}
+}
+
+// Config adds the next configuration.
+func (d *Device) Config(name string, maxPowerMA int) *Config {
+	c := &Config{Value: uint8(len(d.Configs) + 1), Name: name, MaxPowerMA: maxPowerMA}
+	d.Configs = append(d.Configs, c)
+	return c
+}
+
+// strings assigns every string its index in a fixed order, so that the
+// descriptors agree with each other whichever is built first
+func (d *Device) strings() (*strtab, error) {
+	t := &strtab{}
+	t.index(d.Manufacturer)
+	t.index(d.Product)
+	t.index(d.Serial)
+	for _, c := range d.Configs {
+		t.index(c.Name)
+		var b bytes.Buffer
+		if _, e := c.descriptors(&b, SPEED_HIGH, t); e != nil {
+			return nil, e
+		}
+	}
+	return t, t.validate()
+}
+
+// DeviceDescriptor returns the device descriptor for speed.
+func (d *Device) DeviceDescriptor(speed Speed) ([]byte, error) {
+	t, e := d.strings()
+	if e != nil {
+		return nil, e
+	}
+	if len(d.Configs) == 0 {
+		return nil, fmt.Errorf("gadget: device has no configurations")
+	}
+	bcdUSB, mp0 := d.USBVersion, d.MaxPacket0
+	if bcdUSB == 0 {
+		bcdUSB = 0x0200
+		if speed == SPEED_SUPER {
+			bcdUSB = 0x0320
+		}
+	}
+	switch {
+	case speed == SPEED_SUPER:
+		mp0 = 9 // 2^9
+	case mp0 == 0:
+		mp0 = 64
+	case mp0 != 8 && mp0 != 16 && mp0 != 32 && mp0 != 64 || speed == SPEED_HIGH && mp0 != 64:
+		return nil, fmt.Errorf("gadget: bad bMaxPacketSize0 %d", mp0)
+	}
+	return []byte{usb.DT_DEVICE_SIZE, usb.DT_DEVICE, byte(bcdUSB), byte(bcdUSB >> 8),
+		d.Class, d.SubClass, d.Protocol, mp0,
+		byte(d.VendorID), byte(d.VendorID >> 8), byte(d.ProductID), byte(d.ProductID >> 8),
+		byte(d.Version), byte(d.Version >> 8),
+		t.index(d.Manufacturer), t.index(d.Product), t.index(d.Serial), uint8(len(d.Configs))}, nil
+}
+
+// ConfigDescriptor returns configuration index (from 0) with everything
+// below it, as GET_DESCRIPTOR(CONFIG) answers.
+func (d *Device) ConfigDescriptor(index int, speed Speed) ([]byte, error) {
+	return d.config(index, speed, usb.DT_CONFIG)
+}
+
+// OtherSpeedConfig returns configuration index as it would be at the
+// other of full and high speed.
+func (d *Device) OtherSpeedConfig(index int, speed Speed) ([]byte, error) {
+	other := SPEED_FULL
+	if speed == SPEED_FULL {
+		other = SPEED_HIGH
+	}
+	return d.config(index, other, usb.DT_OTHER_SPEED_CONFIG)
+}
+
+func (d *Device) config(index int, speed Speed, kind uint8) ([]byte, error) {
+	t, e := d.strings()
+	if e != nil {
+		return nil, e
+	}
+	if index < 0 || index >= len(d.Configs) {
+		return nil, fmt.Errorf("gadget: no configuration %d", index)
+	}
+	c := d.Configs[index]
+	var body bytes.Buffer
+	if _, e := c.descriptors(&body, speed, t); e != nil {
+		return nil, e
+	}
+	total := usb.DT_CONFIG_SIZE + body.Len()
+	if total > 0xffff {
+		return nil, fmt.Errorf("gadget: configuration %d is %d bytes, over wTotalLength's limit", index, total)
+	}
+	nifc := 0
+	for _, i := range c.Interfaces {
+		if i.Alt == 0 {
+			nifc++
+		}
+	}
+	attr := uint8(0x80)
+	if c.SelfPowered {
+		attr |= 0x40
+	}
+	if c.RemoteWakeup {
+		attr |= 0x20
+	}
+	unit := 2
+	if speed == SPEED_SUPER {
+		unit = 8
+	}
+	power := (c.MaxPowerMA + unit - 1) / unit
+	if power > 255 {
+		return nil, fmt.Errorf("gadget: %dmA is more than a configuration can draw", c.MaxPowerMA)
+	}
+	h := []byte{usb.DT_CONFIG_SIZE, kind, byte(total), byte(total >> 8), uint8(nifc), c.Value,
+		t.index(c.Name), attr, uint8(power)}
+	return append(h, body.Bytes()...), nil
+}
+
+// StringDescriptor returns string index in US English; index 0 is the
+// language list.
+func (d *Device) StringDescriptor(index uint8) ([]byte, error) {
+	t, e := d.strings()
+	if e != nil {
+		return nil, e
+	}
+	if index == 0 {
+		return []byte{4, usb.DT_STRING, 0x09, 0x04}, nil
+	}
+	if int(index) > len(t.list) {
+		return nil, fmt.Errorf("gadget: no string %d", index)
+	}
+	u := utf16.Encode([]rune(t.list[index-1]))
+	b := []byte{uint8(2 + 2*len(u)), usb.DT_STRING}
+	for _, c := range u {
+		b = binary.LittleEndian.AppendUint16(b, c)
+	}
+	return b, nil
+}
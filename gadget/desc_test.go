@@ -0,0 +1,297 @@
+package gadget_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/richardnwinder/usb"
+	"github.com/richardnwinder/usb/gadget"
+)
+
+// unhex reads descriptor bytes laid out one per line
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, e := hex.DecodeString(strings.Join(strings.Fields(s), ""))
+	if e != nil {
+		t.Fatal(e)
+	}
+	return b
+}
+
+// bulkPair is a vendor function with a bulk pipe each way and an
+// interrupt endpoint on a second interface that shares the first's name
+func bulkPair() *gadget.Function {
+	f := &gadget.Function{}
+	i := f.Interface(0xff, 0, 0, "Data")
+	i.Endpoint(true, usb.ENDPOINT_XFER_BULK, 0, 0)
+	i.Endpoint(false, usb.ENDPOINT_XFER_BULK, 0, 0)
+	n := f.Interface(0xff, 1, 0, "Data")
+	n.Endpoint(true, usb.ENDPOINT_XFER_INT, 16, 4*time.Millisecond)
+	return f
+}
+
+func TestFunctionFS(t *testing.T) {
+	desc, strs, e := bulkPair().FunctionFS()
+	if e != nil {
+		t.Fatal(e)
+	}
+	want := unhex(t, `
+03000000 9f000000 07000000 05000000 05000000 08000000
+
+09 04 00 00 02 ff 00 00 01
+07 05 81 02 40 00 00
+07 05 01 02 40 00 00
+09 04 01 00 01 ff 01 00 01
+07 05 82 03 10 00 04
+
+09 04 00 00 02 ff 00 00 01
+07 05 81 02 00 02 00
+07 05 01 02 00 02 00
+09 04 01 00 01 ff 01 00 01
+07 05 82 03 10 00 06
+
+09 04 00 00 02 ff 00 00 01
+07 05 81 02 00 04 00  06 30 00 00 00 00
+07 05 01 02 00 04 00  06 30 00 00 00 00
+09 04 01 00 01 ff 01 00 01
+07 05 82 03 10 00 06  06 30 00 00 10 00
+`)
+	if !bytes.Equal(desc, want) {
+		t.Errorf("descriptors\n% x\nwant\n% x", desc, want)
+	}
+	// one string, in one language
+	want = unhex(t, "02000000 17000000 01000000 01000000  0904 44617461 00")
+	if !bytes.Equal(strs, want) {
+		t.Errorf("strings % x, want % x", strs, want)
+	}
+
+	// no strings at all leaves out the language too
+	f := &gadget.Function{}
+	f.Interface(0xff, 0, 0, "").Endpoint(true, usb.ENDPOINT_XFER_BULK, 0, 0)
+	_, strs, e = f.FunctionFS()
+	if want := unhex(t, "02000000 10000000 00000000 00000000"); e != nil || !bytes.Equal(strs, want) {
+		t.Errorf("no strings: % x, %v; want % x", strs, e, want)
+	}
+}
+
+func TestConfigDescriptor(t *testing.T) {
+	d := gadget.NewDevice(0x1234, 0x5678)
+	d.Manufacturer, d.Product = "Acme", "Widget"
+	c := d.Config("Default", 100)
+	c.SelfPowered = true
+	ctl := c.Interface(0x02, 0x02, 0x01, "Widget")
+	ctl.Extra = []byte{5, 0x24, 0x00, 0x10, 0x01}
+	ctl.Endpoint(true, usb.ENDPOINT_XFER_INT, 8, 16*time.Millisecond)
+	data := c.Interface(0x0a, 0, 0, "")
+	data.Endpoint(true, usb.ENDPOINT_XFER_BULK, 0, 0).MaxBurst = 4
+	data.Endpoint(false, usb.ENDPOINT_XFER_BULK, 0, 0)
+	c.Associate(ctl, 2, 0x02, 0x02, 0x01, "Serial")
+	// an alternate setting reuses the first setting's address
+	data.AltSetting().Endpoint(true, usb.ENDPOINT_XFER_BULK, 0, 0)
+
+	// strings are numbered device first, then in the order the
+	// configuration uses them; the interface reuses the product's
+	for _, c := range []struct {
+		speed gadget.Speed
+		want  string
+	}{
+		{gadget.SPEED_FULL, `
+09 02 4d 00 02 01 03 c0 32
+08 0b 00 02 02 02 01 04
+09 04 00 00 01 02 02 01 02
+05 24 00 10 01
+07 05 81 03 08 00 10
+09 04 01 00 02 0a 00 00 00
+07 05 82 02 40 00 00
+07 05 01 02 40 00 00
+09 04 01 01 01 0a 00 00 00
+07 05 82 02 40 00 00
+`},
+		{gadget.SPEED_HIGH, `
+09 02 4d 00 02 01 03 c0 32
+08 0b 00 02 02 02 01 04
+09 04 00 00 01 02 02 01 02
+05 24 00 10 01
+07 05 81 03 08 00 08
+09 04 01 00 02 0a 00 00 00
+07 05 82 02 00 02 00
+07 05 01 02 00 02 00
+09 04 01 01 01 0a 00 00 00
+07 05 82 02 00 02 00
+`},
+		// SuperSpeed counts power in 8mA units
+		{gadget.SPEED_SUPER, `
+09 02 65 00 02 01 03 c0 0d
+08 0b 00 02 02 02 01 04
+09 04 00 00 01 02 02 01 02
+05 24 00 10 01
+07 05 81 03 08 00 08  06 30 00 00 08 00
+09 04 01 00 02 0a 00 00 00
+07 05 82 02 00 04 00  06 30 04 00 00 00
+07 05 01 02 00 04 00  06 30 00 00 00 00
+09 04 01 01 01 0a 00 00 00
+07 05 82 02 00 04 00  06 30 00 00 00 00
+`},
+	} {
+		got, e := d.ConfigDescriptor(0, c.speed)
+		if e != nil {
+			t.Fatal(e)
+		}
+		if want := unhex(t, c.want); !bytes.Equal(got, want) {
+			t.Errorf("speed %d:\n% x\nwant\n% x", c.speed, got, want)
+		}
+	}
+
+	other, e := d.OtherSpeedConfig(0, gadget.SPEED_HIGH)
+	full, _ := d.ConfigDescriptor(0, gadget.SPEED_FULL)
+	if e != nil || other[1] != usb.DT_OTHER_SPEED_CONFIG || !bytes.Equal(other[2:], full[2:]) {
+		t.Errorf("other speed configuration % x, %v", other, e)
+	}
+
+	dev, e := d.DeviceDescriptor(gadget.SPEED_HIGH)
+	if want := unhex(t, "12 01 00 02 00 00 00 40 34 12 78 56 00 00 01 02 00 01"); e != nil || !bytes.Equal(dev, want) {
+		t.Errorf("device descriptor % x, %v; want % x", dev, e, want)
+	}
+	for _, c := range []struct {
+		index uint8
+		want  string
+	}{
+		{0, "04 03 09 04"},
+		{2, "0e 03 57 00 69 00 64 00 67 00 65 00 74 00"},
+		{4, "0e 03 53 00 65 00 72 00 69 00 61 00 6c 00"},
+	} {
+		got, e := d.StringDescriptor(c.index)
+		if want := unhex(t, c.want); e != nil || !bytes.Equal(got, want) {
+			t.Errorf("string %d: % x, %v; want % x", c.index, got, e, want)
+		}
+	}
+	if _, e := d.StringDescriptor(5); e == nil {
+		t.Error("string 5 of 4 found")
+	}
+}
+
+func TestInterval(t *testing.T) {
+	for _, c := range []struct {
+		kind     uint8
+		speed    gadget.Speed
+		interval time.Duration
+		want     uint8
+	}{
+		// full speed interrupt endpoints count milliseconds
+		{usb.ENDPOINT_XFER_INT, gadget.SPEED_FULL, 0, 1},
+		{usb.ENDPOINT_XFER_INT, gadget.SPEED_FULL, 10 * time.Millisecond, 10},
+		{usb.ENDPOINT_XFER_INT, gadget.SPEED_FULL, time.Second, 255},
+		// everything else is 2^(n-1) frames or microframes, rounded down
+		{usb.ENDPOINT_XFER_INT, gadget.SPEED_HIGH, 125 * time.Microsecond, 1},
+		{usb.ENDPOINT_XFER_INT, gadget.SPEED_HIGH, time.Millisecond, 4},
+		{usb.ENDPOINT_XFER_INT, gadget.SPEED_HIGH, 3 * time.Millisecond, 5},
+		{usb.ENDPOINT_XFER_INT, gadget.SPEED_HIGH, 10 * time.Second, 16},
+		{usb.ENDPOINT_XFER_INT, gadget.SPEED_SUPER, 0, 1},
+		{usb.ENDPOINT_XFER_ISOC, gadget.SPEED_FULL, time.Millisecond, 1},
+		{usb.ENDPOINT_XFER_ISOC, gadget.SPEED_FULL, 8 * time.Millisecond, 4},
+		{usb.ENDPOINT_XFER_ISOC, gadget.SPEED_HIGH, time.Millisecond, 4},
+		{usb.ENDPOINT_XFER_ISOC, gadget.SPEED_SUPER, 125 * time.Microsecond, 1},
+		// bulk endpoints have none
+		{usb.ENDPOINT_XFER_BULK, gadget.SPEED_HIGH, time.Millisecond, 0},
+	} {
+		f := &gadget.Function{}
+		f.Interface(0xff, 0, 0, "").Endpoint(true, c.kind, 64, c.interval)
+		b, e := f.Descriptors(c.speed)
+		if e != nil {
+			t.Fatal(e)
+		}
+		if got := b[usb.DT_INTERFACE_SIZE+6]; got != c.want {
+			t.Errorf("type %d at speed %d every %v: bInterval %d, want %d", c.kind, c.speed, c.interval, got, c.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	bulk := func(f *gadget.Function) *gadget.Interface {
+		i := f.Interface(0xff, 0, 0, "")
+		i.Endpoint(true, usb.ENDPOINT_XFER_BULK, 0, 0)
+		return i
+	}
+	for _, c := range []struct {
+		name  string
+		build func(f *gadget.Function)
+		want  string
+	}{
+		{"duplicate endpoint", func(f *gadget.Function) {
+			i := bulk(f)
+			i.Endpoints = append(i.Endpoints, &gadget.Endpoint{Address: 0x81, Type: usb.ENDPOINT_XFER_BULK})
+		}, "endpoint 0x81 used twice"},
+		{"duplicate across interfaces", func(f *gadget.Function) {
+			bulk(f)
+			f.Interface(0xff, 0, 0, "").Endpoints = []*gadget.Endpoint{{Address: 0x81, Type: usb.ENDPOINT_XFER_INT}}
+		}, "endpoint 0x81 used twice"},
+		{"full speed bulk of 48", func(f *gadget.Function) {
+			f.Interface(0xff, 0, 0, "").Endpoint(false, usb.ENDPOINT_XFER_BULK, 48, 0)
+		}, "endpoint 0x01: full speed bulk packets"},
+		{"full speed bulk of 4", func(f *gadget.Function) {
+			f.Interface(0xff, 0, 0, "").Endpoint(false, usb.ENDPOINT_XFER_BULK, 4, 0)
+		}, "endpoint 0x01: full speed bulk packets"},
+		{"control endpoint", func(f *gadget.Function) {
+			f.Interface(0xff, 0, 0, "").Endpoint(true, usb.ENDPOINT_XFER_CONTROL, 64, 0)
+		}, "only ep0 is a control endpoint"},
+		{"bad address", func(f *gadget.Function) {
+			bulk(f).Endpoints[0].Address = 0x90
+		}, "bad endpoint address 0x90"},
+		{"burst", func(f *gadget.Function) {
+			bulk(f).Endpoints[0].MaxBurst = 16
+		}, "MaxBurst over 15"},
+		{"interface numbers", func(f *gadget.Function) {
+			bulk(f)
+			f.Interfaces = append(f.Interfaces, &gadget.Interface{Number: 2})
+		}, "interface numbers skip 1"},
+		{"long string", func(f *gadget.Function) {
+			f.Interface(0xff, 0, 0, strings.Repeat("x", 127))
+		}, "too long for a descriptor"},
+	} {
+		f := &gadget.Function{}
+		c.build(f)
+		_, _, e := f.FunctionFS()
+		if e == nil || !strings.Contains(e.Error(), c.want) {
+			t.Errorf("%s: %v, want %q", c.name, e, c.want)
+		}
+	}
+
+	// a full speed size is only checked at full speed
+	f := &gadget.Function{}
+	f.Interface(0xff, 0, 0, "").Endpoint(true, usb.ENDPOINT_XFER_BULK, 48, 0)
+	if b, e := f.Descriptors(gadget.SPEED_HIGH); e != nil || b[usb.DT_INTERFACE_SIZE+4] != 0 || b[usb.DT_INTERFACE_SIZE+5] != 2 {
+		t.Errorf("high speed bulk of 48: % x, %v; want 512", b, e)
+	}
+}
+
+func TestDeviceValidate(t *testing.T) {
+	d := gadget.NewDevice(0x1234, 0x5678)
+	if _, e := d.DeviceDescriptor(gadget.SPEED_HIGH); e == nil {
+		t.Error("device with no configurations")
+	}
+	c := d.Config("", 600)
+	if _, e := d.ConfigDescriptor(0, gadget.SPEED_HIGH); e == nil {
+		t.Error("600mA at high speed")
+	}
+	if b, e := d.ConfigDescriptor(0, gadget.SPEED_SUPER); e != nil || b[8] != 75 {
+		t.Errorf("600mA at SuperSpeed: % x, %v", b, e)
+	}
+	c.MaxPowerMA = 100
+	if _, e := d.ConfigDescriptor(1, gadget.SPEED_HIGH); e == nil {
+		t.Error("configuration 1 of 1")
+	}
+	d.MaxPacket0 = 32
+	if _, e := d.DeviceDescriptor(gadget.SPEED_FULL); e != nil {
+		t.Errorf("32 byte ep0 at full speed: %v", e)
+	}
+	if _, e := d.DeviceDescriptor(gadget.SPEED_HIGH); e == nil {
+		t.Error("32 byte ep0 at high speed")
+	}
+	d.MaxPacket0 = 0
+	if b, e := d.DeviceDescriptor(gadget.SPEED_SUPER); e != nil || b[2] != 0x20 || b[3] != 0x03 || b[7] != 9 {
+		t.Errorf("SuperSpeed device descriptor % x, %v", b, e)
+	}
+}
@@ -0,0 +1,131 @@
+package gadget
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+type EthernetOptions struct {
+	// Protocol is "ncm" (the default, and what current Windows, macOS
+	// and Linux hosts all drive) or "ecm" for older hosts.
+	Protocol string
+	DevAddr  net.HardwareAddr // our side's MAC; nil lets the kernel pick
+	HostAddr net.HardwareAddr // the host's MAC
+}
+
+// Ethernet is a network function from the kernel's ncm or ecm driver.
+// On our side it is a network interface named Interface, which can be
+// given an address, bridged or routed like any other, for instance onto
+// a TAP device.  Applications that want the frames themselves use
+// ReadFrame and WriteFrame, which go through a packet socket on it.
+type Ethernet struct {
+	Interface string
+	dir       string
+	sock      *os.File
+}
+
+// AddEthernet adds a network function.
+func (g *Gadget) AddEthernet(instance string, opts EthernetOptions) (*Ethernet, error) {
+	kind := opts.Protocol
+	if kind == "" {
+		kind = "ncm"
+	}
+	if kind != "ncm" && kind != "ecm" {
+		return nil, fmt.Errorf("gadget: unknown ethernet protocol %q", kind)
+	}
+	var attrs [][2]string
+	if opts.DevAddr != nil {
+		attrs = append(attrs, [2]string{"dev_addr", opts.DevAddr.String()})
+	}
+	if opts.HostAddr != nil {
+		attrs = append(attrs, [2]string{"host_addr", opts.HostAddr.String()})
+	}
+	dir, e := g.addFunction(kind, instance, attrs)
+	if e != nil {
+		return nil, e
+	}
+	n := &Ethernet{dir: dir}
+	g.started = append(g.started, n)
+	return n, nil
+}
+
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
+
+// ifreq for SIOCGIFFLAGS and SIOCSIFFLAGS
+type ifreq struct {
+	name  [16]byte
+	flags uint16
+	_     [22]byte
+}
+
+// up sets IFF_UP on the interface
+func up(name string) error {
+	fd, e := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, 0)
+	if e != nil {
+		return e
+	}
+	defer syscall.Close(fd)
+	var r ifreq
+	copy(r.name[:], name)
+	if _, _, e := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.SIOCGIFFLAGS, uintptr(unsafe.Pointer(&r))); e != 0 {
+		return e
+	}
+	r.flags |= syscall.IFF_UP
+	if _, _, e := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.SIOCSIFFLAGS, uintptr(unsafe.Pointer(&r))); e != 0 {
+		return e
+	}
+	return nil
+}
+
+// the interface only gets its final name once the function is bound
+func (n *Ethernet) start() error {
+	n.Interface = readAttr(n.dir + "/ifname")
+	ifc, e := net.InterfaceByName(n.Interface)
+	if e != nil {
+		return e
+	}
+	if e = up(n.Interface); e != nil {
+		return e
+	}
+	fd, e := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC|syscall.SOCK_NONBLOCK, int(htons(syscall.ETH_P_ALL)))
+	if e != nil {
+		return e
+	}
+	if e = syscall.Bind(fd, &syscall.SockaddrLinklayer{Protocol: htons(syscall.ETH_P_ALL), Ifindex: ifc.Index}); e != nil {
+		syscall.Close(fd)
+		return e
+	}
+	n.sock = os.NewFile(uintptr(fd), "packet:"+n.Interface)
+	return nil
+}
+
+func (n *Ethernet) stop() {
+	if n.sock != nil {
+		n.sock.Close()
+		n.sock = nil
+	}
+}
+
+// ReadFrame reads the next Ethernet frame the host sent, without the
+// FCS.  It returns os.ErrClosed once the gadget is unbound.
+func (n *Ethernet) ReadFrame(buf []byte) (int, error) {
+	if n.sock == nil {
+		return 0, os.ErrClosed
+	}
+	return n.sock.Read(buf)
+}
+
+// WriteFrame sends one Ethernet frame, starting with the destination
+// MAC, to the host.
+func (n *Ethernet) WriteFrame(frame []byte) error {
+	if n.sock == nil {
+		return os.ErrClosed
+	}
+	_, e := n.sock.Write(frame)
+	return e
+}
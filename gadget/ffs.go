@@ -0,0 +1,290 @@
+package gadget
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/richardnwinder/usb"
+)
+
+// functionfs ep0 events
+const (
+	FUNCTIONFS_BIND    = 0
+	FUNCTIONFS_UNBIND  = 1
+	FUNCTIONFS_ENABLE  = 2
+	FUNCTIONFS_DISABLE = 3
+	FUNCTIONFS_SETUP   = 4
+	FUNCTIONFS_SUSPEND = 5
+	FUNCTIONFS_RESUME  = 6
+)
+
+var eventNames = []string{"BIND", "UNBIND", "ENABLE", "DISABLE", "SETUP", "SUSPEND", "RESUME"}
+
+// Event is one event read from ep0.  A SETUP event must be answered
+// with exactly one of Reply, Receive or Stall before the next event is
+// read; the kernel holds the control transfer until then.
+type Event struct {
+	Type  uint8              // FUNCTIONFS_*
+	Setup usb.ControlRequest // for FUNCTIONFS_SETUP
+	fs    *FunctionFS
+	once  sync.Once
+	done  chan struct{}
+}
+
+func (ev *Event) String() string {
+	if int(ev.Type) >= len(eventNames) {
+		return "event " + strconv.Itoa(int(ev.Type))
+	}
+	if ev.Type == FUNCTIONFS_SETUP {
+		return "SETUP " + ev.Setup.String()
+	}
+	return eventNames[ev.Type]
+}
+
+func (ev *Event) answered() {
+	ev.once.Do(func() {
+		if ev.done != nil {
+			close(ev.done)
+		}
+	})
+}
+
+// Reply answers an IN request with data, trimmed to what the host asked
+// for.
+func (ev *Event) Reply(data []byte) error {
+	defer ev.answered()
+	if ev.Setup.RequestType&usb.REQTYPE_IN == 0 {
+		return syscall.EINVAL
+	}
+	if len(data) > int(ev.Setup.Length) {
+		data = data[:ev.Setup.Length]
+	}
+	if len(data) == 0 {
+		return ev.fs.zero(false)
+	}
+	_, e := ev.fs.ep0.Write(data)
+	return e
+}
+
+// Receive accepts an OUT request, returning its data stage.
+func (ev *Event) Receive() ([]byte, error) {
+	defer ev.answered()
+	if ev.Setup.RequestType&usb.REQTYPE_IN != 0 {
+		return nil, syscall.EINVAL
+	}
+	if ev.Setup.Length == 0 {
+		return nil, ev.fs.zero(true)
+	}
+	buf := make([]byte, ev.Setup.Length)
+	n, e := ev.fs.ep0.Read(buf)
+	return buf[:n], e
+}
+
+// Stall refuses the request.
+func (ev *Event) Stall() error {
+	defer ev.answered()
+	// going against the request's direction stalls it
+	return ev.fs.zero(ev.Setup.RequestType&usb.REQTYPE_IN != 0)
+}
+
+// FunctionFS is a FunctionFS instance: ep0 plus one file per endpoint,
+// in the order the endpoints were added to the Function.  Events from
+// ep0 arrive on Events, which is closed by Close.
+type FunctionFS struct {
+	Events    <-chan *Event
+	Endpoints []*os.File
+
+	mnt     string // ours to unmount, if we mounted it
+	ep0     *os.File
+	events  chan *Event
+	handler func(*Event) // instead of Events, for the built-in functions
+	quit    chan struct{}
+	exit    chan struct{}
+	closing sync.Once
+	down    atomic.Bool // unbinding: ESHUTDOWN is final
+}
+
+// OpenFunctionFS writes f's descriptors to the FunctionFS mounted at dir
+// and opens its endpoints.
+func OpenFunctionFS(dir string, f *Function) (*FunctionFS, error) {
+	return openFFS(dir, f, nil)
+}
+
+func openFFS(dir string, f *Function, handler func(*Event)) (*FunctionFS, error) {
+	desc, strs, e := f.FunctionFS()
+	if e != nil {
+		return nil, e
+	}
+	x := &FunctionFS{handler: handler, quit: make(chan struct{}), exit: make(chan struct{})}
+	if x.ep0, e = os.OpenFile(dir+"/ep0", os.O_RDWR, 0); e != nil {
+		return nil, e
+	}
+	_, e = x.ep0.Write(desc)
+	if e == nil {
+		_, e = x.ep0.Write(strs)
+	}
+	// one file per address; alternate settings share them
+	seen := map[uint8]bool{}
+	for _, i := range f.Interfaces {
+		for _, ep := range i.Endpoints {
+			if e != nil || seen[ep.Address] {
+				continue
+			}
+			seen[ep.Address] = true
+			var epf *os.File
+			epf, e = os.OpenFile(dir+"/ep"+strconv.Itoa(len(x.Endpoints)+1), os.O_RDWR, 0)
+			if e == nil {
+				x.Endpoints = append(x.Endpoints, epf)
+			}
+		}
+	}
+	if e != nil {
+		close(x.exit)
+		x.Close()
+		return nil, e
+	}
+	if handler == nil {
+		x.events = make(chan *Event, 16)
+		x.Events = x.events
+	}
+	go x.run()
+	return x, nil
+}
+
+// AddFunctionFS adds a FunctionFS function serving f, mounting its
+// filesystem somewhere temporary.
+func (g *Gadget) AddFunctionFS(instance string, f *Function) (*FunctionFS, error) {
+	return g.addFFS(instance, f, nil)
+}
+
+func (g *Gadget) addFFS(instance string, f *Function, handler func(*Event)) (*FunctionFS, error) {
+	if _, e := g.addFunction("ffs", instance, nil); e != nil {
+		return nil, e
+	}
+	mnt, e := os.MkdirTemp("", "ffs")
+	if e != nil {
+		return nil, e
+	}
+	if e = syscall.Mount(instance, mnt, "functionfs", 0, ""); e != nil {
+		os.Remove(mnt)
+		return nil, e
+	}
+	x, e := openFFS(mnt, f, handler)
+	if e != nil {
+		syscall.Unmount(mnt, 0)
+		os.Remove(mnt)
+		return nil, e
+	}
+	x.mnt = mnt
+	g.cleanup = append(g.cleanup, func() { x.Close() })
+	g.started = append(g.started, x)
+	return x, nil
+}
+
+func (x *FunctionFS) start() error {
+	x.down.Store(false)
+	return nil
+}
+
+func (x *FunctionFS) stop() {
+	x.down.Store(true)
+}
+
+// Shutdown reports whether e is an endpoint error that only means the
+// host deconfigured the function, after which I/O waits for the next
+// ENABLE.  During unbinding it is final.
+func (x *FunctionFS) Shutdown(e error) bool {
+	return errors.Is(e, syscall.ESHUTDOWN) && !x.down.Load()
+}
+
+func (x *FunctionFS) run() {
+	defer close(x.exit)
+	if x.events != nil {
+		defer close(x.events)
+	}
+	var b [12]byte
+	for {
+		if _, e := io.ReadFull(x.ep0, b[:]); e != nil {
+			return
+		}
+		ev := &Event{Type: b[8], fs: x}
+		if ev.Type == FUNCTIONFS_SETUP {
+			ev.Setup = usb.ControlRequest{
+				RequestType: b[0],
+				Request:     b[1],
+				Value:       binary.LittleEndian.Uint16(b[2:]),
+				Index:       binary.LittleEndian.Uint16(b[4:]),
+				Length:      binary.LittleEndian.Uint16(b[6:]),
+			}
+		}
+		switch {
+		case x.handler != nil:
+			x.handler(ev)
+			if ev.Type == FUNCTIONFS_SETUP {
+				ev.answered()
+			}
+		default:
+			if ev.Type == FUNCTIONFS_SETUP {
+				ev.done = make(chan struct{})
+			}
+			select {
+			case x.events <- ev:
+			case <-x.quit:
+				return
+			}
+			if ev.done == nil {
+				continue
+			}
+			select {
+			case <-ev.done:
+			case <-x.quit:
+				return
+			}
+		}
+	}
+}
+
+// zero does a zero-length read or write on ep0, which os.File would
+// skip.  Reading acks an OUT request with no data stage; going against
+// the request's direction stalls it.
+func (x *FunctionFS) zero(read bool) error {
+	rc, e := x.ep0.SyscallConn()
+	if e != nil {
+		return e
+	}
+	var err error
+	rc.Control(func(fd uintptr) {
+		if read {
+			_, err = syscall.Read(int(fd), nil)
+		} else {
+			_, err = syscall.Write(int(fd), nil)
+		}
+	})
+	return err
+}
+
+// Close closes ep0 and the endpoint files, and unmounts the filesystem
+// if AddFunctionFS mounted it.
+func (x *FunctionFS) Close() error {
+	var e error
+	x.closing.Do(func() {
+		close(x.quit)
+		e = x.ep0.Close()
+	})
+	<-x.exit
+	for _, f := range x.Endpoints {
+		f.Close()
+	}
+	if x.mnt != "" {
+		syscall.Unmount(x.mnt, 0)
+		os.Remove(x.mnt)
+		x.mnt = ""
+	}
+	return e
+}
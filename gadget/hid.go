@@ -0,0 +1,163 @@
+package gadget
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// report descriptors for the boot protocol devices
+var (
+	KeyboardReportDesc = []byte{
+		0x05, 0x01, 0x09, 0x06, 0xa1, 0x01, 0x05, 0x07, 0x19, 0xe0, 0x29, 0xe7,
+		0x15, 0x00, 0x25, 0x01, 0x75, 0x01, 0x95, 0x08, 0x81, 0x02, 0x95, 0x01,
+		0x75, 0x08, 0x81, 0x03, 0x95, 0x05, 0x75, 0x01, 0x05, 0x08, 0x19, 0x01,
+		0x29, 0x05, 0x91, 0x02, 0x95, 0x01, 0x75, 0x03, 0x91, 0x03, 0x95, 0x06,
+		0x75, 0x08, 0x15, 0x00, 0x25, 0x65, 0x05, 0x07, 0x19, 0x00, 0x29, 0x65,
+		0x81, 0x00, 0xc0,
+	}
+	MouseReportDesc = []byte{
+		0x05, 0x01, 0x09, 0x02, 0xa1, 0x01, 0x09, 0x01, 0xa1, 0x00, 0x05, 0x09,
+		0x19, 0x01, 0x29, 0x03, 0x15, 0x00, 0x25, 0x01, 0x95, 0x03, 0x75, 0x01,
+		0x81, 0x02, 0x95, 0x01, 0x75, 0x05, 0x81, 0x03, 0x05, 0x01, 0x09, 0x30,
+		0x09, 0x31, 0x15, 0x81, 0x25, 0x7f, 0x75, 0x08, 0x95, 0x02, 0x81, 0x06,
+		0xc0, 0xc0,
+	}
+)
+
+type HIDOptions struct {
+	ReportDesc   []byte
+	ReportLength int   // largest report in bytes
+	SubClass     uint8 // 1 for boot interface
+	Protocol     uint8 // boot protocol: 1 keyboard, 2 mouse
+	// NoOutEndpoint makes output reports arrive as SET_REPORT on ep0
+	// instead; the kernel delivers them the same way.
+	NoOutEndpoint bool
+}
+
+func KeyboardOptions() HIDOptions {
+	return HIDOptions{ReportDesc: KeyboardReportDesc, ReportLength: 8, SubClass: 1, Protocol: 1}
+}
+
+func MouseOptions() HIDOptions {
+	return HIDOptions{ReportDesc: MouseReportDesc, ReportLength: 3, SubClass: 1, Protocol: 2}
+}
+
+// HID is a HID function backed by the kernel's f_hid.  Once the gadget is
+// bound, reports sent on Input go to the host as it polls for them, and
+// output reports from the host (keyboard LEDs, for instance) arrive on
+// Output; they are dropped if nobody is receiving.
+type HID struct {
+	Input  chan<- []byte
+	Output <-chan []byte
+	in     chan []byte
+	out    chan []byte
+	dir    string
+	f      *os.File
+	quit   chan struct{}
+	wg     sync.WaitGroup
+	lock   sync.Mutex
+	err    error
+}
+
+// AddHID adds a HID function.
+func (g *Gadget) AddHID(instance string, opts HIDOptions) (*HID, error) {
+	if len(opts.ReportDesc) == 0 || opts.ReportLength <= 0 {
+		return nil, fmt.Errorf("gadget: HID needs a report descriptor and length")
+	}
+	attrs := [][2]string{
+		{"protocol", fmt.Sprint(opts.Protocol)},
+		{"subclass", fmt.Sprint(opts.SubClass)},
+		{"report_length", fmt.Sprint(opts.ReportLength)},
+		{"report_desc", string(opts.ReportDesc)},
+	}
+	if opts.NoOutEndpoint {
+		attrs = append(attrs, [2]string{"no_out_endpoint", "1"})
+	}
+	dir, e := g.addFunction("hid", instance, attrs)
+	if e != nil {
+		return nil, e
+	}
+	h := &HID{dir: dir, in: make(chan []byte), out: make(chan []byte, 16)}
+	h.Input, h.Output = h.in, h.out
+	g.started = append(g.started, h)
+	return h, nil
+}
+
+func (h *HID) start() error {
+	node, e := devNode(h.dir)
+	if e != nil {
+		return e
+	}
+	if h.f, e = os.OpenFile(node, os.O_RDWR, 0); e != nil {
+		return e
+	}
+	h.quit = make(chan struct{})
+	h.wg.Add(2)
+	go h.writer()
+	go h.reader()
+	return nil
+}
+
+func (h *HID) writer() {
+	defer h.wg.Done()
+	for {
+		select {
+		case r := <-h.in:
+			if _, e := h.f.Write(r); e != nil {
+				h.fail(e)
+				return
+			}
+		case <-h.quit:
+			return
+		}
+	}
+}
+
+func (h *HID) reader() {
+	defer h.wg.Done()
+	buf := make([]byte, 1024)
+	for {
+		n, e := h.f.Read(buf)
+		if e != nil {
+			h.fail(e)
+			return
+		}
+		select {
+		case h.out <- append([]byte(nil), buf[:n]...):
+		default:
+			// nobody is listening for LEDs; don't hold up the host
+		}
+	}
+}
+
+func (h *HID) fail(e error) {
+	h.lock.Lock()
+	if h.err == nil {
+		h.err = e
+	}
+	h.lock.Unlock()
+}
+
+// Send queues one input report.  It blocks while the previous report is
+// waiting for the host to poll.
+func (h *HID) Send(report []byte) error {
+	if e := h.Err(); e != nil {
+		return e
+	}
+	h.in <- report
+	return nil
+}
+
+// Err returns the error that stopped report delivery, if any.
+func (h *HID) Err() error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.err
+}
+
+func (h *HID) stop() {
+	close(h.quit)
+	h.f.Close()
+	h.wg.Wait()
+}
@@ -0,0 +1,239 @@
+package gadget
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Hex is a number that JSON may give either as a number or as a string
+// such as "0x1d6b".
+type Hex uint16
+
+func (h *Hex) UnmarshalJSON(b []byte) error {
+	s := strings.Trim(string(b), `"`)
+	v, e := strconv.ParseUint(s, 0, 16)
+	if e != nil {
+		return fmt.Errorf("gadget: bad number %s", b)
+	}
+	*h = Hex(v)
+	return nil
+}
+
+// Spec is a whole gadget as a document:
+//
+//	{
+//		"name": "pi", "vendor_id": "0x1d6b", "product_id": "0x0104",
+//		"manufacturer": "me", "product": "widget", "serial": "1",
+//		"os_descriptors": {"vendor_code": 205},
+//		"functions": [
+//			{"type": "ncm", "instance": "usb0", "compatible_id": "WINNCM"},
+//			{"type": "hid", "instance": "kbd", "preset": "keyboard"},
+//			{"type": "mass_storage", "instance": "disk", "file": "disk.img"}
+//		]
+//	}
+type Spec struct {
+	Name          string         `json:"name"`
+	VendorID      Hex            `json:"vendor_id"`
+	ProductID     Hex            `json:"product_id"`
+	Version       Hex            `json:"version"`
+	USBVersion    Hex            `json:"usb_version"`
+	Class         Hex            `json:"class"`
+	SubClass      Hex            `json:"subclass"`
+	Protocol      Hex            `json:"protocol"`
+	Manufacturer  string         `json:"manufacturer"`
+	Product       string         `json:"product"`
+	Serial        string         `json:"serial"`
+	Configuration string         `json:"configuration"`
+	MaxPowerMA    int            `json:"max_power_ma"`
+	UDC           string         `json:"udc"` // empty picks the first
+	OSDescriptors *OSDescSpec    `json:"os_descriptors"`
+	Functions     []FunctionSpec `json:"functions"`
+}
+
+type OSDescSpec struct {
+	VendorCode uint8  `json:"vendor_code"`
+	Sign       string `json:"sign"`
+}
+
+// FunctionSpec is one function.  Type is hid, serial (the Go CDC-ACM),
+// acm (the kernel's, a /dev/ttyGS port), ncm, ecm, mass_storage, or the
+// name of any other kernel function, whose Attrs are written as given.
+type FunctionSpec struct {
+	Type     string `json:"type"`
+	Instance string `json:"instance"`
+
+	// hid
+	Preset       string `json:"preset"`      // keyboard or mouse
+	ReportDesc   string `json:"report_desc"` // hex, spaces allowed
+	ReportLength int    `json:"report_length"`
+	HIDSubClass  uint8  `json:"hid_subclass"`
+	HIDProtocol  uint8  `json:"hid_protocol"`
+
+	// ncm, ecm
+	DevAddr      string `json:"dev_addr"`
+	HostAddr     string `json:"host_addr"`
+	CompatibleID string `json:"compatible_id"`
+	SubCompatID  string `json:"sub_compatible_id"`
+
+	// mass_storage
+	File      string `json:"file"`
+	ReadOnly  bool   `json:"read_only"`
+	Removable bool   `json:"removable"`
+
+	Attrs map[string]string `json:"attrs"`
+}
+
+// LoadSpec reads a Spec from a JSON file.
+func LoadSpec(path string) (*Spec, error) {
+	b, e := os.ReadFile(path)
+	if e != nil {
+		return nil, e
+	}
+	s := &Spec{}
+	if e = json.Unmarshal(b, s); e != nil {
+		return nil, fmt.Errorf("gadget: %s: %w", path, e)
+	}
+	return s, nil
+}
+
+// Composite is a gadget built from a Spec.  Functions holds what each
+// function instance returned: *HID, *Serial, *Ethernet or *MassStorage,
+// or nil for plain kernel functions.
+type Composite struct {
+	*Gadget
+	Functions map[string]any
+	files     []*os.File
+}
+
+// Start builds the gadget and binds it.  A gadget of the same name
+// already in configfs is removed first, so editing the document and
+// starting it again reconfigures the device.
+func (s *Spec) Start() (*Composite, error) {
+	if s.Name == "" {
+		return nil, fmt.Errorf("gadget: spec has no name")
+	}
+	RemoveGadget(s.Name)
+	d := NewDevice(uint16(s.VendorID), uint16(s.ProductID))
+	d.Version, d.USBVersion = uint16(s.Version), uint16(s.USBVersion)
+	d.Class, d.SubClass, d.Protocol = uint8(s.Class), uint8(s.SubClass), uint8(s.Protocol)
+	d.Manufacturer, d.Product, d.Serial = s.Manufacturer, s.Product, s.Serial
+	if s.MaxPowerMA != 0 || s.Configuration != "" {
+		d.Config(s.Configuration, s.MaxPowerMA)
+	}
+	g, e := NewGadget(s.Name, d)
+	if e != nil {
+		return nil, e
+	}
+	c := &Composite{Gadget: g, Functions: map[string]any{}}
+	if e = c.build(s); e == nil {
+		e = g.Bind(s.UDC)
+	}
+	if e != nil {
+		c.Stop()
+		return nil, e
+	}
+	return c, nil
+}
+
+func (c *Composite) build(s *Spec) error {
+	if s.OSDescriptors != nil {
+		if e := c.SetOSDescriptors(s.OSDescriptors.VendorCode, s.OSDescriptors.Sign); e != nil {
+			return e
+		}
+	}
+	for n, f := range s.Functions {
+		if f.Instance == "" {
+			f.Instance = fmt.Sprintf("%s%d", f.Type, n)
+		}
+		if _, ok := c.Functions[f.Instance]; ok {
+			return fmt.Errorf("gadget: instance %q used twice", f.Instance)
+		}
+		v, e := c.function(&f)
+		if e != nil {
+			return fmt.Errorf("gadget: %s.%s: %w", f.Type, f.Instance, e)
+		}
+		c.Functions[f.Instance] = v
+		if f.CompatibleID != "" {
+			if e := c.CompatibleID(f.Type+"."+f.Instance, f.CompatibleID, f.SubCompatID); e != nil {
+				return e
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Composite) function(f *FunctionSpec) (any, error) {
+	switch f.Type {
+	case "hid":
+		var opts HIDOptions
+		switch f.Preset {
+		case "keyboard":
+			opts = KeyboardOptions()
+		case "mouse":
+			opts = MouseOptions()
+		case "":
+			desc, e := hex.DecodeString(strings.Join(strings.Fields(f.ReportDesc), ""))
+			if e != nil {
+				return nil, e
+			}
+			opts = HIDOptions{ReportDesc: desc, ReportLength: f.ReportLength,
+				SubClass: f.HIDSubClass, Protocol: f.HIDProtocol}
+		default:
+			return nil, fmt.Errorf("unknown preset %q", f.Preset)
+		}
+		return c.AddHID(f.Instance, opts)
+	case "serial":
+		return c.AddSerial(f.Instance)
+	case "ncm", "ecm":
+		opts := EthernetOptions{Protocol: f.Type}
+		var e error
+		if f.DevAddr != "" {
+			if opts.DevAddr, e = net.ParseMAC(f.DevAddr); e != nil {
+				return nil, e
+			}
+		}
+		if f.HostAddr != "" {
+			if opts.HostAddr, e = net.ParseMAC(f.HostAddr); e != nil {
+				return nil, e
+			}
+		}
+		return c.AddEthernet(f.Instance, opts)
+	case "mass_storage":
+		flag := os.O_RDWR
+		if f.ReadOnly {
+			flag = os.O_RDONLY
+		}
+		file, e := os.OpenFile(f.File, flag, 0)
+		if e != nil {
+			return nil, e
+		}
+		c.files = append(c.files, file)
+		return c.AddMassStorage(f.Instance, file, StorageOptions{ReadOnly: f.ReadOnly, Removable: f.Removable})
+	}
+	var attrs [][2]string
+	for k, v := range f.Attrs {
+		attrs = append(attrs, [2]string{k, v})
+	}
+	_, e := c.addFunction(f.Type, f.Instance, attrs)
+	return nil, e
+}
+
+// Stop unbinds and removes the gadget.
+func (c *Composite) Stop() error {
+	e := c.Remove()
+	for _, f := range c.files {
+		f.Close()
+	}
+	return e
+}
+
+// Reload stops c and starts s in its place.
+func (c *Composite) Reload(s *Spec) (*Composite, error) {
+	c.Stop()
+	return s.Start()
+}
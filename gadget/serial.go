@@ -0,0 +1,178 @@
+package gadget
+
+import (
+	"encoding/binary"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/richardnwinder/usb"
+)
+
+// CDC class requests and notifications
+const (
+	CDC_SET_LINE_CODING        = 0x20
+	CDC_GET_LINE_CODING        = 0x21
+	CDC_SET_CONTROL_LINE_STATE = 0x22
+	CDC_SEND_BREAK             = 0x23
+	CDC_SERIAL_STATE           = 0x20
+
+	// SerialState bits
+	SERIAL_DCD     = 0x01
+	SERIAL_DSR     = 0x02
+	SERIAL_BREAK   = 0x04
+	SERIAL_RING    = 0x08
+	SERIAL_FRAMING = 0x10
+	SERIAL_PARITY  = 0x20
+	SERIAL_OVERRUN = 0x40
+)
+
+// LineCoding is what the host's terminal settings look like on the wire.
+type LineCoding struct {
+	Baud     uint32
+	StopBits uint8 // 0: 1, 1: 1.5, 2: 2
+	Parity   uint8 // 0 none, 1 odd, 2 even, 3 mark, 4 space
+	DataBits uint8
+}
+
+// Serial is a CDC-ACM function served from Go over FunctionFS, so that
+// the line settings the host picks are visible, which the kernel's acm
+// function keeps to itself.  The host sees a /dev/ttyACM port; Read and
+// Write carry its data.  The callbacks run on the ep0 event loop and
+// should be set before the gadget is bound.
+type Serial struct {
+	OnLineCoding  func(LineCoding)
+	OnControlLine func(dtr bool, rts bool)
+	OnBreak       func(d time.Duration) // 0 ends the break, -1 is until further notice
+
+	x      *FunctionFS
+	ifc    uint8
+	lock   sync.Mutex
+	coding LineCoding
+	closed bool
+}
+
+// AddSerial adds a serial port function.  It should be the first
+// function added: the CDC union descriptor names interfaces by number and
+// FunctionFS leaves it as written.
+func (g *Gadget) AddSerial(instance string) (*Serial, error) {
+	f := &Function{}
+	comm := f.Interface(2, 2, 1, "")
+	data := f.Interface(0x0a, 0, 0, "")
+	f.Associate(comm, 2, 2, 2, 1, "Serial")
+	comm.Extra = []byte{
+		5, 0x24, 0x00, 0x10, 0x01, // header, CDC 1.10
+		5, 0x24, 0x01, 0x00, data.Number, // call management: none
+		4, 0x24, 0x02, 0x06, // ACM: line coding, break
+		5, 0x24, 0x06, comm.Number, data.Number, // union
+	}
+	comm.Endpoint(true, usb.ENDPOINT_XFER_INT, 16, 32*time.Millisecond)
+	data.Endpoint(false, usb.ENDPOINT_XFER_BULK, 0, 0)
+	data.Endpoint(true, usb.ENDPOINT_XFER_BULK, 0, 0)
+	s := &Serial{ifc: comm.Number, coding: LineCoding{Baud: 115200, DataBits: 8}}
+	x, e := g.addFFS(instance, f, s.event)
+	if e != nil {
+		return nil, e
+	}
+	s.x = x
+	return s, nil
+}
+
+func (s *Serial) event(ev *Event) {
+	req := ev.Setup
+	if ev.Type != FUNCTIONFS_SETUP {
+		return
+	}
+	if req.RequestType&0x7f != usb.REQTYPE_CLASS|usb.REQTYPE_INTERFACE {
+		ev.Stall()
+		return
+	}
+	switch req.Request {
+	case CDC_SET_LINE_CODING:
+		b, e := ev.Receive()
+		if e != nil || len(b) < 7 {
+			return
+		}
+		lc := LineCoding{binary.LittleEndian.Uint32(b), b[4], b[5], b[6]}
+		s.lock.Lock()
+		s.coding = lc
+		s.lock.Unlock()
+		if s.OnLineCoding != nil {
+			s.OnLineCoding(lc)
+		}
+	case CDC_GET_LINE_CODING:
+		lc := s.LineCoding()
+		b := binary.LittleEndian.AppendUint32(nil, lc.Baud)
+		ev.Reply(append(b, lc.StopBits, lc.Parity, lc.DataBits))
+	case CDC_SET_CONTROL_LINE_STATE:
+		ev.Receive()
+		if s.OnControlLine != nil {
+			s.OnControlLine(req.Value&1 != 0, req.Value&2 != 0)
+		}
+	case CDC_SEND_BREAK:
+		ev.Receive()
+		if s.OnBreak != nil {
+			d := time.Duration(req.Value) * time.Millisecond
+			if req.Value == 0xffff {
+				d = -1
+			}
+			s.OnBreak(d)
+		}
+	default:
+		ev.Stall()
+	}
+}
+
+// LineCoding returns the host's current settings, 115200 8N1 until it
+// sets any.
+func (s *Serial) LineCoding() LineCoding {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.coding
+}
+
+func (s *Serial) isClosed() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.closed
+}
+
+// Read returns data the host wrote to the port.  It waits through the
+// host closing and reopening the port.
+func (s *Serial) Read(p []byte) (int, error) {
+	for {
+		if s.isClosed() {
+			return 0, os.ErrClosed
+		}
+		n, e := s.x.Endpoints[1].Read(p)
+		if !s.x.Shutdown(e) {
+			return n, e
+		}
+	}
+}
+
+// Write sends data to the host.  It blocks while nobody on the host has
+// the port open.
+func (s *Serial) Write(p []byte) (int, error) {
+	if s.isClosed() {
+		return 0, os.ErrClosed
+	}
+	return s.x.Endpoints[2].Write(p)
+}
+
+// SetState notifies the host of the SERIAL_* modem and error bits.
+func (s *Serial) SetState(bits uint16) error {
+	n := []byte{0xa1, CDC_SERIAL_STATE, 0, 0, s.ifc, 0, 2, 0, byte(bits), byte(bits >> 8)}
+	_, e := s.x.Endpoints[0].Write(n)
+	return e
+}
+
+// Close stops Read and Write.  The endpoints themselves go away with
+// the gadget; a Read blocked waiting for the host returns once the
+// gadget is unbound.
+func (s *Serial) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.closed = true
+	return nil
+}
@@ -0,0 +1,350 @@
+package gadget
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+
+	"github.com/richardnwinder/usb"
+	"github.com/richardnwinder/usb/msc"
+)
+
+const (
+	cbwSignature = 0x43425355 // "USBC"
+	cswSignature = 0x53425355 // "USBS"
+)
+
+type StorageOptions struct {
+	Size      int64 // bytes; 0 asks the backing store
+	BlockSize int   // 0 is 512
+	ReadOnly  bool  // also implied by a store that is not an io.WriterAt
+	Removable bool
+	Vendor    string // 8 characters of INQUIRY data
+	Product   string // 16
+	// OnEject is called when the host ejects the medium, which is a good
+	// time to act on what it wrote.  It runs on the command loop.
+	OnEject func()
+}
+
+// MassStorage is a Bulk-Only mass storage function with one LUN, served
+// from Go over FunctionFS so that the medium can be anything with
+// ReadAt: a file, memory, or an image generated on the fly.
+type MassStorage struct {
+	x       *FunctionFS
+	store   io.ReaderAt
+	opts    StorageOptions
+	blocks  uint32
+	lock    sync.Mutex
+	ejected bool
+	sense   [3]uint8 // key, ASC, ASCQ for the next REQUEST SENSE
+	exit    chan struct{}
+}
+
+// AddMassStorage adds a mass storage function backed by store.
+func (g *Gadget) AddMassStorage(instance string, store io.ReaderAt, opts StorageOptions) (*MassStorage, error) {
+	if opts.BlockSize == 0 {
+		opts.BlockSize = 512
+	}
+	if _, ok := store.(io.WriterAt); !ok {
+		opts.ReadOnly = true
+	}
+	if opts.Size == 0 {
+		switch st := store.(type) {
+		case interface{ Size() int64 }:
+			opts.Size = st.Size()
+		case *os.File:
+			fi, e := st.Stat()
+			if e != nil {
+				return nil, e
+			}
+			opts.Size = fi.Size()
+		}
+	}
+	blocks := opts.Size / int64(opts.BlockSize)
+	if blocks == 0 || blocks > 0xffffffff {
+		return nil, fmt.Errorf("gadget: %d byte medium does not fit READ CAPACITY(10)", opts.Size)
+	}
+	f := &Function{}
+	i := f.Interface(0x08, 0x06, 0x50, "") // SCSI transparent, Bulk-Only
+	i.Endpoint(false, usb.ENDPOINT_XFER_BULK, 0, 0)
+	i.Endpoint(true, usb.ENDPOINT_XFER_BULK, 0, 0)
+	m := &MassStorage{store: store, opts: opts, blocks: uint32(blocks)}
+	x, e := g.addFFS(instance, f, m.event)
+	if e != nil {
+		return nil, e
+	}
+	m.x = x
+	g.started = append(g.started, m)
+	return m, nil
+}
+
+func (m *MassStorage) event(ev *Event) {
+	req := ev.Setup
+	switch {
+	case ev.Type != FUNCTIONFS_SETUP:
+	case req.RequestType == 0xa1 && req.Request == msc.REQ_GET_MAX_LUN:
+		ev.Reply([]byte{0})
+	case req.RequestType == 0x21 && req.Request == msc.REQ_BOMS_RESET:
+		// commands run to completion, so there is nothing to abort
+		ev.Receive()
+	default:
+		ev.Stall()
+	}
+}
+
+// Eject takes the medium away from the host, as if it had been pulled
+// out; Insert puts it back.  Hosts poll removable media and notice
+// within a second or two.
+func (m *MassStorage) Eject() {
+	m.lock.Lock()
+	m.ejected = true
+	m.lock.Unlock()
+}
+
+func (m *MassStorage) Insert() {
+	m.lock.Lock()
+	m.ejected = false
+	m.sense = [3]uint8{msc.SENSE_UNIT_ATTENTION, 0x28, 0} // medium may have changed
+	m.lock.Unlock()
+}
+
+func (m *MassStorage) start() error {
+	if m.exit != nil {
+		select {
+		case <-m.exit:
+		default:
+			// still waiting from the last time we were bound
+			return nil
+		}
+	}
+	m.exit = make(chan struct{})
+	go m.run()
+	return nil
+}
+
+func (m *MassStorage) stop() {}
+
+func (m *MassStorage) run() {
+	defer close(m.exit)
+	out, in := m.x.Endpoints[0], m.x.Endpoints[1]
+	cbw := make([]byte, 512)
+	for {
+		n, e := out.Read(cbw)
+		if m.x.Shutdown(e) {
+			continue
+		}
+		if e != nil {
+			return
+		}
+		if n != 31 || binary.LittleEndian.Uint32(cbw) != cbwSignature {
+			// not meaningful: stall until the host does reset recovery
+			halt(in, true)
+			continue
+		}
+		tag := binary.LittleEndian.Uint32(cbw[4:])
+		length := binary.LittleEndian.Uint32(cbw[8:])
+		cmd := &command{m: m, in: cbw[12]&0x80 != 0, length: length, cdb: cbw[15 : 15+min(cbw[14]&0x1f, 16)]}
+		status := cmd.run()
+		if cmd.moved < length {
+			// no more data is coming; the host clears the halt and
+			// reads the CSW
+			if cmd.in {
+				halt(in, true)
+			} else {
+				halt(out, false)
+			}
+		}
+		csw := binary.LittleEndian.AppendUint32(nil, cswSignature)
+		csw = binary.LittleEndian.AppendUint32(csw, tag)
+		csw = binary.LittleEndian.AppendUint32(csw, length-cmd.moved)
+		if _, e = in.Write(append(csw, status)); e != nil && !errors.Is(e, syscall.ESHUTDOWN) {
+			return
+		}
+	}
+}
+
+// halt stalls an endpoint: FunctionFS halts one used in the wrong
+// direction
+func halt(f *os.File, in bool) {
+	var b [1]byte
+	if in {
+		f.Read(b[:])
+	} else {
+		f.Write(b[:])
+	}
+}
+
+// command is one CBW being served
+type command struct {
+	m      *MassStorage
+	in     bool
+	length uint32 // dCBWDataTransferLength
+	moved  uint32
+	cdb    []byte
+}
+
+func (c *command) fail(key uint8, asc uint8) uint8 {
+	c.m.lock.Lock()
+	c.m.sense = [3]uint8{key, asc, 0}
+	c.m.lock.Unlock()
+	return msc.CSW_FAILED
+}
+
+// send answers an IN command with data, trimmed to what the host wants
+func (c *command) send(data []byte) uint8 {
+	if !c.in {
+		return msc.CSW_PHASE_ERROR
+	}
+	data = data[:min(uint32(len(data)), c.length-c.moved)]
+	n, e := c.m.x.Endpoints[1].Write(data)
+	c.moved += uint32(n)
+	if e != nil {
+		return msc.CSW_PHASE_ERROR
+	}
+	return msc.CSW_PASSED
+}
+
+func (c *command) run() uint8 {
+	m := c.m
+	if len(c.cdb) == 0 {
+		return c.fail(msc.SENSE_ILLEGAL_REQUEST, 0x20)
+	}
+	m.lock.Lock()
+	ejected := m.ejected
+	m.lock.Unlock()
+	switch c.cdb[0] {
+	case msc.SCSI_REQUEST_SENSE:
+		m.lock.Lock()
+		s := m.sense
+		m.sense = [3]uint8{}
+		m.lock.Unlock()
+		if ejected && s[0] == 0 {
+			s = [3]uint8{msc.SENSE_NOT_READY, 0x3a, 0}
+		}
+		b := make([]byte, 18)
+		b[0], b[2], b[7], b[12], b[13] = 0x70, s[0], 10, s[1], s[2]
+		return c.send(b)
+	case msc.SCSI_INQUIRY:
+		if c.cdb[1]&1 != 0 {
+			return c.fail(msc.SENSE_ILLEGAL_REQUEST, 0x24) // no vital product data
+		}
+		b := []byte{0x00, 0x00, 0x04, 0x02, 31, 0, 0, 0}
+		if m.opts.Removable {
+			b[1] = 0x80
+		}
+		b = append(b, fmt.Sprintf("%-8.8s%-16.16s%-4.4s", m.opts.Vendor, m.opts.Product, "1.00")...)
+		return c.send(b)
+	}
+	if ejected {
+		return c.fail(msc.SENSE_NOT_READY, 0x3a) // medium not present
+	}
+	bs := uint32(m.opts.BlockSize)
+	switch c.cdb[0] {
+	case msc.SCSI_TEST_UNIT_READY, msc.SCSI_PREVENT_ALLOW:
+		return msc.CSW_PASSED
+	case msc.SCSI_START_STOP_UNIT:
+		if c.cdb[4]&3 == 2 { // LoEj without Start
+			m.Eject()
+			if m.opts.OnEject != nil {
+				m.opts.OnEject()
+			}
+		}
+		return msc.CSW_PASSED
+	case msc.SCSI_READ_CAPACITY_10:
+		b := binary.BigEndian.AppendUint32(nil, m.blocks-1)
+		return c.send(binary.BigEndian.AppendUint32(b, bs))
+	case msc.SCSI_READ_FORMAT_CAPS:
+		b := []byte{0, 0, 0, 8}
+		b = binary.BigEndian.AppendUint32(b, m.blocks)
+		b = binary.BigEndian.AppendUint32(b, 0x02000000|bs) // formatted media
+		return c.send(b)
+	case msc.SCSI_MODE_SENSE_6, msc.SCSI_MODE_SENSE_10:
+		// just the header, with the write protect bit
+		wp := uint8(0)
+		if m.opts.ReadOnly {
+			wp = 0x80
+		}
+		if c.cdb[0] == msc.SCSI_MODE_SENSE_6 {
+			return c.send([]byte{3, 0, wp, 0})
+		}
+		return c.send([]byte{0, 6, 0, wp, 0, 0, 0, 0})
+	case msc.SCSI_SYNCHRONIZE_CACHE:
+		if s, ok := m.store.(interface{ Sync() error }); ok && s.Sync() != nil {
+			return c.fail(msc.SENSE_MEDIUM_ERROR, 0x0c)
+		}
+		if s, ok := m.store.(interface{ Flush() error }); ok && s.Flush() != nil {
+			return c.fail(msc.SENSE_MEDIUM_ERROR, 0x0c)
+		}
+		return msc.CSW_PASSED
+	case msc.SCSI_READ_10, msc.SCSI_WRITE_10, msc.SCSI_VERIFY_10:
+		if len(c.cdb) < 10 {
+			return c.fail(msc.SENSE_ILLEGAL_REQUEST, 0x20)
+		}
+		lba := binary.BigEndian.Uint32(c.cdb[2:])
+		count := uint32(binary.BigEndian.Uint16(c.cdb[7:]))
+		switch {
+		case uint64(lba)+uint64(count) > uint64(m.blocks):
+			return c.fail(msc.SENSE_ILLEGAL_REQUEST, 0x21) // LBA out of range
+		case c.cdb[0] == msc.SCSI_VERIFY_10:
+			return msc.CSW_PASSED
+		case c.cdb[0] == msc.SCSI_READ_10:
+			return c.read(int64(lba)*int64(bs), count*bs)
+		}
+		return c.write(int64(lba)*int64(bs), count*bs)
+	}
+	return c.fail(msc.SENSE_ILLEGAL_REQUEST, 0x20) // invalid opcode
+}
+
+const chunk = 64 * 1024
+
+func (c *command) read(off int64, size uint32) uint8 {
+	if !c.in || size > c.length {
+		return msc.CSW_PHASE_ERROR
+	}
+	buf := make([]byte, min(size, chunk))
+	for done := uint32(0); done < size; {
+		b := buf[:min(size-done, chunk)]
+		if _, e := c.m.store.ReadAt(b, off+int64(done)); e != nil && e != io.EOF {
+			return c.fail(msc.SENSE_MEDIUM_ERROR, 0x11) // unrecovered read error
+		}
+		if st := c.send(b); st != msc.CSW_PASSED {
+			return st
+		}
+		done += uint32(len(b))
+	}
+	return msc.CSW_PASSED
+}
+
+func (c *command) write(off int64, size uint32) uint8 {
+	if c.in || size > c.length {
+		return msc.CSW_PHASE_ERROR
+	}
+	w, _ := c.m.store.(io.WriterAt)
+	buf := make([]byte, min(size, chunk))
+	status := uint8(msc.CSW_PASSED)
+	for done := uint32(0); done < size; {
+		b := buf[:min(size-done, chunk)]
+		n, e := io.ReadFull(c.m.x.Endpoints[0], b)
+		c.moved += uint32(n)
+		if e != nil {
+			return msc.CSW_PHASE_ERROR
+		}
+		// take all the data before failing, so the host isn't left
+		// waiting to send it
+		switch {
+		case status != msc.CSW_PASSED:
+		case c.m.opts.ReadOnly:
+			status = c.fail(msc.SENSE_DATA_PROTECT, 0x27)
+		default:
+			if _, e = w.WriteAt(b, off+int64(done)); e != nil {
+				status = c.fail(msc.SENSE_MEDIUM_ERROR, 0x0c) // write error
+			}
+		}
+		done += uint32(len(b))
+	}
+	return status
+}
@@ -0,0 +1,172 @@
+// Package group runs the same operation on several open devices at once.
+package group
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"syscall"
+
+	"github.com/richardnwinder/usb"
+)
+
+// Group runs the same operation on several open devices at once,
+// as a gang programming station flashing a tray of boards does.  Each
+// device runs on its own goroutine, up to Parallel at a time, and a
+// device failing doesn't stop the others.
+type Group struct {
+	Devices  []*usb.Device
+	Parallel int // how many devices run at once, 0 for all of them
+}
+
+func New(devs ...*usb.Device) *Group {
+	return &Group{Devices: devs}
+}
+
+// Error holds the errors of the devices an operation failed on,
+// indexed as the group's Devices with nil for those it succeeded on.
+type Error struct {
+	Errors []error
+}
+
+// Failed lists the indices of the devices that failed.
+func (e *Error) Failed() []int {
+	var list []int
+	for i, err := range e.Errors {
+		if err != nil {
+			list = append(list, i)
+		}
+	}
+	return list
+}
+
+func (e *Error) Error() string {
+	failed := e.Failed()
+	if len(failed) == 0 {
+		return "group: no devices failed"
+	}
+	return fmt.Sprintf("group: %d of %d devices failed; device %d: %v",
+		len(failed), len(e.Errors), failed[0], e.Errors[failed[0]])
+}
+
+// Unwrap gives the individual errors to errors.Is and errors.As.
+func (e *Error) Unwrap() []error {
+	var list []error
+	for _, err := range e.Errors {
+		if err != nil {
+			list = append(list, err)
+		}
+	}
+	return list
+}
+
+// Do calls fn for each device in parallel, with the device's index in
+// Devices, and waits for them all.  Devices not yet started when ctx is
+// done fail with its error.  It returns a *Error if fn failed on
+// any device, nil otherwise.
+func (g *Group) Do(ctx context.Context, fn func(i int, u *usb.Device) error) error {
+	errs := make([]error, len(g.Devices))
+	n := g.Parallel
+	if n <= 0 {
+		n = len(g.Devices)
+	}
+	slots := make(chan struct{}, max(n, 1))
+	var wg sync.WaitGroup
+	for i, u := range g.Devices {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			errs[i] = ctx.Err()
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			if u == nil {
+				errs[i] = syscall.ENODEV
+				return
+			}
+			errs[i] = fn(i, u)
+		}()
+	}
+	wg.Wait()
+	for _, e := range errs {
+		if e != nil {
+			return &Error{errs}
+		}
+	}
+	return nil
+}
+
+// ControlOut sends the same control OUT request to every device.
+func (g *Group) ControlOut(ctx context.Context, reqtype uint8, request uint8, value uint16, index uint16,
+	timeout uint32, data []byte) error {
+
+	if reqtype&usb.REQTYPE_IN != 0 || len(data) > 0xffff {
+		return syscall.EINVAL
+	}
+	return g.Do(ctx, func(i int, u *usb.Device) error {
+		_, e := u.ControlTransferContext(ctx, reqtype, request, value, index, uint16(len(data)), timeout, data)
+		return e
+	})
+}
+
+// ControlIn issues the same control IN request to every device and
+// returns what each sent back, nil for those that failed.
+func (g *Group) ControlIn(ctx context.Context, reqtype uint8, request uint8, value uint16, index uint16,
+	length uint16, timeout uint32) ([][]byte, error) {
+
+	out := make([][]byte, len(g.Devices))
+	e := g.Do(ctx, func(i int, u *usb.Device) error {
+		b := make([]byte, length)
+		n, e := u.ControlTransferContext(ctx, reqtype|usb.REQTYPE_IN, request, value, index, length, timeout, b)
+		if e == nil {
+			out[i] = b[:n]
+		}
+		return e
+	})
+	return out, e
+}
+
+// BulkWrite sends data to the same OUT endpoint of every device, in
+// chunks as a ThrottledWriter with opts would.  Every device reads from
+// data, so it mustn't change until BulkWrite returns.
+func (g *Group) BulkWrite(ctx context.Context, endpoint uint8, data []byte, opts usb.WriterOptions) error {
+	return g.Do(ctx, func(i int, u *usb.Device) error {
+		w, e := u.NewThrottledWriter(endpoint, opts)
+		if e != nil {
+			return e
+		}
+		_, e = w.WriteContext(ctx, data)
+		return e
+	})
+}
+
+// BulkRead reads up to length bytes from the same IN endpoint of every
+// device and returns what each sent, nil for those that failed.
+func (g *Group) BulkRead(ctx context.Context, endpoint uint8, length int, timeout uint32) ([][]byte, error) {
+	if length < 0 {
+		return nil, syscall.EINVAL
+	}
+	out := make([][]byte, len(g.Devices))
+	e := g.Do(ctx, func(i int, u *usb.Device) error {
+		_, b, e := u.BulkTransferContext(ctx, uint32(endpoint|usb.ENDPOINT_IN), uint32(length), timeout, make([]byte, length))
+		if e == nil {
+			out[i] = b
+		}
+		return e
+	})
+	return out, e
+}
+
+// Close closes every device in the group.
+func (g *Group) Close() {
+	for _, u := range g.Devices {
+		if u != nil {
+			u.Close()
+		}
+	}
+}
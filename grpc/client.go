@@ -0,0 +1,394 @@
+package grpc
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"syscall"
+
+	"github.com/richardnwinder/usb"
+)
+
+var (
+	_ usb.Conn   = (*Device)(nil)
+	_ usb.Opener = (*Client)(nil)
+)
+
+// Client is a connection to a Server, or any other implementation of
+// usb.proto's service.  It is safe for concurrent use; calls from
+// different goroutines are in flight together on one HTTP/2 connection.
+type Client struct {
+	hc    *http.Client
+	base  string
+	token string
+}
+
+// Dial connects to a server over TLS, or over unencrypted HTTP/2 if cfg
+// is nil.  The connection is made by the first call.
+func Dial(addr string, cfg *tls.Config, token string) (*Client, error) {
+	if _, _, e := net.SplitHostPort(addr); e != nil {
+		return nil, e
+	}
+	p := new(http.Protocols)
+	t := &http.Transport{
+		Protocols: p,
+		// the server ties the devices we open to the connection, so
+		// every call must go over the same one
+		MaxConnsPerHost: 1,
+		HTTP2:           &http.HTTP2Config{StrictMaxConcurrentRequests: true},
+	}
+	scheme := "https"
+	if cfg == nil {
+		p.SetUnencryptedHTTP2(true)
+		scheme = "http"
+	} else {
+		p.SetHTTP2(true)
+		t.TLSClientConfig = cfg
+	}
+	return &Client{
+		hc:    &http.Client{Transport: t},
+		base:  scheme + "://" + addr + servicePath,
+		token: token,
+	}, nil
+}
+
+// Close drops the connection; the server closes every device it had
+// open for us.
+func (c *Client) Close() error {
+	c.hc.CloseIdleConnections()
+	return nil
+}
+
+func (c *Client) request(ctx context.Context, method string, body io.Reader) (*http.Response, error) {
+	req, e := http.NewRequestWithContext(ctx, http.MethodPost, c.base+method, body)
+	if e != nil {
+		return nil, e
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("Te", "trailers")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, e := c.hc.Do(req)
+	if e != nil {
+		return nil, e
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, &Status{CODE_UNAVAILABLE, "HTTP " + resp.Status}
+	}
+	return resp, nil
+}
+
+// callStatus decodes the status a call ended with, from its trailers, or
+// its headers if the server sent nothing else
+func callStatus(resp *http.Response) error {
+	h := resp.Trailer
+	if h.Get("Grpc-Status") == "" {
+		h = resp.Header
+	}
+	code, e := strconv.Atoi(h.Get("Grpc-Status"))
+	switch {
+	case e != nil:
+		return &Status{CODE_UNKNOWN, "no grpc-status"}
+	case code == CODE_OK:
+		return nil
+	}
+	if errno, e := strconv.Atoi(h.Get(errnoTrailer)); e == nil && errno != 0 {
+		return syscall.Errno(errno)
+	}
+	if code == CODE_UNAUTHENTICATED {
+		return ErrDenied
+	}
+	msg, e := url.PathUnescape(h.Get("Grpc-Message"))
+	if e != nil {
+		msg = h.Get("Grpc-Message")
+	}
+	return &Status{code, msg}
+}
+
+var ErrDenied = errors.New("grpc: token rejected")
+
+// call makes one unary call
+func (c *Client) call(method string, req encoder) (*message, error) {
+	var body bytes.Buffer
+	writeMessage(&body, req)
+	resp, e := c.request(context.Background(), method, &body)
+	if e != nil {
+		return nil, e
+	}
+	defer resp.Body.Close()
+	reply, e := readMessage(resp.Body)
+	if e != nil && e != io.EOF {
+		return nil, e
+	}
+	// the trailers come once the body is read to the end
+	io.Copy(io.Discard, resp.Body)
+	if e := callStatus(resp); e != nil {
+		return nil, e
+	}
+	if reply == nil {
+		return nil, errMessage
+	}
+	return reply, nil
+}
+
+// ListDevices returns the devices the server lets us see.  Their
+// descriptors are filled in, along with the bus, device and port
+// numbers on the server.
+func (c *Client) ListDevices() ([]*usb.DeviceInfo, error) {
+	reply, e := c.call("ListDevices", nil)
+	if e != nil {
+		return nil, e
+	}
+	var list []*usb.DeviceInfo
+	for _, b := range reply.all(1) {
+		m, e := parse(b)
+		if e != nil {
+			return nil, e
+		}
+		ports, e := m.uints(3)
+		if e != nil {
+			return nil, e
+		}
+		di, e := usb.ParseDescriptors(m.data(4))
+		if e != nil {
+			continue
+		}
+		di.BusNum, di.DevNum = int(m.uint(1)), int(m.uint(2))
+		for _, p := range ports {
+			di.Ports = append(di.Ports, int(p))
+		}
+		list = append(list, di)
+	}
+	return list, nil
+}
+
+// Open opens device bus:dev on the server.
+func (c *Client) Open(bus int, dev int) (*Device, error) {
+	var req encoder
+	req.uint(1, uint32(bus))
+	req.uint(2, uint32(dev))
+	reply, e := c.call("Open", req)
+	if e != nil {
+		return nil, e
+	}
+	return &Device{c: c, handle: reply.uint(1)}, nil
+}
+
+// OpenDevice opens a device ListDevices returned, for code written
+// against usb.Opener.
+func (c *Client) OpenDevice(di *usb.DeviceInfo) (usb.Conn, error) {
+	d, e := c.Open(di.BusNum, di.DevNum)
+	if e != nil {
+		return nil, e
+	}
+	return d, nil
+}
+
+// Device is a device open on a server.
+type Device struct {
+	c      *Client
+	handle uint32
+}
+
+func (u *Device) simple(method string, field2 uint32, field3 uint32) error {
+	var req encoder
+	req.uint(1, u.handle)
+	req.uint(2, field2)
+	req.uint(3, field3)
+	_, e := u.c.call(method, req)
+	return e
+}
+
+// transferResult decodes a TransferReply
+func transferResult(reply *message) (int, []byte, error) {
+	n := int(reply.uint(1))
+	switch {
+	case reply.int(3) != 0:
+		return n, reply.data(2), syscall.Errno(reply.int(3))
+	case len(reply.data(4)) != 0:
+		return n, reply.data(2), errors.New("grpc: " + string(reply.data(4)))
+	}
+	return n, reply.data(2), nil
+}
+
+func (u *Device) ControlTransfer(
+	reqtype uint8, request uint8, value uint16, index uint16,
+	length uint16, timeout uint32, data []byte) (int, error) {
+
+	if int(length) > len(data) {
+		return 0, syscall.ENOSPC
+	}
+	var req encoder
+	req.uint(1, u.handle)
+	req.uint(2, uint32(reqtype))
+	req.uint(3, uint32(request))
+	req.uint(4, uint32(value))
+	req.uint(5, uint32(index))
+	req.uint(6, uint32(length))
+	req.uint(7, timeout)
+	if reqtype&usb.REQTYPE_IN == 0 {
+		req.bytes(8, data[:length])
+	}
+	reply, e := u.c.call("Control", req)
+	if e != nil {
+		return 0, e
+	}
+	n, in, e := transferResult(reply)
+	if reqtype&usb.REQTYPE_IN != 0 {
+		n = copy(data[:length], in)
+	}
+	return n, e
+}
+
+func (u *Device) BulkTransfer(endpoint uint32, length uint32, timeout uint32, data []byte) (int, []byte, error) {
+	if int(length) > len(data) {
+		return 0, nil, syscall.ENOSPC
+	}
+	in := endpoint&usb.ENDPOINT_IN != 0
+	reply, e := u.c.call("Bulk", bulkRequest(u.handle, endpoint, length, timeout, in, data))
+	if e != nil {
+		return 0, nil, e
+	}
+	n, b, e := transferResult(reply)
+	if in {
+		n = copy(data[:length], b)
+	}
+	return n, append([]byte(nil), data[:n]...), e
+}
+
+func bulkRequest(handle uint32, endpoint uint32, length uint32, timeout uint32, in bool, data []byte) encoder {
+	var req encoder
+	req.uint(1, handle)
+	req.uint(2, endpoint)
+	req.uint(3, length)
+	req.uint(4, timeout)
+	if !in {
+		req.bytes(5, data[:length])
+	}
+	return req
+}
+
+func (u *Device) ClaimInterface(n uint32) error {
+	return u.simple("Claim", n, 0)
+}
+
+func (u *Device) ReleaseInterface(n uint32) error {
+	return u.simple("Release", n, 0)
+}
+
+func (u *Device) SetConfiguration(num uint8) error {
+	return u.simple("SetConfiguration", uint32(num), 0)
+}
+
+func (u *Device) SetInterface(num uint8, alt uint8) error {
+	return u.simple("SetInterface", uint32(num), uint32(alt))
+}
+
+func (u *Device) ClearHalt(endpoint uint8) error {
+	return u.simple("ClearHalt", uint32(endpoint), 0)
+}
+
+func (u *Device) Reset() error {
+	return u.simple("Reset", 0, 0)
+}
+
+func (u *Device) Close() {
+	u.simple("Close", 0, 0)
+}
+
+// Stream is a Stream call on one endpoint: Send queues a transfer on
+// the server and Recv returns the results in the order they were sent,
+// so a reader can keep several transfers queued and the endpoint busy.
+type Stream struct {
+	u        *Device
+	endpoint uint32
+	cancel   context.CancelFunc
+	w        *io.PipeWriter
+	wlock    sync.Mutex
+	ready    chan struct{}
+	resp     *http.Response
+	err      error
+}
+
+// Result is how one streamed transfer ended.
+type Result struct {
+	Length int    // bytes transferred
+	Data   []byte // what an IN transfer read
+	Err    error
+}
+
+// Stream starts a Stream call on endpoint; cancelling ctx ends it and
+// cancels the transfers still queued.
+func (u *Device) Stream(ctx context.Context, endpoint uint8) *Stream {
+	ctx, cancel := context.WithCancel(ctx)
+	r, w := io.Pipe()
+	s := &Stream{u: u, endpoint: uint32(endpoint), cancel: cancel, w: w, ready: make(chan struct{})}
+	// the request body is written as Send is called, so the call is
+	// made meanwhile
+	go func() {
+		s.resp, s.err = u.c.request(ctx, "Stream", r)
+		if s.err != nil {
+			r.CloseWithError(s.err)
+		}
+		close(s.ready)
+	}()
+	return s
+}
+
+// Send queues a transfer of length bytes; for an OUT endpoint data holds
+// them.  timeout is in milliseconds, 0 for none.
+func (s *Stream) Send(length uint32, timeout uint32, data []byte) error {
+	in := s.endpoint&usb.ENDPOINT_IN != 0
+	if !in && int(length) > len(data) {
+		return syscall.ENOSPC
+	}
+	s.wlock.Lock()
+	defer s.wlock.Unlock()
+	return writeMessage(s.w, bulkRequest(s.u.handle, s.endpoint, length, timeout, in, data))
+}
+
+// CloseSend says no more transfers are coming; Recv still returns the
+// ones sent.
+func (s *Stream) CloseSend() error {
+	return s.w.Close()
+}
+
+// Recv returns the next transfer's result.  Its error is the stream's
+// own: io.EOF once CloseSend has been called and every result is back.
+func (s *Stream) Recv() (*Result, error) {
+	<-s.ready
+	if s.err != nil {
+		return nil, s.err
+	}
+	reply, e := readMessage(s.resp.Body)
+	if e == io.EOF {
+		if e := callStatus(s.resp); e != nil {
+			return nil, e
+		}
+		return nil, io.EOF
+	}
+	if e != nil {
+		return nil, e
+	}
+	n, data, e := transferResult(reply)
+	return &Result{n, data, e}, nil
+}
+
+// Close ends the call, cancelling whatever is still queued.
+func (s *Stream) Close() {
+	s.cancel()
+	s.w.CloseWithError(context.Canceled)
+	<-s.ready
+	if s.resp != nil {
+		s.resp.Body.Close()
+	}
+}
@@ -0,0 +1,16 @@
+// Package grpc serves usb.proto's USB service, for non-Go processes and
+// orchestrators, and has a Go client for it.
+//
+// The module is standard library only, so there are no generated stubs:
+// the few flat messages are encoded by hand and carried in gRPC's framing
+// over net/http's HTTP/2, unencrypted or over TLS.  Other languages
+// generate their clients from usb.proto as usual.  The server does what
+// the remote package's does, over the same usb.Conn operations: it
+// offers the devices Allow picks, authenticates clients by token, and
+// closes a client's devices when its connection goes away.  Failed calls
+// carry the errno behind them in a usb-errno trailer, and transfers in
+// their reply, so Client gives back the errors a local device would.
+//
+// Stream keeps several transfers queued on a local device at once,
+// where each remote call is one round trip.
+package grpc
@@ -0,0 +1,228 @@
+package grpc_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/richardnwinder/usb"
+	"github.com/richardnwinder/usb/grpc"
+	"github.com/richardnwinder/usb/usbtest"
+)
+
+// a vendor-specific device with one interface and bulk endpoints 0x81
+// and 0x02
+const testDescriptors = `
+12 01 00 02 ff 00 00 40  34 12 78 56 00 01 00 00 00 01
+09 02 20 00 01 01 00 80 32
+09 04 00 00 02 ff 00 00 00
+07 05 81 02 00 02 00
+07 05 02 02 00 02 00
+`
+
+// opener lists the one device from a sysfs tree of its own and opens it
+// as a simulated device, so no hardware is needed
+type opener struct {
+	root usb.Root
+	dev  *usbtest.Device
+}
+
+func (o *opener) ListDevices() ([]*usb.DeviceInfo, error) {
+	return o.root.ListDevices(context.Background())
+}
+
+func (o *opener) OpenDevice(di *usb.DeviceInfo) (usb.Conn, error) {
+	return o.dev, nil
+}
+
+func newOpener(t *testing.T) *opener {
+	f, e := usbtest.ParseHex("test", testDescriptors)
+	if e != nil {
+		t.Fatal(e)
+	}
+	sys := t.TempDir()
+	dir := filepath.Join(sys, "1-2")
+	if e := os.Mkdir(dir, 0755); e != nil {
+		t.Fatal(e)
+	}
+	for name, v := range map[string][]byte{
+		"busnum":      []byte("1\n"),
+		"devnum":      []byte("5\n"),
+		"descriptors": f.Descriptors,
+	} {
+		if e := os.WriteFile(filepath.Join(dir, name), v, 0644); e != nil {
+			t.Fatal(e)
+		}
+	}
+	return &opener{root: usb.Root{Sysfs: sys}, dev: f.Device()}
+}
+
+// serve runs a server over unencrypted HTTP/2 on a loopback port and
+// returns its address
+func serve(t *testing.T, s *grpc.Server) string {
+	l, e := net.Listen("tcp", "127.0.0.1:0")
+	if e != nil {
+		t.Fatal(e)
+	}
+	t.Cleanup(func() { l.Close() })
+	go s.Serve(l)
+	return l.Addr().String()
+}
+
+func dial(t *testing.T, addr string, token string) *grpc.Client {
+	c, e := grpc.Dial(addr, nil, token)
+	if e != nil {
+		t.Fatal(e)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func open(t *testing.T, c *grpc.Client) *grpc.Device {
+	devs, e := c.ListDevices()
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(devs) != 1 {
+		t.Fatalf("listed %d devices, want 1", len(devs))
+	}
+	d, e := c.Open(devs[0].BusNum, devs[0].DevNum)
+	if e != nil {
+		t.Fatal(e)
+	}
+	return d
+}
+
+func TestRoundTrip(t *testing.T) {
+	o := newOpener(t)
+	o.dev.On(usbtest.Bulk(0x81)).Reply([]byte("hello"))
+	var out [][]byte
+	o.dev.OnOut = func(r *usbtest.Request) { out = append(out, r.Data) }
+	c := dial(t, serve(t, &grpc.Server{Token: "secret", Opener: o}), "secret")
+
+	devs, e := c.ListDevices()
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(devs) != 1 {
+		t.Fatalf("listed %d devices, want 1", len(devs))
+	}
+	di := devs[0]
+	if di.BusNum != 1 || di.DevNum != 5 || len(di.Ports) != 1 || di.Ports[0] != 2 {
+		t.Errorf("device %d:%d at ports %v, want 1:5 at [2]", di.BusNum, di.DevNum, di.Ports)
+	}
+	if di.VendorID != 0x1234 || di.ProductID != 0x5678 {
+		t.Errorf("device %04x:%04x, want 1234:5678", di.VendorID, di.ProductID)
+	}
+
+	d, e := c.Open(di.BusNum, di.DevNum)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if e := d.ClaimInterface(0); e != nil {
+		t.Fatal(e)
+	}
+	if e := d.ClaimInterface(0); !errors.Is(e, syscall.EBUSY) {
+		t.Errorf("second claim: %v, want EBUSY", e)
+	}
+
+	buf := make([]byte, usb.DT_DEVICE_SIZE)
+	n, e := d.ControlTransfer(usb.REQTYPE_IN, usb.REQ_GET_DESCRIPTOR, usb.DT_DEVICE<<8, 0, uint16(len(buf)), 1000, buf)
+	if e != nil {
+		t.Fatal(e)
+	}
+	f, _ := usbtest.ParseHex("test", testDescriptors)
+	if !bytes.Equal(buf[:n], f.Descriptors[:usb.DT_DEVICE_SIZE]) {
+		t.Errorf("device descriptor % x, want % x", buf[:n], f.Descriptors[:usb.DT_DEVICE_SIZE])
+	}
+	// a stall comes back as the errno a local device gives
+	if _, e := d.ControlTransfer(usb.REQTYPE_IN|usb.REQTYPE_VENDOR, 1, 0, 0, 4, 1000, buf); !errors.Is(e, syscall.EPIPE) {
+		t.Errorf("unknown request: %v, want EPIPE", e)
+	}
+
+	in := make([]byte, 64)
+	n, _, e = d.BulkTransfer(0x81, uint32(len(in)), 1000, in)
+	if e != nil || string(in[:n]) != "hello" {
+		t.Errorf("bulk IN: %q, %v", in[:n], e)
+	}
+	n, _, e = d.BulkTransfer(0x02, 3, 1000, []byte("abc"))
+	if e != nil || n != 3 {
+		t.Errorf("bulk OUT: %d, %v", n, e)
+	}
+	if len(out) != 1 || string(out[0]) != "abc" {
+		t.Errorf("device got %q, want [abc]", out)
+	}
+
+	d.Close()
+	if e := d.ClearHalt(0x81); !errors.Is(e, syscall.EBADF) {
+		t.Errorf("call on a closed handle: %v, want EBADF", e)
+	}
+}
+
+func TestToken(t *testing.T) {
+	addr := serve(t, &grpc.Server{Token: "secret", Opener: newOpener(t)})
+	if _, e := dial(t, addr, "wrong").ListDevices(); e != grpc.ErrDenied {
+		t.Errorf("wrong token: %v, want ErrDenied", e)
+	}
+	if _, e := dial(t, addr, "").ListDevices(); e != grpc.ErrDenied {
+		t.Errorf("no token: %v, want ErrDenied", e)
+	}
+}
+
+func TestAllow(t *testing.T) {
+	s := &grpc.Server{Opener: newOpener(t), Allow: func(*usb.DeviceInfo) bool { return false }}
+	c := dial(t, serve(t, s), "")
+	devs, e := c.ListDevices()
+	if e != nil || len(devs) != 0 {
+		t.Errorf("listed %d devices, %v; want none", len(devs), e)
+	}
+	if _, e := c.Open(1, 5); !errors.Is(e, syscall.ENODEV) {
+		t.Errorf("opening a device not allowed: %v, want ENODEV", e)
+	}
+}
+
+func TestStream(t *testing.T) {
+	o := newOpener(t)
+	for _, s := range []string{"one", "two", "three"} {
+		o.dev.On(usbtest.Bulk(0x81)).Times(1).Reply([]byte(s))
+	}
+	c := dial(t, serve(t, &grpc.Server{Opener: o}), "")
+	d := open(t, c)
+
+	s := d.Stream(context.Background(), 0x81)
+	defer s.Close()
+	for range 4 {
+		if e := s.Send(64, 50, nil); e != nil {
+			t.Fatal(e)
+		}
+	}
+	if e := s.CloseSend(); e != nil {
+		t.Fatal(e)
+	}
+	for _, want := range []string{"one", "two", "three"} {
+		r, e := s.Recv()
+		if e != nil {
+			t.Fatal(e)
+		}
+		if r.Err != nil || string(r.Data) != want || r.Length != len(want) {
+			t.Errorf("got %q (%d), %v; want %q", r.Data, r.Length, r.Err, want)
+		}
+	}
+	// nothing left for the fourth to read
+	r, e := s.Recv()
+	if e != nil {
+		t.Fatal(e)
+	}
+	if !errors.Is(r.Err, syscall.ETIMEDOUT) {
+		t.Errorf("read with nothing to read: %v, want ETIMEDOUT", r.Err)
+	}
+	if _, e := s.Recv(); e != io.EOF {
+		t.Errorf("after the last: %v, want io.EOF", e)
+	}
+}
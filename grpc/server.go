@@ -0,0 +1,539 @@
+package grpc
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/richardnwinder/usb"
+)
+
+// gRPC status codes
+const (
+	CODE_OK                  = 0
+	CODE_UNKNOWN             = 2
+	CODE_INVALID_ARGUMENT    = 3
+	CODE_DEADLINE_EXCEEDED   = 4
+	CODE_NOT_FOUND           = 5
+	CODE_PERMISSION_DENIED   = 7
+	CODE_RESOURCE_EXHAUSTED  = 8
+	CODE_FAILED_PRECONDITION = 9
+	CODE_UNIMPLEMENTED       = 12
+	CODE_UNAVAILABLE         = 14
+	CODE_UNAUTHENTICATED     = 16
+)
+
+// Status is a call's failure as gRPC reports it, for errors that aren't
+// an errno from the device.
+type Status struct {
+	Code    int
+	Message string
+}
+
+func (s *Status) Error() string {
+	return fmt.Sprintf("grpc: status %d: %s", s.Code, s.Message)
+}
+
+// Besides grpc-status and grpc-message, a failed call carries the errno
+// behind it in this trailer, so a Go client gets back the error a local
+// device would have given.
+const errnoTrailer = "Usb-Errno"
+
+// code picks the gRPC status for a device error
+func code(e error) int {
+	var errno syscall.Errno
+	if !errors.As(e, &errno) {
+		return CODE_UNKNOWN
+	}
+	switch errno {
+	case syscall.ENODEV, syscall.ENOENT, syscall.EBADF:
+		return CODE_NOT_FOUND
+	case syscall.EACCES, syscall.EPERM:
+		return CODE_PERMISSION_DENIED
+	case syscall.EINVAL, syscall.ENOSPC, syscall.EMSGSIZE:
+		return CODE_INVALID_ARGUMENT
+	case syscall.ETIMEDOUT:
+		return CODE_DEADLINE_EXCEEDED
+	case syscall.ENOMEM:
+		return CODE_RESOURCE_EXHAUSTED
+	case syscall.EBUSY, syscall.EPIPE:
+		return CODE_FAILED_PRECONDITION
+	case syscall.ENOSYS, syscall.EOPNOTSUPP:
+		return CODE_UNIMPLEMENTED
+	}
+	return CODE_UNKNOWN
+}
+
+// Server serves usb.proto's USB service over HTTP/2, for clients in any
+// language with gRPC, or Client.  It is an http.Handler, but Serve and
+// ServeTLS should be used to run it: they tie the devices a client opens
+// to its connection and close them when it goes away, as the remote
+// package does.  Mounted on another http.Server, devices stay open until
+// the client closes them.
+type Server struct {
+	// Token is what clients must present, as "authorization: Bearer
+	// <token>" metadata.  Leave it empty only when the TLS configuration
+	// verifies client certificates.
+	Token string
+	// Allow, if set, picks the devices clients may list and open.
+	Allow func(di *usb.DeviceInfo) bool
+	// Opener is where the devices come from, usb.Local if nil; a
+	// remote.Client relays another machine's.
+	Opener usb.Opener
+
+	lock     sync.Mutex
+	sessions map[net.Conn]*session
+	shared   *session // for connections Serve didn't accept
+}
+
+// session is the devices one connection opened
+type session struct {
+	lock    sync.Mutex
+	next    uint32
+	handles map[uint32]usb.Conn
+}
+
+type sessionKey struct{}
+
+func (ss *session) close() {
+	ss.lock.Lock()
+	handles := ss.handles
+	ss.handles = map[uint32]usb.Conn{}
+	ss.lock.Unlock()
+	for _, u := range handles {
+		u.Close()
+	}
+}
+
+// Serve accepts unencrypted HTTP/2 connections on l until it fails.
+func (s *Server) Serve(l net.Listener) error {
+	p := new(http.Protocols)
+	p.SetUnencryptedHTTP2(true)
+	return s.httpServer(p).Serve(l)
+}
+
+// ServeTLS accepts HTTP/2 connections over TLS on l until it fails.  cfg
+// must hold the server's certificate.
+func (s *Server) ServeTLS(l net.Listener, cfg *tls.Config) error {
+	p := new(http.Protocols)
+	p.SetHTTP2(true)
+	hs := s.httpServer(p)
+	hs.TLSConfig = cfg
+	return hs.ServeTLS(l, "", "")
+}
+
+// ListenAndServeTLS accepts HTTP/2 connections over TLS on addr.
+func (s *Server) ListenAndServeTLS(addr string, cfg *tls.Config) error {
+	l, e := net.Listen("tcp", addr)
+	if e != nil {
+		return e
+	}
+	defer l.Close()
+	return s.ServeTLS(l, cfg)
+}
+
+func (s *Server) httpServer(p *http.Protocols) *http.Server {
+	return &http.Server{
+		Handler:   s,
+		Protocols: p,
+		ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+			ss := &session{handles: map[uint32]usb.Conn{}}
+			s.lock.Lock()
+			if s.sessions == nil {
+				s.sessions = map[net.Conn]*session{}
+			}
+			s.sessions[c] = ss
+			s.lock.Unlock()
+			return context.WithValue(ctx, sessionKey{}, ss)
+		},
+		ConnState: func(c net.Conn, st http.ConnState) {
+			if st != http.StateClosed && st != http.StateHijacked {
+				return
+			}
+			s.lock.Lock()
+			ss := s.sessions[c]
+			delete(s.sessions, c)
+			s.lock.Unlock()
+			if ss != nil {
+				ss.close()
+			}
+		},
+	}
+}
+
+func (s *Server) session(ctx context.Context) *session {
+	if ss, ok := ctx.Value(sessionKey{}).(*session); ok {
+		return ss
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.shared == nil {
+		s.shared = &session{handles: map[uint32]usb.Conn{}}
+	}
+	return s.shared
+}
+
+func (s *Server) opener() usb.Opener {
+	if s.Opener == nil {
+		return usb.Local
+	}
+	return s.Opener
+}
+
+const servicePath = "/usb.USB/"
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ct := r.Header.Get("Content-Type")
+	if r.Method != http.MethodPost || r.ProtoMajor != 2 ||
+		ct != "application/grpc" && !strings.HasPrefix(ct, "application/grpc+proto") {
+		http.Error(w, "gRPC only", http.StatusUnsupportedMediaType)
+		return
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+	w.WriteHeader(http.StatusOK)
+	e := s.serve(w, r)
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(statusCode(e)))
+	if e != nil {
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", urlEscape(e.Error()))
+		var errno syscall.Errno
+		if errors.As(e, &errno) {
+			w.Header().Set(http.TrailerPrefix+errnoTrailer, strconv.Itoa(int(errno)))
+		}
+	}
+}
+
+func statusCode(e error) int {
+	var st *Status
+	switch {
+	case e == nil:
+		return CODE_OK
+	case errors.As(e, &st):
+		return st.Code
+	}
+	return code(e)
+}
+
+// urlEscape percent-encodes a grpc-message as the protocol asks
+func urlEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < 0x20 || c > 0x7e || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) error {
+	if s.Token != "" {
+		got := r.Header.Get("Authorization")
+		if subtle.ConstantTimeCompare([]byte(got), []byte("Bearer "+s.Token)) != 1 {
+			return &Status{CODE_UNAUTHENTICATED, "token rejected"}
+		}
+	}
+	if enc := r.Header.Get("Grpc-Encoding"); enc != "" && enc != "identity" {
+		return &Status{CODE_UNIMPLEMENTED, errCompressed.Error()}
+	}
+	method, ok := strings.CutPrefix(r.URL.Path, servicePath)
+	if !ok {
+		return &Status{CODE_UNIMPLEMENTED, "unknown service"}
+	}
+	ss := s.session(r.Context())
+	if method == "Stream" {
+		return s.stream(w, r, ss)
+	}
+	req, e := readMessage(r.Body)
+	if e == io.EOF {
+		e = errMessage
+	}
+	if e != nil {
+		return &Status{CODE_INVALID_ARGUMENT, e.Error()}
+	}
+	reply, e := s.call(ss, method, req)
+	if e != nil {
+		return e
+	}
+	return writeMessage(w, reply)
+}
+
+func (s *Server) list() ([]*usb.DeviceInfo, error) {
+	devs, e := s.opener().ListDevices()
+	if e != nil || s.Allow == nil {
+		return devs, e
+	}
+	var list []*usb.DeviceInfo
+	for _, di := range devs {
+		if s.Allow(di) {
+			list = append(list, di)
+		}
+	}
+	return list, nil
+}
+
+// call runs one of the unary methods
+func (s *Server) call(ss *session, method string, req *message) (encoder, error) {
+	var reply encoder
+	switch method {
+	case "ListDevices":
+		devs, e := s.list()
+		if e != nil {
+			return nil, e
+		}
+		vid, pid := req.uint(1), req.uint(2)
+		for _, di := range devs {
+			if vid != 0 && uint32(di.VendorID) != vid || pid != 0 && uint32(di.ProductID) != pid {
+				continue
+			}
+			desc, e := di.Descriptors()
+			if e != nil {
+				continue
+			}
+			var d encoder
+			d.uint(1, uint32(di.BusNum))
+			d.uint(2, uint32(di.DevNum))
+			ports := make([]uint32, len(di.Ports))
+			for i, p := range di.Ports {
+				ports[i] = uint32(p)
+			}
+			d.packed(3, ports)
+			d.bytes(4, desc)
+			reply.message(1, d)
+		}
+		return reply, nil
+	case "Open":
+		bus, dev := int(req.uint(1)), int(req.uint(2))
+		devs, e := s.list()
+		if e != nil {
+			return nil, e
+		}
+		for _, di := range devs {
+			if di.BusNum != bus || di.DevNum != dev {
+				continue
+			}
+			u, e := s.opener().OpenDevice(di)
+			if e != nil {
+				return nil, e
+			}
+			ss.lock.Lock()
+			ss.next++
+			h := ss.next
+			ss.handles[h] = u
+			ss.lock.Unlock()
+			reply.uint(1, h)
+			return reply, nil
+		}
+		return nil, syscall.ENODEV
+	}
+	// the rest act on an open device, whose handle is field 1
+	h := req.uint(1)
+	ss.lock.Lock()
+	u := ss.handles[h]
+	if method == "Close" {
+		delete(ss.handles, h)
+	}
+	ss.lock.Unlock()
+	if u == nil {
+		return nil, syscall.EBADF
+	}
+	switch method {
+	case "Close":
+		u.Close()
+		return reply, nil
+	case "Claim":
+		return reply, u.ClaimInterface(req.uint(2))
+	case "Release":
+		return reply, u.ReleaseInterface(req.uint(2))
+	case "SetConfiguration":
+		return reply, u.SetConfiguration(uint8(req.uint(2)))
+	case "SetInterface":
+		return reply, u.SetInterface(uint8(req.uint(2)), uint8(req.uint(3)))
+	case "ClearHalt":
+		return reply, u.ClearHalt(uint8(req.uint(2)))
+	case "Reset":
+		return reply, u.Reset()
+	case "Control":
+		reqtype := uint8(req.uint(2))
+		length := req.uint(6)
+		if length > 0xffff {
+			return nil, syscall.EINVAL
+		}
+		data := make([]byte, length)
+		if reqtype&usb.REQTYPE_IN == 0 {
+			copy(data, req.data(8))
+		}
+		n, e := u.ControlTransfer(reqtype, uint8(req.uint(3)), uint16(req.uint(4)), uint16(req.uint(5)),
+			uint16(length), req.uint(7), data)
+		if reqtype&usb.REQTYPE_IN == 0 {
+			data = nil
+		}
+		return transferReply(n, data, e), nil
+	case "Bulk":
+		ep, length := req.uint(2), req.uint(3)
+		if length > maxMessage-64 {
+			return nil, syscall.EMSGSIZE
+		}
+		data := make([]byte, length)
+		in := ep&usb.ENDPOINT_IN != 0
+		if !in {
+			copy(data, req.data(5))
+		}
+		n, _, e := u.BulkTransfer(ep, length, req.uint(4), data)
+		if !in {
+			data = nil
+		}
+		return transferReply(n, data, e), nil
+	}
+	return nil, &Status{CODE_UNIMPLEMENTED, "unknown method " + method}
+}
+
+// transferReply reports a transfer's outcome in the reply itself, since
+// a failed transfer may still have moved data
+func transferReply(n int, data []byte, e error) encoder {
+	var reply encoder
+	n = max(n, 0)
+	reply.uint(1, uint32(n))
+	if data != nil {
+		reply.bytes(2, data[:min(n, len(data))])
+	}
+	// as in remote, an errno is sent alone and anything else as text
+	var errno syscall.Errno
+	if errors.As(e, &errno) {
+		reply.int(3, int32(errno))
+	} else if e != nil {
+		reply.string(4, e.Error())
+	}
+	return reply
+}
+
+// streamDepth is how many Stream transfers may be queued on the device
+// while earlier ones are still to be answered
+const streamDepth = 16
+
+// stream runs Stream: on a local device each request is submitted as a
+// URB as soon as it is read, so several are queued at once, and the
+// replies go back in the order the requests came; on a device that can
+// only do synchronous transfers they are done one at a time.
+func (s *Server) stream(w http.ResponseWriter, r *http.Request, ss *session) error {
+	ctx := r.Context()
+	flush := http.NewResponseController(w).Flush
+	// the client may wait for the headers before sending anything
+	if e := flush(); e != nil {
+		return e
+	}
+	replies := make(chan func() encoder, streamDepth)
+	var readErr error
+	go func() {
+		defer close(replies)
+		for {
+			req, e := readMessage(r.Body)
+			if e != nil {
+				if e != io.EOF && ctx.Err() == nil {
+					readErr = &Status{CODE_INVALID_ARGUMENT, e.Error()}
+				}
+				return
+			}
+			f, e := s.streamTransfer(ctx, ss, req)
+			if e != nil {
+				readErr = e
+				return
+			}
+			select {
+			case replies <- f:
+			case <-ctx.Done():
+				f()
+				return
+			}
+		}
+	}()
+	var err error
+	for f := range replies {
+		reply := f()
+		if err == nil {
+			if err = writeMessage(w, reply); err == nil {
+				err = flush()
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return readErr
+}
+
+// streamTransfer starts one Stream request and returns what waits for
+// its reply
+func (s *Server) streamTransfer(ctx context.Context, ss *session, req *message) (func() encoder, error) {
+	ss.lock.Lock()
+	u := ss.handles[req.uint(1)]
+	ss.lock.Unlock()
+	if u == nil {
+		return nil, syscall.EBADF
+	}
+	ep, length, timeout := req.uint(2), req.uint(3), req.uint(4)
+	if length > maxMessage-64 {
+		return nil, syscall.EMSGSIZE
+	}
+	in := ep&usb.ENDPOINT_IN != 0
+	data := make([]byte, length)
+	if !in {
+		copy(data, req.data(5))
+	}
+	dev, ok := u.(*usb.Device)
+	if !ok {
+		return func() encoder {
+			n, _, e := u.BulkTransfer(ep, length, timeout, data)
+			if !in {
+				data = nil
+			}
+			return transferReply(n, data, e)
+		}, nil
+	}
+	// the kernel turns a bulk URB on an interrupt endpoint into an
+	// interrupt one
+	x := &usb.Transfer{Type: usb.URB_TYPE_BULK, Endpoint: uint8(ep), Data: data, Done: make(chan *usb.Transfer, 1)}
+	if e := dev.SubmitTransfer(x); e != nil {
+		return func() encoder { return transferReply(0, nil, e) }, nil
+	}
+	var timer *time.Timer
+	var timedOut atomic.Bool
+	if timeout > 0 {
+		timer = time.AfterFunc(time.Duration(timeout)*time.Millisecond, func() {
+			timedOut.Store(true)
+			dev.CancelTransfer(x)
+		})
+	}
+	return func() encoder {
+		select {
+		case <-x.Done:
+		case <-ctx.Done():
+			dev.CancelTransfer(x)
+			<-x.Done
+		}
+		e := x.Err()
+		if timer != nil {
+			timer.Stop()
+		}
+		if timedOut.Load() && e != nil {
+			e = usb.ErrTimeout
+		}
+		d := x.Data
+		if !in {
+			d = nil
+		}
+		return transferReply(int(x.Length), d, e)
+	}, nil
+}
@@ -0,0 +1,202 @@
+package grpc
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"syscall"
+)
+
+// The messages are few and flat, so rather than generated code they are
+// encoded by hand with the two protobuf wire types they use: varints for
+// the integers and length-delimited fields for bytes, strings, packed
+// repeated integers and nested messages.  Field numbers are usb.proto's.
+const (
+	wireVarint = 0
+	wire64     = 1
+	wireBytes  = 2
+	wire32     = 5
+)
+
+var errMessage = errors.New("grpc: malformed message")
+
+// encoder builds a message; proto3 leaves out fields at their zero value
+type encoder []byte
+
+func (e *encoder) key(field int, wire int) {
+	*e = binary.AppendUvarint(*e, uint64(field)<<3|uint64(wire))
+}
+
+func (e *encoder) uint(field int, v uint32) {
+	if v != 0 {
+		e.key(field, wireVarint)
+		*e = binary.AppendUvarint(*e, uint64(v))
+	}
+}
+
+// int encodes an int32 as protobuf does, a negative one sign extended to
+// ten bytes
+func (e *encoder) int(field int, v int32) {
+	if v != 0 {
+		e.key(field, wireVarint)
+		*e = binary.AppendUvarint(*e, uint64(int64(v)))
+	}
+}
+
+func (e *encoder) bytes(field int, v []byte) {
+	if len(v) != 0 {
+		e.key(field, wireBytes)
+		*e = binary.AppendUvarint(*e, uint64(len(v)))
+		*e = append(*e, v...)
+	}
+}
+
+func (e *encoder) string(field int, v string) {
+	e.bytes(field, []byte(v))
+}
+
+// message encodes a nested message, which is there even if empty
+func (e *encoder) message(field int, v encoder) {
+	e.key(field, wireBytes)
+	*e = binary.AppendUvarint(*e, uint64(len(v)))
+	*e = append(*e, v...)
+}
+
+func (e *encoder) packed(field int, vs []uint32) {
+	var p []byte
+	for _, v := range vs {
+		p = binary.AppendUvarint(p, uint64(v))
+	}
+	e.bytes(field, p)
+}
+
+// message is a decoded message: every value each field had, since a
+// repeated field appears once per element and a scalar's last value wins
+type message struct {
+	ints  map[int][]uint64
+	bytes map[int][][]byte
+}
+
+func parse(b []byte) (*message, error) {
+	m := &message{ints: map[int][]uint64{}, bytes: map[int][][]byte{}}
+	for len(b) > 0 {
+		k, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, errMessage
+		}
+		b = b[n:]
+		field := int(k >> 3)
+		switch k & 7 {
+		case wireVarint:
+			v, n := binary.Uvarint(b)
+			if n <= 0 {
+				return nil, errMessage
+			}
+			b = b[n:]
+			m.ints[field] = append(m.ints[field], v)
+		case wireBytes:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || l > uint64(len(b)-n) {
+				return nil, errMessage
+			}
+			m.bytes[field] = append(m.bytes[field], b[n:n+int(l)])
+			b = b[n+int(l):]
+		case wire64:
+			// fields from a later revision of usb.proto are skipped
+			if len(b) < 8 {
+				return nil, errMessage
+			}
+			b = b[8:]
+		case wire32:
+			if len(b) < 4 {
+				return nil, errMessage
+			}
+			b = b[4:]
+		default:
+			return nil, errMessage
+		}
+	}
+	return m, nil
+}
+
+func (m *message) uint(field int) uint32 {
+	vs := m.ints[field]
+	if len(vs) == 0 {
+		return 0
+	}
+	return uint32(vs[len(vs)-1])
+}
+
+func (m *message) int(field int) int32 {
+	return int32(m.uint(field))
+}
+
+func (m *message) data(field int) []byte {
+	vs := m.bytes[field]
+	if len(vs) == 0 {
+		return nil
+	}
+	return vs[len(vs)-1]
+}
+
+// all is every element of a repeated message field
+func (m *message) all(field int) [][]byte {
+	return m.bytes[field]
+}
+
+// uints is a repeated integer field, which may come packed or not
+func (m *message) uints(field int) ([]uint32, error) {
+	var out []uint32
+	for _, v := range m.ints[field] {
+		out = append(out, uint32(v))
+	}
+	for _, p := range m.bytes[field] {
+		for len(p) > 0 {
+			v, n := binary.Uvarint(p)
+			if n <= 0 {
+				return nil, errMessage
+			}
+			out = append(out, uint32(v))
+			p = p[n:]
+		}
+	}
+	return out, nil
+}
+
+// gRPC carries each message in a frame on the HTTP/2 stream: a flag
+// byte saying whether it is compressed, its length as a 32-bit big-endian
+// number, and the message.  Nothing here compresses or accepts
+// compression.
+const maxMessage = 16 << 20
+
+func writeMessage(w io.Writer, m encoder) error {
+	f := make([]byte, 5, 5+len(m))
+	binary.BigEndian.PutUint32(f[1:], uint32(len(m)))
+	_, e := w.Write(append(f, m...))
+	return e
+}
+
+// readMessage returns io.EOF at the end of the stream
+func readMessage(r io.Reader) (*message, error) {
+	var h [5]byte
+	if _, e := io.ReadFull(r, h[:]); e != nil {
+		if e == io.ErrUnexpectedEOF {
+			e = errMessage
+		}
+		return nil, e
+	}
+	if h[0] != 0 {
+		return nil, errCompressed
+	}
+	n := binary.BigEndian.Uint32(h[1:])
+	if n > maxMessage {
+		return nil, syscall.EMSGSIZE
+	}
+	b := make([]byte, n)
+	if _, e := io.ReadFull(r, b); e != nil {
+		return nil, errMessage
+	}
+	return parse(b)
+}
+
+var errCompressed = errors.New("grpc: compressed messages are not supported")
@@ -0,0 +1,87 @@
+package usb
+
+// Endpoint is one endpoint of an open Device, as far as its halt state
+// goes.  The device notes an endpoint as halted when a transfer on it
+// stalls, synchronously or through SubmitTransfer, and as running again
+// once ClearHalt succeeds on it or the device is reset or reconfigured.
+// SetInterface doesn't clear the note, since the device doesn't know
+// which endpoints the interface has; ClearHalt them.  Endpoint 0 stalls
+// only the request that failed, so it never shows as halted.
+type Endpoint struct {
+	u    *Device
+	addr uint8
+}
+
+// HaltFunc is told when an endpoint goes from running to halted.  It runs
+// on the goroutine that saw the stall, which for submitted transfers is
+// the one delivering completions, so it must not block.
+type HaltFunc func(endpoint uint8)
+
+type haltBox struct{ f HaltFunc }
+
+// Endpoint returns the endpoint with address addr.
+func (u *Device) Endpoint(addr uint8) *Endpoint {
+	return &Endpoint{u, addr}
+}
+
+func (ep *Endpoint) Address() uint8 {
+	return ep.addr
+}
+
+// Halted says whether a transfer on the endpoint stalled and the halt
+// hasn't been cleared since.
+func (ep *Endpoint) Halted() bool {
+	return ep.u.halted.Load()&haltBit(ep.addr) != 0
+}
+
+// ClearHalt is Device.ClearHalt on the endpoint.
+func (ep *Endpoint) ClearHalt() error {
+	return ep.u.ClearHalt(ep.addr)
+}
+
+// Halted lists the endpoints that are halted.
+func (u *Device) Halted() []uint8 {
+	var list []uint8
+	h := u.halted.Load()
+	for i := range 32 {
+		if h&(1<<i) != 0 {
+			list = append(list, uint8(i&0x0f)|uint8(i&0x10)<<3)
+		}
+	}
+	return list
+}
+
+// SetHaltFunc has f told about each endpoint that halts, or stops if f is
+// nil.
+func (u *Device) SetHaltFunc(f HaltFunc) {
+	u.haltFunc.Store(haltBox{f})
+}
+
+// haltBit is the endpoint's bit in Device.halted, laid out as queue
+// indexes them; 0 for endpoint 0
+func haltBit(ep uint8) uint32 {
+	if ep&0x0f == 0 {
+		return 0
+	}
+	i := ep & 0x0f
+	if ep&ENDPOINT_IN != 0 {
+		i += 16
+	}
+	return 1 << i
+}
+
+// halt notes a stall on ep
+func (u *Device) halt(ep uint8) {
+	bit := haltBit(ep)
+	if bit == 0 || u.halted.Or(bit)&bit != 0 {
+		return
+	}
+	if b, _ := u.haltFunc.Load().(haltBox); b.f != nil {
+		b.f(ep)
+	}
+}
+
+// unhalt forgets the stalls on the endpoints in mask
+func (u *Device) unhalt(mask uint32) {
+	u.halted.And(^mask)
+}
@@ -0,0 +1,63 @@
+package usb
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHaltBit(t *testing.T) {
+	for _, ep := range []uint8{0x00, 0x80} {
+		if b := haltBit(ep); b != 0 {
+			t.Errorf("haltBit(0x%02x) = %#x, want 0", ep, b)
+		}
+	}
+	seen := map[uint32]uint8{}
+	for n := uint8(1); n < 16; n++ {
+		for _, ep := range []uint8{n, n | ENDPOINT_IN} {
+			b := haltBit(ep)
+			if b == 0 || b&(b-1) != 0 {
+				t.Errorf("haltBit(0x%02x) = %#x, want one bit", ep, b)
+			}
+			if other, ok := seen[b]; ok {
+				t.Errorf("haltBit(0x%02x) = haltBit(0x%02x)", ep, other)
+			}
+			seen[b] = ep
+		}
+	}
+}
+
+// TestHalted halts and unhalts endpoints on a Device that was never
+// opened, which is all the halt bookkeeping needs
+func TestHalted(t *testing.T) {
+	u := &Device{}
+	var told []uint8
+	u.SetHaltFunc(func(ep uint8) { told = append(told, ep) })
+	for _, ep := range []uint8{0x81, 0x02, 0x8f, 0x01, 0x81, 0x00, 0x80} {
+		u.halt(ep)
+	}
+	if want := []uint8{0x81, 0x02, 0x8f, 0x01}; !reflect.DeepEqual(told, want) {
+		t.Errorf("HaltFunc told of %#x, want %#x", told, want)
+	}
+	if got, want := u.Halted(), []uint8{0x01, 0x02, 0x81, 0x8f}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Halted() = %#x, want %#x", got, want)
+	}
+	if !u.Endpoint(0x81).Halted() || u.Endpoint(0x82).Halted() || u.Endpoint(0x00).Halted() {
+		t.Error("Endpoint.Halted disagrees with Halted")
+	}
+
+	u.unhalt(haltBit(0x81) | haltBit(0x02))
+	if got, want := u.Halted(), []uint8{0x01, 0x8f}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after unhalt, Halted() = %#x, want %#x", got, want)
+	}
+	told = nil
+	u.halt(0x81)
+	if want := []uint8{0x81}; !reflect.DeepEqual(told, want) {
+		t.Errorf("halting again told of %#x, want %#x", told, want)
+	}
+
+	u.SetHaltFunc(nil)
+	u.halt(0x03)
+	if len(told) != 1 {
+		t.Error("HaltFunc told after SetHaltFunc(nil)")
+	}
+}
@@ -0,0 +1,202 @@
+// Package hid implements the USB Human Interface Device class on top of
+// package usb.
+package hid
+
+import "errors"
+
+var ErrShortReport = errors.New("hid: report too short")
+
+// Modifier bits in byte 0 of a boot keyboard report
+type Modifiers uint8
+
+const (
+	MOD_LEFT_CTRL Modifiers = 1 << iota
+	MOD_LEFT_SHIFT
+	MOD_LEFT_ALT
+	MOD_LEFT_GUI
+	MOD_RIGHT_CTRL
+	MOD_RIGHT_SHIFT
+	MOD_RIGHT_ALT
+	MOD_RIGHT_GUI
+)
+
+func (m Modifiers) Ctrl() bool  { return m&(MOD_LEFT_CTRL|MOD_RIGHT_CTRL) != 0 }
+func (m Modifiers) Shift() bool { return m&(MOD_LEFT_SHIFT|MOD_RIGHT_SHIFT) != 0 }
+func (m Modifiers) Alt() bool   { return m&(MOD_LEFT_ALT|MOD_RIGHT_ALT) != 0 }
+func (m Modifiers) GUI() bool   { return m&(MOD_LEFT_GUI|MOD_RIGHT_GUI) != 0 }
+
+const (
+	KEY_ERROR_ROLLOVER = 0x01 // reported in every slot when too many keys are down
+	KEY_LEFT_CTRL      = 0xe0 // modifiers as key usages, LEFT_CTRL..RIGHT_GUI
+)
+
+// KeyboardReport is a decoded 8-byte boot protocol keyboard report.
+type KeyboardReport struct {
+	Modifiers Modifiers
+	Keys      []uint8 // usages of the keys held down, in report order
+	Rollover  bool    // more keys are down than the report can describe
+}
+
+func ParseKeyboardReport(b []byte) (KeyboardReport, error) {
+	var r KeyboardReport
+	if len(b) < 8 {
+		return r, ErrShortReport
+	}
+	r.Modifiers = Modifiers(b[0])
+	for _, k := range b[2:8] {
+		switch k {
+		case 0:
+		case KEY_ERROR_ROLLOVER:
+			r.Rollover = true
+		default:
+			r.Keys = append(r.Keys, k)
+		}
+	}
+	return r, nil
+}
+
+// KeyEvent is a key or modifier changing state.  Modifiers are reported
+// with their keyboard usages 0xe0-0xe7.
+type KeyEvent struct {
+	Key  uint8
+	Down bool
+}
+
+// KeyboardState turns a stream of boot reports into press and release
+// events.  Rollover reports carry no key information, so they leave the
+// key state unchanged rather than reporting everything released.
+type KeyboardState struct {
+	Modifiers Modifiers
+	down      [256]bool
+}
+
+func (s *KeyboardState) Update(b []byte) ([]KeyEvent, error) {
+	r, e := ParseKeyboardReport(b)
+	if e != nil {
+		return nil, e
+	}
+	var events []KeyEvent
+	for i := 0; i < 8; i++ {
+		bit := Modifiers(1 << i)
+		if (s.Modifiers^r.Modifiers)&bit != 0 {
+			events = append(events, KeyEvent{uint8(KEY_LEFT_CTRL + i), r.Modifiers&bit != 0})
+		}
+	}
+	s.Modifiers = r.Modifiers
+	if r.Rollover {
+		return events, nil
+	}
+	var now [256]bool
+	for _, k := range r.Keys {
+		now[k] = true
+	}
+	for k := 0; k < 256; k++ {
+		if k >= KEY_LEFT_CTRL && k < KEY_LEFT_CTRL+8 {
+			continue
+		}
+		if now[k] != s.down[k] {
+			events = append(events, KeyEvent{uint8(k), now[k]})
+		}
+	}
+	s.down = now
+	return events, nil
+}
+
+// Down reports whether key is currently held.
+func (s *KeyboardState) Down(key uint8) bool {
+	if key >= KEY_LEFT_CTRL && key < KEY_LEFT_CTRL+8 {
+		return s.Modifiers&(1<<(key-KEY_LEFT_CTRL)) != 0
+	}
+	return s.down[key]
+}
+
+// US layout for usages 0x04-0x38, unshifted and shifted
+const (
+	keysLower = "abcdefghijklmnopqrstuvwxyz1234567890\n\x1b\b\t -=[]\\#;'`,./"
+	keysUpper = "ABCDEFGHIJKLMNOPQRSTUVWXYZ!@#$%^&*()\n\x1b\b\t _+{}|~:\"~<>?"
+)
+
+// Rune maps a key usage to the character it types on a US keyboard, or 0
+// if it doesn't produce one.
+func Rune(key uint8, mods Modifiers) rune {
+	if key < 0x04 || int(key-0x04) >= len(keysLower) {
+		return 0
+	}
+	if mods.Shift() {
+		return rune(keysUpper[key-0x04])
+	}
+	return rune(keysLower[key-0x04])
+}
+
+// Mouse button bits
+const (
+	BUTTON_LEFT = 1 << iota
+	BUTTON_RIGHT
+	BUTTON_MIDDLE
+)
+
+// MouseReport is a decoded boot protocol mouse report.  X, Y and Wheel are
+// relative motion; Wheel is only present if the device sends a 4th byte.
+type MouseReport struct {
+	Buttons uint8
+	X, Y    int
+	Wheel   int
+}
+
+func ParseMouseReport(b []byte) (MouseReport, error) {
+	var r MouseReport
+	if len(b) < 3 {
+		return r, ErrShortReport
+	}
+	r.Buttons = b[0]
+	r.X = int(int8(b[1]))
+	r.Y = int(int8(b[2]))
+	if len(b) > 3 {
+		r.Wheel = int(int8(b[3]))
+	}
+	return r, nil
+}
+
+// ParseAbsolutePointer decodes the common absolute pointer layout used by
+// tablets, touchscreens and KVM emulated mice: a button byte followed by
+// 16-bit little-endian X and Y, and an optional wheel byte.
+func ParseAbsolutePointer(b []byte) (MouseReport, error) {
+	var r MouseReport
+	if len(b) < 5 {
+		return r, ErrShortReport
+	}
+	r.Buttons = b[0]
+	r.X = int(uint16(b[1]) | uint16(b[2])<<8)
+	r.Y = int(uint16(b[3]) | uint16(b[4])<<8)
+	if len(b) > 5 {
+		r.Wheel = int(int8(b[5]))
+	}
+	return r, nil
+}
+
+// MouseState integrates relative reports into an absolute position,
+// clamped to [0, Width) x [0, Height) when those are set.
+type MouseState struct {
+	X, Y          int
+	Width, Height int
+	Buttons       uint8
+}
+
+func (s *MouseState) Update(r MouseReport) {
+	s.X = clamp(s.X+r.X, s.Width)
+	s.Y = clamp(s.Y+r.Y, s.Height)
+	s.Buttons = r.Buttons
+}
+
+func clamp(v int, limit int) int {
+	if limit <= 0 {
+		return v
+	}
+	if v < 0 {
+		return 0
+	}
+	if v >= limit {
+		return limit - 1
+	}
+	return v
+}
@@ -0,0 +1,150 @@
+package hid_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/richardnwinder/usb/hid"
+)
+
+func TestParseKeyboardReport(t *testing.T) {
+	for _, c := range []struct {
+		report []byte
+		want   string
+	}{
+		{[]byte{0, 0, 0, 0, 0, 0, 0, 0}, "{Modifiers:0 Keys:[] Rollover:false}"},
+		// left shift and right alt, with A and 1 down; byte 1 is reserved
+		{[]byte{0x42, 0xff, hid.KEY_A, 0x1e, 0, 0, 0, 0}, "{Modifiers:66 Keys:[4 30] Rollover:false}"},
+		{[]byte{0x01, 0, 1, 1, 1, 1, 1, 1}, "{Modifiers:1 Keys:[] Rollover:true}"},
+		// a report longer than the boot protocol's, as some send
+		{[]byte{0, 0, 0, 0, 0, 0, 0, hid.KEY_B, 0xaa}, "{Modifiers:0 Keys:[5] Rollover:false}"},
+	} {
+		r, e := hid.ParseKeyboardReport(c.report)
+		if e != nil {
+			t.Errorf("% x: %v", c.report, e)
+			continue
+		}
+		if got := fmt.Sprintf("%+v", r); got != c.want {
+			t.Errorf("% x: %s, want %s", c.report, got, c.want)
+		}
+	}
+	for n := 0; n < 8; n++ {
+		if _, e := hid.ParseKeyboardReport(make([]byte, n)); !errors.Is(e, hid.ErrShortReport) {
+			t.Errorf("%d byte report: %v, want %v", n, e, hid.ErrShortReport)
+		}
+	}
+}
+
+func TestModifiers(t *testing.T) {
+	m := hid.MOD_RIGHT_CTRL | hid.MOD_LEFT_SHIFT
+	if !m.Ctrl() || !m.Shift() || m.Alt() || m.GUI() {
+		t.Errorf("%08b: ctrl %v shift %v alt %v gui %v", m, m.Ctrl(), m.Shift(), m.Alt(), m.GUI())
+	}
+}
+
+func TestKeyboardState(t *testing.T) {
+	var s hid.KeyboardState
+	steps := []struct {
+		report []byte
+		want   []hid.KeyEvent
+	}{
+		{[]byte{0, 0, hid.KEY_A, 0, 0, 0, 0, 0}, []hid.KeyEvent{{hid.KEY_A, true}}},
+		// shift down, B added in another slot
+		{[]byte{0x02, 0, 0, hid.KEY_A, hid.KEY_B, 0, 0, 0}, []hid.KeyEvent{{hid.KEY_LEFT_CTRL + 1, true}, {hid.KEY_B, true}}},
+		// rollover: the modifiers are still good, the keys are not
+		{[]byte{0, 0, 1, 1, 1, 1, 1, 1}, []hid.KeyEvent{{hid.KEY_LEFT_CTRL + 1, false}}},
+		{[]byte{0, 0, hid.KEY_B, 0, 0, 0, 0, 0}, []hid.KeyEvent{{hid.KEY_A, false}}},
+		{[]byte{0, 0, 0, 0, 0, 0, 0, 0}, []hid.KeyEvent{{hid.KEY_B, false}}},
+	}
+	for i, step := range steps {
+		events, e := s.Update(step.report)
+		if e != nil {
+			t.Fatalf("step %d: %v", i, e)
+		}
+		if fmt.Sprint(events) != fmt.Sprint(step.want) {
+			t.Errorf("step %d: events %v, want %v", i, events, step.want)
+		}
+		if i == 1 && (!s.Down(hid.KEY_A) || !s.Down(hid.KEY_B) || !s.Down(hid.KEY_LEFT_CTRL+1) || s.Down(hid.KEY_LEFT_CTRL)) {
+			t.Errorf("step %d: A %v, B %v, left shift %v, left ctrl %v", i,
+				s.Down(hid.KEY_A), s.Down(hid.KEY_B), s.Down(hid.KEY_LEFT_CTRL+1), s.Down(hid.KEY_LEFT_CTRL))
+		}
+		if i == 2 && !s.Down(hid.KEY_A) {
+			t.Errorf("A released by a rollover report")
+		}
+	}
+	if _, e := s.Update([]byte{0, 0, 0}); !errors.Is(e, hid.ErrShortReport) {
+		t.Errorf("short report: %v", e)
+	}
+}
+
+func TestRune(t *testing.T) {
+	for _, c := range []struct {
+		key  uint8
+		mods hid.Modifiers
+		want rune
+	}{
+		{hid.KEY_A, 0, 'a'},
+		{hid.KEY_A, hid.MOD_RIGHT_SHIFT, 'A'},
+		{0x1e, 0, '1'},
+		{0x1e, hid.MOD_LEFT_SHIFT, '!'},
+		{0x28, 0, '\n'},
+		{0x38, hid.MOD_LEFT_SHIFT, '?'},
+		{0x39, 0, 0}, // caps lock
+		{0x00, 0, 0},
+		{hid.KEY_LEFT_CTRL, 0, 0},
+	} {
+		if got := hid.Rune(c.key, c.mods); got != c.want {
+			t.Errorf("Rune(%#x, %08b) = %q, want %q", c.key, c.mods, got, c.want)
+		}
+	}
+}
+
+func TestParseMouseReport(t *testing.T) {
+	for _, c := range []struct {
+		report []byte
+		want   hid.MouseReport
+	}{
+		{[]byte{hid.BUTTON_LEFT, 5, 0xfb}, hid.MouseReport{Buttons: hid.BUTTON_LEFT, X: 5, Y: -5}},
+		{[]byte{hid.BUTTON_RIGHT | hid.BUTTON_MIDDLE, 0x80, 0x7f, 0xff}, hid.MouseReport{Buttons: 6, X: -128, Y: 127, Wheel: -1}},
+	} {
+		r, e := hid.ParseMouseReport(c.report)
+		if e != nil || r != c.want {
+			t.Errorf("% x: %+v, %v; want %+v", c.report, r, e, c.want)
+		}
+	}
+	if _, e := hid.ParseMouseReport([]byte{0, 1}); !errors.Is(e, hid.ErrShortReport) {
+		t.Errorf("short report: %v", e)
+	}
+
+	r, e := hid.ParseAbsolutePointer([]byte{hid.BUTTON_LEFT, 0x34, 0x12, 0xff, 0x7f, 0x02})
+	if want := (hid.MouseReport{Buttons: 1, X: 0x1234, Y: 0x7fff, Wheel: 2}); e != nil || r != want {
+		t.Errorf("absolute: %+v, %v; want %+v", r, e, want)
+	}
+	if _, e := hid.ParseAbsolutePointer([]byte{0, 1, 2, 3}); !errors.Is(e, hid.ErrShortReport) {
+		t.Errorf("short absolute report: %v", e)
+	}
+}
+
+func TestMouseState(t *testing.T) {
+	s := hid.MouseState{Width: 100, Height: 50}
+	for _, c := range []struct {
+		r    hid.MouseReport
+		x, y int
+	}{
+		{hid.MouseReport{X: 10, Y: 10}, 10, 10},
+		{hid.MouseReport{X: -20, Y: 100}, 0, 49},
+		{hid.MouseReport{X: 127, Y: -3, Buttons: hid.BUTTON_LEFT}, 99, 46},
+	} {
+		s.Update(c.r)
+		if s.X != c.x || s.Y != c.y || s.Buttons != c.r.Buttons {
+			t.Errorf("after %+v at %d,%d buttons %d; want %d,%d", c.r, s.X, s.Y, s.Buttons, c.x, c.y)
+		}
+	}
+	// unbounded without a size
+	var free hid.MouseState
+	free.Update(hid.MouseReport{X: -5, Y: -7})
+	if free.X != -5 || free.Y != -7 {
+		t.Errorf("unbounded at %d,%d, want -5,-7", free.X, free.Y)
+	}
+}
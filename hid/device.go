@@ -0,0 +1,105 @@
+package hid
+
+import (
+	"errors"
+	"syscall"
+
+	"github.com/richardnwinder/usb"
+)
+
+const (
+	CLASS_HID     = 0x03
+	SUBCLASS_BOOT = 0x01
+
+	DT_HID    = 0x21
+	DT_REPORT = 0x22
+)
+
+// Device is a claimed HID interface, reading input reports from its
+// interrupt IN endpoint and writing output reports to its interrupt OUT
+// endpoint.
+type Device struct {
+	dev     usb.Conn
+	ifc     uint8
+	in      uint8
+	out     uint8 // 0 if the interface has no OUT endpoint
+	InSize  int   // wMaxPacketSize of the IN endpoint
+	OutSize int   // wMaxPacketSize of the OUT endpoint
+	rdlen   int   // report descriptor length from the HID descriptor
+}
+
+// NewDevice claims the HID interface ii on dev, detaching usbhid if the
+// kernel has bound it and dev is a local device.
+func NewDevice(dev usb.Conn, ii *usb.InterfaceInfo) (*Device, error) {
+	if ii.InterfaceClass != CLASS_HID {
+		return nil, syscall.EINVAL
+	}
+	d := &Device{dev: dev, ifc: ii.InterfaceNumber}
+	for x := ii.Extra; len(x) >= 2 && x[0] >= 2 && int(x[0]) <= len(x); x = x[x[0]:] {
+		// bcdHID, country and count, then type and length pairs
+		if x[1] != DT_HID {
+			continue
+		}
+		for i := 6; i+3 <= int(x[0]); i += 3 {
+			if x[i] == DT_REPORT {
+				d.rdlen = int(x[i+1]) | int(x[i+2])<<8
+				break
+			}
+		}
+	}
+	for _, ep := range ii.Endpoint {
+		if ep.Attributes&usb.ENDPOINT_XFER_MASK != usb.ENDPOINT_XFER_INT {
+			continue
+		}
+		if ep.EndpointAddress&usb.ENDPOINT_IN != 0 {
+			d.in = ep.EndpointAddress
+			d.InSize = int(ep.MaxPacketSize & 0x7ff)
+		} else {
+			d.out = ep.EndpointAddress
+			d.OutSize = int(ep.MaxPacketSize & 0x7ff)
+		}
+	}
+	if d.in == 0 {
+		return nil, syscall.ENODEV
+	}
+	e := dev.ClaimInterface(uint32(d.ifc))
+	if k, ok := dev.(interface{ DisconnectDriver(uint8) error }); ok && errors.Is(e, syscall.EBUSY) {
+		k.DisconnectDriver(d.ifc)
+		e = dev.ClaimInterface(uint32(d.ifc))
+	}
+	if e != nil {
+		return nil, e
+	}
+	return d, nil
+}
+
+// Interface returns the interface number, used as wIndex in class requests.
+func (d *Device) Interface() uint8 {
+	return d.ifc
+}
+
+// Read waits up to timeout ms (0 for ever) for an input report.
+func (d *Device) Read(buf []byte, timeout uint32) (int, error) {
+	if len(buf) == 0 {
+		return 0, syscall.EINVAL
+	}
+	n, _, e := d.dev.BulkTransfer(uint32(d.in), uint32(len(buf)), timeout, buf)
+	return n, e
+}
+
+// Write sends an output report over the interrupt OUT endpoint.
+func (d *Device) Write(report []byte, timeout uint32) (int, error) {
+	if d.out == 0 {
+		return 0, syscall.EOPNOTSUPP
+	}
+	if len(report) == 0 {
+		return 0, syscall.EINVAL
+	}
+	n, _, e := d.dev.BulkTransfer(uint32(d.out), uint32(len(report)), timeout, report)
+	return n, e
+}
+
+// Close releases the interface.
+func (d *Device) Close() error {
+	return d.dev.ReleaseInterface(uint32(d.ifc))
+}
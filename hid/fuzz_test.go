@@ -0,0 +1,25 @@
+package hid_test
+
+import (
+	"testing"
+
+	"github.com/richardnwinder/usb/hid"
+)
+
+// FuzzReports feeds the boot protocol report parsers and the report
+// descriptor parser, which decode whatever the device sends.
+func FuzzReports(f *testing.F) {
+	for _, d := range [][]byte{keyboardDesc, mouseDesc, gamepadDesc} {
+		f.Add(d)
+	}
+	f.Add([]byte{0x02, 0, hid.KEY_A, hid.KEY_B, 0, 0, 0, 0})
+	f.Add([]byte{0, 0, 1, 1, 1, 1, 1, 1})
+	f.Add([]byte{hid.BUTTON_LEFT, 0x05, 0xfb, 0xff})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var k hid.KeyboardState
+		k.Update(data)
+		hid.ParseMouseReport(data)
+		hid.ParseAbsolutePointer(data)
+		hid.ParseReportDescriptor(data)
+	})
+}
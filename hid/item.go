@@ -0,0 +1,88 @@
+package hid
+
+import "fmt"
+
+// item types
+const (
+	ITEM_MAIN   = 0
+	ITEM_GLOBAL = 1
+	ITEM_LOCAL  = 2
+	ITEM_LONG   = 3 // the reserved type; long items are given it too
+)
+
+// Item is one item of a report descriptor, as Items splits it up.
+type Item struct {
+	Type uint8
+	Tag  uint8
+	Data []byte
+	Raw  []byte // the whole item, its prefix included
+}
+
+// Unsigned is the item's data as an unsigned little endian number.
+func (it *Item) Unsigned() uint32 {
+	var u uint32
+	for i, b := range it.Data[:min(len(it.Data), 4)] {
+		u |= uint32(b) << (8 * i)
+	}
+	return u
+}
+
+// Signed is the item's data sign-extended from its size.
+func (it *Item) Signed() int32 {
+	u := it.Unsigned()
+	if n := len(it.Data); n > 0 && n < 4 && u&(1<<(8*n-1)) != 0 {
+		u |= ^uint32(0) << (8 * n)
+	}
+	return int32(u)
+}
+
+var itemNames = [3]map[uint8]string{
+	ITEM_MAIN: {0x8: "Input", 0x9: "Output", 0xa: "Collection", 0xb: "Feature", 0xc: "End Collection"},
+	ITEM_GLOBAL: {0x0: "Usage Page", 0x1: "Logical Minimum", 0x2: "Logical Maximum",
+		0x3: "Physical Minimum", 0x4: "Physical Maximum", 0x5: "Unit Exponent", 0x6: "Unit",
+		0x7: "Report Size", 0x8: "Report ID", 0x9: "Report Count", 0xa: "Push", 0xb: "Pop"},
+	ITEM_LOCAL: {0x0: "Usage", 0x1: "Usage Minimum", 0x2: "Usage Maximum",
+		0x3: "Designator Index", 0x4: "Designator Minimum", 0x5: "Designator Maximum",
+		0x7: "String Index", 0x8: "String Minimum", 0x9: "String Maximum", 0xa: "Delimiter"},
+}
+
+// Name is the item's name in the HID specification, such as "Usage Page".
+func (it *Item) Name() string {
+	if it.Raw[0] == 0xfe {
+		return fmt.Sprintf("Long Item 0x%02x", it.Tag)
+	}
+	if it.Type < ITEM_LONG {
+		if s, ok := itemNames[it.Type][it.Tag]; ok {
+			return s
+		}
+	}
+	return fmt.Sprintf("Reserved 0x%02x", it.Raw[0])
+}
+
+// Items splits a report descriptor into items, without interpreting
+// them, for tools that show the descriptor as it was written.
+func Items(b []byte) ([]Item, error) {
+	var list []Item
+	for len(b) > 0 {
+		prefix := b[0]
+		if prefix == 0xfe {
+			if len(b) < 3 || len(b) < 3+int(b[1]) {
+				return list, ErrBadDescriptor
+			}
+			n := 3 + int(b[1])
+			list = append(list, Item{Type: ITEM_LONG, Tag: b[2], Data: b[3:n], Raw: b[:n]})
+			b = b[n:]
+			continue
+		}
+		size := int(prefix & 3)
+		if size == 3 {
+			size = 4
+		}
+		if len(b) < 1+size {
+			return list, ErrBadDescriptor
+		}
+		list = append(list, Item{Type: prefix >> 2 & 3, Tag: prefix >> 4, Data: b[1 : 1+size], Raw: b[:1+size]})
+		b = b[1+size:]
+	}
+	return list, nil
+}
@@ -0,0 +1,37 @@
+package hid
+
+// Output sends an output report, over the interrupt OUT endpoint if the
+// interface has one and with SET_REPORT otherwise.  reportID is 0 for
+// devices whose reports aren't numbered; for others it is put in front
+// of data, as both paths expect.
+func (d *Device) Output(reportID uint8, data []byte) error {
+	report := data
+	if reportID != 0 {
+		report = append([]byte{reportID}, data...)
+	}
+	if d.out != 0 {
+		_, e := d.Write(report, 1000)
+		return e
+	}
+	return d.SetReport(REPORT_OUTPUT, reportID, report)
+}
+
+// LEDs are the bits of the boot keyboard output report: bit n is LED
+// page usage n+1, so LEDBit(LED_CAPS_LOCK) is the caps lock bit.
+type LEDs uint8
+
+// LEDBit gives the bit for an LED page usage from LED_NUM_LOCK to LED_KANA.
+func LEDBit(usage uint16) LEDs {
+	if usage < LED_NUM_LOCK || usage > LED_KANA {
+		return 0
+	}
+	return 1 << (usage - 1)
+}
+
+// SetLEDs lights a keyboard's indicators, with the one-byte output
+// report of the boot protocol that nearly every keyboard also uses in
+// report protocol.  Keyboards whose LED report is numbered need Output
+// with the ID from their report descriptor instead.
+func (d *Device) SetLEDs(leds LEDs) error {
+	return d.Output(0, []byte{byte(leds)})
+}
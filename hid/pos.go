@@ -0,0 +1,156 @@
+package hid
+
+import "syscall"
+
+// value is one value of a field, as Find locates it
+type value struct {
+	f *Field
+	i int
+}
+
+func find(rd *ReportDescriptor, kind uint8, u Usage) value {
+	f, i := rd.Find(kind, u)
+	return value{f, i}
+}
+
+func (v value) raw(report []byte) (int32, bool) {
+	if v.f == nil {
+		return 0, false
+	}
+	return v.f.Raw(report, v.i)
+}
+
+// fieldBytes reads every value of a variable field as a byte string
+func fieldBytes(f *Field, report []byte) []byte {
+	b := make([]byte, 0, f.Count)
+	for i := 0; i < f.Count; i++ {
+		v, ok := f.Raw(report, i)
+		if !ok {
+			break
+		}
+		b = append(b, byte(v))
+	}
+	return b
+}
+
+// Barcode is a decoded scan from a HID POS bar code scanner.
+type Barcode struct {
+	// Symbology holds the symbology identifiers the scanner sends, the
+	// first usually AIM's ']' and the others the code, as in "]E0" for
+	// EAN-13.  Scanners that only send one leave the rest 0.
+	Symbology [3]byte
+	Data      []byte
+}
+
+// BarcodeDecoder pulls scans out of the Scanned Data Reports of a HID
+// POS scanner, putting together scans sent across several reports.
+type BarcodeDecoder struct {
+	id        uint8
+	symbology [3]value
+	data      *Field
+	continued value
+	pending   *Barcode
+}
+
+// NewBarcodeDecoder finds the scanned data report in a scanner's
+// report descriptor.  It gives ENODEV for devices without one, such as
+// scanners set up to emulate a keyboard.
+func NewBarcodeDecoder(rd *ReportDescriptor) (*BarcodeDecoder, error) {
+	d := &BarcodeDecoder{}
+	d.data, _ = rd.Find(REPORT_INPUT, MakeUsage(PAGE_BARCODE_SCANNER, BCS_DECODED_DATA))
+	if d.data == nil {
+		return nil, syscall.ENODEV
+	}
+	d.id = d.data.ReportID
+	for i := range d.symbology {
+		d.symbology[i] = find(rd, REPORT_INPUT, MakeUsage(PAGE_BARCODE_SCANNER, uint16(BCS_SYMBOLOGY_ID_1+i)))
+	}
+	d.continued = find(rd, REPORT_INPUT, MakeUsage(PAGE_BARCODE_SCANNER, BCS_DECODED_DATA_CONTINUED))
+	return d, nil
+}
+
+// Decode takes an input report and returns the scan it completes.
+// Other reports give nil.  The data field is sent at a fixed size, so
+// trailing NULs are trimmed from each report's share of it.
+func (d *BarcodeDecoder) Decode(report []byte) *Barcode {
+	if d.id != 0 && (len(report) == 0 || report[0] != d.id) {
+		return nil
+	}
+	if d.pending == nil {
+		d.pending = &Barcode{}
+		for i, f := range d.symbology {
+			if v, ok := f.raw(report); ok && f.f.ReportID == d.id {
+				d.pending.Symbology[i] = byte(v)
+			}
+		}
+	}
+	data := fieldBytes(d.data, report)
+	for len(data) > 0 && data[len(data)-1] == 0 {
+		data = data[:len(data)-1]
+	}
+	d.pending.Data = append(d.pending.Data, data...)
+	if v, ok := d.continued.raw(report); ok && d.continued.f.ReportID == d.id && v != 0 {
+		return nil
+	}
+	b := d.pending
+	d.pending = nil
+	return b
+}
+
+// Magstripe is a card swipe from a HID magnetic stripe reader: the data
+// of tracks 1 to 3 as the reader decoded them, nil for tracks it didn't
+// read.
+type Magstripe struct {
+	Tracks [3][]byte
+}
+
+// MagstripeDecoder reads swipes from readers that follow the HID POS
+// magnetic stripe usages.  Readers that emulate a keyboard instead type
+// the tracks out and need no decoder.
+type MagstripeDecoder struct {
+	id     uint8
+	length [3]value
+	data   [3]*Field
+}
+
+// NewMagstripeDecoder finds the track fields in a reader's report
+// descriptor, giving ENODEV if there are none.
+func NewMagstripeDecoder(rd *ReportDescriptor) (*MagstripeDecoder, error) {
+	d := &MagstripeDecoder{}
+	found := false
+	for i := 0; i < 3; i++ {
+		d.length[i] = find(rd, REPORT_INPUT, MakeUsage(PAGE_MSR, uint16(MSR_TRACK_1_LENGTH+i)))
+		d.data[i], _ = rd.Find(REPORT_INPUT, MakeUsage(PAGE_MSR, uint16(MSR_TRACK_1_DATA+i)))
+		if d.data[i] != nil {
+			d.id = d.data[i].ReportID
+			found = true
+		}
+	}
+	if !found {
+		return nil, syscall.ENODEV
+	}
+	return d, nil
+}
+
+// Decode takes an input report and returns the swipe in it, or nil for
+// other reports.
+func (d *MagstripeDecoder) Decode(report []byte) *Magstripe {
+	if d.id != 0 && (len(report) == 0 || report[0] != d.id) {
+		return nil
+	}
+	m := &Magstripe{}
+	for i := 0; i < 3; i++ {
+		f := d.data[i]
+		if f == nil || f.ReportID != d.id {
+			continue
+		}
+		data := fieldBytes(f, report)
+		if n, ok := d.length[i].raw(report); ok && d.length[i].f.ReportID == d.id && n >= 0 && int(n) <= len(data) {
+			data = data[:n]
+		}
+		if len(data) > 0 {
+			m.Tracks[i] = data
+		}
+	}
+	return m
+}
@@ -0,0 +1,233 @@
+package hid
+
+import (
+	"context"
+	"errors"
+	"syscall"
+	"time"
+)
+
+// PowerStatus is the state of a UPS or other HID power device.  Numeric
+// values the device doesn't report are -1.
+type PowerStatus struct {
+	Charge         float64       // percent of full charge
+	RunTime        time.Duration // estimated run time to empty
+	Load           float64       // percent of rated output
+	InputVoltage   float64       // volts
+	OutputVoltage  float64       // volts
+	BatteryVoltage float64       // volts
+
+	ACPresent          bool
+	Charging           bool
+	Discharging        bool
+	BelowCapacityLimit bool // the battery is low
+	NeedReplacement    bool
+	ShutdownImminent   bool
+	Overload           bool
+	InternalFailure    bool
+}
+
+// the HID unit for volts, whose exponent is relative to 10^7 as the
+// SI linear system counts in grams and centimetres
+const unitVolt = 0x00f0d121
+
+type powerField struct {
+	usage  Usage
+	within Usage // the collection it must be in, 0 for any
+}
+
+var (
+	pdRemaining = powerField{MakeUsage(PAGE_BATTERY_SYSTEM, BATTERY_REMAINING_CAPACITY), 0}
+	pdFull      = powerField{MakeUsage(PAGE_BATTERY_SYSTEM, BATTERY_FULL_CHARGE_CAPACITY), 0}
+	pdMode      = powerField{MakeUsage(PAGE_BATTERY_SYSTEM, BATTERY_CAPACITY_MODE), 0}
+	pdRunTime   = powerField{MakeUsage(PAGE_BATTERY_SYSTEM, BATTERY_RUN_TIME_TO_EMPTY), 0}
+	pdLoad      = powerField{MakeUsage(PAGE_POWER_DEVICE, POWER_PERCENT_LOAD), 0}
+	pdInVolts   = powerField{MakeUsage(PAGE_POWER_DEVICE, POWER_VOLTAGE), MakeUsage(PAGE_POWER_DEVICE, POWER_INPUT)}
+	pdOutVolts  = powerField{MakeUsage(PAGE_POWER_DEVICE, POWER_VOLTAGE), MakeUsage(PAGE_POWER_DEVICE, POWER_OUTPUT)}
+	pdBatVolts  = powerField{MakeUsage(PAGE_POWER_DEVICE, POWER_VOLTAGE), MakeUsage(PAGE_POWER_DEVICE, POWER_BATTERY)}
+	pdACPresent = powerField{MakeUsage(PAGE_BATTERY_SYSTEM, BATTERY_AC_PRESENT), 0}
+	pdCharging  = powerField{MakeUsage(PAGE_BATTERY_SYSTEM, BATTERY_CHARGING), 0}
+	pdDischarge = powerField{MakeUsage(PAGE_BATTERY_SYSTEM, BATTERY_DISCHARGING), 0}
+	pdLow       = powerField{MakeUsage(PAGE_BATTERY_SYSTEM, BATTERY_BELOW_REMAINING_CAPACITY_LIMIT), 0}
+	pdReplace   = powerField{MakeUsage(PAGE_BATTERY_SYSTEM, BATTERY_NEED_REPLACEMENT), 0}
+	pdImminent  = powerField{MakeUsage(PAGE_POWER_DEVICE, POWER_SHUTDOWN_IMMINENT), 0}
+	pdOverload  = powerField{MakeUsage(PAGE_POWER_DEVICE, POWER_OVERLOAD), 0}
+	pdFailure   = powerField{MakeUsage(PAGE_POWER_DEVICE, POWER_INTERNAL_FAILURE), 0}
+	powerFields = []powerField{pdRemaining, pdFull, pdMode, pdRunTime, pdLoad, pdInVolts, pdOutVolts, pdBatVolts,
+		pdACPresent, pdCharging, pdDischarge, pdLow, pdReplace, pdImminent, pdOverload, pdFailure}
+	changedStatus = MakeUsage(PAGE_POWER_DEVICE, POWER_CHANGED_STATUS)
+)
+
+// PowerMonitor reads the status of a HID power device, as NUT's usbhid-ups
+// driver does: from feature reports on request, and from the input
+// reports the device sends when something changes.
+type PowerMonitor struct {
+	dev    *Device
+	rd     *ReportDescriptor
+	fields map[powerField][]value // by kind: input, then feature
+	last   map[powerField]float64 // the latest value of each
+}
+
+// NewPowerMonitor reads d's report descriptor, giving ENODEV if it has
+// none of the power device usages.
+func NewPowerMonitor(d *Device) (*PowerMonitor, error) {
+	rd, e := d.ReportDescriptor()
+	if e != nil {
+		return nil, e
+	}
+	m := &PowerMonitor{dev: d, rd: rd, fields: make(map[powerField][]value), last: make(map[powerField]float64)}
+	for _, pf := range powerFields {
+		for _, kind := range []uint8{REPORT_INPUT, REPORT_FEATURE} {
+			if v := m.lookup(kind, pf); v.f != nil {
+				m.fields[pf] = append(m.fields[pf], v)
+			}
+		}
+	}
+	if len(m.fields) == 0 {
+		return nil, syscall.ENODEV
+	}
+	return m, nil
+}
+
+// lookup finds a usage in the present status rather than the changed
+// status collection, within the given collection if any
+func (m *PowerMonitor) lookup(kind uint8, pf powerField) value {
+	for _, f := range m.rd.Fields {
+		if f.Kind != kind || !f.Variable() || f.In(changedStatus) || (pf.within != 0 && !f.In(pf.within)) {
+			continue
+		}
+		for i := 0; i < f.Count; i++ {
+			if f.Usage(i) == pf.usage {
+				return value{f, i}
+			}
+		}
+	}
+	return value{}
+}
+
+// Read fetches every feature report holding status and decodes them.
+// Reports the device refuses are skipped, unless it refuses them all.
+func (m *PowerMonitor) Read() (*PowerStatus, error) {
+	tried := make(map[uint8]bool)
+	var err error
+	got := false
+	for _, list := range m.fields {
+		for _, v := range list {
+			if v.f.Kind != REPORT_FEATURE || tried[v.f.ReportID] {
+				continue
+			}
+			tried[v.f.ReportID] = true
+			b, e := m.dev.GetReport(REPORT_FEATURE, v.f.ReportID, m.rd.Size(REPORT_FEATURE, v.f.ReportID))
+			if e != nil {
+				// many UPSes stall on some of the reports they describe
+				err = e
+				continue
+			}
+			got = true
+			m.decode(REPORT_FEATURE, b)
+		}
+	}
+	if !got && err != nil {
+		return nil, err
+	}
+	return m.status(), nil
+}
+
+// Update applies an input report from the device, returning the status
+// with it.
+func (m *PowerMonitor) Update(report []byte) *PowerStatus {
+	m.decode(REPORT_INPUT, report)
+	return m.status()
+}
+
+// decode stores the values a report carries; the rest are left as the
+// last report holding them had them
+func (m *PowerMonitor) decode(kind uint8, report []byte) {
+	id := uint8(0)
+	if m.rd.Numbered && len(report) > 0 {
+		id = report[0]
+	}
+	for pf, list := range m.fields {
+		for _, v := range list {
+			if v.f.Kind != kind || v.f.ReportID != id {
+				continue
+			}
+			x, ok := v.f.Value(report, v.i)
+			if !ok {
+				continue
+			}
+			if v.f.Unit == unitVolt {
+				for e := 0; e < 7; e++ {
+					x /= 10
+				}
+			}
+			m.last[pf] = x
+		}
+	}
+}
+
+func (m *PowerMonitor) status() *PowerStatus {
+	st := &PowerStatus{Charge: -1, RunTime: -1, Load: -1, InputVoltage: -1, OutputVoltage: -1, BatteryVoltage: -1}
+	num := func(pf powerField, p *float64) {
+		if x, ok := m.last[pf]; ok {
+			*p = x
+		}
+	}
+	flag := func(pf powerField, p *bool) {
+		*p = m.last[pf] != 0
+	}
+	num(pdRemaining, &st.Charge)
+	// capacity mode 2 is percent; otherwise scale by full charge
+	if full := m.last[pdFull]; st.Charge >= 0 && m.last[pdMode] != 2 && full > 0 {
+		st.Charge = st.Charge * 100 / full
+	}
+	if x, ok := m.last[pdRunTime]; ok {
+		st.RunTime = time.Duration(x * float64(time.Second))
+	}
+	num(pdLoad, &st.Load)
+	num(pdInVolts, &st.InputVoltage)
+	num(pdOutVolts, &st.OutputVoltage)
+	num(pdBatVolts, &st.BatteryVoltage)
+	flag(pdACPresent, &st.ACPresent)
+	flag(pdCharging, &st.Charging)
+	flag(pdDischarge, &st.Discharging)
+	flag(pdLow, &st.BelowCapacityLimit)
+	flag(pdReplace, &st.NeedReplacement)
+	flag(pdImminent, &st.ShutdownImminent)
+	flag(pdOverload, &st.Overload)
+	flag(pdFailure, &st.InternalFailure)
+	return st
+}
+
+// Poll calls fn with the device's status every interval until ctx is
+// done, which is the error it returns.  Input reports the device sends
+// in between are applied as they arrive, so changes such as losing AC
+// power are seen straight away rather than at the next poll.
+func (m *PowerMonitor) Poll(ctx context.Context, interval time.Duration, fn func(*PowerStatus, error)) error {
+	fn(m.Read())
+	next := time.Now().Add(interval)
+	buf := make([]byte, max(m.dev.InSize, 64))
+	for ctx.Err() == nil {
+		wait := time.Until(next)
+		if wait <= 0 {
+			fn(m.Read())
+			next = time.Now().Add(interval)
+			continue
+		}
+		// wake at least every 100ms to notice ctx
+		n, e := m.dev.Read(buf, uint32(min(wait, 100*time.Millisecond)/time.Millisecond)+1)
+		if errors.Is(e, syscall.ETIMEDOUT) {
+			continue
+		}
+		if e != nil {
+			fn(nil, e)
+			select {
+			case <-ctx.Done():
+			case <-time.After(min(wait, time.Second)):
+			}
+			continue
+		}
+		fn(m.Update(buf[:n]), nil)
+	}
+	return ctx.Err()
+}
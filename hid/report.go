@@ -0,0 +1,359 @@
+package hid
+
+import (
+	"errors"
+	"syscall"
+
+	"github.com/richardnwinder/usb"
+)
+
+var ErrBadDescriptor = errors.New("hid: malformed report descriptor")
+
+// main item data bits
+const (
+	FLAG_CONSTANT      = 0x001
+	FLAG_VARIABLE      = 0x002
+	FLAG_RELATIVE      = 0x004
+	FLAG_WRAP          = 0x008
+	FLAG_NONLINEAR     = 0x010
+	FLAG_NO_PREFERRED  = 0x020
+	FLAG_NULL_STATE    = 0x040
+	FLAG_VOLATILE      = 0x080
+	FLAG_BUFFERED_BYTE = 0x100
+)
+
+// Field is one main item of a report descriptor: Count values of Size
+// bits each, starting Offset bits into the report after its ID byte.
+// A variable field has one usage per value.  An array field's values
+// are indices into Usages, offset by LogicalMin, naming what is active.
+type Field struct {
+	Kind        uint8 // REPORT_INPUT, REPORT_OUTPUT or REPORT_FEATURE
+	ReportID    uint8
+	Offset      int
+	Size        int
+	Count       int
+	Flags       uint32
+	Usages      []Usage
+	LogicalMin  int32
+	LogicalMax  int32
+	PhysicalMin int32
+	PhysicalMax int32
+	Unit        uint32
+	Exponent    int
+	// Collections are the usages of the collections the field is in,
+	// outermost (the application collection) first.
+	Collections []Usage
+}
+
+func (f *Field) Variable() bool { return f.Flags&FLAG_VARIABLE != 0 }
+func (f *Field) Constant() bool { return f.Flags&FLAG_CONSTANT != 0 }
+
+// Usage returns the usage of value i of a variable field.
+func (f *Field) Usage(i int) Usage {
+	if len(f.Usages) == 0 {
+		return 0
+	}
+	return f.Usages[min(i, len(f.Usages)-1)]
+}
+
+// In says whether the field is inside a collection with usage u.
+func (f *Field) In(u Usage) bool {
+	for _, c := range f.Collections {
+		if c == u {
+			return true
+		}
+	}
+	return false
+}
+
+// Raw extracts value i from a report, starting with its ID byte if the
+// report is numbered.  It is sign-extended when LogicalMin is negative.
+func (f *Field) Raw(report []byte, i int) (int32, bool) {
+	bit := f.Offset + i*f.Size
+	if f.ReportID != 0 {
+		bit += 8
+	}
+	if i < 0 || i >= f.Count || f.Size == 0 || f.Size > 32 || bit+f.Size > 8*len(report) {
+		return 0, false
+	}
+	var v uint32
+	for n := 0; n < f.Size; n++ {
+		if report[(bit+n)/8]&(1<<((bit+n)%8)) != 0 {
+			v |= 1 << n
+		}
+	}
+	if f.LogicalMin < 0 && f.Size < 32 && v&(1<<(f.Size-1)) != 0 {
+		v |= ^uint32(0) << f.Size
+	}
+	return int32(v), true
+}
+
+// Value is Raw scaled into the physical range and unit exponent, as the
+// descriptor says to.  Out of range values, which devices send for "no
+// reading", report false.
+func (f *Field) Value(report []byte, i int) (float64, bool) {
+	v, ok := f.Raw(report, i)
+	if !ok || (f.LogicalMin < f.LogicalMax && (v < f.LogicalMin || v > f.LogicalMax)) {
+		return 0, false
+	}
+	x := float64(v)
+	if f.PhysicalMin != f.PhysicalMax && f.LogicalMin != f.LogicalMax {
+		x = float64(f.PhysicalMin) + (x-float64(f.LogicalMin))*
+			float64(f.PhysicalMax-f.PhysicalMin)/float64(f.LogicalMax-f.LogicalMin)
+	}
+	for e := f.Exponent; e > 0; e-- {
+		x *= 10
+	}
+	for e := f.Exponent; e < 0; e++ {
+		x /= 10
+	}
+	return x, true
+}
+
+// Put stores value i into a report laid out as Raw reads it.
+func (f *Field) Put(report []byte, i int, v int32) bool {
+	bit := f.Offset + i*f.Size
+	if f.ReportID != 0 {
+		bit += 8
+	}
+	if i < 0 || i >= f.Count || f.Size == 0 || f.Size > 32 || bit+f.Size > 8*len(report) {
+		return false
+	}
+	for n := 0; n < f.Size; n++ {
+		m := byte(1 << ((bit + n) % 8))
+		if uint32(v)&(1<<n) != 0 {
+			report[(bit+n)/8] |= m
+		} else {
+			report[(bit+n)/8] &^= m
+		}
+	}
+	return true
+}
+
+// Active lists the usages an array field reports as active.  Usage 0,
+// which the pages keep for "no event", is left out.
+func (f *Field) Active(report []byte) []Usage {
+	var list []Usage
+	for i := 0; i < f.Count; i++ {
+		v, ok := f.Raw(report, i)
+		if !ok {
+			break
+		}
+		j := int(v - f.LogicalMin)
+		if v < f.LogicalMin || v > f.LogicalMax || j >= len(f.Usages) || f.Usages[j].ID() == 0 {
+			continue
+		}
+		list = append(list, f.Usages[j])
+	}
+	return list
+}
+
+// ReportDescriptor is a parsed report descriptor.
+type ReportDescriptor struct {
+	Fields   []*Field
+	Numbered bool // reports start with an ID byte
+	sizes    map[[2]uint8]int
+}
+
+// Find returns the first field of the given kind carrying usage u, and
+// the index of the value with it.
+func (rd *ReportDescriptor) Find(kind uint8, u Usage) (*Field, int) {
+	for _, f := range rd.Fields {
+		if f.Kind != kind || !f.Variable() {
+			continue
+		}
+		for i := 0; i < f.Count; i++ {
+			if f.Usage(i) == u {
+				return f, i
+			}
+		}
+	}
+	return nil, 0
+}
+
+// Size is the length in bytes of a report, with its ID byte.
+func (rd *ReportDescriptor) Size(kind uint8, id uint8) int {
+	n := (rd.sizes[[2]uint8{kind, id}] + 7) / 8
+	if id != 0 {
+		n++
+	}
+	return n
+}
+
+// IDs lists the report IDs of the given kind, in descriptor order.
+func (rd *ReportDescriptor) IDs(kind uint8) []uint8 {
+	var list []uint8
+	seen := make(map[uint8]bool)
+	for _, f := range rd.Fields {
+		if f.Kind == kind && !seen[f.ReportID] {
+			seen[f.ReportID] = true
+			list = append(list, f.ReportID)
+		}
+	}
+	return list
+}
+
+type globals struct {
+	page                   uint16
+	logMin, logMax         int32
+	physMin, physMax       int32
+	unit                   uint32
+	exponent               int
+	size, count            int
+	id                     uint8
+	logMinSize, logMaxSize int
+}
+
+// ParseReportDescriptor decodes a report descriptor into its fields.
+func ParseReportDescriptor(b []byte) (*ReportDescriptor, error) {
+	rd := &ReportDescriptor{sizes: make(map[[2]uint8]int)}
+	var g globals
+	var stack []globals
+	var usages []Usage
+	var umin, umax Usage
+	var haveMin bool
+	var collections []Usage
+	for len(b) > 0 {
+		prefix := b[0]
+		if prefix == 0xfe {
+			// long item: size, tag, data; none are defined
+			if len(b) < 3 || len(b) < 3+int(b[1]) {
+				return nil, ErrBadDescriptor
+			}
+			b = b[3+int(b[1]):]
+			continue
+		}
+		size := int(prefix & 3)
+		if size == 3 {
+			size = 4
+		}
+		if len(b) < 1+size {
+			return nil, ErrBadDescriptor
+		}
+		var u uint32
+		for i := 0; i < size; i++ {
+			u |= uint32(b[1+i]) << (8 * i)
+		}
+		s := int32(u)
+		if size > 0 && size < 4 && u&(1<<(8*size-1)) != 0 {
+			s = int32(u | ^uint32(0)<<(8*size))
+		}
+		b = b[1+size:]
+		tag := prefix >> 4
+		switch prefix >> 2 & 3 {
+		case 0: // main
+			switch tag {
+			case 0x8, 0x9, 0xb:
+				kind := map[uint8]uint8{0x8: REPORT_INPUT, 0x9: REPORT_OUTPUT, 0xb: REPORT_FEATURE}[tag]
+				if haveMin {
+					for v := umin; v <= umax && len(usages) < 1<<16; v++ {
+						usages = append(usages, v)
+					}
+				}
+				f := &Field{
+					Kind: kind, ReportID: g.id, Size: g.size, Count: g.count, Flags: u,
+					Usages: usages, LogicalMin: g.logMin, LogicalMax: g.logMax,
+					PhysicalMin: g.physMin, PhysicalMax: g.physMax,
+					Unit: g.unit, Exponent: g.exponent,
+					Collections: append([]Usage(nil), collections...),
+				}
+				// an unsigned maximum that looks negative, a common mistake
+				if f.LogicalMin >= 0 && f.LogicalMax < 0 && g.logMaxSize < 4 {
+					f.LogicalMax = int32(uint32(f.LogicalMax) & (1<<(8*g.logMaxSize) - 1))
+				}
+				key := [2]uint8{kind, g.id}
+				f.Offset = rd.sizes[key]
+				rd.sizes[key] += f.Size * f.Count
+				rd.Fields = append(rd.Fields, f)
+			case 0xa:
+				var c Usage
+				if len(usages) > 0 {
+					c = usages[0]
+				} else if haveMin {
+					c = umin
+				}
+				collections = append(collections, c)
+			case 0xc:
+				if len(collections) == 0 {
+					return nil, ErrBadDescriptor
+				}
+				collections = collections[:len(collections)-1]
+			}
+			usages, haveMin = nil, false
+		case 1: // global
+			switch tag {
+			case 0x0:
+				g.page = uint16(u)
+			case 0x1:
+				g.logMin, g.logMinSize = s, size
+			case 0x2:
+				g.logMax, g.logMaxSize = s, size
+			case 0x3:
+				g.physMin = s
+			case 0x4:
+				g.physMax = s
+			case 0x5:
+				// a 4-bit two's complement nibble
+				g.exponent = int(u & 0xf)
+				if g.exponent >= 8 {
+					g.exponent -= 16
+				}
+			case 0x6:
+				g.unit = u
+			case 0x7:
+				g.size = int(u)
+			case 0x8:
+				if u == 0 || u > 255 {
+					return nil, ErrBadDescriptor
+				}
+				g.id = uint8(u)
+				rd.Numbered = true
+			case 0x9:
+				g.count = int(u)
+			case 0xa:
+				stack = append(stack, g)
+			case 0xb:
+				if len(stack) == 0 {
+					return nil, ErrBadDescriptor
+				}
+				g = stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+			}
+			if g.size > 32 || g.count > 1<<16 {
+				return nil, ErrBadDescriptor
+			}
+		case 2: // local
+			full := Usage(u)
+			if size < 4 {
+				full = MakeUsage(g.page, uint16(u))
+			}
+			switch tag {
+			case 0x0:
+				usages = append(usages, full)
+			case 0x1:
+				umin, haveMin = full, true
+			case 0x2:
+				umax = full
+			}
+		}
+	}
+	return rd, nil
+}
+
+// ReportDescriptor reads the interface's report descriptor from the
+// device.
+func (d *Device) ReportDescriptor() (*ReportDescriptor, error) {
+	n := d.rdlen
+	if n == 0 {
+		n = 4096
+	}
+	b := make([]byte, n)
+	got, e := d.dev.ControlTransfer(usb.REQTYPE_IN|usb.REQTYPE_STANDARD|usb.REQTYPE_INTERFACE, usb.REQ_GET_DESCRIPTOR, DT_REPORT<<8, uint16(d.ifc), uint16(n), 1000, b)
+	if e != nil {
+		return nil, e
+	}
+	if got == 0 {
+		return nil, syscall.EPROTO
+	}
+	return ParseReportDescriptor(b[:got])
+}
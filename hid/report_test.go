@@ -0,0 +1,253 @@
+package hid_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/richardnwinder/usb/hid"
+)
+
+// the boot keyboard descriptor of the HID specification, appendix B.1
+var keyboardDesc = []byte{
+	0x05, 0x01, 0x09, 0x06, 0xa1, 0x01, // Generic Desktop, Keyboard, Application
+	0x05, 0x07, 0x19, 0xe0, 0x29, 0xe7, 0x15, 0x00, 0x25, 0x01, // modifiers
+	0x75, 0x01, 0x95, 0x08, 0x81, 0x02,
+	0x95, 0x01, 0x75, 0x08, 0x81, 0x01, // reserved byte
+	0x95, 0x05, 0x75, 0x01, 0x05, 0x08, 0x19, 0x01, 0x29, 0x05, 0x91, 0x02, // LEDs
+	0x95, 0x01, 0x75, 0x03, 0x91, 0x01, // LED padding
+	0x95, 0x06, 0x75, 0x08, 0x15, 0x00, 0x25, 0x65, // key array
+	0x05, 0x07, 0x19, 0x00, 0x29, 0x65, 0x81, 0x00,
+	0xc0,
+}
+
+// a wheel mouse in report 1 and consumer controls in report 2, the way
+// wireless receivers describe them, with the consumer array's globals
+// pushed and popped around it
+var mouseDesc = []byte{
+	0x05, 0x01, 0x09, 0x02, 0xa1, 0x01, 0x85, 0x01, // Mouse, report 1
+	0x09, 0x01, 0xa1, 0x00, // Pointer, Physical
+	0x05, 0x09, 0x19, 0x01, 0x29, 0x03, 0x15, 0x00, 0x25, 0x01, // 3 buttons
+	0x95, 0x03, 0x75, 0x01, 0x81, 0x02,
+	0x95, 0x01, 0x75, 0x05, 0x81, 0x03, // padding
+	0x05, 0x01, 0x09, 0x30, 0x09, 0x31, 0x09, 0x38, // X, Y, wheel
+	0x15, 0x81, 0x25, 0x7f, 0x75, 0x08, 0x95, 0x03, 0x81, 0x06,
+	0xc0, 0xc0,
+	0x05, 0x0c, 0x09, 0x01, 0xa1, 0x01, 0x85, 0x02, // Consumer Control, report 2
+	0xa4,                                     // push
+	0x15, 0x00, 0x26, 0xff, 0x03, 0x75, 0x10, // 0..1023, 16 bits
+	0x95, 0x01, 0x19, 0x00, 0x2a, 0xff, 0x03, 0x81, 0x00,
+	0xb4,                   // pop, back to 3 signed bytes
+	0x95, 0x01, 0x81, 0x03, // one of them, constant
+	0xc0,
+}
+
+// a gamepad: two axes with an unsigned maximum written as a negative
+// byte, twelve buttons and a hat switch with a null state
+var gamepadDesc = []byte{
+	0x05, 0x01, 0x09, 0x05, 0xa1, 0x01, // Gamepad, Application
+	0x15, 0x00, 0x25, 0xff, 0x35, 0x00, 0x46, 0xff, 0x00, // 0..255
+	0x09, 0x30, 0x09, 0x31, 0x75, 0x08, 0x95, 0x02, 0x81, 0x02,
+	0x05, 0x09, 0x19, 0x01, 0x29, 0x0c, 0x25, 0x01, 0x75, 0x01, 0x95, 0x0c, 0x81, 0x02,
+	0x75, 0x04, 0x95, 0x01, 0x81, 0x03, // padding
+	0x05, 0x01, 0x09, 0x39, 0x15, 0x00, 0x25, 0x07, // hat, 8 positions
+	0x35, 0x00, 0x46, 0x3b, 0x01, 0x65, 0x14, // 0..315 degrees
+	0x75, 0x04, 0x95, 0x01, 0x81, 0x42,
+	0xc0,
+}
+
+func parse(t *testing.T, b []byte) *hid.ReportDescriptor {
+	t.Helper()
+	rd, e := hid.ParseReportDescriptor(b)
+	if e != nil {
+		t.Fatal(e)
+	}
+	return rd
+}
+
+type field struct {
+	kind                   uint8
+	id                     uint8
+	offset, size, count    int
+	flags                  uint32
+	usages                 int
+	first                  hid.Usage
+	logicalMin, logicalMax int32
+}
+
+func checkFields(t *testing.T, rd *hid.ReportDescriptor, want []field) {
+	t.Helper()
+	if len(rd.Fields) != len(want) {
+		t.Fatalf("%d fields, want %d", len(rd.Fields), len(want))
+	}
+	for i, w := range want {
+		f := rd.Fields[i]
+		got := field{f.Kind, f.ReportID, f.Offset, f.Size, f.Count, f.Flags, len(f.Usages), 0, f.LogicalMin, f.LogicalMax}
+		if len(f.Usages) > 0 {
+			got.first = f.Usages[0]
+		}
+		if got != w {
+			t.Errorf("field %d: %+v, want %+v", i, got, w)
+		}
+	}
+}
+
+func TestParseKeyboard(t *testing.T) {
+	rd := parse(t, keyboardDesc)
+	const in, out = hid.REPORT_INPUT, hid.REPORT_OUTPUT
+	checkFields(t, rd, []field{
+		{in, 0, 0, 1, 8, hid.FLAG_VARIABLE, 8, hid.MakeUsage(hid.PAGE_KEYBOARD, hid.KEY_LEFT_CTRL), 0, 1},
+		{in, 0, 8, 8, 1, hid.FLAG_CONSTANT, 0, 0, 0, 1},
+		{out, 0, 0, 1, 5, hid.FLAG_VARIABLE, 5, hid.MakeUsage(hid.PAGE_LED, 1), 0, 1},
+		{out, 0, 5, 3, 1, hid.FLAG_CONSTANT, 0, 0, 0, 1},
+		{in, 0, 16, 8, 6, 0, 0x66, hid.MakeUsage(hid.PAGE_KEYBOARD, 0), 0, 0x65},
+	})
+	if rd.Numbered || rd.Size(in, 0) != 8 || rd.Size(out, 0) != 1 {
+		t.Errorf("numbered %v, input %d bytes, output %d, want 8 and 1", rd.Numbered, rd.Size(in, 0), rd.Size(out, 0))
+	}
+	keys := rd.Fields[4]
+	if !keys.In(hid.MakeUsage(hid.PAGE_GENERIC_DESKTOP, hid.GD_KEYBOARD)) {
+		t.Errorf("key array in collections %v", keys.Collections)
+	}
+	// shift held, then A and B, and no event in the other slots
+	report := []byte{0x02, 0, hid.KEY_A, hid.KEY_B, 0, 0, 0, 0}
+	active := keys.Active(report)
+	if len(active) != 2 || active[0] != hid.MakeUsage(hid.PAGE_KEYBOARD, hid.KEY_A) || active[1] != hid.MakeUsage(hid.PAGE_KEYBOARD, hid.KEY_B) {
+		t.Errorf("keys down %v, want A and B", active)
+	}
+	f, i := rd.Find(in, hid.MakeUsage(hid.PAGE_KEYBOARD, hid.KEY_LEFT_CTRL+1))
+	if v, ok := f.Raw(report, i); f != rd.Fields[0] || !ok || v != 1 {
+		t.Errorf("left shift %d (%v), want 1", v, ok)
+	}
+}
+
+func TestParseMouse(t *testing.T) {
+	rd := parse(t, mouseDesc)
+	const in = hid.REPORT_INPUT
+	gd := func(id uint16) hid.Usage { return hid.MakeUsage(hid.PAGE_GENERIC_DESKTOP, id) }
+	checkFields(t, rd, []field{
+		{in, 1, 0, 1, 3, hid.FLAG_VARIABLE, 3, hid.MakeUsage(hid.PAGE_BUTTON, 1), 0, 1},
+		{in, 1, 3, 5, 1, hid.FLAG_CONSTANT | hid.FLAG_VARIABLE, 0, 0, 0, 1},
+		{in, 1, 8, 8, 3, hid.FLAG_VARIABLE | hid.FLAG_RELATIVE, 3, gd(hid.GD_X), -127, 127},
+		{in, 2, 0, 16, 1, 0, 0x400, hid.MakeUsage(hid.PAGE_CONSUMER, 0), 0, 0x3ff},
+		// the globals as they were pushed, report ID included
+		{in, 2, 16, 8, 1, hid.FLAG_CONSTANT | hid.FLAG_VARIABLE, 0, 0, -127, 127},
+	})
+	if !rd.Numbered || rd.Size(in, 1) != 5 || rd.Size(in, 2) != 4 {
+		t.Errorf("numbered %v, reports of %d and %d bytes, want 5 and 4", rd.Numbered, rd.Size(in, 1), rd.Size(in, 2))
+	}
+	if ids := rd.IDs(in); len(ids) != 2 || ids[0] != 1 || ids[1] != 2 {
+		t.Errorf("input report IDs %v, want [1 2]", ids)
+	}
+	if c := rd.Fields[2].Collections; len(c) != 2 || c[0] != gd(hid.GD_MOUSE) || c[1] != gd(hid.GD_POINTER) {
+		t.Errorf("axes in collections %v, want Mouse, Pointer", c)
+	}
+	// left and middle buttons, X -2, Y 3, wheel 1
+	report := []byte{1, 0x05, 0xfe, 0x03, 0x01}
+	for _, c := range []struct {
+		usage hid.Usage
+		want  int32
+	}{
+		{hid.MakeUsage(hid.PAGE_BUTTON, 1), 1},
+		{hid.MakeUsage(hid.PAGE_BUTTON, 2), 0},
+		{hid.MakeUsage(hid.PAGE_BUTTON, 3), 1},
+		{gd(hid.GD_X), -2},
+		{gd(hid.GD_Y), 3},
+		{gd(hid.GD_WHEEL), 1},
+	} {
+		f, i := rd.Find(in, c.usage)
+		if f == nil {
+			t.Errorf("no field for %v", c.usage)
+			continue
+		}
+		if v, ok := f.Raw(report, i); !ok || v != c.want {
+			t.Errorf("%v: %d (%v), want %d", c.usage, v, ok, c.want)
+		}
+	}
+	// volume up in report 2
+	consumer := rd.Fields[3]
+	if a := consumer.Active([]byte{2, 0xe9, 0x00, 0}); len(a) != 1 || a[0] != hid.MakeUsage(hid.PAGE_CONSUMER, 0xe9) {
+		t.Errorf("consumer controls active %v, want volume up", a)
+	}
+}
+
+func TestParseGamepad(t *testing.T) {
+	rd := parse(t, gamepadDesc)
+	const in = hid.REPORT_INPUT
+	checkFields(t, rd, []field{
+		// 25 ff read as 255, not -1
+		{in, 0, 0, 8, 2, hid.FLAG_VARIABLE, 2, hid.MakeUsage(hid.PAGE_GENERIC_DESKTOP, hid.GD_X), 0, 255},
+		{in, 0, 16, 1, 12, hid.FLAG_VARIABLE, 12, hid.MakeUsage(hid.PAGE_BUTTON, 1), 0, 1},
+		{in, 0, 28, 4, 1, hid.FLAG_CONSTANT | hid.FLAG_VARIABLE, 0, 0, 0, 1},
+		{in, 0, 32, 4, 1, hid.FLAG_VARIABLE | hid.FLAG_NULL_STATE, 1, hid.MakeUsage(hid.PAGE_GENERIC_DESKTOP, hid.GD_HAT_SWITCH), 0, 7},
+	})
+	if rd.Size(in, 0) != 5 {
+		t.Errorf("input report of %d bytes, want 5", rd.Size(in, 0))
+	}
+	hat := rd.Fields[3]
+	if hat.PhysicalMax != 315 || hat.Unit != 0x14 {
+		t.Errorf("hat physical max %d, unit %#x; want 315 degrees", hat.PhysicalMax, hat.Unit)
+	}
+	for _, c := range []struct {
+		raw  byte
+		want float64
+		ok   bool
+	}{{0, 0, true}, {2, 90, true}, {7, 315, true}, {8, 0, false}} {
+		v, ok := hat.Value([]byte{0x80, 0x80, 0, 0, c.raw}, 0)
+		if v != c.want || ok != c.ok {
+			t.Errorf("hat at %d: %v (%v), want %v (%v)", c.raw, v, ok, c.want, c.ok)
+		}
+	}
+	x := rd.Fields[0]
+	if v, ok := x.Value([]byte{0xc0, 0, 0, 0, 0}, 0); !ok || v != 0xc0 {
+		t.Errorf("X %v (%v), want 192", v, ok)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		desc []byte
+	}{
+		{"short item", []byte{0x05}},
+		{"short 4 byte item", []byte{0x27, 0xff, 0xff}},
+		{"short long item", []byte{0xfe, 0x05, 0x00, 0x01}},
+		{"end without collection", []byte{0xc0}},
+		{"pop without push", []byte{0xb4}},
+		{"report ID 0", []byte{0x85, 0x00}},
+		{"report size over 32", []byte{0x75, 0x21}},
+		{"report count over 65536", []byte{0x97, 0x01, 0x00, 0x01, 0x00}},
+	} {
+		if _, e := hid.ParseReportDescriptor(c.desc); !errors.Is(e, hid.ErrBadDescriptor) {
+			t.Errorf("%s: %v, want %v", c.name, e, hid.ErrBadDescriptor)
+		}
+	}
+}
+
+// TestParseTruncated cuts each descriptor at every length: a cut inside
+// an item is an error, and one between items parses as far as it goes
+func TestParseTruncated(t *testing.T) {
+	for _, desc := range [][]byte{keyboardDesc, mouseDesc, gamepadDesc} {
+		// where each item starts
+		starts := map[int]bool{}
+		for i := 0; i < len(desc); {
+			starts[i] = true
+			size := int(desc[i] & 3)
+			if size == 3 {
+				size = 4
+			}
+			i += 1 + size
+		}
+		for n := 0; n < len(desc); n++ {
+			rd, e := hid.ParseReportDescriptor(desc[:n])
+			if starts[n] {
+				if e != nil {
+					t.Errorf("cut at item boundary %d: %v", n, e)
+				}
+				continue
+			}
+			if !errors.Is(e, hid.ErrBadDescriptor) || rd != nil {
+				t.Errorf("cut inside an item at %d: %v", n, e)
+			}
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package hid
+
+import (
+	"syscall"
+	"time"
+)
+
+// class requests
+const (
+	GET_REPORT   = 0x01
+	GET_IDLE     = 0x02
+	GET_PROTOCOL = 0x03
+	SET_REPORT   = 0x09
+	SET_IDLE     = 0x0a
+	SET_PROTOCOL = 0x0b
+
+	// report types, in the high byte of wValue for GET_REPORT and SET_REPORT
+	REPORT_INPUT   = 0x01
+	REPORT_OUTPUT  = 0x02
+	REPORT_FEATURE = 0x03
+
+	PROTOCOL_BOOT   = 0
+	PROTOCOL_REPORT = 1
+
+	reqOut = 0x21 // class, interface, host to device
+	reqIn  = 0xa1
+)
+
+// SetIdle sets how often the device repeats an unchanged input report:
+// 0 means only when something changes, otherwise it is rounded down to
+// 4ms units, up to 1.02s.  Report ID 0 applies to all reports.  Boot
+// keyboards default to 500ms, and some only start reporting once the
+// host has sent this, as BIOSes and operating systems do.
+func (d *Device) SetIdle(reportID uint8, rate time.Duration) error {
+	n := rate / (4 * time.Millisecond)
+	if rate < 0 || n > 255 {
+		return syscall.EINVAL
+	}
+	_, e := d.dev.ControlTransfer(reqOut, SET_IDLE, uint16(n)<<8|uint16(reportID), uint16(d.ifc), 0, 1000, nil)
+	return e
+}
+
+// Idle reads the idle rate of a report.
+func (d *Device) Idle(reportID uint8) (time.Duration, error) {
+	b := make([]byte, 1)
+	n, e := d.dev.ControlTransfer(reqIn, GET_IDLE, uint16(reportID), uint16(d.ifc), 1, 1000, b)
+	if e != nil {
+		return 0, e
+	}
+	if n < 1 {
+		return 0, ErrShortReport
+	}
+	return time.Duration(b[0]) * 4 * time.Millisecond, nil
+}
+
+// SetProtocol switches a boot device between the boot and report
+// protocols.  Devices come up in the report protocol; the boot reports
+// ParseKeyboardReport and ParseMouseReport decode need PROTOCOL_BOOT.
+// Only interfaces of the boot subclass support it.
+func (d *Device) SetProtocol(protocol uint8) error {
+	if protocol > PROTOCOL_REPORT {
+		return syscall.EINVAL
+	}
+	_, e := d.dev.ControlTransfer(reqOut, SET_PROTOCOL, uint16(protocol), uint16(d.ifc), 0, 1000, nil)
+	return e
+}
+
+// Protocol reads which protocol the device is using.
+func (d *Device) Protocol() (uint8, error) {
+	b := make([]byte, 1)
+	n, e := d.dev.ControlTransfer(reqIn, GET_PROTOCOL, 0, uint16(d.ifc), 1, 1000, b)
+	if e != nil {
+		return 0, e
+	}
+	if n < 1 {
+		return 0, ErrShortReport
+	}
+	return b[0], nil
+}
+
+// GetReport reads a report of the given type through the control pipe.
+// For numbered reports the ID comes back as the first byte.
+func (d *Device) GetReport(kind uint8, reportID uint8, length int) ([]byte, error) {
+	if length <= 0 || length > 0xffff {
+		return nil, syscall.EINVAL
+	}
+	b := make([]byte, length)
+	n, e := d.dev.ControlTransfer(reqIn, GET_REPORT, uint16(kind)<<8|uint16(reportID), uint16(d.ifc), uint16(length), 1000, b)
+	if e != nil {
+		return nil, e
+	}
+	return b[:n], nil
+}
+
+// SetReport sends a report of the given type through the control pipe.
+// report is sent as it is, so for numbered reports it starts with the ID.
+func (d *Device) SetReport(kind uint8, reportID uint8, report []byte) error {
+	if len(report) > 0xffff {
+		return syscall.EINVAL
+	}
+	_, e := d.dev.ControlTransfer(reqOut, SET_REPORT, uint16(kind)<<8|uint16(reportID), uint16(d.ifc), uint16(len(report)), 1000, report)
+	return e
+}
@@ -0,0 +1,203 @@
+package hid
+
+import (
+	"errors"
+	"syscall"
+	"time"
+)
+
+// selectors of the reporting and power state properties, which are
+// written as their index among the property's selectors
+const (
+	SENSOR_REPORTING_NO_EVENTS  = 0x840
+	SENSOR_REPORTING_ALL_EVENTS = 0x841
+	SENSOR_POWER_UNDEFINED      = 0x850
+	SENSOR_POWER_D0_FULL        = 0x851
+)
+
+// usage modifiers occupy the top nibble of sensor usages
+const sensorModifierMask = 0xf000
+
+// Sensor is one sensor of a HID sensor hub: an application collection
+// on the Sensors page, such as SENSOR_ACCELEROMETER_3D, and the reports
+// it uses.
+type Sensor struct {
+	Usage    Usage
+	ReportID uint8
+	Data     []*Field // its input fields
+	Features []*Field // its properties
+	rd       *ReportDescriptor
+}
+
+// Sensors lists the sensors in a hub's report descriptor.
+func Sensors(rd *ReportDescriptor) []*Sensor {
+	var list []*Sensor
+	byKey := make(map[[2]uint32]*Sensor)
+	for _, f := range rd.Fields {
+		if len(f.Collections) == 0 || f.Collections[0].Page() != PAGE_SENSOR || f.Constant() {
+			continue
+		}
+		key := [2]uint32{uint32(f.Collections[0]), uint32(f.ReportID)}
+		s := byKey[key]
+		if s == nil {
+			s = &Sensor{Usage: f.Collections[0], ReportID: f.ReportID, rd: rd}
+			byKey[key] = s
+			list = append(list, s)
+		}
+		switch f.Kind {
+		case REPORT_INPUT:
+			s.Data = append(s.Data, f)
+		case REPORT_FEATURE:
+			s.Features = append(s.Features, f)
+		}
+	}
+	return list
+}
+
+// sensorFind looks a usage up among fields, ignoring usage modifiers.
+// Selector properties are array fields in a logical collection named
+// after the property, so a field in such a collection also matches.
+func sensorFind(fields []*Field, id uint16) value {
+	for _, f := range fields {
+		for i := 0; i < f.Count && f.Variable(); i++ {
+			u := f.Usage(i)
+			if u.Page() == PAGE_SENSOR && u.ID()&^sensorModifierMask == id {
+				return value{f, i}
+			}
+		}
+	}
+	for _, f := range fields {
+		if !f.Variable() && f.In(MakeUsage(PAGE_SENSOR, id)) {
+			return value{f, 0}
+		}
+	}
+	return value{}
+}
+
+// Value reads one data field, such as SENSOR_DATA_ACCELERATION_X, from
+// one of the sensor's input reports, scaled by its unit exponent.
+func (s *Sensor) Value(report []byte, id uint16) (float64, bool) {
+	if s.ReportID != 0 && (len(report) == 0 || report[0] != s.ReportID) {
+		return 0, false
+	}
+	v := sensorFind(s.Data, id)
+	if v.f == nil {
+		return 0, false
+	}
+	return v.f.Value(report, v.i)
+}
+
+// Values reads every data field of an input report, by usage ID with
+// modifiers removed.  It returns nil for another sensor's reports.
+func (s *Sensor) Values(report []byte) map[uint16]float64 {
+	if s.ReportID != 0 && (len(report) == 0 || report[0] != s.ReportID) {
+		return nil
+	}
+	m := make(map[uint16]float64)
+	for _, f := range s.Data {
+		for i := 0; i < f.Count && f.Variable(); i++ {
+			u := f.Usage(i)
+			if x, ok := f.Value(report, i); ok && u.Page() == PAGE_SENSOR {
+				m[u.ID()&^sensorModifierMask] = x
+			}
+		}
+	}
+	return m
+}
+
+// Vector reads the X, Y and Z values of a three axis sensor: x is the
+// X usage, such as SENSOR_DATA_ACCELERATION_X, and Y and Z follow it.
+func (s *Sensor) Vector(report []byte, x uint16) ([3]float64, bool) {
+	var v [3]float64
+	for i := range v {
+		f, ok := s.Value(report, x+uint16(i))
+		if !ok {
+			return v, false
+		}
+		v[i] = f
+	}
+	return v, true
+}
+
+// property reads the sensor's feature report and finds a property in it
+func (s *Sensor) property(d *Device, id uint16) ([]byte, value, error) {
+	v := sensorFind(s.Features, id)
+	if v.f == nil {
+		return nil, v, syscall.EOPNOTSUPP
+	}
+	b, e := d.GetReport(REPORT_FEATURE, v.f.ReportID, s.rd.Size(REPORT_FEATURE, v.f.ReportID))
+	if e != nil {
+		return nil, v, e
+	}
+	if len(b) < s.rd.Size(REPORT_FEATURE, v.f.ReportID) {
+		return nil, v, ErrShortReport
+	}
+	return b, v, nil
+}
+
+func (s *Sensor) setProperty(d *Device, id uint16, x int32) error {
+	b, v, e := s.property(d, id)
+	if e != nil {
+		return e
+	}
+	if !v.f.Put(b, v.i, x) {
+		return syscall.EINVAL
+	}
+	return d.SetReport(REPORT_FEATURE, v.f.ReportID, b)
+}
+
+// Interval reads the sensor's report interval.
+func (s *Sensor) Interval(d *Device) (time.Duration, error) {
+	b, v, e := s.property(d, SENSOR_PROP_REPORT_INTERVAL)
+	if e != nil {
+		return 0, e
+	}
+	x, ok := v.f.Value(b, v.i)
+	if !ok {
+		return 0, syscall.ERANGE
+	}
+	// milliseconds, once the exponent is applied
+	return time.Duration(x * float64(time.Millisecond)), nil
+}
+
+// SetInterval sets how often the sensor reports, read-modify-writing its
+// feature report so other properties keep their values.  0 asks for the
+// sensor's own default rate.
+func (s *Sensor) SetInterval(d *Device, interval time.Duration) error {
+	v := sensorFind(s.Features, SENSOR_PROP_REPORT_INTERVAL)
+	if v.f == nil {
+		return syscall.EOPNOTSUPP
+	}
+	x := float64(interval) / float64(time.Millisecond)
+	for e := v.f.Exponent; e > 0; e-- {
+		x /= 10
+	}
+	for e := v.f.Exponent; e < 0; e++ {
+		x *= 10
+	}
+	return s.setProperty(d, SENSOR_PROP_REPORT_INTERVAL, int32(x))
+}
+
+// Start sets the sensor to full power and to report all events, which
+// most hubs wait for before sending anything.
+func (s *Sensor) Start(d *Device) error {
+	if e := s.selector(d, SENSOR_PROP_POWER_STATE, SENSOR_POWER_D0_FULL); e != nil && !errors.Is(e, syscall.EOPNOTSUPP) {
+		return e
+	}
+	return s.selector(d, SENSOR_PROP_REPORTING_STATE, SENSOR_REPORTING_ALL_EVENTS)
+}
+
+// Stop turns the sensor's reporting off.
+func (s *Sensor) Stop(d *Device) error {
+	return s.selector(d, SENSOR_PROP_REPORTING_STATE, SENSOR_REPORTING_NO_EVENTS)
+}
+
+// selector writes a reporting or power state, as the index of choice
+// among the selectors counted from the field's logical minimum
+func (s *Sensor) selector(d *Device, id uint16, choice uint16) error {
+	v := sensorFind(s.Features, id)
+	if v.f == nil {
+		return syscall.EOPNOTSUPP
+	}
+	return s.setProperty(d, id, v.f.LogicalMin+int32(choice&0xf))
+}
@@ -0,0 +1,1083 @@
+package hid
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Usage is a HID usage: the usage page in the high 16 bits and the usage
+// ID in the low 16 bits, as in an extended usage item.
+type Usage uint32
+
+func MakeUsage(page uint16, id uint16) Usage {
+	return Usage(page)<<16 | Usage(id)
+}
+
+func (u Usage) Page() uint16 { return uint16(u >> 16) }
+func (u Usage) ID() uint16   { return uint16(u) }
+
+// usage pages
+const (
+	PAGE_GENERIC_DESKTOP    = 0x01
+	PAGE_SIMULATION         = 0x02
+	PAGE_VR                 = 0x03
+	PAGE_SPORT              = 0x04
+	PAGE_GAME               = 0x05
+	PAGE_GENERIC_DEVICE     = 0x06
+	PAGE_KEYBOARD           = 0x07
+	PAGE_LED                = 0x08
+	PAGE_BUTTON             = 0x09
+	PAGE_ORDINAL            = 0x0a
+	PAGE_TELEPHONY          = 0x0b
+	PAGE_CONSUMER           = 0x0c
+	PAGE_DIGITIZER          = 0x0d
+	PAGE_HAPTICS            = 0x0e
+	PAGE_PID                = 0x0f
+	PAGE_UNICODE            = 0x10
+	PAGE_EYE_HEAD_TRACKER   = 0x12
+	PAGE_AUXILIARY_DISPLAY  = 0x14
+	PAGE_SENSOR             = 0x20
+	PAGE_MEDICAL            = 0x40
+	PAGE_BRAILLE            = 0x41
+	PAGE_LIGHTING           = 0x59
+	PAGE_MONITOR            = 0x80
+	PAGE_MONITOR_ENUMERATED = 0x81
+	PAGE_VESA_VIRTUAL       = 0x82
+	PAGE_POWER_DEVICE       = 0x84
+	PAGE_BATTERY_SYSTEM     = 0x85
+	PAGE_BARCODE_SCANNER    = 0x8c
+	PAGE_SCALE              = 0x8d
+	PAGE_MSR                = 0x8e
+	PAGE_CAMERA             = 0x90
+	PAGE_ARCADE             = 0x91
+	PAGE_FIDO               = 0xf1d0
+	PAGE_VENDOR_MIN         = 0xff00
+)
+
+// keyboard/keypad page (0x07) usages; KEY_ERROR_ROLLOVER and
+// KEY_LEFT_CTRL are declared with the boot protocol decoder
+const (
+	KEY_POST_FAIL        = 0x02
+	KEY_ERROR_UNDEFINED  = 0x03
+	KEY_A                = 0x04
+	KEY_B                = 0x05
+	KEY_C                = 0x06
+	KEY_D                = 0x07
+	KEY_E                = 0x08
+	KEY_F                = 0x09
+	KEY_G                = 0x0a
+	KEY_H                = 0x0b
+	KEY_I                = 0x0c
+	KEY_J                = 0x0d
+	KEY_K                = 0x0e
+	KEY_L                = 0x0f
+	KEY_M                = 0x10
+	KEY_N                = 0x11
+	KEY_O                = 0x12
+	KEY_P                = 0x13
+	KEY_Q                = 0x14
+	KEY_R                = 0x15
+	KEY_S                = 0x16
+	KEY_T                = 0x17
+	KEY_U                = 0x18
+	KEY_V                = 0x19
+	KEY_W                = 0x1a
+	KEY_X                = 0x1b
+	KEY_Y                = 0x1c
+	KEY_Z                = 0x1d
+	KEY_1                = 0x1e
+	KEY_2                = 0x1f
+	KEY_3                = 0x20
+	KEY_4                = 0x21
+	KEY_5                = 0x22
+	KEY_6                = 0x23
+	KEY_7                = 0x24
+	KEY_8                = 0x25
+	KEY_9                = 0x26
+	KEY_0                = 0x27
+	KEY_ENTER            = 0x28
+	KEY_ESCAPE           = 0x29
+	KEY_BACKSPACE        = 0x2a
+	KEY_TAB              = 0x2b
+	KEY_SPACE            = 0x2c
+	KEY_MINUS            = 0x2d
+	KEY_EQUAL            = 0x2e
+	KEY_LEFT_BRACKET     = 0x2f
+	KEY_RIGHT_BRACKET    = 0x30
+	KEY_BACKSLASH        = 0x31
+	KEY_NON_US_HASH      = 0x32
+	KEY_SEMICOLON        = 0x33
+	KEY_APOSTROPHE       = 0x34
+	KEY_GRAVE            = 0x35
+	KEY_COMMA            = 0x36
+	KEY_PERIOD           = 0x37
+	KEY_SLASH            = 0x38
+	KEY_CAPS_LOCK        = 0x39
+	KEY_F1               = 0x3a
+	KEY_F2               = 0x3b
+	KEY_F3               = 0x3c
+	KEY_F4               = 0x3d
+	KEY_F5               = 0x3e
+	KEY_F6               = 0x3f
+	KEY_F7               = 0x40
+	KEY_F8               = 0x41
+	KEY_F9               = 0x42
+	KEY_F10              = 0x43
+	KEY_F11              = 0x44
+	KEY_F12              = 0x45
+	KEY_PRINT_SCREEN     = 0x46
+	KEY_SCROLL_LOCK      = 0x47
+	KEY_PAUSE            = 0x48
+	KEY_INSERT           = 0x49
+	KEY_HOME             = 0x4a
+	KEY_PAGE_UP          = 0x4b
+	KEY_DELETE           = 0x4c
+	KEY_END              = 0x4d
+	KEY_PAGE_DOWN        = 0x4e
+	KEY_RIGHT            = 0x4f
+	KEY_LEFT             = 0x50
+	KEY_DOWN             = 0x51
+	KEY_UP               = 0x52
+	KEY_NUM_LOCK         = 0x53
+	KEY_KP_SLASH         = 0x54
+	KEY_KP_ASTERISK      = 0x55
+	KEY_KP_MINUS         = 0x56
+	KEY_KP_PLUS          = 0x57
+	KEY_KP_ENTER         = 0x58
+	KEY_KP_1             = 0x59
+	KEY_KP_2             = 0x5a
+	KEY_KP_3             = 0x5b
+	KEY_KP_4             = 0x5c
+	KEY_KP_5             = 0x5d
+	KEY_KP_6             = 0x5e
+	KEY_KP_7             = 0x5f
+	KEY_KP_8             = 0x60
+	KEY_KP_9             = 0x61
+	KEY_KP_0             = 0x62
+	KEY_KP_PERIOD        = 0x63
+	KEY_NON_US_BACKSLASH = 0x64
+	KEY_APPLICATION      = 0x65
+	KEY_POWER            = 0x66
+	KEY_KP_EQUAL         = 0x67
+	KEY_F13              = 0x68
+	KEY_F14              = 0x69
+	KEY_F15              = 0x6a
+	KEY_F16              = 0x6b
+	KEY_F17              = 0x6c
+	KEY_F18              = 0x6d
+	KEY_F19              = 0x6e
+	KEY_F20              = 0x6f
+	KEY_F21              = 0x70
+	KEY_F22              = 0x71
+	KEY_F23              = 0x72
+	KEY_F24              = 0x73
+	KEY_EXECUTE          = 0x74
+	KEY_HELP             = 0x75
+	KEY_MENU             = 0x76
+	KEY_SELECT           = 0x77
+	KEY_STOP             = 0x78
+	KEY_AGAIN            = 0x79
+	KEY_UNDO             = 0x7a
+	KEY_CUT              = 0x7b
+	KEY_COPY             = 0x7c
+	KEY_PASTE            = 0x7d
+	KEY_FIND             = 0x7e
+	KEY_MUTE             = 0x7f
+	KEY_VOLUME_UP        = 0x80
+	KEY_VOLUME_DOWN      = 0x81
+	KEY_LEFT_SHIFT       = 0xe1
+	KEY_LEFT_ALT         = 0xe2
+	KEY_LEFT_GUI         = 0xe3
+	KEY_RIGHT_CTRL       = 0xe4
+	KEY_RIGHT_SHIFT      = 0xe5
+	KEY_RIGHT_ALT        = 0xe6
+	KEY_RIGHT_GUI        = 0xe7
+)
+
+// Generic Desktop page (0x01) usages
+const (
+	GD_POINTER               = 0x01
+	GD_MOUSE                 = 0x02
+	GD_JOYSTICK              = 0x04
+	GD_GAMEPAD               = 0x05
+	GD_KEYBOARD              = 0x06
+	GD_KEYPAD                = 0x07
+	GD_MULTI_AXIS            = 0x08
+	GD_TABLET_PC_CONTROLS    = 0x09
+	GD_X                     = 0x30
+	GD_Y                     = 0x31
+	GD_Z                     = 0x32
+	GD_RX                    = 0x33
+	GD_RY                    = 0x34
+	GD_RZ                    = 0x35
+	GD_SLIDER                = 0x36
+	GD_DIAL                  = 0x37
+	GD_WHEEL                 = 0x38
+	GD_HAT_SWITCH            = 0x39
+	GD_COUNTED_BUFFER        = 0x3a
+	GD_BYTE_COUNT            = 0x3b
+	GD_MOTION_WAKEUP         = 0x3c
+	GD_START                 = 0x3d
+	GD_SELECT                = 0x3e
+	GD_VX                    = 0x40
+	GD_VY                    = 0x41
+	GD_VZ                    = 0x42
+	GD_VBRX                  = 0x43
+	GD_VBRY                  = 0x44
+	GD_VBRZ                  = 0x45
+	GD_VNO                   = 0x46
+	GD_FEATURE_NOTIFICATION  = 0x47
+	GD_RESOLUTION_MULTIPLIER = 0x48
+	GD_SYSTEM_CONTROL        = 0x80
+	GD_SYSTEM_POWER_DOWN     = 0x81
+	GD_SYSTEM_SLEEP          = 0x82
+	GD_SYSTEM_WAKE_UP        = 0x83
+	GD_SYSTEM_CONTEXT_MENU   = 0x84
+	GD_SYSTEM_MAIN_MENU      = 0x85
+	GD_SYSTEM_APP_MENU       = 0x86
+	GD_SYSTEM_MENU_HELP      = 0x87
+	GD_SYSTEM_MENU_EXIT      = 0x88
+	GD_SYSTEM_MENU_SELECT    = 0x89
+	GD_SYSTEM_MENU_RIGHT     = 0x8a
+	GD_SYSTEM_MENU_LEFT      = 0x8b
+	GD_SYSTEM_MENU_UP        = 0x8c
+	GD_SYSTEM_MENU_DOWN      = 0x8d
+	GD_DPAD_UP               = 0x90
+	GD_DPAD_DOWN             = 0x91
+	GD_DPAD_RIGHT            = 0x92
+	GD_DPAD_LEFT             = 0x93
+)
+
+// LED page (0x08) usages
+const (
+	LED_NUM_LOCK          = 0x01
+	LED_CAPS_LOCK         = 0x02
+	LED_SCROLL_LOCK       = 0x03
+	LED_COMPOSE           = 0x04
+	LED_KANA              = 0x05
+	LED_POWER             = 0x06
+	LED_SHIFT             = 0x07
+	LED_DO_NOT_DISTURB    = 0x08
+	LED_MUTE              = 0x09
+	LED_MESSAGE_WAITING   = 0x19
+	LED_GENERIC_INDICATOR = 0x4b
+)
+
+// Consumer page (0x0c) usages
+const (
+	CONSUMER_CONTROL              = 0x01
+	CONSUMER_NUMERIC_KEYPAD       = 0x02
+	CONSUMER_PROGRAMMABLE_BUTTONS = 0x03
+	CONSUMER_MICROPHONE           = 0x04
+	CONSUMER_HEADPHONE            = 0x05
+	CONSUMER_GRAPHIC_EQUALIZER    = 0x06
+	CONSUMER_POWER                = 0x30
+	CONSUMER_RESET                = 0x31
+	CONSUMER_SLEEP                = 0x32
+	CONSUMER_MENU                 = 0x40
+	CONSUMER_BRIGHTNESS_UP        = 0x6f
+	CONSUMER_BRIGHTNESS_DOWN      = 0x70
+	CONSUMER_PLAY                 = 0xb0
+	CONSUMER_PAUSE                = 0xb1
+	CONSUMER_RECORD               = 0xb2
+	CONSUMER_FAST_FORWARD         = 0xb3
+	CONSUMER_REWIND               = 0xb4
+	CONSUMER_NEXT_TRACK           = 0xb5
+	CONSUMER_PREVIOUS_TRACK       = 0xb6
+	CONSUMER_STOP                 = 0xb7
+	CONSUMER_EJECT                = 0xb8
+	CONSUMER_PLAY_PAUSE           = 0xcd
+	CONSUMER_VOLUME               = 0xe0
+	CONSUMER_MUTE                 = 0xe2
+	CONSUMER_BASS                 = 0xe3
+	CONSUMER_TREBLE               = 0xe4
+	CONSUMER_VOLUME_UP            = 0xe9
+	CONSUMER_VOLUME_DOWN          = 0xea
+	CONSUMER_AL_CONFIGURATION     = 0x183
+	CONSUMER_AL_EMAIL             = 0x18a
+	CONSUMER_AL_CALCULATOR        = 0x192
+	CONSUMER_AL_FILE_BROWSER      = 0x194
+	CONSUMER_AC_SEARCH            = 0x221
+	CONSUMER_AC_HOME              = 0x223
+	CONSUMER_AC_BACK              = 0x224
+	CONSUMER_AC_FORWARD           = 0x225
+	CONSUMER_AC_STOP              = 0x226
+	CONSUMER_AC_REFRESH           = 0x227
+	CONSUMER_AC_BOOKMARKS         = 0x22a
+	CONSUMER_AC_PAN               = 0x238
+)
+
+// Digitizers page (0x0d) usages
+const (
+	DIGITIZER_DIGITIZER         = 0x01
+	DIGITIZER_PEN               = 0x02
+	DIGITIZER_TOUCH_SCREEN      = 0x04
+	DIGITIZER_TOUCH_PAD         = 0x05
+	DIGITIZER_STYLUS            = 0x20
+	DIGITIZER_FINGER            = 0x22
+	DIGITIZER_TIP_PRESSURE      = 0x30
+	DIGITIZER_IN_RANGE          = 0x32
+	DIGITIZER_TOUCH             = 0x33
+	DIGITIZER_TIP_SWITCH        = 0x42
+	DIGITIZER_BARREL_SWITCH     = 0x44
+	DIGITIZER_CONFIDENCE        = 0x47
+	DIGITIZER_WIDTH             = 0x48
+	DIGITIZER_HEIGHT            = 0x49
+	DIGITIZER_CONTACT_ID        = 0x51
+	DIGITIZER_CONTACT_COUNT     = 0x54
+	DIGITIZER_CONTACT_COUNT_MAX = 0x55
+)
+
+// Sensors page (0x20) usages
+const (
+	SENSOR_SENSOR                    = 0x01
+	SENSOR_BIOMETRIC                 = 0x10
+	SENSOR_HUMAN_PRESENCE            = 0x11
+	SENSOR_HUMAN_PROXIMITY           = 0x12
+	SENSOR_HUMAN_TOUCH               = 0x13
+	SENSOR_ENVIRONMENTAL             = 0x30
+	SENSOR_ATMOSPHERIC_PRESSURE      = 0x31
+	SENSOR_HUMIDITY                  = 0x32
+	SENSOR_TEMPERATURE               = 0x33
+	SENSOR_LIGHT                     = 0x40
+	SENSOR_AMBIENT_LIGHT             = 0x41
+	SENSOR_LOCATION                  = 0x50
+	SENSOR_MOTION                    = 0x70
+	SENSOR_ACCELEROMETER_1D          = 0x71
+	SENSOR_ACCELEROMETER_2D          = 0x72
+	SENSOR_ACCELEROMETER_3D          = 0x73
+	SENSOR_GYROMETER_1D              = 0x74
+	SENSOR_GYROMETER_2D              = 0x75
+	SENSOR_GYROMETER_3D              = 0x76
+	SENSOR_MOTION_DETECTOR           = 0x77
+	SENSOR_ORIENTATION               = 0x80
+	SENSOR_COMPASS_1D                = 0x81
+	SENSOR_COMPASS_2D                = 0x82
+	SENSOR_COMPASS_3D                = 0x83
+	SENSOR_INCLINOMETER_3D           = 0x86
+	SENSOR_DEVICE_ORIENTATION        = 0x8a
+	SENSOR_STATE                     = 0x201
+	SENSOR_EVENT                     = 0x202
+	SENSOR_PROPERTY                  = 0x300
+	SENSOR_PROP_FRIENDLY_NAME        = 0x301
+	SENSOR_PROP_SENSOR_STATUS        = 0x303
+	SENSOR_PROP_MIN_REPORT_INTERVAL  = 0x304
+	SENSOR_PROP_MANUFACTURER         = 0x305
+	SENSOR_PROP_MODEL                = 0x306
+	SENSOR_PROP_SERIAL_NUMBER        = 0x307
+	SENSOR_PROP_REPORT_INTERVAL      = 0x30e
+	SENSOR_PROP_SENSITIVITY_ABS      = 0x30f
+	SENSOR_PROP_ACCURACY             = 0x312
+	SENSOR_PROP_RESOLUTION           = 0x313
+	SENSOR_PROP_MAXIMUM              = 0x314
+	SENSOR_PROP_MINIMUM              = 0x315
+	SENSOR_PROP_REPORTING_STATE      = 0x316
+	SENSOR_PROP_SAMPLING_RATE        = 0x317
+	SENSOR_PROP_POWER_STATE          = 0x319
+	SENSOR_DATA_ATMOSPHERIC_PRESSURE = 0x431
+	SENSOR_DATA_HUMIDITY             = 0x433
+	SENSOR_DATA_TEMPERATURE          = 0x434
+	SENSOR_DATA_ACCELERATION         = 0x452
+	SENSOR_DATA_ACCELERATION_X       = 0x453
+	SENSOR_DATA_ACCELERATION_Y       = 0x454
+	SENSOR_DATA_ACCELERATION_Z       = 0x455
+	SENSOR_DATA_ANGULAR_VELOCITY     = 0x456
+	SENSOR_DATA_ANGULAR_VELOCITY_X   = 0x457
+	SENSOR_DATA_ANGULAR_VELOCITY_Y   = 0x458
+	SENSOR_DATA_ANGULAR_VELOCITY_Z   = 0x459
+	SENSOR_DATA_HEADING              = 0x471
+	SENSOR_DATA_QUATERNION           = 0x483
+	SENSOR_DATA_MAGNETIC_FLUX        = 0x485
+	SENSOR_DATA_MAGNETIC_FLUX_X      = 0x486
+	SENSOR_DATA_MAGNETIC_FLUX_Y      = 0x487
+	SENSOR_DATA_MAGNETIC_FLUX_Z      = 0x488
+	SENSOR_DATA_LIGHT                = 0x4d0
+	SENSOR_DATA_ILLUMINANCE          = 0x4d1
+	SENSOR_DATA_COLOR_TEMPERATURE    = 0x4d2
+)
+
+// Power Device page (0x84) usages
+const (
+	POWER_INAME                 = 0x01
+	POWER_PRESENT_STATUS        = 0x02
+	POWER_CHANGED_STATUS        = 0x03
+	POWER_UPS                   = 0x04
+	POWER_POWER_SUPPLY          = 0x05
+	POWER_BATTERY_SYSTEM        = 0x10
+	POWER_BATTERY               = 0x12
+	POWER_CHARGER               = 0x14
+	POWER_POWER_CONVERTER       = 0x16
+	POWER_INPUT                 = 0x1a
+	POWER_OUTPUT                = 0x1c
+	POWER_FLOW                  = 0x1e
+	POWER_POWER_SUMMARY         = 0x24
+	POWER_VOLTAGE               = 0x30
+	POWER_CURRENT               = 0x31
+	POWER_FREQUENCY             = 0x32
+	POWER_APPARENT_POWER        = 0x33
+	POWER_ACTIVE_POWER          = 0x34
+	POWER_PERCENT_LOAD          = 0x35
+	POWER_TEMPERATURE           = 0x36
+	POWER_HUMIDITY              = 0x37
+	POWER_CONFIG_VOLTAGE        = 0x40
+	POWER_LOW_VOLTAGE_TRANSFER  = 0x53
+	POWER_HIGH_VOLTAGE_TRANSFER = 0x54
+	POWER_DELAY_BEFORE_STARTUP  = 0x56
+	POWER_DELAY_BEFORE_SHUTDOWN = 0x57
+	POWER_TEST                  = 0x58
+	POWER_AUDIBLE_ALARM_CONTROL = 0x5a
+	POWER_PRESENT               = 0x60
+	POWER_GOOD                  = 0x61
+	POWER_INTERNAL_FAILURE      = 0x62
+	POWER_OVERLOAD              = 0x65
+	POWER_OVER_CHARGED          = 0x66
+	POWER_OVER_TEMPERATURE      = 0x67
+	POWER_SHUTDOWN_REQUESTED    = 0x68
+	POWER_SHUTDOWN_IMMINENT     = 0x69
+	POWER_SWITCH_ON_OFF         = 0x6b
+	POWER_BOOST                 = 0x6e
+	POWER_BUCK                  = 0x6f
+	POWER_COMMUNICATION_LOST    = 0x73
+	POWER_IMANUFACTURER         = 0xfd
+	POWER_IPRODUCT              = 0xfe
+	POWER_ISERIAL_NUMBER        = 0xff
+)
+
+// Battery System page (0x85) usages
+const (
+	BATTERY_REMAINING_CAPACITY_LIMIT       = 0x29
+	BATTERY_REMAINING_TIME_LIMIT           = 0x2a
+	BATTERY_CAPACITY_MODE                  = 0x2c
+	BATTERY_BELOW_REMAINING_CAPACITY_LIMIT = 0x42
+	BATTERY_REMAINING_TIME_LIMIT_EXPIRED   = 0x43
+	BATTERY_CHARGING                       = 0x44
+	BATTERY_DISCHARGING                    = 0x45
+	BATTERY_NEED_REPLACEMENT               = 0x4b
+	BATTERY_REMAINING_CAPACITY             = 0x66
+	BATTERY_FULL_CHARGE_CAPACITY           = 0x67
+	BATTERY_RUN_TIME_TO_EMPTY              = 0x68
+	BATTERY_DESIGN_CAPACITY                = 0x83
+	BATTERY_MANUFACTURE_DATE               = 0x85
+	BATTERY_IDEVICE_CHEMISTRY              = 0x89
+	BATTERY_RECHARGEABLE                   = 0x8b
+	BATTERY_WARNING_CAPACITY_LIMIT         = 0x8c
+	BATTERY_AC_PRESENT                     = 0xd0
+	BATTERY_BATTERY_PRESENT                = 0xd1
+)
+
+// Bar Code Scanner page (0x8c) usages
+const (
+	BCS_BADGE_READER            = 0x01
+	BCS_SCANNER                 = 0x02
+	BCS_DUMB_SCANNER            = 0x03
+	BCS_CORDLESS_BASE           = 0x04
+	BCS_CRADLE                  = 0x05
+	BCS_ATTRIBUTE_REPORT        = 0x10
+	BCS_SETTINGS_REPORT         = 0x11
+	BCS_SCANNED_DATA_REPORT     = 0x12
+	BCS_RAW_SCANNED_DATA_REPORT = 0x13
+	BCS_TRIGGER_REPORT          = 0x14
+	BCS_STATUS_REPORT           = 0x15
+	BCS_SYMBOLOGY_ID_1          = 0xfb
+	BCS_SYMBOLOGY_ID_2          = 0xfc
+	BCS_SYMBOLOGY_ID_3          = 0xfd
+	BCS_DECODED_DATA            = 0xfe
+	BCS_DECODED_DATA_CONTINUED  = 0xff
+)
+
+// Scale page (0x8d) usages
+const (
+	SCALE_SCALES                = 0x01
+	SCALE_DEVICE                = 0x20
+	SCALE_ATTRIBUTE_REPORT      = 0x30
+	SCALE_CONTROL_REPORT        = 0x31
+	SCALE_DATA_REPORT           = 0x32
+	SCALE_STATUS_REPORT         = 0x33
+	SCALE_DATA_WEIGHT           = 0x40
+	SCALE_DATA_SCALING          = 0x41
+	SCALE_WEIGHT_UNIT           = 0x50
+	SCALE_UNIT_MILLIGRAM        = 0x51
+	SCALE_UNIT_GRAM             = 0x52
+	SCALE_UNIT_KILOGRAM         = 0x53
+	SCALE_UNIT_OUNCE            = 0x5b
+	SCALE_UNIT_POUND            = 0x5c
+	SCALE_STATUS                = 0x70
+	SCALE_STATUS_FAULT          = 0x71
+	SCALE_STATUS_CENTER_OF_ZERO = 0x72
+	SCALE_STATUS_IN_MOTION      = 0x73
+	SCALE_STATUS_WEIGHT_STABLE  = 0x74
+	SCALE_STATUS_UNDER_ZERO     = 0x75
+	SCALE_STATUS_OVER_LIMIT     = 0x76
+)
+
+// Magnetic Stripe Reader page (0x8e) usages
+const (
+	MSR_DEVICE_READ_ONLY = 0x01
+	MSR_TRACK_1_LENGTH   = 0x11
+	MSR_TRACK_2_LENGTH   = 0x12
+	MSR_TRACK_3_LENGTH   = 0x13
+	MSR_TRACK_JIS_LENGTH = 0x14
+	MSR_TRACK_DATA       = 0x20
+	MSR_TRACK_1_DATA     = 0x21
+	MSR_TRACK_2_DATA     = 0x22
+	MSR_TRACK_3_DATA     = 0x23
+	MSR_TRACK_JIS_DATA   = 0x24
+)
+
+// FIDO Alliance page (0xf1d0) usages
+const (
+	FIDO_U2F_AUTHENTICATOR  = 0x01
+	FIDO_INPUT_REPORT_DATA  = 0x20
+	FIDO_OUTPUT_REPORT_DATA = 0x21
+)
+
+var pageNames = map[uint16]string{
+	PAGE_GENERIC_DESKTOP:    "Generic Desktop",
+	PAGE_SIMULATION:         "Simulation Controls",
+	PAGE_VR:                 "VR Controls",
+	PAGE_SPORT:              "Sport Controls",
+	PAGE_GAME:               "Game Controls",
+	PAGE_GENERIC_DEVICE:     "Generic Device Controls",
+	PAGE_KEYBOARD:           "Keyboard/Keypad",
+	PAGE_LED:                "LED",
+	PAGE_BUTTON:             "Button",
+	PAGE_ORDINAL:            "Ordinal",
+	PAGE_TELEPHONY:          "Telephony Device",
+	PAGE_CONSUMER:           "Consumer",
+	PAGE_DIGITIZER:          "Digitizers",
+	PAGE_HAPTICS:            "Haptics",
+	PAGE_PID:                "Physical Input Device",
+	PAGE_UNICODE:            "Unicode",
+	PAGE_EYE_HEAD_TRACKER:   "Eye and Head Trackers",
+	PAGE_AUXILIARY_DISPLAY:  "Auxiliary Display",
+	PAGE_SENSOR:             "Sensors",
+	PAGE_MEDICAL:            "Medical Instrument",
+	PAGE_BRAILLE:            "Braille Display",
+	PAGE_LIGHTING:           "Lighting And Illumination",
+	PAGE_MONITOR:            "Monitor",
+	PAGE_MONITOR_ENUMERATED: "Monitor Enumerated",
+	PAGE_VESA_VIRTUAL:       "VESA Virtual Controls",
+	PAGE_POWER_DEVICE:       "Power Device",
+	PAGE_BATTERY_SYSTEM:     "Battery System",
+	PAGE_BARCODE_SCANNER:    "Bar Code Scanner",
+	PAGE_SCALE:              "Scale",
+	PAGE_MSR:                "Magnetic Stripe Reader",
+	PAGE_CAMERA:             "Camera Control",
+	PAGE_ARCADE:             "Arcade",
+	PAGE_FIDO:               "FIDO Alliance",
+}
+
+var usageNames = map[uint16]map[uint16]string{
+	PAGE_KEYBOARD: {
+		0x01: "ErrorRollOver",
+		0x02: "POSTFail",
+		0x03: "ErrorUndefined",
+		0x04: "A",
+		0x05: "B",
+		0x06: "C",
+		0x07: "D",
+		0x08: "E",
+		0x09: "F",
+		0x0a: "G",
+		0x0b: "H",
+		0x0c: "I",
+		0x0d: "J",
+		0x0e: "K",
+		0x0f: "L",
+		0x10: "M",
+		0x11: "N",
+		0x12: "O",
+		0x13: "P",
+		0x14: "Q",
+		0x15: "R",
+		0x16: "S",
+		0x17: "T",
+		0x18: "U",
+		0x19: "V",
+		0x1a: "W",
+		0x1b: "X",
+		0x1c: "Y",
+		0x1d: "Z",
+		0x1e: "1",
+		0x1f: "2",
+		0x20: "3",
+		0x21: "4",
+		0x22: "5",
+		0x23: "6",
+		0x24: "7",
+		0x25: "8",
+		0x26: "9",
+		0x27: "0",
+		0x28: "Return",
+		0x29: "Escape",
+		0x2a: "Backspace",
+		0x2b: "Tab",
+		0x2c: "Space",
+		0x2d: "-",
+		0x2e: "=",
+		0x2f: "[",
+		0x30: "]",
+		0x31: "\\",
+		0x32: "Non-US #",
+		0x33: ";",
+		0x34: "'",
+		0x35: "`",
+		0x36: ",",
+		0x37: ".",
+		0x38: "/",
+		0x39: "Caps Lock",
+		0x3a: "F1",
+		0x3b: "F2",
+		0x3c: "F3",
+		0x3d: "F4",
+		0x3e: "F5",
+		0x3f: "F6",
+		0x40: "F7",
+		0x41: "F8",
+		0x42: "F9",
+		0x43: "F10",
+		0x44: "F11",
+		0x45: "F12",
+		0x46: "Print Screen",
+		0x47: "Scroll Lock",
+		0x48: "Pause",
+		0x49: "Insert",
+		0x4a: "Home",
+		0x4b: "Page Up",
+		0x4c: "Delete",
+		0x4d: "End",
+		0x4e: "Page Down",
+		0x4f: "Right Arrow",
+		0x50: "Left Arrow",
+		0x51: "Down Arrow",
+		0x52: "Up Arrow",
+		0x53: "Num Lock",
+		0x54: "Keypad /",
+		0x55: "Keypad *",
+		0x56: "Keypad -",
+		0x57: "Keypad +",
+		0x58: "Keypad Enter",
+		0x59: "Keypad 1",
+		0x5a: "Keypad 2",
+		0x5b: "Keypad 3",
+		0x5c: "Keypad 4",
+		0x5d: "Keypad 5",
+		0x5e: "Keypad 6",
+		0x5f: "Keypad 7",
+		0x60: "Keypad 8",
+		0x61: "Keypad 9",
+		0x62: "Keypad 0",
+		0x63: "Keypad .",
+		0x64: "Non-US \\",
+		0x65: "Application",
+		0x66: "Power",
+		0x67: "Keypad =",
+		0x68: "F13",
+		0x69: "F14",
+		0x6a: "F15",
+		0x6b: "F16",
+		0x6c: "F17",
+		0x6d: "F18",
+		0x6e: "F19",
+		0x6f: "F20",
+		0x70: "F21",
+		0x71: "F22",
+		0x72: "F23",
+		0x73: "F24",
+		0x74: "Execute",
+		0x75: "Help",
+		0x76: "Menu",
+		0x77: "Select",
+		0x78: "Stop",
+		0x79: "Again",
+		0x7a: "Undo",
+		0x7b: "Cut",
+		0x7c: "Copy",
+		0x7d: "Paste",
+		0x7e: "Find",
+		0x7f: "Mute",
+		0x80: "Volume Up",
+		0x81: "Volume Down",
+		0xe0: "Left Control",
+		0xe1: "Left Shift",
+		0xe2: "Left Alt",
+		0xe3: "Left GUI",
+		0xe4: "Right Control",
+		0xe5: "Right Shift",
+		0xe6: "Right Alt",
+		0xe7: "Right GUI",
+	},
+	PAGE_GENERIC_DESKTOP: {
+		GD_POINTER:               "Pointer",
+		GD_MOUSE:                 "Mouse",
+		GD_JOYSTICK:              "Joystick",
+		GD_GAMEPAD:               "Gamepad",
+		GD_KEYBOARD:              "Keyboard",
+		GD_KEYPAD:                "Keypad",
+		GD_MULTI_AXIS:            "Multi-axis Controller",
+		GD_TABLET_PC_CONTROLS:    "Tablet PC System Controls",
+		GD_X:                     "X",
+		GD_Y:                     "Y",
+		GD_Z:                     "Z",
+		GD_RX:                    "Rx",
+		GD_RY:                    "Ry",
+		GD_RZ:                    "Rz",
+		GD_SLIDER:                "Slider",
+		GD_DIAL:                  "Dial",
+		GD_WHEEL:                 "Wheel",
+		GD_HAT_SWITCH:            "Hat Switch",
+		GD_COUNTED_BUFFER:        "Counted Buffer",
+		GD_BYTE_COUNT:            "Byte Count",
+		GD_MOTION_WAKEUP:         "Motion Wakeup",
+		GD_START:                 "Start",
+		GD_SELECT:                "Select",
+		GD_VX:                    "Vx",
+		GD_VY:                    "Vy",
+		GD_VZ:                    "Vz",
+		GD_VBRX:                  "Vbrx",
+		GD_VBRY:                  "Vbry",
+		GD_VBRZ:                  "Vbrz",
+		GD_VNO:                   "Vno",
+		GD_FEATURE_NOTIFICATION:  "Feature Notification",
+		GD_RESOLUTION_MULTIPLIER: "Resolution Multiplier",
+		GD_SYSTEM_CONTROL:        "System Control",
+		GD_SYSTEM_POWER_DOWN:     "System Power Down",
+		GD_SYSTEM_SLEEP:          "System Sleep",
+		GD_SYSTEM_WAKE_UP:        "System Wake Up",
+		GD_SYSTEM_CONTEXT_MENU:   "System Context Menu",
+		GD_SYSTEM_MAIN_MENU:      "System Main Menu",
+		GD_SYSTEM_APP_MENU:       "System App Menu",
+		GD_SYSTEM_MENU_HELP:      "System Menu Help",
+		GD_SYSTEM_MENU_EXIT:      "System Menu Exit",
+		GD_SYSTEM_MENU_SELECT:    "System Menu Select",
+		GD_SYSTEM_MENU_RIGHT:     "System Menu Right",
+		GD_SYSTEM_MENU_LEFT:      "System Menu Left",
+		GD_SYSTEM_MENU_UP:        "System Menu Up",
+		GD_SYSTEM_MENU_DOWN:      "System Menu Down",
+		GD_DPAD_UP:               "D-pad Up",
+		GD_DPAD_DOWN:             "D-pad Down",
+		GD_DPAD_RIGHT:            "D-pad Right",
+		GD_DPAD_LEFT:             "D-pad Left",
+	},
+	PAGE_LED: {
+		LED_NUM_LOCK:          "Num Lock",
+		LED_CAPS_LOCK:         "Caps Lock",
+		LED_SCROLL_LOCK:       "Scroll Lock",
+		LED_COMPOSE:           "Compose",
+		LED_KANA:              "Kana",
+		LED_POWER:             "Power",
+		LED_SHIFT:             "Shift",
+		LED_DO_NOT_DISTURB:    "Do Not Disturb",
+		LED_MUTE:              "Mute",
+		LED_MESSAGE_WAITING:   "Message Waiting",
+		LED_GENERIC_INDICATOR: "Generic Indicator",
+	},
+	PAGE_CONSUMER: {
+		CONSUMER_CONTROL:              "Consumer Control",
+		CONSUMER_NUMERIC_KEYPAD:       "Numeric Key Pad",
+		CONSUMER_PROGRAMMABLE_BUTTONS: "Programmable Buttons",
+		CONSUMER_MICROPHONE:           "Microphone",
+		CONSUMER_HEADPHONE:            "Headphone",
+		CONSUMER_GRAPHIC_EQUALIZER:    "Graphic Equalizer",
+		CONSUMER_POWER:                "Power",
+		CONSUMER_RESET:                "Reset",
+		CONSUMER_SLEEP:                "Sleep",
+		CONSUMER_MENU:                 "Menu",
+		CONSUMER_BRIGHTNESS_UP:        "Display Brightness Increment",
+		CONSUMER_BRIGHTNESS_DOWN:      "Display Brightness Decrement",
+		CONSUMER_PLAY:                 "Play",
+		CONSUMER_PAUSE:                "Pause",
+		CONSUMER_RECORD:               "Record",
+		CONSUMER_FAST_FORWARD:         "Fast Forward",
+		CONSUMER_REWIND:               "Rewind",
+		CONSUMER_NEXT_TRACK:           "Scan Next Track",
+		CONSUMER_PREVIOUS_TRACK:       "Scan Previous Track",
+		CONSUMER_STOP:                 "Stop",
+		CONSUMER_EJECT:                "Eject",
+		CONSUMER_PLAY_PAUSE:           "Play/Pause",
+		CONSUMER_VOLUME:               "Volume",
+		CONSUMER_MUTE:                 "Mute",
+		CONSUMER_BASS:                 "Bass",
+		CONSUMER_TREBLE:               "Treble",
+		CONSUMER_VOLUME_UP:            "Volume Increment",
+		CONSUMER_VOLUME_DOWN:          "Volume Decrement",
+		CONSUMER_AL_CONFIGURATION:     "AL Consumer Control Configuration",
+		CONSUMER_AL_EMAIL:             "AL Email Reader",
+		CONSUMER_AL_CALCULATOR:        "AL Calculator",
+		CONSUMER_AL_FILE_BROWSER:      "AL Local Machine Browser",
+		CONSUMER_AC_SEARCH:            "AC Search",
+		CONSUMER_AC_HOME:              "AC Home",
+		CONSUMER_AC_BACK:              "AC Back",
+		CONSUMER_AC_FORWARD:           "AC Forward",
+		CONSUMER_AC_STOP:              "AC Stop",
+		CONSUMER_AC_REFRESH:           "AC Refresh",
+		CONSUMER_AC_BOOKMARKS:         "AC Bookmarks",
+		CONSUMER_AC_PAN:               "AC Pan",
+	},
+	PAGE_DIGITIZER: {
+		DIGITIZER_DIGITIZER:         "Digitizer",
+		DIGITIZER_PEN:               "Pen",
+		DIGITIZER_TOUCH_SCREEN:      "Touch Screen",
+		DIGITIZER_TOUCH_PAD:         "Touch Pad",
+		DIGITIZER_STYLUS:            "Stylus",
+		DIGITIZER_FINGER:            "Finger",
+		DIGITIZER_TIP_PRESSURE:      "Tip Pressure",
+		DIGITIZER_IN_RANGE:          "In Range",
+		DIGITIZER_TOUCH:             "Touch",
+		DIGITIZER_TIP_SWITCH:        "Tip Switch",
+		DIGITIZER_BARREL_SWITCH:     "Barrel Switch",
+		DIGITIZER_CONFIDENCE:        "Confidence",
+		DIGITIZER_WIDTH:             "Width",
+		DIGITIZER_HEIGHT:            "Height",
+		DIGITIZER_CONTACT_ID:        "Contact Identifier",
+		DIGITIZER_CONTACT_COUNT:     "Contact Count",
+		DIGITIZER_CONTACT_COUNT_MAX: "Contact Count Maximum",
+	},
+	PAGE_SENSOR: {
+		SENSOR_SENSOR:                    "Sensor",
+		SENSOR_BIOMETRIC:                 "Biometric",
+		SENSOR_HUMAN_PRESENCE:            "Biometric: Human Presence",
+		SENSOR_HUMAN_PROXIMITY:           "Biometric: Human Proximity",
+		SENSOR_HUMAN_TOUCH:               "Biometric: Human Touch",
+		SENSOR_ENVIRONMENTAL:             "Environmental",
+		SENSOR_ATMOSPHERIC_PRESSURE:      "Environmental: Atmospheric Pressure",
+		SENSOR_HUMIDITY:                  "Environmental: Humidity",
+		SENSOR_TEMPERATURE:               "Environmental: Temperature",
+		SENSOR_LIGHT:                     "Light",
+		SENSOR_AMBIENT_LIGHT:             "Light: Ambient Light",
+		SENSOR_LOCATION:                  "Location",
+		SENSOR_MOTION:                    "Motion",
+		SENSOR_ACCELEROMETER_1D:          "Motion: Accelerometer 1D",
+		SENSOR_ACCELEROMETER_2D:          "Motion: Accelerometer 2D",
+		SENSOR_ACCELEROMETER_3D:          "Motion: Accelerometer 3D",
+		SENSOR_GYROMETER_1D:              "Motion: Gyrometer 1D",
+		SENSOR_GYROMETER_2D:              "Motion: Gyrometer 2D",
+		SENSOR_GYROMETER_3D:              "Motion: Gyrometer 3D",
+		SENSOR_MOTION_DETECTOR:           "Motion: Motion Detector",
+		SENSOR_ORIENTATION:               "Orientation",
+		SENSOR_COMPASS_1D:                "Orientation: Compass 1D",
+		SENSOR_COMPASS_2D:                "Orientation: Compass 2D",
+		SENSOR_COMPASS_3D:                "Orientation: Compass 3D",
+		SENSOR_INCLINOMETER_3D:           "Orientation: Inclinometer 3D",
+		SENSOR_DEVICE_ORIENTATION:        "Orientation: Device Orientation",
+		SENSOR_STATE:                     "Sensor State",
+		SENSOR_EVENT:                     "Sensor Event",
+		SENSOR_PROPERTY:                  "Property",
+		SENSOR_PROP_FRIENDLY_NAME:        "Property: Friendly Name",
+		SENSOR_PROP_SENSOR_STATUS:        "Property: Sensor Status",
+		SENSOR_PROP_MIN_REPORT_INTERVAL:  "Property: Minimum Report Interval",
+		SENSOR_PROP_MANUFACTURER:         "Property: Sensor Manufacturer",
+		SENSOR_PROP_MODEL:                "Property: Sensor Model",
+		SENSOR_PROP_SERIAL_NUMBER:        "Property: Sensor Serial Number",
+		SENSOR_PROP_REPORT_INTERVAL:      "Property: Report Interval",
+		SENSOR_PROP_SENSITIVITY_ABS:      "Property: Change Sensitivity Absolute",
+		SENSOR_PROP_ACCURACY:             "Property: Accuracy",
+		SENSOR_PROP_RESOLUTION:           "Property: Resolution",
+		SENSOR_PROP_MAXIMUM:              "Property: Maximum",
+		SENSOR_PROP_MINIMUM:              "Property: Minimum",
+		SENSOR_PROP_REPORTING_STATE:      "Property: Reporting State",
+		SENSOR_PROP_SAMPLING_RATE:        "Property: Sampling Rate",
+		SENSOR_PROP_POWER_STATE:          "Property: Power State",
+		SENSOR_DATA_ATMOSPHERIC_PRESSURE: "Data Field: Atmospheric Pressure",
+		SENSOR_DATA_HUMIDITY:             "Data Field: Relative Humidity",
+		SENSOR_DATA_TEMPERATURE:          "Data Field: Temperature",
+		SENSOR_DATA_ACCELERATION:         "Data Field: Acceleration",
+		SENSOR_DATA_ACCELERATION_X:       "Data Field: Acceleration Axis X",
+		SENSOR_DATA_ACCELERATION_Y:       "Data Field: Acceleration Axis Y",
+		SENSOR_DATA_ACCELERATION_Z:       "Data Field: Acceleration Axis Z",
+		SENSOR_DATA_ANGULAR_VELOCITY:     "Data Field: Angular Velocity",
+		SENSOR_DATA_ANGULAR_VELOCITY_X:   "Data Field: Angular Velocity about X Axis",
+		SENSOR_DATA_ANGULAR_VELOCITY_Y:   "Data Field: Angular Velocity about Y Axis",
+		SENSOR_DATA_ANGULAR_VELOCITY_Z:   "Data Field: Angular Velocity about Z Axis",
+		SENSOR_DATA_HEADING:              "Data Field: Heading",
+		SENSOR_DATA_QUATERNION:           "Data Field: Quaternion",
+		SENSOR_DATA_MAGNETIC_FLUX:        "Data Field: Magnetic Flux",
+		SENSOR_DATA_MAGNETIC_FLUX_X:      "Data Field: Magnetic Flux X Axis",
+		SENSOR_DATA_MAGNETIC_FLUX_Y:      "Data Field: Magnetic Flux Y Axis",
+		SENSOR_DATA_MAGNETIC_FLUX_Z:      "Data Field: Magnetic Flux Z Axis",
+		SENSOR_DATA_LIGHT:                "Data Field: Light",
+		SENSOR_DATA_ILLUMINANCE:          "Data Field: Illuminance",
+		SENSOR_DATA_COLOR_TEMPERATURE:    "Data Field: Color Temperature",
+	},
+	PAGE_POWER_DEVICE: {
+		POWER_INAME:                 "iName",
+		POWER_PRESENT_STATUS:        "PresentStatus",
+		POWER_CHANGED_STATUS:        "ChangedStatus",
+		POWER_UPS:                   "UPS",
+		POWER_POWER_SUPPLY:          "PowerSupply",
+		POWER_BATTERY_SYSTEM:        "BatterySystem",
+		POWER_BATTERY:               "Battery",
+		POWER_CHARGER:               "Charger",
+		POWER_POWER_CONVERTER:       "PowerConverter",
+		POWER_INPUT:                 "Input",
+		POWER_OUTPUT:                "Output",
+		POWER_FLOW:                  "Flow",
+		POWER_POWER_SUMMARY:         "PowerSummary",
+		POWER_VOLTAGE:               "Voltage",
+		POWER_CURRENT:               "Current",
+		POWER_FREQUENCY:             "Frequency",
+		POWER_APPARENT_POWER:        "ApparentPower",
+		POWER_ACTIVE_POWER:          "ActivePower",
+		POWER_PERCENT_LOAD:          "PercentLoad",
+		POWER_TEMPERATURE:           "Temperature",
+		POWER_HUMIDITY:              "Humidity",
+		POWER_CONFIG_VOLTAGE:        "ConfigVoltage",
+		POWER_LOW_VOLTAGE_TRANSFER:  "LowVoltageTransfer",
+		POWER_HIGH_VOLTAGE_TRANSFER: "HighVoltageTransfer",
+		POWER_DELAY_BEFORE_STARTUP:  "DelayBeforeStartup",
+		POWER_DELAY_BEFORE_SHUTDOWN: "DelayBeforeShutdown",
+		POWER_TEST:                  "Test",
+		POWER_AUDIBLE_ALARM_CONTROL: "AudibleAlarmControl",
+		POWER_PRESENT:               "Present",
+		POWER_GOOD:                  "Good",
+		POWER_INTERNAL_FAILURE:      "InternalFailure",
+		POWER_OVERLOAD:              "Overload",
+		POWER_OVER_CHARGED:          "OverCharged",
+		POWER_OVER_TEMPERATURE:      "OverTemperature",
+		POWER_SHUTDOWN_REQUESTED:    "ShutdownRequested",
+		POWER_SHUTDOWN_IMMINENT:     "ShutdownImminent",
+		POWER_SWITCH_ON_OFF:         "SwitchOn/Off",
+		POWER_BOOST:                 "Boost",
+		POWER_BUCK:                  "Buck",
+		POWER_COMMUNICATION_LOST:    "CommunicationLost",
+		POWER_IMANUFACTURER:         "iManufacturer",
+		POWER_IPRODUCT:              "iProduct",
+		POWER_ISERIAL_NUMBER:        "iSerialNumber",
+	},
+	PAGE_BATTERY_SYSTEM: {
+		BATTERY_REMAINING_CAPACITY_LIMIT:       "RemainingCapacityLimit",
+		BATTERY_REMAINING_TIME_LIMIT:           "RemainingTimeLimit",
+		BATTERY_CAPACITY_MODE:                  "CapacityMode",
+		BATTERY_BELOW_REMAINING_CAPACITY_LIMIT: "BelowRemainingCapacityLimit",
+		BATTERY_REMAINING_TIME_LIMIT_EXPIRED:   "RemainingTimeLimitExpired",
+		BATTERY_CHARGING:                       "Charging",
+		BATTERY_DISCHARGING:                    "Discharging",
+		BATTERY_NEED_REPLACEMENT:               "NeedReplacement",
+		BATTERY_REMAINING_CAPACITY:             "RemainingCapacity",
+		BATTERY_FULL_CHARGE_CAPACITY:           "FullChargeCapacity",
+		BATTERY_RUN_TIME_TO_EMPTY:              "RunTimeToEmpty",
+		BATTERY_DESIGN_CAPACITY:                "DesignCapacity",
+		BATTERY_MANUFACTURE_DATE:               "ManufactureDate",
+		BATTERY_IDEVICE_CHEMISTRY:              "iDeviceChemistry",
+		BATTERY_RECHARGEABLE:                   "Rechargeable",
+		BATTERY_WARNING_CAPACITY_LIMIT:         "WarningCapacityLimit",
+		BATTERY_AC_PRESENT:                     "ACPresent",
+		BATTERY_BATTERY_PRESENT:                "BatteryPresent",
+	},
+	PAGE_BARCODE_SCANNER: {
+		BCS_BADGE_READER:            "Bar Code Badge Reader",
+		BCS_SCANNER:                 "Bar Code Scanner",
+		BCS_DUMB_SCANNER:            "Dumb Bar Code Scanner",
+		BCS_CORDLESS_BASE:           "Cordless Scanner Base",
+		BCS_CRADLE:                  "Bar Code Scanner Cradle",
+		BCS_ATTRIBUTE_REPORT:        "Attribute Report",
+		BCS_SETTINGS_REPORT:         "Settings Report",
+		BCS_SCANNED_DATA_REPORT:     "Scanned Data Report",
+		BCS_RAW_SCANNED_DATA_REPORT: "Raw Scanned Data Report",
+		BCS_TRIGGER_REPORT:          "Trigger Report",
+		BCS_STATUS_REPORT:           "Status Report",
+		BCS_SYMBOLOGY_ID_1:          "Symbology Identifier 1",
+		BCS_SYMBOLOGY_ID_2:          "Symbology Identifier 2",
+		BCS_SYMBOLOGY_ID_3:          "Symbology Identifier 3",
+		BCS_DECODED_DATA:            "Decoded Data",
+		BCS_DECODED_DATA_CONTINUED:  "Decode Data Continued",
+	},
+	PAGE_SCALE: {
+		SCALE_SCALES:                "Scales",
+		SCALE_DEVICE:                "Scale Device",
+		SCALE_ATTRIBUTE_REPORT:      "Scale Attribute Report",
+		SCALE_CONTROL_REPORT:        "Scale Control Report",
+		SCALE_DATA_REPORT:           "Scale Data Report",
+		SCALE_STATUS_REPORT:         "Scale Status Report",
+		SCALE_DATA_WEIGHT:           "Data Weight",
+		SCALE_DATA_SCALING:          "Data Scaling",
+		SCALE_WEIGHT_UNIT:           "Weight Unit",
+		SCALE_UNIT_MILLIGRAM:        "Weight Unit Milligram",
+		SCALE_UNIT_GRAM:             "Weight Unit Gram",
+		SCALE_UNIT_KILOGRAM:         "Weight Unit Kilogram",
+		SCALE_UNIT_OUNCE:            "Weight Unit Ounce",
+		SCALE_UNIT_POUND:            "Weight Unit Pound",
+		SCALE_STATUS:                "Scale Status",
+		SCALE_STATUS_FAULT:          "Scale Status Fault",
+		SCALE_STATUS_CENTER_OF_ZERO: "Scale Status Stable at Center of Zero",
+		SCALE_STATUS_IN_MOTION:      "Scale Status In Motion",
+		SCALE_STATUS_WEIGHT_STABLE:  "Scale Status Weight Stable",
+		SCALE_STATUS_UNDER_ZERO:     "Scale Status Under Zero",
+		SCALE_STATUS_OVER_LIMIT:     "Scale Status Over Weight Limit",
+	},
+	PAGE_MSR: {
+		MSR_DEVICE_READ_ONLY: "MSR Device Read-Only",
+		MSR_TRACK_1_LENGTH:   "Track 1 Length",
+		MSR_TRACK_2_LENGTH:   "Track 2 Length",
+		MSR_TRACK_3_LENGTH:   "Track 3 Length",
+		MSR_TRACK_JIS_LENGTH: "Track JIS Length",
+		MSR_TRACK_DATA:       "Track Data",
+		MSR_TRACK_1_DATA:     "Track 1 Data",
+		MSR_TRACK_2_DATA:     "Track 2 Data",
+		MSR_TRACK_3_DATA:     "Track 3 Data",
+		MSR_TRACK_JIS_DATA:   "Track JIS Data",
+	},
+	PAGE_FIDO: {
+		FIDO_U2F_AUTHENTICATOR:  "U2F Authenticator Device",
+		FIDO_INPUT_REPORT_DATA:  "Input Report Data",
+		FIDO_OUTPUT_REPORT_DATA: "Output Report Data",
+	},
+}
+
+// PageName returns the name of a usage page, "Vendor 0xff00" for vendor
+// pages and "Page 0x..." for pages not in the table.
+func PageName(page uint16) string {
+	if s, ok := pageNames[page]; ok {
+		return s
+	}
+	if page >= PAGE_VENDOR_MIN {
+		return fmt.Sprintf("Vendor 0x%04x", page)
+	}
+	return fmt.Sprintf("Page 0x%04x", page)
+}
+
+// Name returns the usage's name within its page.  Buttons and ordinals
+// are numbered rather than named; other unknown usages are shown in hex.
+func (u Usage) Name() string {
+	switch u.Page() {
+	case PAGE_BUTTON:
+		if u.ID() == 0 {
+			return "No Button Pressed"
+		}
+		return fmt.Sprintf("Button %d", u.ID())
+	case PAGE_ORDINAL:
+		return fmt.Sprintf("Instance %d", u.ID())
+	}
+	if s, ok := usageNames[u.Page()][u.ID()]; ok {
+		return s
+	}
+	return fmt.Sprintf("0x%04x", u.ID())
+}
+
+// String formats the usage as "Page/Usage", e.g. "Generic Desktop/X".
+func (u Usage) String() string {
+	return PageName(u.Page()) + "/" + u.Name()
+}
+
+// LookupUsage finds a usage by name within a page, ignoring case, so
+// fields can be matched as LookupUsage(PAGE_SENSOR, "Data Field: Illuminance").
+func LookupUsage(page uint16, name string) (Usage, bool) {
+	switch page {
+	case PAGE_BUTTON, PAGE_ORDINAL:
+		var n uint16
+		var prefix = "button %d"
+		if page == PAGE_ORDINAL {
+			prefix = "instance %d"
+		}
+		if _, e := fmt.Sscanf(strings.ToLower(name), prefix, &n); e == nil {
+			return MakeUsage(page, n), true
+		}
+		return 0, false
+	}
+	for id, s := range usageNames[page] {
+		if strings.EqualFold(s, name) {
+			return MakeUsage(page, id), true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,338 @@
+package usb
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+const NETLINK_KOBJECT_UEVENT = 15
+
+// HotplugEvent describes a USB device being added to or removed from the
+// system.  For removals the sysfs entry is already gone, so Device only
+// carries what the kernel put in the uevent: bus and device number,
+// vendor and product IDs, device version and class triple.
+type HotplugEvent struct {
+	Action string // "add", "remove", "bind", "unbind", "change"
+	Device *DeviceInfo
+}
+
+// HotplugMonitor reads kernel uevents for USB devices from netlink.
+type HotplugMonitor struct {
+	f *os.File
+}
+
+func NewHotplugMonitor() (*HotplugMonitor, error) {
+	fd, e := syscall.Socket(syscall.AF_NETLINK,
+		syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC|syscall.SOCK_NONBLOCK,
+		NETLINK_KOBJECT_UEVENT)
+	if e != nil {
+		return nil, e
+	}
+	sa := &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: 1}
+	if e := syscall.Bind(fd, sa); e != nil {
+		syscall.Close(fd)
+		return nil, e
+	}
+	// nonblocking, so os.File hands it to the runtime poller and Close
+	// wakes up a pending Next
+	return &HotplugMonitor{f: os.NewFile(uintptr(fd), "uevent")}, nil
+}
+
+// Next blocks until the next USB device event arrives.  Interface-level
+// events and other subsystems are skipped.
+func (m *HotplugMonitor) Next() (*HotplugEvent, error) {
+	buf := make([]byte, 8192)
+	for {
+		n, e := m.f.Read(buf)
+		if e != nil {
+			return nil, e
+		}
+		if ev := parseUevent(buf[:n]); ev != nil {
+			return ev, nil
+		}
+	}
+}
+
+func (m *HotplugMonitor) Close() error {
+	return m.f.Close()
+}
+
+func parseUevent(msg []byte) *HotplugEvent {
+	vars := make(map[string]string)
+	for i, f := range strings.Split(string(msg), "\x00") {
+		// the first field is the "action@devpath" summary
+		if i == 0 {
+			continue
+		}
+		if n := strings.IndexByte(f, '='); n > 0 {
+			vars[f[:n]] = f[n+1:]
+		}
+	}
+	if vars["SUBSYSTEM"] != "usb" || vars["DEVTYPE"] != "usb_device" {
+		return nil
+	}
+	ev := &HotplugEvent{Action: vars["ACTION"]}
+	// the same path as listings give, rather than the one under
+	// /sys/devices, so events and listed devices can be matched up
+	r := DefaultRoot
+	syspath := r.sysfs() + vars["DEVPATH"][strings.LastIndexByte(vars["DEVPATH"], '/')+1:]
+	if ev.Action == "add" {
+		if di, e := readDeviceInfo(r, syspath); e == nil {
+			ev.Device = di
+			return ev
+		}
+	}
+	di := &DeviceInfo{}
+	di.BusNum, _ = strconv.Atoi(vars["BUSNUM"])
+	di.DevNum, _ = strconv.Atoi(vars["DEVNUM"])
+	di.syspath = syspath
+	di.devpath = r.node(di.BusNum, di.DevNum)
+	di.root = r
+	di.Ports = parsePorts(di.Path())
+	// PRODUCT=vid/pid/bcdDevice, TYPE=class/subclass/protocol
+	if p := strings.Split(vars["PRODUCT"], "/"); len(p) == 3 {
+		di.VendorID = uint16(parseHex(p[0]))
+		di.ProductID = uint16(parseHex(p[1]))
+		di.DeviceVersion = uint16(parseHex(p[2]))
+	}
+	if t := strings.Split(vars["TYPE"], "/"); len(t) == 3 {
+		di.DeviceClass = uint8(atou([]byte(t[0])))
+		di.DeviceSubClass = uint8(atou([]byte(t[1])))
+		di.DeviceProtocol = uint8(atou([]byte(t[2])))
+	}
+	ev.Device = di
+	return ev
+}
+
+func parseHex(s string) uint64 {
+	n, _ := strconv.ParseUint(s, 16, 16)
+	return n
+}
+
+// Matcher selects devices for WaitForDevice and friends.
+type Matcher func(*DeviceInfo) bool
+
+func MatchVidPid(vid uint16, pid uint16) Matcher {
+	return func(di *DeviceInfo) bool {
+		return di.VendorID == vid && di.ProductID == pid
+	}
+}
+
+// WaitForDevice returns the first device accepted by match, waiting for it
+// to be plugged in if it isn't attached already.  It gives up with the
+// context's error once ctx is done.  The kernel announces a device before
+// udev has set up permissions on its node, so a caller that opens the
+// device straight away may briefly see EACCES.
+func WaitForDevice(ctx context.Context, match Matcher) (*DeviceInfo, error) {
+	// start listening before scanning so an attach between the two isn't lost
+	m, e := NewHotplugMonitor()
+	if e != nil {
+		return nil, e
+	}
+	defer m.Close()
+	devs, e := ListDevices()
+	if e != nil {
+		return nil, e
+	}
+	for _, di := range devs {
+		if match(di) {
+			return di, nil
+		}
+	}
+	stop := context.AfterFunc(ctx, func() { m.Close() })
+	defer stop()
+	for {
+		ev, e := m.Next()
+		if e != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, e
+		}
+		if ev.Action == "add" && match(ev.Device) {
+			return ev.Device, nil
+		}
+	}
+}
+
+type HotplugEventType int
+
+const (
+	HOTPLUG_ARRIVED   HotplugEventType = 1 << iota // device added
+	HOTPLUG_LEFT                                   // device removed
+	HOTPLUG_ENUMERATE                              // also report devices already attached
+)
+
+func (ev *HotplugEvent) Type() HotplugEventType {
+	switch ev.Action {
+	case "add":
+		return HOTPLUG_ARRIVED
+	case "remove":
+		return HOTPLUG_LEFT
+	}
+	return 0
+}
+
+// HotplugCallback handles an event; returning true deregisters it, as with
+// libusb's hotplug callbacks.
+type HotplugCallback func(ev *HotplugEvent) bool
+
+type HotplugHandle struct {
+	events    HotplugEventType
+	match     Matcher
+	cb        HotplugCallback
+	dead      bool
+	enumerate bool // the devices already attached are still to be reported
+}
+
+var hotplug struct {
+	lock    sync.Mutex
+	mon     *HotplugMonitor
+	wake    chan struct{} // to the monitor's dispatcher, when a handle needs enumerating
+	handles []*HotplugHandle
+}
+
+// RegisterHotplugCallback arranges for cb to be called for every event of
+// the requested types on devices accepted by match (nil matches all).  All
+// callbacks run one at a time on a single goroutine shared by every
+// registration, which is started with the first one and stopped when the
+// last is deregistered.  With HOTPLUG_ENUMERATE, cb is first called on
+// that goroutine with a synthetic arrival for each matching device already
+// attached, before any event that comes in later; RegisterHotplugCallback
+// doesn't wait for them, so it may be called from a callback.
+func RegisterHotplugCallback(events HotplugEventType, match Matcher, cb HotplugCallback) (*HotplugHandle, error) {
+	h := &HotplugHandle{events: events, match: match, cb: cb, enumerate: events&HOTPLUG_ENUMERATE != 0}
+	hotplug.lock.Lock()
+	defer hotplug.lock.Unlock()
+	if hotplug.mon == nil {
+		m, e := NewHotplugMonitor()
+		if e != nil {
+			return nil, e
+		}
+		hotplug.mon = m
+		hotplug.wake = make(chan struct{}, 1)
+		go hotplugDispatch(m, hotplug.wake)
+	}
+	hotplug.handles = append(hotplug.handles, h)
+	if h.enumerate {
+		select {
+		case hotplug.wake <- struct{}{}:
+		default:
+		}
+	}
+	return h, nil
+}
+
+// Deregister stops further callbacks.  It may be called from within the
+// callback itself.
+func (h *HotplugHandle) Deregister() {
+	hotplug.lock.Lock()
+	defer hotplug.lock.Unlock()
+	h.dead = true
+	for i, x := range hotplug.handles {
+		if x == h {
+			hotplug.handles = append(hotplug.handles[:i], hotplug.handles[i+1:]...)
+			break
+		}
+	}
+	hotplugIdle()
+}
+
+// deliver reports whether the handle is finished
+func (h *HotplugHandle) deliver(ev *HotplugEvent) bool {
+	hotplug.lock.Lock()
+	dead := h.dead
+	hotplug.lock.Unlock()
+	if dead || h.events&ev.Type() == 0 {
+		return dead
+	}
+	if h.match != nil && !h.match(ev.Device) {
+		return false
+	}
+	if h.cb(ev) {
+		hotplug.lock.Lock()
+		h.dead = true
+		hotplug.lock.Unlock()
+		return true
+	}
+	return false
+}
+
+// hotplugIdle shuts the monitor down once nobody is listening; the caller
+// holds hotplug.lock
+func hotplugIdle() {
+	if len(hotplug.handles) == 0 && hotplug.mon != nil {
+		hotplug.mon.Close()
+		hotplug.mon = nil
+	}
+}
+
+// hotplugDispatch delivers m's events, and the enumerations handles ask
+// for through wake, until m is closed
+func hotplugDispatch(m *HotplugMonitor, wake <-chan struct{}) {
+	events := make(chan *HotplugEvent)
+	go func() {
+		defer close(events)
+		for {
+			ev, e := m.Next()
+			if e != nil {
+				return
+			}
+			events <- ev
+		}
+	}()
+	// the reader stops once m is closed
+	defer func() {
+		for range events {
+		}
+	}()
+	for {
+		var ev *HotplugEvent
+		select {
+		case <-wake:
+		case x, ok := <-events:
+			if !ok {
+				return
+			}
+			ev = x
+		}
+		hotplug.lock.Lock()
+		if hotplug.mon != m {
+			// closed, and maybe replaced by one with its own dispatcher
+			hotplug.lock.Unlock()
+			return
+		}
+		handles := append([]*HotplugHandle(nil), hotplug.handles...)
+		var enumerate []bool
+		for _, h := range handles {
+			enumerate = append(enumerate, h.enumerate)
+			h.enumerate = false
+		}
+		hotplug.lock.Unlock()
+		for i, h := range handles {
+			if enumerate[i] && h.enumerateAttached() {
+				h.Deregister()
+				continue
+			}
+			if ev != nil && h.deliver(ev) {
+				h.Deregister()
+			}
+		}
+	}
+}
+
+// enumerateAttached reports the devices already attached as arrivals,
+// and whether the handle is finished
+func (h *HotplugHandle) enumerateAttached() bool {
+	devs, _ := ListDevices()
+	for _, di := range devs {
+		if h.deliver(&HotplugEvent{Action: "add", Device: di}) {
+			return true
+		}
+	}
+	return false
+}
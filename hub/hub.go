@@ -0,0 +1,280 @@
+// Package hub drives hubs through their class requests: the hub
+// descriptor, port status and features, port resets and power cycling,
+// and a monitor of port events.  The kernel's hub driver keeps running
+// alongside, except on ports claimed with usb.Device.ClaimPort.
+package hub
+
+import (
+	"syscall"
+	"time"
+
+	"github.com/richardnwinder/usb"
+)
+
+const (
+	CLASS_HUB = 0x09
+	DT_HUB    = 0x29
+	DT_SS_HUB = 0x2a
+
+	// hub class request recipients
+	REQTYPE_HUB  = usb.REQTYPE_CLASS | usb.REQTYPE_DEVICE
+	REQTYPE_PORT = usb.REQTYPE_CLASS | usb.REQTYPE_OTHER
+
+	// hub feature selectors
+	HUB_C_HUB_LOCAL_POWER  = 0
+	HUB_C_HUB_OVER_CURRENT = 1
+
+	// port feature selectors
+	HUB_PORT_CONNECTION     = 0
+	HUB_PORT_ENABLE         = 1
+	HUB_PORT_SUSPEND        = 2
+	HUB_PORT_OVER_CURRENT   = 3
+	HUB_PORT_RESET          = 4
+	HUB_PORT_POWER          = 8
+	HUB_PORT_LOW_SPEED      = 9
+	HUB_C_PORT_CONNECTION   = 16
+	HUB_C_PORT_ENABLE       = 17
+	HUB_C_PORT_SUSPEND      = 18
+	HUB_C_PORT_OVER_CURRENT = 19
+	HUB_C_PORT_RESET        = 20
+	HUB_PORT_TEST           = 21
+	HUB_PORT_INDICATOR      = 22
+	HUB_C_PORT_LINK_STATE   = 25 // SuperSpeed
+	HUB_C_PORT_CONFIG_ERROR = 26 // SuperSpeed
+	HUB_BH_PORT_RESET       = 28 // SuperSpeed warm reset
+	HUB_C_BH_PORT_RESET     = 29 // SuperSpeed
+
+	// wPortStatus bits
+	PORT_STAT_CONNECTION  = 0x0001
+	PORT_STAT_ENABLE      = 0x0002
+	PORT_STAT_SUSPEND     = 0x0004
+	PORT_STAT_OVERCURRENT = 0x0008
+	PORT_STAT_RESET       = 0x0010
+	PORT_STAT_POWER       = 0x0100
+	PORT_STAT_LOW_SPEED   = 0x0200
+	PORT_STAT_HIGH_SPEED  = 0x0400
+	PORT_STAT_SS_POWER    = 0x0200
+
+	// wPortChange bits
+	PORT_STAT_C_CONNECTION   = 0x0001
+	PORT_STAT_C_ENABLE       = 0x0002
+	PORT_STAT_C_SUSPEND      = 0x0004
+	PORT_STAT_C_OVERCURRENT  = 0x0008
+	PORT_STAT_C_RESET        = 0x0010
+	PORT_STAT_C_BH_RESET     = 0x0020 // SuperSpeed
+	PORT_STAT_C_LINK_STATE   = 0x0040 // SuperSpeed
+	PORT_STAT_C_CONFIG_ERROR = 0x0080 // SuperSpeed
+
+	// wHubStatus bits, and the same in wHubChange
+	HUB_STAT_LOCAL_POWER = 0x0001 // the local power supply is lost
+	HUB_STAT_OVERCURRENT = 0x0002
+)
+
+// Descriptor is the part of a hub's class descriptor that is the same
+// for USB 2.0 and SuperSpeed hubs.
+type Descriptor struct {
+	SuperSpeed      bool
+	NumPorts        uint8
+	Characteristics uint16
+	PowerOnDelay    time.Duration // from switching a port on to its power being good
+	ControlCurrent  uint8         // mA for USB 2.0 hubs, 4mA units for SuperSpeed
+	Removable       []bool        // by port, from 1; index 0 is unused
+}
+
+// PowerSwitching says how the hub switches port power, from
+// wHubCharacteristics: "ganged", "per-port" or "none".  Many hubs claim
+// per-port switching and don't actually cut VBUS.
+func (h *Descriptor) PowerSwitching() string {
+	switch h.Characteristics & 3 {
+	case 0:
+		return "ganged"
+	case 1:
+		return "per-port"
+	}
+	return "none"
+}
+
+// ReadDescriptor reads the hub class descriptor of the hub u.
+func ReadDescriptor(u usb.ControlTransferer, superSpeed bool) (*Descriptor, error) {
+	kind := uint16(DT_HUB)
+	if superSpeed {
+		kind = DT_SS_HUB
+	}
+	b := make([]byte, 64)
+	n, e := u.ControlTransfer(usb.REQTYPE_IN|REQTYPE_HUB, usb.REQ_GET_DESCRIPTOR, kind<<8, 0, uint16(len(b)), 1000, b)
+	if e != nil {
+		return nil, e
+	}
+	if n < 7 || b[1] != uint8(kind) {
+		return nil, syscall.EPROTO
+	}
+	h := &Descriptor{
+		SuperSpeed:      superSpeed,
+		NumPorts:        b[2],
+		Characteristics: uint16(b[3]) | uint16(b[4])<<8,
+		PowerOnDelay:    time.Duration(b[5]) * 2 * time.Millisecond,
+		ControlCurrent:  b[6],
+		Removable:       make([]bool, int(b[2])+1),
+	}
+	// DeviceRemovable is a bitmap with bit 0 reserved; SuperSpeed hubs
+	// put bHubHdrDecLat before it
+	at := 7
+	if superSpeed {
+		at = 10
+	}
+	for p := 1; p <= int(h.NumPorts); p++ {
+		if i := at + p/8; i < n {
+			h.Removable[p] = b[i]&(1<<(p%8)) == 0
+		}
+	}
+	return h, nil
+}
+
+// PortStatus is a hub port's wPortStatus and wPortChange.
+type PortStatus struct {
+	Status uint16
+	Change uint16
+}
+
+// ReadPortStatus reads the status of a port of the hub u, counting from
+// 1.
+func ReadPortStatus(u usb.ControlTransferer, port int) (PortStatus, error) {
+	if port < 1 || port > 255 {
+		return PortStatus{}, syscall.EINVAL
+	}
+	b := make([]byte, 4)
+	n, e := u.ControlTransfer(usb.REQTYPE_IN|REQTYPE_PORT, usb.REQ_GET_STATUS, 0, uint16(port), 4, 1000, b)
+	if e != nil {
+		return PortStatus{}, e
+	}
+	if n < 4 {
+		return PortStatus{}, syscall.EPROTO
+	}
+	return PortStatus{uint16(b[0]) | uint16(b[1])<<8, uint16(b[2]) | uint16(b[3])<<8}, nil
+}
+
+// ReadHubStatus reads the hub u's own wHubStatus and wHubChange, in a
+// PortStatus.
+func ReadHubStatus(u usb.ControlTransferer) (PortStatus, error) {
+	b := make([]byte, 4)
+	n, e := u.ControlTransfer(usb.REQTYPE_IN|REQTYPE_HUB, usb.REQ_GET_STATUS, 0, 0, 4, 1000, b)
+	if e != nil {
+		return PortStatus{}, e
+	}
+	if n < 4 {
+		return PortStatus{}, syscall.EPROTO
+	}
+	return PortStatus{uint16(b[0]) | uint16(b[1])<<8, uint16(b[2]) | uint16(b[3])<<8}, nil
+}
+
+func ClearHubFeature(u usb.ControlTransferer, feature uint16) error {
+	_, e := u.ControlTransfer(REQTYPE_HUB, usb.REQ_CLEAR_FEATURE, feature, 0, 0, 1000, nil)
+	return e
+}
+
+func SetPortFeature(u usb.ControlTransferer, port int, feature uint16) error {
+	if port < 1 || port > 255 {
+		return syscall.EINVAL
+	}
+	_, e := u.ControlTransfer(REQTYPE_PORT, usb.REQ_SET_FEATURE, feature, uint16(port), 0, 1000, nil)
+	return e
+}
+
+func ClearPortFeature(u usb.ControlTransferer, port int, feature uint16) error {
+	if port < 1 || port > 255 {
+		return syscall.EINVAL
+	}
+	_, e := u.ControlTransfer(REQTYPE_PORT, usb.REQ_CLEAR_FEATURE, feature, uint16(port), 0, 1000, nil)
+	return e
+}
+
+// ResetPort resets a hub's port and waits for the reset to finish.  This
+// goes behind the kernel's hub driver: the device loses its address, so
+// the kernel finds it unresponsive and enumerates it afresh, which is
+// the point when USBDEVFS_RESET on the device itself can't get through.
+func ResetPort(u usb.ControlTransferer, port int) error {
+	if e := SetPortFeature(u, port, HUB_PORT_RESET); e != nil {
+		return e
+	}
+	for deadline := time.Now().Add(time.Second); ; {
+		time.Sleep(20 * time.Millisecond)
+		st, e := ReadPortStatus(u, port)
+		if e != nil {
+			return e
+		}
+		if st.Status&PORT_STAT_RESET == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return syscall.ETIMEDOUT
+		}
+	}
+}
+
+// PowerCycle switches the port the device is plugged into off for off,
+// then on again, which the device sees as being unplugged and replugged.
+// It recovers devices too wedged to answer a reset.  The device's
+// parent hub, or the root hub, must switch power per port; others give
+// EOPNOTSUPP.  Many hubs claim per-port switching without cutting VBUS,
+// which only trying finds out.  The port is claimed while it is off, so
+// the hub driver doesn't switch it back on.  PowerCycle returns once the
+// power is good again; the device then enumerates afresh and has to be
+// listed and opened again.
+func PowerCycle(di *usb.DeviceInfo, off time.Duration) error {
+	parent, e := di.Parent()
+	if e != nil {
+		return e
+	}
+	port := di.Ports[len(di.Ports)-1]
+	h, e := usb.Open(parent)
+	if e != nil {
+		return e
+	}
+	defer h.Close()
+	s, _ := parent.Speed()
+	desc, e := ReadDescriptor(h, s >= usb.SPEED_SUPER)
+	if e != nil {
+		return e
+	}
+	if desc.PowerSwitching() != "per-port" {
+		return syscall.EOPNOTSUPP
+	}
+	power := uint16(PORT_STAT_POWER)
+	if desc.SuperSpeed {
+		power = PORT_STAT_SS_POWER
+	}
+	if e := h.ClaimPort(port); e != nil {
+		return e
+	}
+	if e := ClearPortFeature(h, port, HUB_PORT_POWER); e != nil {
+		h.ReleasePort(port)
+		return e
+	}
+	st, e := ReadPortStatus(h, port)
+	if e == nil && st.Status&power != 0 {
+		e = syscall.EOPNOTSUPP
+	}
+	if e == nil {
+		time.Sleep(off)
+	}
+	// released before power returns, so the device is enumerated
+	h.ReleasePort(port)
+	if e2 := SetPortFeature(h, port, HUB_PORT_POWER); e == nil {
+		e = e2
+	}
+	if e != nil {
+		return e
+	}
+	time.Sleep(desc.PowerOnDelay)
+	return nil
+}
+
+// PowerCycleDevice power cycles the port of the open device u, as
+// PowerCycle does.  A Device opened without sysfs, through
+// usb.Root.OpenBusDev, doesn't know its port and gives ENODEV.
+func PowerCycleDevice(u *usb.Device, off time.Duration) error {
+	if u.Info().SysPath() == "" {
+		return syscall.ENODEV
+	}
+	return PowerCycle(u.Info(), off)
+}
@@ -0,0 +1,87 @@
+package hub_test
+
+import (
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/richardnwinder/usb"
+	"github.com/richardnwinder/usb/hub"
+	"github.com/richardnwinder/usb/usbtest"
+)
+
+// a four port hub
+const hubDescriptors = `
+12 01 00 02 09 00 01 40  34 12 78 56 00 01 00 00 00 01
+09 02 19 00 01 01 00 e0 00
+09 04 00 00 01 09 00 00 00
+07 05 81 03 01 00 0c
+`
+
+func newHub(t *testing.T) *usbtest.Device {
+	f, e := usbtest.ParseHex("hub", hubDescriptors)
+	if e != nil {
+		t.Fatal(e)
+	}
+	return f.Device()
+}
+
+// getHubDescriptor matches reads of the hub class descriptor
+var getHubDescriptor = usbtest.Control(usb.REQTYPE_IN|hub.REQTYPE_HUB, usb.REQ_GET_DESCRIPTOR)
+
+// TestReadDescriptor reads the descriptors PowerCycle decides by: how the
+// hub switches power and how long it takes to come good
+func TestReadDescriptor(t *testing.T) {
+	for _, c := range []struct {
+		name      string
+		ss        bool
+		desc      []byte
+		switching string
+		removable []bool
+	}{
+		{"usb2", false,
+			[]byte{9, hub.DT_HUB, 4, 0x09, 0x00, 0x32, 0x64, 0x04, 0xff},
+			"per-port", []bool{false, true, false, true, true}},
+		{"superspeed", true,
+			[]byte{12, hub.DT_SS_HUB, 4, 0x0a, 0x00, 0x32, 0x19, 0x00, 0x00, 0x00, 0x12, 0x00},
+			"none", []bool{false, false, true, true, false}},
+	} {
+		d := newHub(t)
+		d.On(getHubDescriptor).Reply(c.desc)
+		h, e := hub.ReadDescriptor(d, c.ss)
+		if e != nil {
+			t.Errorf("%s: %v", c.name, e)
+			continue
+		}
+		if h.SuperSpeed != c.ss || h.NumPorts != 4 || h.PowerOnDelay != 100*time.Millisecond {
+			t.Errorf("%s: %+v", c.name, h)
+		}
+		if s := h.PowerSwitching(); s != c.switching {
+			t.Errorf("%s: power switching %q, want %q", c.name, s, c.switching)
+		}
+		if !reflect.DeepEqual(h.Removable, c.removable) {
+			t.Errorf("%s: removable %v, want %v", c.name, h.Removable, c.removable)
+		}
+		want := uint16(hub.DT_HUB) << 8
+		if c.ss {
+			want = uint16(hub.DT_SS_HUB) << 8
+		}
+		if q := d.Requests(); len(q) != 1 || q[0].Setup.Value != want {
+			t.Errorf("%s: requests %+v, want one for descriptor %#x", c.name, q, want)
+		}
+	}
+}
+
+func TestReadDescriptorBad(t *testing.T) {
+	for _, desc := range [][]byte{
+		{9, hub.DT_SS_HUB, 4, 0x09, 0x00, 0x32, 0x64, 0x04, 0xff}, // the wrong kind
+		{5, hub.DT_HUB, 4, 0x09, 0x00},                            // short
+	} {
+		d := newHub(t)
+		d.On(getHubDescriptor).Reply(desc)
+		if _, e := hub.ReadDescriptor(d, false); e != syscall.EPROTO {
+			t.Errorf("% x gave %v, want EPROTO", desc, e)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package hub
+
+import (
+	"sync"
+	"time"
+
+	"github.com/richardnwinder/usb"
+)
+
+// PortEventKind is what happened on a hub port.
+type PortEventKind int
+
+const (
+	PORT_CONNECT     PortEventKind = iota
+	PORT_DISCONNECT                // also reported, followed by PORT_CONNECT, for a bounce between polls
+	PORT_DISABLE                   // the hub disabled a connected port, as it does on babble or a bus error
+	PORT_OVERCURRENT               // the port is drawing too much current
+	PORT_RESET                     // a reset of the port finished
+)
+
+var portEventNames = []string{"connect", "disconnect", "disable", "overcurrent", "reset"}
+
+func (k PortEventKind) String() string {
+	if k < 0 || int(k) >= len(portEventNames) {
+		return "unknown"
+	}
+	return portEventNames[k]
+}
+
+// PortEvent reports a change on a hub port, with the status it was seen in.
+type PortEvent struct {
+	Port   int
+	Kind   PortEventKind
+	Status PortStatus
+	Time   time.Time
+}
+
+// PortMonitorOptions tunes a PortMonitor.
+type PortMonitorOptions struct {
+	// Interval is the time between polls of every port's status, 100ms
+	// if zero.
+	Interval time.Duration
+	// Interrupt, if set, is the hub's status change endpoint, which the
+	// monitor then reads instead of polling, querying only the ports it
+	// flags.  That needs the hub interface claimed, so the kernel's hub
+	// driver detached, and the monitor clears the change bits it has
+	// seen, as the hub driver would.
+	Interrupt *usb.EndpointDescriptor
+}
+
+// PortMonitor watches the ports of a hub and reports their connects,
+// disconnects, overcurrents, resets and disables on C, for finding
+// intermittent connections.  By default it polls the port status
+// alongside the kernel's hub driver, which clears the change bits as it
+// handles them, so a change that comes and goes between polls may be
+// missed.  C is closed when the monitor stops, after which Err says why.
+type PortMonitor struct {
+	C <-chan PortEvent
+
+	dev   usb.ControlTransferer
+	opts  PortMonitorOptions
+	ports int
+	last  []PortStatus // by port, from 1
+	c     chan PortEvent
+	poll  *usb.PollReader
+	quit  chan struct{}
+	once  sync.Once
+	exit  chan struct{}
+	err   error
+}
+
+// NewPortMonitor starts watching the ports of the hub u.
+func NewPortMonitor(u *usb.Device, opts PortMonitorOptions) (*PortMonitor, error) {
+	ss := false
+	if di := u.Info(); di.SysPath() != "" {
+		s, _ := di.Speed()
+		ss = s >= usb.SPEED_SUPER
+	}
+	desc, e := ReadDescriptor(u, ss)
+	if e != nil {
+		return nil, e
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = 100 * time.Millisecond
+	}
+	m := &PortMonitor{
+		dev:   u,
+		opts:  opts,
+		ports: int(desc.NumPorts),
+		last:  make([]PortStatus, int(desc.NumPorts)+1),
+		c:     make(chan PortEvent, 16),
+		quit:  make(chan struct{}),
+		exit:  make(chan struct{}),
+	}
+	m.C = m.c
+	for p := 1; p <= m.ports; p++ {
+		if m.last[p], e = ReadPortStatus(u, p); e != nil {
+			return nil, e
+		}
+	}
+	if opts.Interrupt != nil {
+		if m.poll, e = u.NewPollReader(*opts.Interrupt, usb.PollOptions{}); e != nil {
+			return nil, e
+		}
+	}
+	go m.run()
+	return m, nil
+}
+
+func (m *PortMonitor) run() {
+	defer close(m.exit)
+	defer close(m.c)
+	if m.poll != nil {
+		defer m.poll.Close()
+	}
+	t := time.NewTicker(m.opts.Interval)
+	defer t.Stop()
+	for m.err == nil {
+		var changed []byte // bitmap, bit 0 for the hub itself
+		if m.poll != nil {
+			select {
+			case <-m.quit:
+				return
+			case b, ok := <-m.poll.C:
+				if !ok {
+					m.err = m.poll.Err()
+					return
+				}
+				changed = b
+			}
+		} else {
+			select {
+			case <-m.quit:
+				return
+			case <-t.C:
+			}
+		}
+		if len(changed) > 0 && changed[0]&1 != 0 {
+			m.checkHub()
+		}
+		for p := 1; p <= m.ports && m.err == nil; p++ {
+			if changed != nil && (p/8 >= len(changed) || changed[p/8]&(1<<(p%8)) == 0) {
+				continue
+			}
+			m.check(p)
+		}
+	}
+}
+
+// check reads a port's status and reports what changed since the last
+// time
+func (m *PortMonitor) check(p int) {
+	st, e := ReadPortStatus(m.dev, p)
+	if e != nil {
+		m.err = e
+		return
+	}
+	was := m.last[p]
+	m.last[p] = st
+	// change bits the hub driver hasn't cleared yet were seen last time
+	change := st.Change
+	if m.opts.Interrupt == nil {
+		change &^= was.Change
+	}
+	now := time.Now()
+	report := func(k PortEventKind) {
+		select {
+		case m.c <- PortEvent{Port: p, Kind: k, Status: st, Time: now}:
+		case <-m.quit:
+		}
+	}
+	conn := st.Status&PORT_STAT_CONNECTION != 0
+	wasConn := was.Status&PORT_STAT_CONNECTION != 0
+	switch {
+	case wasConn && !conn:
+		report(PORT_DISCONNECT)
+	case !wasConn && conn:
+		report(PORT_CONNECT)
+	case conn && change&PORT_STAT_C_CONNECTION != 0:
+		report(PORT_DISCONNECT)
+		report(PORT_CONNECT)
+	}
+	if conn && wasConn && was.Status&PORT_STAT_ENABLE != 0 && st.Status&PORT_STAT_ENABLE == 0 ||
+		change&PORT_STAT_C_ENABLE != 0 {
+		report(PORT_DISABLE)
+	}
+	if st.Status&PORT_STAT_OVERCURRENT != 0 && was.Status&PORT_STAT_OVERCURRENT == 0 ||
+		change&PORT_STAT_C_OVERCURRENT != 0 {
+		report(PORT_OVERCURRENT)
+	}
+	if change&PORT_STAT_C_RESET != 0 {
+		report(PORT_RESET)
+	}
+	if m.opts.Interrupt != nil {
+		m.clear(p, st.Change)
+	}
+}
+
+// checkHub acknowledges the hub's own change bits, which the hub flags
+// as bit 0 of the status change bitmap until they are cleared
+func (m *PortMonitor) checkHub() {
+	st, e := ReadHubStatus(m.dev)
+	if e != nil {
+		m.err = e
+		return
+	}
+	if st.Change&HUB_STAT_LOCAL_POWER != 0 {
+		ClearHubFeature(m.dev, HUB_C_HUB_LOCAL_POWER)
+	}
+	if st.Change&HUB_STAT_OVERCURRENT != 0 {
+		ClearHubFeature(m.dev, HUB_C_HUB_OVER_CURRENT)
+	}
+}
+
+var changeFeatures = []struct {
+	bit     uint16
+	feature uint16
+}{
+	{PORT_STAT_C_CONNECTION, HUB_C_PORT_CONNECTION},
+	{PORT_STAT_C_ENABLE, HUB_C_PORT_ENABLE},
+	{PORT_STAT_C_SUSPEND, HUB_C_PORT_SUSPEND},
+	{PORT_STAT_C_OVERCURRENT, HUB_C_PORT_OVER_CURRENT},
+	{PORT_STAT_C_RESET, HUB_C_PORT_RESET},
+	{PORT_STAT_C_BH_RESET, HUB_C_BH_PORT_RESET},
+	{PORT_STAT_C_LINK_STATE, HUB_C_PORT_LINK_STATE},
+	{PORT_STAT_C_CONFIG_ERROR, HUB_C_PORT_CONFIG_ERROR},
+}
+
+// clear acknowledges a port's change bits, without which the hub keeps
+// flagging the port
+func (m *PortMonitor) clear(p int, change uint16) {
+	for _, f := range changeFeatures {
+		if change&f.bit != 0 {
+			ClearPortFeature(m.dev, p, f.feature)
+		}
+	}
+}
+
+// Close stops the monitor.
+func (m *PortMonitor) Close() {
+	m.once.Do(func() { close(m.quit) })
+	<-m.exit
+}
+
+// Err returns the error reading a port that stopped the monitor, or nil
+// if it was closed.  It is only meaningful once C has been closed.
+func (m *PortMonitor) Err() error {
+	return m.err
+}
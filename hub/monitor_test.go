@@ -0,0 +1,187 @@
+package hub
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/richardnwinder/usb"
+	"github.com/richardnwinder/usb/usbtest"
+)
+
+// fakePorts answers port status requests from a table that the test
+// changes between checks, and accepts feature clears
+type fakePorts struct {
+	lock sync.Mutex
+	st   map[uint16]PortStatus
+}
+
+func (f *fakePorts) set(port int, st PortStatus) {
+	f.lock.Lock()
+	f.st[uint16(port)] = st
+	f.lock.Unlock()
+}
+
+func newMonitor(t *testing.T, interrupt bool) (*PortMonitor, *fakePorts, *usbtest.Device) {
+	f, e := usbtest.ParseHex("hub", `
+12 01 00 02 09 00 01 40  34 12 78 56 00 01 00 00 00 01
+09 02 19 00 01 01 00 e0 00
+09 04 00 00 01 09 00 00 00
+07 05 81 03 01 00 0c
+`)
+	if e != nil {
+		t.Fatal(e)
+	}
+	d := f.Device()
+	fp := &fakePorts{st: make(map[uint16]PortStatus)}
+	status := func(st PortStatus) []byte {
+		return []byte{byte(st.Status), byte(st.Status >> 8), byte(st.Change), byte(st.Change >> 8)}
+	}
+	d.On(usbtest.Control(usb.REQTYPE_IN|REQTYPE_PORT, usb.REQ_GET_STATUS)).Do(func(q *usbtest.Request) ([]byte, error) {
+		fp.lock.Lock()
+		defer fp.lock.Unlock()
+		return status(fp.st[q.Setup.Index]), nil
+	})
+	d.On(usbtest.Control(usb.REQTYPE_IN|REQTYPE_HUB, usb.REQ_GET_STATUS)).Do(func(q *usbtest.Request) ([]byte, error) {
+		fp.lock.Lock()
+		defer fp.lock.Unlock()
+		return status(fp.st[0]), nil
+	})
+	d.On(usbtest.Control(REQTYPE_PORT, usb.REQ_CLEAR_FEATURE)).Reply(nil)
+	d.On(usbtest.Control(REQTYPE_HUB, usb.REQ_CLEAR_FEATURE)).Reply(nil)
+
+	m := &PortMonitor{
+		dev:   d,
+		ports: 4,
+		last:  make([]PortStatus, 5),
+		c:     make(chan PortEvent, 16),
+		quit:  make(chan struct{}),
+		exit:  make(chan struct{}),
+	}
+	if interrupt {
+		m.opts.Interrupt = &usb.EndpointDescriptor{}
+	}
+	return m, fp, d
+}
+
+// events drains the events check reported
+func events(m *PortMonitor) []PortEventKind {
+	var list []PortEventKind
+	for {
+		select {
+		case ev := <-m.c:
+			list = append(list, ev.Kind)
+		default:
+			return list
+		}
+	}
+}
+
+// clears lists the features cleared by the requests of one type
+func clears(d *usbtest.Device, reqtype uint8) []uint16 {
+	var list []uint16
+	for _, q := range d.Requests() {
+		if q.Setup.RequestType == reqtype && q.Setup.Request == usb.REQ_CLEAR_FEATURE {
+			list = append(list, q.Setup.Value)
+		}
+	}
+	return list
+}
+
+// TestCheck follows one port through the changes a poll can see, each
+// diffed against the status of the poll before
+func TestCheck(t *testing.T) {
+	m, fp, _ := newMonitor(t, false)
+	const conn = PORT_STAT_CONNECTION | PORT_STAT_ENABLE | PORT_STAT_POWER
+	for _, c := range []struct {
+		name string
+		st   PortStatus
+		want []PortEventKind
+	}{
+		{"empty", PortStatus{PORT_STAT_POWER, 0}, nil},
+		{"connect", PortStatus{conn, PORT_STAT_C_CONNECTION}, []PortEventKind{PORT_CONNECT}},
+		// the hub driver hasn't cleared the change bit yet
+		{"seen", PortStatus{conn, PORT_STAT_C_CONNECTION}, nil},
+		{"cleared", PortStatus{conn, 0}, nil},
+		{"bounce", PortStatus{conn, PORT_STAT_C_CONNECTION}, []PortEventKind{PORT_DISCONNECT, PORT_CONNECT}},
+		{"reset", PortStatus{conn, PORT_STAT_C_CONNECTION | PORT_STAT_C_RESET}, []PortEventKind{PORT_RESET}},
+		{"disable", PortStatus{conn &^ PORT_STAT_ENABLE, 0}, []PortEventKind{PORT_DISABLE}},
+		{"overcurrent", PortStatus{conn | PORT_STAT_OVERCURRENT, PORT_STAT_C_OVERCURRENT}, []PortEventKind{PORT_OVERCURRENT}},
+		{"disconnect", PortStatus{PORT_STAT_POWER, PORT_STAT_C_CONNECTION}, []PortEventKind{PORT_DISCONNECT}},
+	} {
+		fp.set(2, c.st)
+		m.check(2)
+		if m.err != nil {
+			t.Fatalf("%s: %v", c.name, m.err)
+		}
+		if got := events(m); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: events %v, want %v", c.name, got, c.want)
+		}
+		if m.last[2] != c.st {
+			t.Errorf("%s: last %+v, want %+v", c.name, m.last[2], c.st)
+		}
+	}
+}
+
+// TestCheckInterrupt checks that a monitor reading the status change
+// endpoint acknowledges every change bit, SuperSpeed ones included, and
+// reports a change bit every time it is set, since it cleared it after
+// the time before
+func TestCheckInterrupt(t *testing.T) {
+	m, fp, d := newMonitor(t, true)
+	fp.set(3, PortStatus{PORT_STAT_CONNECTION, PORT_STAT_C_CONNECTION | PORT_STAT_C_RESET |
+		PORT_STAT_C_BH_RESET | PORT_STAT_C_LINK_STATE | PORT_STAT_C_CONFIG_ERROR})
+	m.check(3)
+	if got, want := events(m), []PortEventKind{PORT_CONNECT, PORT_RESET}; !reflect.DeepEqual(got, want) {
+		t.Errorf("events %v, want %v", got, want)
+	}
+	want := []uint16{HUB_C_PORT_CONNECTION, HUB_C_PORT_RESET, HUB_C_BH_PORT_RESET, HUB_C_PORT_LINK_STATE,
+		HUB_C_PORT_CONFIG_ERROR}
+	if got := clears(d, REQTYPE_PORT); !reflect.DeepEqual(got, want) {
+		t.Errorf("cleared %v, want %v", got, want)
+	}
+	for _, q := range d.Requests() {
+		if q.Setup.Request == usb.REQ_CLEAR_FEATURE && q.Setup.Index != 3 {
+			t.Errorf("cleared feature %d of port %d", q.Setup.Value, q.Setup.Index)
+		}
+	}
+
+	fp.set(3, PortStatus{PORT_STAT_CONNECTION, PORT_STAT_C_RESET})
+	m.check(3)
+	if got, want := events(m), []PortEventKind{PORT_RESET}; !reflect.DeepEqual(got, want) {
+		t.Errorf("second reset: events %v, want %v", got, want)
+	}
+}
+
+// TestCheckHub checks that the hub's own change bits are acknowledged
+func TestCheckHub(t *testing.T) {
+	m, fp, d := newMonitor(t, true)
+	fp.set(0, PortStatus{HUB_STAT_OVERCURRENT, HUB_STAT_LOCAL_POWER | HUB_STAT_OVERCURRENT})
+	m.checkHub()
+	if m.err != nil {
+		t.Fatal(m.err)
+	}
+	want := []uint16{HUB_C_HUB_LOCAL_POWER, HUB_C_HUB_OVER_CURRENT}
+	if got := clears(d, REQTYPE_HUB); !reflect.DeepEqual(got, want) {
+		t.Errorf("cleared %v, want %v", got, want)
+	}
+}
+
+func TestCloseTwice(t *testing.T) {
+	m, _, _ := newMonitor(t, false)
+	close(m.exit)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.Close()
+		}()
+	}
+	wg.Wait()
+	select {
+	case <-m.quit:
+	default:
+		t.Error("quit not closed")
+	}
+}
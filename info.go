@@ -14,12 +14,19 @@
 
 package usb
 
+import "context"
+import "errors"
+import "sort"
 import "strings"
+import "syscall"
 import "io/ioutil"
-import "fmt"
+import "path/filepath"
 
 const SYSPATH = "/sys/bus/usb/devices/"
 
+// DEVFS_PATH is where usbfs device nodes are, as BBB/DDD.
+const DEVFS_PATH = "/dev/bus/usb/"
+
 func atou(s []byte) int {
 	var n int = 0
 	for i := range s {
@@ -31,24 +38,37 @@ func atou(s []byte) int {
 	return n
 }
 
+// readAttr reads a sysfs attribute, "" if it can't be read
+func readAttr(path string) string {
+	b, e := ioutil.ReadFile(path)
+	if e != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
 type DeviceInfo struct {
-	Next   *DeviceInfo
+	Next   *DeviceInfo // Deprecated: only set by DeviceInfoList
 	DevNum int
 	BusNum int
+	Ports  []int // port numbers from the root hub down, nil for root hubs
 	DeviceDescriptor
 	Config  []ConfigInfo
 	syspath string
 	devpath string
+	root    Root
 }
 
 type ConfigInfo struct {
 	ConfigDescriptor
 	Interface []InterfaceInfo
+	Extra     []byte // interface association and other config-level descriptors
 }
 
 type InterfaceInfo struct {
 	InterfaceDescriptor
 	Endpoint []EndpointDescriptor
+	Extra    []byte // class-specific descriptors following the interface
 }
 
 func badDesc(d []byte, kind uint8, size uint8) bool {
@@ -89,7 +109,12 @@ func parseDeviceDesc(d []byte, desc *DeviceDescriptor) []byte {
 }
 
 func parseConfigDesc(d []byte, desc *ConfigDescriptor) []byte {
-	if badDesc(d, DT_CONFIG, DT_CONFIG_SIZE) {
+	return parseConfigKind(d, DT_CONFIG, desc)
+}
+
+// other-speed configurations share the layout
+func parseConfigKind(d []byte, kind uint8, desc *ConfigDescriptor) []byte {
+	if badDesc(d, kind, DT_CONFIG_SIZE) {
 		return nil
 	}
 	desc.Length = d[0]
@@ -103,6 +128,21 @@ func parseConfigDesc(d []byte, desc *ConfigDescriptor) []byte {
 	return d[d[0]:]
 }
 
+func parseQualifierDesc(d []byte, desc *DeviceQualifier) []byte {
+	if badDesc(d, DT_DEVICE_QUALIFIER, DT_DEVICE_QUALIFIER_SIZE) {
+		return nil
+	}
+	desc.Length = d[0]
+	desc.DescriptorType = d[1]
+	desc.UsbVersion = uint16(d[2]) | (uint16(d[3]) << 8)
+	desc.DeviceClass = d[4]
+	desc.DeviceSubClass = d[5]
+	desc.DeviceProtocol = d[6]
+	desc.MaxPacketSize0 = d[7]
+	desc.NumConfigurations = d[8]
+	return d[d[0]:]
+}
+
 func parseInterfaceDesc(d []byte, desc *InterfaceDescriptor) []byte {
 	if badDesc(d, DT_INTERFACE, DT_INTERFACE_SIZE) {
 		return nil
@@ -135,7 +175,7 @@ func parseEndpointDesc(d []byte, desc *EndpointDescriptor) []byte {
 func countDescriptors(d []byte, kind uint8) int {
 	count := 0
 	for len(d) > 1 {
-		if int(d[0]) > len(d) {
+		if d[0] < 2 || int(d[0]) > len(d) {
 			break
 		}
 		if d[1] == kind {
@@ -160,7 +200,7 @@ func skipNonmatching(d []byte, kind uint8) []byte {
 }
 
 func parseConfig(d []byte, ci *ConfigInfo) []byte {
-	if ci.TotalLength < uint16(ci.Length) {
+	if ci.TotalLength < uint16(ci.Length) || int(ci.TotalLength-uint16(ci.Length)) > len(d) {
 		return nil
 	}
 	after := d[ci.TotalLength-uint16(ci.Length):]
@@ -170,20 +210,36 @@ func parseConfig(d []byte, ci *ConfigInfo) []byte {
 	count := countDescriptors(d, DT_INTERFACE)
 
 	ci.Interface = make([]InterfaceInfo, count)
-	for i := 0; i < count; i++ {
-		d = skipNonmatching(d, DT_INTERFACE)
-		d = parseInterfaceDesc(d, &ci.Interface[i].InterfaceDescriptor)
-		if d == nil {
+	var ii *InterfaceInfo
+	n := 0
+	for len(d) >= 2 {
+		if d[0] < 2 || int(d[0]) > len(d) {
 			return nil
 		}
-		ii := &ci.Interface[i]
-		ii.Endpoint = make([]EndpointDescriptor, ii.NumEndpoints)
-		for j := 0; j < int(ii.NumEndpoints); j++ {
-			d = skipNonmatching(d, DT_ENDPOINT)
-			d = parseEndpointDesc(d, &ii.Endpoint[j])
-			if d == nil {
+		switch {
+		case d[1] == DT_INTERFACE && n < count:
+			ii = &ci.Interface[n]
+			n++
+			if parseInterfaceDesc(d, &ii.InterfaceDescriptor) == nil {
+				return nil
+			}
+			ii.Endpoint = make([]EndpointDescriptor, 0, ii.NumEndpoints)
+		case d[1] == DT_ENDPOINT && ii != nil && len(ii.Endpoint) < int(ii.NumEndpoints):
+			var ep EndpointDescriptor
+			if parseEndpointDesc(d, &ep) == nil {
 				return nil
 			}
+			ii.Endpoint = append(ii.Endpoint, ep)
+		case d[1] == DT_INTERFACE_ASSOC || ii == nil:
+			ci.Extra = append(ci.Extra, d[:d[0]]...)
+		default:
+			ii.Extra = append(ii.Extra, d[:d[0]]...)
+		}
+		d = d[d[0]:]
+	}
+	for i := range ci.Interface {
+		if len(ci.Interface[i].Endpoint) != int(ci.Interface[i].NumEndpoints) {
+			return nil
 		}
 	}
 	return after
@@ -210,41 +266,117 @@ func parseDescriptors(d []byte) *DeviceInfo {
 	return di
 }
 
-func DeviceInfoList() *DeviceInfo {
-	var list *DeviceInfo
-	var devnum int
-	var busnum int
-	fi, e := ioutil.ReadDir(SYSPATH)
+// ParseDescriptors decodes a device's descriptors in the layout sysfs
+// and usbfs present them: the device descriptor followed by each
+// configuration in full.  Only the descriptor fields are filled in.
+func ParseDescriptors(d []byte) (*DeviceInfo, error) {
+	di := parseDescriptors(d)
+	if di == nil {
+		return nil, syscall.EINVAL
+	}
+	return di, nil
+}
+
+// Descriptors returns the raw descriptors the kernel cached for the
+// device, as ParseDescriptors takes them.
+func (di *DeviceInfo) Descriptors() ([]byte, error) {
+	return ioutil.ReadFile(di.syspath + "/descriptors")
+}
+
+// SysPath is the device's sysfs directory, for the attributes this
+// package doesn't read itself; it is "" for a device that wasn't listed
+// from sysfs.
+func (di *DeviceInfo) SysPath() string {
+	return di.syspath
+}
+
+// Serial returns the serial number the kernel read from the device at
+// enumeration, "" if it has none.
+func (di *DeviceInfo) Serial() string {
+	return readAttr(di.syspath + "/serial")
+}
+
+// ListDevices enumerates the attached devices from sysfs.  Devices whose
+// attributes can't be read (typically because they were unplugged during
+// the scan) are left out; failing to read the device directory at all is
+// reported as an error.  ListDevicesContext reports the devices left out.
+func ListDevices() ([]*DeviceInfo, error) {
+	list, e := ListDevicesContext(context.Background())
+	var le *ListError
+	if errors.As(e, &le) {
+		return list, nil
+	}
+	return list, e
+}
+
+// readDeviceInfo builds a DeviceInfo from a device's sysfs directory
+func readDeviceInfo(r Root, syspath string) (*DeviceInfo, error) {
+	s, e := ioutil.ReadFile(syspath + "/devnum")
+	if e != nil {
+		return nil, e
+	}
+	devnum := atou(s)
+	s, e = ioutil.ReadFile(syspath + "/busnum")
 	if e != nil {
+		return nil, e
+	}
+	busnum := atou(s)
+	desc, e := ioutil.ReadFile(syspath + "/descriptors")
+	if e != nil {
+		return nil, e
+	}
+	di := parseDescriptors(desc)
+	if di == nil {
+		return nil, syscall.EINVAL
+	}
+	di.BusNum = busnum
+	di.DevNum = devnum
+	di.Ports = parsePorts(filepath.Base(syspath))
+	di.syspath = syspath
+	di.devpath = r.node(busnum, devnum)
+	di.root = r
+	return di, nil
+}
+
+// sysfs names devices "<bus>-<port>.<port>..." and root hubs "usb<bus>"
+func parsePorts(name string) []int {
+	n := strings.IndexByte(name, '-')
+	if n == -1 {
 		return nil
 	}
-	for i := range fi {
-		if strings.IndexByte(fi[i].Name(), ':') != -1 {
-			continue
-		}
-		s, e := ioutil.ReadFile(SYSPATH + fi[i].Name() + "/devnum")
-		if e != nil {
-			continue
-		}
-		devnum = atou(s)
-		s, e = ioutil.ReadFile(SYSPATH + fi[i].Name() + "/busnum")
-		if e != nil {
-			continue
-		}
-		busnum = atou(s)
-		desc, e := ioutil.ReadFile(SYSPATH + fi[i].Name() + "/descriptors")
-		if e != nil {
-			continue
+	var ports []int
+	for _, p := range strings.Split(name[n+1:], ".") {
+		ports = append(ports, atou([]byte(p)))
+	}
+	return ports
+}
+
+// SortByPort orders devices by bus number and then by their port path,
+// so that a given physical socket always lands in the same position.
+func SortByPort(list []*DeviceInfo) {
+	sort.Slice(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if a.BusNum != b.BusNum {
+			return a.BusNum < b.BusNum
 		}
-		di := parseDescriptors(desc)
-		if di != nil {
-			di.BusNum = busnum
-			di.DevNum = devnum
-			di.syspath = SYSPATH + fi[i].Name()
-			di.devpath = fmt.Sprintf("/dev/bus/usb/%03d/%03d", busnum, devnum)
-			di.Next = list
-			list = di
+		for k := 0; k < len(a.Ports) && k < len(b.Ports); k++ {
+			if a.Ports[k] != b.Ports[k] {
+				return a.Ports[k] < b.Ports[k]
+			}
 		}
+		return len(a.Ports) < len(b.Ports)
+	})
+}
+
+// DeviceInfoList returns the attached devices chained through Next.
+//
+// Deprecated: use ListDevices, which reports enumeration errors.
+func DeviceInfoList() *DeviceInfo {
+	var list *DeviceInfo
+	devs, _ := ListDevices()
+	for _, di := range devs {
+		di.Next = list
+		list = di
 	}
 	return list
 }
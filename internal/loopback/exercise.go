@@ -0,0 +1,118 @@
+package loopback
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"syscall"
+
+	"github.com/richardnwinder/usb"
+)
+
+// Endpoints are the addresses the gadget's endpoints were enumerated at.
+type Endpoints struct {
+	BulkOut, BulkIn uint8
+	IntOut, IntIn   uint8
+}
+
+// FindEndpoints reads the gadget's endpoint addresses from its descriptors.
+func FindEndpoints(di *usb.DeviceInfo) (Endpoints, error) {
+	var eps Endpoints
+	if len(di.Config) == 0 || len(di.Config[0].Interface) == 0 {
+		return eps, fmt.Errorf("loopback: no interface")
+	}
+	for _, ep := range di.Config[0].Interface[0].Endpoint {
+		in := ep.EndpointAddress&usb.ENDPOINT_IN != 0
+		switch ep.Attributes & usb.ENDPOINT_XFER_MASK {
+		case usb.ENDPOINT_XFER_BULK:
+			if in {
+				eps.BulkIn = ep.EndpointAddress
+			} else {
+				eps.BulkOut = ep.EndpointAddress
+			}
+		case usb.ENDPOINT_XFER_INT:
+			if in {
+				eps.IntIn = ep.EndpointAddress
+			} else {
+				eps.IntOut = ep.EndpointAddress
+			}
+		}
+	}
+	return eps, nil
+}
+
+// Exercise runs control, bulk and interrupt round trips against an opened
+// loopback gadget, returning the first mismatch or failure.
+func Exercise(dev *usb.Device, di *usb.DeviceInfo) error {
+	eps, e := FindEndpoints(di)
+	if e != nil {
+		return e
+	}
+	bulkIn, bulkOut, intIn, intOut := eps.BulkIn, eps.BulkOut, eps.IntIn, eps.IntOut
+	if e := dev.ClaimInterface(0); e != nil {
+		return fmt.Errorf("loopback: claim: %v", e)
+	}
+	defer dev.ReleaseInterface(0)
+
+	pattern := func(n int, seed byte) []byte {
+		b := make([]byte, n)
+		for i := range b {
+			b[i] = seed + byte(i)
+		}
+		return b
+	}
+
+	// control: store then recall through ep0
+	p := pattern(32, 1)
+	if _, e := dev.ControlTransfer(0x41, REQ_STORE, 0, 0, uint16(len(p)), 1000, p); e != nil {
+		return fmt.Errorf("loopback: control out: %v", e)
+	}
+	buf := make([]byte, 64)
+	n, e := dev.ControlTransfer(0xc1, REQ_RECALL, 0, 0, uint16(len(buf)), 1000, buf)
+	if e != nil {
+		return fmt.Errorf("loopback: control in: %v", e)
+	}
+	if !bytes.Equal(buf[:n], p) {
+		return fmt.Errorf("loopback: control data mismatch")
+	}
+	// unknown requests must stall
+	if _, e := dev.ControlTransfer(0xc1, 0x7f, 0, 0, 1, 1000, buf); !errors.Is(e, syscall.EPIPE) {
+		return fmt.Errorf("loopback: expected stall, got %v", e)
+	}
+
+	// bulk: synchronous round trip
+	p = pattern(512, 7)
+	if _, _, e := dev.BulkTransfer(uint32(bulkOut), uint32(len(p)), 1000, p); e != nil {
+		return fmt.Errorf("loopback: bulk out: %v", e)
+	}
+	buf = make([]byte, 4096)
+	n, data, e := dev.BulkTransfer(uint32(bulkIn), uint32(len(buf)), 1000, buf)
+	if e != nil {
+		return fmt.Errorf("loopback: bulk in: %v", e)
+	}
+	if !bytes.Equal(data[:n], p) {
+		return fmt.Errorf("loopback: bulk data mismatch")
+	}
+
+	// interrupt: through the async URB path
+	done := make(chan *usb.Transfer, 1)
+	p = pattern(64, 13)
+	out := &usb.Transfer{Type: usb.URB_TYPE_INTERRUPT, Endpoint: intOut, Data: p, Done: done}
+	if e := dev.SubmitTransfer(out); e != nil {
+		return fmt.Errorf("loopback: interrupt out: %v", e)
+	}
+	if x := <-done; x.Status != 0 {
+		return fmt.Errorf("loopback: interrupt out status %d", x.Status)
+	}
+	in := &usb.Transfer{Type: usb.URB_TYPE_INTERRUPT, Endpoint: intIn, Data: make([]byte, 64), Done: done}
+	if e := dev.SubmitTransfer(in); e != nil {
+		return fmt.Errorf("loopback: interrupt in: %v", e)
+	}
+	if x := <-done; x.Status != 0 {
+		return fmt.Errorf("loopback: interrupt in status %d", x.Status)
+	}
+	if !bytes.Equal(in.Data[:in.Length], p) {
+		return fmt.Errorf("loopback: interrupt data mismatch")
+	}
+	return nil
+}
@@ -0,0 +1,223 @@
+// Package loopback sets up a loopback USB gadget on dummy_hcd so the usb
+// package can be exercised end to end without any hardware.  It needs root,
+// configfs, and the dummy_hcd, libcomposite and usb_f_fs modules.
+package loopback
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/richardnwinder/usb"
+	"github.com/richardnwinder/usb/gadget"
+)
+
+const (
+	VendorID  = 0x1d6b
+	ProductID = 0x0104
+
+	// vendor requests handled on ep0
+	REQ_STORE  = 0x01 // OUT: remember the data stage
+	REQ_RECALL = 0x02 // IN: return what was stored
+
+	configfs = "/sys/kernel/config/usb_gadget/"
+	name     = "usbloop"
+)
+
+// Gadget is a running loopback device.  Bulk and interrupt data written
+// to an OUT endpoint comes back on the matching IN endpoint, and ep0
+// echoes vendor requests.  dummy_hcd has no isochronous support, so the
+// gadget has no iso endpoints.
+type Gadget struct {
+	dir   string // configfs gadget directory
+	mnt   string // functionfs mount point
+	fs    *gadget.FunctionFS
+	wg    sync.WaitGroup
+	lock  sync.Mutex
+	store []byte
+	bound bool
+	done  bool
+}
+
+func Setup() (*Gadget, error) {
+	for _, m := range []string{"libcomposite", "usb_f_fs", "dummy_hcd"} {
+		exec.Command("modprobe", m).Run()
+	}
+	g := &Gadget{dir: configfs + name}
+	e := g.configure()
+	if e == nil {
+		e = g.start()
+	}
+	if e != nil {
+		g.Teardown()
+		return nil, e
+	}
+	return g, nil
+}
+
+func write(path string, value string) error {
+	return os.WriteFile(path, []byte(value), 0644)
+}
+
+func (g *Gadget) configure() error {
+	steps := []func() error{
+		func() error { return os.MkdirAll(g.dir+"/strings/0x409", 0755) },
+		func() error { return os.MkdirAll(g.dir+"/configs/c.1/strings/0x409", 0755) },
+		func() error { return os.MkdirAll(g.dir+"/functions/ffs."+name, 0755) },
+		func() error { return write(g.dir+"/idVendor", "0x1d6b") },
+		func() error { return write(g.dir+"/idProduct", "0x0104") },
+		func() error { return write(g.dir+"/strings/0x409/manufacturer", "usb") },
+		func() error { return write(g.dir+"/strings/0x409/product", "loopback") },
+		func() error { return write(g.dir+"/strings/0x409/serialnumber", "0") },
+		func() error { return write(g.dir+"/configs/c.1/strings/0x409/configuration", "loop") },
+		func() error {
+			return os.Symlink(g.dir+"/functions/ffs."+name, g.dir+"/configs/c.1/ffs."+name)
+		},
+	}
+	for _, step := range steps {
+		if e := step(); e != nil && !os.IsExist(e) {
+			return e
+		}
+	}
+	var e error
+	if g.mnt, e = os.MkdirTemp("", "ffs"); e != nil {
+		return e
+	}
+	return syscall.Mount(name, g.mnt, "functionfs", 0, "")
+}
+
+// one vendor-class interface with bulk and interrupt pairs, in the order
+// the endpoint files are created: ep1 bulk OUT, ep2 bulk IN, ep3 int OUT,
+// ep4 int IN
+func function() *gadget.Function {
+	f := &gadget.Function{}
+	i := f.Interface(0xff, 0, 0, "loop")
+	i.Endpoint(false, usb.ENDPOINT_XFER_BULK, 0, 0)
+	i.Endpoint(true, usb.ENDPOINT_XFER_BULK, 0, 0)
+	i.Endpoint(false, usb.ENDPOINT_XFER_INT, 64, 4*time.Millisecond)
+	i.Endpoint(true, usb.ENDPOINT_XFER_INT, 64, 4*time.Millisecond)
+	return f
+}
+
+func (g *Gadget) start() error {
+	var e error
+	if g.fs, e = gadget.OpenFunctionFS(g.mnt, function()); e != nil {
+		return e
+	}
+	udc, e := filepath.Glob("/sys/class/udc/dummy_udc.*")
+	if e != nil || len(udc) == 0 {
+		return syscall.ENODEV
+	}
+	if e = write(g.dir+"/UDC", filepath.Base(udc[0])); e != nil {
+		return e
+	}
+	// the endpoint files only wake up from a blocked read once bound, so
+	// don't start serving them before that or Teardown could hang
+	g.bound = true
+	g.wg.Add(3)
+	go g.events()
+	go g.echo(g.fs.Endpoints[0], g.fs.Endpoints[1])
+	go g.echo(g.fs.Endpoints[2], g.fs.Endpoints[3])
+	return nil
+}
+
+func (g *Gadget) closing() bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.done
+}
+
+func (g *Gadget) echo(out *os.File, in *os.File) {
+	defer g.wg.Done()
+	buf := make([]byte, 4096)
+	for {
+		n, e := out.Read(buf)
+		if errors.Is(e, syscall.ESHUTDOWN) && !g.closing() {
+			// host deconfigured us; wait for the next ENABLE
+			continue
+		}
+		if e != nil {
+			return
+		}
+		_, e = in.Write(buf[:n])
+		if e != nil && !errors.Is(e, syscall.ESHUTDOWN) {
+			return
+		}
+	}
+}
+
+func (g *Gadget) events() {
+	defer g.wg.Done()
+	for ev := range g.fs.Events {
+		if ev.Type == gadget.FUNCTIONFS_UNBIND && g.closing() {
+			return
+		}
+		if ev.Type != gadget.FUNCTIONFS_SETUP {
+			continue
+		}
+		req := ev.Setup
+		switch {
+		case req.RequestType == 0x41 && req.Request == REQ_STORE:
+			data, _ := ev.Receive()
+			g.lock.Lock()
+			g.store = data
+			g.lock.Unlock()
+		case req.RequestType == 0xc1 && req.Request == REQ_RECALL:
+			g.lock.Lock()
+			data := g.store
+			g.lock.Unlock()
+			ev.Reply(data)
+		default:
+			ev.Stall()
+		}
+	}
+}
+
+// Open waits for the host side of the gadget to be enumerated and opens it.
+func (g *Gadget) Open(ctx context.Context) (*usb.Device, *usb.DeviceInfo, error) {
+	di, e := usb.WaitForDevice(ctx, usb.MatchVidPid(VendorID, ProductID))
+	if e != nil {
+		return nil, nil, e
+	}
+	// the node may not be accessible the instant the device is announced
+	for {
+		dev, e := usb.Open(di)
+		if e == nil {
+			return dev, di, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, nil, e
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+// Teardown unbinds the gadget and removes everything Setup created.
+func (g *Gadget) Teardown() {
+	g.lock.Lock()
+	g.done = true
+	g.lock.Unlock()
+	if g.bound {
+		write(g.dir+"/UDC", "\n")
+		g.wg.Wait()
+	}
+	if g.fs != nil {
+		g.fs.Close()
+	}
+	if g.mnt != "" {
+		syscall.Unmount(g.mnt, 0)
+		os.Remove(g.mnt)
+	}
+	os.Remove(g.dir + "/configs/c.1/ffs." + name)
+	os.Remove(g.dir + "/configs/c.1/strings/0x409")
+	os.Remove(g.dir + "/configs/c.1")
+	os.Remove(g.dir + "/functions/ffs." + name)
+	os.Remove(g.dir + "/strings/0x409")
+	os.Remove(g.dir)
+}
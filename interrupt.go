@@ -0,0 +1,189 @@
+package usb
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// PollOptions tunes a PollReader.
+type PollOptions struct {
+	// Interval is the minimum time between polls.  Zero polls as often as
+	// the host controller schedules the endpoint, which is bInterval.
+	Interval time.Duration
+	// Coalesce drops reports identical to the one before, which suits
+	// devices that repeat their state every interval.
+	Coalesce bool
+}
+
+// PollReader keeps an interrupt IN endpoint polled and delivers each report
+// on C.  Transient errors are retried: the URB is resubmitted after babble
+// and protocol errors, and a stalled endpoint is cleared first.  C is
+// closed when the reader stops, after which Err says why.
+type PollReader struct {
+	C <-chan []byte
+
+	dev     *Device
+	xfer    *Transfer
+	opts    PollOptions
+	c       chan []byte
+	done    chan *Transfer
+	pause   chan bool
+	quit    chan struct{}
+	exit    chan struct{}
+	once    sync.Once // closes quit
+	err     error
+	retries int // failed URBs since the last report
+}
+
+// NewPollReader starts polling the interrupt IN endpoint ep.
+func (u *Device) NewPollReader(ep EndpointDescriptor, opts PollOptions) (*PollReader, error) {
+	if ep.EndpointAddress&ENDPOINT_IN == 0 || ep.Attributes&ENDPOINT_XFER_MASK != ENDPOINT_XFER_INT {
+		return nil, syscall.EINVAL
+	}
+	// high-bandwidth endpoints move up to three packets per interval
+	size := int(ep.MaxPacketSize&0x7ff) * (1 + int(ep.MaxPacketSize>>11&3))
+	if size == 0 {
+		return nil, syscall.EINVAL
+	}
+	r := &PollReader{
+		dev:   u,
+		opts:  opts,
+		c:     make(chan []byte),
+		done:  make(chan *Transfer, 1),
+		pause: make(chan bool),
+		quit:  make(chan struct{}),
+		exit:  make(chan struct{}),
+	}
+	r.C = r.c
+	r.xfer = &Transfer{
+		Type:     URB_TYPE_INTERRUPT,
+		Endpoint: ep.EndpointAddress,
+		Data:     make([]byte, size),
+		Done:     r.done,
+	}
+	go r.run()
+	return r, nil
+}
+
+// retryable reports whether polling should carry on after a failed URB
+func (r *PollReader) retryable(xfer *Transfer) bool {
+	e := xfer.Err()
+	switch {
+	case errors.Is(e, syscall.EPIPE):
+		return r.dev.ClearHalt(xfer.Endpoint) == nil
+	case errors.Is(e, syscall.EOVERFLOW), errors.Is(e, syscall.EPROTO),
+		errors.Is(e, syscall.EILSEQ), errors.Is(e, syscall.ETIME):
+		return true
+	}
+	return false
+}
+
+func (r *PollReader) run() {
+	defer close(r.exit)
+	defer close(r.c)
+	var last []byte
+	var next time.Time
+	running := true
+	inflight := false
+	cancelled := false // the URB in flight was cancelled by a pause
+	for {
+		if running && !inflight {
+			if d := time.Until(next); d > 0 {
+				t := time.NewTimer(d)
+				select {
+				case <-t.C:
+				case p := <-r.pause:
+					t.Stop()
+					running = !p
+					continue
+				case <-r.quit:
+					t.Stop()
+					return
+				}
+			}
+			next = time.Now().Add(r.opts.Interval)
+			r.xfer.retries = r.retries
+			if e := r.dev.SubmitTransfer(r.xfer); e != nil {
+				r.err = e
+				return
+			}
+			inflight = true
+		}
+		select {
+		case xfer := <-r.done:
+			inflight = false
+			wasCancelled := cancelled
+			cancelled = false
+			if xfer.Status != 0 {
+				// a pause cancels the URB, which is not an error, even
+				// if polling was resumed before it came back
+				e := xfer.Err()
+				if wasCancelled && (errors.Is(e, syscall.ENOENT) || errors.Is(e, syscall.ECONNRESET)) {
+					continue
+				}
+				if !r.retryable(xfer) {
+					r.err = xfer.Err()
+					return
+				}
+				r.retries++
+				continue
+			}
+			r.retries = 0
+			report := xfer.Data[:xfer.Length]
+			if r.opts.Coalesce && last != nil && bytes.Equal(report, last) {
+				continue
+			}
+			last = append([]byte(nil), report...)
+			select {
+			case r.c <- append([]byte(nil), report...):
+			case p := <-r.pause:
+				running = !p
+			case <-r.quit:
+				return
+			}
+		case p := <-r.pause:
+			running = !p
+			if p && inflight && !cancelled {
+				cancelled = r.dev.CancelTransfer(r.xfer) == nil
+			}
+		case <-r.quit:
+			if inflight {
+				r.dev.CancelTransfer(r.xfer)
+				<-r.done
+			}
+			return
+		}
+	}
+}
+
+// Pause stops polling until Resume.  Reports already read by the device
+// but not yet handed over may still be delivered.
+func (r *PollReader) Pause() {
+	select {
+	case r.pause <- true:
+	case <-r.exit:
+	}
+}
+
+func (r *PollReader) Resume() {
+	select {
+	case r.pause <- false:
+	case <-r.exit:
+	}
+}
+
+// Close stops polling and waits for the endpoint to be idle.  It may be
+// called more than once.
+func (r *PollReader) Close() {
+	r.once.Do(func() { close(r.quit) })
+	<-r.exit
+}
+
+// Err returns the error that stopped the reader, or nil if it was closed.
+// It is only meaningful once C has been closed.
+func (r *PollReader) Err() error {
+	return r.err
+}
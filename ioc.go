@@ -0,0 +1,12 @@
+//go:build !(mips || mipsle || mips64 || mips64le || ppc64 || ppc64le)
+
+package usb
+
+// asm-generic ioctl number layout
+const (
+	_IOC_NONE      = 0
+	_IOC_WRITE     = 1
+	_IOC_READ      = 2
+	_IOC_SIZESHIFT = 16
+	_IOC_DIRSHIFT  = 30
+)
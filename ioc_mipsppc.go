@@ -0,0 +1,12 @@
+//go:build mips || mipsle || mips64 || mips64le || ppc64 || ppc64le
+
+package usb
+
+// MIPS and PowerPC use a 13-bit size field and 3 direction bits
+const (
+	_IOC_NONE      = 1
+	_IOC_READ      = 2
+	_IOC_WRITE     = 4
+	_IOC_SIZESHIFT = 16
+	_IOC_DIRSHIFT  = 29
+)
@@ -0,0 +1,102 @@
+package usb
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// ISO_MAX_PACKETS is the most packets usbfs takes in one isochronous
+// transfer.
+const ISO_MAX_PACKETS = 128
+
+// IsoPacket is one packet of an isochronous transfer.
+type IsoPacket struct {
+	Length       uint32 // bytes to send, or room to receive
+	ActualLength uint32 // bytes sent or received
+	Status       int32  // 0 or a negative errno, as Transfer.Status
+}
+
+// Err decodes the packet's Status, as Transfer.Err does.
+func (p *IsoPacket) Err() error {
+	return StatusError(p.Status)
+}
+
+type usbdevfs_iso_packet_desc struct {
+	length        uint32
+	actual_length uint32
+	status        int32
+}
+
+// isoURB is a URB with room for the most packet descriptors usbfs takes
+// right after it, which is where the kernel reads and writes them
+type isoURB struct {
+	urb  usbdevfs_urb
+	desc [ISO_MAX_PACKETS]usbdevfs_iso_packet_desc
+}
+
+// PacketData returns what packet i of a completed isochronous IN
+// transfer received.  The kernel leaves each packet where the Lengths
+// before it put it in Data, so a short packet is followed by a gap.
+func (xfer *Transfer) PacketData(i int) []byte {
+	at := 0
+	for j := 0; j < i; j++ {
+		at += int(xfer.Packets[j].Length)
+	}
+	return xfer.Data[at : at+int(xfer.Packets[i].ActualLength)]
+}
+
+// getURB takes a URB for xfer from the slab and describes the transfer
+// in it, with the packet descriptors after it for an isochronous one
+func (u *Device) getURB(xfer *Transfer) error {
+	var urb *usbdevfs_urb
+	if xfer.Type == URB_TYPE_ISO {
+		if len(xfer.Packets) < 1 || len(xfer.Packets) > ISO_MAX_PACKETS {
+			return syscall.EINVAL
+		}
+		var total int64
+		for i := range xfer.Packets {
+			total += int64(xfer.Packets[i].Length)
+		}
+		// the kernel takes the packet lengths over the buffer's
+		if total > int64(len(xfer.Data)) {
+			return syscall.EINVAL
+		}
+		xfer.iso = u.urbs.getISO()
+		for i := range xfer.Packets {
+			xfer.iso.desc[i].length = xfer.Packets[i].Length
+		}
+		urb = &xfer.iso.urb
+		urb.number_of_packets = int32(len(xfer.Packets))
+		if xfer.Flags&URB_FLAG_ISO_ASAP == 0 {
+			urb.start_frame = xfer.StartFrame
+		}
+	} else {
+		urb = u.urbs.get()
+	}
+	urb.urbtype = xfer.Type
+	urb.endpoint = xfer.Endpoint
+	urb.flags = uint32(xfer.Flags)
+	urb.buffer_length = int32(len(xfer.Data))
+	if len(xfer.Data) > 0 {
+		urb.buffer = uintptr(unsafe.Pointer(&xfer.Data[0]))
+	}
+	xfer.urb = urb
+	return nil
+}
+
+// putURB gives xfer's URB back to the slab, first copying out what the
+// kernel wrote into an isochronous transfer's packet descriptors
+func (u *Device) putURB(xfer *Transfer) {
+	if xfer.iso != nil {
+		for i := range xfer.Packets {
+			d := &xfer.iso.desc[i]
+			xfer.Packets[i].ActualLength = d.actual_length
+			xfer.Packets[i].Status = d.status
+		}
+		u.urbs.putISO(xfer.iso)
+		xfer.iso = nil
+	} else {
+		u.urbs.put(xfer.urb)
+	}
+	xfer.urb = nil
+}
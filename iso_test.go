@@ -0,0 +1,191 @@
+package usb
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+	"unsafe"
+)
+
+// kernelDesc is the packet descriptor array as the kernel finds it, right
+// after the URB it was given
+func kernelDesc(urb *usbdevfs_urb) []usbdevfs_iso_packet_desc {
+	p := (*usbdevfs_iso_packet_desc)(unsafe.Add(unsafe.Pointer(urb), sizeof_usbdevfs_urb))
+	return unsafe.Slice(p, urb.number_of_packets)
+}
+
+// TestISOURB checks the URB an isochronous transfer is submitted with,
+// and that completing it hands the packet results back
+func TestISOURB(t *testing.T) {
+	u := &Device{}
+	xfer := &Transfer{
+		Type:     URB_TYPE_ISO,
+		Endpoint: 0x83,
+		Flags:    URB_FLAG_ISO_ASAP,
+		Data:     make([]byte, 40),
+		Packets:  []IsoPacket{{Length: 8}, {Length: 16}, {Length: 8, ActualLength: 99, Status: 1}},
+	}
+	if e := u.getURB(xfer); e != nil {
+		t.Fatal(e)
+	}
+	urb := xfer.urb
+	if urb.urbtype != URB_TYPE_ISO || urb.endpoint != 0x83 || urb.flags != uint32(URB_FLAG_ISO_ASAP) ||
+		urb.buffer != uintptr(unsafe.Pointer(&xfer.Data[0])) || urb.buffer_length != 40 {
+		t.Errorf("URB %+v", *urb)
+	}
+	if urb.number_of_packets != 3 {
+		t.Fatalf("number_of_packets %d, want 3", urb.number_of_packets)
+	}
+	desc := kernelDesc(urb)
+	for i, want := range []uint32{8, 16, 8} {
+		if desc[i] != (usbdevfs_iso_packet_desc{length: want}) {
+			t.Errorf("packet %d descriptor %+v, want length %d", i, desc[i], want)
+		}
+	}
+
+	// the kernel completes the URB
+	desc[0] = usbdevfs_iso_packet_desc{8, 8, 0}
+	desc[1] = usbdevfs_iso_packet_desc{16, 5, 0}
+	desc[2] = usbdevfs_iso_packet_desc{8, 0, -int32(syscall.EXDEV)}
+	copy(xfer.Data, "abcdefgh")
+	copy(xfer.Data[8:], "ijklm")
+	u.putURB(xfer)
+	want := []IsoPacket{{8, 8, 0}, {16, 5, 0}, {8, 0, -int32(syscall.EXDEV)}}
+	for i := range want {
+		if xfer.Packets[i] != want[i] {
+			t.Errorf("packet %d %+v, want %+v", i, xfer.Packets[i], want[i])
+		}
+	}
+	if xfer.urb != nil || xfer.iso != nil {
+		t.Error("URB kept after it was put back")
+	}
+	if !errors.Is(xfer.Packets[2].Err(), ErrPartial) {
+		t.Errorf("packet 2 error %v, want %v", xfer.Packets[2].Err(), ErrPartial)
+	}
+	for i, want := range []string{"abcdefgh", "ijklm", ""} {
+		if got := string(xfer.PacketData(i)); got != want {
+			t.Errorf("packet %d data %q, want %q", i, got, want)
+		}
+	}
+
+	// a URB comes back from the slab cleared
+	again := &Transfer{Type: URB_TYPE_ISO, Data: make([]byte, 1), Packets: []IsoPacket{{Length: 1}}}
+	if e := u.getURB(again); e != nil {
+		t.Fatal(e)
+	}
+	if d := [3]usbdevfs_iso_packet_desc(again.iso.desc[1:4]); d != [3]usbdevfs_iso_packet_desc{} {
+		t.Errorf("reused URB holds old descriptors %+v", d)
+	}
+	if again.iso.desc[0] != (usbdevfs_iso_packet_desc{length: 1}) {
+		t.Errorf("reused URB descriptor %+v", again.iso.desc[0])
+	}
+}
+
+func TestISOURBInvalid(t *testing.T) {
+	u := &Device{}
+	for _, c := range []struct {
+		name    string
+		data    int
+		packets []IsoPacket
+	}{
+		{"no packets", 8, nil},
+		{"too many packets", 8 * (ISO_MAX_PACKETS + 1), make([]IsoPacket, ISO_MAX_PACKETS+1)},
+		{"longer than Data", 15, []IsoPacket{{Length: 8}, {Length: 8}}},
+	} {
+		xfer := &Transfer{Type: URB_TYPE_ISO, Data: make([]byte, c.data), Packets: c.packets}
+		if e := u.getURB(xfer); e != syscall.EINVAL {
+			t.Errorf("%s: %v, want EINVAL", c.name, e)
+		}
+		if xfer.urb != nil || xfer.iso != nil {
+			t.Errorf("%s: URB taken for a transfer refused", c.name)
+		}
+	}
+	if u.urbs.isoSize != 0 || u.urbs.size != 0 {
+		t.Errorf("%d URBs allocated for transfers refused", u.urbs.isoSize+u.urbs.size)
+	}
+}
+
+// TestISOURBAllocs checks that an isochronous transfer reused from
+// completion to resubmission, as a stream's ring of them is, allocates
+// nothing for its URB and packet descriptors
+func TestISOURBAllocs(t *testing.T) {
+	u := &Device{}
+	xfers := make([]*Transfer, 4)
+	for i := range xfers {
+		xfers[i] = &Transfer{Type: URB_TYPE_ISO, Data: make([]byte, 32*192), Packets: make([]IsoPacket, 32)}
+		for j := range xfers[i].Packets {
+			xfers[i].Packets[j].Length = 192
+		}
+	}
+	cycle := func() {
+		for _, x := range xfers {
+			if e := u.getURB(x); e != nil {
+				t.Fatal(e)
+			}
+		}
+		for _, x := range xfers {
+			u.putURB(x)
+		}
+	}
+	cycle()
+	if n := testing.AllocsPerRun(100, cycle); n != 0 {
+		t.Errorf("%v allocations per cycle of %d transfers, want 0", n, len(xfers))
+	}
+	if u.urbs.isoSize != isoSlabSize || u.urbs.size != 0 {
+		t.Errorf("%d isochronous and %d other URBs allocated, want %d and 0", u.urbs.isoSize, u.urbs.size, isoSlabSize)
+	}
+}
+
+// inFlight does what SubmitTransfer does short of the ioctl, so that the
+// test can complete the transfer as the reaper would
+func inFlight(t *testing.T, u *Device, xfer *Transfer) {
+	t.Helper()
+	if e := u.getURB(xfer); e != nil {
+		t.Fatal(e)
+	}
+	xfer.token = u.token.Add(1)
+	xfer.urb.usercontext = xfer.token
+	q := u.queue(xfer.Endpoint)
+	if q.active == nil {
+		q.active = make(map[uintptr]*Transfer)
+	}
+	q.active[xfer.token] = xfer
+	u.nactive.Add(1)
+}
+
+// TestISOStartFrame checks that a transfer scheduled for a frame asks
+// the kernel for it, and that completion reports the frame a transfer
+// began in
+func TestISOStartFrame(t *testing.T) {
+	u := &Device{}
+	done := make(chan *Transfer, 1)
+	for _, c := range []struct {
+		name  string
+		flags URBFlag
+		frame int32 // submitted with
+		want  int32 // in the URB
+	}{
+		{"scheduled", 0, 1500, 1500},
+		{"asap", URB_FLAG_ISO_ASAP, 1500, 0},
+	} {
+		xfer := &Transfer{Type: URB_TYPE_ISO, Endpoint: 0x81, Flags: c.flags, StartFrame: c.frame,
+			Data: make([]byte, 16), Packets: []IsoPacket{{Length: 8}, {Length: 8}}, Done: done}
+		inFlight(t, u, xfer)
+		urb := xfer.urb
+		if urb.start_frame != c.want {
+			t.Errorf("%s: start_frame %d, want %d", c.name, urb.start_frame, c.want)
+		}
+		urb.start_frame = 1502
+		urb.actual_length = 12
+		desc := kernelDesc(urb)
+		desc[0].actual_length, desc[1].actual_length = 8, 4
+		u.complete(urb)
+		<-done
+		if xfer.StartFrame != 1502 || xfer.Length != 12 {
+			t.Errorf("%s: completed in frame %d with %d bytes, want 1502 and 12", c.name, xfer.StartFrame, xfer.Length)
+		}
+		if xfer.Packets[0].ActualLength != 8 || xfer.Packets[1].ActualLength != 4 {
+			t.Errorf("%s: packets %+v", c.name, xfer.Packets)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package usb_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/richardnwinder/usb"
+	"github.com/richardnwinder/usb/internal/loopback"
+)
+
+// The tests that move data run against the loopback gadget on dummy_hcd,
+// as usbselftest does.  Setting it up loads modules and creates a gadget
+// in configfs, so they run only when USB_LOOPBACK=1 asks for that, and
+// are skipped where it can't be done: it needs root and the dummy_hcd,
+// libcomposite and usb_f_fs modules.  It is set up the first time a test
+// asks for it and torn down after the last.
+var gadget struct {
+	once sync.Once
+	g    *loopback.Gadget
+	err  error
+}
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if gadget.g != nil {
+		gadget.g.Teardown()
+	}
+	os.Exit(code)
+}
+
+// openLoopback opens the loopback gadget with its interface claimed, and
+// closes it when tb ends, or skips tb if there is no gadget
+func openLoopback(tb testing.TB) (*usb.Device, *usb.DeviceInfo, loopback.Endpoints) {
+	tb.Helper()
+	if testing.Short() {
+		tb.Skip("loopback gadget: skipped in short mode")
+	}
+	if os.Getenv("USB_LOOPBACK") != "1" {
+		tb.Skip("loopback gadget: set USB_LOOPBACK=1 to run")
+	}
+	gadget.once.Do(func() {
+		if os.Geteuid() != 0 {
+			gadget.err = errors.New("needs root")
+			return
+		}
+		gadget.g, gadget.err = loopback.Setup()
+	})
+	if gadget.err != nil {
+		tb.Skip("loopback gadget:", gadget.err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	dev, di, e := gadget.g.Open(ctx)
+	if e != nil {
+		tb.Fatal(e)
+	}
+	tb.Cleanup(dev.Close)
+	eps, e := loopback.FindEndpoints(di)
+	if e != nil {
+		tb.Fatal(e)
+	}
+	if e := dev.ClaimInterface(0); e != nil {
+		tb.Fatal(e)
+	}
+	drain(dev, eps)
+	return dev, di, eps
+}
+
+// drain reads and drops whatever an earlier test left the gadget to echo
+func drain(dev *usb.Device, eps loopback.Endpoints) {
+	buf := make([]byte, 4096)
+	for _, ep := range []uint8{eps.BulkIn, eps.IntIn} {
+		for {
+			if _, _, e := dev.BulkTransfer(uint32(ep), uint32(len(buf)), 50, buf); e != nil {
+				break
+			}
+		}
+	}
+}
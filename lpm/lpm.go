@@ -0,0 +1,157 @@
+// Package lpm reads and sets link power management: the SuperSpeed
+// U1 and U2 link states and latency tolerance messaging, USB 2.0 L1, and
+// what the kernel permits and enables of them through sysfs.
+package lpm
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+
+	"github.com/richardnwinder/usb"
+)
+
+// ExitLatency is the SET_SEL data: the system and device exit latencies
+// for the U1 and U2 link states, in microseconds.
+type ExitLatency struct {
+	U1SEL uint8
+	U1PEL uint8
+	U2SEL uint16
+	U2PEL uint16
+}
+
+// SetSEL tells a SuperSpeed device the exit latencies the host calculated
+// for its path, which it uses to decide when entering U1 or U2 pays off.
+func SetSEL(u usb.ControlTransferer, l ExitLatency) error {
+	b := []byte{l.U1SEL, l.U1PEL, byte(l.U2SEL), byte(l.U2SEL >> 8), byte(l.U2PEL), byte(l.U2PEL >> 8)}
+	_, e := u.ControlTransfer(usb.REQTYPE_STANDARD|usb.REQTYPE_DEVICE, usb.REQ_SET_SEL, 0, 0, uint16(len(b)), 1000, b)
+	return e
+}
+
+// SetIsochDelay tells a SuperSpeed device the delay, in nanoseconds, from
+// the host sending a packet to the device receiving it.
+func SetIsochDelay(u usb.ControlTransferer, ns uint16) error {
+	if ns > 40000 {
+		return syscall.EINVAL
+	}
+	_, e := u.ControlTransfer(usb.REQTYPE_STANDARD|usb.REQTYPE_DEVICE, usb.REQ_SET_ISOCH_DELAY, ns, 0, 0, 1000, nil)
+	return e
+}
+
+// device feature selectors for SuperSpeed link power management
+const (
+	FEATURE_U1_ENABLE  = 48
+	FEATURE_U2_ENABLE  = 49
+	FEATURE_LTM_ENABLE = 50
+)
+
+func feature(u usb.ControlTransferer, set bool, sel uint16) error {
+	req := uint8(usb.REQ_CLEAR_FEATURE)
+	if set {
+		req = usb.REQ_SET_FEATURE
+	}
+	_, e := u.ControlTransfer(usb.REQTYPE_STANDARD|usb.REQTYPE_DEVICE, req, sel, 0, 0, 1000, nil)
+	return e
+}
+
+// SetU1 allows or forbids the device initiating U1 entry.  The kernel
+// sets these itself when it enables LPM, so a change here lasts until the
+// next reset or resume; use SetPermit for a lasting one.
+func SetU1(u usb.ControlTransferer, enable bool) error {
+	return feature(u, enable, FEATURE_U1_ENABLE)
+}
+
+func SetU2(u usb.ControlTransferer, enable bool) error {
+	return feature(u, enable, FEATURE_U2_ENABLE)
+}
+
+// SetLTM turns latency tolerance messaging on or off.
+func SetLTM(u usb.ControlTransferer, enable bool) error {
+	return feature(u, enable, FEATURE_LTM_ENABLE)
+}
+
+// State is the kernel's view of a device's link power management.
+// Fields the kernel doesn't provide for the device's speed are false.
+type State struct {
+	USB2LPM  bool // USB 2.0 L1, power/usb2_hardware_lpm
+	U1       bool // power/usb3_hardware_lpm_u1
+	U2       bool // power/usb3_hardware_lpm_u2
+	PermitU1 bool // the port's usb3_lpm_permit
+	PermitU2 bool
+}
+
+func readAttr(path string) string {
+	b, e := os.ReadFile(path)
+	if e != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// Read reads the device's link power management state from sysfs.
+func Read(di *usb.DeviceInfo) State {
+	var l State
+	dir := di.SysPath()
+	l.USB2LPM = readAttr(dir+"/power/usb2_hardware_lpm") == "y"
+	l.U1 = readAttr(dir+"/power/usb3_hardware_lpm_u1") == "enabled"
+	l.U2 = readAttr(dir+"/power/usb3_hardware_lpm_u2") == "enabled"
+	switch readAttr(dir + "/port/usb3_lpm_permit") {
+	case "u1_u2":
+		l.PermitU1, l.PermitU2 = true, true
+	case "u1":
+		l.PermitU1 = true
+	case "u2":
+		l.PermitU2 = true
+	}
+	return l
+}
+
+// SetPermit sets which U states the kernel may enable on the port the
+// SuperSpeed device is attached to.  It needs root.
+func SetPermit(di *usb.DeviceInfo, u1 bool, u2 bool) error {
+	if di.SysPath() == "" {
+		return syscall.ENODEV
+	}
+	v := "0"
+	switch {
+	case u1 && u2:
+		v = "u1_u2"
+	case u1:
+		v = "u1"
+	case u2:
+		v = "u2"
+	}
+	return os.WriteFile(di.SysPath()+"/port/usb3_lpm_permit", []byte(v), 0)
+}
+
+// SetUSB2 turns USB 2.0 hardware LPM (L1) on or off for the device.
+func SetUSB2(di *usb.DeviceInfo, enable bool) error {
+	if di.SysPath() == "" {
+		return syscall.ENODEV
+	}
+	v := "n"
+	if enable {
+		v = "y"
+	}
+	return os.WriteFile(di.SysPath()+"/power/usb2_hardware_lpm", []byte(v), 0)
+}
+
+const usbcoreQuirks = "/sys/module/usbcore/parameters/quirks"
+
+// AddNoLPMQuirk tells usbcore never to enable LPM for vid:pid.  It takes
+// effect the next time such a device is enumerated and lasts until
+// reboot.
+func AddNoLPMQuirk(vid uint16, pid uint16) error {
+	q := fmt.Sprintf("%04x:%04x:k", vid, pid)
+	cur := readAttr(usbcoreQuirks)
+	for _, e := range strings.Split(cur, ",") {
+		if e == q {
+			return nil
+		}
+	}
+	if cur != "" {
+		q = cur + "," + q
+	}
+	return os.WriteFile(usbcoreQuirks, []byte(q), 0)
+}
@@ -0,0 +1,279 @@
+// Package mchp drives the Microchip USB HID Bootloader found on PIC18,
+// PIC24 and PIC32 parts.  Package flip drives the Atmel HID bootloaders.
+package mchp
+
+import (
+	"bytes"
+	"fmt"
+	"syscall"
+
+	"github.com/richardnwinder/usb/firmware"
+	"github.com/richardnwinder/usb/hid"
+)
+
+const (
+	VendorID  = 0x04d8
+	ProductID = 0x003c
+
+	// commands
+	QUERY_DEVICE     = 0x02
+	UNLOCK_CONFIG    = 0x03
+	ERASE_DEVICE     = 0x04
+	PROGRAM_DEVICE   = 0x05
+	PROGRAM_COMPLETE = 0x06
+	GET_DATA         = 0x07
+	RESET_DEVICE     = 0x08
+	SIGN_FLASH       = 0x09
+
+	// device families
+	FAMILY_PIC18 = 0x01
+	FAMILY_PIC24 = 0x02
+	FAMILY_PIC32 = 0x03
+	FAMILY_PIC16 = 0x04
+
+	// memory region types
+	REGION_PROGRAM = 0x01
+	REGION_EEPROM  = 0x02
+	REGION_CONFIG  = 0x03
+	REGION_END     = 0xff
+
+	packetSize = 64
+)
+
+type Region struct {
+	Type    uint8
+	Address uint32 // in device address units
+	Size    uint32 // in device address units
+}
+
+type Device struct {
+	h          *hid.Device
+	Family     uint8
+	PacketData int // payload bytes per PROGRAM_DEVICE or GET_DATA packet
+	Regions    []Region
+	Timeout    uint32 // ms, per packet
+}
+
+// Open queries the bootloader behind h for its memory map.
+func Open(h *hid.Device) (*Device, error) {
+	d := &Device{h: h, Timeout: 5000}
+	if e := d.query(); e != nil {
+		return nil, e
+	}
+	return d, nil
+}
+
+func (d *Device) command(cmd []byte) error {
+	p := make([]byte, packetSize)
+	copy(p, cmd)
+	_, e := d.h.Write(p, d.Timeout)
+	return e
+}
+
+func (d *Device) reply(cmd uint8, p []byte, timeout uint32) error {
+	for {
+		n, e := d.h.Read(p, timeout)
+		if e != nil {
+			return e
+		}
+		if n == packetSize && p[0] == cmd {
+			return nil
+		}
+	}
+}
+
+func (d *Device) query() error {
+	if e := d.command([]byte{QUERY_DEVICE}); e != nil {
+		return e
+	}
+	p := make([]byte, packetSize)
+	if e := d.reply(QUERY_DEVICE, p, d.Timeout); e != nil {
+		return e
+	}
+	d.PacketData = int(p[1])
+	d.Family = p[2]
+	if d.PacketData == 0 || d.PacketData > packetSize-6 {
+		return syscall.EPROTO
+	}
+	d.Regions = d.Regions[:0]
+	for r := p[3:]; len(r) >= 9 && r[0] != REGION_END; r = r[9:] {
+		d.Regions = append(d.Regions, Region{r[0], le32(r[1:]), le32(r[5:])})
+	}
+	return nil
+}
+
+func le32(b []byte) uint32 {
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24
+}
+
+// BytesPerAddress is how many image bytes one device address holds: PIC16
+// and PIC24 flash is word addressed.
+func (d *Device) BytesPerAddress() int {
+	if d.Family == FAMILY_PIC16 || d.Family == FAMILY_PIC24 {
+		return 2
+	}
+	return 1
+}
+
+// Unlock allows (or forbids again) programming the configuration words.
+func (d *Device) Unlock(unlock bool) error {
+	b := byte(1)
+	if unlock {
+		b = 0
+	}
+	return d.command([]byte{UNLOCK_CONFIG, b})
+}
+
+// Erase erases all program memory.  The bootloader answers nothing until
+// it is done, which is detected by querying it again.
+func (d *Device) Erase() error {
+	if e := d.command([]byte{ERASE_DEVICE}); e != nil {
+		return e
+	}
+	return d.query()
+}
+
+// Write programs data at device address addr.
+func (d *Device) Write(addr uint32, data []byte) error {
+	return d.write(addr, data, nil)
+}
+
+func (d *Device) write(addr uint32, data []byte, sent func(n int)) error {
+	bpa := uint32(d.BytesPerAddress())
+	for len(data) > 0 {
+		n := min(len(data), d.PacketData)
+		// the payload is right justified in the packet
+		p := make([]byte, packetSize)
+		p[0] = PROGRAM_DEVICE
+		p[1], p[2], p[3], p[4] = byte(addr), byte(addr>>8), byte(addr>>16), byte(addr>>24)
+		p[5] = byte(n)
+		copy(p[packetSize-n:], data[:n])
+		if _, e := d.h.Write(p, d.Timeout); e != nil {
+			return e
+		}
+		addr += uint32(n) / bpa
+		data = data[n:]
+		if sent != nil {
+			sent(n)
+		}
+	}
+	// flush the partly filled write buffer
+	return d.command([]byte{PROGRAM_COMPLETE, byte(addr), byte(addr >> 8), byte(addr >> 16), byte(addr >> 24), 0})
+}
+
+// Read reads len(buf) bytes from device address addr.
+func (d *Device) Read(addr uint32, buf []byte) error {
+	bpa := uint32(d.BytesPerAddress())
+	p := make([]byte, packetSize)
+	for len(buf) > 0 {
+		n := min(len(buf), d.PacketData)
+		e := d.command([]byte{GET_DATA, byte(addr), byte(addr >> 8), byte(addr >> 16), byte(addr >> 24), byte(n)})
+		if e != nil {
+			return e
+		}
+		if e := d.reply(GET_DATA, p, d.Timeout); e != nil {
+			return e
+		}
+		if int(p[5]) != n || le32(p[1:]) != addr {
+			return syscall.EPROTO
+		}
+		copy(buf, p[packetSize-n:])
+		addr += uint32(n) / bpa
+		buf = buf[n:]
+	}
+	return nil
+}
+
+// Reset restarts the device into the application.
+func (d *Device) Reset() error {
+	return d.command([]byte{RESET_DEVICE})
+}
+
+// Sign marks the flashed image valid, for bootloaders that refuse to run
+// an application until it has been verified.
+func (d *Device) Sign() error {
+	if e := d.command([]byte{SIGN_FLASH}); e != nil {
+		return e
+	}
+	return d.query()
+}
+
+// clip restricts segments, addressed in image bytes as in a HEX file, to
+// the program memory regions and converts them to device addresses
+func (d *Device) clip(segs []firmware.Segment) []firmware.Segment {
+	bpa := uint64(d.BytesPerAddress())
+	var out []firmware.Segment
+	for _, r := range d.Regions {
+		if r.Type != REGION_PROGRAM {
+			continue
+		}
+		lo, hi := uint64(r.Address)*bpa, uint64(r.Address+r.Size)*bpa
+		for _, s := range segs {
+			a, b := uint64(s.Address), uint64(s.Address)+uint64(len(s.Data))
+			a, b = max(a, lo), min(b, hi)
+			if a < b {
+				out = append(out, firmware.Segment{
+					Address: uint32(a / bpa),
+					Data:    s.Data[a-uint64(s.Address) : b-uint64(s.Address)],
+				})
+			}
+		}
+	}
+	return out
+}
+
+// Program erases the device, writes the program memory parts of segs and
+// reads them back.  Segment addresses are byte addresses as found in the
+// HEX file.  Configuration words and EEPROM are left alone; use Unlock and
+// Write for those.  The erase is whole-chip, so a resumed update skips it
+// along with the first opts.Resume bytes.  The device is not reset.
+func (d *Device) Program(segs []firmware.Segment, opts *firmware.Options) error {
+	segs = d.clip(segs)
+	var total int64
+	for _, s := range segs {
+		total += int64(len(s.Data))
+	}
+	var skip int64
+	if opts != nil {
+		skip = opts.Resume
+	}
+	if skip == 0 {
+		opts.Report(firmware.STAGE_ERASE, 0, 0)
+		if e := d.Erase(); e != nil {
+			return e
+		}
+	}
+	bpa := uint32(d.BytesPerAddress())
+	done := skip
+	opts.Report(firmware.STAGE_DOWNLOAD, done, total)
+	for _, s := range segs {
+		data, addr := s.Data, s.Address
+		if skip >= int64(len(data)) {
+			skip -= int64(len(data))
+			continue
+		}
+		data, addr, skip = data[skip:], addr+uint32(skip)/bpa, 0
+		e := d.write(addr, data, func(n int) {
+			done += int64(n)
+			opts.Report(firmware.STAGE_DOWNLOAD, done, total)
+		})
+		if e != nil {
+			return e
+		}
+	}
+	done = 0
+	opts.Report(firmware.STAGE_VERIFY, done, total)
+	for _, s := range segs {
+		buf := make([]byte, len(s.Data))
+		if e := d.Read(s.Address, buf); e != nil {
+			return e
+		}
+		if !bytes.Equal(buf, s.Data) {
+			return fmt.Errorf("mchp: verify failed in block at 0x%x", s.Address)
+		}
+		done += int64(len(buf))
+		opts.Report(firmware.STAGE_VERIFY, done, total)
+	}
+	opts.Report(firmware.STAGE_DONE, total, total)
+	return nil
+}
@@ -0,0 +1,74 @@
+package usb
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// ErrUsbfsMemoryLimit is matched by errors.Is for a *MemoryLimitError.
+var ErrUsbfsMemoryLimit = errors.New("usbfs memory limit reached")
+
+// MemoryLimitError is what SubmitTransfer and AllocBuffer return in
+// place of ENOMEM from usbfs, which means the usbfs_memory_mb limit was
+// reached.  Raise the parameter, or queue fewer or smaller transfers.
+type MemoryLimitError struct {
+	Limit int64 // bytes, when the error was made; 0 if unlimited or unreadable
+	Held  int64 // bytes this Device had submitted and mapped
+}
+
+func (e *MemoryLimitError) Error() string {
+	if e.Limit == 0 {
+		return fmt.Sprintf("usbfs out of memory, %d bytes held by this device", e.Held)
+	}
+	return fmt.Sprintf("usbfs memory limit of %dMB reached, %d bytes held by this device",
+		e.Limit>>20, e.Held)
+}
+
+func (e *MemoryLimitError) Is(target error) bool {
+	return target == ErrUsbfsMemoryLimit
+}
+
+// Unwrap gives ENOMEM, for code that checks for it.
+func (e *MemoryLimitError) Unwrap() error {
+	return syscall.ENOMEM
+}
+
+// UsbfsMemoryLimit reads the usbfs_memory_mb limit, in bytes; 0 means
+// there is none.  It is shared by every program using usbfs on the
+// machine.
+func UsbfsMemoryLimit() (int64, error) {
+	b, e := os.ReadFile(USBFS_MEMORY_MB)
+	if e != nil {
+		return 0, e
+	}
+	return int64(atou(b)) << 20, nil
+}
+
+func (u *Device) memoryError() error {
+	limit, _ := UsbfsMemoryLimit()
+	return &MemoryLimitError{Limit: limit, Held: u.held.Load()}
+}
+
+// UsbfsHeld is how many bytes of usbfs memory the device holds, in
+// transfers submitted and not yet completed and in AllocBuffer's mapped
+// buffers.  usbfs also counts some bookkeeping per URB.
+func (u *Device) UsbfsHeld() int64 {
+	return u.held.Load()
+}
+
+// FitQueueDepth says how many transfers of size bytes, up to count, can
+// be queued within the usbfs memory limit on top of what the device
+// already holds, for sizing a queue such as NewAcquisitionReader's.  It
+// can't see what other devices and programs hold, so it is an upper
+// bound.  With no limit, or if the limit can't be read, it returns
+// count.
+func (u *Device) FitQueueDepth(size int, count int) int {
+	limit, e := UsbfsMemoryLimit()
+	if e != nil || limit == 0 || size <= 0 {
+		return count
+	}
+	n := (limit - u.held.Load()) / int64(size)
+	return int(max(min(n, int64(count)), 0))
+}
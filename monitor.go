@@ -0,0 +1,65 @@
+package usb
+
+import (
+	"sort"
+	"syscall"
+)
+
+// Monitor is told of a device's transfers by the core, for packages
+// layered on it that watch a device's health, such as watchdog.
+type Monitor interface {
+	// Transfer is told of each transfer on endpoint ep as it finishes,
+	// with its error.  It runs on the goroutine that finished the
+	// transfer, the reaper's for submitted ones, so it must be quick.
+	Transfer(ep uint8, e error)
+	// Closing is called once Close has begun, before the transfers
+	// still queued are discarded; the monitor has been removed by then.
+	Closing()
+}
+
+type monitorBox struct{ m Monitor }
+
+// SetMonitor makes m the device's monitor until RemoveMonitor or Close.
+// A device has one monitor at most; setting another gives EBUSY.
+func (u *Device) SetMonitor(m Monitor) error {
+	if !u.monitor.CompareAndSwap(nil, &monitorBox{m}) {
+		return syscall.EBUSY
+	}
+	return nil
+}
+
+// RemoveMonitor removes m, saying whether it was the device's monitor.
+func (u *Device) RemoveMonitor(m Monitor) bool {
+	b := u.monitor.Load()
+	return b != nil && b.m == m && u.monitor.CompareAndSwap(b, nil)
+}
+
+// observe tells the monitor, if any, of a finished transfer
+func (u *Device) observe(ep uint8, e error) {
+	if b := u.monitor.Load(); b != nil {
+		b.m.Transfer(ep, e)
+	}
+}
+
+// Queued is how many submitted transfers the kernel has yet to return.
+func (u *Device) Queued() int {
+	return int(u.nactive.Load())
+}
+
+// Claimed lists the interfaces claimed through u, in order.
+func (u *Device) Claimed() []uint32 {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	var list []uint32
+	for n := range u.claimed {
+		list = append(list, n)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i] < list[j] })
+	return list
+}
+
+// Info returns the DeviceInfo u was opened from.  For a device opened
+// through Root.OpenBusDev it holds only the bus and device numbers.
+func (u *Device) Info() *DeviceInfo {
+	return u.info
+}
@@ -0,0 +1,257 @@
+package msc
+
+import (
+	"io"
+	"sync"
+	"syscall"
+)
+
+// DEFAULT_MAX_TRANSFER is the largest READ or WRITE a BlockDevice issues
+// unless told otherwise.  usbfs copies bulk data through a kernel buffer,
+// so much larger transfers gain little.
+const DEFAULT_MAX_TRANSFER = 64 * 1024
+
+// BlockDevice presents a LUN as a flat array of bytes for partition and
+// filesystem code.  Reads are rounded out to MaxTransfer, with the surplus
+// kept for the next sequential read; writes are gathered into MaxTransfer
+// sized runs of contiguous blocks and only sent when the run is broken,
+// full, or Flush is called.  Unaligned access is handled by reading the
+// partial blocks first.  It is safe for concurrent use.
+type BlockDevice struct {
+	d         *Device
+	lun       uint8
+	BlockSize int
+	Blocks    uint64
+	max       int // blocks per command
+
+	lock  sync.Mutex
+	rlba  uint64 // read cache: blocks starting at rlba
+	rbuf  []byte
+	wlba  uint64 // pending writes: blocks starting at wlba
+	wbuf  []byte
+	wfail error
+}
+
+// OpenLUN waits for lun to become ready and reads its geometry.
+// maxTransfer is the largest single command in bytes, 0 for
+// DEFAULT_MAX_TRANSFER.
+func (d *Device) OpenLUN(lun uint8, maxTransfer int) (*BlockDevice, error) {
+	if e := d.TestUnitReady(lun); e != nil {
+		return nil, e
+	}
+	size, blocks, e := d.ReadCapacity(lun)
+	if e != nil {
+		return nil, e
+	}
+	if maxTransfer <= 0 {
+		maxTransfer = DEFAULT_MAX_TRANSFER
+	}
+	b := &BlockDevice{d: d, lun: lun, BlockSize: int(size), Blocks: blocks,
+		max: max(1, maxTransfer/int(size))}
+	return b, nil
+}
+
+func (b *BlockDevice) Size() int64 {
+	return int64(b.Blocks) * int64(b.BlockSize)
+}
+
+func (b *BlockDevice) read(lba uint64, buf []byte) error {
+	count := uint32(len(buf) / b.BlockSize)
+	n, e := b.d.command(b.lun, rw(false, lba, count), DIR_IN, buf)
+	if e == nil && n < len(buf) {
+		e = syscall.EIO
+	}
+	return e
+}
+
+func (b *BlockDevice) write(lba uint64, buf []byte) error {
+	count := uint32(len(buf) / b.BlockSize)
+	n, e := b.d.command(b.lun, rw(true, lba, count), DIR_OUT, buf)
+	if e == nil && n < len(buf) {
+		e = syscall.EIO
+	}
+	return e
+}
+
+// cached returns block lba from pending writes or the read cache
+func (b *BlockDevice) cached(lba uint64) []byte {
+	bs := uint64(b.BlockSize)
+	if lba >= b.wlba && lba < b.wlba+uint64(len(b.wbuf))/bs {
+		o := (lba - b.wlba) * bs
+		return b.wbuf[o : o+bs]
+	}
+	if lba >= b.rlba && lba < b.rlba+uint64(len(b.rbuf))/bs {
+		o := (lba - b.rlba) * bs
+		return b.rbuf[o : o+bs]
+	}
+	return nil
+}
+
+// block returns block lba, filling the read cache from it onwards if it
+// isn't already held
+func (b *BlockDevice) block(lba uint64) ([]byte, error) {
+	if p := b.cached(lba); p != nil {
+		return p, nil
+	}
+	n := min(uint64(b.max), b.Blocks-lba)
+	if cap(b.rbuf) < b.max*b.BlockSize {
+		b.rbuf = make([]byte, b.max*b.BlockSize)
+	}
+	b.rbuf = b.rbuf[:n*uint64(b.BlockSize)]
+	b.rlba = lba
+	if e := b.read(lba, b.rbuf); e != nil {
+		b.rbuf = b.rbuf[:0]
+		return nil, e
+	}
+	// pending writes are newer than what was just read
+	if b.overlaps(b.rlba, n) {
+		lo, hi := max(b.rlba, b.wlba), min(b.rlba+n, b.wlba+uint64(len(b.wbuf)/b.BlockSize))
+		bs := uint64(b.BlockSize)
+		copy(b.rbuf[(lo-b.rlba)*bs:(hi-b.rlba)*bs], b.wbuf[(lo-b.wlba)*bs:])
+	}
+	return b.cached(lba), nil
+}
+
+func (b *BlockDevice) overlaps(lba uint64, n uint64) bool {
+	w := uint64(len(b.wbuf) / b.BlockSize)
+	return w > 0 && lba < b.wlba+w && b.wlba < lba+n
+}
+
+func (b *BlockDevice) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, syscall.EINVAL
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	var eof error
+	if size := b.Size(); off >= size {
+		return 0, io.EOF
+	} else if off+int64(len(p)) > size {
+		p = p[:size-off]
+		eof = io.EOF
+	}
+	bs := int64(b.BlockSize)
+	done := 0
+	for done < len(p) {
+		pos := off + int64(done)
+		lba := uint64(pos / bs)
+		// large aligned reads skip the cache
+		if pos%bs == 0 && len(p)-done >= b.max*b.BlockSize && !b.overlaps(lba, uint64(b.max)) {
+			n := b.max * b.BlockSize
+			if e := b.read(lba, p[done:done+n]); e != nil {
+				return done, e
+			}
+			done += n
+			continue
+		}
+		blk, e := b.block(lba)
+		if e != nil {
+			return done, e
+		}
+		done += copy(p[done:], blk[pos%bs:])
+	}
+	return done, eof
+}
+
+func (b *BlockDevice) WriteAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, syscall.EINVAL
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if e := b.wfail; e != nil {
+		return 0, e
+	}
+	var short error
+	if size := b.Size(); off+int64(len(p)) > size {
+		if off > size {
+			off = size
+		}
+		p = p[:size-off]
+		short = syscall.ENOSPC
+	}
+	bs := int64(b.BlockSize)
+	done := 0
+	for done < len(p) {
+		pos := off + int64(done)
+		lba := uint64(pos / bs)
+		var blk []byte
+		if pos%bs != 0 || len(p)-done < b.BlockSize {
+			// a partial block needs its old contents
+			old, e := b.block(lba)
+			if e != nil {
+				return done, e
+			}
+			blk = append([]byte(nil), old...)
+		} else {
+			blk = make([]byte, bs)
+		}
+		n := copy(blk[pos%bs:], p[done:])
+		if e := b.queue(lba, blk); e != nil {
+			return done, e
+		}
+		done += n
+	}
+	return done, short
+}
+
+// queue adds block lba to the pending run, flushing first if it doesn't
+// extend it
+func (b *BlockDevice) queue(lba uint64, blk []byte) error {
+	bs := uint64(b.BlockSize)
+	// keep the read cache coherent
+	if lba >= b.rlba && lba < b.rlba+uint64(len(b.rbuf))/bs {
+		copy(b.rbuf[(lba-b.rlba)*bs:], blk)
+	}
+	w := uint64(len(b.wbuf)) / bs
+	switch {
+	case w > 0 && lba >= b.wlba && lba < b.wlba+w:
+		copy(b.wbuf[(lba-b.wlba)*bs:], blk)
+		return nil
+	case w > 0 && (lba != b.wlba+w || int(w) >= b.max):
+		if e := b.flush(); e != nil {
+			return e
+		}
+	}
+	if len(b.wbuf) == 0 {
+		b.wlba = lba
+	}
+	b.wbuf = append(b.wbuf, blk...)
+	return nil
+}
+
+func (b *BlockDevice) flush() error {
+	if len(b.wbuf) == 0 {
+		return nil
+	}
+	e := b.write(b.wlba, b.wbuf)
+	b.wbuf = b.wbuf[:0]
+	if e != nil {
+		// the data is lost; make sure the caller hears about it
+		b.wfail = e
+	}
+	return e
+}
+
+// Flush sends pending writes and has the device commit its cache.
+func (b *BlockDevice) Flush() error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if e := b.flush(); e != nil {
+		return e
+	}
+	if b.wfail != nil {
+		return b.wfail
+	}
+	e := b.d.SynchronizeCache(b.lun)
+	// not every device implements it
+	if _, ok := e.(*SenseError); ok {
+		e = nil
+	}
+	return e
+}
+
+// Close flushes pending writes.  The Device stays open.
+func (b *BlockDevice) Close() error {
+	return b.Flush()
+}
@@ -0,0 +1,19 @@
+package msc
+
+import "testing"
+
+// FuzzStatus feeds the parsers of the status and sense data a device
+// sends back.
+func FuzzStatus(f *testing.F) {
+	// a command that passed with 16 bytes left over, and one that failed
+	f.Add(uint32(0xad), []byte{'U', 'S', 'B', 'S', 0xad, 0, 0, 0, 0x10, 0, 0, 0, CSW_PASSED})
+	f.Add(uint32(7), []byte{'U', 'S', 'B', 'S', 7, 0, 0, 0, 0, 0, 0, 0, CSW_FAILED})
+	// fixed format sense: illegal request, invalid field in CDB
+	f.Add(uint32(0), []byte{0x70, 0, 0x05, 0, 0, 0, 0, 0x0a, 0, 0, 0, 0, 0x24, 0x00, 0, 0, 0, 0})
+	f.Fuzz(func(t *testing.T, tag uint32, data []byte) {
+		parseCSW(data, tag)
+		if e, ok := parseSense(data).(*SenseError); ok && len(data) < 14 {
+			t.Errorf("%d bytes of sense data read as %v", len(data), e)
+		}
+	})
+}
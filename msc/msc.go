@@ -0,0 +1,249 @@
+// Package msc implements the USB Mass Storage Class Bulk-Only Transport
+// carrying SCSI commands.
+package msc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"syscall"
+
+	"github.com/richardnwinder/usb"
+)
+
+const (
+	CLASS_MASS_STORAGE = 0x08
+	SUBCLASS_SCSI      = 0x06
+	PROTOCOL_BBB       = 0x50 // bulk-only
+
+	REQ_GET_MAX_LUN = 0xfe
+	REQ_BOMS_RESET  = 0xff
+
+	cbwSize = 31
+	cswSize = 13
+
+	cbwSignature = 0x43425355 // "USBC"
+	cswSignature = 0x53425355 // "USBS"
+
+	// bCSWStatus
+	CSW_PASSED      = 0x00
+	CSW_FAILED      = 0x01
+	CSW_PHASE_ERROR = 0x02
+)
+
+var ErrPhase = errors.New("msc: phase error")
+
+type Device struct {
+	dev     *usb.Device
+	ifc     uint8
+	in      uint8
+	out     uint8
+	tag     uint32
+	MaxLUN  uint8
+	Timeout uint32 // ms, per command
+}
+
+// Open claims the bulk-only mass storage interface ii, detaching
+// usb-storage or uas if the kernel has bound one.
+func Open(dev *usb.Device, ii *usb.InterfaceInfo) (*Device, error) {
+	if ii.InterfaceClass != CLASS_MASS_STORAGE || ii.InterfaceProtocol != PROTOCOL_BBB {
+		return nil, syscall.EINVAL
+	}
+	d := &Device{dev: dev, ifc: ii.InterfaceNumber, Timeout: 10000}
+	for _, ep := range ii.Endpoint {
+		if ep.Attributes&usb.ENDPOINT_XFER_MASK != usb.ENDPOINT_XFER_BULK {
+			continue
+		}
+		if ep.EndpointAddress&usb.ENDPOINT_IN != 0 {
+			d.in = ep.EndpointAddress
+		} else {
+			d.out = ep.EndpointAddress
+		}
+	}
+	if d.in == 0 || d.out == 0 {
+		return nil, syscall.ENODEV
+	}
+	e := dev.ClaimInterface(uint32(d.ifc))
+	if errors.Is(e, syscall.EBUSY) {
+		dev.DisconnectDriver(d.ifc)
+		e = dev.ClaimInterface(uint32(d.ifc))
+	}
+	if e != nil {
+		return nil, e
+	}
+	// devices with a single LUN may stall this
+	b := make([]byte, 1)
+	if n, e := dev.ControlTransfer(0xa1, REQ_GET_MAX_LUN, 0, uint16(d.ifc), 1, d.Timeout, b); e == nil && n == 1 {
+		d.MaxLUN = b[0]
+	}
+	return d, nil
+}
+
+func (d *Device) Close() error {
+	return d.dev.ReleaseInterface(uint32(d.ifc))
+}
+
+// Reset performs bulk-only reset recovery on the device's interface.
+func (d *Device) Reset() error {
+	return ResetRecovery(d.dev, d.ifc, d.in, d.out, d.Timeout)
+}
+
+// ResetRecovery performs bulk-only reset recovery, as the specification
+// has it after a phase error or an invalid CSW: the Bulk-Only Mass
+// Storage Reset class request to interface ifc, then clearing the halts
+// of the bulk endpoints in and out.  Vendor protocols that borrow the
+// bulk-only framing often recover the same way.  Both halts are cleared
+// even if the first fails; the first error is returned.
+func ResetRecovery(dev usb.Conn, ifc, in, out uint8, timeout uint32) error {
+	if _, e := dev.ControlTransfer(0x21, REQ_BOMS_RESET, 0, uint16(ifc), 0, timeout, nil); e != nil {
+		return e
+	}
+	e := dev.ClearHalt(in)
+	if e2 := dev.ClearHalt(out); e == nil {
+		e = e2
+	}
+	return e
+}
+
+// execute runs one command through the CBW, data and CSW phases.  It
+// returns the number of bytes moved and the CSW status.
+func (d *Device) execute(lun uint8, cdb []byte, dir Direction, buf []byte) (int, uint8, error) {
+	if len(cdb) == 0 || len(cdb) > 16 || lun > d.MaxLUN || (dir == DIR_NONE) != (len(buf) == 0) {
+		return 0, 0, syscall.EINVAL
+	}
+	d.tag++
+	cbw := make([]byte, cbwSize)
+	binary.LittleEndian.PutUint32(cbw[0:], cbwSignature)
+	binary.LittleEndian.PutUint32(cbw[4:], d.tag)
+	binary.LittleEndian.PutUint32(cbw[8:], uint32(len(buf)))
+	in := dir == DIR_IN
+	if in {
+		cbw[12] = 0x80
+	}
+	cbw[13] = lun
+	cbw[14] = uint8(len(cdb))
+	copy(cbw[15:], cdb)
+	if _, _, e := d.dev.BulkTransfer(uint32(d.out), cbwSize, d.Timeout, cbw); e != nil {
+		d.Reset()
+		return 0, 0, e
+	}
+	n := 0
+	if len(buf) > 0 {
+		ep := d.out
+		if in {
+			ep = d.in
+		}
+		var e error
+		n, _, e = d.dev.BulkTransfer(uint32(ep), uint32(len(buf)), d.Timeout, buf)
+		// a stalled data phase still ends with a CSW
+		if errors.Is(e, syscall.EPIPE) {
+			d.dev.ClearHalt(ep)
+		} else if e != nil {
+			d.Reset()
+			return 0, 0, e
+		}
+	}
+	csw := make([]byte, cswSize)
+	m, _, e := d.dev.BulkTransfer(uint32(d.in), cswSize, d.Timeout, csw)
+	if errors.Is(e, syscall.EPIPE) {
+		d.dev.ClearHalt(d.in)
+		m, _, e = d.dev.BulkTransfer(uint32(d.in), cswSize, d.Timeout, csw)
+	}
+	if e != nil {
+		d.Reset()
+		return 0, 0, e
+	}
+	residue, status, e := parseCSW(csw[:m], d.tag)
+	if e != nil {
+		d.Reset()
+		return 0, 0, e
+	}
+	// the residue is what the device didn't use of the data phase
+	if r := int(min(residue, uint32(len(buf)))); in && len(buf)-r < n {
+		n = len(buf) - r
+	}
+	return n, status, nil
+}
+
+// parseCSW checks a command status wrapper against the command's tag and
+// returns its residue and status.  A phase error, or a status the spec
+// doesn't define, means the device has lost track and needs a reset.
+func parseCSW(csw []byte, tag uint32) (uint32, uint8, error) {
+	if len(csw) != cswSize || binary.LittleEndian.Uint32(csw[0:]) != cswSignature ||
+		binary.LittleEndian.Uint32(csw[4:]) != tag {
+		return 0, 0, syscall.EPROTO
+	}
+	if csw[12] >= CSW_PHASE_ERROR {
+		return 0, 0, ErrPhase
+	}
+	return binary.LittleEndian.Uint32(csw[8:]), csw[12], nil
+}
+
+// SenseError is a command failure as described by REQUEST SENSE.
+type SenseError struct {
+	Key   uint8
+	ASC   uint8
+	ASCQ  uint8
+	Sense []byte // the whole fixed format sense data, for vendor fields
+}
+
+var senseKeys = []string{"no sense", "recovered error", "not ready", "medium error",
+	"hardware error", "illegal request", "unit attention", "data protect",
+	"blank check", "vendor specific", "copy aborted", "aborted command",
+	"", "volume overflow", "miscompare", "completed"}
+
+func (e *SenseError) Error() string {
+	return fmt.Sprintf("msc: %s (asc 0x%02x ascq 0x%02x)", senseKeys[e.Key&0xf], e.ASC, e.ASCQ)
+}
+
+const (
+	SENSE_NOT_READY       = 0x2
+	SENSE_MEDIUM_ERROR    = 0x3
+	SENSE_ILLEGAL_REQUEST = 0x5
+	SENSE_UNIT_ATTENTION  = 0x6
+	SENSE_DATA_PROTECT    = 0x7
+)
+
+// command runs a SCSI command and turns a failed status into a
+// SenseError.
+func (d *Device) command(lun uint8, cdb []byte, dir Direction, buf []byte) (int, error) {
+	n, status, e := d.execute(lun, cdb, dir, buf)
+	if e != nil || status == CSW_PASSED {
+		return n, e
+	}
+	sense := make([]byte, 18)
+	m, status, e := d.execute(lun, []byte{SCSI_REQUEST_SENSE, 0, 0, 0, 18, 0}, DIR_IN, sense)
+	if e != nil {
+		return n, e
+	}
+	if status != CSW_PASSED {
+		return n, syscall.EIO
+	}
+	return n, parseSense(sense[:m])
+}
+
+// parseSense decodes fixed format sense data, which needs at least the
+// ASC and ASCQ bytes
+func parseSense(sense []byte) error {
+	if len(sense) < 14 {
+		return syscall.EIO
+	}
+	return &SenseError{sense[2] & 0xf, sense[12], sense[13], sense}
+}
+
+// Direction is the way a command's data phase goes.
+type Direction int
+
+const (
+	DIR_NONE Direction = iota
+	DIR_IN             // device to host
+	DIR_OUT            // host to device
+)
+
+// ExecuteSCSI issues an arbitrary command, for the vendor-unique and
+// diagnostic commands the package has no wrapper for.  buf is the data
+// phase, which must be empty for DIR_NONE.  It returns how many bytes
+// were transferred; a command the device rejects returns a *SenseError.
+func (d *Device) ExecuteSCSI(lun uint8, cdb []byte, dir Direction, buf []byte) (int, error) {
+	return d.command(lun, cdb, dir, buf)
+}
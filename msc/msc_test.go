@@ -0,0 +1,82 @@
+package msc_test
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+
+	"github.com/richardnwinder/usb"
+	"github.com/richardnwinder/usb/msc"
+	"github.com/richardnwinder/usb/usbtest"
+)
+
+// a bulk-only mass storage device with bulk endpoints 0x81 and 0x02
+const testDescriptors = `
+12 01 00 02 00 00 00 40  34 12 78 56 00 01 00 00 00 01
+09 02 20 00 01 01 00 80 32
+09 04 00 00 02 08 06 50 00
+07 05 81 02 00 02 00
+07 05 02 02 00 02 00
+`
+
+func newDevice(t *testing.T) *usbtest.Device {
+	f, e := usbtest.ParseHex("test", testDescriptors)
+	if e != nil {
+		t.Fatal(e)
+	}
+	return f.Device()
+}
+
+func TestResetRecovery(t *testing.T) {
+	d := newDevice(t)
+	d.On(usbtest.Control(0x21, msc.REQ_BOMS_RESET)).Reply(nil)
+	d.On(usbtest.Bulk(0x81)).Times(1).Stall()
+	d.On(usbtest.Bulk(0x02)).Times(1).Stall()
+	d.On(usbtest.Bulk(0x81)).Reply([]byte{1, 2, 3})
+
+	buf := make([]byte, 16)
+	d.BulkTransfer(0x81, 16, 100, buf)
+	d.BulkTransfer(0x02, 1, 100, buf)
+	if _, _, e := d.BulkTransfer(0x81, 16, 100, buf); !errors.Is(e, syscall.EPIPE) {
+		t.Fatalf("halted endpoint: %v, want EPIPE", e)
+	}
+
+	if e := msc.ResetRecovery(d, 0, 0x81, 0x02, 100); e != nil {
+		t.Fatal(e)
+	}
+	var reset *usbtest.Request
+	for _, q := range d.Requests() {
+		if q.Endpoint == 0 && q.Setup.Request == msc.REQ_BOMS_RESET {
+			reset = &q
+		}
+	}
+	if reset == nil {
+		t.Fatal("no Bulk-Only Mass Storage Reset sent")
+	}
+	if want := (usb.ControlRequest{RequestType: 0x21, Request: msc.REQ_BOMS_RESET}); reset.Setup != want {
+		t.Errorf("reset request %+v, want %+v", reset.Setup, want)
+	}
+	if n, _, e := d.BulkTransfer(0x81, 16, 100, buf); e != nil || n != 3 {
+		t.Errorf("after recovery, read %d bytes, %v; want 3", n, e)
+	}
+	if _, _, e := d.BulkTransfer(0x02, 1, 100, buf); e != nil {
+		t.Errorf("after recovery, write: %v", e)
+	}
+}
+
+// TestResetRecoveryFailed checks that a device refusing the reset is
+// reported and its endpoints left alone
+func TestResetRecoveryFailed(t *testing.T) {
+	d := newDevice(t)
+	d.On(usbtest.Control(0x21, msc.REQ_BOMS_RESET)).Stall()
+	d.On(usbtest.Bulk(0x81)).Times(1).Stall()
+
+	buf := make([]byte, 16)
+	d.BulkTransfer(0x81, 16, 100, buf)
+	if e := msc.ResetRecovery(d, 0, 0x81, 0x02, 100); !errors.Is(e, syscall.EPIPE) {
+		t.Fatalf("ResetRecovery: %v, want EPIPE", e)
+	}
+	if _, _, e := d.BulkTransfer(0x81, 16, 100, buf); !errors.Is(e, syscall.EPIPE) {
+		t.Errorf("endpoint cleared after a failed reset: %v", e)
+	}
+}
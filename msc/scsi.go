@@ -0,0 +1,103 @@
+package msc
+
+import (
+	"encoding/binary"
+	"errors"
+	"syscall"
+	"time"
+)
+
+const (
+	SCSI_TEST_UNIT_READY   = 0x00
+	SCSI_REQUEST_SENSE     = 0x03
+	SCSI_INQUIRY           = 0x12
+	SCSI_MODE_SENSE_6      = 0x1a
+	SCSI_START_STOP_UNIT   = 0x1b
+	SCSI_PREVENT_ALLOW     = 0x1e
+	SCSI_READ_FORMAT_CAPS  = 0x23
+	SCSI_READ_CAPACITY_10  = 0x25
+	SCSI_READ_10           = 0x28
+	SCSI_WRITE_10          = 0x2a
+	SCSI_VERIFY_10         = 0x2f
+	SCSI_SYNCHRONIZE_CACHE = 0x35
+	SCSI_MODE_SENSE_10     = 0x5a
+	SCSI_READ_16           = 0x88
+	SCSI_WRITE_16          = 0x8a
+	SCSI_SERVICE_ACTION_IN = 0x9e // READ CAPACITY(16) is service action 0x10
+)
+
+// TestUnitReady waits for the medium to be ready, riding out the unit
+// attention devices report after a reset or media change.
+func (d *Device) TestUnitReady(lun uint8) error {
+	var e error
+	for i := 0; i < 10; i++ {
+		_, e = d.command(lun, []byte{SCSI_TEST_UNIT_READY, 0, 0, 0, 0, 0}, DIR_NONE, nil)
+		var se *SenseError
+		if !errors.As(e, &se) || (se.Key != SENSE_UNIT_ATTENTION && se.Key != SENSE_NOT_READY) {
+			return e
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return e
+}
+
+// ReadCapacity returns the block size and number of blocks on lun.
+func (d *Device) ReadCapacity(lun uint8) (uint32, uint64, error) {
+	b := make([]byte, 8)
+	n, e := d.command(lun, []byte{SCSI_READ_CAPACITY_10, 0, 0, 0, 0, 0, 0, 0, 0, 0}, DIR_IN, b)
+	if e != nil {
+		return 0, 0, e
+	}
+	if n < 8 {
+		return 0, 0, syscall.EPROTO
+	}
+	last := uint64(binary.BigEndian.Uint32(b))
+	size := binary.BigEndian.Uint32(b[4:])
+	if last == 0xffffffff {
+		b = make([]byte, 32)
+		cdb := make([]byte, 16)
+		cdb[0], cdb[1] = SCSI_SERVICE_ACTION_IN, 0x10
+		binary.BigEndian.PutUint32(cdb[10:], 32)
+		if n, e = d.command(lun, cdb, DIR_IN, b); e != nil {
+			return 0, 0, e
+		}
+		if n < 12 {
+			return 0, 0, syscall.EPROTO
+		}
+		last = binary.BigEndian.Uint64(b)
+		size = binary.BigEndian.Uint32(b[8:])
+	}
+	if size == 0 {
+		return 0, 0, syscall.EPROTO
+	}
+	return size, last + 1, nil
+}
+
+// rw builds a READ or WRITE, using the 16 byte forms only when the
+// address or count needs them.
+func rw(write bool, lba uint64, count uint32) []byte {
+	if lba+uint64(count) <= 1<<32 && count <= 0xffff {
+		cdb := make([]byte, 10)
+		cdb[0] = SCSI_READ_10
+		if write {
+			cdb[0] = SCSI_WRITE_10
+		}
+		binary.BigEndian.PutUint32(cdb[2:], uint32(lba))
+		binary.BigEndian.PutUint16(cdb[7:], uint16(count))
+		return cdb
+	}
+	cdb := make([]byte, 16)
+	cdb[0] = SCSI_READ_16
+	if write {
+		cdb[0] = SCSI_WRITE_16
+	}
+	binary.BigEndian.PutUint64(cdb[2:], lba)
+	binary.BigEndian.PutUint32(cdb[10:], count)
+	return cdb
+}
+
+// SynchronizeCache asks the device to commit its write cache to the medium.
+func (d *Device) SynchronizeCache(lun uint8) error {
+	_, e := d.command(lun, []byte{SCSI_SYNCHRONIZE_CACHE, 0, 0, 0, 0, 0, 0, 0, 0, 0}, DIR_NONE, nil)
+	return e
+}
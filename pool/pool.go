@@ -0,0 +1,206 @@
+// Package pool runs a function, such as flash-and-verify, on every
+// matching device attached, and on those plugged in while it runs, as a
+// production line programming boards does.
+package pool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"syscall"
+	"time"
+
+	"github.com/richardnwinder/usb"
+)
+
+// Result is the outcome of a Pool's function on one device.
+type Result struct {
+	Device    *usb.DeviceInfo
+	Err       error // from opening the device or the function
+	Unplugged bool  // the device went away while the function ran
+	Start     time.Time
+	End       time.Time
+}
+
+// Pool runs a function, such as flash-and-verify, on every device Match
+// accepts: those attached when Run starts and, with Wait set, those
+// plugged in later.  Each device is opened and passed to the function on
+// its own goroutine, up to Parallel at a time.
+type Pool struct {
+	Match    usb.Matcher
+	Parallel int  // how many devices run at once, 0 for no limit
+	Wait     bool // keep taking newly attached devices until ctx is done
+
+	// Key names a board so that one re-enumerating while or after the
+	// function runs on it, as most do after being flashed, isn't taken
+	// again.  It defaults to the vendor and product IDs and the serial
+	// number, or the port path for devices without one, so boards that
+	// lack a serial number are only taken once per port.
+	Key func(*usb.DeviceInfo) string
+
+	// OnResult, if set, is called with each result as it comes in, from
+	// the goroutine running Run.
+	OnResult func(Result)
+}
+
+func poolKey(di *usb.DeviceInfo) string {
+	if s := di.Serial(); s != "" {
+		return fmt.Sprintf("%04x:%04x/%s", di.VendorID, di.ProductID, s)
+	}
+	return fmt.Sprintf("%04x:%04x@%d-%v", di.VendorID, di.ProductID, di.BusNum, di.Ports)
+}
+
+type poolJob struct {
+	di        *usb.DeviceInfo
+	cancel    context.CancelFunc
+	unplugged bool
+	start     time.Time
+}
+
+// Run runs fn on the matching devices and returns every result.  The
+// context fn gets is cancelled if its device is unplugged, which fn
+// should notice as well as the ENODEV its transfers start failing with.
+// Without Wait, Run returns once every device attached at the start has
+// been done; with it, once ctx is done.  In both cases functions still
+// running when ctx is done are cancelled and waited for.  The error is
+// for the pool itself, such as failing to watch for hotplug events;
+// each device's is in its result.
+func (p *Pool) Run(ctx context.Context, fn func(ctx context.Context, u *usb.Device, di *usb.DeviceInfo) error) ([]Result, error) {
+	match := p.Match
+	if match == nil {
+		match = func(*usb.DeviceInfo) bool { return true }
+	}
+	key := p.Key
+	if key == nil {
+		key = poolKey
+	}
+	// listen before scanning so an attach between the two isn't lost
+	m, e := usb.NewHotplugMonitor()
+	if e != nil {
+		return nil, e
+	}
+	defer m.Close()
+	devs, e := usb.ListDevices()
+	if e != nil {
+		return nil, e
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	events := make(chan *usb.HotplugEvent)
+	var monErr error
+	go func() {
+		defer close(events)
+		for {
+			ev, e := m.Next()
+			if e != nil {
+				monErr = e
+				return
+			}
+			select {
+			case events <- ev:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	var queue []*usb.DeviceInfo
+	seen := make(map[string]bool)
+	add := func(di *usb.DeviceInfo) {
+		if k := key(di); match(di) && !seen[k] {
+			seen[k] = true
+			queue = append(queue, di)
+		}
+	}
+	for _, di := range devs {
+		add(di)
+	}
+
+	var results []Result
+	running := make(map[string]*poolJob) // by sysfs path
+	done := make(chan Result)
+	finish := func(r Result) {
+		j := running[r.Device.SysPath()]
+		delete(running, r.Device.SysPath())
+		r.Unplugged = j.unplugged
+		r.Start = j.start
+		results = append(results, r)
+		if p.OnResult != nil {
+			p.OnResult(r)
+		}
+	}
+	var err error
+	for {
+		for len(queue) > 0 && ctx.Err() == nil && (p.Parallel <= 0 || len(running) < p.Parallel) {
+			di := queue[0]
+			queue = queue[1:]
+			jctx, cancel := context.WithCancel(ctx)
+			running[di.SysPath()] = &poolJob{di: di, cancel: cancel, start: time.Now()}
+			go func() {
+				defer cancel()
+				e := poolRun(jctx, di, fn)
+				done <- Result{Device: di, Err: e, End: time.Now()}
+			}()
+		}
+		if len(running) == 0 && ((len(queue) == 0 && !p.Wait) || ctx.Err() != nil) {
+			break
+		}
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				// without events, unplugs go unnoticed; carry on only
+				// with what is already known
+				events = nil
+				err = monErr
+				continue
+			}
+			switch ev.Action {
+			case "add":
+				// without Wait, only the devices attached at the start
+				// are taken
+				if p.Wait {
+					add(ev.Device)
+				}
+			case "remove":
+				if j := running[ev.Device.SysPath()]; j != nil {
+					j.unplugged = true
+					j.cancel()
+				}
+			}
+		case r := <-done:
+			finish(r)
+		case <-ctx.Done():
+			// let the running functions see it and drain their results
+			for len(running) > 0 {
+				finish(<-done)
+			}
+		}
+	}
+	if ctx.Err() != nil && !p.Wait {
+		err = ctx.Err()
+	}
+	return results, err
+}
+
+// poolRun opens the device and runs fn on it.  udev sets the node's
+// permissions just after the kernel announces a device, so opening is
+// retried briefly on EACCES.
+func poolRun(ctx context.Context, di *usb.DeviceInfo, fn func(ctx context.Context, u *usb.Device, di *usb.DeviceInfo) error) error {
+	var u *usb.Device
+	var e error
+	for try := 0; ; try++ {
+		if u, e = usb.Open(di); !errors.Is(e, syscall.EACCES) || try == 10 {
+			break
+		}
+		select {
+		case <-time.After(100 * time.Millisecond):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if e != nil {
+		return e
+	}
+	defer u.Close()
+	return fn(ctx, u, di)
+}
@@ -0,0 +1,40 @@
+package usb
+
+import (
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// ClaimPort has the kernel's hub driver leave a port of the hub u alone
+// until ReleasePort or Close: a device connected to it isn't enumerated,
+// and the driver doesn't act on its status changes.
+func (u *Device) ClaimPort(port int) error {
+	n := uint32(port)
+	start := time.Now()
+	_, e := u.do(USBDEVFS_CLAIM_PORT, unsafe.Pointer(&n))
+	return u.opError("claim_port", start, e, port)
+}
+
+func (u *Device) ReleasePort(port int) error {
+	n := uint32(port)
+	start := time.Now()
+	_, e := u.do(USBDEVFS_RELEASE_PORT, unsafe.Pointer(&n))
+	return u.opError("release_port", start, e, port)
+}
+
+// Parent returns the hub the device is plugged into: an external hub, or
+// the root hub for devices on a root port.  Root hubs give ENODEV.
+func (di *DeviceInfo) Parent() (*DeviceInfo, error) {
+	if len(di.Ports) == 0 || di.syspath == "" {
+		return nil, syscall.ENODEV
+	}
+	return readDeviceInfo(di.root, di.parentPath())
+}
+
+// Path is the device's sysfs name, its bus and port path such as
+// "1-2.3", or "usb1" for a root hub.
+func (di *DeviceInfo) Path() string {
+	return di.syspath[strings.LastIndexByte(di.syspath, '/')+1:]
+}
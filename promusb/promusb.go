@@ -0,0 +1,222 @@
+// Package promusb exports device statistics in the Prometheus text
+// format.  An Exporter is an http.Handler to mount on /metrics; it reads
+// the counters every open Device keeps, so nothing in the data path
+// changes.  It writes the exposition format directly rather than
+// depending on the Prometheus client library.
+package promusb
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/richardnwinder/usb"
+)
+
+// source is what an entry reads its counters from: a *usb.Device, or a
+// fake in the tests
+type source interface {
+	Stats() map[uint8]usb.EndpointStats
+	ReapStats() usb.ReapStats
+}
+
+type entry struct {
+	dev        source
+	reconnects uint64
+	// counters carried over from devices this name had before
+	past     map[uint8]usb.EndpointStats
+	pastReap usb.ReapStats
+}
+
+// Exporter serves the statistics of the devices added to it.
+type Exporter struct {
+	lock    sync.Mutex
+	devices map[string]*entry
+}
+
+func New() *Exporter {
+	return &Exporter{devices: map[string]*entry{}}
+}
+
+// Add exports u under name, such as a serial number or port path.
+// Adding a new Device under a name already in use counts a reconnect
+// and carries the old device's counters on, so the series stay
+// monotonic across replugs.
+func (x *Exporter) Add(name string, u *usb.Device) {
+	// a nil *usb.Device would make a source that isn't nil
+	if u == nil {
+		x.add(name, nil)
+		return
+	}
+	x.add(name, u)
+}
+
+func (x *Exporter) add(name string, u source) {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	en := x.devices[name]
+	if en == nil {
+		x.devices[name] = &entry{dev: u, past: map[uint8]usb.EndpointStats{}}
+		return
+	}
+	if en.dev == u {
+		return
+	}
+	if en.dev != nil {
+		en.past = merge(en.past, en.dev.Stats())
+		en.pastReap = mergeReap(en.pastReap, en.dev.ReapStats())
+		// nothing is queued on a device that's gone
+		for ep, s := range en.past {
+			s.InFlight = 0
+			en.past[ep] = s
+		}
+	}
+	en.dev = u
+	en.reconnects++
+}
+
+// Remove stops exporting name.
+func (x *Exporter) Remove(name string) {
+	x.lock.Lock()
+	delete(x.devices, name)
+	x.lock.Unlock()
+}
+
+func merge(a map[uint8]usb.EndpointStats, b map[uint8]usb.EndpointStats) map[uint8]usb.EndpointStats {
+	m := map[uint8]usb.EndpointStats{}
+	for _, src := range []map[uint8]usb.EndpointStats{a, b} {
+		for ep, s := range src {
+			t := m[ep]
+			t.Transfers += s.Transfers
+			t.Bytes += s.Bytes
+			t.InFlight = s.InFlight
+			t.Latency.Add(s.Latency)
+			if t.Errors == nil {
+				t.Errors = map[string]uint64{}
+			}
+			for k, v := range s.Errors {
+				t.Errors[k] += v
+			}
+			m[ep] = t
+		}
+	}
+	return m
+}
+
+func mergeReap(a, b usb.ReapStats) usb.ReapStats {
+	return usb.ReapStats{Batches: a.Batches + b.Batches, URBs: a.URBs + b.URBs, Largest: max(a.Largest, b.Largest)}
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+type sample struct {
+	suffix string // of the family's name, for a histogram's series
+	labels string
+	value  string
+}
+
+type family struct {
+	name, kind, help string
+	samples          []sample
+}
+
+func (f *family) add(value uint64, kv ...string) {
+	f.addSeries("", strconv.FormatUint(value, 10), kv...)
+}
+
+func (f *family) addSeries(suffix string, value string, kv ...string) {
+	var b strings.Builder
+	for i := 0; i < len(kv); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, kv[i], escaper.Replace(kv[i+1]))
+	}
+	f.samples = append(f.samples, sample{suffix, b.String(), value})
+}
+
+// addHistogram adds h's cumulative buckets, sum and count, in seconds
+func (f *family) addHistogram(h usb.Histogram, kv ...string) {
+	var n uint64
+	for i, c := range h.Counts {
+		n += c
+		le := "+Inf"
+		if i < usb.HISTOGRAM_BUCKETS-1 {
+			le = strconv.FormatFloat(h.Bound(i).Seconds(), 'g', -1, 64)
+		}
+		f.addSeries("_bucket", strconv.FormatUint(n, 10), append(kv, "le", le)...)
+	}
+	f.addSeries("_sum", strconv.FormatFloat(h.Sum.Seconds(), 'g', -1, 64), kv...)
+	f.addSeries("_count", strconv.FormatUint(n, 10), kv...)
+}
+
+// WriteTo writes the current metrics.
+func (x *Exporter) WriteTo(w io.Writer) (int64, error) {
+	transfers := &family{name: "usb_transfers_total", kind: "counter", help: "Transfers completed, by endpoint."}
+	bytes := &family{name: "usb_transfer_bytes_total", kind: "counter", help: "Bytes moved, by endpoint."}
+	errs := &family{name: "usb_transfer_errors_total", kind: "counter", help: "Failed transfers, by endpoint and URB status."}
+	latency := &family{name: "usb_transfer_latency_seconds", kind: "histogram", help: "Time from submission to completion, by endpoint."}
+	queued := &family{name: "usb_transfers_in_flight", kind: "gauge", help: "Asynchronous transfers queued to the kernel."}
+	reconn := &family{name: "usb_reconnects_total", kind: "counter", help: "Times the device was reopened after going away."}
+	batches := &family{name: "usb_reap_batches_total", kind: "counter", help: "Batches of completed URBs delivered by the reaper."}
+	reaped := &family{name: "usb_reaped_urbs_total", kind: "counter", help: "URBs delivered in those batches; divided by the batches, the mean batch size."}
+
+	x.lock.Lock()
+	names := make([]string, 0, len(x.devices))
+	for n := range x.devices {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		en := x.devices[n]
+		reconn.add(en.reconnects, "device", n)
+		st, rs := en.past, en.pastReap
+		if en.dev != nil {
+			st = merge(en.past, en.dev.Stats())
+			rs = mergeReap(en.pastReap, en.dev.ReapStats())
+		}
+		batches.add(rs.Batches, "device", n)
+		reaped.add(rs.URBs, "device", n)
+		eps := make([]int, 0, len(st))
+		for ep := range st {
+			eps = append(eps, int(ep))
+		}
+		sort.Ints(eps)
+		for _, ep := range eps {
+			s := st[uint8(ep)]
+			epl := fmt.Sprintf("0x%02x", ep)
+			transfers.add(s.Transfers, "device", n, "endpoint", epl)
+			bytes.add(s.Bytes, "device", n, "endpoint", epl)
+			queued.add(uint64(max(s.InFlight, 0)), "device", n, "endpoint", epl)
+			latency.addHistogram(s.Latency, "device", n, "endpoint", epl)
+			kinds := make([]string, 0, len(s.Errors))
+			for k := range s.Errors {
+				kinds = append(kinds, k)
+			}
+			sort.Strings(kinds)
+			for _, k := range kinds {
+				errs.add(s.Errors[k], "device", n, "endpoint", epl, "status", k)
+			}
+		}
+	}
+	x.lock.Unlock()
+
+	var b strings.Builder
+	for _, f := range []*family{transfers, bytes, errs, latency, queued, reconn, batches, reaped} {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.kind)
+		for _, s := range f.samples {
+			fmt.Fprintf(&b, "%s%s{%s} %s\n", f.name, s.suffix, s.labels, s.value)
+		}
+	}
+	n, e := io.WriteString(w, b.String())
+	return int64(n), e
+}
+
+func (x *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	x.WriteTo(w)
+}
@@ -0,0 +1,118 @@
+package promusb
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/richardnwinder/usb"
+)
+
+// fakeDevice stands in for an open *usb.Device
+type fakeDevice struct {
+	stats map[uint8]usb.EndpointStats
+	reap  usb.ReapStats
+}
+
+func (d *fakeDevice) Stats() map[uint8]usb.EndpointStats { return d.stats }
+func (d *fakeDevice) ReapStats() usb.ReapStats           { return d.reap }
+
+func latency(ds ...time.Duration) usb.Histogram {
+	var h usb.Histogram
+	for _, d := range ds {
+		h.Observe(d)
+	}
+	return h
+}
+
+func TestWriteTo(t *testing.T) {
+	x := New()
+	name := "port \"1-2\"\n\\x"
+	x.add(name, &fakeDevice{
+		stats: map[uint8]usb.EndpointStats{
+			0x81: {Transfers: 3, Bytes: 100, InFlight: 2, Errors: map[string]uint64{"EPIPE": 1},
+				Latency: latency(time.Microsecond, 3*time.Microsecond, time.Hour)},
+		},
+		reap: usb.ReapStats{Batches: 2, URBs: 5, Largest: 3},
+	})
+	// replugged: the old counters carry on under the same name, apart from
+	// what was queued on the old device, and a histogram's buckets add up
+	again := &fakeDevice{
+		stats: map[uint8]usb.EndpointStats{
+			0x81: {Transfers: 1, Bytes: 4, InFlight: 1, Errors: map[string]uint64{"EPIPE": 1, "ETIMEDOUT": 1},
+				Latency: latency(time.Millisecond)},
+		},
+		reap: usb.ReapStats{Batches: 1, URBs: 1, Largest: 1},
+	}
+	x.add(name, again)
+	// adding the same device again isn't a reconnect
+	x.add(name, again)
+	x.add("b", &fakeDevice{})
+
+	var b strings.Builder
+	n, e := x.WriteTo(&b)
+	if e != nil || n != int64(b.Len()) {
+		t.Fatalf("WriteTo: %d, %v; wrote %d", n, e, b.Len())
+	}
+	if b.String() != golden {
+		t.Errorf("got\n%s\nwant\n%s", b.String(), golden)
+	}
+}
+
+const golden = `# HELP usb_transfers_total Transfers completed, by endpoint.
+# TYPE usb_transfers_total counter
+usb_transfers_total{device="port \"1-2\"\n\\x",endpoint="0x81"} 4
+# HELP usb_transfer_bytes_total Bytes moved, by endpoint.
+# TYPE usb_transfer_bytes_total counter
+usb_transfer_bytes_total{device="port \"1-2\"\n\\x",endpoint="0x81"} 104
+# HELP usb_transfer_errors_total Failed transfers, by endpoint and URB status.
+# TYPE usb_transfer_errors_total counter
+usb_transfer_errors_total{device="port \"1-2\"\n\\x",endpoint="0x81",status="EPIPE"} 2
+usb_transfer_errors_total{device="port \"1-2\"\n\\x",endpoint="0x81",status="ETIMEDOUT"} 1
+# HELP usb_transfer_latency_seconds Time from submission to completion, by endpoint.
+# TYPE usb_transfer_latency_seconds histogram
+usb_transfer_latency_seconds_bucket{device="port \"1-2\"\n\\x",endpoint="0x81",le="1e-06"} 1
+usb_transfer_latency_seconds_bucket{device="port \"1-2\"\n\\x",endpoint="0x81",le="2e-06"} 1
+usb_transfer_latency_seconds_bucket{device="port \"1-2\"\n\\x",endpoint="0x81",le="4e-06"} 2
+usb_transfer_latency_seconds_bucket{device="port \"1-2\"\n\\x",endpoint="0x81",le="8e-06"} 2
+usb_transfer_latency_seconds_bucket{device="port \"1-2\"\n\\x",endpoint="0x81",le="1.6e-05"} 2
+usb_transfer_latency_seconds_bucket{device="port \"1-2\"\n\\x",endpoint="0x81",le="3.2e-05"} 2
+usb_transfer_latency_seconds_bucket{device="port \"1-2\"\n\\x",endpoint="0x81",le="6.4e-05"} 2
+usb_transfer_latency_seconds_bucket{device="port \"1-2\"\n\\x",endpoint="0x81",le="0.000128"} 2
+usb_transfer_latency_seconds_bucket{device="port \"1-2\"\n\\x",endpoint="0x81",le="0.000256"} 2
+usb_transfer_latency_seconds_bucket{device="port \"1-2\"\n\\x",endpoint="0x81",le="0.000512"} 2
+usb_transfer_latency_seconds_bucket{device="port \"1-2\"\n\\x",endpoint="0x81",le="0.001024"} 3
+usb_transfer_latency_seconds_bucket{device="port \"1-2\"\n\\x",endpoint="0x81",le="0.002048"} 3
+usb_transfer_latency_seconds_bucket{device="port \"1-2\"\n\\x",endpoint="0x81",le="0.004096"} 3
+usb_transfer_latency_seconds_bucket{device="port \"1-2\"\n\\x",endpoint="0x81",le="0.008192"} 3
+usb_transfer_latency_seconds_bucket{device="port \"1-2\"\n\\x",endpoint="0x81",le="0.016384"} 3
+usb_transfer_latency_seconds_bucket{device="port \"1-2\"\n\\x",endpoint="0x81",le="0.032768"} 3
+usb_transfer_latency_seconds_bucket{device="port \"1-2\"\n\\x",endpoint="0x81",le="0.065536"} 3
+usb_transfer_latency_seconds_bucket{device="port \"1-2\"\n\\x",endpoint="0x81",le="0.131072"} 3
+usb_transfer_latency_seconds_bucket{device="port \"1-2\"\n\\x",endpoint="0x81",le="0.262144"} 3
+usb_transfer_latency_seconds_bucket{device="port \"1-2\"\n\\x",endpoint="0x81",le="0.524288"} 3
+usb_transfer_latency_seconds_bucket{device="port \"1-2\"\n\\x",endpoint="0x81",le="1.048576"} 3
+usb_transfer_latency_seconds_bucket{device="port \"1-2\"\n\\x",endpoint="0x81",le="2.097152"} 3
+usb_transfer_latency_seconds_bucket{device="port \"1-2\"\n\\x",endpoint="0x81",le="4.194304"} 3
+usb_transfer_latency_seconds_bucket{device="port \"1-2\"\n\\x",endpoint="0x81",le="8.388608"} 3
+usb_transfer_latency_seconds_bucket{device="port \"1-2\"\n\\x",endpoint="0x81",le="16.777216"} 3
+usb_transfer_latency_seconds_bucket{device="port \"1-2\"\n\\x",endpoint="0x81",le="33.554432"} 3
+usb_transfer_latency_seconds_bucket{device="port \"1-2\"\n\\x",endpoint="0x81",le="+Inf"} 4
+usb_transfer_latency_seconds_sum{device="port \"1-2\"\n\\x",endpoint="0x81"} 3600.001004
+usb_transfer_latency_seconds_count{device="port \"1-2\"\n\\x",endpoint="0x81"} 4
+# HELP usb_transfers_in_flight Asynchronous transfers queued to the kernel.
+# TYPE usb_transfers_in_flight gauge
+usb_transfers_in_flight{device="port \"1-2\"\n\\x",endpoint="0x81"} 1
+# HELP usb_reconnects_total Times the device was reopened after going away.
+# TYPE usb_reconnects_total counter
+usb_reconnects_total{device="b"} 0
+usb_reconnects_total{device="port \"1-2\"\n\\x"} 1
+# HELP usb_reap_batches_total Batches of completed URBs delivered by the reaper.
+# TYPE usb_reap_batches_total counter
+usb_reap_batches_total{device="b"} 0
+usb_reap_batches_total{device="port \"1-2\"\n\\x"} 3
+# HELP usb_reaped_urbs_total URBs delivered in those batches; divided by the batches, the mean batch size.
+# TYPE usb_reaped_urbs_total counter
+usb_reaped_urbs_total{device="b"} 0
+usb_reaped_urbs_total{device="port \"1-2\"\n\\x"} 6
+`
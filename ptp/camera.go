@@ -0,0 +1,333 @@
+package ptp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"syscall"
+	"time"
+)
+
+// standard device properties
+const (
+	DPC_BATTERY_LEVEL      = 0x5001
+	DPC_IMAGE_SIZE         = 0x5003
+	DPC_WHITE_BALANCE      = 0x5005
+	DPC_F_NUMBER           = 0x5007 // f/100
+	DPC_FOCUS_MODE         = 0x500a
+	DPC_EXPOSURE_TIME      = 0x500d // 1/10000 s
+	DPC_EXPOSURE_PROGRAM   = 0x500e
+	DPC_EXPOSURE_INDEX     = 0x500f // ISO
+	DPC_EXPOSURE_BIAS      = 0x5010
+	DPC_CAPTURE_DELAY      = 0x5012
+	DPC_STILL_CAPTURE_MODE = 0x5013
+)
+
+// property data types
+const (
+	DTC_INT8   = 0x0001
+	DTC_UINT8  = 0x0002
+	DTC_INT16  = 0x0003
+	DTC_UINT16 = 0x0004
+	DTC_INT32  = 0x0005
+	DTC_UINT32 = 0x0006
+	DTC_INT64  = 0x0007
+	DTC_UINT64 = 0x0008
+	DTC_STR    = 0xffff
+)
+
+var ErrNotSupported = errors.New("ptp: not supported by this camera")
+
+// PropDesc describes a device property.  Integer properties use Current
+// and Allowed; DTC_STR properties use Str.
+type PropDesc struct {
+	Code     uint16
+	Type     uint16
+	Writable bool
+	Current  int64
+	Str      string
+	Allowed  []int64 // the enumeration, or min, max and step for a range
+	Range    bool
+}
+
+func (d *decoder) value(t uint16) (int64, string) {
+	switch t {
+	case DTC_INT8:
+		return int64(int8(d.u8())), ""
+	case DTC_UINT8:
+		return int64(d.u8()), ""
+	case DTC_INT16:
+		return int64(int16(d.u16())), ""
+	case DTC_UINT16:
+		return int64(d.u16()), ""
+	case DTC_INT32:
+		return int64(int32(d.u32())), ""
+	case DTC_UINT32:
+		return int64(d.u32()), ""
+	case DTC_INT64, DTC_UINT64:
+		return int64(d.u64()), ""
+	case DTC_STR:
+		return 0, d.str()
+	}
+	d.bad = true
+	return 0, ""
+}
+
+func encodeValue(t uint16, v int64) ([]byte, error) {
+	switch t {
+	case DTC_INT8, DTC_UINT8:
+		return []byte{uint8(v)}, nil
+	case DTC_INT16, DTC_UINT16:
+		return binary.LittleEndian.AppendUint16(nil, uint16(v)), nil
+	case DTC_INT32, DTC_UINT32:
+		return binary.LittleEndian.AppendUint32(nil, uint32(v)), nil
+	case DTC_INT64, DTC_UINT64:
+		return binary.LittleEndian.AppendUint64(nil, uint64(v)), nil
+	}
+	return nil, syscall.EINVAL
+}
+
+func (c *Conn) GetPropDesc(code uint16) (*PropDesc, error) {
+	b, _, e := c.Transaction(OC_GET_DEVICE_PROP_DESC, []uint32{uint32(code)}, nil)
+	if e != nil {
+		return nil, e
+	}
+	d := &decoder{b: b}
+	p := &PropDesc{Code: d.u16(), Type: d.u16()}
+	p.Writable = d.u8() != 0
+	d.value(p.Type) // factory default
+	p.Current, p.Str = d.value(p.Type)
+	switch d.u8() {
+	case 1:
+		p.Range = true
+		for i := 0; i < 3; i++ {
+			v, _ := d.value(p.Type)
+			p.Allowed = append(p.Allowed, v)
+		}
+	case 2:
+		for n := d.u16(); n > 0 && !d.bad; n-- {
+			v, _ := d.value(p.Type)
+			p.Allowed = append(p.Allowed, v)
+		}
+	}
+	if d.bad {
+		return nil, syscall.EPROTO
+	}
+	return p, nil
+}
+
+// Camera adds capture, live view and property helpers to a PTP session,
+// routing them through a vendor extension where the standard operations
+// don't cover what cameras actually do.
+type Camera struct {
+	*Conn
+	Info   *DeviceInfo
+	Vendor *Vendor // nil for a plain PTP camera
+	types  map[uint16]uint16
+}
+
+// Vendor holds the hooks for one vendor's extensions.  Any of them may be
+// nil, in which case the standard operation is used or the feature is
+// reported as unsupported.
+type Vendor struct {
+	Name          string
+	Init          func(c *Camera) error
+	Capture       func(c *Camera) error
+	StartLiveView func(c *Camera) error
+	LiveViewFrame func(c *Camera) ([]byte, error) // one JPEG frame
+	StopLiveView  func(c *Camera) error
+	SetProp       func(c *Camera, code uint16, value int64) error
+	// Events polls for events on cameras that don't use the interrupt
+	// endpoint for them.
+	Events func(c *Camera) ([]Event, error)
+	// Props maps the standard property codes to the vendor's own, for
+	// cameras that only expose ISO and shutter speed through those.
+	Props map[uint16]uint16
+}
+
+var vendors = map[uint32]*Vendor{}
+
+// RegisterVendor installs the hooks used for cameras reporting
+// VendorExtensionID id, replacing any already registered.
+func RegisterVendor(id uint32, v *Vendor) {
+	vendors[id] = v
+}
+
+// OpenCamera opens a session and sets the camera up for remote control.
+func OpenCamera(c *Conn) (*Camera, error) {
+	if e := c.OpenSession(); e != nil {
+		return nil, e
+	}
+	di, e := c.GetDeviceInfo()
+	if e != nil {
+		return nil, e
+	}
+	cam := &Camera{Conn: c, Info: di, Vendor: vendors[di.VendorExtensionID], types: map[uint16]uint16{}}
+	if cam.Vendor != nil && cam.Vendor.Init != nil {
+		if e := cam.Vendor.Init(cam); e != nil {
+			return nil, e
+		}
+	}
+	return cam, nil
+}
+
+func (c *Camera) prop(code uint16) uint16 {
+	if c.Vendor != nil {
+		if v, ok := c.Vendor.Props[code]; ok {
+			return v
+		}
+	}
+	return code
+}
+
+// GetProp returns a property's description and current value.
+func (c *Camera) GetProp(code uint16) (*PropDesc, error) {
+	p, e := c.GetPropDesc(c.prop(code))
+	if e == nil {
+		c.types[p.Code] = p.Type
+	}
+	return p, e
+}
+
+// SetProp sets an integer property, looking up its type first if needed.
+func (c *Camera) SetProp(code uint16, value int64) error {
+	code = c.prop(code)
+	if c.Vendor != nil && c.Vendor.SetProp != nil {
+		return c.Vendor.SetProp(c, code, value)
+	}
+	t, ok := c.types[code]
+	if !ok {
+		p, e := c.GetPropDesc(code)
+		if e != nil {
+			return e
+		}
+		t = p.Type
+		c.types[code] = t
+	}
+	b, e := encodeValue(t, value)
+	if e != nil {
+		return e
+	}
+	_, _, e = c.Transaction(OC_SET_DEVICE_PROP_VALUE, []uint32{uint32(code)}, b)
+	return e
+}
+
+func (c *Camera) ISO() (int64, error) {
+	p, e := c.GetProp(DPC_EXPOSURE_INDEX)
+	if e != nil {
+		return 0, e
+	}
+	return p.Current, nil
+}
+
+func (c *Camera) SetISO(iso int64) error {
+	return c.SetProp(DPC_EXPOSURE_INDEX, iso)
+}
+
+// Shutter returns the exposure time in the camera's own units: 1/10000 s
+// for standard PTP, vendor codes otherwise.
+func (c *Camera) Shutter() (int64, error) {
+	p, e := c.GetProp(DPC_EXPOSURE_TIME)
+	if e != nil {
+		return 0, e
+	}
+	return p.Current, nil
+}
+
+func (c *Camera) SetShutter(t int64) error {
+	return c.SetProp(DPC_EXPOSURE_TIME, t)
+}
+
+// Capture releases the shutter and returns the handles of the objects it
+// produced, waiting up to timeout for the camera to report them.
+func (c *Camera) Capture(timeout time.Duration) ([]uint32, error) {
+	var e error
+	if c.Vendor != nil && c.Vendor.Capture != nil {
+		e = c.Vendor.Capture(c)
+	} else {
+		_, _, e = c.Transaction(OC_INITIATE_CAPTURE, []uint32{0, 0}, nil)
+	}
+	if e != nil {
+		return nil, e
+	}
+	var handles []uint32
+	deadline := time.Now().Add(timeout)
+	for {
+		wait := time.Until(deadline)
+		if len(handles) > 0 && c.Vendor != nil && c.Vendor.Events != nil {
+			wait = min(wait, time.Second)
+		}
+		evs, e := c.events(wait)
+		if e != nil {
+			if errors.Is(e, syscall.ETIMEDOUT) && len(handles) > 0 {
+				return handles, nil
+			}
+			return handles, e
+		}
+		for _, ev := range evs {
+			switch ev.Code {
+			case EC_OBJECT_ADDED:
+				if len(ev.Params) > 0 {
+					handles = append(handles, ev.Params[0])
+				}
+			case EC_CAPTURE_COMPLETE:
+				return handles, nil
+			}
+		}
+	}
+}
+
+// events waits for the next events from the interrupt endpoint or the
+// vendor's event poll.  Cameras that poll don't send CaptureComplete, so
+// a second of quiet after an object arrives also ends a capture.
+func (c *Camera) events(timeout time.Duration) ([]Event, error) {
+	if c.Vendor == nil || c.Vendor.Events == nil {
+		ev, e := c.Event(timeout)
+		if e != nil {
+			return nil, e
+		}
+		return []Event{*ev}, nil
+	}
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		evs, e := c.Vendor.Events(c)
+		if e != nil || len(evs) > 0 {
+			return evs, e
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return nil, syscall.ETIMEDOUT
+}
+
+// StartLiveView turns on the camera's live view output.
+func (c *Camera) StartLiveView() error {
+	if c.Vendor == nil || c.Vendor.StartLiveView == nil {
+		return ErrNotSupported
+	}
+	return c.Vendor.StartLiveView(c)
+}
+
+// LiveViewFrame returns the current live view frame as a JPEG.
+func (c *Camera) LiveViewFrame() ([]byte, error) {
+	if c.Vendor == nil || c.Vendor.LiveViewFrame == nil {
+		return nil, ErrNotSupported
+	}
+	return c.Vendor.LiveViewFrame(c)
+}
+
+func (c *Camera) StopLiveView() error {
+	if c.Vendor == nil || c.Vendor.StopLiveView == nil {
+		return ErrNotSupported
+	}
+	return c.Vendor.StopLiveView(c)
+}
+
+// jpeg trims whatever a vendor puts before and after the image
+func jpeg(b []byte) ([]byte, error) {
+	i := bytes.Index(b, []byte{0xff, 0xd8})
+	j := bytes.LastIndex(b, []byte{0xff, 0xd9})
+	if i < 0 || j < i {
+		return nil, syscall.EPROTO
+	}
+	return b[i : j+2], nil
+}
@@ -0,0 +1,406 @@
+// Package ptp implements the Picture Transfer Protocol (ISO 15740) over the
+// USB Still Image class, as spoken by digital cameras.
+package ptp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"syscall"
+	"time"
+	"unicode/utf16"
+
+	"github.com/richardnwinder/usb"
+)
+
+const (
+	CLASS_STILL_IMAGE = 0x06
+
+	// container types
+	CONTAINER_COMMAND  = 1
+	CONTAINER_DATA     = 2
+	CONTAINER_RESPONSE = 3
+	CONTAINER_EVENT    = 4
+
+	// operations
+	OC_GET_DEVICE_INFO        = 0x1001
+	OC_OPEN_SESSION           = 0x1002
+	OC_CLOSE_SESSION          = 0x1003
+	OC_GET_STORAGE_IDS        = 0x1004
+	OC_GET_OBJECT_HANDLES     = 0x1007
+	OC_GET_OBJECT_INFO        = 0x1008
+	OC_GET_OBJECT             = 0x1009
+	OC_DELETE_OBJECT          = 0x100b
+	OC_INITIATE_CAPTURE       = 0x100e
+	OC_GET_DEVICE_PROP_DESC   = 0x1014
+	OC_GET_DEVICE_PROP_VALUE  = 0x1015
+	OC_SET_DEVICE_PROP_VALUE  = 0x1016
+	OC_TERMINATE_OPEN_CAPTURE = 0x1018
+
+	// responses
+	RC_OK                      = 0x2001
+	RC_GENERAL_ERROR           = 0x2002
+	RC_SESSION_NOT_OPEN        = 0x2003
+	RC_OPERATION_NOT_SUPPORTED = 0x2005
+	RC_DEVICE_BUSY             = 0x2019
+	RC_SESSION_ALREADY_OPEN    = 0x201e
+
+	// events
+	EC_OBJECT_ADDED        = 0x4002
+	EC_DEVICE_PROP_CHANGED = 0x4006
+	EC_CAPTURE_COMPLETE    = 0x400d
+
+	// class requests
+	REQ_CANCEL            = 0x64
+	REQ_DEVICE_RESET      = 0x66
+	REQ_GET_DEVICE_STATUS = 0x67
+
+	// vendor extension IDs from DeviceInfo
+	VENDOR_MICROSOFT = 0x00000006
+	VENDOR_NIKON     = 0x0000000a
+	VENDOR_CANON     = 0x0000000b
+
+	headerSize = 12
+)
+
+// Error is a response code other than RC_OK.
+type Error uint16
+
+func (e Error) Error() string {
+	return fmt.Sprintf("ptp: response 0x%04x", uint16(e))
+}
+
+// Conn carries PTP transactions over a Still Image interface.  It is not
+// safe for concurrent use.
+type Conn struct {
+	dev     *usb.Device
+	ifc     uint8
+	in      uint8
+	out     uint8
+	intr    uint8
+	outSize int
+	tid     uint32
+	session uint32
+	Timeout uint32 // ms
+}
+
+// Dial claims the Still Image interface ii, detaching any kernel driver.
+func Dial(dev *usb.Device, ii *usb.InterfaceInfo) (*Conn, error) {
+	if ii.InterfaceClass != CLASS_STILL_IMAGE {
+		return nil, syscall.EINVAL
+	}
+	c := &Conn{dev: dev, ifc: ii.InterfaceNumber, Timeout: 10000}
+	for _, ep := range ii.Endpoint {
+		switch ep.Attributes & usb.ENDPOINT_XFER_MASK {
+		case usb.ENDPOINT_XFER_BULK:
+			if ep.EndpointAddress&usb.ENDPOINT_IN != 0 {
+				c.in = ep.EndpointAddress
+			} else {
+				c.out = ep.EndpointAddress
+				c.outSize = int(ep.MaxPacketSize & 0x7ff)
+			}
+		case usb.ENDPOINT_XFER_INT:
+			c.intr = ep.EndpointAddress
+		}
+	}
+	if c.in == 0 || c.out == 0 || c.outSize == 0 {
+		return nil, syscall.ENODEV
+	}
+	e := dev.ClaimInterface(uint32(c.ifc))
+	if errors.Is(e, syscall.EBUSY) {
+		dev.DisconnectDriver(c.ifc)
+		e = dev.ClaimInterface(uint32(c.ifc))
+	}
+	if e != nil {
+		return nil, e
+	}
+	return c, nil
+}
+
+func (c *Conn) Close() error {
+	if c.session != 0 {
+		c.CloseSession()
+	}
+	return c.dev.ReleaseInterface(uint32(c.ifc))
+}
+
+func (c *Conn) container(kind uint16, code uint16, payload []byte) []byte {
+	b := make([]byte, headerSize, headerSize+len(payload))
+	binary.LittleEndian.PutUint32(b[0:], uint32(headerSize+len(payload)))
+	binary.LittleEndian.PutUint16(b[4:], kind)
+	binary.LittleEndian.PutUint16(b[6:], code)
+	binary.LittleEndian.PutUint32(b[8:], c.tid)
+	return append(b, payload...)
+}
+
+func (c *Conn) send(b []byte) error {
+	if _, _, e := c.dev.BulkTransfer(uint32(c.out), uint32(len(b)), c.Timeout, b); e != nil {
+		return e
+	}
+	if len(b)%c.outSize == 0 {
+		_, _, e := c.dev.BulkTransfer(uint32(c.out), 0, c.Timeout, nil)
+		return e
+	}
+	return nil
+}
+
+// receive reads one container from the bulk IN endpoint
+func (c *Conn) receive() (uint16, uint16, []byte, error) {
+	buf := make([]byte, 64*1024)
+	var n int
+	var e error
+	// skip the zero length packet that ends a data phase filling whole
+	// packets
+	for n == 0 {
+		if n, _, e = c.dev.BulkTransfer(uint32(c.in), uint32(len(buf)), c.Timeout, buf); e != nil {
+			return 0, 0, nil, e
+		}
+	}
+	if n < headerSize {
+		return 0, 0, nil, syscall.EPROTO
+	}
+	size := int64(binary.LittleEndian.Uint32(buf))
+	kind := binary.LittleEndian.Uint16(buf[4:])
+	code := binary.LittleEndian.Uint16(buf[6:])
+	if size < headerSize || binary.LittleEndian.Uint32(buf[8:]) != c.tid {
+		return 0, 0, nil, syscall.EPROTO
+	}
+	// objects can be hundreds of megabytes; 0xffffffff means "read until
+	// a short packet"
+	data := append(make([]byte, 0, int(min(size, 1<<24))), buf[:n]...)
+	for n == len(buf) && (size == 0xffffffff || int64(len(data)) < size) {
+		if n, _, e = c.dev.BulkTransfer(uint32(c.in), uint32(len(buf)), c.Timeout, buf); e != nil {
+			return 0, 0, nil, e
+		}
+		data = append(data, buf[:n]...)
+	}
+	return kind, code, data[headerSize:], nil
+}
+
+// Transaction runs operation op with up to five parameters.  out, if not
+// nil, is sent as the data phase; a data phase from the device is
+// returned.  The response parameters come back with any error.
+func (c *Conn) Transaction(op uint16, params []uint32, out []byte) ([]byte, []uint32, error) {
+	if len(params) > 5 {
+		return nil, nil, syscall.EINVAL
+	}
+	c.tid++
+	if op == OC_OPEN_SESSION {
+		c.tid = 0
+	}
+	p := make([]byte, 4*len(params))
+	for i, v := range params {
+		binary.LittleEndian.PutUint32(p[4*i:], v)
+	}
+	if e := c.send(c.container(CONTAINER_COMMAND, op, p)); e != nil {
+		return nil, nil, e
+	}
+	if out != nil {
+		if e := c.send(c.container(CONTAINER_DATA, op, out)); e != nil {
+			return nil, nil, e
+		}
+	}
+	var data []byte
+	for {
+		kind, code, payload, e := c.receive()
+		if e != nil {
+			return nil, nil, e
+		}
+		switch kind {
+		case CONTAINER_DATA:
+			data = payload
+			continue
+		case CONTAINER_RESPONSE:
+			resp := make([]uint32, len(payload)/4)
+			for i := range resp {
+				resp[i] = binary.LittleEndian.Uint32(payload[4*i:])
+			}
+			if code != RC_OK {
+				return data, resp, Error(code)
+			}
+			return data, resp, nil
+		default:
+			return nil, nil, syscall.EPROTO
+		}
+	}
+}
+
+// OpenSession opens session 1, which most operations need.
+func (c *Conn) OpenSession() error {
+	_, _, e := c.Transaction(OC_OPEN_SESSION, []uint32{1}, nil)
+	if e == Error(RC_SESSION_ALREADY_OPEN) {
+		e = nil
+	}
+	if e == nil {
+		c.session = 1
+	}
+	return e
+}
+
+func (c *Conn) CloseSession() error {
+	c.session = 0
+	_, _, e := c.Transaction(OC_CLOSE_SESSION, nil, nil)
+	return e
+}
+
+// Event is an asynchronous notification from the interrupt endpoint.
+type Event struct {
+	Code   uint16
+	Params []uint32
+}
+
+// Event waits up to timeout for the next event.
+func (c *Conn) Event(timeout time.Duration) (*Event, error) {
+	if c.intr == 0 {
+		return nil, syscall.EOPNOTSUPP
+	}
+	buf := make([]byte, 64)
+	n, _, e := c.dev.BulkTransfer(uint32(c.intr), uint32(len(buf)), uint32(max(timeout.Milliseconds(), 1)), buf)
+	if e != nil {
+		return nil, e
+	}
+	if n < headerSize || binary.LittleEndian.Uint16(buf[4:]) != CONTAINER_EVENT {
+		return nil, syscall.EPROTO
+	}
+	ev := &Event{Code: binary.LittleEndian.Uint16(buf[6:])}
+	for p := buf[headerSize:n]; len(p) >= 4; p = p[4:] {
+		ev.Params = append(ev.Params, binary.LittleEndian.Uint32(p))
+	}
+	return ev, nil
+}
+
+// decoder reads the PTP dataset encodings
+type decoder struct {
+	b   []byte
+	bad bool
+}
+
+func (d *decoder) take(n int) []byte {
+	if len(d.b) < n {
+		d.bad = true
+		d.b = nil
+		return make([]byte, n)
+	}
+	p := d.b[:n]
+	d.b = d.b[n:]
+	return p
+}
+
+func (d *decoder) u8() uint8   { return d.take(1)[0] }
+func (d *decoder) u16() uint16 { return binary.LittleEndian.Uint16(d.take(2)) }
+func (d *decoder) u32() uint32 { return binary.LittleEndian.Uint32(d.take(4)) }
+func (d *decoder) u64() uint64 { return binary.LittleEndian.Uint64(d.take(8)) }
+
+func (d *decoder) str() string {
+	n := int(d.u8())
+	u := make([]uint16, 0, n)
+	for i := 0; i < n; i++ {
+		u = append(u, d.u16())
+	}
+	// the count includes the terminating NUL
+	if len(u) > 0 && u[len(u)-1] == 0 {
+		u = u[:len(u)-1]
+	}
+	return string(utf16.Decode(u))
+}
+
+func (d *decoder) u16s() []uint16 {
+	n := d.u32()
+	if uint64(n)*2 > uint64(len(d.b)) {
+		d.bad = true
+		return nil
+	}
+	a := make([]uint16, n)
+	for i := range a {
+		a[i] = d.u16()
+	}
+	return a
+}
+
+func (d *decoder) u32s() []uint32 {
+	n := d.u32()
+	if uint64(n)*4 > uint64(len(d.b)) {
+		d.bad = true
+		return nil
+	}
+	a := make([]uint32, n)
+	for i := range a {
+		a[i] = d.u32()
+	}
+	return a
+}
+
+func encodeString(s string) []byte {
+	if s == "" {
+		return []byte{0}
+	}
+	u := append(utf16.Encode([]rune(s)), 0)
+	b := []byte{uint8(len(u))}
+	for _, c := range u {
+		b = binary.LittleEndian.AppendUint16(b, c)
+	}
+	return b
+}
+
+type DeviceInfo struct {
+	StandardVersion   uint16
+	VendorExtensionID uint32
+	VendorExtension   string
+	Operations        []uint16
+	Events            []uint16
+	Properties        []uint16
+	CaptureFormats    []uint16
+	ImageFormats      []uint16
+	Manufacturer      string
+	Model             string
+	DeviceVersion     string
+	SerialNumber      string
+}
+
+// Supports reports whether the device lists operation op.
+func (di *DeviceInfo) Supports(op uint16) bool {
+	for _, o := range di.Operations {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Conn) GetDeviceInfo() (*DeviceInfo, error) {
+	b, _, e := c.Transaction(OC_GET_DEVICE_INFO, nil, nil)
+	if e != nil {
+		return nil, e
+	}
+	d := &decoder{b: b}
+	di := &DeviceInfo{}
+	di.StandardVersion = d.u16()
+	di.VendorExtensionID = d.u32()
+	d.u16() // VendorExtensionVersion
+	di.VendorExtension = d.str()
+	d.u16() // FunctionalMode
+	di.Operations = d.u16s()
+	di.Events = d.u16s()
+	di.Properties = d.u16s()
+	di.CaptureFormats = d.u16s()
+	di.ImageFormats = d.u16s()
+	di.Manufacturer = d.str()
+	di.Model = d.str()
+	di.DeviceVersion = d.str()
+	di.SerialNumber = d.str()
+	if d.bad {
+		return nil, syscall.EPROTO
+	}
+	return di, nil
+}
+
+// GetObject returns the contents of object handle.
+func (c *Conn) GetObject(handle uint32) ([]byte, error) {
+	b, _, e := c.Transaction(OC_GET_OBJECT, []uint32{handle}, nil)
+	return b, e
+}
+
+func (c *Conn) DeleteObject(handle uint32) error {
+	_, _, e := c.Transaction(OC_DELETE_OBJECT, []uint32{handle, 0}, nil)
+	return e
+}
@@ -0,0 +1,172 @@
+package ptp
+
+import (
+	"encoding/binary"
+	"syscall"
+	"time"
+)
+
+// Nikon operations
+const (
+	NIKON_INITIATE_CAPTURE_REC_IN_SDRAM = 0x90c0
+	NIKON_AF_DRIVE                      = 0x90c1
+	NIKON_DEVICE_READY                  = 0x90c8
+	NIKON_START_LIVE_VIEW               = 0x9201
+	NIKON_END_LIVE_VIEW                 = 0x9202
+	NIKON_GET_LIVE_VIEW_IMAGE           = 0x9203
+)
+
+// Canon EOS operations and properties
+const (
+	CANON_EOS_REMOTE_RELEASE           = 0x910f
+	CANON_EOS_SET_DEVICE_PROP_VALUE_EX = 0x9110
+	CANON_EOS_SET_REMOTE_MODE          = 0x9114
+	CANON_EOS_SET_EVENT_MODE           = 0x9115
+	CANON_EOS_GET_EVENT                = 0x9116
+	CANON_EOS_GET_VIEWFINDER_DATA      = 0x9153
+
+	CANON_EOS_EVENT_OBJECT_ADDED_EX = 0xc181
+
+	CANON_EOS_PROP_APERTURE   = 0xd101
+	CANON_EOS_PROP_SHUTTER    = 0xd102
+	CANON_EOS_PROP_ISO        = 0xd103
+	CANON_EOS_PROP_EVF_OUTPUT = 0xd1b0
+)
+
+func init() {
+	RegisterVendor(VENDOR_NIKON, &Vendor{
+		Name:          "Nikon",
+		StartLiveView: nikonStartLiveView,
+		LiveViewFrame: nikonLiveViewFrame,
+		StopLiveView: func(c *Camera) error {
+			_, _, e := c.Transaction(NIKON_END_LIVE_VIEW, nil, nil)
+			return e
+		},
+	})
+	RegisterVendor(VENDOR_CANON, &Vendor{
+		Name:    "Canon",
+		Init:    canonInit,
+		Capture: canonCapture,
+		StartLiveView: func(c *Camera) error {
+			return canonSetProp(c, CANON_EOS_PROP_EVF_OUTPUT, 2) // to the PC
+		},
+		LiveViewFrame: canonLiveViewFrame,
+		StopLiveView: func(c *Camera) error {
+			return canonSetProp(c, CANON_EOS_PROP_EVF_OUTPUT, 0)
+		},
+		SetProp: canonSetProp,
+		Events:  canonEvents,
+		Props: map[uint16]uint16{
+			DPC_F_NUMBER:       CANON_EOS_PROP_APERTURE,
+			DPC_EXPOSURE_TIME:  CANON_EOS_PROP_SHUTTER,
+			DPC_EXPOSURE_INDEX: CANON_EOS_PROP_ISO,
+		},
+	})
+}
+
+// nikonReady waits out the busy responses Nikon bodies give while they
+// switch modes
+func nikonReady(c *Camera) error {
+	for i := 0; i < 50; i++ {
+		_, _, e := c.Transaction(NIKON_DEVICE_READY, nil, nil)
+		if e != Error(RC_DEVICE_BUSY) {
+			return e
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return Error(RC_DEVICE_BUSY)
+}
+
+func nikonStartLiveView(c *Camera) error {
+	if _, _, e := c.Transaction(NIKON_START_LIVE_VIEW, nil, nil); e != nil {
+		return e
+	}
+	return nikonReady(c)
+}
+
+func nikonLiveViewFrame(c *Camera) ([]byte, error) {
+	b, _, e := c.Transaction(NIKON_GET_LIVE_VIEW_IMAGE, nil, nil)
+	if e != nil {
+		return nil, e
+	}
+	// the frame follows a model dependent header
+	return jpeg(b)
+}
+
+func canonInit(c *Camera) error {
+	if !c.Info.Supports(CANON_EOS_SET_REMOTE_MODE) {
+		// a PowerShot, which speaks enough standard PTP
+		c.Vendor = nil
+		return nil
+	}
+	if _, _, e := c.Transaction(CANON_EOS_SET_REMOTE_MODE, []uint32{1}, nil); e != nil {
+		return e
+	}
+	_, _, e := c.Transaction(CANON_EOS_SET_EVENT_MODE, []uint32{1}, nil)
+	return e
+}
+
+func canonCapture(c *Camera) error {
+	_, _, e := c.Transaction(CANON_EOS_REMOTE_RELEASE, nil, nil)
+	return e
+}
+
+// canonSetProp sets an EOS property, which takes a size, code, value
+// record rather than SetDevicePropValue
+func canonSetProp(c *Camera, code uint16, value int64) error {
+	b := make([]byte, 12)
+	binary.LittleEndian.PutUint32(b[0:], 12)
+	binary.LittleEndian.PutUint32(b[4:], uint32(code))
+	binary.LittleEndian.PutUint32(b[8:], uint32(value))
+	_, _, e := c.Transaction(CANON_EOS_SET_DEVICE_PROP_VALUE_EX, nil, b)
+	return e
+}
+
+func canonLiveViewFrame(c *Camera) ([]byte, error) {
+	for i := 0; i < 20; i++ {
+		b, _, e := c.Transaction(CANON_EOS_GET_VIEWFINDER_DATA, []uint32{0x00200000}, nil)
+		if e == Error(RC_DEVICE_BUSY) || e == nil && len(b) == 0 {
+			// no frame ready yet
+			time.Sleep(50 * time.Millisecond)
+			continue
+		}
+		if e != nil {
+			return nil, e
+		}
+		// a list of (length, type, data) records; type 1 is the JPEG
+		for len(b) >= 8 {
+			n := int(binary.LittleEndian.Uint32(b))
+			if n < 8 || n > len(b) {
+				break
+			}
+			if binary.LittleEndian.Uint32(b[4:]) == 1 {
+				return b[8:n], nil
+			}
+			b = b[n:]
+		}
+		return nil, syscall.EPROTO
+	}
+	return nil, Error(RC_DEVICE_BUSY)
+}
+
+// canonEvents reads the records GetEvent returns: length, type and a type
+// dependent body, ended by a record of type 0
+func canonEvents(c *Camera) ([]Event, error) {
+	b, _, e := c.Transaction(CANON_EOS_GET_EVENT, nil, nil)
+	if e != nil {
+		return nil, e
+	}
+	var evs []Event
+	for len(b) >= 8 {
+		n := int(binary.LittleEndian.Uint32(b))
+		t := binary.LittleEndian.Uint32(b[4:])
+		if n < 8 || n > len(b) || t == 0 {
+			break
+		}
+		if t == CANON_EOS_EVENT_OBJECT_ADDED_EX && n >= 12 {
+			evs = append(evs, Event{EC_OBJECT_ADDED, []uint32{binary.LittleEndian.Uint32(b[8:])}})
+		}
+		b = b[n:]
+	}
+	return evs, nil
+}
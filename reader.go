@@ -0,0 +1,47 @@
+package usb
+
+import (
+	"context"
+	"syscall"
+)
+
+// BulkReader is an EndpointReader on a bulk or interrupt IN endpoint,
+// one synchronous transfer for each Read.  A Read gets what the device
+// sent in that transfer, which is less than len(p) at a short packet.  A
+// zero length packet carries nothing for an io.Reader to return, so Read
+// goes on to the next transfer; protocols that need to see one should
+// use BulkTransfer.
+type BulkReader struct {
+	dev     *Device
+	ep      uint8
+	timeout uint32
+}
+
+// NewBulkReader reads from the IN endpoint with address endpoint, each
+// transfer timing out after timeout milliseconds, 0 for none.
+func (u *Device) NewBulkReader(endpoint uint8, timeout uint32) (*BulkReader, error) {
+	if endpoint&ENDPOINT_IN == 0 {
+		return nil, syscall.EINVAL
+	}
+	return &BulkReader{dev: u, ep: endpoint, timeout: timeout}, nil
+}
+
+func (r *BulkReader) Read(p []byte) (int, error) {
+	return r.ReadContext(context.Background(), p)
+}
+
+// ReadContext is Read, not starting a transfer once ctx is done.
+func (r *BulkReader) ReadContext(ctx context.Context, p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	for {
+		if e := ctx.Err(); e != nil {
+			return 0, e
+		}
+		n, _, e := r.dev.BulkTransferContext(ctx, uint32(r.ep), uint32(len(p)), r.timeout, p)
+		if n > 0 || e != nil {
+			return n, e
+		}
+	}
+}
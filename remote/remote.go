@@ -0,0 +1,364 @@
+// Package remote drives devices attached to another machine.  An agent
+// next to the devices runs a Server; a Client dials it over TLS, or
+// starts it through ssh with DialSSH, authenticates with a token, and
+// gets Devices that behave like local ones.  Each call is one round
+// trip, so it suits control, configuration and moderate bulk traffic
+// rather than streaming.
+package remote
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"syscall"
+
+	"github.com/richardnwinder/usb"
+)
+
+// Backend is the set of device operations that crosses the network.
+// *usb.Device and *Device both implement it.
+type Backend = usb.Conn
+
+var (
+	_ Backend    = (*usb.Device)(nil)
+	_ Backend    = (*Device)(nil)
+	_ usb.Opener = (*Client)(nil)
+)
+
+// operations
+const (
+	OP_LIST       = 1
+	OP_OPEN       = 2
+	OP_CLOSE      = 3
+	OP_CONTROL    = 4
+	OP_BULK       = 5
+	OP_CLAIM      = 6
+	OP_RELEASE    = 7
+	OP_SET_CONFIG = 8
+	OP_SET_ALT    = 9
+	OP_CLEAR_HALT = 10
+	OP_RESET      = 11
+)
+
+// Every message is a frame: a 32-bit big-endian length and then the
+// payload.  The client opens with a hello frame holding the magic and
+// its token and the server answers with one status byte.  After that a
+// request is id, op and handle followed by the op's arguments, and a
+// response is the id, an errno, an error text and the result, all little
+// endian.
+const (
+	magic    = "USBR1"
+	maxFrame = 16 << 20
+	maxHello = 4096 // the most a server reads from a client it hasn't authenticated
+)
+
+var ErrDenied = errors.New("remote: token rejected")
+
+func writeFrame(w io.Writer, b []byte) error {
+	f := binary.BigEndian.AppendUint32(make([]byte, 0, 4+len(b)), uint32(len(b)))
+	_, e := w.Write(append(f, b...))
+	return e
+}
+
+// readFrame reads a frame of up to max bytes
+func readFrame(r io.Reader, max uint32) ([]byte, error) {
+	var h [4]byte
+	if _, e := io.ReadFull(r, h[:]); e != nil {
+		return nil, e
+	}
+	n := binary.BigEndian.Uint32(h[:])
+	if n > max {
+		return nil, syscall.EMSGSIZE
+	}
+	b := make([]byte, n)
+	_, e := io.ReadFull(r, b)
+	return b, e
+}
+
+// decoder takes little-endian fields off the front of a payload; running
+// short leaves zeros and sets bad
+type decoder struct {
+	b   []byte
+	bad bool
+}
+
+func (d *decoder) take(n int) []byte {
+	if len(d.b) < n {
+		d.bad = true
+		d.b = nil
+		return make([]byte, n)
+	}
+	v := d.b[:n]
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *decoder) u8() uint8   { return d.take(1)[0] }
+func (d *decoder) u16() uint16 { return binary.LittleEndian.Uint16(d.take(2)) }
+func (d *decoder) u32() uint32 { return binary.LittleEndian.Uint32(d.take(4)) }
+
+func (d *decoder) bytes() []byte {
+	n := d.u32()
+	return append([]byte(nil), d.take(int(n))...)
+}
+
+func appendBytes(b []byte, v []byte) []byte {
+	return append(binary.LittleEndian.AppendUint32(b, uint32(len(v))), v...)
+}
+
+// Client is a connection to a Server.  It is safe for concurrent use;
+// calls from different goroutines are in flight together.
+type Client struct {
+	conn  net.Conn
+	wlock sync.Mutex
+	lock  sync.Mutex
+	next  uint32
+	wait  map[uint32]chan []byte
+	err   error
+}
+
+// Dial connects to a server over TLS.
+func Dial(addr string, cfg *tls.Config, token string) (*Client, error) {
+	conn, e := tls.Dial("tcp", addr, cfg)
+	if e != nil {
+		return nil, e
+	}
+	c, e := NewClient(conn, token)
+	if e != nil {
+		conn.Close()
+	}
+	return c, e
+}
+
+// NewClient authenticates on an established connection, for transports
+// other than plain TLS over TCP.
+func NewClient(conn net.Conn, token string) (*Client, error) {
+	if e := writeFrame(conn, append([]byte(magic), token...)); e != nil {
+		return nil, e
+	}
+	b, e := readFrame(conn, maxFrame)
+	if e != nil {
+		return nil, e
+	}
+	if len(b) != 1 || b[0] != 0 {
+		return nil, ErrDenied
+	}
+	c := &Client{conn: conn, wait: map[uint32]chan []byte{}}
+	go c.receive()
+	return c, nil
+}
+
+func (c *Client) receive() {
+	for {
+		b, e := readFrame(c.conn, maxFrame)
+		if e != nil {
+			c.lock.Lock()
+			c.err = e
+			for id, ch := range c.wait {
+				close(ch)
+				delete(c.wait, id)
+			}
+			c.lock.Unlock()
+			return
+		}
+		if len(b) < 4 {
+			continue
+		}
+		id := binary.LittleEndian.Uint32(b)
+		c.lock.Lock()
+		ch := c.wait[id]
+		delete(c.wait, id)
+		c.lock.Unlock()
+		if ch != nil {
+			ch <- b[4:]
+		}
+	}
+}
+
+// call sends one request and waits for its response, decoding the error
+func (c *Client) call(op uint8, handle uint32, args []byte) (*decoder, error) {
+	ch := make(chan []byte, 1)
+	c.lock.Lock()
+	if c.err != nil {
+		c.lock.Unlock()
+		return nil, c.err
+	}
+	c.next++
+	id := c.next
+	c.wait[id] = ch
+	c.lock.Unlock()
+	req := binary.LittleEndian.AppendUint32(nil, id)
+	req = append(req, op)
+	req = binary.LittleEndian.AppendUint32(req, handle)
+	c.wlock.Lock()
+	e := writeFrame(c.conn, append(req, args...))
+	c.wlock.Unlock()
+	if e != nil {
+		return nil, e
+	}
+	b, ok := <-ch
+	if !ok {
+		return nil, c.err
+	}
+	d := &decoder{b: b}
+	errno := syscall.Errno(d.u32())
+	msg := string(d.take(int(d.u16())))
+	switch {
+	case d.bad:
+		return nil, syscall.EPROTO
+	case errno != 0:
+		return d, errno
+	case msg != "":
+		return d, errors.New("remote: " + msg)
+	}
+	return d, nil
+}
+
+// ListDevices returns the devices the server lets us see.  Their
+// descriptors are filled in, along with the bus, device and port
+// numbers on the server.
+func (c *Client) ListDevices() ([]*usb.DeviceInfo, error) {
+	d, e := c.call(OP_LIST, 0, nil)
+	if e != nil {
+		return nil, e
+	}
+	var list []*usb.DeviceInfo
+	for n := d.u16(); n > 0 && !d.bad; n-- {
+		bus, dev := d.u16(), d.u16()
+		var ports []int
+		for np := d.u8(); np > 0; np-- {
+			ports = append(ports, int(d.u8()))
+		}
+		di, e := usb.ParseDescriptors(d.bytes())
+		if e != nil {
+			continue
+		}
+		di.BusNum, di.DevNum, di.Ports = int(bus), int(dev), ports
+		list = append(list, di)
+	}
+	if d.bad {
+		return nil, syscall.EPROTO
+	}
+	return list, nil
+}
+
+// Open opens device bus:dev on the server.
+func (c *Client) Open(bus int, dev int) (*Device, error) {
+	args := binary.LittleEndian.AppendUint16(nil, uint16(bus))
+	args = binary.LittleEndian.AppendUint16(args, uint16(dev))
+	d, e := c.call(OP_OPEN, 0, args)
+	if e != nil {
+		return nil, e
+	}
+	return &Device{c: c, handle: d.u32()}, nil
+}
+
+// OpenDevice opens a device ListDevices returned, for code written
+// against usb.Opener.
+func (c *Client) OpenDevice(di *usb.DeviceInfo) (usb.Conn, error) {
+	d, e := c.Open(di.BusNum, di.DevNum)
+	if e != nil {
+		return nil, e
+	}
+	return d, nil
+}
+
+// Close drops the connection; the server closes every device it had
+// open for us.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Device is a device open on a server.
+type Device struct {
+	c      *Client
+	handle uint32
+}
+
+func (u *Device) simple(op uint8, args ...byte) error {
+	_, e := u.c.call(op, u.handle, args)
+	return e
+}
+
+func u32(v uint32) []byte {
+	return binary.LittleEndian.AppendUint32(nil, v)
+}
+
+func (u *Device) ControlTransfer(
+	reqtype uint8, request uint8, value uint16, index uint16,
+	length uint16, timeout uint32, data []byte) (int, error) {
+
+	if int(length) > len(data) {
+		return 0, syscall.ENOSPC
+	}
+	args := []byte{reqtype, request}
+	args = binary.LittleEndian.AppendUint16(args, value)
+	args = binary.LittleEndian.AppendUint16(args, index)
+	args = binary.LittleEndian.AppendUint16(args, length)
+	args = binary.LittleEndian.AppendUint32(args, timeout)
+	if reqtype&usb.REQTYPE_IN == 0 {
+		args = append(args, data[:length]...)
+	}
+	d, e := u.c.call(OP_CONTROL, u.handle, args)
+	if d == nil {
+		return 0, e
+	}
+	n := int(d.u32())
+	if reqtype&usb.REQTYPE_IN != 0 {
+		n = copy(data[:length], d.b)
+	}
+	return n, e
+}
+
+func (u *Device) BulkTransfer(endpoint uint32, length uint32, timeout uint32, data []byte) (int, []byte, error) {
+	if int(length) > len(data) {
+		return 0, nil, syscall.ENOSPC
+	}
+	args := binary.LittleEndian.AppendUint32(nil, endpoint)
+	args = binary.LittleEndian.AppendUint32(args, length)
+	args = binary.LittleEndian.AppendUint32(args, timeout)
+	in := endpoint&usb.ENDPOINT_IN != 0
+	if !in {
+		args = append(args, data[:length]...)
+	}
+	d, e := u.c.call(OP_BULK, u.handle, args)
+	if d == nil {
+		return 0, nil, e
+	}
+	n := int(d.u32())
+	if in {
+		n = copy(data[:length], d.b)
+	}
+	return n, append([]byte(nil), data[:n]...), e
+}
+
+func (u *Device) ClaimInterface(n uint32) error {
+	return u.simple(OP_CLAIM, u32(n)...)
+}
+
+func (u *Device) ReleaseInterface(n uint32) error {
+	return u.simple(OP_RELEASE, u32(n)...)
+}
+
+func (u *Device) SetConfiguration(num uint8) error {
+	return u.simple(OP_SET_CONFIG, num)
+}
+
+func (u *Device) SetInterface(num uint8, alt uint8) error {
+	return u.simple(OP_SET_ALT, num, alt)
+}
+
+func (u *Device) ClearHalt(endpoint uint8) error {
+	return u.simple(OP_CLEAR_HALT, endpoint)
+}
+
+func (u *Device) Reset() error {
+	return u.simple(OP_RESET)
+}
+
+func (u *Device) Close() {
+	u.simple(OP_CLOSE)
+}
@@ -0,0 +1,288 @@
+package remote
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/richardnwinder/usb"
+)
+
+// Server exposes this machine's devices to Clients.
+type Server struct {
+	// Token is what clients must present.  Leave it empty only when the
+	// TLS configuration verifies client certificates, or when serving
+	// over SSH, which has authenticated the user.
+	Token string
+	// Allow, if set, picks the devices clients may list and open.
+	Allow func(di *usb.DeviceInfo) bool
+	// MaxRequests is how many requests one connection may have under way
+	// at once, 16 if zero; the server reads no more from it until one
+	// finishes.
+	MaxRequests int
+	// IdleTimeout closes a connection that has sent nothing and had no
+	// request under way for this long, 5 minutes if zero; negative
+	// never does.
+	IdleTimeout time.Duration
+}
+
+// helloTimeout is how long a client has to authenticate; a variable so
+// the tests needn't wait that long
+var helloTimeout = 10 * time.Second
+
+// ListenAndServeTLS accepts TLS connections on addr.
+func (s *Server) ListenAndServeTLS(addr string, cfg *tls.Config) error {
+	l, e := tls.Listen("tcp", addr, cfg)
+	if e != nil {
+		return e
+	}
+	defer l.Close()
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l until it fails.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, e := l.Accept()
+		if e != nil {
+			return e
+		}
+		go s.serveConn(conn)
+	}
+}
+
+// session is one authenticated connection and the devices it opened
+type session struct {
+	s       *Server
+	conn    net.Conn
+	wlock   sync.Mutex
+	lock    sync.Mutex
+	next    uint32
+	handles map[uint32]Backend
+	busy    int // requests under way
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+	// deadlines are best effort: stdio has none, and ssh takes care of
+	// its sessions
+	conn.SetReadDeadline(time.Now().Add(helloTimeout))
+	b, e := readFrame(conn, maxHello)
+	if e != nil {
+		return
+	}
+	hello := []byte(magic + s.Token)
+	if subtle.ConstantTimeCompare(b, hello) != 1 {
+		writeFrame(conn, []byte{1})
+		return
+	}
+	if writeFrame(conn, []byte{0}) != nil {
+		return
+	}
+	ss := &session{s: s, conn: conn, handles: map[uint32]Backend{}}
+	ss.track(0)
+	slots := s.MaxRequests
+	if slots <= 0 {
+		slots = 16
+	}
+	sem := make(chan struct{}, slots)
+	var wg sync.WaitGroup
+	for {
+		b, e := readFrame(conn, maxFrame)
+		if e != nil {
+			break
+		}
+		ss.track(1)
+		sem <- struct{}{}
+		// a slow bulk read shouldn't hold up control requests
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ss.handle(b)
+			<-sem
+			ss.track(-1)
+		}()
+	}
+	wg.Wait()
+	for _, u := range ss.handles {
+		u.Close()
+	}
+}
+
+// track counts requests starting or finishing, and keeps the idle
+// deadline for reading the next one only while none is under way, since
+// a client waiting on a slow transfer has nothing to send
+func (ss *session) track(delta int) {
+	idle := ss.s.IdleTimeout
+	if idle == 0 {
+		idle = 5 * time.Minute
+	}
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+	ss.busy += delta
+	switch {
+	case ss.busy == 1 && delta > 0:
+		ss.conn.SetReadDeadline(time.Time{})
+	case ss.busy == 0 && idle > 0:
+		ss.conn.SetReadDeadline(time.Now().Add(idle))
+	case ss.busy == 0:
+		ss.conn.SetReadDeadline(time.Time{})
+	}
+}
+
+func (ss *session) handle(b []byte) {
+	d := &decoder{b: b}
+	id, op, h := d.u32(), d.u8(), d.u32()
+	var body []byte
+	var e error
+	if d.bad {
+		e = syscall.EPROTO
+	} else {
+		body, e = ss.do(op, h, d)
+	}
+	resp := binary.LittleEndian.AppendUint32(nil, id)
+	var errno syscall.Errno
+	msg := ""
+	if e != nil && !errors.As(e, &errno) {
+		msg = e.Error()
+	}
+	resp = binary.LittleEndian.AppendUint32(resp, uint32(errno))
+	resp = binary.LittleEndian.AppendUint16(resp, uint16(len(msg)))
+	resp = append(append(resp, msg...), body...)
+	ss.wlock.Lock()
+	writeFrame(ss.conn, resp)
+	ss.wlock.Unlock()
+}
+
+func (ss *session) list() ([]*usb.DeviceInfo, error) {
+	devs, e := usb.ListDevices()
+	if e != nil || ss.s.Allow == nil {
+		return devs, e
+	}
+	var list []*usb.DeviceInfo
+	for _, di := range devs {
+		if ss.s.Allow(di) {
+			list = append(list, di)
+		}
+	}
+	return list, nil
+}
+
+func (ss *session) do(op uint8, h uint32, d *decoder) ([]byte, error) {
+	switch op {
+	case OP_LIST:
+		devs, e := ss.list()
+		if e != nil {
+			return nil, e
+		}
+		var b []byte
+		n := 0
+		for _, di := range devs {
+			desc, e := di.Descriptors()
+			if e != nil || len(di.Ports) > 255 {
+				continue
+			}
+			b = binary.LittleEndian.AppendUint16(b, uint16(di.BusNum))
+			b = binary.LittleEndian.AppendUint16(b, uint16(di.DevNum))
+			b = append(b, uint8(len(di.Ports)))
+			for _, p := range di.Ports {
+				b = append(b, uint8(p))
+			}
+			b = appendBytes(b, desc)
+			n++
+		}
+		return append(binary.LittleEndian.AppendUint16(nil, uint16(n)), b...), nil
+	case OP_OPEN:
+		bus, dev := int(d.u16()), int(d.u16())
+		devs, e := ss.list()
+		if e != nil {
+			return nil, e
+		}
+		for _, di := range devs {
+			if di.BusNum != bus || di.DevNum != dev {
+				continue
+			}
+			u, e := usb.Open(di)
+			if e != nil {
+				return nil, e
+			}
+			ss.lock.Lock()
+			ss.next++
+			h := ss.next
+			ss.handles[h] = u
+			ss.lock.Unlock()
+			return binary.LittleEndian.AppendUint32(nil, h), nil
+		}
+		return nil, syscall.ENODEV
+	}
+	ss.lock.Lock()
+	u := ss.handles[h]
+	if op == OP_CLOSE {
+		delete(ss.handles, h)
+	}
+	ss.lock.Unlock()
+	if u == nil {
+		return nil, syscall.EBADF
+	}
+	switch op {
+	case OP_CLOSE:
+		u.Close()
+		return nil, nil
+	case OP_CONTROL:
+		reqtype, req := d.u8(), d.u8()
+		value, index, length := d.u16(), d.u16(), d.u16()
+		timeout := d.u32()
+		data := make([]byte, length)
+		if reqtype&usb.REQTYPE_IN == 0 {
+			copy(data, d.take(int(length)))
+		}
+		if d.bad {
+			return nil, syscall.EPROTO
+		}
+		n, e := u.ControlTransfer(reqtype, req, value, index, length, timeout, data)
+		n = max(n, 0)
+		b := binary.LittleEndian.AppendUint32(nil, uint32(n))
+		if reqtype&usb.REQTYPE_IN != 0 {
+			b = append(b, data[:n]...)
+		}
+		return b, e
+	case OP_BULK:
+		ep, length, timeout := d.u32(), d.u32(), d.u32()
+		if length > maxFrame-64 {
+			return nil, syscall.EMSGSIZE
+		}
+		data := make([]byte, length)
+		in := ep&usb.ENDPOINT_IN != 0
+		if !in {
+			copy(data, d.take(int(length)))
+		}
+		if d.bad {
+			return nil, syscall.EPROTO
+		}
+		n, _, e := u.BulkTransfer(ep, length, timeout, data)
+		n = max(n, 0)
+		b := binary.LittleEndian.AppendUint32(nil, uint32(n))
+		if in {
+			b = append(b, data[:n]...)
+		}
+		return b, e
+	case OP_CLAIM:
+		return nil, u.ClaimInterface(d.u32())
+	case OP_RELEASE:
+		return nil, u.ReleaseInterface(d.u32())
+	case OP_SET_CONFIG:
+		return nil, u.SetConfiguration(d.u8())
+	case OP_SET_ALT:
+		return nil, u.SetInterface(d.u8(), d.u8())
+	case OP_CLEAR_HALT:
+		return nil, u.ClearHalt(d.u8())
+	case OP_RESET:
+		return nil, u.Reset()
+	}
+	return nil, syscall.ENOSYS
+}
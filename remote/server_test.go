@@ -0,0 +1,115 @@
+package remote
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// serve runs s on one end of a pipe, returning the other and a channel
+// closed when the server is done with the connection
+func serve(t *testing.T, s *Server) (net.Conn, chan struct{}) {
+	client, server := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		s.ServeConn(server)
+		close(done)
+	}()
+	t.Cleanup(func() { client.Close() })
+	return client, done
+}
+
+// closed waits for the server to drop the connection unanswered
+func closed(t *testing.T, conn net.Conn, done chan struct{}) {
+	t.Helper()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("server still serving")
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if n, e := conn.Read(make([]byte, 1)); e != io.EOF {
+		t.Errorf("read %d bytes, %v; want EOF", n, e)
+	}
+}
+
+func TestToken(t *testing.T) {
+	s := &Server{Token: "sesame"}
+	for _, token := range []string{"", "Sesame", "sesame\x00", "sesam"} {
+		conn, done := serve(t, s)
+		if _, e := NewClient(conn, token); !errors.Is(e, ErrDenied) {
+			t.Errorf("token %q: %v, want %v", token, e, ErrDenied)
+		}
+		closed(t, conn, done)
+	}
+
+	conn, done := serve(t, s)
+	c, e := NewClient(conn, "sesame")
+	if e != nil {
+		t.Fatal(e)
+	}
+	// a round trip: nothing is open, so there is nothing to reset
+	u := &Device{c: c, handle: 7}
+	if e := u.Reset(); !errors.Is(e, syscall.EBADF) {
+		t.Errorf("reset of an unknown handle: %v, want %v", e, syscall.EBADF)
+	}
+	c.Close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("server still serving a closed connection")
+	}
+}
+
+func TestOversizedFrame(t *testing.T) {
+	s := &Server{Token: "sesame"}
+
+	// before authenticating, anything over maxHello
+	conn, done := serve(t, s)
+	go conn.Write(binary.BigEndian.AppendUint32(nil, maxHello+1))
+	closed(t, conn, done)
+
+	// after, anything over maxFrame: the server stops reading rather
+	// than allocate it
+	conn, done = serve(t, s)
+	c, e := NewClient(conn, "sesame")
+	if e != nil {
+		t.Fatal(e)
+	}
+	c.wlock.Lock()
+	_, e = conn.Write(binary.BigEndian.AppendUint32(nil, maxFrame+1))
+	c.wlock.Unlock()
+	if e != nil {
+		t.Fatal(e)
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("server still serving")
+	}
+	if e := (&Device{c: c, handle: 1}).Reset(); e == nil {
+		t.Error("request answered after an oversized frame")
+	}
+}
+
+func TestHelloTimeout(t *testing.T) {
+	defer func(d time.Duration) { helloTimeout = d }(helloTimeout)
+	helloTimeout = 50 * time.Millisecond
+	s := &Server{Token: "sesame"}
+
+	// nothing at all
+	conn, done := serve(t, s)
+	closed(t, conn, done)
+
+	// a hello that starts and doesn't finish
+	conn, done = serve(t, s)
+	hello := append(binary.BigEndian.AppendUint32(nil, uint32(len(magic)+6)), magic...)
+	if _, e := conn.Write(hello); e != nil {
+		t.Fatal(e)
+	}
+	closed(t, conn, done)
+}
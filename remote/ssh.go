@@ -0,0 +1,97 @@
+package remote
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// DialSSH starts the agent on a lab machine through ssh and speaks the
+// protocol over the session's standard input and output, so nothing but
+// sshd needs to listen there.  destination is as ssh(1) takes it, such
+// as "user@rig3", and command runs ServeStdio on the far side, for
+// example []string{"usbagent", "-stdio"}.  The ssh binary is used rather
+// than a library so that the user's keys, agent, known hosts and jump
+// host settings all apply; it runs in batch mode, so it fails instead of
+// prompting.  ssh's own errors go to stderr.  ssh has authenticated the
+// user already, so the token may be empty if the agent's is.
+func DialSSH(destination string, command []string, token string) (*Client, error) {
+	args := append([]string{"-T", "-o", "BatchMode=yes", "--", destination}, command...)
+	cmd := exec.Command("ssh", args...)
+	cmd.Stderr = os.Stderr
+	w, e := cmd.StdinPipe()
+	if e != nil {
+		return nil, e
+	}
+	r, e := cmd.StdoutPipe()
+	if e != nil {
+		return nil, e
+	}
+	if e := cmd.Start(); e != nil {
+		return nil, e
+	}
+	conn := &stdioConn{r: r, w: w, done: func() error {
+		// closing stdin ends the agent, and with it ssh
+		return cmd.Wait()
+	}}
+	c, e := NewClient(conn, token)
+	if e != nil {
+		conn.Close()
+		return nil, e
+	}
+	return c, nil
+}
+
+// ServeStdio serves one client on standard input and output, as DialSSH
+// runs the agent.  It returns when the client goes away.
+func (s *Server) ServeStdio() {
+	s.ServeConn(&stdioConn{r: os.Stdin, w: os.Stdout})
+}
+
+// ServeConn serves one client on an established connection, for
+// transports other than a listener.  It returns when the connection
+// closes, having closed every device the client left open.
+func (s *Server) ServeConn(conn net.Conn) {
+	s.serveConn(conn)
+}
+
+// stdioConn is a net.Conn over a pair of pipes
+type stdioConn struct {
+	r    io.ReadCloser
+	w    io.WriteCloser
+	done func() error
+	once sync.Once
+	err  error
+}
+
+func (c *stdioConn) Read(b []byte) (int, error)  { return c.r.Read(b) }
+func (c *stdioConn) Write(b []byte) (int, error) { return c.w.Write(b) }
+
+func (c *stdioConn) Close() error {
+	c.once.Do(func() {
+		c.err = c.w.Close()
+		c.r.Close()
+		if c.done != nil {
+			if e := c.done(); c.err == nil {
+				c.err = e
+			}
+		}
+	})
+	return c.err
+}
+
+type stdioAddr struct{}
+
+func (stdioAddr) Network() string { return "stdio" }
+func (stdioAddr) String() string  { return "stdio" }
+
+func (c *stdioConn) LocalAddr() net.Addr  { return stdioAddr{} }
+func (c *stdioConn) RemoteAddr() net.Addr { return stdioAddr{} }
+
+func (c *stdioConn) SetDeadline(t time.Time) error      { return errors.ErrUnsupported }
+func (c *stdioConn) SetReadDeadline(t time.Time) error  { return errors.ErrUnsupported }
+func (c *stdioConn) SetWriteDeadline(t time.Time) error { return errors.ErrUnsupported }
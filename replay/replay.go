@@ -0,0 +1,247 @@
+// Package replay re-issues the host side of a captured USB conversation
+// against a live device: control requests and OUT transfers are sent as
+// recorded, and IN transfers are read and compared with what the device
+// answered at the time.  It is meant for poking at undocumented
+// protocols, by replaying what the vendor's software did.
+package replay
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"syscall"
+	"time"
+
+	"github.com/richardnwinder/usb"
+	"github.com/richardnwinder/usb/capture"
+)
+
+// Source is where the recording comes from, such as a
+// capture.PcapngReader or capture.TextReader.
+type Source interface {
+	Next() (*capture.Event, error)
+}
+
+// Device is what the replay is sent to, such as a *usb.Device or a
+// *remote.Device.
+type Device interface {
+	usb.ControlTransferer
+	usb.BulkTransferer
+	SetConfiguration(num uint8) error
+	SetInterface(num uint8, alt uint8) error
+	ClearHalt(endpoint uint8) error
+}
+
+// how IN responses are checked
+type Check int
+
+const (
+	CHECK_NONE   Check = iota
+	CHECK_STATUS       // the transfer succeeds or fails as recorded
+	CHECK_LENGTH       // and returns as many bytes
+	CHECK_DATA         // and the bytes that were captured match
+)
+
+// Step is one recorded transfer: its submission and its completion.
+type Step struct {
+	Submit   *capture.Event
+	Complete *capture.Event
+}
+
+// Load reads a recording and pairs submissions with completions,
+// keeping the control, bulk and interrupt traffic of one device.  Steps
+// are in completion order, which is the order the device saw things
+// happen; a read queued early is only issued once the transfers that
+// completed before it have been replayed.  Transfers captured without
+// completing, or that were cancelled, are left out.  A bus or device of
+// 0 matches any; the default control pipe before SET_ADDRESS is never
+// included.
+func Load(src Source, bus int, dev int) ([]Step, error) {
+	pending := make(map[uint64]*capture.Event)
+	var steps []Step
+	for {
+		ev, e := src.Next()
+		if e == io.EOF {
+			return steps, nil
+		}
+		if e != nil {
+			return steps, e
+		}
+		if ev.XferType == usb.URB_TYPE_ISO || ev.Device == 0 ||
+			(bus != 0 && int(ev.Bus) != bus) || (dev != 0 && int(ev.Device) != dev) {
+			continue
+		}
+		switch ev.Type {
+		case capture.EVENT_SUBMIT:
+			pending[ev.ID] = ev
+		case capture.EVENT_COMPLETE:
+			s, ok := pending[ev.ID]
+			if !ok {
+				continue
+			}
+			delete(pending, ev.ID)
+			st := -ev.Status
+			if st == int32(syscall.ENOENT) || st == int32(syscall.ECONNRESET) || st == int32(syscall.ESHUTDOWN) {
+				continue
+			}
+			steps = append(steps, Step{s, ev})
+		case capture.EVENT_ERROR:
+			delete(pending, ev.ID)
+		}
+	}
+}
+
+// Mismatch reports an IN transfer that did not go as recorded, or a
+// transfer that could not be replayed at all.
+type Mismatch struct {
+	Index int // into the steps
+	Step  Step
+	Got   []byte
+	Err   error // the replayed transfer's error
+}
+
+func (m *Mismatch) Error() string {
+	s := m.Step.Complete
+	if m.Err != nil && (s.Status == 0 || !errors.Is(m.Err, syscall.Errno(-s.Status))) {
+		return fmt.Sprintf("replay: step %d (ep %02x): %v, recorded status %d", m.Index, s.Endpoint, m.Err, s.Status)
+	}
+	return fmt.Sprintf("replay: step %d (ep %02x): got %d bytes % x, recorded %d bytes % x",
+		m.Index, s.Endpoint, len(m.Got), m.Got, s.Length, s.Data)
+}
+
+// Options tunes a replay.
+type Options struct {
+	// Speed scales the recorded gaps between transfers: 1 keeps the
+	// original timing, 2 halves it.  Zero sends them back to back, with
+	// Delay in between.
+	Speed float64
+	Delay time.Duration
+	// Timeout is each transfer's timeout in milliseconds; zero is 5s.
+	Timeout uint32
+	Check   Check
+	// OnMismatch is called for each failed check.  Returning nil carries
+	// on; by default the replay stops with the mismatch as its error.
+	OnMismatch func(*Mismatch) error
+	// OnStep is called after each transfer, for logging.
+	OnStep func(i int, s Step, got []byte, err error)
+}
+
+// Replay sends steps to u in order.
+func Replay(u Device, steps []Step, opts Options) error {
+	if opts.Timeout == 0 {
+		opts.Timeout = 5000
+	}
+	var start time.Time
+	for i, s := range steps {
+		if opts.Speed > 0 {
+			if i == 0 {
+				start = time.Now()
+			} else {
+				at := start.Add(time.Duration(float64(s.Complete.Time.Sub(steps[0].Complete.Time)) / opts.Speed))
+				time.Sleep(time.Until(at))
+			}
+		} else if i > 0 {
+			time.Sleep(opts.Delay)
+		}
+		got, e := issue(u, s, opts.Timeout)
+		if opts.OnStep != nil {
+			opts.OnStep(i, s, got, e)
+		}
+		if !check(s, got, e, opts.Check) {
+			m := &Mismatch{Index: i, Step: s, Got: got, Err: e}
+			if opts.OnMismatch == nil {
+				return m
+			}
+			if e := opts.OnMismatch(m); e != nil {
+				return e
+			}
+		}
+	}
+	return nil
+}
+
+// errTruncated stops an OUT transfer whose data wasn't fully captured
+var errTruncated = errors.New("replay: OUT data not fully captured")
+
+func issue(u Device, s Step, timeout uint32) ([]byte, error) {
+	sub := s.Submit
+	in := sub.Endpoint&usb.ENDPOINT_IN != 0
+	if sub.XferType == usb.URB_TYPE_CONTROL {
+		if sub.SetupFlag != 0 {
+			return nil, errTruncated
+		}
+		st := sub.Setup
+		reqtype, req := st[0], st[1]
+		value := uint16(st[2]) | uint16(st[3])<<8
+		index := uint16(st[4]) | uint16(st[5])<<8
+		length := uint16(st[6]) | uint16(st[7])<<8
+		// let the kernel keep track of the requests that change its state
+		switch {
+		case reqtype == usb.REQTYPE_STANDARD|usb.REQTYPE_DEVICE && req == usb.REQ_SET_CONFIGURATION:
+			return nil, u.SetConfiguration(uint8(value))
+		case reqtype == usb.REQTYPE_STANDARD|usb.REQTYPE_INTERFACE && req == usb.REQ_SET_INTERFACE:
+			return nil, u.SetInterface(uint8(index), uint8(value))
+		case reqtype == usb.REQTYPE_STANDARD|usb.REQTYPE_ENDPOINT && req == usb.REQ_CLEAR_FEATURE && value == 0:
+			return nil, u.ClearHalt(uint8(index))
+		case reqtype == usb.REQTYPE_STANDARD|usb.REQTYPE_DEVICE && req == usb.REQ_SET_ADDRESS:
+			return nil, nil
+		}
+		data := make([]byte, length)
+		if reqtype&usb.REQTYPE_IN == 0 {
+			if len(sub.Data) < int(length) {
+				return nil, errTruncated
+			}
+			copy(data, sub.Data)
+		}
+		n, e := u.ControlTransfer(reqtype, req, value, index, length, timeout, data)
+		if n < 0 {
+			n = 0
+		}
+		if reqtype&usb.REQTYPE_IN == 0 {
+			return nil, e
+		}
+		return data[:n], e
+	}
+	data := make([]byte, sub.Length)
+	if !in {
+		if len(sub.Data) < int(sub.Length) {
+			return nil, errTruncated
+		}
+		copy(data, sub.Data)
+	}
+	_, got, e := u.BulkTransfer(uint32(sub.Endpoint), sub.Length, timeout, data)
+	if !in {
+		return nil, e
+	}
+	return got, e
+}
+
+func check(s Step, got []byte, e error, c Check) bool {
+	if e == errTruncated {
+		return false
+	}
+	c2 := s.Complete
+	if c == CHECK_NONE {
+		return true
+	}
+	if c2.Status == 0 {
+		if e != nil {
+			return false
+		}
+	} else if e == nil || !errors.Is(e, syscall.Errno(-c2.Status)) {
+		return false
+	}
+	// only IN transfers have a response to compare
+	if s.Submit.Endpoint&usb.ENDPOINT_IN == 0 || c < CHECK_LENGTH {
+		return true
+	}
+	if len(got) != int(c2.Length) {
+		return false
+	}
+	if c < CHECK_DATA {
+		return true
+	}
+	// usbmon may have captured less than was transferred
+	return bytes.Equal(got[:min(len(got), len(c2.Data))], c2.Data)
+}
@@ -0,0 +1,185 @@
+package replay_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/richardnwinder/usb"
+	"github.com/richardnwinder/usb/capture"
+	"github.com/richardnwinder/usb/replay"
+	"github.com/richardnwinder/usb/usbtest"
+)
+
+// a session with device 2 on bus 1, in usbmon's text format
+const recording = `
+d0000001 1000 S Co:1:002:0 s 00 09 0001 0000 0000 0
+d0000001 1010 C Co:1:002:0 0 0
+d0000002 1100 S Co:1:002:0 s 40 01 1234 0000 0004 4 = 01020304
+d0000002 1110 C Co:1:002:0 0 4 >
+d0000003 1200 S Ci:1:002:0 s c0 02 0000 0005 0004 4 <
+d0000003 1210 C Ci:1:002:0 0 4 = deadbeef
+d0000004 1300 S Bi:1:002:1 -115 64 <
+d0000005 1310 S Bo:1:002:2 -115 3 = 616263
+d0000005 1320 C Bo:1:002:2 0 3 >
+d0000004 1330 C Bi:1:002:1 0 2 = 6869
+d0000006 1400 S Bi:1:003:1 -115 64 <
+d0000006 1410 C Bi:1:003:1 0 1 = 00
+d0000007 1500 S Bi:1:002:1 -115 64 <
+d0000007 1510 C Bi:1:002:1 -2 0
+d0000008 1600 S Ci:1:002:0 s c0 03 0000 0000 0002 2 <
+d0000008 1610 C Ci:1:002:0 -32 0
+`
+
+func load(t *testing.T, text string) []replay.Step {
+	steps, e := replay.Load(capture.NewTextReader(strings.NewReader(text)), 1, 2)
+	if e != nil {
+		t.Fatal(e)
+	}
+	return steps
+}
+
+// device answers as the recorded one did, but with in for the bulk read
+// and, unless stall is false, stalling the last request
+func device(t *testing.T, in []byte, stall bool) *usbtest.Device {
+	fx, e := usbtest.ParseHex("vendor", `
+12 01 00 02 ff 00 00 40  34 12 78 56 00 01 00 00 00 01
+09 02 20 00 01 01 00 80 32
+09 04 00 00 02 ff 00 00 00
+07 05 81 02 40 00 00
+07 05 02 02 40 00 00
+`)
+	if e != nil {
+		t.Fatal(e)
+	}
+	d := fx.Device()
+	d.On(usbtest.Control(0x40, 0x01)).Reply(nil)
+	d.On(usbtest.Control(0xc0, 0x02)).Reply([]byte{0xde, 0xad, 0xbe, 0xef})
+	if stall {
+		d.On(usbtest.Control(0xc0, 0x03)).Stall()
+	} else {
+		d.On(usbtest.Control(0xc0, 0x03)).Reply([]byte{0, 0})
+	}
+	d.On(usbtest.Bulk(0x81)).Reply(in)
+	return d
+}
+
+func TestLoad(t *testing.T) {
+	steps := load(t, recording)
+	// in completion order, without the other device or the cancelled read
+	var ids []uint64
+	for _, s := range steps {
+		if s.Submit.ID != s.Complete.ID {
+			t.Errorf("submission %x paired with completion %x", s.Submit.ID, s.Complete.ID)
+		}
+		ids = append(ids, s.Submit.ID)
+	}
+	want := []uint64{0xd0000001, 0xd0000002, 0xd0000003, 0xd0000005, 0xd0000004, 0xd0000008}
+	if len(ids) != len(want) {
+		t.Fatalf("steps %x, want %x", ids, want)
+	}
+	for i := range ids {
+		if ids[i] != want[i] {
+			t.Fatalf("steps %x, want %x", ids, want)
+		}
+	}
+}
+
+func TestReplay(t *testing.T) {
+	d := device(t, []byte("hi"), true)
+	var seen []int
+	e := replay.Replay(d, load(t, recording), replay.Options{
+		Timeout: 100,
+		Check:   replay.CHECK_DATA,
+		OnStep:  func(i int, s replay.Step, got []byte, err error) { seen = append(seen, i) },
+	})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(seen) != 6 {
+		t.Errorf("OnStep called for steps %v, want 0 to 5", seen)
+	}
+
+	// the OUT data reached the device as recorded
+	var ctl, bulk *usbtest.Request
+	list := d.Requests()
+	for i, q := range list {
+		switch {
+		case q.Endpoint == 0 && q.Setup.Request == 0x01:
+			ctl = &list[i]
+		case q.Endpoint == 0x02:
+			bulk = &list[i]
+		}
+	}
+	if ctl == nil || ctl.Setup.Value != 0x1234 || !bytes.Equal(ctl.Data, []byte{1, 2, 3, 4}) {
+		t.Errorf("vendor OUT request %+v", ctl)
+	}
+	if bulk == nil || string(bulk.Data) != "abc" {
+		t.Errorf("bulk OUT %+v", bulk)
+	}
+	// SET_CONFIGURATION went through the device, not as a raw request
+	cfg := make([]byte, 1)
+	if _, e := d.ControlTransfer(usb.REQTYPE_IN, usb.REQ_GET_CONFIGURATION, 0, 0, 1, 100, cfg); e != nil || cfg[0] != 1 {
+		t.Errorf("configuration %d after replay, %v", cfg[0], e)
+	}
+}
+
+func TestReplayMismatch(t *testing.T) {
+	steps := load(t, recording)
+
+	// the bulk read answers two other bytes
+	e := replay.Replay(device(t, []byte("ho"), true), steps, replay.Options{Timeout: 100, Check: replay.CHECK_DATA})
+	var m *replay.Mismatch
+	if !errors.As(e, &m) {
+		t.Fatalf("different data: %v, want a mismatch", e)
+	}
+	if m.Index != 4 || string(m.Got) != "ho" || m.Err != nil || m.Step.Complete.ID != 0xd0000004 {
+		t.Errorf("mismatch %+v", m)
+	}
+	// which is fine if only the length counts
+	e = replay.Replay(device(t, []byte("ho"), true), steps, replay.Options{Timeout: 100, Check: replay.CHECK_LENGTH})
+	if e != nil {
+		t.Errorf("same length, checking the length: %v", e)
+	}
+	// and a short answer isn't
+	e = replay.Replay(device(t, []byte("h"), true), steps, replay.Options{Timeout: 100, Check: replay.CHECK_LENGTH})
+	if !errors.As(e, &m) || m.Index != 4 || len(m.Got) != 1 {
+		t.Errorf("shorter answer: %v", e)
+	}
+
+	// a request that stalled in the recording and now succeeds, with the
+	// replay carrying on past each mismatch
+	var list []*replay.Mismatch
+	e = replay.Replay(device(t, []byte("ho"), false), steps, replay.Options{
+		Timeout:    100,
+		Check:      replay.CHECK_STATUS,
+		OnMismatch: func(m *replay.Mismatch) error { list = append(list, m); return nil },
+	})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(list) != 1 || list[0].Index != 5 || list[0].Err != nil {
+		t.Errorf("mismatches %+v, want step 5 succeeding", list)
+	}
+}
+
+// TestReplayTruncated replays a write whose data usbmon cut short, which
+// must not be sent even when nothing is checked
+func TestReplayTruncated(t *testing.T) {
+	steps := load(t, `
+d0000001 1000 S Bo:1:002:2 -115 8 = 6162
+d0000001 1010 C Bo:1:002:2 0 8 >
+`)
+	d := device(t, nil, true)
+	e := replay.Replay(d, steps, replay.Options{Timeout: 100, Check: replay.CHECK_NONE})
+	var m *replay.Mismatch
+	if !errors.As(e, &m) || m.Index != 0 || m.Err == nil {
+		t.Errorf("truncated write: %v, want a mismatch", e)
+	}
+	for _, q := range d.Requests() {
+		if q.Endpoint == 0x02 {
+			t.Errorf("truncated write sent as % x", q.Data)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package usb
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Root says where devices are found: the sysfs directory listing them
+// and the usbfs directory holding their nodes.  Containers that bind
+// mount the host's bus elsewhere, chroots, and old systems with usbfs
+// mounted on /proc/bus/usb need other paths than the defaults.  Empty
+// fields take the defaults.
+type Root struct {
+	Sysfs string // SYSPATH by default
+	Devfs string // DEVFS_PATH by default, or say "/proc/bus/usb/"
+}
+
+// DefaultRoot is where ListDevices, the hotplug functions and
+// DeviceCache look.  Set it before using them, not while they run.
+// Devices opened from a DeviceInfo use the root it was listed under.
+var DefaultRoot = Root{Sysfs: SYSPATH, Devfs: DEVFS_PATH}
+
+func dirPath(p string, def string) string {
+	if p == "" {
+		return def
+	}
+	if !strings.HasSuffix(p, "/") {
+		p += "/"
+	}
+	return p
+}
+
+func (r Root) sysfs() string { return dirPath(r.Sysfs, SYSPATH) }
+func (r Root) devfs() string { return dirPath(r.Devfs, DEVFS_PATH) }
+
+// node is the path of a device's usbfs node
+func (r Root) node(bus int, dev int) string {
+	return fmt.Sprintf("%s%03d/%03d", r.devfs(), bus, dev)
+}
+
+// OpenBusDev opens the node of device dev on bus bus under r.  Unlike
+// the package's OpenBusDev it doesn't look in sysfs, so it works where
+// only usbfs is mounted.
+func (r Root) OpenBusDev(bus int, dev int) (*Device, error) {
+	return Open(&DeviceInfo{BusNum: bus, DevNum: dev, devpath: r.node(bus, dev), root: r})
+}
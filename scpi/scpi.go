@@ -0,0 +1,223 @@
+// Package scpi is a convenience layer for driving SCPI instruments over
+// USBTMC:
+//
+//	inst, _ := scpi.Open(dev, ii)
+//	id, _ := inst.Query("*IDN?")
+//	wave, _ := inst.Timed(30 * time.Second).QueryBinaryBlock(":WAV:DATA?")
+package scpi
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/richardnwinder/usb"
+	"github.com/richardnwinder/usb/usbtmc"
+)
+
+// IEEE 488.2 status byte bits
+const (
+	STB_MAV = 0x10 // message available
+	STB_ESB = 0x20 // standard event status summary
+	STB_RQS = 0x40 // requesting service
+)
+
+type Instrument struct {
+	tmc     *usbtmc.Device
+	lock    *sync.Mutex
+	Timeout time.Duration
+}
+
+// Open claims the USBTMC interface ii and clears the instrument's
+// input and output queues.
+func Open(dev *usb.Device, ii *usb.InterfaceInfo) (*Instrument, error) {
+	tmc, e := usbtmc.Open(dev, ii)
+	if e != nil {
+		return nil, e
+	}
+	if e := tmc.Clear(); e != nil {
+		tmc.Close()
+		return nil, e
+	}
+	return New(tmc), nil
+}
+
+// New wraps an already open USBTMC device.
+func New(tmc *usbtmc.Device) *Instrument {
+	return &Instrument{tmc: tmc, lock: new(sync.Mutex), Timeout: tmc.Timeout}
+}
+
+func (i *Instrument) Close() error {
+	return i.tmc.Close()
+}
+
+// Timed returns a view of the instrument whose commands use timeout, for
+// the occasional slow measurement or self test.
+func (i *Instrument) Timed(timeout time.Duration) *Instrument {
+	c := *i
+	c.Timeout = timeout
+	return &c
+}
+
+// Device returns the underlying USBTMC device.
+func (i *Instrument) Device() *usbtmc.Device {
+	return i.tmc
+}
+
+func terminate(cmd string) []byte {
+	if !strings.HasSuffix(cmd, "\n") {
+		cmd += "\n"
+	}
+	return []byte(cmd)
+}
+
+// Command sends cmd, which should not produce a response.
+func (i *Instrument) Command(cmd string) error {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	return i.tmc.WriteTimeout(terminate(cmd), i.Timeout)
+}
+
+// Commandf formats and sends a command.
+func (i *Instrument) Commandf(format string, args ...any) error {
+	return i.Command(fmt.Sprintf(format, args...))
+}
+
+func (i *Instrument) query(cmd string) ([]byte, error) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	if e := i.tmc.WriteTimeout(terminate(cmd), i.Timeout); e != nil {
+		return nil, e
+	}
+	return i.tmc.ReadTimeout(i.Timeout)
+}
+
+// Query sends cmd and returns its response with the terminator removed.
+func (i *Instrument) Query(cmd string) (string, error) {
+	b, e := i.query(cmd)
+	if e != nil {
+		return "", e
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+// QueryFloat sends cmd and parses the response as a number.
+func (i *Instrument) QueryFloat(cmd string) (float64, error) {
+	s, e := i.Query(cmd)
+	if e != nil {
+		return 0, e
+	}
+	return strconv.ParseFloat(strings.TrimSpace(s), 64)
+}
+
+// QueryBinaryBlock sends cmd and decodes the IEEE 488.2 block it answers
+// with.
+func (i *Instrument) QueryBinaryBlock(cmd string) ([]byte, error) {
+	b, e := i.query(cmd)
+	if e != nil {
+		return nil, e
+	}
+	return ParseBinaryBlock(b)
+}
+
+// ReadBinaryBlock reads a response holding an IEEE 488.2 block, for
+// commands sent with Command.
+func (i *Instrument) ReadBinaryBlock() ([]byte, error) {
+	i.lock.Lock()
+	b, e := i.tmc.ReadTimeout(i.Timeout)
+	i.lock.Unlock()
+	if e != nil {
+		return nil, e
+	}
+	return ParseBinaryBlock(b)
+}
+
+// ParseBinaryBlock decodes a definite length block "#<n><length><data>"
+// or an indefinite one, "#0<data>" ended by a newline.  Anything after
+// the block, such as the response terminator, is ignored.
+func ParseBinaryBlock(b []byte) ([]byte, error) {
+	// some instruments put whitespace before the block
+	b = bytes.TrimLeft(b, " \t\r\n")
+	if len(b) < 2 || b[0] != '#' || b[1] < '0' || b[1] > '9' {
+		return nil, fmt.Errorf("scpi: not a binary block")
+	}
+	n := int(b[1] - '0')
+	if n == 0 {
+		data := b[2:]
+		return bytes.TrimSuffix(data, []byte("\n")), nil
+	}
+	if len(b) < 2+n {
+		return nil, fmt.Errorf("scpi: truncated binary block header")
+	}
+	size, e := strconv.Atoi(string(b[2 : 2+n]))
+	if e != nil || size < 0 {
+		return nil, fmt.Errorf("scpi: bad binary block length %q", b[2:2+n])
+	}
+	if len(b) < 2+n+size {
+		return nil, fmt.Errorf("scpi: binary block has %d of %d bytes", len(b)-2-n, size)
+	}
+	return b[2+n : 2+n+size], nil
+}
+
+// StatusByte reads the status byte without going through the message
+// queue.
+func (i *Instrument) StatusByte() (uint8, error) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	return i.tmc.ReadStatusByte()
+}
+
+// SRQ delivers the status byte of each service request, or is nil if the
+// instrument has no interrupt endpoint.
+func (i *Instrument) SRQ() <-chan uint8 {
+	return i.tmc.SRQ
+}
+
+// WaitSRQ waits for a service request.  Without an interrupt endpoint it
+// falls back to polling the status byte for RQS.
+func (i *Instrument) WaitSRQ(ctx context.Context) (uint8, error) {
+	if c := i.SRQ(); c != nil {
+		select {
+		case stb, ok := <-c:
+			if !ok {
+				return 0, fmt.Errorf("scpi: interrupt endpoint stopped")
+			}
+			return stb, nil
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+	for {
+		stb, e := i.StatusByte()
+		if e != nil {
+			return 0, e
+		}
+		if stb&STB_RQS != 0 {
+			return stb, nil
+		}
+		select {
+		case <-time.After(50 * time.Millisecond):
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+}
+
+// Wait sends "*OPC" with service requests enabled for operation complete
+// and waits for the SRQ, so a long operation doesn't tie up the bus with
+// a blocked query.
+func (i *Instrument) Wait(ctx context.Context) error {
+	if e := i.Command("*ESE 1;*SRE 32;*OPC"); e != nil {
+		return e
+	}
+	_, e := i.WaitSRQ(ctx)
+	if e == nil {
+		// reading the event register clears it for next time
+		_, e = i.Query("*ESR?")
+	}
+	return e
+}
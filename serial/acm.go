@@ -0,0 +1,138 @@
+package serial
+
+import (
+	"encoding/binary"
+	"syscall"
+
+	"github.com/richardnwinder/usb"
+)
+
+const (
+	SUBCLASS_ACM = 0x02
+
+	// ACM class requests
+	SET_LINE_CODING        = 0x20
+	GET_LINE_CODING        = 0x21
+	SET_CONTROL_LINE_STATE = 0x22
+	SEND_BREAK             = 0x23
+
+	lineDTR = 1
+	lineRTS = 2
+)
+
+// ACM is a claimed CDC ACM function.
+type ACM struct {
+	bulkPipe
+	comm uint8
+	ifcs []uint8
+}
+
+// OpenACM claims the comm and data interfaces of an ACM function of ci,
+// as ConfigInfo.Functions finds them, and raises DTR and RTS.
+func OpenACM(dev *usb.Device, ci *usb.ConfigInfo, f *usb.Function) (*ACM, error) {
+	a := &ACM{bulkPipe: bulkPipe{dev: dev}, ifcs: f.Interfaces}
+	var data *usb.InterfaceInfo
+	haveComm := false
+	for i := range ci.Interface {
+		ii := &ci.Interface[i]
+		if !in(f.Interfaces, ii.InterfaceNumber) {
+			continue
+		}
+		switch {
+		case ii.InterfaceClass == usb.CLASS_COMM:
+			a.comm, haveComm = ii.InterfaceNumber, true
+		case ii.InterfaceClass == usb.CLASS_CDC_DATA && data == nil && a.findBulk(ii):
+			data = ii
+		}
+	}
+	if !haveComm || data == nil {
+		return nil, syscall.ENODEV
+	}
+	if e := claim(dev, a.ifcs); e != nil {
+		return nil, e
+	}
+	var e error
+	if data.AlternateSetting != 0 {
+		e = dev.SetInterface(data.InterfaceNumber, data.AlternateSetting)
+	}
+	if e == nil {
+		e = a.SetLines(true, true)
+	}
+	if e != nil {
+		(&usb.Function{Interfaces: a.ifcs}).Release(dev)
+		return nil, e
+	}
+	return a, nil
+}
+
+func in(list []uint8, n uint8) bool {
+	for _, m := range list {
+		if m == n {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *ACM) request(req uint8, value uint16, b []byte) (int, error) {
+	rt := uint8(usb.REQTYPE_CLASS | usb.REQTYPE_INTERFACE)
+	if req == GET_LINE_CODING {
+		rt |= usb.REQTYPE_IN
+	}
+	return a.dev.ControlTransfer(rt, req, value, uint16(a.comm), uint16(len(b)), 1000, b)
+}
+
+func (a *ACM) Read(b []byte) (int, error) {
+	return a.read(b)
+}
+
+// SetMode sends the line coding.  Devices that are USB all the way, with
+// no UART behind them, accept any and ignore it.
+func (a *ACM) SetMode(m Mode) error {
+	b := make([]byte, 7)
+	binary.LittleEndian.PutUint32(b, uint32(m.Baud))
+	b[4], b[5], b[6] = uint8(m.StopBits), uint8(m.Parity), uint8(m.dataBits())
+	_, e := a.request(SET_LINE_CODING, 0, b)
+	return e
+}
+
+// Mode reads the line coding back.
+func (a *ACM) Mode() (Mode, error) {
+	b := make([]byte, 7)
+	n, e := a.request(GET_LINE_CODING, 0, b)
+	if e != nil {
+		return Mode{}, e
+	}
+	if n < 7 {
+		return Mode{}, syscall.EPROTO
+	}
+	return Mode{Baud: int(binary.LittleEndian.Uint32(b)), StopBits: StopBits(b[4]),
+		Parity: Parity(b[5]), DataBits: int(b[6])}, nil
+}
+
+func (a *ACM) SetLines(dtr, rts bool) error {
+	var v uint16
+	if dtr {
+		v |= lineDTR
+	}
+	if rts {
+		v |= lineRTS
+	}
+	_, e := a.request(SET_CONTROL_LINE_STATE, v, nil)
+	return e
+}
+
+// Break holds the line in the break state for ms milliseconds, or until
+// Break(0) if ms is 0xffff.
+func (a *ACM) Break(ms uint16) error {
+	_, e := a.request(SEND_BREAK, ms, nil)
+	return e
+}
+
+// Close drops DTR and RTS and releases the interfaces.  A Read in
+// progress returns io.EOF.
+func (a *ACM) Close() error {
+	a.closed.Store(true)
+	a.SetLines(false, false)
+	return (&usb.Function{Interfaces: a.ifcs}).Release(a.dev)
+}
@@ -0,0 +1,169 @@
+package serial
+
+import (
+	"sync"
+	"syscall"
+
+	"github.com/richardnwinder/usb"
+)
+
+const (
+	FTDI_VID = 0x0403
+
+	// FTDI vendor requests
+	FTDI_RESET             = 0x00
+	FTDI_MODEM_CTRL        = 0x01
+	FTDI_SET_FLOW_CTRL     = 0x02
+	FTDI_SET_BAUD_RATE     = 0x03
+	FTDI_SET_DATA          = 0x04
+	FTDI_SET_LATENCY_TIMER = 0x09
+
+	ftdiStatusSize = 2 // modem and line status at the start of each IN packet
+	ftdiClock      = 48000000
+)
+
+// FTDI is a claimed interface of an FTDI FT232, FT2232 or FT4232 family
+// chip.  Baud rates are set the FT232BM way, which the later chips also
+// take, so the fastest is 3Mbaud.
+type FTDI struct {
+	bulkPipe
+	ifc   uint8
+	index uint16 // wIndex naming the port: 0 on single port chips, else 1 for A, 2 for B...
+	mode  Mode
+
+	lock sync.Mutex // for Read's leftovers
+	buf  []byte
+	rest []byte
+}
+
+// OpenFTDI claims the FTDI interface ii, resets the port and raises DTR
+// and RTS.  multiport is true for chips with more than one interface.
+func OpenFTDI(dev *usb.Device, ii *usb.InterfaceInfo, multiport bool) (*FTDI, error) {
+	f := &FTDI{bulkPipe: bulkPipe{dev: dev}, ifc: ii.InterfaceNumber}
+	if !f.findBulk(ii) {
+		return nil, syscall.ENODEV
+	}
+	if multiport {
+		f.index = uint16(ii.InterfaceNumber) + 1
+	}
+	f.buf = make([]byte, 8*f.inSize)
+	if e := claim(dev, []uint8{f.ifc}); e != nil {
+		return nil, e
+	}
+	e := f.request(FTDI_RESET, 0, f.index)
+	if e == nil {
+		e = f.request(FTDI_SET_FLOW_CTRL, 0, f.index)
+	}
+	if e == nil {
+		e = f.SetLines(true, true)
+	}
+	if e != nil {
+		dev.ReleaseInterface(uint32(f.ifc))
+		return nil, e
+	}
+	return f, nil
+}
+
+func (f *FTDI) request(req uint8, value uint16, index uint16) error {
+	_, e := f.dev.ControlTransfer(usb.REQTYPE_VENDOR|usb.REQTYPE_DEVICE, req, value, index, 0, 1000, nil)
+	return e
+}
+
+// divisor encodes baud as the chip's clock divisor: 14 bits of whole
+// part and 3 bits of eighths, in a scrambled order
+func divisor(baud int) uint32 {
+	frac := [8]uint32{0, 3, 2, 4, 1, 5, 6, 7}
+	d3 := uint32((ftdiClock + baud) / (2 * baud)) // eighths, rounded
+	d := d3>>3 | frac[d3&7]<<14
+	// 3 and 2Mbaud have codes of their own
+	switch d {
+	case 1:
+		d = 0
+	case 0x4001:
+		d = 1
+	}
+	return d
+}
+
+func (f *FTDI) SetMode(m Mode) error {
+	if m.Baud < 184 || m.Baud > 3000000 {
+		return syscall.EINVAL
+	}
+	d := divisor(m.Baud)
+	index := uint16(d >> 16)
+	if f.index != 0 {
+		index = index<<8 | f.index
+	}
+	if e := f.request(FTDI_SET_BAUD_RATE, uint16(d), index); e != nil {
+		return e
+	}
+	if e := f.request(FTDI_SET_DATA, f.data(m, false), f.index); e != nil {
+		return e
+	}
+	f.mode = m
+	return nil
+}
+
+// data is wValue of FTDI_SET_DATA
+func (f *FTDI) data(m Mode, brk bool) uint16 {
+	v := uint16(m.dataBits()) | uint16(m.Parity)<<8 | uint16(m.StopBits)<<11
+	if brk {
+		v |= 1 << 14
+	}
+	return v
+}
+
+func (f *FTDI) SetLines(dtr, rts bool) error {
+	// the high byte says which lines the low byte sets
+	v := uint16(0x0300)
+	if dtr {
+		v |= lineDTR
+	}
+	if rts {
+		v |= lineRTS
+	}
+	return f.request(FTDI_MODEM_CTRL, v, f.index)
+}
+
+// Break sets or clears the break state, keeping the mode last set.
+func (f *FTDI) Break(on bool) error {
+	return f.request(FTDI_SET_DATA, f.data(f.mode, on), f.index)
+}
+
+// SetLatency sets how many milliseconds the chip holds a part filled
+// packet before sending it, 1 to 255; it is 16 after reset.
+func (f *FTDI) SetLatency(ms uint8) error {
+	return f.request(FTDI_SET_LATENCY_TIMER, uint16(ms), f.index)
+}
+
+// Read strips the status bytes the chip puts at the start of every
+// packet, which arrive every latency period with or without data.
+func (f *FTDI) Read(b []byte) (int, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for len(f.rest) == 0 {
+		n, e := f.read(f.buf)
+		if e != nil {
+			return 0, e
+		}
+		f.rest = f.buf[:0]
+		for p := f.buf[:n]; len(p) > 0; {
+			pkt := p[:min(len(p), f.inSize)]
+			p = p[len(pkt):]
+			if len(pkt) > ftdiStatusSize {
+				f.rest = append(f.rest, pkt[ftdiStatusSize:]...)
+			}
+		}
+	}
+	n := copy(b, f.rest)
+	f.rest = f.rest[n:]
+	return n, nil
+}
+
+// Close drops DTR and RTS and releases the interface.  A Read in
+// progress returns io.EOF.
+func (f *FTDI) Close() error {
+	f.closed.Store(true)
+	f.SetLines(false, false)
+	return f.dev.ReleaseInterface(uint32(f.ifc))
+}
@@ -0,0 +1,144 @@
+// Package serial drives USB serial adapters from user space: CDC ACM
+// devices, which most microcontroller boards and modems are, and FTDI
+// chips.  The kernel's drivers are detached from the interfaces claimed.
+package serial
+
+import (
+	"errors"
+	"io"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/richardnwinder/usb"
+)
+
+type Parity uint8
+
+const (
+	PARITY_NONE Parity = iota
+	PARITY_ODD
+	PARITY_EVEN
+	PARITY_MARK
+	PARITY_SPACE
+)
+
+type StopBits uint8
+
+const (
+	STOP_1 StopBits = iota
+	STOP_1_5
+	STOP_2
+)
+
+// Mode is the line's framing.  A zero DataBits means 8.
+type Mode struct {
+	Baud     int
+	DataBits int
+	Parity   Parity
+	StopBits StopBits
+}
+
+func (m Mode) dataBits() int {
+	if m.DataBits == 0 {
+		return 8
+	}
+	return m.DataBits
+}
+
+// Port is an open serial adapter.  Read blocks until data arrives or the
+// port is closed, and returns io.EOF after Close.  Reads and writes may
+// run concurrently with each other, but not with more of their own kind.
+type Port interface {
+	io.ReadWriteCloser
+	SetMode(m Mode) error
+	// SetLines sets the DTR and RTS modem control lines.  Open raises
+	// both, since many devices don't send until DTR is up.
+	SetLines(dtr, rts bool) error
+}
+
+// Open opens the first serial function of the device: its FTDI interface
+// or its CDC ACM function, in the first configuration.
+func Open(dev *usb.Device, di *usb.DeviceInfo) (Port, error) {
+	if len(di.Config) == 0 {
+		return nil, syscall.ENODEV
+	}
+	ci := &di.Config[0]
+	if di.VendorID == FTDI_VID {
+		for i := range ci.Interface {
+			if ci.Interface[i].AlternateSetting == 0 {
+				return OpenFTDI(dev, &ci.Interface[i], len(ci.Interface) > 1)
+			}
+		}
+	}
+	for _, f := range ci.Functions() {
+		if f.Class == usb.CLASS_COMM && f.SubClass == SUBCLASS_ACM {
+			return OpenACM(dev, ci, &f)
+		}
+	}
+	return nil, syscall.ENODEV
+}
+
+// bulkPipe is the pair of bulk endpoints serial data goes over
+type bulkPipe struct {
+	dev     *usb.Device
+	in, out uint8
+	inSize  int
+	closed  atomic.Bool
+}
+
+// findBulk fills in the pipe from an interface's endpoints
+func (p *bulkPipe) findBulk(ii *usb.InterfaceInfo) bool {
+	for _, ep := range ii.Endpoint {
+		if ep.Attributes&usb.ENDPOINT_XFER_MASK != usb.ENDPOINT_XFER_BULK {
+			continue
+		}
+		if ep.EndpointAddress&usb.ENDPOINT_IN != 0 {
+			p.in = ep.EndpointAddress
+			p.inSize = int(ep.MaxPacketSize & 0x7ff)
+		} else {
+			p.out = ep.EndpointAddress
+		}
+	}
+	return p.in != 0 && p.out != 0 && p.inSize != 0
+}
+
+// pollInterval is how often a blocked Read looks to see if the port was
+// closed
+const pollInterval = 200
+
+// read waits for one transfer with data in it
+func (p *bulkPipe) read(b []byte) (int, error) {
+	for {
+		if p.closed.Load() {
+			return 0, io.EOF
+		}
+		n, _, e := p.dev.BulkTransfer(uint32(p.in), uint32(len(b)), pollInterval, b)
+		if errors.Is(e, syscall.ETIMEDOUT) {
+			continue
+		}
+		if e != nil || n > 0 {
+			return n, e
+		}
+	}
+}
+
+func (p *bulkPipe) Write(b []byte) (int, error) {
+	if p.closed.Load() {
+		return 0, syscall.EBADF
+	}
+	n, _, e := p.dev.BulkTransfer(uint32(p.out), uint32(len(b)), uint32(max(len(b), 1000)), b)
+	return n, e
+}
+
+// claim claims the interfaces, taking them from any kernel driver
+func claim(dev *usb.Device, ifcs []uint8) error {
+	f := usb.Function{Interfaces: ifcs}
+	e := f.Claim(dev)
+	if errors.Is(e, syscall.EBUSY) {
+		for _, n := range ifcs {
+			dev.DisconnectDriver(n)
+		}
+		e = f.Claim(dev)
+	}
+	return e
+}
@@ -0,0 +1,71 @@
+package usb
+
+import "sync"
+
+// urbSlabSize is how many URBs a Device allocates at a time, and
+// isoSlabSize how many of the larger isochronous ones.
+const (
+	urbSlabSize = 64
+	isoSlabSize = 8
+)
+
+// urbSlab hands out the URBs the kernel is given.  They are allocated a
+// slab at a time and kept until the device is garbage, so submitting
+// allocates only when more transfers are in flight than the device has
+// had before, and a Transfer holds a URB only while the kernel does.
+// Isochronous URBs carry their packet descriptors and come from slabs of
+// their own, so only devices that use them pay for the room.
+type urbSlab struct {
+	lock    sync.Mutex
+	free    []*usbdevfs_urb
+	size    int // URBs allocated
+	isoFree []*isoURB
+	isoSize int
+}
+
+func (s *urbSlab) get() *usbdevfs_urb {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(s.free) == 0 {
+		slab := make([]usbdevfs_urb, urbSlabSize)
+		for i := range slab {
+			s.free = append(s.free, &slab[i])
+		}
+		s.size += urbSlabSize
+	}
+	urb := s.free[len(s.free)-1]
+	s.free = s.free[:len(s.free)-1]
+	return urb
+}
+
+// put returns a URB the kernel has given back
+func (s *urbSlab) put(urb *usbdevfs_urb) {
+	*urb = usbdevfs_urb{}
+	s.lock.Lock()
+	s.free = append(s.free, urb)
+	s.lock.Unlock()
+}
+
+func (s *urbSlab) getISO() *isoURB {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(s.isoFree) == 0 {
+		slab := make([]isoURB, isoSlabSize)
+		for i := range slab {
+			s.isoFree = append(s.isoFree, &slab[i])
+		}
+		s.isoSize += isoSlabSize
+	}
+	urb := s.isoFree[len(s.isoFree)-1]
+	s.isoFree = s.isoFree[:len(s.isoFree)-1]
+	return urb
+}
+
+func (s *urbSlab) putISO(urb *isoURB) {
+	n := urb.urb.number_of_packets
+	urb.urb = usbdevfs_urb{}
+	clear(urb.desc[:n])
+	s.lock.Lock()
+	s.isoFree = append(s.isoFree, urb)
+	s.lock.Unlock()
+}
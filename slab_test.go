@@ -0,0 +1,55 @@
+package usb
+
+import "testing"
+
+func TestURBSlab(t *testing.T) {
+	var s urbSlab
+	seen := map[*usbdevfs_urb]bool{}
+	var urbs []*usbdevfs_urb
+	for i := 0; i < 2*urbSlabSize+1; i++ {
+		urb := s.get()
+		if seen[urb] {
+			t.Fatalf("URB %d handed out twice", i)
+		}
+		seen[urb] = true
+		urb.usercontext = uintptr(i + 1)
+		urbs = append(urbs, urb)
+	}
+	if s.size != 3*urbSlabSize {
+		t.Errorf("%d URBs allocated for %d in use, want %d", s.size, len(urbs), 3*urbSlabSize)
+	}
+	for _, urb := range urbs {
+		s.put(urb)
+	}
+	for range urbs {
+		urb := s.get()
+		if !seen[urb] {
+			t.Fatal("a new URB allocated with freed ones to hand")
+		}
+		if *urb != (usbdevfs_urb{}) {
+			t.Fatalf("URB handed out again uncleared: %+v", *urb)
+		}
+	}
+	if s.size != 3*urbSlabSize {
+		t.Errorf("%d URBs allocated after reuse, want %d", s.size, 3*urbSlabSize)
+	}
+}
+
+// TestURBSlabAllocs checks that taking and returning URBs allocates
+// nothing once the slab has grown to the number in use
+func TestURBSlabAllocs(t *testing.T) {
+	var s urbSlab
+	urbs := make([]*usbdevfs_urb, 8)
+	cycle := func() {
+		for i := range urbs {
+			urbs[i] = s.get()
+		}
+		for _, urb := range urbs {
+			s.put(urb)
+		}
+	}
+	cycle()
+	if n := testing.AllocsPerRun(100, cycle); n != 0 {
+		t.Errorf("%v allocations per cycle of %d URBs, want 0", n, len(urbs))
+	}
+}
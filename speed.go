@@ -0,0 +1,149 @@
+package usb
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Speed is the signalling rate a device is running at.
+type Speed int
+
+const (
+	SPEED_UNKNOWN    Speed = iota
+	SPEED_LOW              // 1.5 Mbit/s
+	SPEED_FULL             // 12 Mbit/s
+	SPEED_HIGH             // 480 Mbit/s
+	SPEED_SUPER            // 5 Gbit/s
+	SPEED_SUPER_PLUS       // 10 Gbit/s and up
+)
+
+var speedNames = []string{"unknown", "low", "full", "high", "super", "super+"}
+
+func (s Speed) String() string {
+	if s < 0 || int(s) >= len(speedNames) {
+		return speedNames[0]
+	}
+	return speedNames[s]
+}
+
+// Speed reads the speed the device enumerated at from sysfs.
+func (di *DeviceInfo) Speed() (Speed, error) {
+	return readSpeed(di.syspath)
+}
+
+func readSpeed(dir string) (Speed, error) {
+	switch readAttr(dir + "/speed") {
+	case "":
+		return SPEED_UNKNOWN, syscall.ENOENT
+	case "1.5":
+		return SPEED_LOW, nil
+	case "12":
+		return SPEED_FULL, nil
+	case "480":
+		return SPEED_HIGH, nil
+	case "5000":
+		return SPEED_SUPER, nil
+	case "10000", "20000":
+		return SPEED_SUPER_PLUS, nil
+	}
+	return SPEED_UNKNOWN, nil
+}
+
+// SpeedCheck compares the speed a device enumerated at with what its
+// descriptors say it can do.
+type SpeedCheck struct {
+	Speed      Speed  // as enumerated
+	Capable    Speed  // the fastest the descriptors claim, SPEED_UNKNOWN if they don't say
+	UsbVersion uint16 // bcdUSB
+	Upstream   Speed  // the hub port's, as its hub enumerated; SPEED_UNKNOWN if unknown
+
+	Problems []string // discrepancies, in plain words
+}
+
+// CheckSpeed cross-checks the sysfs speed, bcdUSB and, if bos isn't nil,
+// the device's BOS capabilities.  A SuperSpeed device found running at
+// high speed behind a SuperSpeed hub usually means a bad cable or
+// connector: the SuperSpeed pairs failed and the link fell back to USB
+// 2.0.  High speed devices running at full speed are only caught when
+// the BOS lists the speeds supported, as bcdUSB 2.00 is claimed by many
+// full speed devices too.
+func (di *DeviceInfo) CheckSpeed(bos *BOS) (*SpeedCheck, error) {
+	sp, e := di.Speed()
+	if e != nil {
+		return nil, e
+	}
+	c := &SpeedCheck{Speed: sp, UsbVersion: di.UsbVersion, Upstream: SPEED_UNKNOWN}
+	if len(di.Ports) > 0 {
+		c.Upstream, _ = readSpeed(di.parentPath())
+	}
+	if di.UsbVersion >= 0x0300 {
+		c.Capable = SPEED_SUPER
+	}
+	var ss DeviceCapability
+	if bos != nil {
+		if caps := bos.Find(CAP_SUPERSPEED_USB); len(caps) > 0 && len(caps[0]) >= 6 {
+			ss = caps[0]
+			// wSpeedsSupported: bit 0 low, 1 full, 2 high, 3 5 Gbit/s
+			speeds := uint16(ss[4]) | uint16(ss[5])<<8
+			for bit := 3; bit >= 0; bit-- {
+				if speeds&(1<<bit) != 0 {
+					c.Capable = max(c.Capable, Speed(bit+1))
+					break
+				}
+			}
+		}
+		if len(bos.Find(CAP_SUPERSPEED_PLUS)) > 0 {
+			c.Capable = SPEED_SUPER_PLUS
+		}
+	}
+	version := bcd(di.UsbVersion)
+
+	// descriptors that contradict each other or the link
+	if sp >= SPEED_SUPER && di.UsbVersion < 0x0300 {
+		c.problem("running at %s speed but bcdUSB is %s; the device descriptor is wrong", sp, version)
+	}
+	if sp == SPEED_HIGH && di.UsbVersion < 0x0200 {
+		c.problem("running at high speed but bcdUSB is %s; the device descriptor is wrong", version)
+	}
+	if bos != nil && ss == nil && di.UsbVersion >= 0x0300 {
+		c.problem("bcdUSB is %s but the BOS has no SuperSpeed USB capability", version)
+	}
+	if ss != nil && di.UsbVersion < 0x0300 && c.Capable >= SPEED_SUPER {
+		c.problem("the BOS claims SuperSpeed but bcdUSB is %s", version)
+	}
+
+	// a link slower than both ends support
+	if sp != SPEED_UNKNOWN && c.Capable > sp {
+		switch {
+		case c.Upstream != SPEED_UNKNOWN && c.Upstream <= sp:
+			c.problem("%s speed capable device is limited to %s speed by its upstream port", c.Capable, sp)
+		case c.Capable >= SPEED_SUPER && sp < SPEED_SUPER:
+			c.problem("USB %s device enumerated at %s speed; check the cable and connector for failed SuperSpeed lanes", version, sp)
+		case c.Capable == SPEED_SUPER_PLUS:
+			c.problem("SuperSpeedPlus device enumerated at 5 Gbit/s; check the cable is rated for 10 Gbit/s")
+		default:
+			c.problem("%s speed capable device enumerated at %s speed; check the cable and connector", c.Capable, sp)
+		}
+	}
+	return c, nil
+}
+
+func (c *SpeedCheck) problem(format string, args ...any) {
+	c.Problems = append(c.Problems, fmt.Sprintf(format, args...))
+}
+
+// parentPath is the sysfs directory of the hub the device is plugged
+// into, the root hub's for devices on a root port
+func (di *DeviceInfo) parentPath() string {
+	dir := di.syspath[:strings.LastIndexByte(di.syspath, '/')+1]
+	if len(di.Ports) == 1 {
+		return dir + "usb" + strconv.Itoa(di.BusNum)
+	}
+	ports := make([]string, len(di.Ports)-1)
+	for i, p := range di.Ports[:len(di.Ports)-1] {
+		ports[i] = strconv.Itoa(p)
+	}
+	return dir + strconv.Itoa(di.BusNum) + "-" + strings.Join(ports, ".")
+}
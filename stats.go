@@ -0,0 +1,192 @@
+package usb
+
+import (
+	"errors"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// EndpointStats counts the traffic on one endpoint of an open Device.
+// Endpoint 0 covers every control transfer.
+type EndpointStats struct {
+	Transfers uint64
+	Bytes     uint64
+	Errors    map[string]uint64 // by URBError name
+	InFlight  int               // submitted and not yet completed
+	Latency   Histogram         // from submission to completion
+}
+
+// HISTOGRAM_BUCKETS is how many buckets a Histogram has.  Bucket i holds
+// latencies up to 1µs<<i, and the last everything longer, so the buckets
+// run to about 33s with the relative precision of a factor of two, in
+// the manner of an HDR histogram.
+const HISTOGRAM_BUCKETS = 27
+
+// Histogram counts transfer latencies in logarithmic buckets.
+type Histogram struct {
+	Counts [HISTOGRAM_BUCKETS]uint64
+	Sum    time.Duration
+}
+
+// Bound is the upper bound of bucket i; the last has none, so it gives
+// the largest Duration.
+func (h *Histogram) Bound(i int) time.Duration {
+	if i >= HISTOGRAM_BUCKETS-1 {
+		return time.Duration(1<<63 - 1)
+	}
+	return time.Microsecond << i
+}
+
+func (h *Histogram) Observe(d time.Duration) {
+	i := 0
+	for i < HISTOGRAM_BUCKETS-1 && d > h.Bound(i) {
+		i++
+	}
+	h.Counts[i]++
+	h.Sum += d
+}
+
+func (h *Histogram) Count() uint64 {
+	var n uint64
+	for _, c := range h.Counts {
+		n += c
+	}
+	return n
+}
+
+// Add adds o's counts to h, as when combining devices or endpoints.
+func (h *Histogram) Add(o Histogram) {
+	for i, c := range o.Counts {
+		h.Counts[i] += c
+	}
+	h.Sum += o.Sum
+}
+
+// Quantile is the upper bound of the bucket the q'th quantile falls in;
+// Quantile(0.99) is a latency at least 99% of transfers beat.  It is 0
+// for an empty histogram.
+func (h *Histogram) Quantile(q float64) time.Duration {
+	n := h.Count()
+	if n == 0 {
+		return 0
+	}
+	rank := uint64(q * float64(n))
+	var seen uint64
+	for i, c := range h.Counts {
+		seen += c
+		if seen > rank || seen == n {
+			return h.Bound(i)
+		}
+	}
+	return h.Bound(HISTOGRAM_BUCKETS - 1)
+}
+
+// errorName files an error under the name of its URB status
+func errorName(e error) string {
+	var ue *URBError
+	if errors.As(e, &ue) {
+		return ue.Name
+	}
+	var errno syscall.Errno
+	if errors.As(e, &errno) {
+		if ue := urbErrors[errno]; ue != nil {
+			return ue.Name
+		}
+		return errno.Error()
+	}
+	return "other"
+}
+
+func (u *Device) endpointStats(ep uint8) *EndpointStats {
+	if u.stats == nil {
+		u.stats = map[uint8]*EndpointStats{}
+	}
+	s := u.stats[ep]
+	if s == nil {
+		s = &EndpointStats{Errors: map[string]uint64{}}
+		u.stats[ep] = s
+	}
+	return s
+}
+
+// record counts a transfer on ep that started at start
+func (u *Device) record(ep uint8, n int, e error, start time.Time) {
+	d := time.Since(start)
+	u.statLock.Lock()
+	s := u.endpointStats(ep)
+	s.Transfers++
+	s.Latency.Observe(d)
+	if n > 0 {
+		s.Bytes += uint64(n)
+	}
+	if e != nil {
+		s.Errors[errorName(e)]++
+	}
+	u.statLock.Unlock()
+	if errors.Is(e, syscall.EPIPE) {
+		u.halt(ep)
+	}
+	u.observe(ep, e)
+}
+
+func (u *Device) inflight(ep uint8, delta int) {
+	u.statLock.Lock()
+	u.endpointStats(ep).InFlight += delta
+	u.statLock.Unlock()
+}
+
+// Stats returns a snapshot of the per-endpoint counters, keyed by
+// endpoint address.
+func (u *Device) Stats() map[uint8]EndpointStats {
+	u.statLock.Lock()
+	defer u.statLock.Unlock()
+	m := make(map[uint8]EndpointStats, len(u.stats))
+	for ep, s := range u.stats {
+		c := *s
+		c.Errors = make(map[string]uint64, len(s.Errors))
+		for k, v := range s.Errors {
+			c.Errors[k] = v
+		}
+		m[ep] = c
+	}
+	return m
+}
+
+// reapBatch is the most completions the reaper collects before
+// delivering them.
+const reapBatch = 64
+
+// ReapStats counts how the reaper collected completed URBs: Batches is
+// the number of times it delivered a batch, URBs the total in them, and
+// Largest the biggest.  URBs/Batches near 1 means completions arrive
+// one at a time; larger means the batching is saving wakeups.
+type ReapStats struct {
+	Batches uint64
+	URBs    uint64
+	Largest int
+}
+
+// reapCounters is written by the reaper only
+type reapCounters struct {
+	batches atomic.Uint64
+	urbs    atomic.Uint64
+	largest atomic.Int32
+}
+
+func (c *reapCounters) add(n int) {
+	c.batches.Add(1)
+	c.urbs.Add(uint64(n))
+	if int32(n) > c.largest.Load() {
+		c.largest.Store(int32(n))
+	}
+}
+
+// ReapStats returns the reaper's counters.
+func (u *Device) ReapStats() ReapStats {
+	return ReapStats{
+		Batches: u.reapStats.batches.Load(),
+		URBs:    u.reapStats.urbs.Load(),
+		Largest: int(u.reapStats.largest.Load()),
+	}
+}
@@ -0,0 +1,76 @@
+package usb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReapStats(t *testing.T) {
+	u := &Device{}
+	for _, n := range []int{1, 5, 3, 1} {
+		u.reapStats.add(n)
+	}
+	if got, want := u.ReapStats(), (ReapStats{Batches: 4, URBs: 10, Largest: 5}); got != want {
+		t.Errorf("ReapStats() = %+v, want %+v", got, want)
+	}
+}
+
+func TestHistogramBuckets(t *testing.T) {
+	var h Histogram
+	for _, c := range []struct {
+		d      time.Duration
+		bucket int
+	}{
+		{0, 0},
+		{time.Microsecond, 0},
+		{time.Microsecond + 1, 1},
+		{3 * time.Microsecond, 2},
+		{time.Millisecond, 10},
+		{time.Second, 20},
+		{time.Hour, HISTOGRAM_BUCKETS - 1},
+	} {
+		before := h.Counts[c.bucket]
+		h.Observe(c.d)
+		if h.Counts[c.bucket] != before+1 {
+			t.Errorf("%v not counted in bucket %d (bound %v)", c.d, c.bucket, h.Bound(c.bucket))
+		}
+	}
+	if h.Count() != 7 {
+		t.Errorf("Count() = %d, want 7", h.Count())
+	}
+	if h.Bound(HISTOGRAM_BUCKETS-1) <= time.Hour {
+		t.Errorf("last bucket bounded at %v", h.Bound(HISTOGRAM_BUCKETS-1))
+	}
+}
+
+func TestHistogramQuantile(t *testing.T) {
+	var h Histogram
+	if q := h.Quantile(0.5); q != 0 {
+		t.Errorf("empty Quantile(0.5) = %v, want 0", q)
+	}
+	for i := 0; i < 99; i++ {
+		h.Observe(time.Microsecond)
+	}
+	h.Observe(time.Millisecond)
+	for _, c := range []struct {
+		q    float64
+		want time.Duration
+	}{
+		{0, time.Microsecond},
+		{0.5, time.Microsecond},
+		{0.98, time.Microsecond},
+		{0.99, 1024 * time.Microsecond},
+		{1, 1024 * time.Microsecond},
+	} {
+		if got := h.Quantile(c.q); got != c.want {
+			t.Errorf("Quantile(%v) = %v, want %v", c.q, got, c.want)
+		}
+	}
+
+	var sum Histogram
+	sum.Add(h)
+	sum.Add(h)
+	if sum.Count() != 200 || sum.Sum != 2*h.Sum || sum.Counts[10] != 2 {
+		t.Errorf("adding twice gave %d transfers, sum %v", sum.Count(), sum.Sum)
+	}
+}
@@ -0,0 +1,85 @@
+package usb
+
+import "syscall"
+
+// URBError is a decoded URB completion status.  The kernel reports these
+// as negative errno values, most of which mean something USB-specific
+// quite different from their usual text, so each one carries a short name
+// and a hint at the likely cause.  errors.Is matches the underlying errno.
+type URBError struct {
+	Errno syscall.Errno
+	Name  string
+	Hint  string
+}
+
+func (e *URBError) Error() string {
+	return "usb: " + e.Name + ": " + e.Hint
+}
+
+func (e *URBError) String() string {
+	return e.Name
+}
+
+func (e *URBError) Unwrap() error {
+	return e.Errno
+}
+
+var (
+	ErrStall = &URBError{syscall.EPIPE, "stall",
+		"endpoint halted or request not supported; ClearHalt the endpoint or check the request"}
+	ErrProtocol = &URBError{syscall.EPROTO, "protocol error",
+		"bitstuff error or no response packet; suspect the cable, hub or device firmware"}
+	ErrCRC = &URBError{syscall.EILSEQ, "CRC mismatch",
+		"corrupted packet; suspect signal integrity (cable, connector, EMI)"}
+	ErrNoResponse = &URBError{syscall.ETIME, "no response",
+		"device did not answer within the bus turnaround time"}
+	ErrBabble = &URBError{syscall.EOVERFLOW, "babble",
+		"device sent more than requested; make IN buffers a multiple of wMaxPacketSize"}
+	ErrShortPacket = &URBError{syscall.EREMOTEIO, "short packet",
+		"device sent less than requested with SHORT_NOT_OK set"}
+	ErrCancelled = &URBError{syscall.ENOENT, "cancelled",
+		"transfer was discarded by CancelTransfer or Close"}
+	ErrUnlinked = &URBError{syscall.ECONNRESET, "unlinked",
+		"transfer was unlinked asynchronously, usually by an endpoint reset"}
+	ErrShutdown = &URBError{syscall.ESHUTDOWN, "shutdown",
+		"host controller or device is being disabled or suspended"}
+	ErrDisconnected = &URBError{syscall.ENODEV, "disconnected",
+		"device was unplugged or reset; reopen it"}
+	ErrTimeout = &URBError{syscall.ETIMEDOUT, "timeout",
+		"transfer did not finish before its timeout"}
+	ErrOverrun = &URBError{syscall.ECOMM, "overrun",
+		"host controller could not write received data to memory fast enough"}
+	ErrUnderrun = &URBError{syscall.ENOSR, "underrun",
+		"host controller could not read data to send from memory fast enough"}
+	ErrPartial = &URBError{syscall.EXDEV, "partial",
+		"isochronous transfer only partially completed; check the per-packet status"}
+	ErrInvalid = &URBError{syscall.EINVAL, "invalid",
+		"URB was rejected as malformed; check type, endpoint and length"}
+)
+
+var urbErrors = map[syscall.Errno]*URBError{}
+
+func init() {
+	for _, e := range []*URBError{ErrStall, ErrProtocol, ErrCRC, ErrNoResponse,
+		ErrBabble, ErrShortPacket, ErrCancelled, ErrUnlinked, ErrShutdown,
+		ErrDisconnected, ErrTimeout, ErrOverrun, ErrUnderrun, ErrPartial, ErrInvalid} {
+		urbErrors[e.Errno] = e
+	}
+}
+
+// StatusError decodes a raw URB status (0 or a negative errno).
+func StatusError(status int32) error {
+	if status == 0 {
+		return nil
+	}
+	errno := syscall.Errno(-status)
+	if e := urbErrors[errno]; e != nil {
+		return e
+	}
+	return &URBError{errno, errno.Error(), "unexpected URB status"}
+}
+
+// Err returns the transfer's completion status as an error, nil on success.
+func (xfer *Transfer) Err() error {
+	return StatusError(xfer.Status)
+}
@@ -0,0 +1,255 @@
+package usb
+
+import (
+	"fmt"
+	"strings"
+)
+
+var classNames = map[uint8]string{
+	0x00: "(Defined at Interface level)",
+	0x01: "Audio",
+	0x02: "Communications",
+	0x03: "Human Interface Device",
+	0x05: "Physical Interface Device",
+	0x06: "Imaging",
+	0x07: "Printer",
+	0x08: "Mass Storage",
+	0x09: "Hub",
+	0x0a: "CDC Data",
+	0x0b: "Chip/SmartCard",
+	0x0d: "Content Security",
+	0x0e: "Video",
+	0x0f: "Personal Healthcare",
+	0x10: "Audio/Video",
+	0x11: "Billboard",
+	0x12: "Type-C Bridge",
+	0xdc: "Diagnostic",
+	0xe0: "Wireless",
+	0xef: "Miscellaneous Device",
+	0xfe: "Application Specific Interface",
+	0xff: "Vendor Specific Class",
+}
+
+// ClassName returns the name of a device or interface class code.
+func ClassName(class uint8) string {
+	if s, ok := classNames[class]; ok {
+		return s
+	}
+	return "[unknown]"
+}
+
+func bcd(v uint16) string {
+	return fmt.Sprintf("%x.%02x", v>>8, v&0xff)
+}
+
+// field writes one lsusb style "name value" line
+func field(b *strings.Builder, indent string, name string, format string, args ...any) {
+	fmt.Fprintf(b, "%s%-20s"+format+"\n", append([]any{indent, name}, args...)...)
+}
+
+func (d DeviceDescriptor) format(b *strings.Builder, indent string) {
+	fmt.Fprintf(b, "%sDevice Descriptor:\n", indent)
+	indent += "  "
+	field(b, indent, "bLength", "%d", d.Length)
+	field(b, indent, "bDescriptorType", "%d", d.DescriptorType)
+	field(b, indent, "bcdUSB", "%s", bcd(d.UsbVersion))
+	field(b, indent, "bDeviceClass", "%d %s", d.DeviceClass, ClassName(d.DeviceClass))
+	field(b, indent, "bDeviceSubClass", "%d", d.DeviceSubClass)
+	field(b, indent, "bDeviceProtocol", "%d", d.DeviceProtocol)
+	field(b, indent, "bMaxPacketSize0", "%d", d.MaxPacketSize0)
+	field(b, indent, "idVendor", "0x%04x", d.VendorID)
+	field(b, indent, "idProduct", "0x%04x", d.ProductID)
+	field(b, indent, "bcdDevice", "%s", bcd(d.DeviceVersion))
+	field(b, indent, "iManufacturer", "%d", d.ManufacturerIdx)
+	field(b, indent, "iProduct", "%d", d.ProductIdx)
+	field(b, indent, "iSerial", "%d", d.SerialNumberIdx)
+	field(b, indent, "bNumConfigurations", "%d", d.NumConfigurations)
+}
+
+func (d DeviceDescriptor) String() string {
+	var b strings.Builder
+	d.format(&b, "")
+	return b.String()
+}
+
+func (d DeviceQualifier) String() string {
+	var b strings.Builder
+	b.WriteString("Device Qualifier (for other device speed):\n")
+	field(&b, "  ", "bLength", "%d", d.Length)
+	field(&b, "  ", "bDescriptorType", "%d", d.DescriptorType)
+	field(&b, "  ", "bcdUSB", "%s", bcd(d.UsbVersion))
+	field(&b, "  ", "bDeviceClass", "%d %s", d.DeviceClass, ClassName(d.DeviceClass))
+	field(&b, "  ", "bDeviceSubClass", "%d", d.DeviceSubClass)
+	field(&b, "  ", "bDeviceProtocol", "%d", d.DeviceProtocol)
+	field(&b, "  ", "bMaxPacketSize0", "%d", d.MaxPacketSize0)
+	field(&b, "  ", "bNumConfigurations", "%d", d.NumConfigurations)
+	return b.String()
+}
+
+func (d ConfigDescriptor) format(b *strings.Builder, indent string) {
+	if d.DescriptorType == DT_OTHER_SPEED_CONFIG {
+		fmt.Fprintf(b, "%sOther Speed Configuration Descriptor:\n", indent)
+	} else {
+		fmt.Fprintf(b, "%sConfiguration Descriptor:\n", indent)
+	}
+	indent += "  "
+	field(b, indent, "bLength", "%d", d.Length)
+	field(b, indent, "bDescriptorType", "%d", d.DescriptorType)
+	field(b, indent, "wTotalLength", "0x%04x", d.TotalLength)
+	field(b, indent, "bNumInterfaces", "%d", d.NumInterfaces)
+	field(b, indent, "bConfigurationValue", "%d", d.ConfigurationValue)
+	field(b, indent, "iConfiguration", "%d", d.ConfigurationIdx)
+	field(b, indent, "bmAttributes", "0x%02x", d.Attributes)
+	if d.Attributes&0x40 != 0 {
+		fmt.Fprintf(b, "%s  Self Powered\n", indent)
+	} else {
+		fmt.Fprintf(b, "%s  (Bus Powered)\n", indent)
+	}
+	if d.Attributes&0x20 != 0 {
+		fmt.Fprintf(b, "%s  Remote Wakeup\n", indent)
+	}
+	// SuperSpeed devices count in 8mA units, but the descriptor alone
+	// doesn't say which kind this is
+	field(b, indent, "MaxPower", "%dmA", int(d.MaxPower)*2)
+}
+
+func (d ConfigDescriptor) String() string {
+	var b strings.Builder
+	d.format(&b, "")
+	return b.String()
+}
+
+func (d InterfaceDescriptor) format(b *strings.Builder, indent string) {
+	fmt.Fprintf(b, "%sInterface Descriptor:\n", indent)
+	indent += "  "
+	field(b, indent, "bLength", "%d", d.Length)
+	field(b, indent, "bDescriptorType", "%d", d.DescriptorType)
+	field(b, indent, "bInterfaceNumber", "%d", d.InterfaceNumber)
+	field(b, indent, "bAlternateSetting", "%d", d.AlternateSetting)
+	field(b, indent, "bNumEndpoints", "%d", d.NumEndpoints)
+	field(b, indent, "bInterfaceClass", "%d %s", d.InterfaceClass, ClassName(d.InterfaceClass))
+	field(b, indent, "bInterfaceSubClass", "%d", d.InterfaceSubClass)
+	field(b, indent, "bInterfaceProtocol", "%d", d.InterfaceProtocol)
+	field(b, indent, "iInterface", "%d", d.InterfaceIdx)
+}
+
+func (d InterfaceDescriptor) String() string {
+	var b strings.Builder
+	d.format(&b, "")
+	return b.String()
+}
+
+var transferTypes = []string{"Control", "Isochronous", "Bulk", "Interrupt"}
+var syncTypes = []string{"None", "Asynchronous", "Adaptive", "Synchronous"}
+var usageTypes = []string{"Data", "Feedback", "Implicit feedback Data", "Reserved"}
+
+func (d EndpointDescriptor) format(b *strings.Builder, indent string) {
+	fmt.Fprintf(b, "%sEndpoint Descriptor:\n", indent)
+	indent += "  "
+	dir := "OUT"
+	if d.EndpointAddress&ENDPOINT_IN != 0 {
+		dir = "IN"
+	}
+	field(b, indent, "bLength", "%d", d.Length)
+	field(b, indent, "bDescriptorType", "%d", d.DescriptorType)
+	field(b, indent, "bEndpointAddress", "0x%02x  EP %d %s", d.EndpointAddress, d.EndpointAddress&0x0f, dir)
+	field(b, indent, "bmAttributes", "%d", d.Attributes)
+	kind := d.Attributes & ENDPOINT_XFER_MASK
+	fmt.Fprintf(b, "%s  Transfer Type            %s\n", indent, transferTypes[kind])
+	if kind == ENDPOINT_XFER_ISOC {
+		fmt.Fprintf(b, "%s  Synch Type               %s\n", indent, syncTypes[d.Attributes>>2&3])
+	}
+	if kind == ENDPOINT_XFER_ISOC || kind == ENDPOINT_XFER_INT {
+		fmt.Fprintf(b, "%s  Usage Type               %s\n", indent, usageTypes[d.Attributes>>4&3])
+	}
+	field(b, indent, "wMaxPacketSize", "0x%04x  %dx %d bytes", d.MaxPacketSize,
+		1+d.MaxPacketSize>>11&3, d.MaxPacketSize&0x7ff)
+	field(b, indent, "bInterval", "%d", d.Interval)
+}
+
+func (d EndpointDescriptor) String() string {
+	var b strings.Builder
+	d.format(&b, "")
+	return b.String()
+}
+
+var requestNames = map[uint8]string{
+	REQ_GET_STATUS:        "GET_STATUS",
+	REQ_CLEAR_FEATURE:     "CLEAR_FEATURE",
+	REQ_SET_FEATURE:       "SET_FEATURE",
+	REQ_SET_ADDRESS:       "SET_ADDRESS",
+	REQ_GET_DESCRIPTOR:    "GET_DESCRIPTOR",
+	REQ_SET_DESCRIPTOR:    "SET_DESCRIPTOR",
+	REQ_GET_CONFIGURATION: "GET_CONFIGURATION",
+	REQ_SET_CONFIGURATION: "SET_CONFIGURATION",
+	REQ_GET_INTERFACE:     "GET_INTERFACE",
+	REQ_SET_INTERFACE:     "SET_INTERFACE",
+	REQ_SYNCH_FRAME:       "SYNCH_FRAME",
+	REQ_SET_SEL:           "SET_SEL",
+	REQ_SET_ISOCH_DELAY:   "SET_ISOCH_DELAY",
+}
+
+// String decodes a setup packet the way bus analyzers show it.
+func (r ControlRequest) String() string {
+	dir := "OUT"
+	if r.RequestType&REQTYPE_IN != 0 {
+		dir = "IN"
+	}
+	kind := []string{"standard", "class", "vendor", "reserved"}[r.RequestType>>5&3]
+	recip := []string{"device", "interface", "endpoint", "other"}[min(r.RequestType&0x1f, 3)]
+	name := fmt.Sprintf("0x%02x", r.Request)
+	if n, ok := requestNames[r.Request]; ok && r.RequestType&0x60 == REQTYPE_STANDARD {
+		name = n
+	}
+	return fmt.Sprintf("%s %s %s %s wValue=0x%04x wIndex=0x%04x wLength=%d",
+		dir, kind, recip, name, r.Value, r.Index, r.Length)
+}
+
+func (ii *InterfaceInfo) format(b *strings.Builder, indent string) {
+	ii.InterfaceDescriptor.format(b, indent)
+	if len(ii.Extra) > 0 {
+		fmt.Fprintf(b, "%s  ** class specific descriptors: % x\n", indent, ii.Extra)
+	}
+	for _, ep := range ii.Endpoint {
+		ep.format(b, indent+"  ")
+	}
+}
+
+func (ii *InterfaceInfo) String() string {
+	var b strings.Builder
+	ii.format(&b, "")
+	return b.String()
+}
+
+func (ci *ConfigInfo) format(b *strings.Builder, indent string) {
+	ci.ConfigDescriptor.format(b, indent)
+	if len(ci.Extra) > 0 {
+		fmt.Fprintf(b, "%s  ** extra descriptors: % x\n", indent, ci.Extra)
+	}
+	for i := range ci.Interface {
+		ci.Interface[i].format(b, indent+"  ")
+	}
+}
+
+func (ci *ConfigInfo) String() string {
+	var b strings.Builder
+	ci.format(&b, "")
+	return b.String()
+}
+
+// String gives lsusb's one line summary.  Verbose prints the whole
+// descriptor tree.
+func (di *DeviceInfo) String() string {
+	return fmt.Sprintf("Bus %03d Device %03d: ID %04x:%04x", di.BusNum, di.DevNum, di.VendorID, di.ProductID)
+}
+
+func (di *DeviceInfo) Verbose() string {
+	var b strings.Builder
+	b.WriteString(di.String())
+	b.WriteString("\n")
+	di.DeviceDescriptor.format(&b, "")
+	for i := range di.Config {
+		di.Config[i].format(&b, "  ")
+	}
+	return b.String()
+}
@@ -0,0 +1,139 @@
+package usb
+
+import (
+	"encoding/binary"
+	"errors"
+	"syscall"
+	"time"
+)
+
+// TapEvent is one side of a transfer the library performed: the
+// submission, or the completion that matches it by ID.  Data is only
+// valid during the call.
+type TapEvent struct {
+	ID       uint64
+	Complete bool  // false at submission
+	Type     uint8 // URB_TYPE_*
+	Endpoint uint8 // with ENDPOINT_IN for reads; 0 or 0x80 for control
+	Bus      int
+	Device   int
+	Setup    []byte // the 8 setup bytes, on control submissions
+	Status   int32  // completions: 0 or a negative errno
+	Length   int    // requested at submission, moved at completion
+	Data     []byte // OUT data at submission, IN data at completion
+	Time     time.Time
+}
+
+// Tap receives every transfer on a device.  It runs in the caller's
+// goroutine, or the reaper's for completions, so it should be quick.
+type Tap func(ev *TapEvent)
+
+type tapBox struct{ t Tap }
+
+// SetTap sends the device's traffic to t, or stops if t is nil.  The
+// capture package turns these into pcapng for Wireshark, without
+// needing usbmon.
+func (u *Device) SetTap(t Tap) {
+	u.tap.Store(tapBox{t})
+}
+
+func (u *Device) getTap() Tap {
+	b, _ := u.tap.Load().(tapBox)
+	return b.t
+}
+
+// tapSubmit reports a submission, returning its ID, or 0 when nobody is
+// listening
+func (u *Device) tapSubmit(kind uint8, ep uint8, setup []byte, data []byte) uint64 {
+	t := u.getTap()
+	if t == nil {
+		return 0
+	}
+	id := u.tapID.Add(1)
+	ev := &TapEvent{ID: id, Type: kind, Endpoint: ep, Bus: u.bus, Device: u.devnum,
+		Setup: setup, Length: len(data), Time: time.Now()}
+	if ep&ENDPOINT_IN == 0 {
+		ev.Data = data
+	}
+	t(ev)
+	return id
+}
+
+func (u *Device) tapControl(ct *ctrltransfer, data []byte) uint64 {
+	if u.getTap() == nil {
+		return 0
+	}
+	setup := []byte{ct.bRequestType, ct.bRequest, 0, 0, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint16(setup[2:], ct.wValue)
+	binary.LittleEndian.PutUint16(setup[4:], ct.wIndex)
+	binary.LittleEndian.PutUint16(setup[6:], ct.wLength)
+	return u.tapSubmit(URB_TYPE_CONTROL, ct.bRequestType&ENDPOINT_IN, setup, data[:ct.wLength])
+}
+
+func (u *Device) tapDone(id uint64, kind uint8, ep uint8, n int, e error, data []byte) {
+	t := u.getTap()
+	if t == nil {
+		return
+	}
+	ev := &TapEvent{ID: id, Complete: true, Type: kind, Endpoint: ep, Bus: u.bus, Device: u.devnum,
+		Length: n, Time: time.Now()}
+	var errno syscall.Errno
+	if errors.As(e, &errno) {
+		ev.Status = -int32(errno)
+	} else if e != nil {
+		ev.Status = -int32(syscall.EIO)
+	}
+	if ep&ENDPOINT_IN != 0 && n > 0 {
+		ev.Data = data[:n]
+	}
+	t(ev)
+}
+
+func (u *Device) tapTransfer(xfer *Transfer) {
+	if xfer.tapID == 0 {
+		return
+	}
+	id := xfer.tapID
+	xfer.tapID = 0
+	t := u.getTap()
+	if t == nil {
+		return
+	}
+	ev := &TapEvent{ID: id, Complete: true, Type: xfer.Type, Endpoint: xfer.Endpoint, Bus: u.bus,
+		Device: u.devnum, Status: xfer.Status, Length: int(xfer.Length), Time: time.Now()}
+	if xfer.Endpoint&ENDPOINT_IN != 0 && xfer.Length > 0 {
+		ev.Data = xfer.Data[:xfer.Length]
+	}
+	t(ev)
+}
+
+// TapOp is an operation on a device other than a transfer, such as
+// claiming an interface: Name is "claim", "release", "clear_halt",
+// "set_configuration", "set_interface", "reset", "disconnect_driver" or
+// "close", and Args its arguments in the order the method takes them.
+type TapOp struct {
+	Name   string
+	Bus    int
+	Device int
+	Args   []int
+	Err    error
+	Time   time.Time
+}
+
+// OpTap receives a device's operations, after each is done.
+type OpTap func(op *TapOp)
+
+type opTapBox struct{ t OpTap }
+
+// SetOpTap sends the device's operations to t, or stops if t is nil.
+func (u *Device) SetOpTap(t OpTap) {
+	u.opTap.Store(opTapBox{t})
+}
+
+func (u *Device) tapOp(name string, e error, args ...int) {
+	b, _ := u.opTap.Load().(opTapBox)
+	if b.t == nil {
+		return
+	}
+	b.t(&TapOp{Name: name, Bus: u.bus, Device: u.devnum, Args: args, Err: e, Time: time.Now()})
+}
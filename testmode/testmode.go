@@ -0,0 +1,62 @@
+// Package testmode puts devices and hub ports into the USB 2.0 electrical
+// test modes (USB 2.0 section 7.1.20), for signal quality measurements.
+// A device or port in test mode stays there until it is power cycled, or
+// for a hub port, until the hub is reset.
+package testmode
+
+import (
+	"syscall"
+
+	"github.com/richardnwinder/usb"
+	"github.com/richardnwinder/usb/hub"
+)
+
+// Mode selects one of the test modes.
+type Mode uint8
+
+const (
+	TEST_J            Mode = 1
+	TEST_K            Mode = 2
+	TEST_SE0_NAK      Mode = 3
+	TEST_PACKET       Mode = 4
+	TEST_FORCE_ENABLE Mode = 5
+
+	FEATURE_TEST_MODE = 2
+)
+
+// SetDevice puts the upstream port of the device u into test mode.  The
+// device stops responding once the status stage completes.  Only
+// high-speed devices support test modes.
+func SetDevice(u usb.ControlTransferer, mode Mode) error {
+	if mode < TEST_J || mode > TEST_PACKET {
+		return syscall.EINVAL
+	}
+	_, e := u.ControlTransfer(usb.REQTYPE_STANDARD|usb.REQTYPE_DEVICE, usb.REQ_SET_FEATURE,
+		FEATURE_TEST_MODE, uint16(mode)<<8, 0, 1000, nil)
+	return e
+}
+
+// SetPort puts downstream port (counting from 1) of the USB 2.0 hub u
+// into test mode.  The specification requires every other port to be
+// suspended first, which this does; ports that aren't enabled refuse the
+// suspend, which is harmless.  The kernel's hub driver is not told, so
+// expect it to log errors about the hub until it is reset.
+func SetPort(u usb.ControlTransferer, port int, mode Mode) error {
+	if mode < TEST_J || mode > TEST_FORCE_ENABLE || port < 1 || port > 255 {
+		return syscall.EINVAL
+	}
+	desc, e := hub.ReadDescriptor(u, false)
+	if e != nil {
+		return e
+	}
+	n := int(desc.NumPorts)
+	if port > n {
+		return syscall.EINVAL
+	}
+	for p := 1; p <= n; p++ {
+		hub.SetPortFeature(u, p, hub.HUB_PORT_SUSPEND)
+	}
+	_, e = u.ControlTransfer(hub.REQTYPE_PORT, usb.REQ_SET_FEATURE, hub.HUB_PORT_TEST,
+		uint16(mode)<<8|uint16(port), 0, 1000, nil)
+	return e
+}
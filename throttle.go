@@ -0,0 +1,107 @@
+package usb
+
+import (
+	"context"
+	"syscall"
+	"time"
+)
+
+// WriterOptions tunes a ThrottledWriter.
+type WriterOptions struct {
+	// BytesPerSecond and TransfersPerSecond cap the rate data goes out
+	// at; zero leaves it unlimited.  Both can be set.
+	BytesPerSecond     int
+	TransfersPerSecond int
+	// ChunkSize is the most sent in one transfer, 16KiB by default.
+	// With a byte rate set, chunks are also cut to about 50ms worth so
+	// the stream goes out evenly rather than in bursts.
+	ChunkSize int
+	// Timeout is each transfer's timeout in milliseconds, 0 for none.
+	Timeout uint32
+}
+
+// ThrottledWriter is an EndpointWriter on a bulk or interrupt OUT endpoint,
+// with optional throttling for devices that can't take data at the bus
+// rate: bootloaders writing flash as it arrives, or EMC tests that want
+// a steady known load.
+type ThrottledWriter struct {
+	dev  *Device
+	ep   uint8
+	opts WriterOptions
+	next time.Time // when the next transfer may go
+}
+
+func (u *Device) NewThrottledWriter(endpoint uint8, opts WriterOptions) (*ThrottledWriter, error) {
+	if endpoint&ENDPOINT_IN != 0 || opts.BytesPerSecond < 0 || opts.TransfersPerSecond < 0 || opts.ChunkSize < 0 {
+		return nil, syscall.EINVAL
+	}
+	if opts.ChunkSize == 0 {
+		opts.ChunkSize = 16384
+	}
+	if r := opts.BytesPerSecond / 20; r > 0 && r < opts.ChunkSize {
+		opts.ChunkSize = r
+	}
+	return &ThrottledWriter{dev: u, ep: endpoint, opts: opts}, nil
+}
+
+// Write sends p in chunks, waiting as the limits require.  It returns
+// how much the device accepted before any error.  An empty p is sent as
+// a zero length packet.
+func (w *ThrottledWriter) Write(p []byte) (int, error) {
+	return w.WriteContext(context.Background(), p)
+}
+
+// WriteContext is Write, giving up between chunks when ctx is done.
+func (w *ThrottledWriter) WriteContext(ctx context.Context, p []byte) (int, error) {
+	done := 0
+	for len(p) > 0 || done == 0 {
+		n := min(len(p), w.opts.ChunkSize)
+		if e := w.wait(ctx); e != nil {
+			return done, e
+		}
+		sent, _, e := w.dev.BulkTransferContext(ctx, uint32(w.ep), uint32(n), w.opts.Timeout, p[:n])
+		w.account(sent)
+		done += sent
+		if e != nil {
+			return done, e
+		}
+		p = p[n:]
+		if n == 0 {
+			// a zero length write is sent once, as a ZLP
+			break
+		}
+	}
+	return done, nil
+}
+
+func (w *ThrottledWriter) wait(ctx context.Context) error {
+	d := time.Until(w.next)
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// account moves next on by the time n bytes, and one transfer, take at
+// the configured rates.  Time the writer spent idle is not saved up.
+func (w *ThrottledWriter) account(n int) {
+	now := time.Now()
+	if w.next.Before(now) {
+		w.next = now
+	}
+	var gap time.Duration
+	if r := w.opts.BytesPerSecond; r > 0 {
+		gap = time.Duration(n) * time.Second / time.Duration(r)
+	}
+	if r := w.opts.TransfersPerSecond; r > 0 {
+		gap = max(gap, time.Second/time.Duration(r))
+	}
+	w.next = w.next.Add(gap)
+}
@@ -0,0 +1,131 @@
+package usb
+
+import (
+	"context"
+)
+
+// Span is one traced operation.  Attributes are set before End.
+type Span interface {
+	SetAttribute(key string, value any)
+	End(err error)
+}
+
+// Tracer creates spans for transfers.  The package has no tracing
+// dependency of its own; an OpenTelemetry adapter is a few lines:
+//
+//	type otelTracer struct{ t trace.Tracer }
+//	type otelSpan struct{ s trace.Span }
+//
+//	func (o otelTracer) Start(ctx context.Context, name string) (context.Context, usb.Span) {
+//		ctx, s := o.t.Start(ctx, name)
+//		return ctx, otelSpan{s}
+//	}
+//	func (o otelSpan) SetAttribute(k string, v any) { o.s.SetAttributes(attribute.String(k, fmt.Sprint(v))) }
+//	func (o otelSpan) End(err error) {
+//		if err != nil {
+//			o.s.RecordError(err)
+//			o.s.SetStatus(codes.Error, err.Error())
+//		}
+//		o.s.End()
+//	}
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+type tracerBox struct{ t Tracer }
+
+// SetTracer traces the device's transfers with t, or stops tracing if t
+// is nil.  Synchronous transfers take their parent from the Context
+// variants, asynchronous ones from Transfer.Context.
+func (u *Device) SetTracer(t Tracer) {
+	u.tracer.Store(tracerBox{t})
+}
+
+func (u *Device) getTracer() Tracer {
+	b, _ := u.tracer.Load().(tracerBox)
+	return b.t
+}
+
+// ControlTransferContext is ControlTransfer with a span parented on ctx.
+func (u *Device) ControlTransferContext(ctx context.Context,
+	reqtype uint8, request uint8, value uint16, index uint16,
+	length uint16, timeout uint32, data []byte) (int, error) {
+
+	t := u.getTracer()
+	if t == nil {
+		return u.control(reqtype, request, value, index, length, timeout, data)
+	}
+	_, span := t.Start(ctx, "usb.control")
+	span.SetAttribute("usb.endpoint", 0)
+	span.SetAttribute("usb.request_type", reqtype)
+	span.SetAttribute("usb.request", request)
+	span.SetAttribute("usb.value", value)
+	span.SetAttribute("usb.index", index)
+	span.SetAttribute("usb.length", length)
+	n, e := u.control(reqtype, request, value, index, length, timeout, data)
+	span.SetAttribute("usb.actual_length", n)
+	if e != nil {
+		span.SetAttribute("usb.status", errorName(e))
+	}
+	span.End(e)
+	return n, e
+}
+
+// BulkTransferContext is BulkTransfer with a span parented on ctx.
+func (u *Device) BulkTransferContext(ctx context.Context, endpoint uint32, length uint32, timeout uint32, inData []byte) (int, []byte, error) {
+	t := u.getTracer()
+	if t == nil {
+		return u.bulk(endpoint, length, timeout, inData)
+	}
+	_, span := t.Start(ctx, "usb.bulk")
+	span.SetAttribute("usb.endpoint", uint8(endpoint))
+	span.SetAttribute("usb.length", length)
+	n, b, e := u.bulk(endpoint, length, timeout, inData)
+	span.SetAttribute("usb.actual_length", n)
+	if e != nil {
+		span.SetAttribute("usb.status", errorName(e))
+	}
+	span.End(e)
+	return n, b, e
+}
+
+var urbTypeNames = map[uint8]string{
+	URB_TYPE_ISO: "usb.iso", URB_TYPE_INTERRUPT: "usb.interrupt",
+	URB_TYPE_CONTROL: "usb.control", URB_TYPE_BULK: "usb.bulk",
+}
+
+// startSpan opens the span of an asynchronous transfer being submitted
+func (u *Device) startSpan(xfer *Transfer) {
+	t := u.getTracer()
+	if t == nil {
+		return
+	}
+	ctx := xfer.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	name := urbTypeNames[xfer.Type]
+	if name == "" {
+		name = "usb.urb"
+	}
+	_, xfer.span = t.Start(ctx, name)
+	xfer.span.SetAttribute("usb.endpoint", xfer.Endpoint)
+	xfer.span.SetAttribute("usb.length", len(xfer.Data))
+}
+
+func (xfer *Transfer) endSpan() {
+	if xfer.span == nil {
+		return
+	}
+	span := xfer.span
+	xfer.span = nil
+	e := xfer.Err()
+	span.SetAttribute("usb.actual_length", xfer.Length)
+	if xfer.retries > 0 {
+		span.SetAttribute("usb.retries", xfer.retries)
+	}
+	if e != nil {
+		span.SetAttribute("usb.status", errorName(e))
+	}
+	span.End(e)
+}
@@ -0,0 +1,49 @@
+package usb
+
+import (
+	"context"
+	"testing"
+)
+
+type testSpan struct {
+	attrs map[string]any
+	ended bool
+}
+
+func (s *testSpan) SetAttribute(k string, v any) { s.attrs[k] = v }
+func (s *testSpan) End(error)                    { s.ended = true }
+
+type testTracer struct{ spans []*testSpan }
+
+func (t *testTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	s := &testSpan{attrs: map[string]any{"name": name}}
+	t.spans = append(t.spans, s)
+	return ctx, s
+}
+
+// TestSpanRetries checks that a transfer's retries reach its span, and
+// that they don't carry over into the next submission
+func TestSpanRetries(t *testing.T) {
+	u := &Device{}
+	tr := &testTracer{}
+	u.SetTracer(tr)
+	done := make(chan *Transfer, 1)
+	xfer := &Transfer{Type: URB_TYPE_INTERRUPT, Endpoint: 0x81, Data: make([]byte, 8), Done: done}
+	for _, retries := range []int{2, 0} {
+		inFlight(t, u, xfer)
+		// as PollReader resubmits after a failure
+		xfer.retries = retries
+		u.startSpan(xfer)
+		u.complete(xfer.urb)
+		<-done
+	}
+	if len(tr.spans) != 2 || !tr.spans[0].ended || !tr.spans[1].ended {
+		t.Fatalf("spans %+v", tr.spans)
+	}
+	if n := tr.spans[0].attrs["usb.retries"]; n != 2 {
+		t.Errorf("usb.retries %v, want 2", n)
+	}
+	if n, ok := tr.spans[1].attrs["usb.retries"]; ok {
+		t.Errorf("usb.retries %v on a transfer that wasn't retried", n)
+	}
+}
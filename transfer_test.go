@@ -0,0 +1,132 @@
+package usb_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/richardnwinder/usb"
+)
+
+// TestTag queues reads on one endpoint sharing a Done channel and checks
+// that each comes back with the Tag and UserData it was submitted with.
+// Transfers on an endpoint complete in order, so the one tagged i gets
+// the i'th packet echoed.
+func TestTag(t *testing.T) {
+	dev, _, eps := openLoopback(t)
+	const n = 4
+	done := make(chan *usb.Transfer, n)
+	for i := 0; i < n; i++ {
+		x := &usb.Transfer{Type: usb.URB_TYPE_BULK, Endpoint: eps.BulkIn, Data: make([]byte, 64), Done: done,
+			Tag: uint64(i), UserData: fmt.Sprint("stream ", i)}
+		if e := dev.SubmitTransfer(x); e != nil {
+			t.Fatal(e)
+		}
+	}
+	p := make([]byte, 64)
+	for i := 0; i < n; i++ {
+		p[0] = byte(i)
+		if _, _, e := dev.BulkTransfer(uint32(eps.BulkOut), uint32(len(p)), 1000, p); e != nil {
+			t.Fatal(e)
+		}
+	}
+	for i := 0; i < n; i++ {
+		x := <-done
+		if e := x.Err(); e != nil {
+			t.Fatal(e)
+		}
+		if x.Tag != uint64(i) || x.Data[0] != byte(i) {
+			t.Errorf("transfer %d back with tag %d and packet %d", i, x.Tag, x.Data[0])
+		}
+		if want := fmt.Sprint("stream ", x.Tag); x.UserData != want {
+			t.Errorf("tag %d back with UserData %v, want %q", x.Tag, x.UserData, want)
+		}
+	}
+	checkIdle(t, dev)
+}
+
+// TestCallback reads a stream with one transfer that resubmits itself
+// from its Callback, with no Done channel, and then checks that a
+// transfer with both has its Callback called before it reaches Done.
+func TestCallback(t *testing.T) {
+	dev, _, eps := openLoopback(t)
+	const n = 16
+	got := make(chan byte, n)
+	errs := make(chan error, 1)
+	x := &usb.Transfer{Type: usb.URB_TYPE_BULK, Endpoint: eps.BulkIn, Data: make([]byte, 64)}
+	reads := 0 // only the reaper touches it
+	x.Callback = func(x *usb.Transfer) {
+		if e := x.Err(); e != nil {
+			errs <- e
+			return
+		}
+		got <- x.Data[0]
+		if reads++; reads < n {
+			if e := dev.SubmitTransfer(x); e != nil {
+				errs <- e
+			}
+		}
+	}
+	if e := dev.SubmitTransfer(x); e != nil {
+		t.Fatal(e)
+	}
+	p := make([]byte, 64)
+	for i := 0; i < n; i++ {
+		p[0] = byte(i)
+		if _, _, e := dev.BulkTransfer(uint32(eps.BulkOut), uint32(len(p)), 1000, p); e != nil {
+			t.Fatal(e)
+		}
+	}
+	for i := 0; i < n; i++ {
+		select {
+		case b := <-got:
+			if b != byte(i) {
+				t.Fatalf("packet %d read as %d", i, b)
+			}
+		case e := <-errs:
+			t.Fatal(e)
+		}
+	}
+
+	called := false
+	x.Done = make(chan *usb.Transfer, 1)
+	x.Callback = func(*usb.Transfer) { called = true }
+	if e := dev.SubmitTransfer(x); e != nil {
+		t.Fatal(e)
+	}
+	if _, _, e := dev.BulkTransfer(uint32(eps.BulkOut), uint32(len(p)), 1000, p); e != nil {
+		t.Fatal(e)
+	}
+	if <-x.Done; !called {
+		t.Error("transfer reached Done before its Callback was called")
+	}
+	checkIdle(t, dev)
+}
+
+// TestShortNotOK reads a 64 byte echo into a larger transfer, which
+// completes short: without flags that is a success, and with
+// URB_FLAG_SHORT_NOT_OK the kernel fails it with ErrShortPacket.
+func TestShortNotOK(t *testing.T) {
+	dev, _, eps := openLoopback(t)
+	p := make([]byte, 64)
+	x := &usb.Transfer{Type: usb.URB_TYPE_BULK, Endpoint: eps.BulkIn, Data: make([]byte, 1024),
+		Done: make(chan *usb.Transfer, 1)}
+	for _, flags := range []usb.URBFlag{0, usb.URB_FLAG_SHORT_NOT_OK} {
+		x.Flags = flags
+		if e := dev.SubmitTransfer(x); e != nil {
+			t.Fatal(e)
+		}
+		if _, _, e := dev.BulkTransfer(uint32(eps.BulkOut), uint32(len(p)), 1000, p); e != nil {
+			t.Fatal(e)
+		}
+		<-x.Done
+		e := x.Err()
+		switch {
+		case flags == 0 && (e != nil || x.Length != 64):
+			t.Errorf("short read without flags: %d bytes, %v", x.Length, e)
+		case flags != 0 && !errors.Is(e, usb.ErrShortPacket):
+			t.Errorf("short read with URB_FLAG_SHORT_NOT_OK: %v, want ErrShortPacket", e)
+		}
+	}
+	checkIdle(t, dev)
+}
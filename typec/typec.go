@@ -0,0 +1,238 @@
+// Package typec reads the Type-C connectors the kernel's typec class
+// knows: their roles, what is plugged into them, and the power
+// negotiated, and finds the one a device is plugged into.
+package typec
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/richardnwinder/usb"
+)
+
+const SYSPATH = "/sys/class/typec/"
+
+// Port is a Type-C connector as the kernel's typec class sees it.
+// The role fields hold the active choice, such as "host" or "sink";
+// fields the port driver doesn't report are empty.
+type Port struct {
+	Name        string // "port0"
+	DataRole    string // host, device
+	PowerRole   string // source, sink
+	PortType    string // dual, source, sink
+	PowerMode   string // default, 1.5A, 3.0A, usb_power_delivery
+	Orientation string // normal, reverse, unknown
+	Revision    string // Type-C specification revision
+	PDRevision  string
+	Partner     *Partner // nil with nothing attached
+	Contract    *PowerContract
+	syspath     string
+}
+
+// Partner is what is plugged into a port.
+type Partner struct {
+	Type       string // as reported by the driver, if it knows
+	Accessory  string // audio, debug or none
+	SupportsPD bool
+	PDRevision string
+	// the USB PD Discover Identity response, when there was one
+	IDHeader uint32
+	CertStat uint32
+	Product  uint32
+	// SourceCaps are the power data objects the partner advertised as a
+	// source, SinkCaps as a sink
+	SourceCaps []PDO
+	SinkCaps   []PDO
+}
+
+// PDO is one USB PD power data object.  Voltages are in mV, currents in
+// mA and power in mW; fields not used by the supply type are 0.
+type PDO struct {
+	Type       string // fixed_supply, variable_supply, battery, programmable_supply
+	Voltage    int
+	MaxVoltage int
+	Current    int
+	Power      int
+}
+
+// PowerContract is the power currently negotiated on a port, taken from
+// the power supply the port driver registers.
+type PowerContract struct {
+	Online       bool
+	VoltageMV    int
+	CurrentMA    int
+	MaxCurrentMA int
+}
+
+func readAttr(path string) string {
+	b, e := os.ReadFile(path)
+	if e != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+func readIntAttr(path string) int {
+	n, _ := strconv.Atoi(readAttr(path))
+	return n
+}
+
+// selected picks the bracketed choice out of "[host] device"
+func selected(s string) string {
+	if i := strings.IndexByte(s, '['); i >= 0 {
+		if j := strings.IndexByte(s[i:], ']'); j > 0 {
+			return s[i+1 : i+j]
+		}
+	}
+	return s
+}
+
+// List returns the system's Type-C ports.  It returns an empty list on
+// systems without the typec class.
+func List() ([]*Port, error) {
+	fi, e := os.ReadDir(SYSPATH)
+	if os.IsNotExist(e) {
+		return nil, nil
+	}
+	if e != nil {
+		return nil, e
+	}
+	var ports []*Port
+	for _, f := range fi {
+		name := f.Name()
+		// partners, cables and plugs live alongside as port0-partner etc.
+		if !strings.HasPrefix(name, "port") || strings.IndexByte(name, '-') != -1 {
+			continue
+		}
+		ports = append(ports, readPort(SYSPATH+name))
+	}
+	return ports, nil
+}
+
+func readPort(path string) *Port {
+	p := &Port{
+		Name:        filepath.Base(path),
+		DataRole:    selected(readAttr(path + "/data_role")),
+		PowerRole:   selected(readAttr(path + "/power_role")),
+		PortType:    selected(readAttr(path + "/port_type")),
+		PowerMode:   readAttr(path + "/power_operation_mode"),
+		Orientation: readAttr(path + "/orientation"),
+		Revision:    readAttr(path + "/usb_typec_revision"),
+		PDRevision:  readAttr(path + "/usb_power_delivery_revision"),
+		syspath:     path,
+	}
+	pp := path + "/" + p.Name + "-partner"
+	if _, e := os.Stat(pp); e == nil {
+		pt := &Partner{
+			Type:       readAttr(pp + "/type"),
+			Accessory:  readAttr(pp + "/accessory_mode"),
+			SupportsPD: readAttr(pp+"/supports_usb_power_delivery") == "yes",
+			PDRevision: readAttr(pp + "/usb_power_delivery_revision"),
+			IDHeader:   uint32(parseHex32(readAttr(pp + "/identity/id_header"))),
+			CertStat:   uint32(parseHex32(readAttr(pp + "/identity/cert_stat"))),
+			Product:    uint32(parseHex32(readAttr(pp + "/identity/product"))),
+			SourceCaps: readPDOs(pp + "/usb_power_delivery/source-capabilities"),
+			SinkCaps:   readPDOs(pp + "/usb_power_delivery/sink-capabilities"),
+		}
+		p.Partner = pt
+	}
+	p.Contract = readContract(path)
+	return p
+}
+
+// position is the number a capabilities entry starts with
+func position(name string) int {
+	n, _ := strconv.Atoi(name[:strings.IndexByte(name, ':')])
+	return n
+}
+
+func parseHex32(s string) uint64 {
+	n, _ := strconv.ParseUint(strings.TrimPrefix(s, "0x"), 16, 32)
+	return n
+}
+
+// readPDOs reads a capabilities directory, whose entries are named
+// "<position>:<type>"
+func readPDOs(dir string) []PDO {
+	fi, e := os.ReadDir(dir)
+	if e != nil {
+		return nil
+	}
+	names := make([]string, 0, len(fi))
+	for _, f := range fi {
+		if strings.IndexByte(f.Name(), ':') > 0 {
+			names = append(names, f.Name())
+		}
+	}
+	sort.Slice(names, func(i, j int) bool { return position(names[i]) < position(names[j]) })
+	var pdos []PDO
+	for _, n := range names {
+		d := dir + "/" + n
+		pdo := PDO{Type: n[strings.IndexByte(n, ':')+1:]}
+		switch pdo.Type {
+		case "fixed_supply":
+			pdo.Voltage = readIntAttr(d + "/voltage")
+			pdo.Current = readIntAttr(d + "/maximum_current")
+		case "variable_supply", "programmable_supply":
+			pdo.Voltage = readIntAttr(d + "/minimum_voltage")
+			pdo.MaxVoltage = readIntAttr(d + "/maximum_voltage")
+			pdo.Current = readIntAttr(d + "/maximum_current")
+		case "battery":
+			pdo.Voltage = readIntAttr(d + "/minimum_voltage")
+			pdo.MaxVoltage = readIntAttr(d + "/maximum_voltage")
+			pdo.Power = readIntAttr(d + "/maximum_power")
+		}
+		pdos = append(pdos, pdo)
+	}
+	return pdos
+}
+
+// readContract finds the power supply registered by the port's driver.
+// UCSI and TCPM put it under the controller device next to the typec
+// directory, named with the connector number (counting from 1) at the end.
+func readContract(port string) *PowerContract {
+	real, e := filepath.EvalSymlinks(port)
+	if e != nil {
+		return nil
+	}
+	ctrl := filepath.Dir(filepath.Dir(real))
+	fi, e := os.ReadDir(ctrl + "/power_supply")
+	if e != nil {
+		return nil
+	}
+	n, _ := strconv.Atoi(strings.TrimPrefix(filepath.Base(port), "port"))
+	num := strconv.Itoa(n + 1)
+	for _, f := range fi {
+		name := f.Name()
+		if len(fi) > 1 && !strings.HasSuffix(name, num) {
+			continue
+		}
+		ps := ctrl + "/power_supply/" + name
+		return &PowerContract{
+			Online:       readAttr(ps+"/online") == "1",
+			VoltageMV:    readIntAttr(ps+"/voltage_now") / 1000,
+			CurrentMA:    readIntAttr(ps+"/current_now") / 1000,
+			MaxCurrentMA: readIntAttr(ps+"/current_max") / 1000,
+		}
+	}
+	return nil
+}
+
+// ForDevice returns the Type-C port the device is plugged into,
+// following the connector link the kernel puts on hub ports it has
+// matched to a typec port.  It returns nil if there is no such link,
+// which is the case for devices behind an external hub and on firmware
+// that doesn't describe the connectors.
+func ForDevice(di *usb.DeviceInfo) *Port {
+	if di.SysPath() == "" {
+		return nil
+	}
+	port, e := filepath.EvalSymlinks(di.SysPath() + "/port/connector")
+	if e != nil {
+		return nil
+	}
+	return readPort(port)
+}
@@ -0,0 +1,234 @@
+package uac
+
+import (
+	"math"
+	"syscall"
+
+	"github.com/richardnwinder/usb"
+)
+
+const (
+	// UAC1 requests
+	SET_CUR = 0x01
+	GET_CUR = 0x81
+	GET_MIN = 0x82
+	GET_MAX = 0x83
+	GET_RES = 0x84
+
+	// UAC2 requests, with the direction in bmRequestType
+	CUR   = 0x01
+	RANGE = 0x02
+
+	// feature unit control selectors
+	MUTE_CONTROL   = 0x01
+	VOLUME_CONTROL = 0x02
+
+	// UAC2 clock source control selectors
+	CS_SAM_FREQ_CONTROL    = 0x01
+	CS_CLOCK_VALID_CONTROL = 0x02
+
+	// UAC1 endpoint control selectors
+	EP_SAMPLING_FREQ_CONTROL = 0x01
+
+	reqOut   = 0x21 // class, interface, host to device
+	reqIn    = 0xa1
+	reqEpOut = 0x22 // class, endpoint
+	reqEpIn  = 0xa2
+)
+
+// Control drives the units of an audio control interface.  Claiming the
+// interface isn't needed for these requests, so they work alongside
+// snd-usb-audio.
+type Control struct {
+	*Topology
+	dev *usb.Device
+}
+
+// NewControl reads the topology of the audio control interface ii on dev.
+func NewControl(dev *usb.Device, ii *usb.InterfaceInfo) (*Control, error) {
+	t, e := ParseTopology(ii)
+	if e != nil {
+		return nil, e
+	}
+	return &Control{Topology: t, dev: dev}, nil
+}
+
+func (c *Control) get(req uint8, id uint8, cs uint8, cn uint8, b []byte) error {
+	n, e := c.dev.ControlTransfer(reqIn, req, uint16(cs)<<8|uint16(cn), uint16(id)<<8|uint16(c.Interface), uint16(len(b)), 1000, b)
+	if e == nil && n < len(b) {
+		e = syscall.EPROTO
+	}
+	return e
+}
+
+func (c *Control) set(id uint8, cs uint8, cn uint8, b []byte) error {
+	_, e := c.dev.ControlTransfer(reqOut, SET_CUR, uint16(cs)<<8|uint16(cn), uint16(id)<<8|uint16(c.Interface), uint16(len(b)), 1000, b)
+	return e
+}
+
+// UAC2 reuses 0x01 for CUR in both directions
+func (c *Control) cur() uint8 {
+	if c.V2() {
+		return CUR
+	}
+	return GET_CUR
+}
+
+// Mute reads a feature unit's mute control on a channel, 0 for master.
+func (c *Control) Mute(unit uint8, channel uint8) (bool, error) {
+	b := make([]byte, 1)
+	e := c.get(c.cur(), unit, MUTE_CONTROL, channel, b)
+	return b[0] != 0, e
+}
+
+func (c *Control) SetMute(unit uint8, channel uint8, mute bool) error {
+	b := []byte{0}
+	if mute {
+		b[0] = 1
+	}
+	return c.set(unit, MUTE_CONTROL, channel, b)
+}
+
+// volumes are signed 1/256 dB steps, with 0x8000 for silence
+func toDB(v uint16) float64 {
+	if v == 0x8000 {
+		return math.Inf(-1)
+	}
+	return float64(int16(v)) / 256
+}
+
+func fromDB(db float64) uint16 {
+	if math.IsInf(db, -1) {
+		return 0x8000
+	}
+	return uint16(int16(math.Round(min(max(db, -127.9961), 127.9961) * 256)))
+}
+
+// Volume reads a feature unit's volume on a channel in dB.
+func (c *Control) Volume(unit uint8, channel uint8) (float64, error) {
+	b := make([]byte, 2)
+	if e := c.get(c.cur(), unit, VOLUME_CONTROL, channel, b); e != nil {
+		return 0, e
+	}
+	return toDB(le16(b)), nil
+}
+
+// SetVolume sets a feature unit's volume on a channel in dB; the device
+// rounds it to its own resolution.
+func (c *Control) SetVolume(unit uint8, channel uint8, db float64) error {
+	v := fromDB(db)
+	return c.set(unit, VOLUME_CONTROL, channel, []byte{byte(v), byte(v >> 8)})
+}
+
+// VolumeRange reads the lowest and highest volume in dB and the step.
+// On UAC2 devices with several subranges, the first gives the step.
+func (c *Control) VolumeRange(unit uint8, channel uint8) (lo, hi, res float64, e error) {
+	if c.V2() {
+		r, e := c.ranges(unit, VOLUME_CONTROL, channel, 2)
+		if e != nil {
+			return 0, 0, 0, e
+		}
+		return toDB(uint16(r[0].Min)), toDB(uint16(r[len(r)-1].Max)), toDB(uint16(r[0].Res)), nil
+	}
+	var v [3]uint16
+	for i, req := range []uint8{GET_MIN, GET_MAX, GET_RES} {
+		b := make([]byte, 2)
+		if e := c.get(req, unit, VOLUME_CONTROL, channel, b); e != nil {
+			return 0, 0, 0, e
+		}
+		v[i] = le16(b)
+	}
+	return toDB(v[0]), toDB(v[1]), toDB(v[2]), nil
+}
+
+// Range is one UAC2 subrange: values from Min to Max in steps of Res.
+type Range struct {
+	Min, Max, Res uint32
+}
+
+// ranges issues a UAC2 RANGE request for a control whose values are
+// size bytes wide
+func (c *Control) ranges(id uint8, cs uint8, cn uint8, size int) ([]Range, error) {
+	// the count comes first; ask for it, then for all of them
+	b := make([]byte, 2)
+	if e := c.get(RANGE, id, cs, cn, b); e != nil {
+		return nil, e
+	}
+	n := int(le16(b))
+	if n == 0 {
+		return nil, syscall.EPROTO
+	}
+	b = make([]byte, 2+3*size*n)
+	if e := c.get(RANGE, id, cs, cn, b); e != nil {
+		return nil, e
+	}
+	list := make([]Range, n)
+	for i := range list {
+		var v [3]uint32
+		for j := range v {
+			at := 2 + (3*i+j)*size
+			if size == 2 {
+				v[j] = uint32(le16(b[at:]))
+			} else {
+				v[j] = le32(b[at:])
+			}
+		}
+		list[i] = Range{v[0], v[1], v[2]}
+	}
+	return list, nil
+}
+
+// SampleRate reads the frequency of a UAC2 clock source in Hz.
+func (c *Control) SampleRate(clock uint8) (uint32, error) {
+	if !c.V2() {
+		return 0, syscall.EOPNOTSUPP
+	}
+	b := make([]byte, 4)
+	if e := c.get(CUR, clock, CS_SAM_FREQ_CONTROL, 0, b); e != nil {
+		return 0, e
+	}
+	return le32(b), nil
+}
+
+// SetSampleRate sets the frequency of a UAC2 clock source in Hz.
+func (c *Control) SetSampleRate(clock uint8, hz uint32) error {
+	if !c.V2() {
+		return syscall.EOPNOTSUPP
+	}
+	return c.set(clock, CS_SAM_FREQ_CONTROL, 0, []byte{byte(hz), byte(hz >> 8), byte(hz >> 16), byte(hz >> 24)})
+}
+
+// SampleRates lists the frequency ranges a UAC2 clock source supports;
+// a fixed rate has Min equal to Max.
+func (c *Control) SampleRates(clock uint8) ([]Range, error) {
+	if !c.V2() {
+		return nil, syscall.EOPNOTSUPP
+	}
+	return c.ranges(clock, CS_SAM_FREQ_CONTROL, 0, 4)
+}
+
+// EndpointSampleRate reads the sampling frequency of a UAC1 streaming
+// endpoint in Hz.  UAC1 has no clock entities; the rate is set per
+// endpoint, after selecting the streaming interface's alternate setting.
+func EndpointSampleRate(dev *usb.Device, endpoint uint8) (uint32, error) {
+	b := make([]byte, 3)
+	n, e := dev.ControlTransfer(reqEpIn, GET_CUR, EP_SAMPLING_FREQ_CONTROL<<8, uint16(endpoint), 3, 1000, b)
+	if e != nil {
+		return 0, e
+	}
+	if n < 3 {
+		return 0, syscall.EPROTO
+	}
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16, nil
+}
+
+// SetEndpointSampleRate sets the sampling frequency of a UAC1 streaming
+// endpoint in Hz.
+func SetEndpointSampleRate(dev *usb.Device, endpoint uint8, hz uint32) error {
+	if hz >= 1<<24 {
+		return syscall.EINVAL
+	}
+	b := []byte{byte(hz), byte(hz >> 8), byte(hz >> 16)}
+	_, e := dev.ControlTransfer(reqEpOut, SET_CUR, EP_SAMPLING_FREQ_CONTROL<<8, uint16(endpoint), 3, 1000, b)
+	return e
+}
@@ -0,0 +1,333 @@
+// Package uac reads the unit and terminal graph of a USB Audio Class
+// (1.0 or 2.0) control interface and drives the common controls on it:
+// mute and volume on feature units, and the sample rate of clock sources
+// or, on UAC1, of the streaming endpoints.
+package uac
+
+import (
+	"syscall"
+
+	"github.com/richardnwinder/usb"
+)
+
+const (
+	CLASS_AUDIO        = 0x01
+	SUBCLASS_CONTROL   = 0x01
+	SUBCLASS_STREAMING = 0x02
+	DT_CS_INTERFACE    = 0x24
+
+	// audio control descriptor subtypes, shared by both versions
+	AC_HEADER          = 0x01
+	AC_INPUT_TERMINAL  = 0x02
+	AC_OUTPUT_TERMINAL = 0x03
+	AC_MIXER_UNIT      = 0x04
+	AC_SELECTOR_UNIT   = 0x05
+	AC_FEATURE_UNIT    = 0x06
+
+	// the rest are numbered differently in UAC2, so ParseTopology maps
+	// UAC1 subtypes onto these
+	AC_EFFECT_UNIT           = 0x07
+	AC_PROCESSING_UNIT       = 0x08
+	AC_EXTENSION_UNIT        = 0x09
+	AC_CLOCK_SOURCE          = 0x0a
+	AC_CLOCK_SELECTOR        = 0x0b
+	AC_CLOCK_MULTIPLIER      = 0x0c
+	AC_SAMPLE_RATE_CONVERTER = 0x0d
+	uac1ProcessingUnit       = 0x07
+	uac1ExtensionUnit        = 0x08
+
+	// terminal types
+	TERMINAL_USB_STREAMING = 0x0101
+	TERMINAL_MICROPHONE    = 0x0201
+	TERMINAL_SPEAKER       = 0x0301
+	TERMINAL_HEADPHONES    = 0x0302
+	TERMINAL_HEADSET       = 0x0402
+	TERMINAL_LINE          = 0x0603
+	TERMINAL_SPDIF         = 0x0605
+)
+
+// Entity is a unit or terminal in the audio function's graph.
+type Entity struct {
+	ID      uint8
+	Subtype uint8  // AC_*, in the UAC2 numbering
+	Type    uint16 // terminal type, or process or effect type
+	// Sources are the entities feeding this one; for clock selectors and
+	// multipliers they are clock entities.
+	Sources []uint8
+	// Clock is the clock entity a UAC2 terminal or converter runs from.
+	Clock    uint8
+	Channels int // for input terminals, as they describe their output
+	// Controls holds a feature unit's control bitmaps, master channel
+	// first: one bit per control in UAC1, two in UAC2.
+	Controls []uint32
+	Desc     []byte // the whole descriptor
+}
+
+// Topology is the graph of an audio control interface.
+type Topology struct {
+	Version   uint16 // bcdADC
+	Interface uint8
+	// Streaming lists the streaming interfaces a UAC1 header names.  UAC2
+	// leaves them to the interface association descriptor.
+	Streaming []uint8
+	Entities  map[uint8]*Entity
+	Order     []uint8 // entity IDs in descriptor order
+}
+
+// V2 says the function follows UAC 2.0.
+func (t *Topology) V2() bool {
+	return t.Version >= 0x0200
+}
+
+// ParseTopology reads the class-specific descriptors of an audio control
+// interface.  UAC3 functions aren't understood and give EOPNOTSUPP.
+func ParseTopology(ii *usb.InterfaceInfo) (*Topology, error) {
+	if ii.InterfaceClass != CLASS_AUDIO || ii.InterfaceSubClass != SUBCLASS_CONTROL {
+		return nil, syscall.EINVAL
+	}
+	t := &Topology{Interface: ii.InterfaceNumber, Entities: make(map[uint8]*Entity)}
+	for d := ii.Extra; len(d) > 0; d = d[d[0]:] {
+		if len(d) < 3 || d[0] < 3 || int(d[0]) > len(d) {
+			return nil, syscall.EINVAL
+		}
+		b := d[:d[0]]
+		if b[1] != DT_CS_INTERFACE {
+			continue
+		}
+		if b[2] == AC_HEADER {
+			if len(b) < 5 {
+				return nil, syscall.EINVAL
+			}
+			t.Version = le16(b[3:])
+			if t.Version >= 0x0300 {
+				return nil, syscall.EOPNOTSUPP
+			}
+			if !t.V2() && len(b) >= 8 {
+				t.Streaming = append([]uint8(nil), b[8:min(len(b), 8+int(b[7]))]...)
+			}
+			continue
+		}
+		if len(b) < 4 {
+			return nil, syscall.EINVAL
+		}
+		e := &Entity{ID: b[3], Subtype: b[2], Desc: b}
+		var ok bool
+		if t.V2() {
+			ok = parseUAC2(e, b)
+		} else {
+			ok = parseUAC1(e, b)
+		}
+		if !ok {
+			return nil, syscall.EINVAL
+		}
+		if e.ID == 0 {
+			continue
+		}
+		t.Entities[e.ID] = e
+		t.Order = append(t.Order, e.ID)
+	}
+	if t.Version == 0 {
+		return nil, syscall.EINVAL
+	}
+	return t, nil
+}
+
+// pins reads bNrInPins and the source IDs after it
+func pins(e *Entity, b []byte, at int) bool {
+	if len(b) <= at {
+		return false
+	}
+	n := int(b[at])
+	if len(b) < at+1+n {
+		return false
+	}
+	e.Sources = append([]uint8(nil), b[at+1:at+1+n]...)
+	return true
+}
+
+func parseUAC1(e *Entity, b []byte) bool {
+	switch b[2] {
+	case AC_INPUT_TERMINAL:
+		if len(b) < 12 {
+			return false
+		}
+		e.Type = le16(b[4:])
+		e.Channels = int(b[7])
+	case AC_OUTPUT_TERMINAL:
+		if len(b) < 9 {
+			return false
+		}
+		e.Type = le16(b[4:])
+		e.Sources = []uint8{b[7]}
+	case AC_MIXER_UNIT, AC_SELECTOR_UNIT:
+		return pins(e, b, 4)
+	case AC_FEATURE_UNIT:
+		if len(b) < 7 || b[5] == 0 {
+			return false
+		}
+		e.Sources = []uint8{b[4]}
+		size := int(b[5])
+		for i := 6; i+size < len(b); i += size {
+			var v uint32
+			for j := 0; j < size && j < 4; j++ {
+				v |= uint32(b[i+j]) << (8 * j)
+			}
+			e.Controls = append(e.Controls, v)
+		}
+	case uac1ProcessingUnit, uac1ExtensionUnit:
+		if len(b) < 7 {
+			return false
+		}
+		e.Subtype = b[2] + 1
+		e.Type = le16(b[4:])
+		return pins(e, b, 6)
+	default:
+		e.ID = 0
+	}
+	return true
+}
+
+func parseUAC2(e *Entity, b []byte) bool {
+	switch b[2] {
+	case AC_INPUT_TERMINAL:
+		if len(b) < 17 {
+			return false
+		}
+		e.Type = le16(b[4:])
+		e.Clock = b[7]
+		e.Channels = int(b[8])
+	case AC_OUTPUT_TERMINAL:
+		if len(b) < 12 {
+			return false
+		}
+		e.Type = le16(b[4:])
+		e.Sources = []uint8{b[7]}
+		e.Clock = b[8]
+	case AC_MIXER_UNIT, AC_SELECTOR_UNIT, AC_CLOCK_SELECTOR:
+		return pins(e, b, 4)
+	case AC_FEATURE_UNIT:
+		if len(b) < 10 {
+			return false
+		}
+		e.Sources = []uint8{b[4]}
+		for i := 5; i+4 < len(b); i += 4 {
+			e.Controls = append(e.Controls, le32(b[i:]))
+		}
+	case AC_EFFECT_UNIT:
+		if len(b) < 7 {
+			return false
+		}
+		e.Type = le16(b[4:])
+		e.Sources = []uint8{b[6]}
+	case AC_PROCESSING_UNIT, AC_EXTENSION_UNIT:
+		if len(b) < 7 {
+			return false
+		}
+		e.Type = le16(b[4:])
+		return pins(e, b, 6)
+	case AC_CLOCK_SOURCE:
+		if len(b) < 8 {
+			return false
+		}
+	case AC_CLOCK_MULTIPLIER:
+		if len(b) < 5 {
+			return false
+		}
+		e.Sources = []uint8{b[4]}
+	case AC_SAMPLE_RATE_CONVERTER:
+		if len(b) < 7 {
+			return false
+		}
+		e.Sources = []uint8{b[4]}
+		e.Clock = b[5]
+	default:
+		e.ID = 0
+	}
+	return true
+}
+
+func le16(b []byte) uint16 {
+	return uint16(b[0]) | uint16(b[1])<<8
+}
+
+func le32(b []byte) uint32 {
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24
+}
+
+// Terminals lists the terminals of the given type, input and output.
+func (t *Topology) Terminals(kind uint16) []*Entity {
+	var list []*Entity
+	for _, id := range t.Order {
+		e := t.Entities[id]
+		if (e.Subtype == AC_INPUT_TERMINAL || e.Subtype == AC_OUTPUT_TERMINAL) && e.Type == kind {
+			list = append(list, e)
+		}
+	}
+	return list
+}
+
+// Upstream finds the nearest entity of the given subtype feeding id, by
+// a breadth-first walk back through the sources, or nil.  The volume
+// for a speaker is typically Upstream(speaker.ID, AC_FEATURE_UNIT).
+func (t *Topology) Upstream(id uint8, subtype uint8) *Entity {
+	seen := map[uint8]bool{id: true}
+	queue := []uint8{id}
+	for len(queue) > 0 {
+		e := t.Entities[queue[0]]
+		queue = queue[1:]
+		if e == nil {
+			continue
+		}
+		for _, s := range e.Sources {
+			if seen[s] {
+				continue
+			}
+			seen[s] = true
+			if src := t.Entities[s]; src != nil && src.Subtype == subtype {
+				return src
+			}
+			queue = append(queue, s)
+		}
+	}
+	return nil
+}
+
+// ClockSource follows a UAC2 terminal's clock back through selectors
+// and multipliers to the clock source, taking a selector's first input.
+// It returns nil on UAC1, which has no clock entities.
+func (t *Topology) ClockSource(id uint8) *Entity {
+	e := t.Entities[id]
+	if e == nil {
+		return nil
+	}
+	next := e.Clock
+	for i := 0; i < len(t.Entities); i++ {
+		c := t.Entities[next]
+		if c == nil {
+			return nil
+		}
+		if c.Subtype == AC_CLOCK_SOURCE {
+			return c
+		}
+		if len(c.Sources) == 0 {
+			return nil
+		}
+		next = c.Sources[0]
+	}
+	return nil
+}
+
+// HasControl says whether a feature unit offers the control with the
+// given selector (MUTE_CONTROL, VOLUME_CONTROL and so on) on a channel,
+// 0 being the master channel.
+func (t *Topology) HasControl(unit uint8, channel int, selector uint8) bool {
+	e := t.Entities[unit]
+	if e == nil || e.Subtype != AC_FEATURE_UNIT || channel >= len(e.Controls) || selector == 0 {
+		return false
+	}
+	c := e.Controls[channel]
+	if t.V2() {
+		return c>>(2*(selector-1))&3 != 0
+	}
+	return c>>(selector-1)&1 != 0
+}
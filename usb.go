@@ -1,63 +1,364 @@
+// Package usb talks to USB devices through Linux usbfs: finding them in
+// sysfs, opening them, claiming interfaces and moving data over their
+// endpoints, synchronously or with queued URBs.  It imports nothing but
+// the standard library.
+//
+// Class and protocol drivers are subpackages built on those primitives,
+// each importing only the core and what it layers on: hid, serial, msc,
+// usbtmc, dfu, uvc, uac, ptp and aoa on the host side, gadget for the
+// device side, and capture, replay, remote and usbtest around them.  Hub
+// ports, Type-C, link power management, test modes and Billboard are in
+// hub, typec, lpm, testmode and billboard, and pool, group and watchdog
+// manage many devices or failing ones.  A program pulls in only the
+// packages it imports.
 package usb
 
 import (
-	"fmt"
+	"context"
+	"iter"
 	"log"
 	"os"
 	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 	"unsafe"
 )
 
 type Transfer struct {
-	Status int32          // transaction status (0 == success)
-	Length int32          // length of data transferred
-	Data   []byte         // data to transmit or receive
-	Done   chan *Transfer // written to on completion
-	urb    usbdevfs_urb
+	Type     uint8   // URB_TYPE_*
+	Endpoint uint8   // endpoint address (ENDPOINT_IN for reads)
+	Flags    URBFlag // passed to usbdevfs as they are
+	Status   int32   // transaction status (0 == success)
+	Length   int32   // length of data transferred
+	// StartFrame only applies to isochronous transfers.  Submitted
+	// without URB_FLAG_ISO_ASAP, one starts in this frame, as the host
+	// controller counts them; on completion StartFrame is the frame it
+	// began in.  Other transfers ignore it and leave it 0.
+	StartFrame int32
+	Data       []byte         // data to transmit or receive
+	Done       chan *Transfer // written to on completion
+	// Packets divides an isochronous transfer's Data into the packets
+	// of successive (micro)frames, each starting where the one before
+	// it ends.  The caller sets each Length, between them no more than
+	// Data holds, and completion fills in ActualLength and Status.  An
+	// isochronous transfer needs 1 to ISO_MAX_PACKETS of them, and
+	// usually URB_FLAG_ISO_ASAP; other transfers leave Packets nil.
+	Packets []IsoPacket
+	// Callback, if set, is called with the transfer when it completes,
+	// before it is written to Done; either may be left nil.  It runs on
+	// the device's reaper goroutine, handing the completion over without
+	// waking another goroutine, and no other completion on the device is
+	// delivered until it returns, so it must not block.  It may
+	// resubmit the transfer, or submit and cancel others, but must not
+	// Close the device, which waits for the reaper.
+	Callback func(*Transfer)
+	// Context is the parent of the transfer's span when the device has a
+	// Tracer; nil is context.Background().
+	Context context.Context
+	// Tag and UserData are the caller's, left alone by the device and
+	// returned with the transfer on Done, for telling apart the streams
+	// that share one Done channel or endpoint.
+	Tag       uint64
+	UserData  any
+	urb       *usbdevfs_urb // from the device's slab while in flight
+	iso       *isoURB       // holding urb, for an isochronous transfer
+	held      int64         // bytes of usbfs memory counted for Data in flight
+	submitted time.Time
+	token     uintptr
+	span      Span
+	retries   int // attempts before this one: interrupted or after a failure
+	tapID     uint64
 }
 
-type Device struct {
-	fd     int
+// endpointQueue tracks the URBs the kernel holds for one endpoint.  Each
+// has its own lock, so submissions and completions on one endpoint never
+// wait for another's.
+type endpointQueue struct {
 	lock   sync.Mutex
 	active map[uintptr]*Transfer
-	log    *log.Logger
 }
 
-// This ioctl is interruptible by signals and will not wedge the process on
-// exit, but otherwise blocks forever if no URBs complete.  Probably should
-// poll/select and use the nonblocking version to allow for better cleanup
-// on Close()
+// queue indices: OUT endpoints 0-15, then IN
+func (u *Device) queue(ep uint8) *endpointQueue {
+	i := int(ep & 0x0f)
+	if ep&ENDPOINT_IN != 0 {
+		i += 16
+	}
+	return &u.queues[i]
+}
+
+// The device lock covers the fd's lifetime and the reaper; transfers are
+// tracked per endpoint, and nactive counts them all for the reaper.
+type Device struct {
+	fd         int
+	lock       sync.Mutex
+	queues     [32]endpointQueue
+	nactive    atomic.Int32
+	token      atomic.Uintptr
+	reaping    bool
+	closing    bool
+	drained    bool // closing, and no submission is still under way
+	reaped     chan struct{}
+	users      sync.WaitGroup // synchronous ioctls holding fd
+	submitting sync.WaitGroup
+	wake       [2]int
+	bufmode    BufferMode
+	buferr     error
+	mapped     map[*byte]int   // AllocBuffer's mappings and their sizes
+	claimed    map[uint32]bool // interfaces claimed through this Device
+	log        *log.Logger
+
+	statLock sync.Mutex
+	stats    map[uint8]*EndpointStats
+	tracer   atomic.Value // tracerBox
+	tap      atomic.Value // tapBox
+	opTap    atomic.Value // opTapBox
+	tapID    atomic.Uint64
+	bus      int
+	devnum   int
+	path     string      // of the usbfs node
+	info     *DeviceInfo // the device was opened from
+
+	halted    atomic.Uint32 // endpoints seen to stall, by haltBit
+	haltFunc  atomic.Value  // haltBox
+	reapStats reapCounters
+	held      atomic.Int64 // bytes of usbfs memory queued and mapped
+	monitor   atomic.Pointer[monitorBox]
+	urbs      urbSlab
+}
+
+// The reaper sleeps in poll() on the usbfs fd, which becomes writable when
+// completed URBs are waiting, and on a pipe that Close uses to wake it.
+// Completions are drained with the nonblocking reap so the set of tracked
+// transfers only ever holds what the kernel currently owns, and the reaper
+// exits once Close has discarded everything and the kernel returned it.
+//
+// Each wakeup reaps everything that has completed, up to reapBatch URBs,
+// before delivering any of it, so a burst of completions costs one poll
+// and the reap calls that collect it.
+//
+// Transfers are tracked by a token carried in the URB's usercontext rather
+// than by address.  The kernel hands back the URB pointer we submitted;
+// it is received into an unsafe.Pointer so the collector keeps seeing it.
 func (u *Device) reaper() {
-	var x int
-	var n uint32
-	for x = 0; x < 4; x++ {
-		n = uint32(x)
-		_, _, e := ioctl(u.fd, USBDEVFS_REAPURB, uintptr(unsafe.Pointer(&n)))
-		if e != nil {
-			fmt.Println("failure reaping URBs", e)
-			break
+	var p unsafe.Pointer
+	fds := []pollfd{
+		{fd: int32(u.fd), events: POLLOUT},
+		{fd: int32(u.wake[0]), events: POLLIN},
+	}
+	batch := make([]*usbdevfs_urb, 0, reapBatch)
+	for {
+		_, _, e := ioctl(u.fd, USBDEVFS_REAPURBNDELAY, uintptr(unsafe.Pointer(&p)))
+		if e == nil {
+			batch = append(batch, (*usbdevfs_urb)(p))
+			if len(batch) < reapBatch {
+				continue
+			}
 		}
-		u.lock.Lock()
-		xfer := u.active[uintptr(unsafe.Pointer(&n))]
-		delete(u.active, uintptr(unsafe.Pointer(&n)))
-		u.lock.Unlock()
-		if xfer == nil {
-			fmt.Println("kernel returned invalid urb pointer?!")
+		if len(batch) > 0 {
+			u.reapStats.add(len(batch))
+			for _, urb := range batch {
+				u.complete(urb)
+			}
+			clear(batch)
+			batch = batch[:0]
+			if e == nil {
+				continue
+			}
+		}
+		if e == syscall.EAGAIN {
+			u.lock.Lock()
+			idle := u.drained && u.nactive.Load() == 0
+			u.lock.Unlock()
+			if idle {
+				break
+			}
+			e = poll(fds)
+			if fds[1].revents&POLLIN != 0 {
+				var b [16]byte
+				syscall.Read(u.wake[0], b[:])
+			}
+		}
+		if e == nil {
 			continue
 		}
-		xfer.Status = xfer.urb.status
-		xfer.Length = xfer.urb.actual_length
-		fmt.Println("status ", xfer.urb.status)
-		fmt.Println("actual ", xfer.urb.actual_length)
-		if xfer.Done != nil {
-			xfer.Done <- xfer
+		// The kernel frees outstanding URBs itself on disconnect, so they
+		// will never be reaped.  Hand them back to their owners instead.
+		u.log.Println("failure reaping URBs", e)
+		u.failAll(-int32(syscall.ENODEV))
+		break
+	}
+	u.lock.Lock()
+	u.reaping = false
+	syscall.Close(u.wake[0])
+	syscall.Close(u.wake[1])
+	close(u.reaped)
+	u.lock.Unlock()
+}
+
+func (u *Device) complete(urb *usbdevfs_urb) {
+	q := u.queue(urb.endpoint)
+	q.lock.Lock()
+	xfer := q.active[urb.usercontext]
+	if xfer == nil || xfer.urb != urb {
+		q.lock.Unlock()
+		u.log.Println("kernel returned invalid urb pointer?!")
+		return
+	}
+	delete(q.active, urb.usercontext)
+	q.lock.Unlock()
+	u.nactive.Add(-1)
+	xfer.Status = urb.status
+	xfer.Length = urb.actual_length
+	xfer.StartFrame = urb.start_frame
+	u.putURB(xfer)
+	u.uncount(xfer)
+	u.inflight(xfer.Endpoint, -1)
+	u.record(xfer.Endpoint, int(xfer.Length), xfer.Err(), xfer.submitted)
+	xfer.endSpan()
+	xfer.retries = 0
+	u.tapTransfer(xfer)
+	xfer.deliver()
+}
+
+func (u *Device) failAll(status int32) {
+	var lost []*Transfer
+	for i := range u.queues {
+		q := &u.queues[i]
+		q.lock.Lock()
+		for key, xfer := range q.active {
+			delete(q.active, key)
+			lost = append(lost, xfer)
+			u.nactive.Add(-1)
+		}
+		q.lock.Unlock()
+	}
+	for _, xfer := range lost {
+		xfer.Status = status
+		xfer.Length = 0
+		u.putURB(xfer)
+		for i := range xfer.Packets {
+			xfer.Packets[i].Status = status
 		}
+		u.uncount(xfer)
+		u.inflight(xfer.Endpoint, -1)
+		u.record(xfer.Endpoint, 0, xfer.Err(), xfer.submitted)
+		xfer.endSpan()
+		xfer.retries = 0
+		u.tapTransfer(xfer)
+		xfer.deliver()
 	}
 }
 
+// deliver hands a completed transfer to its Callback and Done
+func (xfer *Transfer) deliver() {
+	if xfer.Callback != nil {
+		xfer.Callback(xfer)
+	}
+	if xfer.Done != nil {
+		xfer.Done <- xfer
+	}
+}
+
+// SubmitTransfer queues xfer to the kernel and returns immediately.  The
+// reaper fills in Status and Length and then calls Callback and writes
+// xfer to Done, so Done must be buffered or serviced promptly.  Data must
+// not be touched until the transfer completes.  A Transfer may be
+// resubmitted as soon as it has come back.
+//
+// The URB the kernel is given comes from slabs the device keeps, and goes
+// back to them when the transfer completes, so a program that allocates
+// its Transfers up front and reuses them allocates nothing per
+// submission or completion once the device has had that many in flight.
+// A tap or tracer set on the device does, for its events and spans, as
+// does a failed submission for its error.
+func (u *Device) SubmitTransfer(xfer *Transfer) error {
+	u.lock.Lock()
+	if u.fd < 0 || u.closing {
+		u.lock.Unlock()
+		return syscall.EBADF
+	}
+	if !u.reaping {
+		if e := syscall.Pipe2(u.wake[:], syscall.O_CLOEXEC|syscall.O_NONBLOCK); e != nil {
+			u.lock.Unlock()
+			return e
+		}
+		u.reaping = true
+		u.reaped = make(chan struct{})
+		go u.reaper()
+	}
+	fd := u.fd
+	// usbfs counts a mapped buffer when it is mapped, not per URB
+	mapped := u.inMapped(xfer.Data)
+	u.submitting.Add(1)
+	u.lock.Unlock()
+	defer u.submitting.Done()
+
+	q := u.queue(xfer.Endpoint)
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if xfer.token != 0 && q.active[xfer.token] == xfer {
+		return syscall.EBUSY
+	}
+	if e := u.getURB(xfer); e != nil {
+		return u.xferError("submit", time.Now(), xfer.Endpoint, len(xfer.Data), e)
+	}
+	xfer.token = u.token.Add(1)
+	if xfer.token == 0 {
+		xfer.token = u.token.Add(1)
+	}
+	xfer.urb.usercontext = xfer.token
+	if q.active == nil {
+		q.active = make(map[uintptr]*Transfer)
+	}
+	q.active[xfer.token] = xfer
+	u.nactive.Add(1)
+	u.startSpan(xfer)
+	xfer.tapID = u.tapSubmit(xfer.Type, xfer.Endpoint, nil, xfer.Data)
+	xfer.submitted = time.Now()
+	e := submitURB(fd, xfer)
+	if e != nil {
+		delete(q.active, xfer.token)
+		u.nactive.Add(-1)
+		xfer.token = 0
+		xfer.retries = 0
+		u.putURB(xfer)
+		if xfer.span != nil {
+			xfer.span.End(e)
+			xfer.span = nil
+		}
+		if e == syscall.ENOMEM {
+			e = u.memoryError()
+		}
+		return u.xferError("submit", time.Now(), xfer.Endpoint, len(xfer.Data), e)
+	}
+	u.count(xfer, mapped)
+	u.inflight(xfer.Endpoint, 1)
+	return nil
+}
+
+// CancelTransfer asks the kernel to discard an in-flight transfer.  It
+// still completes through Done, with Status set to -ENOENT.
+// Cancelling a transfer that is not in flight returns EINVAL.
+func (u *Device) CancelTransfer(xfer *Transfer) error {
+	q := u.queue(xfer.Endpoint)
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if xfer.token == 0 || q.active[xfer.token] != xfer {
+		return syscall.EINVAL
+	}
+	// the fd stays open while the kernel holds a URB
+	_, _, e := ioctl(u.fd, USBDEVFS_DISCARDURB, uintptr(unsafe.Pointer(xfer.urb)))
+	return e
+}
+
 func OpenVidPid(vid uint16, pid uint16) (*Device, error) {
-	for di := DeviceInfoList(); di != nil; di = di.Next {
+	devs, e := ListDevices()
+	if e != nil {
+		return nil, e
+	}
+	for _, di := range devs {
 		if (vid != di.VendorID) || (pid != di.ProductID) {
 			continue
 		}
@@ -66,8 +367,56 @@ func OpenVidPid(vid uint16, pid uint16) (*Device, error) {
 	return nil, syscall.ENODEV
 }
 
+// VidPidDevices iterates over every attached device matching vid and pid,
+// opening each one as it goes.  A device that fails to open is yielded
+// with a nil *Device and the error, and iteration continues.
+func VidPidDevices(vid uint16, pid uint16) iter.Seq2[*Device, error] {
+	return func(yield func(*Device, error) bool) {
+		devs, e := ListDevices()
+		if e != nil {
+			yield(nil, e)
+			return
+		}
+		for _, di := range devs {
+			if (vid != di.VendorID) || (pid != di.ProductID) {
+				continue
+			}
+			if !yield(Open(di)) {
+				return
+			}
+		}
+	}
+}
+
+// OpenAllVidPid opens every attached device matching vid and pid.  If some
+// of them fail to open, the ones that succeeded are returned along with
+// the first error.  ENODEV is returned if nothing matches.
+func OpenAllVidPid(vid uint16, pid uint16) ([]*Device, error) {
+	var list []*Device
+	var err error
+	found := false
+	for dev, e := range VidPidDevices(vid, pid) {
+		found = true
+		if e != nil {
+			if err == nil {
+				err = e
+			}
+			continue
+		}
+		list = append(list, dev)
+	}
+	if !found {
+		return nil, syscall.ENODEV
+	}
+	return list, err
+}
+
 func OpenBusDev(bus int, dev int) (*Device, error) {
-	for di := DeviceInfoList(); di != nil; di = di.Next {
+	devs, e := ListDevices()
+	if e != nil {
+		return nil, e
+	}
+	for _, di := range devs {
 		if (bus != di.BusNum) || (dev != di.DevNum) {
 			continue
 		}
@@ -79,85 +428,219 @@ func OpenBusDev(bus int, dev int) (*Device, error) {
 func Open(di *DeviceInfo) (*Device, error) {
 	fd, e := syscall.Open(di.devpath, os.O_RDWR|syscall.O_CLOEXEC, 0666)
 	if e != nil {
-		return nil, e
+		return nil, &Error{Op: "open", Bus: di.BusNum, Device: di.DevNum, Err: e}
 	}
 	dev := &Device{
-		fd:     fd,
-		active: make(map[uintptr]*Transfer),
-		log:    log.New(os.Stderr, "usb: ", 0),
+		fd:      fd,
+		mapped:  make(map[*byte]int),
+		claimed: make(map[uint32]bool),
+		log:     log.New(os.Stderr, "usb: ", 0),
+		bus:     di.BusNum,
+		devnum:  di.DevNum,
+		path:    di.devpath,
+		info:    di,
 	}
 	//dev.reaper()
 	return dev, nil
 }
 
+// Close discards any transfers still in flight and waits for them to come
+// back through their Done channels (with Status -ENOENT) before closing the
+// device, so callers must keep servicing Done until Close returns.  It
+// also waits for synchronous calls already in progress, which a timeout
+// of 0 can make wait forever; calls made once Close has begun fail with
+// EBADF.
 func (u *Device) Close() {
-	// TODO: sanely shutdown reaper
+	u.lock.Lock()
+	if u.fd < 0 || u.closing {
+		u.lock.Unlock()
+		return
+	}
+	u.closing = true
+	u.lock.Unlock()
+	if b := u.monitor.Swap(nil); b != nil {
+		b.m.Closing()
+	}
+	// submissions are quick, and once they're in every URB can be found
+	u.submitting.Wait()
+	for i := range u.queues {
+		q := &u.queues[i]
+		q.lock.Lock()
+		for _, xfer := range q.active {
+			ioctl(u.fd, USBDEVFS_DISCARDURB, uintptr(unsafe.Pointer(xfer.urb)))
+		}
+		q.lock.Unlock()
+	}
+	u.lock.Lock()
+	u.drained = true
+	reaped := u.reaped
+	if u.reaping {
+		syscall.Write(u.wake[1], []byte{0})
+	}
+	u.lock.Unlock()
+	if reaped != nil {
+		<-reaped
+	}
+	// the number must not be reused for another file while an ioctl
+	// might still be issued on it
+	u.users.Wait()
 	u.lock.Lock()
 	syscall.Close(u.fd)
 	u.fd = -1
 	u.lock.Unlock()
+	u.tapOp("close", nil)
+}
+
+// do issues a synchronous ioctl with the fd held open against Close.
+// The reaper and the URB calls made under u.lock don't need this: Close
+// waits for the reaper and takes the lock before closing.
+func (u *Device) do(req uintptr, arg unsafe.Pointer) (int, error) {
+	fd, e := u.hold()
+	if e != nil {
+		return 0, e
+	}
+	defer u.users.Done()
+	n, _, e := ioctl(fd, req, uintptr(arg))
+	return n, e
+}
+
+// hold takes a reference on the fd, which the caller drops with
+// u.users.Done()
+func (u *Device) hold() (int, error) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	if u.fd < 0 || u.closing {
+		return -1, syscall.EBADF
+	}
+	u.users.Add(1)
+	return u.fd, nil
 }
 
 func (u *Device) ClaimInterface(n uint32) error {
-	_, _, e := ioctl(u.fd, USBDEVFS_CLAIMINTERFACE, uintptr(unsafe.Pointer(&n)))
-	return e
+	start := time.Now()
+	_, e := u.do(USBDEVFS_CLAIMINTERFACE, unsafe.Pointer(&n))
+	u.tapOp("claim", e, int(n))
+	if e == nil {
+		u.lock.Lock()
+		u.claimed[n] = true
+		u.lock.Unlock()
+	}
+	return u.opError("claim", start, e, int(n))
 }
 
 func (u *Device) ReleaseInterface(n uint32) error {
-	_, _, e := ioctl(u.fd, USBDEVFS_RELEASEINTERFACE, uintptr(unsafe.Pointer(&n)))
-	return e
+	start := time.Now()
+	_, e := u.do(USBDEVFS_RELEASEINTERFACE, unsafe.Pointer(&n))
+	u.tapOp("release", e, int(n))
+	if e == nil || e == syscall.EINVAL {
+		u.lock.Lock()
+		delete(u.claimed, n)
+		u.lock.Unlock()
+	}
+	return u.opError("release", start, e, int(n))
 }
 
 func (u *Device) ClearHalt(endpoint uint8) error {
 	var n = uint32(endpoint)
-	_, _, e := ioctl(u.fd, USBDEVFS_CLEAR_HALT, uintptr(unsafe.Pointer(&n)))
-	return e
+	start := time.Now()
+	_, e := u.do(USBDEVFS_CLEAR_HALT, unsafe.Pointer(&n))
+	u.tapOp("clear_halt", e, int(endpoint))
+	if e == nil {
+		u.unhalt(haltBit(endpoint))
+	}
+	return u.opError("clear_halt", start, e, int(endpoint))
 }
 
 func (u *Device) SetConfiguration(num uint8) error {
 	var n = uint32(num)
-	_, _, e := ioctl(u.fd, USBDEVFS_SETCONFIGURATION, uintptr(unsafe.Pointer(&n)))
-	return e
+	start := time.Now()
+	_, e := u.do(USBDEVFS_SETCONFIGURATION, unsafe.Pointer(&n))
+	u.tapOp("set_configuration", e, int(num))
+	if e == nil {
+		u.unhalt(^uint32(0))
+	}
+	return u.opError("set_configuration", start, e, int(num))
 }
 
 func (u *Device) SetInterface(num uint8, alt uint8) error {
 	x := usbdevfs_setifc{uint32(num), uint32(alt)}
-	_, _, e := ioctl(u.fd, USBDEVFS_SETINTERFACE, uintptr(unsafe.Pointer(&x)))
-	return e
+	start := time.Now()
+	_, e := u.do(USBDEVFS_SETINTERFACE, unsafe.Pointer(&x))
+	u.tapOp("set_interface", e, int(num), int(alt))
+	return u.opError("set_interface", start, e, int(num), int(alt))
+}
+
+// Reset resets the device's port.  The device reenumerates, possibly with a
+// new device number, if its descriptors changed.
+func (u *Device) Reset() error {
+	start := time.Now()
+	_, e := u.do(USBDEVFS_RESET, nil)
+	u.tapOp("reset", e)
+	if e == nil {
+		u.unhalt(^uint32(0))
+	}
+	return u.opError("reset", start, e)
 }
 
 func (u *Device) DisconnectDriver(ifc uint8) error {
 	x := usbdevfs_ioctl{uint32(ifc), USBDEVFS_DISCONNECT, 0}
-	_, _, e := ioctl(u.fd, USBDEVFS_IOCTL, uintptr(unsafe.Pointer(&x)))
-	return e
+	start := time.Now()
+	_, e := u.do(USBDEVFS_IOCTL, unsafe.Pointer(&x))
+	u.tapOp("disconnect_driver", e, int(ifc))
+	return u.opError("disconnect_driver", start, e, int(ifc))
 }
 
 func (u *Device) ControlTransfer(
 	reqtype uint8, request uint8, value uint16, index uint16,
 	length uint16, timeout uint32, data []byte) (int, error) {
+	return u.ControlTransferContext(context.Background(), reqtype, request, value, index, length, timeout, data)
+}
+
+func (u *Device) control(
+	reqtype uint8, request uint8, value uint16, index uint16,
+	length uint16, timeout uint32, data []byte) (int, error) {
 
 	if int(length) > len(data) {
 		return 0, syscall.ENOSPC
 	}
-	p := unsafe.Pointer(&data[0])
-	ct := ctrltransfer{reqtype, request, value, index, length, timeout, 0, uintptr(p)}
-	n, _, e := ioctl(u.fd, USBDEVFS_CONTROL, uintptr(unsafe.Pointer(&ct)))
-	return n, e
+	var p unsafe.Pointer
+	if len(data) > 0 {
+		p = unsafe.Pointer(&data[0])
+	}
+	ct := ctrltransfer{reqtype, request, value, index, length, timeout, uintptr(p)}
+	id := u.tapControl(&ct, data)
+	start := time.Now()
+	n, e := u.do(USBDEVFS_CONTROL, unsafe.Pointer(&ct))
+	u.record(0, n, e, start)
+	if id != 0 {
+		u.tapDone(id, URB_TYPE_CONTROL, reqtype&ENDPOINT_IN, n, e, data)
+	}
+	return n, u.xferError("control", start, reqtype&ENDPOINT_IN, int(length), e)
 }
 
 func (u *Device) BulkTransfer(endpoint uint32, length uint32, timeout uint32, inData []byte) (int, []byte, error) {
+	return u.BulkTransferContext(context.Background(), endpoint, length, timeout, inData)
+}
+
+func (u *Device) bulk(endpoint uint32, length uint32, timeout uint32, inData []byte) (int, []byte, error) {
 
 	if int(length) > len(inData) {
 		return 0, nil, syscall.ENOSPC
 	}
-	p := unsafe.Pointer(&inData[0])
-	bt := bulktransfer{endpoint, length, timeout, 0, uintptr(p)}
-	n, _, e := ioctl(u.fd, USBDEVFS_BULK, uintptr(unsafe.Pointer(&bt)))
-	//fmt.Printf("ioctl return n = %d\n", n)
-	if e != nil {
-		fmt.Println("ERROR: ioctl error")
-		fmt.Println(e)
+	// a zero length packet has no buffer
+	var p unsafe.Pointer
+	if len(inData) > 0 {
+		p = unsafe.Pointer(&inData[0])
+	}
+	bt := bulktransfer{endpoint, length, timeout, uintptr(p)}
+	id := u.tapSubmit(URB_TYPE_BULK, uint8(endpoint), nil, inData[:length])
+	start := time.Now()
+	n, e := u.do(USBDEVFS_BULK, unsafe.Pointer(&bt))
+	u.record(uint8(endpoint), n, e, start)
+	if id != 0 {
+		u.tapDone(id, URB_TYPE_BULK, uint8(endpoint), n, e, inData)
 	}
+	e = u.xferError("bulk", start, uint8(endpoint), int(length), e)
 	//binary.LittleEndian.PutUint64(b, uint64(r))
 	b := make([]byte, n)
 	for i := 0; i < n; i++ {
@@ -170,10 +653,55 @@ func (u *Device) BulkTransfer(endpoint uint32, length uint32, timeout uint32, in
 	return n, b, e
 }
 
+// poll waits for any of fds to become ready, retrying if a signal (such as
+// the Go runtime's preemption signal) interrupts the wait.
+func poll(fds []pollfd) error {
+	for {
+		_, _, e := syscall.Syscall6(syscall.SYS_PPOLL, uintptr(unsafe.Pointer(&fds[0])),
+			uintptr(len(fds)), 0, 0, 0, 0)
+		if e == syscall.EINTR {
+			continue
+		}
+		if e != 0 {
+			return e
+		}
+		return nil
+	}
+}
+
+// submitURB is ioctl for USBDEVFS_SUBMITURB, counting the retries of a
+// submission a signal interrupted against the transfer
+func submitURB(fd int, xfer *Transfer) error {
+	for {
+		_, _, e := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), USBDEVFS_SUBMITURB, uintptr(unsafe.Pointer(xfer.urb)))
+		if e == syscall.EINTR {
+			xfer.retries++
+			continue
+		}
+		if e != 0 {
+			return e
+		}
+		return nil
+	}
+}
+
+// ioctl retries calls interrupted by signals; usbfs only returns EINTR from
+// its interruptible waits, before anything has been done.  Other errnos,
+// including EAGAIN from the nonblocking reap, are passed back to the caller.
+// usbfs ioctls return non-negative ints on success, so a negative result
+// without an errno is reported as EIO rather than as a huge count.
 func ioctl(fd int, req uintptr, arg uintptr) (int, uintptr, error) {
-	r, b, e := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), req, arg)
-	if e == 0 {
+	for {
+		r, b, e := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), req, arg)
+		if e == syscall.EINTR {
+			continue
+		}
+		if e != 0 {
+			return 0, b, e
+		}
+		if int32(r) < 0 {
+			return 0, b, syscall.EIO
+		}
 		return int(r), b, nil
 	}
-	return 0, b, e
 }
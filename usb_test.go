@@ -0,0 +1,493 @@
+package usb_test
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/richardnwinder/usb"
+	"github.com/richardnwinder/usb/internal/loopback"
+)
+
+// soakBytes is how much TestSoak streams: 256M unless USB_SOAK_BYTES says
+// otherwise, with a K, M, G or T suffix, as 1T for the long run.
+func soakBytes(t *testing.T) int64 {
+	s := os.Getenv("USB_SOAK_BYTES")
+	if s == "" {
+		return 256 << 20
+	}
+	shift := 0
+	if i := strings.IndexByte("KMGT", s[len(s)-1]); i >= 0 {
+		shift = 10 * (i + 1)
+		s = s[:len(s)-1]
+	}
+	n, e := strconv.ParseInt(s, 10, 64)
+	if e != nil || n <= 0 {
+		t.Fatalf("USB_SOAK_BYTES %q", os.Getenv("USB_SOAK_BYTES"))
+	}
+	return n << shift
+}
+
+// TestSoak keeps a queue of bulk transfers full in both directions
+// through the loopback gadget, reusing the same Transfers throughout, and
+// checks that the stream comes back whole and in order and that nothing
+// is left tracked or allocated afterwards.
+func TestSoak(t *testing.T) {
+	dev, _, eps := openLoopback(t)
+	const (
+		depth = 8
+		size  = 4096 // the gadget echoes up to this much per transfer
+	)
+	total := soakBytes(t) / size * size
+	done := make(chan *usb.Transfer, 2*depth)
+	var sent, posted, received int64
+	outstanding := 0 // submitted and not yet back on done
+	var base runtime.MemStats
+	ins := 0
+	submitOut := func(x *usb.Transfer) {
+		// each transfer carries its offset in the stream
+		binary.LittleEndian.PutUint64(x.Data, uint64(sent))
+		sent += size
+		outstanding++
+		if e := dev.SubmitTransfer(x); e != nil {
+			t.Fatal(e)
+		}
+	}
+	submitIn := func(x *usb.Transfer) {
+		posted += size
+		outstanding++
+		if e := dev.SubmitTransfer(x); e != nil {
+			t.Fatal(e)
+		}
+	}
+	for i := 0; i < depth && sent < total; i++ {
+		submitOut(&usb.Transfer{Type: usb.URB_TYPE_BULK, Endpoint: eps.BulkOut, Data: make([]byte, size), Done: done})
+		submitIn(&usb.Transfer{Type: usb.URB_TYPE_BULK, Endpoint: eps.BulkIn, Data: make([]byte, size), Done: done})
+	}
+	for outstanding > 0 {
+		x := <-done
+		outstanding--
+		if e := x.Err(); e != nil {
+			t.Fatalf("endpoint %#02x at %d of %d: %v", x.Endpoint, received, total, e)
+		}
+		if x.Endpoint == eps.BulkOut {
+			if sent < total {
+				submitOut(x)
+			}
+			continue
+		}
+		if x.Length != size {
+			t.Fatalf("short transfer of %d at %d", x.Length, received)
+		}
+		if off := int64(binary.LittleEndian.Uint64(x.Data)); off != received {
+			t.Fatalf("got offset %d, want %d", off, received)
+		}
+		received += size
+		if posted < total {
+			submitIn(x)
+		}
+		// measure the heap once everything is warmed up
+		if ins++; ins == 1024 {
+			runtime.GC()
+			runtime.ReadMemStats(&base)
+		}
+	}
+	if received != total {
+		t.Fatalf("received %d of %d bytes", received, total)
+	}
+	checkIdle(t, dev)
+	if n := dev.ReapStats().URBs; n != uint64(2*total/size) {
+		t.Errorf("reaped %d URBs, want %d", n, 2*total/size)
+	}
+	if ins > 1024 {
+		var end runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&end)
+		// allow for noise, but not for anything kept per transfer
+		if grew := int64(end.HeapAlloc) - int64(base.HeapAlloc); grew > 1<<20 {
+			t.Errorf("heap grew by %d bytes over %d transfers", grew, 2*(ins-1024))
+		}
+	}
+	t.Logf("streamed %d bytes each way", total)
+}
+
+// TestResubmitCancel has workers on two endpoints each submit a transfer
+// that nothing will complete, try to submit it again, cancel it and wait
+// for it, all at once, so the token bookkeeping is shared between them.
+func TestResubmitCancel(t *testing.T) {
+	dev, _, eps := openLoopback(t)
+	const workers, rounds = 8, 200
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for w := 0; w < workers; w++ {
+		ep := eps.BulkIn
+		if w%2 == 1 {
+			ep = eps.IntIn
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			done := make(chan *usb.Transfer, 1)
+			x := &usb.Transfer{Type: usb.URB_TYPE_BULK, Endpoint: ep, Data: make([]byte, 64), Done: done}
+			if ep == eps.IntIn {
+				x.Type = usb.URB_TYPE_INTERRUPT
+			}
+			for i := 0; i < rounds; i++ {
+				if e := dev.SubmitTransfer(x); e != nil {
+					errs <- fmt.Errorf("submit: %v", e)
+					return
+				}
+				if e := dev.SubmitTransfer(x); e != syscall.EBUSY {
+					errs <- fmt.Errorf("submitting an in-flight transfer gave %v, want EBUSY", e)
+					return
+				}
+				if e := dev.CancelTransfer(x); e != nil {
+					errs <- fmt.Errorf("cancel: %v", e)
+					return
+				}
+				if <-done; !isCancelled(x) {
+					errs <- fmt.Errorf("cancelled transfer has status %d", x.Status)
+					return
+				}
+				if e := dev.CancelTransfer(x); e != syscall.EINVAL {
+					errs <- fmt.Errorf("cancelling a completed transfer gave %v, want EINVAL", e)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for e := range errs {
+		t.Error(e)
+	}
+	checkIdle(t, dev)
+}
+
+// TestSubmitReapCancelRace keeps data flowing through the gadget while
+// workers submit transfers to receive it and cancel them at random, and
+// some cancel each other's from their Callbacks on the reaper, so that
+// completions, discards and resubmissions cross.  Every submission must
+// come back exactly once, either with data or cancelled.
+func TestSubmitReapCancelRace(t *testing.T) {
+	dev, _, eps := openLoopback(t)
+	const workers, rounds = 8, 500
+	stop := make(chan struct{})
+	pumped := make(chan struct{})
+	go func() {
+		defer close(pumped)
+		p := make([]byte, 64)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			// times out while no worker is reading
+			dev.BulkTransfer(uint32(eps.BulkOut), uint32(len(p)), 20, p)
+		}
+	}()
+	var wg sync.WaitGroup
+	var ok, cancelled atomic.Int64
+	errs := make(chan error, workers)
+	xs := make([]*usb.Transfer, workers)
+	for w := range xs {
+		xs[w] = &usb.Transfer{Type: usb.URB_TYPE_BULK, Endpoint: eps.BulkIn, Data: make([]byte, 64),
+			Done: make(chan *usb.Transfer, 1)}
+		if w%4 == 0 {
+			next := (w + 1) % workers
+			xs[w].Callback = func(*usb.Transfer) {
+				dev.CancelTransfer(xs[next])
+			}
+		}
+	}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			rnd := rand.New(rand.NewSource(int64(w)))
+			x := xs[w]
+			done := x.Done
+			for i := 0; i < rounds; i++ {
+				if e := dev.SubmitTransfer(x); e != nil {
+					errs <- fmt.Errorf("submit: %v", e)
+					return
+				}
+				if rnd.Intn(2) == 0 {
+					runtime.Gosched()
+				}
+				// it may have completed already, or been cancelled by
+				// another worker's Callback
+				dev.CancelTransfer(x)
+				<-done
+				switch {
+				case x.Status == 0:
+					ok.Add(1)
+				case isCancelled(x):
+					cancelled.Add(1)
+				default:
+					errs <- fmt.Errorf("status %d", x.Status)
+					return
+				}
+				select {
+				case <-done:
+					errs <- fmt.Errorf("transfer came back twice")
+					return
+				default:
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(stop)
+	<-pumped
+	close(errs)
+	for e := range errs {
+		t.Error(e)
+	}
+	checkIdle(t, dev)
+	t.Logf("%d completed, %d cancelled", ok.Load(), cancelled.Load())
+}
+
+// isCancelled says whether x came back discarded, which the kernel
+// reports as ENOENT or, depending on whether the host controller had
+// started on it, ECONNRESET
+func isCancelled(x *usb.Transfer) bool {
+	e := x.Err()
+	return errors.Is(e, usb.ErrCancelled) || errors.Is(e, usb.ErrUnlinked)
+}
+
+// checkIdle fails t if dev still tracks any transfer
+func checkIdle(t *testing.T, dev *usb.Device) {
+	t.Helper()
+	if n := dev.UsbfsHeld(); n != 0 {
+		t.Errorf("device holds %d bytes of usbfs memory when idle", n)
+	}
+	for ep, s := range dev.Stats() {
+		if s.InFlight != 0 {
+			t.Errorf("endpoint %#02x has %d transfers in flight when idle", ep, s.InFlight)
+		}
+	}
+}
+
+// TestCloseRace closes the device while synchronous calls and
+// submissions are under way on it, over and over, with another goroutine
+// opening and closing files all the while so that a descriptor number
+// Close gives up is soon reused.  A call that raced Close must fail with
+// EBADF; one that reached the reused number would get ENOTTY instead.
+// Every transfer submitted must still come back.
+func TestCloseRace(t *testing.T) {
+	first, di, eps := openLoopback(t)
+	first.Close()
+	stop := make(chan struct{})
+	churned := make(chan struct{})
+	go func() {
+		defer close(churned)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if f, e := os.Open(os.DevNull); e == nil {
+				f.Close()
+			}
+		}
+	}()
+	defer func() {
+		close(stop)
+		<-churned
+	}()
+	for round := 0; round < 50; round++ {
+		dev, e := usb.Open(di)
+		if e != nil {
+			t.Fatal(e)
+		}
+		if e := dev.ClaimInterface(0); e != nil {
+			dev.Close()
+			t.Fatal(e)
+		}
+		var wg sync.WaitGroup
+		errs := make(chan error, 8)
+		for w := 0; w < 4; w++ {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				status := make([]byte, 2)
+				for {
+					_, e := dev.ControlTransfer(usb.REQTYPE_IN|usb.REQTYPE_STANDARD|usb.REQTYPE_DEVICE,
+						usb.REQ_GET_STATUS, 0, 0, 2, 1000, status)
+					if e != nil {
+						if !errors.Is(e, syscall.EBADF) {
+							errs <- fmt.Errorf("control transfer racing Close: %v", e)
+						}
+						return
+					}
+				}
+			}()
+			go func() {
+				defer wg.Done()
+				done := make(chan *usb.Transfer, 1)
+				x := &usb.Transfer{Type: usb.URB_TYPE_BULK, Endpoint: eps.BulkIn, Data: make([]byte, 64), Done: done}
+				for {
+					if e := dev.SubmitTransfer(x); e != nil {
+						if !errors.Is(e, syscall.EBADF) {
+							errs <- fmt.Errorf("submit racing Close: %v", e)
+						}
+						return
+					}
+					if <-done; !isCancelled(x) {
+						errs <- fmt.Errorf("transfer discarded by Close has status %d", x.Status)
+						return
+					}
+				}
+			}()
+		}
+		time.Sleep(time.Duration(round%5) * time.Millisecond)
+		// nothing completes the submitted transfers but Close, so
+		// the submitters are waiting on them while it runs
+		dev.Close()
+		wg.Wait()
+		close(errs)
+		for e := range errs {
+			t.Fatalf("round %d: %v", round, e)
+		}
+	}
+}
+
+// benchStream echoes b.N bulk transfers of size bytes through the gadget,
+// eight queued each way, and reports the rate they come back at
+func benchStream(b *testing.B, dev *usb.Device, eps loopback.Endpoints, size int) {
+	const depth = 8
+	done := make(chan *usb.Transfer, 2*depth)
+	outs, ins, back := 0, 0, 0
+	submit := func(x *usb.Transfer) {
+		if e := dev.SubmitTransfer(x); e != nil {
+			b.Fatal(e)
+		}
+	}
+	b.SetBytes(int64(size))
+	b.ResetTimer()
+	for i := 0; i < depth && i < b.N; i++ {
+		submit(&usb.Transfer{Type: usb.URB_TYPE_BULK, Endpoint: eps.BulkOut, Data: make([]byte, size), Done: done})
+		submit(&usb.Transfer{Type: usb.URB_TYPE_BULK, Endpoint: eps.BulkIn, Data: make([]byte, size), Done: done})
+		outs++
+		ins++
+	}
+	for back < b.N {
+		x := <-done
+		if e := x.Err(); e != nil {
+			b.Fatal(e)
+		}
+		if x.Endpoint == eps.BulkOut {
+			if outs < b.N {
+				outs++
+				submit(x)
+			}
+			continue
+		}
+		back++
+		if ins < b.N {
+			ins++
+			submit(x)
+		}
+	}
+	b.StopTimer()
+	// the last OUT transfers may still be on their way back
+	for n := back; n < outs; n++ {
+		<-done
+	}
+}
+
+func BenchmarkBulkStream(b *testing.B) {
+	dev, _, eps := openLoopback(b)
+	benchStream(b, dev, eps, 4096)
+}
+
+// BenchmarkBulkStreamWithControl streams as BenchmarkBulkStream does
+// while another goroutine keeps a vendor request in progress on ep0,
+// which the gadget answers from its event loop and so slowly.  With each
+// endpoint locked apart, the two should stream at about the same rate.
+func BenchmarkBulkStreamWithControl(b *testing.B) {
+	dev, _, eps := openLoopback(b)
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	var controls atomic.Int64
+	go func() {
+		defer close(stopped)
+		buf := make([]byte, 64)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if _, e := dev.ControlTransfer(0xc1, loopback.REQ_RECALL, 0, 0, uint16(len(buf)), 1000, buf); e == nil {
+				controls.Add(1)
+			}
+		}
+	}()
+	benchStream(b, dev, eps, 4096)
+	close(stop)
+	<-stopped
+	b.ReportMetric(float64(controls.Load())/b.Elapsed().Seconds(), "controls/s")
+}
+
+// BenchmarkSubmitAllocs echoes a packet through the gadget with one OUT
+// and one IN transfer, reused throughout, and reports what each round
+// trip allocates, which with the URBs from the device's slab should be
+// nothing.
+func BenchmarkSubmitAllocs(b *testing.B) {
+	dev, _, eps := openLoopback(b)
+	done := make(chan *usb.Transfer, 2)
+	out := &usb.Transfer{Type: usb.URB_TYPE_BULK, Endpoint: eps.BulkOut, Data: make([]byte, 64), Done: done}
+	in := &usb.Transfer{Type: usb.URB_TYPE_BULK, Endpoint: eps.BulkIn, Data: make([]byte, 64), Done: done}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if e := dev.SubmitTransfer(in); e != nil {
+			b.Fatal(e)
+		}
+		if e := dev.SubmitTransfer(out); e != nil {
+			b.Fatal(e)
+		}
+		for range 2 {
+			if x := <-done; x.Status != 0 {
+				b.Fatal(x.Err())
+			}
+		}
+	}
+}
+
+// BenchmarkSubmitCancel submits and cancels transfers from parallel
+// goroutines, each on an endpoint of its own where it can, which the
+// device lock used to serialize.
+func BenchmarkSubmitCancel(b *testing.B) {
+	dev, _, eps := openLoopback(b)
+	var next atomic.Int32
+	b.RunParallel(func(pb *testing.PB) {
+		x := &usb.Transfer{Type: usb.URB_TYPE_BULK, Endpoint: eps.BulkIn, Data: make([]byte, 64),
+			Done: make(chan *usb.Transfer, 1)}
+		if next.Add(1)%2 == 0 {
+			x.Type, x.Endpoint = usb.URB_TYPE_INTERRUPT, eps.IntIn
+		}
+		for pb.Next() {
+			if e := dev.SubmitTransfer(x); e != nil {
+				b.Error(e)
+				return
+			}
+			dev.CancelTransfer(x)
+			<-x.Done
+		}
+	})
+}
@@ -14,31 +14,52 @@
 
 package usb
 
+import "unsafe"
+
 const (
-	USBDEVFS_CONTROL          = 0xc0185500
-	USBDEVFS_BULK             = 0xc0185502
-	USBDEVFS_RESETEP          = 0x80045503
-	USBDEVFS_SETINTERFACE     = 0x80085504
-	USBDEVFS_SETCONFIGURATION = 0x80045505
-	USBDEVFS_GETDRIVER        = 0x41045508
-	USBDEVFS_SUBMITURB        = 0x8038550a
-	USBDEVFS_DISCARDURB       = 0x0000550b
-	USBDEVFS_REAPURB          = 0x4008550c
-	USBDEVFS_REAPURBNDELAY    = 0x4008550d
-	USBDEVFS_DISCSIGNAL       = 0x8010550e
-	USBDEVFS_CLAIMINTERFACE   = 0x8004550f
-	USBDEVFS_RELEASEINTERFACE = 0x80045510
-	USBDEVFS_CONNECTINFO      = 0x40085511
-	USBDEVFS_IOCTL            = 0xc0105512
-	USBDEVFS_HUB_PORTINFO     = 0x80805513
-	USBDEVFS_RESET            = 0x00005514
-	USBDEVFS_CLEAR_HALT       = 0x80045515
-	USBDEVFS_DISCONNECT       = 0x00005516
-	USBDEVFS_CONNECT          = 0x00005517
-	USBDEVFS_CLAIM_PORT       = 0x80045518
-	USBDEVFS_RELEASE_PORT     = 0x80045519
-	USBDEVFS_GET_CAPABILITIES = 0x8004551a
-	USBDEVFS_DISCONNECT_CLAIM = 0x8108551b
+	USBDEVFS_CONTROL          = (_IOC_READ|_IOC_WRITE)<<_IOC_DIRSHIFT | sizeof_ctrltransfer<<_IOC_SIZESHIFT | 'U'<<8 | 0
+	USBDEVFS_BULK             = (_IOC_READ|_IOC_WRITE)<<_IOC_DIRSHIFT | sizeof_bulktransfer<<_IOC_SIZESHIFT | 'U'<<8 | 2
+	USBDEVFS_RESETEP          = _IOC_READ<<_IOC_DIRSHIFT | 4<<_IOC_SIZESHIFT | 'U'<<8 | 3
+	USBDEVFS_SETINTERFACE     = _IOC_READ<<_IOC_DIRSHIFT | 8<<_IOC_SIZESHIFT | 'U'<<8 | 4
+	USBDEVFS_SETCONFIGURATION = _IOC_READ<<_IOC_DIRSHIFT | 4<<_IOC_SIZESHIFT | 'U'<<8 | 5
+	USBDEVFS_GETDRIVER        = _IOC_WRITE<<_IOC_DIRSHIFT | 260<<_IOC_SIZESHIFT | 'U'<<8 | 8
+	USBDEVFS_SUBMITURB        = _IOC_READ<<_IOC_DIRSHIFT | sizeof_usbdevfs_urb<<_IOC_SIZESHIFT | 'U'<<8 | 10
+	USBDEVFS_DISCARDURB       = _IOC_NONE<<_IOC_DIRSHIFT | 'U'<<8 | 11
+	USBDEVFS_REAPURB          = _IOC_WRITE<<_IOC_DIRSHIFT | sizeof_pointer<<_IOC_SIZESHIFT | 'U'<<8 | 12
+	USBDEVFS_REAPURBNDELAY    = _IOC_WRITE<<_IOC_DIRSHIFT | sizeof_pointer<<_IOC_SIZESHIFT | 'U'<<8 | 13
+	USBDEVFS_DISCSIGNAL       = _IOC_READ<<_IOC_DIRSHIFT | 2*sizeof_pointer<<_IOC_SIZESHIFT | 'U'<<8 | 14
+	USBDEVFS_CLAIMINTERFACE   = _IOC_READ<<_IOC_DIRSHIFT | 4<<_IOC_SIZESHIFT | 'U'<<8 | 15
+	USBDEVFS_RELEASEINTERFACE = _IOC_READ<<_IOC_DIRSHIFT | 4<<_IOC_SIZESHIFT | 'U'<<8 | 16
+	USBDEVFS_CONNECTINFO      = _IOC_WRITE<<_IOC_DIRSHIFT | 8<<_IOC_SIZESHIFT | 'U'<<8 | 17
+	USBDEVFS_IOCTL            = (_IOC_READ|_IOC_WRITE)<<_IOC_DIRSHIFT | sizeof_usbdevfs_ioctl<<_IOC_SIZESHIFT | 'U'<<8 | 18
+	USBDEVFS_HUB_PORTINFO     = _IOC_READ<<_IOC_DIRSHIFT | 128<<_IOC_SIZESHIFT | 'U'<<8 | 19
+	USBDEVFS_RESET            = _IOC_NONE<<_IOC_DIRSHIFT | 'U'<<8 | 20
+	USBDEVFS_CLEAR_HALT       = _IOC_READ<<_IOC_DIRSHIFT | 4<<_IOC_SIZESHIFT | 'U'<<8 | 21
+	USBDEVFS_DISCONNECT       = _IOC_NONE<<_IOC_DIRSHIFT | 'U'<<8 | 22
+	USBDEVFS_CONNECT          = _IOC_NONE<<_IOC_DIRSHIFT | 'U'<<8 | 23
+	USBDEVFS_CLAIM_PORT       = _IOC_READ<<_IOC_DIRSHIFT | 4<<_IOC_SIZESHIFT | 'U'<<8 | 24
+	USBDEVFS_RELEASE_PORT     = _IOC_READ<<_IOC_DIRSHIFT | 4<<_IOC_SIZESHIFT | 'U'<<8 | 25
+	USBDEVFS_GET_CAPABILITIES = _IOC_READ<<_IOC_DIRSHIFT | 4<<_IOC_SIZESHIFT | 'U'<<8 | 26
+	USBDEVFS_DISCONNECT_CLAIM = _IOC_READ<<_IOC_DIRSHIFT | 264<<_IOC_SIZESHIFT | 'U'<<8 | 27
+)
+
+// The argument size is encoded in the ioctl number, so the Go layouts below
+// must match the kernel ABI for every GOARCH.  The sizeof_* values come from
+// the per-word-size files; these declarations fail to compile if a struct
+// drifts from them.
+var (
+	_ [sizeof_pointer - unsafe.Sizeof(uintptr(0))]byte
+	_ [unsafe.Sizeof(uintptr(0)) - sizeof_pointer]byte
+	_ [sizeof_ctrltransfer - unsafe.Sizeof(ctrltransfer{})]byte
+	_ [unsafe.Sizeof(ctrltransfer{}) - sizeof_ctrltransfer]byte
+	_ [sizeof_bulktransfer - unsafe.Sizeof(bulktransfer{})]byte
+	_ [unsafe.Sizeof(bulktransfer{}) - sizeof_bulktransfer]byte
+	_ [sizeof_usbdevfs_urb - unsafe.Sizeof(usbdevfs_urb{})]byte
+	_ [unsafe.Sizeof(usbdevfs_urb{}) - sizeof_usbdevfs_urb]byte
+	_ [sizeof_usbdevfs_ioctl - unsafe.Sizeof(usbdevfs_ioctl{})]byte
+	_ [unsafe.Sizeof(usbdevfs_ioctl{}) - sizeof_usbdevfs_ioctl]byte
+	_ [sizeof_usbdevfs_urb - unsafe.Offsetof(isoURB{}.desc)]byte
+	_ [unsafe.Offsetof(isoURB{}.desc) - sizeof_usbdevfs_urb]byte
 )
 
 type ctrltransfer struct {
@@ -48,7 +69,6 @@ type ctrltransfer struct {
 	wIndex       uint16
 	wLength      uint16
 	timeout      uint32 // ms
-	_pad0        uint32
 	data         uintptr
 }
 
@@ -56,7 +76,6 @@ type bulktransfer struct {
 	endpoint uint32
 	length   uint32
 	timeout  uint32 // ms
-	_pad0    uint32
 	data     uintptr
 }
 
@@ -67,13 +86,16 @@ const (
 	URB_TYPE_BULK      = 3
 )
 
+// URBFlag is a set of usbdevfs URB flags, for Transfer.Flags.
+type URBFlag uint32
+
 const (
-	URB_FLAG_SHORT_NOT_OK      = 0x01
-	URB_FLAG_ISO_ASAP          = 0x02
-	URB_FLAG_BULK_CONTINUATION = 0x04
-	URB_FLAG_NO_FSBR           = 0x20
-	URB_FLAG_ZERO_PACKET       = 0x40
-	URB_FLAG_NO_INTERRUPT      = 0x80
+	URB_FLAG_SHORT_NOT_OK      URBFlag = 0x01 // a short IN transfer is an error
+	URB_FLAG_ISO_ASAP          URBFlag = 0x02 // start in the next free frame
+	URB_FLAG_BULK_CONTINUATION URBFlag = 0x04 // continues the previous URB on the endpoint
+	URB_FLAG_NO_FSBR           URBFlag = 0x20 // ignored by the kernel
+	URB_FLAG_ZERO_PACKET       URBFlag = 0x40 // end an OUT transfer of whole packets with a zero-length one
+	URB_FLAG_NO_INTERRUPT      URBFlag = 0x80 // a hint that no completion interrupt is needed
 )
 
 type usbdevfs_urb struct {
@@ -83,7 +105,6 @@ type usbdevfs_urb struct {
 	_pad1             uint8
 	status            int32
 	flags             uint32
-	_pad2             uint32
 	buffer            uintptr
 	buffer_length     int32
 	actual_length     int32
@@ -99,8 +120,26 @@ type usbdevfs_setifc struct {
 	alt uint32
 }
 
+type usbdevfs_getdriver struct {
+	ifc    uint32
+	driver [256]byte
+}
+
 type usbdevfs_ioctl struct {
 	ifc  uint32
 	code uint32
 	data uintptr
 }
+
+const (
+	POLLIN  = 0x01
+	POLLOUT = 0x04
+	POLLERR = 0x08
+	POLLHUP = 0x10
+)
+
+type pollfd struct {
+	fd      int32
+	events  int16
+	revents int16
+}
@@ -0,0 +1,12 @@
+//go:build 386 || arm || mips || mipsle
+
+package usb
+
+// usbdevfs argument sizes for 32-bit kernels
+const (
+	sizeof_pointer        = 4
+	sizeof_ctrltransfer   = 16
+	sizeof_bulktransfer   = 16
+	sizeof_usbdevfs_urb   = 44
+	sizeof_usbdevfs_ioctl = 12
+)
@@ -0,0 +1,12 @@
+//go:build !(386 || arm || mips || mipsle)
+
+package usb
+
+// usbdevfs argument sizes for 64-bit kernels
+const (
+	sizeof_pointer        = 8
+	sizeof_ctrltransfer   = 24
+	sizeof_bulktransfer   = 24
+	sizeof_usbdevfs_urb   = 56
+	sizeof_usbdevfs_ioctl = 16
+)
@@ -0,0 +1,116 @@
+package usb
+
+import (
+	"testing"
+	"unsafe"
+)
+
+// The expected values are worked out from linux/usbdevice_fs.h and the
+// kernel's _IOC macros by hand, not from the constants under test, for a
+// 64-bit and a 32-bit kernel with the asm-generic ioctl layout, and the
+// same with the MIPS and PowerPC one.
+var ioctlNumbers = []struct {
+	name                 string
+	got                  uintptr
+	gen64, gen32         uint32
+	mipsppc64, mipsppc32 uint32
+}{
+	{"CONTROL", USBDEVFS_CONTROL, 0xc0185500, 0xc0105500, 0xc0185500, 0xc0105500},
+	{"BULK", USBDEVFS_BULK, 0xc0185502, 0xc0105502, 0xc0185502, 0xc0105502},
+	{"SUBMITURB", USBDEVFS_SUBMITURB, 0x8038550a, 0x802c550a, 0x4038550a, 0x402c550a},
+	{"DISCARDURB", USBDEVFS_DISCARDURB, 0x0000550b, 0x0000550b, 0x2000550b, 0x2000550b},
+	{"REAPURB", USBDEVFS_REAPURB, 0x4008550c, 0x4004550c, 0x8008550c, 0x8004550c},
+	{"REAPURBNDELAY", USBDEVFS_REAPURBNDELAY, 0x4008550d, 0x4004550d, 0x8008550d, 0x8004550d},
+	{"DISCSIGNAL", USBDEVFS_DISCSIGNAL, 0x8010550e, 0x8008550e, 0x4010550e, 0x4008550e},
+	{"CLAIMINTERFACE", USBDEVFS_CLAIMINTERFACE, 0x8004550f, 0x8004550f, 0x4004550f, 0x4004550f},
+	{"CONNECTINFO", USBDEVFS_CONNECTINFO, 0x40085511, 0x40085511, 0x80085511, 0x80085511},
+	{"IOCTL", USBDEVFS_IOCTL, 0xc0105512, 0xc00c5512, 0xc0105512, 0xc00c5512},
+	{"RESET", USBDEVFS_RESET, 0x00005514, 0x00005514, 0x20005514, 0x20005514},
+	{"GETDRIVER", USBDEVFS_GETDRIVER, 0x41045508, 0x41045508, 0x81045508, 0x81045508},
+	{"DISCONNECT_CLAIM", USBDEVFS_DISCONNECT_CLAIM, 0x8108551b, 0x8108551b, 0x4108551b, 0x4108551b},
+}
+
+func TestIoctlNumbers(t *testing.T) {
+	mipsppc := _IOC_DIRSHIFT == 29
+	for _, c := range ioctlNumbers {
+		want := c.gen64
+		switch {
+		case mipsppc && sizeof_pointer == 8:
+			want = c.mipsppc64
+		case mipsppc:
+			want = c.mipsppc32
+		case sizeof_pointer == 4:
+			want = c.gen32
+		}
+		if uint32(c.got) != want {
+			t.Errorf("USBDEVFS_%s = %#08x, want %#08x", c.name, uint32(c.got), want)
+		}
+	}
+}
+
+// The compile-time checks in usbdevfs.go only hold the structs to the
+// sizeof_* constants; this holds both, and the field offsets the kernel
+// reads, to the kernel's layout.
+func TestStructLayout(t *testing.T) {
+	p := unsafe.Sizeof(uintptr(0))
+	if p != 4 && p != 8 {
+		t.Fatalf("pointer size %d", p)
+	}
+	// the kernel aligns pointers to their size on every architecture we
+	// build for, so a pointer after an odd number of 32-bit fields is
+	// padded on 64-bit only
+	pad := p - 4
+	var urb usbdevfs_urb
+	var ctrl ctrltransfer
+	var bulk bulktransfer
+	var ioc usbdevfs_ioctl
+	for _, c := range []struct {
+		name      string
+		got, want uintptr
+	}{
+		{"sizeof(usbdevfs_ctrltransfer)", unsafe.Sizeof(ctrl), 12 + pad + p},
+		{"offsetof(usbdevfs_ctrltransfer.data)", unsafe.Offsetof(ctrl.data), 12 + pad},
+		{"sizeof(usbdevfs_bulktransfer)", unsafe.Sizeof(bulk), 12 + pad + p},
+		{"offsetof(usbdevfs_bulktransfer.data)", unsafe.Offsetof(bulk.data), 12 + pad},
+		{"sizeof(usbdevfs_urb)", unsafe.Sizeof(urb), 36 + pad + 2*p},
+		{"offsetof(usbdevfs_urb.status)", unsafe.Offsetof(urb.status), 4},
+		{"offsetof(usbdevfs_urb.flags)", unsafe.Offsetof(urb.flags), 8},
+		{"offsetof(usbdevfs_urb.buffer)", unsafe.Offsetof(urb.buffer), 12 + pad},
+		{"offsetof(usbdevfs_urb.buffer_length)", unsafe.Offsetof(urb.buffer_length), 12 + pad + p},
+		{"offsetof(usbdevfs_urb.actual_length)", unsafe.Offsetof(urb.actual_length), 16 + pad + p},
+		{"offsetof(usbdevfs_urb.number_of_packets)", unsafe.Offsetof(urb.number_of_packets), 24 + pad + p},
+		{"offsetof(usbdevfs_urb.signr)", unsafe.Offsetof(urb.signr), 32 + pad + p},
+		{"offsetof(usbdevfs_urb.usercontext)", unsafe.Offsetof(urb.usercontext), 36 + pad + p},
+		{"sizeof(usbdevfs_iso_packet_desc)", unsafe.Sizeof(usbdevfs_iso_packet_desc{}), 12},
+		{"offsetof(usbdevfs_urb.iso_frame_desc)", unsafe.Offsetof(isoURB{}.desc), 36 + pad + 2*p},
+		{"sizeof(usbdevfs_ioctl)", unsafe.Sizeof(ioc), 8 + p},
+		{"offsetof(usbdevfs_ioctl.data)", unsafe.Offsetof(ioc.data), 8},
+		{"sizeof(usbdevfs_getdriver)", unsafe.Sizeof(usbdevfs_getdriver{}), 260},
+		{"sizeof(pollfd)", unsafe.Sizeof(pollfd{}), 8},
+	} {
+		if c.got != c.want {
+			t.Errorf("%s = %d, want %d", c.name, c.got, c.want)
+		}
+	}
+}
+
+// TestURBFlags checks the flags against linux/usbdevice_fs.h, since
+// SubmitTransfer passes them through as they are
+func TestURBFlags(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		got  URBFlag
+		want uint32
+	}{
+		{"SHORT_NOT_OK", URB_FLAG_SHORT_NOT_OK, 0x01},
+		{"ISO_ASAP", URB_FLAG_ISO_ASAP, 0x02},
+		{"BULK_CONTINUATION", URB_FLAG_BULK_CONTINUATION, 0x04},
+		{"NO_FSBR", URB_FLAG_NO_FSBR, 0x20},
+		{"ZERO_PACKET", URB_FLAG_ZERO_PACKET, 0x40},
+		{"NO_INTERRUPT", URB_FLAG_NO_INTERRUPT, 0x80},
+	} {
+		if uint32(c.got) != c.want {
+			t.Errorf("USBDEVFS_URB_%s = %#x, want %#x", c.name, uint32(c.got), c.want)
+		}
+	}
+}
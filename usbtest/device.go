@@ -0,0 +1,415 @@
+// Package usbtest provides a simulated device for testing code written
+// against usb.Conn (which *usb.Device also implements) without
+// hardware.  The device answers the standard requests from its
+// descriptors; everything else, and any misbehaviour worth testing, is
+// scripted with rules:
+//
+//	d := usbtest.NewDevice(desc)
+//	d.On(usbtest.Control(usb.REQTYPE_IN|usb.REQTYPE_VENDOR, 0x01)).Reply(status)
+//	d.On(usbtest.Control(usb.REQTYPE_VENDOR, 0x02)).Nth(3).Stall()
+//	d.On(usbtest.Bulk(0x81)).Short(5)
+//
+// Faulty does the opposite, wrapping a real device and injecting random
+// faults into its transfers.
+package usbtest
+
+import (
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/richardnwinder/usb"
+)
+
+var _ usb.Conn = (*Device)(nil)
+
+// Request is one transfer as the device sees it.
+type Request struct {
+	Endpoint uint8 // 0 for control, with usb.ENDPOINT_IN for reads
+	Setup    usb.ControlRequest
+	Data     []byte // OUT data
+	Length   int    // bytes the host asked for, for IN
+	Seq      int    // this request's number among those matching the rule, from 1
+}
+
+func (r *Request) In() bool {
+	return r.Endpoint&usb.ENDPOINT_IN != 0
+}
+
+// Match selects requests for a rule.
+type Match func(*Request) bool
+
+// Control matches control requests of one type and request code.
+func Control(reqtype uint8, request uint8) Match {
+	return func(r *Request) bool {
+		return r.Endpoint&^usb.ENDPOINT_IN == 0 && r.Setup.RequestType == reqtype && r.Setup.Request == request
+	}
+}
+
+// Bulk matches transfers on a bulk or interrupt endpoint.
+func Bulk(endpoint uint8) Match {
+	return func(r *Request) bool {
+		return r.Endpoint == endpoint && endpoint&^usb.ENDPOINT_IN != 0
+	}
+}
+
+// Any matches every request.
+func Any() Match {
+	return func(*Request) bool { return true }
+}
+
+// Action is a rule's answer: the IN data, or an error to fail with.
+type Action func(*Request) ([]byte, error)
+
+// Rule is one scripted behaviour.  Its methods configure it and return
+// it, so that a rule reads as one line.
+type Rule struct {
+	match  Match
+	nth    int
+	times  int // 0 is unlimited
+	delay  time.Duration
+	action Action
+	short  int // -1 to send everything
+	seen   int
+	used   int
+}
+
+// Nth restricts the rule to the nth matching request, counting from 1.
+func (r *Rule) Nth(n int) *Rule {
+	r.nth = n
+	return r
+}
+
+// Times retires the rule after it has applied n times.
+func (r *Rule) Times(n int) *Rule {
+	r.times = n
+	return r
+}
+
+// After delays the answer, to exercise timeouts.
+func (r *Rule) After(d time.Duration) *Rule {
+	r.delay = d
+	return r
+}
+
+// Do answers with a callback.
+func (r *Rule) Do(a Action) *Rule {
+	r.action = a
+	return r
+}
+
+// Reply answers IN requests with data, cut to what the host asked for,
+// and accepts OUT requests.
+func (r *Rule) Reply(data []byte) *Rule {
+	return r.Do(func(*Request) ([]byte, error) { return data, nil })
+}
+
+// Fail fails the request with e.
+func (r *Rule) Fail(e error) *Rule {
+	return r.Do(func(*Request) ([]byte, error) { return nil, e })
+}
+
+// Stall fails with EPIPE; a stalled bulk endpoint stays halted until
+// ClearHalt, as real ones do.
+func (r *Rule) Stall() *Rule {
+	return r.Fail(syscall.EPIPE)
+}
+
+// Short sends no more than n bytes of what the device would otherwise
+// have answered, whether by another of this rule's actions or by
+// default.
+func (r *Rule) Short(n int) *Rule {
+	r.short = n
+	return r
+}
+
+// Device is a simulated device.  It is safe for concurrent use, though
+// requests are answered one at a time.
+type Device struct {
+	Descriptors []byte           // device descriptor and full configurations
+	Strings     map[uint8]string // string descriptors by index
+
+	// OnOut, if set, receives OUT data not claimed by a rule.
+	OnOut func(r *Request)
+
+	lock    sync.Mutex
+	rules   []*Rule
+	halted  map[uint8]bool
+	claimed map[uint32]bool
+	config  uint8
+	alt     map[uint8]uint8
+	closed  bool
+	log     []Request
+}
+
+// NewDevice makes a device from its descriptors, as
+// usb.ParseDescriptors takes them.
+func NewDevice(desc []byte) *Device {
+	return &Device{
+		Descriptors: desc,
+		Strings:     make(map[uint8]string),
+		halted:      make(map[uint8]bool),
+		claimed:     make(map[uint32]bool),
+		alt:         make(map[uint8]uint8),
+	}
+}
+
+// On adds a rule.  Rules are tried in the order they were added and the
+// first to apply answers the request.
+func (d *Device) On(m Match) *Rule {
+	r := &Rule{match: m, short: -1}
+	d.lock.Lock()
+	d.rules = append(d.rules, r)
+	d.lock.Unlock()
+	return r
+}
+
+// Requests returns the requests received so far.
+func (d *Device) Requests() []Request {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return append([]Request(nil), d.log...)
+}
+
+// handle runs a request through the rules, then the defaults
+func (d *Device) handle(q *Request, def Action) ([]byte, error) {
+	d.lock.Lock()
+	if d.closed {
+		d.lock.Unlock()
+		return nil, syscall.ENODEV
+	}
+	d.log = append(d.log, *q)
+	if d.halted[q.Endpoint] {
+		d.lock.Unlock()
+		return nil, syscall.EPIPE
+	}
+	var rule *Rule
+	for _, r := range d.rules {
+		if (r.times != 0 && r.used >= r.times) || !r.match(q) {
+			continue
+		}
+		r.seen++
+		if r.nth != 0 && r.seen != r.nth {
+			continue
+		}
+		if (r.action != nil || r.short >= 0) && rule == nil {
+			rule = r
+			r.used++
+			q.Seq = r.seen
+		}
+	}
+	d.lock.Unlock()
+
+	action := def
+	if rule != nil {
+		if rule.delay > 0 {
+			time.Sleep(rule.delay)
+		}
+		if rule.action != nil {
+			action = rule.action
+		}
+	}
+	data, e := action(q)
+	if rule != nil && rule.short >= 0 && len(data) > rule.short {
+		data = data[:rule.short]
+	}
+	if e == syscall.EPIPE && q.Endpoint&^usb.ENDPOINT_IN != 0 {
+		d.lock.Lock()
+		d.halted[q.Endpoint] = true
+		d.lock.Unlock()
+	}
+	return data, e
+}
+
+func (d *Device) ControlTransfer(reqtype uint8, request uint8, value uint16, index uint16, length uint16, timeout uint32, data []byte) (int, error) {
+	if int(length) > len(data) {
+		return 0, syscall.ENOSPC
+	}
+	q := &Request{
+		Endpoint: reqtype & usb.ENDPOINT_IN,
+		Setup: usb.ControlRequest{
+			RequestType: reqtype, Request: request, Value: value, Index: index, Length: length,
+		},
+		Length: int(length),
+	}
+	if !q.In() {
+		q.Data = append([]byte(nil), data[:length]...)
+	}
+	got, e := d.handle(q, d.standard)
+	if e != nil {
+		return 0, e
+	}
+	if !q.In() {
+		return int(length), nil
+	}
+	return copy(data[:length], got), nil
+}
+
+func (d *Device) BulkTransfer(endpoint uint32, length uint32, timeout uint32, data []byte) (int, []byte, error) {
+	if int(length) > len(data) {
+		return 0, nil, syscall.ENOSPC
+	}
+	q := &Request{Endpoint: uint8(endpoint), Length: int(length)}
+	if !q.In() {
+		q.Data = append([]byte(nil), data[:length]...)
+	}
+	got, e := d.handle(q, func(q *Request) ([]byte, error) {
+		if q.In() {
+			// nothing to say: the host times out
+			time.Sleep(time.Duration(timeout) * time.Millisecond)
+			return nil, syscall.ETIMEDOUT
+		}
+		if d.OnOut != nil {
+			d.OnOut(q)
+		}
+		return nil, nil
+	})
+	if e != nil {
+		return 0, nil, e
+	}
+	if !q.In() {
+		return int(length), nil, nil
+	}
+	n := copy(data[:length], got)
+	return n, append([]byte(nil), data[:n]...), nil
+}
+
+// standard answers chapter 9 requests the way a plain device would and
+// stalls anything else
+func (d *Device) standard(q *Request) ([]byte, error) {
+	s := q.Setup
+	if s.RequestType&^(usb.REQTYPE_IN|usb.REQTYPE_OTHER) != usb.REQTYPE_STANDARD {
+		if !q.In() && d.OnOut != nil {
+			d.OnOut(q)
+			return nil, nil
+		}
+		return nil, syscall.EPIPE
+	}
+	switch s.Request {
+	case usb.REQ_GET_DESCRIPTOR:
+		return d.descriptor(uint8(s.Value>>8), uint8(s.Value))
+	case usb.REQ_GET_STATUS:
+		return []byte{0, 0}, nil
+	case usb.REQ_GET_CONFIGURATION:
+		d.lock.Lock()
+		defer d.lock.Unlock()
+		return []byte{d.config}, nil
+	case usb.REQ_GET_INTERFACE:
+		d.lock.Lock()
+		defer d.lock.Unlock()
+		return []byte{d.alt[uint8(s.Index)]}, nil
+	case usb.REQ_SET_CONFIGURATION:
+		return nil, d.SetConfiguration(uint8(s.Value))
+	case usb.REQ_SET_INTERFACE:
+		return nil, d.SetInterface(uint8(s.Index), uint8(s.Value))
+	case usb.REQ_CLEAR_FEATURE, usb.REQ_SET_FEATURE:
+		if s.RequestType&usb.REQTYPE_OTHER == usb.REQTYPE_ENDPOINT && s.Value == 0 {
+			d.lock.Lock()
+			d.halted[uint8(s.Index)] = s.Request == usb.REQ_SET_FEATURE
+			d.lock.Unlock()
+		}
+		return nil, nil
+	}
+	return nil, syscall.EPIPE
+}
+
+func (d *Device) descriptor(kind uint8, index uint8) ([]byte, error) {
+	b := d.Descriptors
+	switch kind {
+	case usb.DT_DEVICE:
+		if len(b) < usb.DT_DEVICE_SIZE {
+			return nil, syscall.EPIPE
+		}
+		return b[:usb.DT_DEVICE_SIZE], nil
+	case usb.DT_CONFIG:
+		for n := 0; len(b) >= 4; n++ {
+			// skip to the next configuration by its total length
+			for len(b) >= 2 && b[0] >= 2 && b[1] != usb.DT_CONFIG {
+				b = b[b[0]:]
+			}
+			if len(b) < 4 {
+				break
+			}
+			total := min(int(b[2])|int(b[3])<<8, len(b))
+			if n == int(index) {
+				return b[:total], nil
+			}
+			b = b[total:]
+		}
+	case usb.DT_STRING:
+		if index == 0 {
+			return []byte{4, usb.DT_STRING, 0x09, 0x04}, nil
+		}
+		if s, ok := d.Strings[index]; ok {
+			out := []byte{0, usb.DT_STRING}
+			for _, c := range s {
+				out = append(out, byte(c), byte(c>>8))
+			}
+			out[0] = byte(len(out))
+			return out, nil
+		}
+	}
+	return nil, syscall.EPIPE
+}
+
+func (d *Device) ClaimInterface(n uint32) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.claimed[n] {
+		return syscall.EBUSY
+	}
+	d.claimed[n] = true
+	return nil
+}
+
+func (d *Device) ReleaseInterface(n uint32) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if !d.claimed[n] {
+		return syscall.EINVAL
+	}
+	delete(d.claimed, n)
+	return nil
+}
+
+func (d *Device) SetConfiguration(num uint8) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.config = num
+	clear(d.alt)
+	clear(d.halted)
+	return nil
+}
+
+func (d *Device) SetInterface(num uint8, alt uint8) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.alt[num] = alt
+	return nil
+}
+
+func (d *Device) ClearHalt(endpoint uint8) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	delete(d.halted, endpoint)
+	return nil
+}
+
+// Reset returns the device to its unconfigured state.  Rules stay.
+func (d *Device) Reset() error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.config = 0
+	clear(d.alt)
+	clear(d.halted)
+	clear(d.claimed)
+	return nil
+}
+
+// Close makes every later request fail with ENODEV, like an unplugged
+// device.
+func (d *Device) Close() {
+	d.lock.Lock()
+	d.closed = true
+	d.lock.Unlock()
+}
@@ -0,0 +1,198 @@
+package usbtest
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/richardnwinder/usb"
+)
+
+// Faults says how a Faulty device misbehaves.  The rates are the chance,
+// from 0 to 1, of each transfer being hit.
+type Faults struct {
+	Protocol   float64 // fail with usb.ErrProtocol, as a bad cable does, without issuing the transfer
+	Short      float64 // cut an IN transfer's data to a random shorter length
+	Delay      float64 // hold a transfer's completion back by up to MaxDelay
+	MaxDelay   time.Duration
+	Disconnect float64 // the device goes away: this and every later call fails with usb.ErrDisconnected
+	// Endpoints limits the faults to transfers on these endpoints, 0
+	// being control; nil means every endpoint.
+	Endpoints []uint8
+	// Seed seeds the choices, so a failing run can be repeated; 0 takes
+	// one from the clock.
+	Seed int64
+}
+
+// FaultCounts counts the faults a Faulty device injected.
+type FaultCounts struct {
+	Protocol, Short, Delay, Disconnect int
+}
+
+// Faulty wraps a real device, or any usb.Conn, and injects faults into
+// its synchronous transfers, for testing an application's recovery
+// against misbehaviour a healthy device on the bench won't show.
+type Faulty struct {
+	usb.Conn
+	f      Faults
+	lock   sync.Mutex
+	rand   *rand.Rand
+	gone   bool
+	counts FaultCounts
+}
+
+var _ usb.Conn = (*Faulty)(nil)
+
+func NewFaulty(c usb.Conn, f Faults) *Faulty {
+	seed := f.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &Faulty{Conn: c, f: f, rand: rand.New(rand.NewSource(seed))}
+}
+
+// Counts returns how many faults of each kind have been injected.
+func (d *Faulty) Counts() FaultCounts {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.counts
+}
+
+// Unplug makes the device go away now, as Faults.Disconnect does at random.
+func (d *Faulty) Unplug() {
+	d.lock.Lock()
+	d.gone = true
+	d.lock.Unlock()
+}
+
+// fault is the faults chosen for one transfer
+type fault struct {
+	err   error
+	short bool
+	delay time.Duration
+}
+
+func (d *Faulty) choose(ep uint8) fault {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.gone {
+		return fault{err: usb.ErrDisconnected}
+	}
+	if d.f.Endpoints != nil {
+		found := false
+		for _, e := range d.f.Endpoints {
+			found = found || e == ep || e == 0 && ep&^usb.ENDPOINT_IN == 0
+		}
+		if !found {
+			return fault{}
+		}
+	}
+	var f fault
+	switch {
+	case d.rand.Float64() < d.f.Disconnect:
+		d.gone = true
+		d.counts.Disconnect++
+		return fault{err: usb.ErrDisconnected}
+	case d.rand.Float64() < d.f.Protocol:
+		d.counts.Protocol++
+		return fault{err: usb.ErrProtocol}
+	}
+	if ep&usb.ENDPOINT_IN != 0 && d.rand.Float64() < d.f.Short {
+		d.counts.Short++
+		f.short = true
+	}
+	if d.f.MaxDelay > 0 && d.rand.Float64() < d.f.Delay {
+		d.counts.Delay++
+		f.delay = time.Duration(d.rand.Int63n(int64(d.f.MaxDelay)))
+	}
+	return f
+}
+
+// cut picks the length a short transfer of n bytes is cut to
+func (d *Faulty) cut(n int) int {
+	if n == 0 {
+		return 0
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.rand.Intn(n)
+}
+
+func (d *Faulty) ControlTransfer(reqtype uint8, request uint8, value uint16, index uint16, length uint16, timeout uint32, data []byte) (int, error) {
+	f := d.choose(reqtype & usb.ENDPOINT_IN)
+	if f.err != nil {
+		return 0, f.err
+	}
+	n, e := d.Conn.ControlTransfer(reqtype, request, value, index, length, timeout, data)
+	time.Sleep(f.delay)
+	if f.short && e == nil {
+		n = d.cut(n)
+	}
+	return n, e
+}
+
+func (d *Faulty) BulkTransfer(endpoint uint32, length uint32, timeout uint32, data []byte) (int, []byte, error) {
+	f := d.choose(uint8(endpoint))
+	if f.err != nil {
+		return 0, nil, f.err
+	}
+	n, got, e := d.Conn.BulkTransfer(endpoint, length, timeout, data)
+	time.Sleep(f.delay)
+	if f.short && e == nil {
+		n = d.cut(n)
+		got = got[:min(n, len(got))]
+	}
+	return n, got, e
+}
+
+// goneErr is the error for calls on a device that has gone away
+func (d *Faulty) goneErr() error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.gone {
+		return usb.ErrDisconnected
+	}
+	return nil
+}
+
+func (d *Faulty) ClaimInterface(n uint32) error {
+	if e := d.goneErr(); e != nil {
+		return e
+	}
+	return d.Conn.ClaimInterface(n)
+}
+
+func (d *Faulty) ReleaseInterface(n uint32) error {
+	if e := d.goneErr(); e != nil {
+		return e
+	}
+	return d.Conn.ReleaseInterface(n)
+}
+
+func (d *Faulty) SetConfiguration(num uint8) error {
+	if e := d.goneErr(); e != nil {
+		return e
+	}
+	return d.Conn.SetConfiguration(num)
+}
+
+func (d *Faulty) SetInterface(num uint8, alt uint8) error {
+	if e := d.goneErr(); e != nil {
+		return e
+	}
+	return d.Conn.SetInterface(num, alt)
+}
+
+func (d *Faulty) ClearHalt(endpoint uint8) error {
+	if e := d.goneErr(); e != nil {
+		return e
+	}
+	return d.Conn.ClearHalt(endpoint)
+}
+
+func (d *Faulty) Reset() error {
+	if e := d.goneErr(); e != nil {
+		return e
+	}
+	return d.Conn.Reset()
+}
@@ -0,0 +1,112 @@
+package usbtest_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/richardnwinder/usb"
+	"github.com/richardnwinder/usb/usbtest"
+)
+
+// a vendor-specific device with one interface and bulk endpoints 0x81
+// and 0x02
+const testDescriptors = `
+12 01 00 02 ff 00 00 40  34 12 78 56 00 01 00 00 00 01
+09 02 20 00 01 01 00 80 32
+09 04 00 00 02 ff 00 00 00
+07 05 81 02 00 02 00
+07 05 02 02 00 02 00
+`
+
+func newDevice(t *testing.T) *usbtest.Device {
+	f, e := usbtest.ParseHex("test", testDescriptors)
+	if e != nil {
+		t.Fatal(e)
+	}
+	d := f.Device()
+	d.On(usbtest.Bulk(0x81)).Reply(bytes.Repeat([]byte{0xa5}, 64))
+	return d
+}
+
+func TestFaultyProtocol(t *testing.T) {
+	d := newDevice(t)
+	f := usbtest.NewFaulty(d, usbtest.Faults{Protocol: 1, Endpoints: []uint8{0x81}, Seed: 1})
+	buf := make([]byte, 64)
+	for range 10 {
+		if _, _, e := f.BulkTransfer(0x81, 64, 100, buf); !errors.Is(e, usb.ErrProtocol) {
+			t.Fatalf("read: %v, want ErrProtocol", e)
+		}
+	}
+	if _, _, e := f.BulkTransfer(0x02, 64, 100, buf); e != nil {
+		t.Errorf("write to an endpoint left alone: %v", e)
+	}
+	if _, e := f.ControlTransfer(0x80, usb.REQ_GET_STATUS, 0, 0, 2, 100, buf); e != nil {
+		t.Errorf("control transfer with faults on 0x81 only: %v", e)
+	}
+	if c := f.Counts(); c != (usbtest.FaultCounts{Protocol: 10}) {
+		t.Errorf("counts %+v, want 10 protocol errors", c)
+	}
+	for _, q := range d.Requests() {
+		if q.Endpoint == 0x81 {
+			t.Fatal("a transfer failed with a protocol error reached the device")
+		}
+	}
+}
+
+func TestFaultyShort(t *testing.T) {
+	d := newDevice(t)
+	f := usbtest.NewFaulty(d, usbtest.Faults{Short: 1, Seed: 1})
+	buf := make([]byte, 64)
+	for range 20 {
+		n, got, e := f.BulkTransfer(0x81, 64, 100, buf)
+		if e != nil || n >= 64 || len(got) != n {
+			t.Fatalf("short read gave %d bytes, %d returned, %v", n, len(got), e)
+		}
+	}
+	// OUT transfers aren't cut
+	if n, _, e := f.BulkTransfer(0x02, 64, 100, buf); e != nil || n != 64 {
+		t.Errorf("write: %d bytes, %v", n, e)
+	}
+}
+
+// TestFaultySeed checks that the same seed injects the same faults
+func TestFaultySeed(t *testing.T) {
+	run := func() []int {
+		f := usbtest.NewFaulty(newDevice(t), usbtest.Faults{Protocol: 0.3, Short: 0.5, Seed: 42})
+		buf := make([]byte, 64)
+		var lens []int
+		for range 50 {
+			n, _, e := f.BulkTransfer(0x81, 64, 100, buf)
+			if e != nil {
+				n = -1
+			}
+			lens = append(lens, n)
+		}
+		return lens
+	}
+	a, b := run(), run()
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("transfer %d: %d bytes the first time, %d the second", i, a[i], b[i])
+		}
+	}
+}
+
+func TestFaultyUnplug(t *testing.T) {
+	f := usbtest.NewFaulty(newDevice(t), usbtest.Faults{})
+	buf := make([]byte, 64)
+	if _, _, e := f.BulkTransfer(0x81, 64, 100, buf); e != nil {
+		t.Fatal(e)
+	}
+	f.Unplug()
+	if _, _, e := f.BulkTransfer(0x81, 64, 100, buf); !errors.Is(e, usb.ErrDisconnected) {
+		t.Errorf("read after Unplug: %v", e)
+	}
+	if e := f.ClaimInterface(0); !errors.Is(e, usb.ErrDisconnected) {
+		t.Errorf("ClaimInterface after Unplug: %v", e)
+	}
+	if e := f.Reset(); !errors.Is(e, usb.ErrDisconnected) {
+		t.Errorf("Reset after Unplug: %v", e)
+	}
+}
@@ -0,0 +1,265 @@
+package usbtest
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/richardnwinder/usb"
+)
+
+// Fixture is a captured device: its descriptors in the layout sysfs
+// gives them, and the strings they refer to where the dump had them.
+type Fixture struct {
+	Name        string
+	Descriptors []byte
+	Strings     map[uint8]string
+}
+
+// Info parses the descriptors.
+func (f *Fixture) Info() (*usb.DeviceInfo, error) {
+	return usb.ParseDescriptors(f.Descriptors)
+}
+
+// Device makes a simulated device that answers with the fixture.
+func (f *Fixture) Device() *Device {
+	d := NewDevice(f.Descriptors)
+	for i, s := range f.Strings {
+		d.Strings[i] = s
+	}
+	return d
+}
+
+// ParseHex reads descriptors written as hex, with any spacing and with
+// '#' starting a comment that runs to the end of the line.
+func ParseHex(name string, text string) (*Fixture, error) {
+	var digits strings.Builder
+	for _, line := range strings.Split(text, "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		for _, w := range strings.Fields(line) {
+			digits.WriteString(strings.TrimPrefix(strings.TrimSuffix(w, ","), "0x"))
+		}
+	}
+	b, e := hex.DecodeString(digits.String())
+	if e != nil {
+		return nil, fmt.Errorf("usbtest: %s: %v", name, e)
+	}
+	return &Fixture{Name: name, Descriptors: b, Strings: make(map[uint8]string)}, nil
+}
+
+// lsusb field names start with a lower case prefix giving their type
+var fieldSizes = []struct {
+	prefix string
+	size   int
+}{
+	{"bcd", 2}, {"bma", 1}, {"bm", 1}, {"ba", 1}, {"b", 1},
+	{"dw", 4}, {"wa", 2}, {"w", 2}, {"id", 2}, {"i", 1},
+}
+
+func fieldSize(name string) int {
+	for _, f := range fieldSizes {
+		rest, ok := strings.CutPrefix(name, f.prefix)
+		if ok && rest != "" && unicode.IsUpper(rune(rest[0])) {
+			return f.size
+		}
+	}
+	return 0
+}
+
+// lsusb prints BCD as "2.00", and wider fields in hex or decimal
+func fieldValue(name string, s string) (uint64, bool) {
+	if strings.HasPrefix(name, "bcd") {
+		hi, lo, _ := strings.Cut(s, ".")
+		v, e := strconv.ParseUint(hi+lo, 16, 16)
+		return v, e == nil
+	}
+	v, e := strconv.ParseUint(s, 0, 32)
+	return v, e == nil
+}
+
+// the CDC functional descriptors lsusb decodes, by subtype
+var cdcHeadings = map[string]uint8{
+	"CDC Header:":          0x00,
+	"CDC Call Management:": 0x01,
+	"CDC ACM:":             0x02,
+	"CDC Union:":           0x06,
+	"CDC Ethernet:":        0x0f,
+	"CDC NCM:":             0x1a,
+	"CDC MBIM:":            0x1b,
+	"CDC MBIM Extended:":   0x1c,
+}
+
+// ParseLsusb rebuilds the descriptors of each device in the output of
+// lsusb -v.  Every field lsusb prints is put back at its place in its
+// descriptor, which is then padded or cut to bLength, so even class
+// descriptors lsusb only partly decodes keep the right shape.  Their
+// contents may not be exact; where that matters, use a hex dump of
+// the device's descriptors file instead.
+func ParseLsusb(text string) ([]*Fixture, error) {
+	var list []*Fixture
+	var f *Fixture
+	var desc []byte // the descriptor being rebuilt
+	var usb3 bool
+	flush := func() {
+		if f != nil && len(desc) > 0 {
+			n := int(desc[0])
+			for len(desc) < n {
+				desc = append(desc, 0)
+			}
+			if n == 0 {
+				n = len(desc)
+				desc[0] = byte(n)
+			}
+			f.Descriptors = append(f.Descriptors, desc[:n]...)
+		}
+		desc = nil
+	}
+	s := bufio.NewScanner(strings.NewReader(text))
+	for s.Scan() {
+		line := s.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			continue
+		}
+		if line[0] != ' ' {
+			// top-level sections: only the device descriptor and the
+			// configurations inside it are in sysfs
+			flush()
+			if strings.HasPrefix(trimmed, "Bus ") {
+				f = nil
+			} else if trimmed == "Device Descriptor:" {
+				f = &Fixture{Strings: make(map[uint8]string)}
+				list = append(list, f)
+			} else {
+				f = nil
+			}
+			continue
+		}
+		if f == nil {
+			continue
+		}
+		if raw, ok := strings.CutPrefix(trimmed, "** UNRECOGNIZED:"); ok {
+			flush()
+			b, e := hex.DecodeString(strings.Join(strings.Fields(raw), ""))
+			if e != nil {
+				return nil, fmt.Errorf("usbtest: bad lsusb line %q", line)
+			}
+			f.Descriptors = append(f.Descriptors, b...)
+			continue
+		}
+		if sub, ok := cdcHeadings[trimmed]; ok {
+			// lsusb leaves out the length and type of these
+			flush()
+			desc = []byte{0, 0x24, sub} // CS_INTERFACE
+			continue
+		}
+		// arrays print as "baSourceID( 0)"
+		if i := strings.IndexByte(trimmed, '('); i > 0 {
+			if j := strings.IndexByte(trimmed, ')'); j > i && !strings.ContainsRune(trimmed[:i], ' ') {
+				trimmed = trimmed[:i] + " " + trimmed[j+1:]
+			}
+		}
+		fields := strings.Fields(trimmed)
+		if len(fields) < 2 {
+			continue
+		}
+		name := fields[0]
+		if name == "bLength" {
+			flush()
+		}
+		if name == "MaxPower" {
+			mA, e := strconv.Atoi(strings.TrimSuffix(fields[1], "mA"))
+			if e == nil && usb3 {
+				desc = append(desc, byte(mA/8))
+			} else if e == nil {
+				desc = append(desc, byte(mA/2))
+			}
+			continue
+		}
+		size := fieldSize(name)
+		if size == 0 || (desc == nil && name != "bLength") {
+			continue
+		}
+		v, ok := fieldValue(name, fields[1])
+		if !ok {
+			continue
+		}
+		// bitmaps come in any width, which shows in their digits
+		if x, ok := strings.CutPrefix(fields[1], "0x"); ok && strings.HasPrefix(name, "bm") {
+			size = (len(x) + 1) / 2
+		}
+		for i := 0; i < size; i++ {
+			desc = append(desc, byte(v>>(8*i)))
+		}
+		if name == "bcdUSB" && len(f.Descriptors) == 0 {
+			usb3 = v >= 0x300
+		}
+		if name == "idVendor" || name == "idProduct" {
+			if f.Name != "" {
+				f.Name += ":"
+			}
+			f.Name += fmt.Sprintf("%04x", v)
+		}
+		if name[0] == 'i' && size == 1 && v != 0 && len(fields) > 2 && fields[2] != "(??)" {
+			f.Strings[uint8(v)] = strings.Join(fields[2:], " ")
+		}
+	}
+	flush()
+	if len(list) == 0 {
+		return nil, fmt.Errorf("usbtest: no devices in lsusb output")
+	}
+	return list, nil
+}
+
+// LoadFixture reads a fixture file: lsusb -v output, a hex dump, or a
+// binary copy of a device's sysfs descriptors file.  For lsusb output
+// holding several devices, the first is used.
+func LoadFixture(path string) (*Fixture, error) {
+	b, e := os.ReadFile(path)
+	if e != nil {
+		return nil, e
+	}
+	name := filepath.Base(path)
+	if len(b) >= 2 && b[0] == usb.DT_DEVICE_SIZE && b[1] == usb.DT_DEVICE {
+		return &Fixture{Name: name, Descriptors: b, Strings: make(map[uint8]string)}, nil
+	}
+	if strings.Contains(string(b), "Device Descriptor:") {
+		list, e := ParseLsusb(string(b))
+		if e != nil {
+			return nil, e
+		}
+		list[0].Name = name
+		return list[0], nil
+	}
+	return ParseHex(name, string(b))
+}
+
+// LoadFixtures reads every file in dir, in name order.
+func LoadFixtures(dir string) ([]*Fixture, error) {
+	fi, e := os.ReadDir(dir)
+	if e != nil {
+		return nil, e
+	}
+	var names []string
+	for _, f := range fi {
+		if !f.IsDir() {
+			names = append(names, f.Name())
+		}
+	}
+	sort.Strings(names)
+	var list []*Fixture
+	for _, n := range names {
+		f, e := LoadFixture(filepath.Join(dir, n))
+		if e != nil {
+			return nil, e
+		}
+		list = append(list, f)
+	}
+	return list, nil
+}
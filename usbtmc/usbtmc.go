@@ -0,0 +1,394 @@
+// Package usbtmc implements the USB Test and Measurement Class and its
+// USB488 subclass, which carry IEEE 488.2 messages to instruments.
+package usbtmc
+
+import (
+	"encoding/binary"
+	"errors"
+	"syscall"
+	"time"
+
+	"github.com/richardnwinder/usb"
+)
+
+const (
+	CLASS_APP_SPECIFIC = 0xfe
+	SUBCLASS_USBTMC    = 0x03
+	PROTOCOL_USB488    = 0x01
+
+	// bulk message IDs
+	DEV_DEP_MSG_OUT         = 1
+	REQUEST_DEV_DEP_MSG_IN  = 2
+	DEV_DEP_MSG_IN          = 2
+	VENDOR_SPECIFIC_OUT     = 126
+	REQUEST_VENDOR_SPECIFIC = 127
+
+	// class requests
+	INITIATE_ABORT_BULK_OUT     = 1
+	CHECK_ABORT_BULK_OUT_STATUS = 2
+	INITIATE_ABORT_BULK_IN      = 3
+	CHECK_ABORT_BULK_IN_STATUS  = 4
+	INITIATE_CLEAR              = 5
+	CHECK_CLEAR_STATUS          = 6
+	GET_CAPABILITIES            = 7
+	INDICATOR_PULSE             = 64
+	READ_STATUS_BYTE            = 128
+	REN_CONTROL                 = 160
+	GO_TO_LOCAL                 = 161
+	LOCAL_LOCKOUT               = 162
+
+	// USBTMC_status
+	STATUS_SUCCESS                  = 0x01
+	STATUS_PENDING                  = 0x02
+	STATUS_FAILED                   = 0x80
+	STATUS_TRANSFER_NOT_IN_PROGRESS = 0x81
+	STATUS_SPLIT_NOT_IN_PROGRESS    = 0x82
+	STATUS_SPLIT_IN_PROGRESS        = 0x83
+
+	headerSize = 12
+	chunkSize  = 64 * 1024
+)
+
+var ErrStatus = errors.New("usbtmc: request failed")
+
+// Device is a claimed USBTMC interface.  It is not safe for concurrent use.
+type Device struct {
+	dev      *usb.Device
+	ifc      uint8
+	in, out  uint8
+	inSize   int
+	USB488   bool
+	tag      uint8
+	stbTag   uint8
+	Timeout  time.Duration
+	TermChar int // terminate reads at this byte if the device supports it, -1 for none
+	// SRQ receives the status byte each time the instrument requests
+	// service.  It is nil without an interrupt endpoint.  Requests that
+	// arrive while the channel is full are dropped.
+	SRQ <-chan uint8
+
+	poll *usb.PollReader
+	srq  chan uint8
+	stb  chan [2]uint8
+}
+
+// Open claims the USBTMC interface ii and, for USB488 instruments with an
+// interrupt endpoint, starts listening for service requests.
+func Open(dev *usb.Device, ii *usb.InterfaceInfo) (*Device, error) {
+	if ii.InterfaceClass != CLASS_APP_SPECIFIC || ii.InterfaceSubClass != SUBCLASS_USBTMC {
+		return nil, syscall.EINVAL
+	}
+	d := &Device{dev: dev, ifc: ii.InterfaceNumber, USB488: ii.InterfaceProtocol == PROTOCOL_USB488,
+		Timeout: 5 * time.Second, TermChar: -1, stbTag: 1}
+	var intr *usb.EndpointDescriptor
+	for i, ep := range ii.Endpoint {
+		switch ep.Attributes & usb.ENDPOINT_XFER_MASK {
+		case usb.ENDPOINT_XFER_BULK:
+			if ep.EndpointAddress&usb.ENDPOINT_IN != 0 {
+				d.in = ep.EndpointAddress
+				d.inSize = int(ep.MaxPacketSize & 0x7ff)
+			} else {
+				d.out = ep.EndpointAddress
+			}
+		case usb.ENDPOINT_XFER_INT:
+			intr = &ii.Endpoint[i]
+		}
+	}
+	if d.in == 0 || d.out == 0 || d.inSize == 0 {
+		return nil, syscall.ENODEV
+	}
+	e := dev.ClaimInterface(uint32(d.ifc))
+	if errors.Is(e, syscall.EBUSY) {
+		dev.DisconnectDriver(d.ifc)
+		e = dev.ClaimInterface(uint32(d.ifc))
+	}
+	if e != nil {
+		return nil, e
+	}
+	if intr != nil && d.USB488 {
+		if d.poll, e = dev.NewPollReader(*intr, usb.PollOptions{}); e != nil {
+			dev.ReleaseInterface(uint32(d.ifc))
+			return nil, e
+		}
+		d.srq = make(chan uint8, 8)
+		d.stb = make(chan [2]uint8, 1)
+		d.SRQ = d.srq
+		go d.notifications()
+	}
+	return d, nil
+}
+
+// notifications sorts interrupt IN messages into service requests and
+// replies to READ_STATUS_BYTE
+func (d *Device) notifications() {
+	defer close(d.srq)
+	for n := range d.poll.C {
+		if len(n) < 2 || n[0]&0x80 == 0 {
+			continue
+		}
+		if n[0] == 0x81 {
+			select {
+			case d.srq <- n[1]:
+			default:
+			}
+			continue
+		}
+		select {
+		case d.stb <- [2]uint8{n[0] & 0x7f, n[1]}:
+		default:
+		}
+	}
+}
+
+func (d *Device) Close() error {
+	if d.poll != nil {
+		d.poll.Close()
+	}
+	return d.dev.ReleaseInterface(uint32(d.ifc))
+}
+
+func ms(t time.Duration) uint32 {
+	return uint32(max(t.Milliseconds(), 1))
+}
+
+func (d *Device) nextTag() uint8 {
+	// bTag runs 1..255
+	d.tag++
+	if d.tag == 0 {
+		d.tag = 1
+	}
+	return d.tag
+}
+
+func (d *Device) header(id uint8, size int, attr uint8, term uint8) []byte {
+	tag := d.nextTag()
+	h := make([]byte, headerSize, headerSize+size+3)
+	h[0], h[1], h[2] = id, tag, ^tag
+	binary.LittleEndian.PutUint32(h[4:], uint32(size))
+	h[8], h[9] = attr, term
+	return h
+}
+
+func (d *Device) bulk(ep uint8, buf []byte, timeout time.Duration) (int, error) {
+	n, _, e := d.dev.BulkTransfer(uint32(ep), uint32(len(buf)), ms(timeout), buf)
+	return n, e
+}
+
+// Write sends msg as one device dependent message, split into transfers of
+// at most 64k, the last one flagged end of message.
+func (d *Device) Write(msg []byte) error {
+	return d.WriteTimeout(msg, d.Timeout)
+}
+
+func (d *Device) WriteTimeout(msg []byte, timeout time.Duration) error {
+	for {
+		n := min(len(msg), chunkSize)
+		var eom uint8
+		if n == len(msg) {
+			eom = 1
+		}
+		b := append(d.header(DEV_DEP_MSG_OUT, n, eom, 0), msg[:n]...)
+		for len(b)%4 != 0 {
+			b = append(b, 0)
+		}
+		if _, e := d.bulk(d.out, b, timeout); e != nil {
+			if errors.Is(e, syscall.ETIMEDOUT) {
+				d.abort(INITIATE_ABORT_BULK_OUT, CHECK_ABORT_BULK_OUT_STATUS, d.out)
+			}
+			return e
+		}
+		msg = msg[n:]
+		if eom != 0 {
+			return nil
+		}
+	}
+}
+
+// Read returns the next complete response message.
+func (d *Device) Read() ([]byte, error) {
+	return d.ReadTimeout(d.Timeout)
+}
+
+func (d *Device) ReadTimeout(timeout time.Duration) ([]byte, error) {
+	var msg []byte
+	for {
+		var attr, term uint8
+		if d.TermChar >= 0 {
+			attr, term = 0x02, uint8(d.TermChar)
+		}
+		req := d.header(REQUEST_DEV_DEP_MSG_IN, chunkSize, attr, term)
+		if _, e := d.bulk(d.out, req, timeout); e != nil {
+			return nil, e
+		}
+		tag := req[1]
+		// read the first packet to learn the size, then exactly the rest,
+		// so that a response filling whole packets without a zero length
+		// packet after it doesn't leave us waiting
+		buf := make([]byte, headerSize+chunkSize+d.inSize)
+		n, e := d.bulk(d.in, buf[:d.inSize], timeout)
+		if e == nil && (n < headerSize || buf[0] != DEV_DEP_MSG_IN || buf[1] != tag) {
+			e = syscall.EPROTO
+		}
+		if e != nil {
+			if errors.Is(e, syscall.ETIMEDOUT) || errors.Is(e, syscall.EPROTO) {
+				d.abort(INITIATE_ABORT_BULK_IN, CHECK_ABORT_BULK_IN_STATUS, d.in)
+			}
+			return nil, e
+		}
+		size := int(binary.LittleEndian.Uint32(buf[4:]))
+		eom := buf[8]&1 != 0
+		if size > chunkSize {
+			return nil, syscall.EPROTO
+		}
+		for got := n; got < headerSize+size; {
+			want := (headerSize + size - got + d.inSize - 1) / d.inSize * d.inSize
+			m, e := d.bulk(d.in, buf[got:got+want], timeout)
+			if e != nil {
+				if errors.Is(e, syscall.ETIMEDOUT) {
+					d.abort(INITIATE_ABORT_BULK_IN, CHECK_ABORT_BULK_IN_STATUS, d.in)
+				}
+				return nil, e
+			}
+			got += m
+			if m < want {
+				break
+			}
+		}
+		msg = append(msg, buf[headerSize:headerSize+size]...)
+		if eom {
+			return msg, nil
+		}
+	}
+}
+
+const (
+	toInterface = 0xa1
+	toEndpoint  = 0xa2
+)
+
+// control issues a class request that answers with a USBTMC_status byte
+// first
+func (d *Device) control(reqtype uint8, req uint8, value uint16, index uint16, buf []byte) error {
+	n, e := d.dev.ControlTransfer(reqtype, req, value, index, uint16(len(buf)), ms(d.Timeout), buf)
+	if e != nil {
+		return e
+	}
+	if n < 1 {
+		return syscall.EPROTO
+	}
+	if buf[0] != STATUS_SUCCESS && buf[0] != STATUS_PENDING {
+		return ErrStatus
+	}
+	return nil
+}
+
+// abort cancels the transfer in progress on ep after a timeout
+func (d *Device) abort(initiate uint8, check uint8, ep uint8) error {
+	b := make([]byte, 8)
+	if e := d.control(toEndpoint, initiate, uint16(d.tag), uint16(ep), b[:2]); e != nil {
+		return e
+	}
+	for i := 0; i < 50; i++ {
+		n, e := d.dev.ControlTransfer(toEndpoint, check, 0, uint16(ep), 8, ms(d.Timeout), b)
+		if e != nil {
+			return e
+		}
+		if n < 1 || b[0] != STATUS_PENDING {
+			break
+		}
+		// drain whatever the device still has queued
+		if initiate == INITIATE_ABORT_BULK_IN {
+			d.bulk(d.in, make([]byte, chunkSize), 100*time.Millisecond)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return d.dev.ClearHalt(ep)
+}
+
+// Clear performs the device clear sequence, discarding any pending input
+// and output on the instrument.
+func (d *Device) Clear() error {
+	b := make([]byte, 2)
+	if e := d.control(toInterface, INITIATE_CLEAR, 0, uint16(d.ifc), b[:1]); e != nil {
+		return e
+	}
+	for i := 0; i < 100; i++ {
+		if e := d.control(toInterface, CHECK_CLEAR_STATUS, 0, uint16(d.ifc), b); e != nil {
+			return e
+		}
+		if b[0] != STATUS_PENDING {
+			break
+		}
+		if b[1]&1 != 0 {
+			d.bulk(d.in, make([]byte, chunkSize), 100*time.Millisecond)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return d.dev.ClearHalt(d.out)
+}
+
+// Capabilities returns the GET_CAPABILITIES response, 24 bytes.
+func (d *Device) Capabilities() ([]byte, error) {
+	b := make([]byte, 24)
+	if e := d.control(toInterface, GET_CAPABILITIES, 0, uint16(d.ifc), b); e != nil {
+		return nil, e
+	}
+	return b, nil
+}
+
+// ReadStatusByte returns the IEEE 488.2 status byte.  Instruments with an
+// interrupt endpoint send it there rather than in the control response.
+func (d *Device) ReadStatusByte() (uint8, error) {
+	if !d.USB488 {
+		return 0, syscall.EOPNOTSUPP
+	}
+	// bTag for READ_STATUS_BYTE runs 2..127
+	d.stbTag++
+	if d.stbTag < 2 || d.stbTag > 127 {
+		d.stbTag = 2
+	}
+	b := make([]byte, 3)
+	if e := d.control(toInterface, READ_STATUS_BYTE, uint16(d.stbTag), uint16(d.ifc), b); e != nil {
+		return 0, e
+	}
+	if d.poll == nil {
+		return b[2], nil
+	}
+	t := time.NewTimer(d.Timeout)
+	defer t.Stop()
+	for {
+		select {
+		case r := <-d.stb:
+			if r[0] == d.stbTag {
+				return r[1], nil
+			}
+		case <-t.C:
+			return 0, syscall.ETIMEDOUT
+		}
+	}
+}
+
+func (d *Device) usb488(req uint8, value uint16) error {
+	if !d.USB488 {
+		return syscall.EOPNOTSUPP
+	}
+	b := make([]byte, 1)
+	return d.control(toInterface, req, value, uint16(d.ifc), b)
+}
+
+// Remote asserts or releases remote enable.
+func (d *Device) Remote(enable bool) error {
+	var v uint16
+	if enable {
+		v = 1
+	}
+	return d.usb488(REN_CONTROL, v)
+}
+
+func (d *Device) GoToLocal() error {
+	return d.usb488(GO_TO_LOCAL, 0)
+}
+
+func (d *Device) LocalLockout() error {
+	return d.usb488(LOCAL_LOCKOUT, 0)
+}
@@ -0,0 +1,52 @@
+package uvc_test
+
+import (
+	"testing"
+
+	"github.com/richardnwinder/usb/uvc"
+)
+
+// stream lays payloads out for FuzzDemux, each behind a length byte
+func stream(payloads ...[]byte) []byte {
+	var b []byte
+	for _, p := range payloads {
+		b = append(append(b, byte(len(p))), p...)
+	}
+	return b
+}
+
+// FuzzDemux feeds payload reassembly.  Bit 0 of mode picks MJPEG and bit
+// 1 an uncompressed stream with a small frame size from the rest; data is
+// the payloads, each a length byte and that many bytes, as the device
+// sends them.
+func FuzzDemux(f *testing.F) {
+	// an MJPEG frame in two payloads, then the start of the next
+	f.Add(byte(1), stream(
+		payload(uvc.HEADER_EOH, 0xff, 0xd8, 1, 2),
+		payload(uvc.HEADER_EOH|uvc.HEADER_EOF, 3, 0xff, 0xd9),
+		payload(uvc.HEADER_EOH|uvc.HEADER_FID, 0xff, 0xd8)))
+	// 8 byte uncompressed frames told apart by the FID toggle, one with
+	// an error
+	f.Add(byte(2|8<<2), stream(
+		payload(uvc.HEADER_EOH, 1, 2, 3, 4, 5, 6, 7, 8),
+		payload(uvc.HEADER_EOH|uvc.HEADER_FID|uvc.HEADER_ERR, 1, 2, 3),
+		payload(uvc.HEADER_EOH, 1, 2, 3, 4, 5, 6, 7, 8)))
+	// a header claiming more than the payload holds
+	f.Add(byte(0), stream([]byte{12, uvc.HEADER_EOH | uvc.HEADER_PTS | uvc.HEADER_SCR, 1}))
+	f.Fuzz(func(t *testing.T, mode byte, data []byte) {
+		d := &uvc.Demux{MJPEG: mode&1 != 0, MaxSize: 4096}
+		if mode&2 != 0 {
+			d.FrameSize = int(mode >> 2)
+		}
+		for len(data) > 0 {
+			n := min(int(data[0]), len(data)-1)
+			if fr := d.Packet(data[1 : 1+n]); fr != nil && len(fr.Data) > d.MaxSize {
+				t.Fatalf("frame of %d bytes past MaxSize %d", len(fr.Data), d.MaxSize)
+			}
+			data = data[1+n:]
+		}
+		if fr := d.Flush(); fr != nil && len(fr.Data) > d.MaxSize {
+			t.Fatalf("flushed frame of %d bytes past MaxSize %d", len(fr.Data), d.MaxSize)
+		}
+	})
+}
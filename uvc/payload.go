@@ -0,0 +1,220 @@
+package uvc
+
+import (
+	"time"
+
+	"github.com/richardnwinder/usb"
+)
+
+// payload header bmHeaderInfo bits
+const (
+	HEADER_FID = 0x01 // frame ID, toggling with each new frame
+	HEADER_EOF = 0x02 // the payload ends a frame
+	HEADER_PTS = 0x04
+	HEADER_SCR = 0x08
+	HEADER_RES = 0x10
+	HEADER_STI = 0x20 // still image
+	HEADER_ERR = 0x40
+	HEADER_EOH = 0x80
+)
+
+// VideoFrame is a reassembled frame.
+type VideoFrame struct {
+	Data []byte
+	// Time is when its first payload arrived.
+	Time time.Time
+	// PTS is the device clock when the frame was captured, and SCR the
+	// device clock (STC) and bus frame number (SOF) when the last payload
+	// carrying one was sent; each is only valid if its Has flag is set.
+	PTS    uint32
+	HasPTS bool
+	STC    uint32
+	SOF    uint16
+	HasSCR bool
+	Still  bool
+	// Error is set when a payload reported an error, or the frame is
+	// not the size its format says it must be.
+	Error bool
+}
+
+// MAX_FRAME_SIZE is the most NewDemux lets a frame grow to, whatever
+// dwMaxVideoFrameSize says: a 4K frame at 32 bits a pixel fits.
+const MAX_FRAME_SIZE = 64 << 20
+
+// Demux reassembles frames from the payloads of a video stream: one per
+// isochronous packet, or one per bulk transfer.
+type Demux struct {
+	// FrameSize is the exact size of an uncompressed frame, 0 for
+	// compressed formats, whose frames end at EOF or the FID toggle.
+	FrameSize int
+	// MaxSize caps a frame, from dwMaxVideoFrameSize; payloads past it
+	// are dropped and the frame marked in error.  Frame buffers grow as
+	// payloads arrive rather than being allocated at this size.
+	MaxSize int
+	// ClockFrequency is the device clock PTS and SCR count in, in Hz.
+	ClockFrequency uint32
+	MJPEG          bool
+
+	cur  *VideoFrame
+	fid  byte
+	done bool // cur already delivered by size, waiting for the toggle
+	lost bool // a payload was lost before the next frame started
+	last int  // the size of the last frame, to start the next one's buffer
+}
+
+// NewDemux sets up a Demux for the negotiated stream.  The sizes the
+// device gives are held to MAX_FRAME_SIZE.
+func NewDemux(n *Negotiated) *Demux {
+	limit := n.Probe.MaxVideoFrameSize
+	if limit == 0 {
+		limit = n.Frame.MaxFrameSize
+	}
+	d := &Demux{
+		MaxSize:        int(min(limit, MAX_FRAME_SIZE)),
+		ClockFrequency: n.Probe.ClockFrequency,
+		MJPEG:          n.Format.Subtype == VS_FORMAT_MJPEG,
+	}
+	if n.Format.Subtype == VS_FORMAT_UNCOMPRESSED {
+		size := uint64(n.Frame.Width) * uint64(n.Frame.Height) * uint64(n.Format.BitsPerPixel) / 8
+		d.FrameSize = int(min(size, MAX_FRAME_SIZE))
+	}
+	return d
+}
+
+// Packet takes one payload, header included, and returns the frame it
+// completes, if any.  Empty payloads, which isochronous endpoints send
+// when they have nothing, are ignored, as are payloads with a bad
+// header.  The frame's Data is its own and may be kept.
+func (d *Demux) Packet(p []byte) *VideoFrame {
+	if len(p) < 2 || int(p[0]) < 2 || int(p[0]) > len(p) {
+		return nil
+	}
+	hlen, info := int(p[0]), p[1]
+	var out *VideoFrame
+	if info&HEADER_FID != d.fid {
+		d.done = false
+		if d.cur != nil {
+			// a new frame has started without the old one seeing EOF
+			out = d.finish()
+		}
+	}
+	d.fid = info & HEADER_FID
+	if d.done {
+		// the rest of a frame already delivered at its full size
+		if info&HEADER_EOF != 0 {
+			d.done = false
+		}
+		return out
+	}
+	if d.cur == nil {
+		d.cur = &VideoFrame{Time: time.Now(), Data: make([]byte, 0, max(d.FrameSize, d.last)), Error: d.lost}
+		d.lost = false
+	}
+	f := d.cur
+	h := p[2:hlen]
+	if info&HEADER_PTS != 0 && len(h) >= 4 {
+		f.PTS, f.HasPTS = le32(h), true
+		h = h[4:]
+	}
+	if info&HEADER_SCR != 0 && len(h) >= 6 {
+		f.STC, f.SOF, f.HasSCR = le32(h), le16(h[4:])&0x7ff, true
+	}
+	f.Still = f.Still || info&HEADER_STI != 0
+	f.Error = f.Error || info&HEADER_ERR != 0
+	data := p[hlen:]
+	if d.MaxSize > 0 && len(f.Data)+len(data) > d.MaxSize {
+		data = data[:max(d.MaxSize-len(f.Data), 0)]
+		f.Error = true
+	}
+	f.Data = append(f.Data, data...)
+	switch {
+	case info&HEADER_EOF != 0:
+		frame := d.finish()
+		if out == nil {
+			out = frame
+		}
+	case d.FrameSize > 0 && len(f.Data) >= d.FrameSize:
+		// some devices never set EOF on uncompressed streams
+		frame := d.finish()
+		d.done = true
+		if out == nil {
+			out = frame
+		}
+	}
+	return out
+}
+
+// Transfer takes a completed transfer from the streaming endpoint and
+// returns the frames it completes, in order.  Each packet of an
+// isochronous transfer is a payload, and one that failed loses its part
+// of a frame, which is then marked in error; a bulk transfer is one
+// payload.
+func (d *Demux) Transfer(xfer *usb.Transfer) []*VideoFrame {
+	var frames []*VideoFrame
+	if xfer.Type != usb.URB_TYPE_ISO {
+		if xfer.Status != 0 {
+			d.lose()
+		} else if f := d.Packet(xfer.Data[:xfer.Length]); f != nil {
+			frames = append(frames, f)
+		}
+		return frames
+	}
+	at := 0
+	for i := range xfer.Packets {
+		p := &xfer.Packets[i]
+		data := xfer.Data[at : at+int(p.ActualLength)]
+		at += int(p.Length)
+		if p.Status != 0 {
+			d.lose()
+		} else if f := d.Packet(data); f != nil {
+			frames = append(frames, f)
+		}
+	}
+	return frames
+}
+
+// lose marks the frame a lost payload belonged to in error: the one
+// being assembled, or else the next, unless the last was delivered
+// whole and only its tail is missing
+func (d *Demux) lose() {
+	switch {
+	case d.cur != nil:
+		d.cur.Error = true
+	case !d.done:
+		d.lost = true
+	}
+}
+
+// Flush returns the frame being assembled, marked in error, as at the
+// end of a stream.
+func (d *Demux) Flush() *VideoFrame {
+	d.done = false
+	if d.cur == nil {
+		return nil
+	}
+	f := d.finish()
+	f.Error = true
+	return f
+}
+
+func (d *Demux) finish() *VideoFrame {
+	f := d.cur
+	d.cur = nil
+	d.last = len(f.Data)
+	if d.FrameSize > 0 && len(f.Data) != d.FrameSize {
+		f.Error = true
+	}
+	// a JPEG starts with SOI; anything else lost its beginning
+	if d.MJPEG && (len(f.Data) < 2 || f.Data[0] != 0xff || f.Data[1] != 0xd8) {
+		f.Error = true
+	}
+	return f
+}
+
+// Timestamp converts a PTS or STC value into time on the device clock.
+func (d *Demux) Timestamp(v uint32) time.Duration {
+	if d.ClockFrequency == 0 {
+		return 0
+	}
+	return time.Duration(uint64(v) * uint64(time.Second) / uint64(d.ClockFrequency))
+}
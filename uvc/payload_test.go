@@ -0,0 +1,146 @@
+package uvc_test
+
+import (
+	"bytes"
+	"syscall"
+	"testing"
+
+	"github.com/richardnwinder/usb"
+	"github.com/richardnwinder/usb/uvc"
+)
+
+// isoTransfer lays payloads out as a completed isochronous IN transfer
+// holds them, each packet at a stride of size; a nil payload is a packet
+// that failed
+func isoTransfer(size int, payloads ...[]byte) *usb.Transfer {
+	xfer := &usb.Transfer{Type: usb.URB_TYPE_ISO, Endpoint: 0x81, Data: make([]byte, size*len(payloads))}
+	for i, p := range payloads {
+		pkt := usb.IsoPacket{Length: uint32(size), ActualLength: uint32(len(p))}
+		if p == nil {
+			pkt.Status = -int32(syscall.EPROTO)
+		}
+		copy(xfer.Data[i*size:], p)
+		xfer.Packets = append(xfer.Packets, pkt)
+		xfer.Length += int32(len(p))
+	}
+	return xfer
+}
+
+func payload(info byte, data ...byte) []byte {
+	return append([]byte{2, info}, data...)
+}
+
+// TestTransferISO reassembles an MJPEG frame spread over the packets of
+// two transfers, with the empty packets a camera sends between frames
+func TestTransferISO(t *testing.T) {
+	d := &uvc.Demux{MJPEG: true}
+	frames := d.Transfer(isoTransfer(16,
+		payload(0, 0xff, 0xd8, 1, 2),
+		[]byte{},
+		payload(0, 3, 4, 5),
+	))
+	if len(frames) != 0 {
+		t.Fatalf("%d frames before EOF", len(frames))
+	}
+	frames = d.Transfer(isoTransfer(16,
+		payload(uvc.HEADER_EOF, 0xff, 0xd9),
+		[]byte{},
+		payload(uvc.HEADER_FID, 0xff, 0xd8, 6),
+		payload(uvc.HEADER_FID|uvc.HEADER_EOF, 0xff, 0xd9),
+	))
+	if len(frames) != 2 {
+		t.Fatalf("%d frames, want 2", len(frames))
+	}
+	for i, want := range [][]byte{
+		{0xff, 0xd8, 1, 2, 3, 4, 5, 0xff, 0xd9},
+		{0xff, 0xd8, 6, 0xff, 0xd9},
+	} {
+		if f := frames[i]; !bytes.Equal(f.Data, want) || f.Error {
+			t.Errorf("frame %d: % x, error %v; want % x", i, f.Data, f.Error, want)
+		}
+	}
+}
+
+// TestTransferLost checks that a failed packet marks its frame in error,
+// whether it fell inside the frame or was the frame's first
+func TestTransferLost(t *testing.T) {
+	d := &uvc.Demux{}
+	frames := d.Transfer(isoTransfer(8,
+		payload(0, 1, 2),
+		nil,
+		payload(uvc.HEADER_EOF, 4),
+		nil,
+		payload(uvc.HEADER_FID, 6),
+		payload(uvc.HEADER_FID|uvc.HEADER_EOF, 7),
+		payload(0, 8),
+		payload(uvc.HEADER_EOF, 9),
+	))
+	want := []struct {
+		data  []byte
+		error bool
+	}{
+		{[]byte{1, 2, 4}, true},
+		{[]byte{6, 7}, true},
+		{[]byte{8, 9}, false},
+	}
+	if len(frames) != len(want) {
+		t.Fatalf("%d frames, want %d", len(frames), len(want))
+	}
+	for i, w := range want {
+		if f := frames[i]; !bytes.Equal(f.Data, w.data) || f.Error != w.error {
+			t.Errorf("frame %d: % x, error %v; want % x, %v", i, f.Data, f.Error, w.data, w.error)
+		}
+	}
+}
+
+// TestTransferBulk takes each bulk transfer as one payload, up to the
+// length received
+func TestTransferBulk(t *testing.T) {
+	d := &uvc.Demux{FrameSize: 6}
+	bulk := func(status int32, p []byte) *usb.Transfer {
+		xfer := &usb.Transfer{Type: usb.URB_TYPE_BULK, Status: status, Data: make([]byte, 64), Length: int32(len(p))}
+		copy(xfer.Data, p)
+		return xfer
+	}
+	if f := d.Transfer(bulk(0, payload(0, 1, 2, 3))); f != nil {
+		t.Fatalf("frame from half a frame: %v", f)
+	}
+	frames := d.Transfer(bulk(0, payload(uvc.HEADER_EOF, 4, 5, 6)))
+	if len(frames) != 1 || !bytes.Equal(frames[0].Data, []byte{1, 2, 3, 4, 5, 6}) || frames[0].Error {
+		t.Fatalf("frames %+v", frames)
+	}
+	d.Transfer(bulk(-int32(syscall.EPROTO), nil))
+	d.Transfer(bulk(0, payload(uvc.HEADER_FID, 1, 2, 3)))
+	frames = d.Transfer(bulk(0, payload(uvc.HEADER_FID, 4, 5, 6)))
+	if len(frames) != 1 || !frames[0].Error {
+		t.Errorf("frame after a failed transfer %+v, want one in error", frames)
+	}
+}
+
+// TestPacketHeader reads the timestamps from a payload header with the
+// PTS and SCR fields, both little endian
+func TestPacketHeader(t *testing.T) {
+	d := &uvc.Demux{ClockFrequency: 1000000}
+	p := []byte{12, uvc.HEADER_EOH | uvc.HEADER_PTS | uvc.HEADER_SCR | uvc.HEADER_EOF | uvc.HEADER_STI,
+		0x40, 0x42, 0x0f, 0x00, // PTS 1000000
+		0x78, 0x56, 0x34, 0x12, 0x05, 0xf8, // STC, then SOF 0xf805 of which 11 bits count
+		0xaa}
+	f := d.Packet(p)
+	if f == nil {
+		t.Fatal("no frame at EOF")
+	}
+	if !f.HasPTS || f.PTS != 1000000 || d.Timestamp(f.PTS).Seconds() != 1 {
+		t.Errorf("PTS %d (%v), want 1000000", f.PTS, f.HasPTS)
+	}
+	if !f.HasSCR || f.STC != 0x12345678 || f.SOF != 0x005 {
+		t.Errorf("SCR %#x %#x (%v), want 0x12345678 0x5", f.STC, f.SOF, f.HasSCR)
+	}
+	if !f.Still || !bytes.Equal(f.Data, []byte{0xaa}) {
+		t.Errorf("frame %+v", f)
+	}
+	for _, bad := range [][]byte{nil, {1}, {1, 0}, {9, 0, 1, 2}} {
+		if f := d.Packet(bad); f != nil {
+			t.Errorf("frame from bad payload % x", bad)
+		}
+	}
+}
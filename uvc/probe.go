@@ -0,0 +1,248 @@
+package uvc
+
+import (
+	"errors"
+	"syscall"
+	"time"
+)
+
+// Probe is the video probe and commit control, through which host and
+// device agree on a format.  Fields past MaxPayloadTransferSize only
+// exist from UVC 1.1; those the 1.5 layout adds are kept as Extra.
+type Probe struct {
+	Hint                   uint16 // which fields the device should keep
+	FormatIndex            uint8
+	FrameIndex             uint8
+	FrameInterval          uint32 // in 100ns units
+	KeyFrameRate           uint16
+	PFrameRate             uint16
+	CompQuality            uint16
+	CompWindowSize         uint16
+	Delay                  uint16 // ms
+	MaxVideoFrameSize      uint32
+	MaxPayloadTransferSize uint32
+	ClockFrequency         uint32
+	FramingInfo            uint8
+	PreferredVersion       uint8
+	MinVersion             uint8
+	MaxVersion             uint8
+	Extra                  [14]byte
+}
+
+// bmHint bits
+const (
+	HINT_FRAME_INTERVAL   = 0x01
+	HINT_KEY_FRAME_RATE   = 0x02
+	HINT_P_FRAME_RATE     = 0x04
+	HINT_COMP_QUALITY     = 0x08
+	HINT_COMP_WINDOW_SIZE = 0x10
+)
+
+// the control is 26 bytes in UVC 1.0, 34 in 1.1 and 48 from 1.5
+func probeSize(version uint16) int {
+	switch {
+	case version >= 0x0150:
+		return 48
+	case version >= 0x0110:
+		return 34
+	}
+	return 26
+}
+
+func (p *Probe) marshal(size int) []byte {
+	b := make([]byte, 48)
+	put16(b[0:], p.Hint)
+	b[2] = p.FormatIndex
+	b[3] = p.FrameIndex
+	put32(b[4:], p.FrameInterval)
+	put16(b[8:], p.KeyFrameRate)
+	put16(b[10:], p.PFrameRate)
+	put16(b[12:], p.CompQuality)
+	put16(b[14:], p.CompWindowSize)
+	put16(b[16:], p.Delay)
+	put32(b[18:], p.MaxVideoFrameSize)
+	put32(b[22:], p.MaxPayloadTransferSize)
+	put32(b[26:], p.ClockFrequency)
+	b[30] = p.FramingInfo
+	b[31] = p.PreferredVersion
+	b[32] = p.MinVersion
+	b[33] = p.MaxVersion
+	copy(b[34:], p.Extra[:])
+	return b[:size]
+}
+
+// unmarshal reads what the device returned, leaving fields it cut short
+// as they were
+func (p *Probe) unmarshal(b []byte) {
+	if len(b) < 26 {
+		return
+	}
+	p.Hint = le16(b[0:])
+	p.FormatIndex = b[2]
+	p.FrameIndex = b[3]
+	p.FrameInterval = le32(b[4:])
+	p.KeyFrameRate = le16(b[8:])
+	p.PFrameRate = le16(b[10:])
+	p.CompQuality = le16(b[12:])
+	p.CompWindowSize = le16(b[14:])
+	p.Delay = le16(b[16:])
+	p.MaxVideoFrameSize = le32(b[18:])
+	p.MaxPayloadTransferSize = le32(b[22:])
+	if len(b) >= 34 {
+		p.ClockFrequency = le32(b[26:])
+		p.FramingInfo = b[30]
+		p.PreferredVersion = b[31]
+		p.MinVersion = b[32]
+		p.MaxVersion = b[33]
+	}
+	if len(b) >= 48 {
+		copy(p.Extra[:], b[34:48])
+	}
+}
+
+func put16(b []byte, v uint16) {
+	b[0], b[1] = byte(v), byte(v>>8)
+}
+
+func put32(b []byte, v uint32) {
+	b[0], b[1], b[2], b[3] = byte(v), byte(v>>8), byte(v>>16), byte(v>>24)
+}
+
+// Get issues a GET request (GET_CUR, GET_MIN, GET_MAX or GET_DEF) on the
+// probe or commit control.
+func (s *Stream) Get(req uint8, selector uint8) (*Probe, error) {
+	b := make([]byte, probeSize(s.Version))
+	n, e := s.dev.ControlTransfer(reqIn, req, uint16(selector)<<8, uint16(s.ifc), uint16(len(b)), 1000, b)
+	if e != nil {
+		return nil, e
+	}
+	if n < 26 {
+		return nil, syscall.EPROTO
+	}
+	p := &Probe{}
+	p.unmarshal(b[:n])
+	return p, nil
+}
+
+// Set issues SET_CUR on the probe or commit control.
+func (s *Stream) Set(selector uint8, p *Probe) error {
+	b := p.marshal(probeSize(s.Version))
+	_, e := s.dev.ControlTransfer(reqOut, SET_CUR, uint16(selector)<<8, uint16(s.ifc), uint16(len(b)), 1000, b)
+	return e
+}
+
+// Want describes the stream to negotiate.  Zero fields match anything.
+type Want struct {
+	FourCC        string
+	Width, Height int           // 0 for each format's default frame
+	Interval      time.Duration // 0 for the frame's default interval
+}
+
+// Negotiated is the outcome of Negotiate.
+type Negotiated struct {
+	Format *Format
+	Frame  *Frame
+	Probe  Probe // as committed
+}
+
+// Interval is the frame interval the device agreed to.
+func (n *Negotiated) Interval() time.Duration {
+	return time.Duration(n.Probe.FrameInterval) * 100
+}
+
+// Negotiate works through the formats and frames matching w in the
+// order the device lists them.  For each it sets the probe control,
+// reads back the device's answer, and brings the values it changed back
+// within the GET_MIN and GET_MAX limits for another round if needed.
+// The first the device accepts is committed.  Candidates the device
+// stalls on or swaps for another format are passed over; ENOENT means
+// none was accepted.  The interface isn't claimed and no streaming
+// alternate setting is selected.  A bulk stream is read from the
+// endpoint of setting 0, with transfers of MaxPayloadTransferSize; an
+// isochronous one needs a setting whose endpoint carries that much per
+// interval, as usb.EndpointBandwidth works out, and is read with
+// transfers of a packet per interval, which Demux.Transfer takes.
+func (s *Stream) Negotiate(w Want) (*Negotiated, error) {
+	for i := range s.Formats {
+		f := &s.Formats[i]
+		if w.FourCC != "" && w.FourCC != f.FourCC {
+			continue
+		}
+		for j := range f.Frames {
+			fr := &f.Frames[j]
+			if w.Width == 0 && w.Height == 0 {
+				if fr.Index != f.DefaultFrame {
+					continue
+				}
+			} else if (w.Width != 0 && fr.Width != w.Width) || (w.Height != 0 && fr.Height != w.Height) {
+				continue
+			}
+			p, e := s.probe(f, fr, fr.Nearest(w.Interval))
+			if errors.Is(e, syscall.EPIPE) || errors.Is(e, syscall.ENOENT) {
+				continue
+			}
+			if e != nil {
+				return nil, e
+			}
+			if e := s.Set(VS_COMMIT_CONTROL, p); e != nil {
+				return nil, e
+			}
+			return &Negotiated{Format: f, Frame: fr, Probe: *p}, nil
+		}
+	}
+	return nil, syscall.ENOENT
+}
+
+// probe runs the probe rounds for one candidate, returning ENOENT if
+// the device settles on a different format or frame
+func (s *Stream) probe(f *Format, fr *Frame, iv time.Duration) (*Probe, error) {
+	p := &Probe{Hint: HINT_FRAME_INTERVAL, FormatIndex: f.Index, FrameIndex: fr.Index, FrameInterval: uint32(iv / 100)}
+	var lo, hi *Probe
+	for round := 0; round < 3; round++ {
+		if e := s.Set(VS_PROBE_CONTROL, p); e != nil {
+			return nil, e
+		}
+		got, e := s.Get(GET_CUR, VS_PROBE_CONTROL)
+		if e != nil {
+			return nil, e
+		}
+		if got.FormatIndex != f.Index || got.FrameIndex != fr.Index {
+			return nil, syscall.ENOENT
+		}
+		if round == 0 {
+			// not every device answers these; without them its own
+			// answer is taken as it stands
+			lo, _ = s.Get(GET_MIN, VS_PROBE_CONTROL)
+			hi, _ = s.Get(GET_MAX, VS_PROBE_CONTROL)
+		}
+		if lo == nil || hi == nil || !clamp(got, lo, hi) {
+			return got, nil
+		}
+		p = got
+	}
+	return p, nil
+}
+
+// clamp brings the negotiable fields of p within lo and hi, saying if
+// it had to change anything
+func clamp(p, lo, hi *Probe) bool {
+	changed := false
+	c32 := func(v *uint32, lo, hi uint32) {
+		if lo <= hi && (*v < lo || *v > hi) {
+			*v = min(max(*v, lo), hi)
+			changed = true
+		}
+	}
+	c16 := func(v *uint16, lo, hi uint16) {
+		if lo <= hi && (*v < lo || *v > hi) {
+			*v = min(max(*v, lo), hi)
+			changed = true
+		}
+	}
+	c32(&p.FrameInterval, lo.FrameInterval, hi.FrameInterval)
+	c16(&p.KeyFrameRate, lo.KeyFrameRate, hi.KeyFrameRate)
+	c16(&p.PFrameRate, lo.PFrameRate, hi.PFrameRate)
+	c16(&p.CompQuality, lo.CompQuality, hi.CompQuality)
+	c16(&p.CompWindowSize, lo.CompWindowSize, hi.CompWindowSize)
+	return changed
+}
@@ -0,0 +1,286 @@
+// Package uvc implements the USB Video Class: the streaming interface
+// descriptors that list formats and frame sizes, the PROBE/COMMIT
+// negotiation that picks one of them, and reassembly of the frames that
+// then arrive as payloads, over a bulk or isochronous streaming endpoint.
+package uvc
+
+import (
+	"syscall"
+	"time"
+
+	"github.com/richardnwinder/usb"
+)
+
+const (
+	CLASS_VIDEO            = 0x0e
+	SUBCLASS_CONTROL       = 0x01
+	SUBCLASS_STREAMING     = 0x02
+	DT_CS_INTERFACE        = 0x24
+	VC_HEADER              = 0x01
+	VS_INPUT_HEADER        = 0x01
+	VS_FORMAT_UNCOMPRESSED = 0x04
+	VS_FRAME_UNCOMPRESSED  = 0x05
+	VS_FORMAT_MJPEG        = 0x06
+	VS_FRAME_MJPEG         = 0x07
+	VS_FORMAT_FRAME_BASED  = 0x10
+	VS_FRAME_FRAME_BASED   = 0x11
+
+	// requests
+	SET_CUR  = 0x01
+	GET_CUR  = 0x81
+	GET_MIN  = 0x82
+	GET_MAX  = 0x83
+	GET_RES  = 0x84
+	GET_LEN  = 0x85
+	GET_INFO = 0x86
+	GET_DEF  = 0x87
+
+	// streaming interface control selectors
+	VS_PROBE_CONTROL  = 0x01
+	VS_COMMIT_CONTROL = 0x02
+
+	reqOut = 0x21 // class, interface, host to device
+	reqIn  = 0xa1
+)
+
+// Frame is one frame size a format offers.  Intervals are the discrete
+// frame intervals it supports; when the device gives a continuous range
+// instead, Intervals is nil and MinInterval, MaxInterval and StepInterval
+// describe it.
+type Frame struct {
+	Index           uint8
+	Width, Height   int
+	MaxFrameSize    uint32 // 0 for frame-based formats, which don't give one
+	DefaultInterval time.Duration
+	Intervals       []time.Duration
+	MinInterval     time.Duration
+	MaxInterval     time.Duration
+	StepInterval    time.Duration
+}
+
+// Format is one of the video formats a streaming interface offers.
+type Format struct {
+	Index        uint8
+	Subtype      uint8    // VS_FORMAT_*
+	GUID         [16]byte // zero for MJPEG
+	FourCC       string   // "MJPG" for MJPEG, otherwise from the GUID
+	BitsPerPixel int
+	DefaultFrame uint8
+	Frames       []Frame
+}
+
+// Frame finds one of the format's frames by index.
+func (f *Format) Frame(index uint8) *Frame {
+	for i := range f.Frames {
+		if f.Frames[i].Index == index {
+			return &f.Frames[i]
+		}
+	}
+	return nil
+}
+
+// frame intervals are in units of 100ns
+func interval(b []byte) time.Duration {
+	return time.Duration(le32(b)) * 100
+}
+
+func le16(b []byte) uint16 {
+	return uint16(b[0]) | uint16(b[1])<<8
+}
+
+func le32(b []byte) uint32 {
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24
+}
+
+// ParseFormats reads the formats and frames from the class-specific
+// descriptors of a streaming interface, as found in the Extra of its
+// alternate setting 0.  Still image and color matching descriptors are
+// skipped, as are formats this package doesn't know.
+func ParseFormats(extra []byte) ([]Format, error) {
+	var list []Format
+	var f *Format
+	for d := extra; len(d) > 0; d = d[d[0]:] {
+		if len(d) < 3 || d[0] < 3 || int(d[0]) > len(d) {
+			return nil, syscall.EINVAL
+		}
+		if d[1] != DT_CS_INTERFACE {
+			continue
+		}
+		b := d[:d[0]]
+		switch b[2] {
+		case VS_FORMAT_UNCOMPRESSED, VS_FORMAT_FRAME_BASED:
+			if len(b) < 23 {
+				return nil, syscall.EINVAL
+			}
+			list = append(list, Format{Index: b[3], Subtype: b[2], BitsPerPixel: int(b[21]), DefaultFrame: b[22]})
+			f = &list[len(list)-1]
+			copy(f.GUID[:], b[5:21])
+			f.FourCC = fourcc(f.GUID[:4])
+		case VS_FORMAT_MJPEG:
+			if len(b) < 7 {
+				return nil, syscall.EINVAL
+			}
+			list = append(list, Format{Index: b[3], Subtype: b[2], FourCC: "MJPG", DefaultFrame: b[6]})
+			f = &list[len(list)-1]
+		case VS_FRAME_UNCOMPRESSED, VS_FRAME_MJPEG, VS_FRAME_FRAME_BASED:
+			if f == nil || b[2] != f.Subtype+1 {
+				// a frame for a format we skipped
+				continue
+			}
+			fr, ok := parseFrame(b)
+			if !ok {
+				return nil, syscall.EINVAL
+			}
+			f.Frames = append(f.Frames, fr)
+		}
+	}
+	return list, nil
+}
+
+func fourcc(b []byte) string {
+	for _, c := range b {
+		if c < ' ' || c > '~' {
+			return ""
+		}
+	}
+	return string(b)
+}
+
+func parseFrame(b []byte) (Frame, bool) {
+	// frame-based frames leave out the buffer size and add bytes per line
+	off := 21
+	if b[2] == VS_FRAME_FRAME_BASED {
+		off = 17
+	}
+	if len(b) < off+5 {
+		return Frame{}, false
+	}
+	fr := Frame{
+		Index:           b[3],
+		Width:           int(le16(b[5:])),
+		Height:          int(le16(b[7:])),
+		DefaultInterval: interval(b[off:]),
+	}
+	if b[2] != VS_FRAME_FRAME_BASED {
+		fr.MaxFrameSize = le32(b[17:])
+	}
+	n := int(b[off+4])
+	list := b[off+5:]
+	if b[2] == VS_FRAME_FRAME_BASED {
+		if len(list) < 4 {
+			return Frame{}, false
+		}
+		list = list[4:] // dwBytesPerLine
+	}
+	if n == 0 {
+		if len(list) < 12 {
+			return Frame{}, false
+		}
+		fr.MinInterval = interval(list)
+		fr.MaxInterval = interval(list[4:])
+		fr.StepInterval = interval(list[8:])
+		return fr, true
+	}
+	if len(list) < 4*n {
+		return Frame{}, false
+	}
+	for i := 0; i < n; i++ {
+		fr.Intervals = append(fr.Intervals, interval(list[4*i:]))
+	}
+	fr.MinInterval = fr.Intervals[0]
+	fr.MaxInterval = fr.Intervals[0]
+	for _, iv := range fr.Intervals {
+		fr.MinInterval = min(fr.MinInterval, iv)
+		fr.MaxInterval = max(fr.MaxInterval, iv)
+	}
+	return fr, true
+}
+
+// Nearest returns the supported frame interval closest to want, or the
+// default for 0.
+func (fr *Frame) Nearest(want time.Duration) time.Duration {
+	if want == 0 {
+		return fr.DefaultInterval
+	}
+	if fr.Intervals == nil {
+		iv := min(max(want, fr.MinInterval), fr.MaxInterval)
+		if fr.StepInterval > 0 {
+			iv = fr.MinInterval + (iv-fr.MinInterval+fr.StepInterval/2)/fr.StepInterval*fr.StepInterval
+		}
+		return min(iv, fr.MaxInterval)
+	}
+	best := fr.Intervals[0]
+	for _, iv := range fr.Intervals {
+		if absDiff(iv, want) < absDiff(best, want) {
+			best = iv
+		}
+	}
+	return best
+}
+
+func absDiff(a, b time.Duration) time.Duration {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+// Stream is a video streaming interface and what it offers.
+type Stream struct {
+	dev       *usb.Device
+	ifc       uint8
+	Version   uint16 // bcdUVC from the control interface
+	Formats   []Format
+	Alternate []*usb.InterfaceInfo // the streaming interface's settings, 0 first
+}
+
+// NewStream finds streaming interface ifnum in configuration ci and reads
+// its formats.  It doesn't claim the interface.
+func NewStream(dev *usb.Device, ci *usb.ConfigInfo, ifnum uint8) (*Stream, error) {
+	s := &Stream{dev: dev, ifc: ifnum, Version: 0x0100}
+	for i := range ci.Interface {
+		ii := &ci.Interface[i]
+		if ii.InterfaceClass != CLASS_VIDEO {
+			continue
+		}
+		if ii.InterfaceSubClass == SUBCLASS_CONTROL && ii.AlternateSetting == 0 {
+			if v, ok := controlVersion(ii.Extra, ifnum); ok {
+				s.Version = v
+			}
+		}
+		if ii.InterfaceSubClass == SUBCLASS_STREAMING && ii.InterfaceNumber == ifnum {
+			s.Alternate = append(s.Alternate, ii)
+		}
+	}
+	if len(s.Alternate) == 0 || s.Alternate[0].AlternateSetting != 0 {
+		return nil, syscall.ENODEV
+	}
+	f, e := ParseFormats(s.Alternate[0].Extra)
+	if e != nil {
+		return nil, e
+	}
+	s.Formats = f
+	return s, nil
+}
+
+// controlVersion reads bcdUVC from a control interface's header, if the
+// header lists streaming interface ifnum
+func controlVersion(extra []byte, ifnum uint8) (uint16, bool) {
+	for d := extra; len(d) >= 3 && d[0] >= 3 && int(d[0]) <= len(d); d = d[d[0]:] {
+		if d[1] != DT_CS_INTERFACE || d[2] != VC_HEADER || d[0] < 12 {
+			continue
+		}
+		n := int(d[11])
+		for i := 0; i < n && 12+i < int(d[0]); i++ {
+			if d[12+i] == ifnum {
+				return le16(d[3:]), true
+			}
+		}
+	}
+	return 0, false
+}
+
+// Interface returns the interface number, used as wIndex in requests.
+func (s *Stream) Interface() uint8 {
+	return s.ifc
+}
@@ -0,0 +1,248 @@
+// Package watchdog watches a device's transfers and recovers it when they
+// keep failing or stop completing, escalating from clearing halts to
+// resetting or power cycling the device.
+package watchdog
+
+import (
+	"errors"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/richardnwinder/usb"
+)
+
+// Step is one step of the watchdog's escalation.
+type Step int
+
+const (
+	RECOVER_CLEAR_HALT      Step = iota // ClearHalt the halted and failing endpoints
+	RECOVER_INTERFACE_RESET             // SetInterface each claimed interface to its setting again
+	RECOVER_DEVICE_RESET                // Reset the device
+	RECOVER_POWER_CYCLE                 // Config.PowerCycle, if set
+	RECOVER_EXHAUSTED                   // every step was tried and the device still fails
+)
+
+var recoveryNames = []string{"clear halt", "interface reset", "device reset", "power cycle", "exhausted"}
+
+func (s Step) String() string {
+	if s < 0 || int(s) >= len(recoveryNames) {
+		return "unknown"
+	}
+	return recoveryNames[s]
+}
+
+// Config says when the watchdog steps in and whom it tells.
+type Config struct {
+	// Failures is how many transfers in a row must fail to start a
+	// recovery step; 0 doesn't count failures.  Cancelled transfers
+	// don't count, nor do timeouts unless CountTimeouts is set, since
+	// polling an idle endpoint times out as a matter of course.
+	Failures      int
+	CountTimeouts bool
+	// Stall starts a recovery step when submitted transfers have been
+	// queued this long without any transfer completing successfully; 0
+	// doesn't watch for stalls.
+	Stall time.Duration
+	// PowerCycle switches the device's hub port off and on, the last
+	// resort, such as with hub.PowerCycleDevice; without it the
+	// escalation ends with the device reset.  A device that has been
+	// reset or power cycled has usually gone from the bus and come back,
+	// and must be opened again.
+	PowerCycle func() error
+	// Event is told of each recovery step once it has been taken.  It
+	// runs on the watchdog's goroutine; it may Close the device, but
+	// not Stop the watchdog.
+	Event func(Event)
+}
+
+// Event reports a recovery step, and its error if it failed.
+type Event struct {
+	Step     Step
+	Failures int // failed transfers in a row that led to it
+	Err      error
+	Time     time.Time
+}
+
+// Watchdog watches a device's transfers and recovers it when they keep
+// failing or stop completing.  Each time it is triggered it takes the next
+// step of the escalation, so a device that a ClearHalt doesn't bring back
+// gets its interfaces reset the next time, and so on.  A successful
+// transfer starts the escalation over.
+type Watchdog struct {
+	u        *usb.Device
+	cfg      Config
+	lock     sync.Mutex
+	failures int
+	lastEP   uint8 // of the last failure
+	lastOK   time.Time
+	next     Step
+	busy     bool // recovering, so transfers made to recover aren't counted
+	kick     chan struct{}
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// Start watches the device's transfers until Stop or Close, as the
+// device's Monitor; it gives EBUSY if the device already has one.
+func Start(u *usb.Device, cfg Config) (*Watchdog, error) {
+	w := &Watchdog{u: u, cfg: cfg, lastOK: time.Now(),
+		kick: make(chan struct{}, 1), stop: make(chan struct{}), done: make(chan struct{})}
+	if e := u.SetMonitor(w); e != nil {
+		return nil, e
+	}
+	go w.run()
+	return w, nil
+}
+
+// Stop stops watching, and waits for a recovery step under way, so it
+// must not be called from Event.
+func (w *Watchdog) Stop() {
+	if w.u.RemoveMonitor(w) {
+		close(w.stop)
+	}
+	<-w.done
+}
+
+// Closing stops the watchdog without waiting for it, for the device's
+// Close.
+func (w *Watchdog) Closing() {
+	close(w.stop)
+}
+
+// Transfer is told of each transfer on the device.
+func (w *Watchdog) Transfer(ep uint8, e error) {
+	if errors.Is(e, syscall.ENOENT) || errors.Is(e, syscall.ECONNRESET) || errors.Is(e, syscall.ESHUTDOWN) ||
+		(!w.cfg.CountTimeouts && errors.Is(e, syscall.ETIMEDOUT)) {
+		return
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.busy {
+		return
+	}
+	if e == nil {
+		w.failures = 0
+		w.lastOK = time.Now()
+		w.next = RECOVER_CLEAR_HALT
+		return
+	}
+	w.failures++
+	w.lastEP = ep
+	if w.cfg.Failures > 0 && w.failures >= w.cfg.Failures {
+		select {
+		case w.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (w *Watchdog) run() {
+	defer close(w.done)
+	var tick <-chan time.Time
+	if w.cfg.Stall > 0 {
+		t := time.NewTicker(max(w.cfg.Stall/4, 10*time.Millisecond))
+		defer t.Stop()
+		tick = t.C
+	}
+	// when transfers were first seen queued, to the tick
+	var queued time.Time
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-w.kick:
+		case now := <-tick:
+			if w.u.Queued() == 0 {
+				queued = time.Time{}
+				continue
+			}
+			if queued.IsZero() {
+				queued = now
+			}
+			w.lock.Lock()
+			since := queued
+			if w.lastOK.After(since) {
+				since = w.lastOK
+			}
+			w.lock.Unlock()
+			if now.Sub(since) < w.cfg.Stall {
+				continue
+			}
+		}
+		w.recover()
+	}
+}
+
+// recover takes the next step of the escalation
+func (w *Watchdog) recover() {
+	w.lock.Lock()
+	if w.next == RECOVER_EXHAUSTED {
+		w.lock.Unlock()
+		return
+	}
+	step, failures, ep := w.next, w.failures, w.lastEP
+	w.busy = true
+	w.lock.Unlock()
+
+	var e error
+	switch step {
+	case RECOVER_CLEAR_HALT:
+		e = w.clearHalts(ep)
+	case RECOVER_INTERFACE_RESET:
+		e = w.resetInterfaces()
+	case RECOVER_DEVICE_RESET:
+		e = w.u.Reset()
+	case RECOVER_POWER_CYCLE:
+		e = w.cfg.PowerCycle()
+	}
+	next := step + 1
+	if next == RECOVER_POWER_CYCLE && w.cfg.PowerCycle == nil {
+		next++
+	}
+
+	w.lock.Lock()
+	w.busy = false
+	w.next = next
+	// give the step time to work before judging it
+	w.failures = 0
+	w.lastOK = time.Now()
+	w.lock.Unlock()
+	if w.cfg.Event != nil {
+		w.cfg.Event(Event{Step: step, Failures: failures, Err: e, Time: time.Now()})
+		if next == RECOVER_EXHAUSTED {
+			w.cfg.Event(Event{Step: next, Failures: failures, Time: time.Now()})
+		}
+	}
+}
+
+func (w *Watchdog) clearHalts(ep uint8) error {
+	eps := w.u.Halted()
+	if ep&0x0f != 0 && !w.u.Endpoint(ep).Halted() {
+		eps = append(eps, ep)
+	}
+	var err error
+	for _, ep := range eps {
+		if e := w.u.ClearHalt(ep); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// resetInterfaces selects each claimed interface's current alternate
+// setting again, which resets its endpoints on both sides
+func (w *Watchdog) resetInterfaces() error {
+	var err error
+	for _, n := range w.u.Claimed() {
+		alt := make([]byte, 1)
+		if _, e := w.u.ControlTransfer(usb.REQTYPE_IN|usb.REQTYPE_STANDARD|usb.REQTYPE_INTERFACE, usb.REQ_GET_INTERFACE,
+			0, uint16(n), 1, 1000, alt); e != nil {
+			alt[0] = 0
+		}
+		if e := w.u.SetInterface(uint8(n), alt[0]); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
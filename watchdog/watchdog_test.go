@@ -0,0 +1,80 @@
+package watchdog
+
+import (
+	"syscall"
+	"testing"
+
+	"github.com/richardnwinder/usb"
+)
+
+// kicked says whether the watchdog has been told to take a step, and
+// takes the signal
+func kicked(w *Watchdog) bool {
+	select {
+	case <-w.kick:
+		return true
+	default:
+		return false
+	}
+}
+
+// TestTransferCounting feeds transfer outcomes to a watchdog that isn't
+// running and checks when it would step in
+func TestTransferCounting(t *testing.T) {
+	w := &Watchdog{cfg: Config{Failures: 3}, kick: make(chan struct{}, 1)}
+	for _, e := range []error{syscall.EIO, syscall.EIO, nil, syscall.EPROTO, syscall.EPROTO} {
+		w.Transfer(0x81, e)
+	}
+	if kicked(w) {
+		t.Fatal("kicked before 3 failures in a row")
+	}
+	// cancellations and timeouts don't count
+	for _, e := range []error{usb.ErrCancelled, syscall.ECONNRESET, syscall.ESHUTDOWN, syscall.ETIMEDOUT} {
+		w.Transfer(0x81, e)
+	}
+	if kicked(w) {
+		t.Fatal("kicked by cancellations or a timeout")
+	}
+	w.Transfer(0x02, syscall.EPIPE)
+	if !kicked(w) {
+		t.Fatal("not kicked after 3 failures in a row")
+	}
+	if w.failures != 3 || w.lastEP != 0x02 {
+		t.Errorf("%d failures, last on 0x%02x; want 3 on 0x02", w.failures, w.lastEP)
+	}
+
+	w.next = RECOVER_DEVICE_RESET
+	w.Transfer(0x81, nil)
+	if w.failures != 0 || w.next != RECOVER_CLEAR_HALT {
+		t.Errorf("a success left %d failures and %v next", w.failures, w.next)
+	}
+
+	// nor are transfers made while recovering
+	w.busy = true
+	for range 3 {
+		w.Transfer(0x81, syscall.EIO)
+	}
+	if kicked(w) || w.failures != 0 {
+		t.Error("transfers counted while recovering")
+	}
+}
+
+func TestCountTimeouts(t *testing.T) {
+	w := &Watchdog{cfg: Config{Failures: 2, CountTimeouts: true}, kick: make(chan struct{}, 1)}
+	w.Transfer(0x81, usb.ErrTimeout)
+	w.Transfer(0x81, syscall.ETIMEDOUT)
+	if !kicked(w) {
+		t.Error("timeouts not counted with CountTimeouts")
+	}
+}
+
+func TestStepString(t *testing.T) {
+	for s, want := range map[Step]string{
+		RECOVER_CLEAR_HALT: "clear halt", RECOVER_POWER_CYCLE: "power cycle",
+		RECOVER_EXHAUSTED: "exhausted", -1: "unknown", RECOVER_EXHAUSTED + 1: "unknown",
+	} {
+		if got := s.String(); got != want {
+			t.Errorf("Step(%d) is %q, want %q", int(s), got, want)
+		}
+	}
+}